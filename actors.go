@@ -0,0 +1,81 @@
+package main
+
+import (
+	"fmt"
+)
+
+// User kinds. Only human users hold tokens; system and service rows exist so
+// compacts, importers and bots have explicit identities in entries and logs.
+const (
+	kindHuman   = "human"
+	kindSystem  = "system"
+	kindService = "service"
+)
+
+// actorIdentity is a reserved, non-human user row.
+type actorIdentity struct {
+	ID       int64
+	Username string
+	Kind     string
+}
+
+// Reserved identities use negative ids, which never collide with
+// AUTOINCREMENT ids handed out to human users.
+var (
+	actorSystem       = actorIdentity{ID: -1, Username: "system", Kind: kindSystem}
+	actorScheduler    = actorIdentity{ID: -2, Username: "scheduler", Kind: kindSystem}
+	actorIntake       = actorIdentity{ID: -3, Username: "intake", Kind: kindSystem}
+	actorGitImporter  = actorIdentity{ID: -4, Username: "git_importer", Kind: kindService}
+	actorEmailGateway = actorIdentity{ID: -5, Username: "email_gateway", Kind: kindService}
+	actorAlerts       = actorIdentity{ID: -6, Username: "alerts", Kind: kindService}
+	actorCalendar     = actorIdentity{ID: -7, Username: "calendar", Kind: kindService}
+	actorWikiImporter = actorIdentity{ID: -8, Username: "wiki_importer", Kind: kindService}
+	actorCI           = actorIdentity{ID: -9, Username: "ci_builds", Kind: kindService}
+)
+
+var reservedActors = []actorIdentity{
+	actorSystem,
+	actorScheduler,
+	actorIntake,
+	actorGitImporter,
+	actorEmailGateway,
+	actorAlerts,
+	actorCalendar,
+	actorWikiImporter,
+	actorCI,
+}
+
+func isReservedUsername(name string) bool {
+	for _, act := range reservedActors {
+		if act.Username == name {
+			return true
+		}
+	}
+	return false
+}
+
+// seedActors makes sure every reserved identity has its user row and moves
+// legacy owner-less compacts onto the system user. It fails if a human
+// account already holds a reserved username.
+func (a *App) seedActors() error {
+	for _, act := range reservedActors {
+		if _, err := a.db.Exec(`INSERT OR IGNORE INTO users(id, username, role, kind, created_at) VALUES(?, ?, ?, ?, ?)`,
+			act.ID, act.Username, roleMember, act.Kind, nowUTC()); err != nil {
+			return err
+		}
+		var name, kind string
+		if err := a.db.QueryRow(`SELECT username, kind FROM users WHERE id = ?`, act.ID).Scan(&name, &kind); err != nil {
+			return fmt.Errorf("reserved user %s (id %d) missing; is the username taken by a human account? %w", act.Username, act.ID, err)
+		}
+		if name != act.Username || kind != act.Kind {
+			return fmt.Errorf("reserved user id %d holds %s/%s, want %s/%s", act.ID, name, kind, act.Username, act.Kind)
+		}
+	}
+	_, err := a.db.Exec(`UPDATE entries SET user_id = ? WHERE user_id IS NULL`, actorSystem.ID)
+	return err
+}
+
+// logActorAction records an audit row for a reserved identity.
+func (a *App) logActorAction(act actorIdentity, action, metadata string) error {
+	return a.logAction(act.Kind, act.Username, action, metadata)
+}
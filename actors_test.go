@@ -0,0 +1,57 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestAPICompactOwnedBySystemActor(t *testing.T) {
+	app := newTestApp(t)
+	h := newTestMux(app)
+	createUser(t, app, "alice", "PUDABCDEF12")
+
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, authedReq(t, http.MethodPost, "/api/entries", map[string]string{"content": "shipped it"}, "PUDABCDEF12"))
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("expected 201, got %d", rr.Code)
+	}
+	day := time.Now().UTC().Format("2006-01-02")
+	if err := app.compactDay(day); err != nil {
+		t.Fatalf("compactDay: %v", err)
+	}
+	// A compact written before reserved identities existed has no owner.
+	if _, err := app.db.Exec(`INSERT INTO entries(user_id, entry_type, content, created_at) VALUES(NULL, 'daily_compact', 'legacy', ?)`, nowUTC()); err != nil {
+		t.Fatalf("insert legacy compact: %v", err)
+	}
+	if err := app.seedActors(); err != nil {
+		t.Fatalf("seedActors: %v", err)
+	}
+
+	rr = httptest.NewRecorder()
+	h.ServeHTTP(rr, authedReq(t, http.MethodGet, "/api/entries?day="+day, nil, "PUDABCDEF12"))
+	var got struct {
+		Entries []entryRow `json:"entries"`
+	}
+	if err := json.Unmarshal(rr.Body.Bytes(), &got); err != nil {
+		t.Fatalf("unmarshal: %v", err)
+	}
+	if len(got.Entries) != 2 {
+		t.Fatalf("expected 2 compacts, got %+v", got.Entries)
+	}
+	for _, e := range got.Entries {
+		if e.User != "system" || e.UserKind != kindSystem {
+			t.Fatalf("expected compact owned by system actor, got %+v", e)
+		}
+	}
+
+	var actor string
+	if err := app.db.QueryRow(`SELECT actor_type || '/' || actor_username FROM action_logs WHERE action = 'daily_compact'`).Scan(&actor); err != nil {
+		t.Fatalf("query audit: %v", err)
+	}
+	if actor != "system/scheduler" {
+		t.Fatalf("unexpected compaction actor %q", actor)
+	}
+}
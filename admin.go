@@ -19,28 +19,103 @@ func runAdmin(args []string) error {
 		printAdminUsage()
 		return nil
 	}
-	if args[0] == "help" || args[0] == "-h" || args[0] == "--help" {
+	switch args[0] {
+	case "help", "-h", "--help":
 		printAdminUsage()
 		return nil
-	}
-	if args[0] != "create-user" {
+	case "create-user":
+		return runAdminCreateUser(args[1:])
+	case "rotate-token":
+		return runAdminRotateToken(args[1:])
+	case "disable-user":
+		return runAdminDisableUser(args[1:], true)
+	case "enable-user":
+		return runAdminDisableUser(args[1:], false)
+	case "erase-user":
+		return runAdminEraseUser(args[1:])
+	case "add-alert-rule":
+		return runAdminAddAlertRule(args[1:])
+	case "list-alert-rules":
+		return runAdminListAlertRules(args[1:])
+	case "remove-alert-rule":
+		return runAdminRemoveAlertRule(args[1:])
+	case "export-notes":
+		return runAdminExportNotes(args[1:])
+	case "export":
+		return runAdminExport(args[1:])
+	case "map-git-author":
+		return runAdminMapGitAuthor(args[1:])
+	case "link-identity":
+		return runAdminLinkIdentity(args[1:])
+	case "map-email-sender":
+		return runAdminMapEmailSender(args[1:])
+	case "maintenance":
+		return runAdminMaintenance(args[1:])
+	case "rerender-compacts":
+		return runAdminRerenderCompacts(args[1:])
+	case "hold":
+		return runAdminHold(args[1:])
+	case "release-hold":
+		return runAdminReleaseHold(args[1:])
+	case "list-holds":
+		return runAdminListHolds(args[1:])
+	case "export-audit":
+		return runAdminExportAudit(args[1:])
+	case "verify-audit-export":
+		return runAdminVerifyAuditExport(args[1:])
+	case "verify-audit":
+		return runAdminVerifyAudit(args[1:])
+	case "blob-gc":
+		return runAdminBlobGC(args[1:])
+	case "promote-standby":
+		return runAdminPromoteStandby(args[1:])
+	case "integrity":
+		return runAdminIntegrity(args[1:])
+	case "compact":
+		return runAdminCompact(args[1:])
+	case "uncompact":
+		return runAdminUncompact(args[1:])
+	case "compaction-journal":
+		return runAdminCompactionJournal(args[1:])
+	case "usage":
+		return runAdminUsage(args[1:])
+	case "es-backfill":
+		return runAdminESBackfill(args[1:])
+	case "retention":
+		return runAdminRetention(args[1:])
+	case "backup":
+		return runAdminBackup(args[1:])
+	case "restore":
+		return runAdminRestore(args[1:])
+	default:
 		printAdminUsage()
 		return fmt.Errorf("unknown admin command: %s", args[0])
 	}
+}
 
+func runAdminCreateUser(args []string) error {
 	fs := flag.NewFlagSet("admin create-user", flag.ContinueOnError)
 	fs.Usage = func() {
 		fmt.Fprintf(fs.Output(), "Usage: %s admin create-user --username <name> [options]\n\n", binName())
 		fmt.Fprintln(fs.Output(), "Creates an API user and generates a token.")
 		fmt.Fprintln(fs.Output(), "Token format: PUD + 9 uppercase slug chars (12 chars total).")
+		fmt.Fprintln(fs.Output(), "With --claim-link it prints a one-time URL that reveals the token instead.")
 		fmt.Fprintln(fs.Output())
 		fmt.Fprintln(fs.Output(), "Options:")
 		fs.PrintDefaults()
 	}
 	username := fs.String("username", "", "username to create")
+	role := fs.String("role", roleMember, "user role (member|admin, or a role from --policy-file)")
+	policyFile := fs.String("policy-file", "", "policy overrides the server runs with, for custom roles")
+	tokenPepperFile := fs.String("token-pepper-file", "", "token pepper the server runs with (default <db>.pepper if it exists)")
+	ttlFlag := fs.String("ttl", "", "token lifetime, e.g. 90d, 12w or 720h (default: never expires)")
+	claimLink := fs.Bool("claim-link", false, "print a one-time claim URL instead of the token")
+	claimTTLFlag := fs.String("claim-ttl", "72h", "how long the claim URL works (at most 30d)")
+	externalURL := fs.String("external-url", "", "public URL of the web UI for the claim URL (default: the external_url setting)")
+	basePath := fs.String("base-path", "", "path prefix the web UI is served under (serve --base-path)")
 	dbPath := fs.String("db", defaultDBPath, "sqlite db path")
 	logPath := fs.String("log", defaultLogPath, "log target path ('-' for stdout only)")
-	if err := fs.Parse(args[1:]); err != nil {
+	if err := fs.Parse(args); err != nil {
 		if errors.Is(err, flag.ErrHelp) {
 			return nil
 		}
@@ -49,46 +124,132 @@ func runAdmin(args []string) error {
 	if strings.TrimSpace(*username) == "" {
 		return errors.New("--username is required")
 	}
-
-	logger, closeLog, err := buildLogger(*logPath)
+	ttl, err := parseTokenTTL(*ttlFlag)
+	if err != nil {
+		return fmt.Errorf("--ttl: %w", err)
+	}
+	claimTTL, err := parseClaimTTL(*claimTTLFlag)
+	if err != nil {
+		return fmt.Errorf("--claim-ttl: %w", err)
+	}
+	extURL, err := parseExternalURL(*externalURL)
 	if err != nil {
 		return err
 	}
-	defer closeLog()
-
-	db, err := openDB(*dbPath)
+	base, err := normalizeBasePath(*basePath)
 	if err != nil {
 		return err
 	}
-	defer db.Close()
-
-	app := &App{db: db, logger: logger}
-	if err := app.initSchema(); err != nil {
+	policy, err := loadPolicy(*policyFile)
+	if err != nil {
 		return err
 	}
-
-	token, err := generateToken()
+	tokenPepper, err := loadTokenPepper(*tokenPepperFile, *dbPath, false, nil)
 	if err != nil {
 		return err
 	}
-	hash := hashToken(token)
+	app, closeApp, err := openAdminApp(*dbPath, *logPath)
+	if err != nil {
+		return err
+	}
+	defer closeApp()
+	app.policy = policy
+	app.tokenPepper = tokenPepper
+	app.externalURL = extURL
+	app.basePath = base
+	if err := app.checkTokenPepper(); err != nil {
+		return err
+	}
+	if *claimLink && app.externalBase() == "" {
+		return errors.New("--claim-link needs --external-url or the external_url setting")
+	}
 
-	res, err := app.db.Exec(`INSERT INTO users(username, token_hash, created_at) VALUES(?, ?, ?)`, strings.TrimSpace(*username), hash, nowUTC())
+	nu, err := app.provisionUser(*username, *role, ttl)
 	if err != nil {
 		return err
 	}
-	uid, _ := res.LastInsertId()
-	_ = app.logAction("admin_cli", "admin", "create_user", fmt.Sprintf("target_username=%s user_id=%d", *username, uid))
+	if *claimLink {
+		if nu, err = app.withClaimLink(nu, "admin", claimTTL); err != nil {
+			return err
+		}
+	}
+	_ = app.logAction("admin_cli", "admin", "create_user", nu.createUserMeta())
 
-	fmt.Printf("created user: %s\n", *username)
-	fmt.Printf("token (save now, cannot be retrieved later): %s\n", token)
+	fmt.Printf("created user: %s (%s)\n", nu.Username, nu.Role)
+	if nu.ClaimURL != "" {
+		fmt.Printf("claim URL (works once, until %s): %s\n", nu.ClaimExpiresAt, nu.ClaimURL)
+		fmt.Println("whoever opens it first gets the token; if the user finds it used, rotate their token")
+		if ttl > 0 {
+			fmt.Printf("token expires %s after it is claimed\n", strings.TrimSpace(*ttlFlag))
+		}
+		return nil
+	}
+	fmt.Printf("token (save now, cannot be retrieved later): %s\n", nu.Token)
+	if nu.ExpiresAt != "" {
+		fmt.Printf("token expires at: %s\n", nu.ExpiresAt)
+	}
 	return nil
 }
 
+// openAdminApp builds the logger, database and schema shared by admin subcommands.
+func openAdminApp(dbPath, logPath string) (*App, func(), error) {
+	logger, closeLog, err := buildLogger(logPath)
+	if err != nil {
+		return nil, nil, err
+	}
+	db, err := openDB(dbPath)
+	if err != nil {
+		closeLog()
+		return nil, nil, err
+	}
+	// The importers store entries through this App; redact like serve's default.
+	app := &App{db: db, logger: logger, redactSecrets: true}
+	if err := app.initSchema(); err != nil {
+		_ = db.Close()
+		closeLog()
+		return nil, nil, err
+	}
+	return app, func() {
+		_ = db.Close()
+		closeLog()
+	}, nil
+}
+
 func printAdminUsage() {
 	fmt.Printf("Usage: %s admin <subcommand> [options]\n\n", binName())
 	fmt.Println("Subcommands:")
-	fmt.Println("  create-user    Create a user and print a generated token once")
+	fmt.Println("  create-user         Create a user and print a generated token once")
+	fmt.Println("  rotate-token        Replace a user's token and print the new one once")
+	fmt.Println("  disable-user        Disable a user's account (token rejected, data kept)")
+	fmt.Println("  enable-user         Re-enable a disabled user")
+	fmt.Println("  erase-user          Anonymize a departed user's personal data (not reversible)")
+	fmt.Println("  add-alert-rule      Add a keyword that triggers an alert when posted")
+	fmt.Println("  list-alert-rules    List configured keyword alert rules")
+	fmt.Println("  remove-alert-rule   Remove a keyword alert rule")
+	fmt.Println("  export-notes        Write one Obsidian-style Markdown file per day")
+	fmt.Println("  export              Write all entries in a day range as JSON, CSV or Markdown")
+	fmt.Println("  link-identity       Link an email/Slack/GitHub identity to a user (or --list)")
+	fmt.Println("  map-git-author      Map a git commit email to a user for 'import git'")
+	fmt.Println("  map-email-sender    Allow a sender address to post entries by email")
+	fmt.Println("  rerender-compacts   Re-render daily compacts with the current format")
+	fmt.Println("  hold                Place a legal hold on a day range (blocks compaction/pruning)")
+	fmt.Println("  release-hold        Release a legal hold")
+	fmt.Println("  list-holds          List legal holds")
+	fmt.Println("  maintenance         Show or toggle maintenance mode (reads only, writes get 503)")
+	fmt.Println("  export-audit        Export action logs as a signed, hash-chained file")
+	fmt.Println("  verify-audit-export Verify an audit export's chain and signature")
+	fmt.Println("  verify-audit        Verify the action_logs hash chain in the database")
+	fmt.Println("  blob-gc             Delete attachment blobs no entry references anymore")
+	fmt.Println("  promote-standby     Promote a warm standby copy so serve can open it")
+	fmt.Println("  integrity           Run the integrity self-check, list or resolve quarantined issues")
+	fmt.Println("  compact             Compact a day now (retry a failed or missed scheduled run)")
+	fmt.Println("  uncompact           Restore a compacted day's original entries and remove its compact")
+	fmt.Println("  compaction-journal  Show compaction progress markers and startup recovery outcomes")
+	fmt.Println("  usage               Print usage statistics as JSON or OpenMetrics for capacity planning")
+	fmt.Println("  es-backfill         Index every existing entry into Elasticsearch/OpenSearch")
+	fmt.Println("  retention           Apply the retention_rules setting now and print what was deleted")
+	fmt.Println("  backup              Write a consistent online backup of the database to a file")
+	fmt.Println("  restore             Replace the database with a backup (serve must be stopped)")
 	fmt.Println()
 	fmt.Printf("Try: %s admin create-user --help\n", binName())
 }
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// matchAlertRules returns the configured keywords that appear in content as
+// whole words, compared case-insensitively.
+func (a *App) matchAlertRules(content string) ([]string, error) {
+	rows, err := a.db.Query(`SELECT keyword FROM alert_rules ORDER BY keyword ASC`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var matched []string
+	for rows.Next() {
+		var kw string
+		if err := rows.Scan(&kw); err != nil {
+			return nil, err
+		}
+		re, err := regexp.Compile(`(?i)\b` + regexp.QuoteMeta(kw) + `\b`)
+		if err != nil {
+			continue
+		}
+		if re.MatchString(content) {
+			matched = append(matched, kw)
+		}
+	}
+	return matched, rows.Err()
+}
+
+// fireKeywordAlerts records and dispatches a keyword_alert event when a new
+// entry matches any alert rule. Failures are logged and never block the write.
+func (a *App) fireKeywordAlerts(u AuthedUser, entryID int64, content string) {
+	matched, err := a.matchAlertRules(content)
+	if err != nil {
+		a.logger.Printf("event=alert_rules_error entry_id=%d err=%v", entryID, err)
+		return
+	}
+	if len(matched) == 0 {
+		return
+	}
+	_ = a.logActorAction(actorAlerts, "keyword_alert", fmt.Sprintf("entry_id=%d keywords=%s author=%s", entryID, strings.Join(matched, ","), u.Username))
+	a.dispatcher.Dispatch(IntegrationEvent{
+		Type:    "keyword_alert",
+		User:    u.Username,
+		EntryID: entryID,
+		Message: fmt.Sprintf("%s posted an entry matching %s", u.Username, strings.Join(matched, ", ")),
+		Data:    map[string]any{"keywords": matched, "content": content},
+	})
+}
+
+func runAdminAddAlertRule(args []string) error {
+	fs := flag.NewFlagSet("admin add-alert-rule", flag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s admin add-alert-rule --keyword <word> [options]\n\n", binName())
+		fmt.Fprintln(fs.Output(), "Adds a keyword that emits a keyword_alert integration event when posted.")
+		fmt.Fprintln(fs.Output())
+		fmt.Fprintln(fs.Output(), "Options:")
+		fs.PrintDefaults()
+	}
+	keyword := fs.String("keyword", "", "keyword or phrase to watch (case-insensitive, whole words)")
+	dbPath := fs.String("db", defaultDBPath, "sqlite db path")
+	logPath := fs.String("log", defaultLogPath, "log target path ('-' for stdout only)")
+	if err := fs.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return nil
+		}
+		return err
+	}
+	kw := strings.ToLower(strings.TrimSpace(*keyword))
+	if kw == "" {
+		return errors.New("--keyword is required")
+	}
+
+	app, closeApp, err := openAdminApp(*dbPath, *logPath)
+	if err != nil {
+		return err
+	}
+	defer closeApp()
+
+	if _, err := app.db.Exec(`INSERT INTO alert_rules(keyword, created_at) VALUES(?, ?)`, kw, nowUTC()); err != nil {
+		return err
+	}
+	_ = app.logAction("admin_cli", "admin", "add_alert_rule", "keyword="+kw)
+	fmt.Printf("added alert rule: %s\n", kw)
+	return nil
+}
+
+func runAdminListAlertRules(args []string) error {
+	fs := flag.NewFlagSet("admin list-alert-rules", flag.ContinueOnError)
+	dbPath := fs.String("db", defaultDBPath, "sqlite db path")
+	logPath := fs.String("log", defaultLogPath, "log target path ('-' for stdout only)")
+	if err := fs.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return nil
+		}
+		return err
+	}
+
+	app, closeApp, err := openAdminApp(*dbPath, *logPath)
+	if err != nil {
+		return err
+	}
+	defer closeApp()
+
+	rows, err := app.db.Query(`SELECT keyword, created_at FROM alert_rules ORDER BY keyword ASC`)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var kw, createdAt string
+		if err := rows.Scan(&kw, &createdAt); err != nil {
+			return err
+		}
+		fmt.Printf("%s\t%s\n", kw, createdAt)
+	}
+	return rows.Err()
+}
+
+func runAdminRemoveAlertRule(args []string) error {
+	fs := flag.NewFlagSet("admin remove-alert-rule", flag.ContinueOnError)
+	keyword := fs.String("keyword", "", "keyword to remove")
+	dbPath := fs.String("db", defaultDBPath, "sqlite db path")
+	logPath := fs.String("log", defaultLogPath, "log target path ('-' for stdout only)")
+	if err := fs.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return nil
+		}
+		return err
+	}
+	kw := strings.ToLower(strings.TrimSpace(*keyword))
+	if kw == "" {
+		return errors.New("--keyword is required")
+	}
+
+	app, closeApp, err := openAdminApp(*dbPath, *logPath)
+	if err != nil {
+		return err
+	}
+	defer closeApp()
+
+	res, err := app.db.Exec(`DELETE FROM alert_rules WHERE keyword = ?`, kw)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return fmt.Errorf("no alert rule for keyword: %s", kw)
+	}
+	_ = app.logAction("admin_cli", "admin", "remove_alert_rule", "keyword="+kw)
+	fmt.Printf("removed alert rule: %s\n", kw)
+	return nil
+}
@@ -0,0 +1,33 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestAPICreateEntryKeywordAlert(t *testing.T) {
+	app := newTestApp(t)
+	h := newTestMux(app)
+	token := "PUDALERT234"
+	createUser(t, app, "frank", token)
+	if _, err := app.db.Exec(`INSERT INTO alert_rules(keyword, created_at) VALUES('data loss', ?)`, nowUTC()); err != nil {
+		t.Fatalf("insert alert rule: %v", err)
+	}
+
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, authedReq(t, http.MethodPost, "/api/entries", map[string]string{
+		"content": "Possible DATA LOSS in the nightly sync",
+	}, token))
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("expected 201, got %d body=%s", rr.Code, rr.Body.String())
+	}
+
+	var n int
+	if err := app.db.QueryRow(`SELECT COUNT(*) FROM action_logs WHERE action = 'keyword_alert'`).Scan(&n); err != nil {
+		t.Fatalf("count alerts: %v", err)
+	}
+	if n != 1 {
+		t.Fatalf("expected 1 keyword_alert log, got %d", n)
+	}
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// anonymousUser replaces human authors and mentions in anonymized responses.
+const anonymousUser = "teammate"
+
+// Anonymous modes set with serve --anonymize.
+const (
+	anonymizeOff   = "off"   // ?anonymize=1 is rejected
+	anonymizeAllow = "allow" // callers opt in with ?anonymize=1
+	anonymizeForce = "force" // every list, entry and export response is anonymized
+)
+
+var errAnonymizeDisabled = errors.New("anonymous mode is disabled on this server")
+
+func parseAnonymizeMode(s string) (string, error) {
+	switch s := strings.TrimSpace(s); s {
+	case "", anonymizeOff:
+		return anonymizeOff, nil
+	case anonymizeAllow, anonymizeForce:
+		return s, nil
+	}
+	return "", fmt.Errorf("--anonymize must be %s, %s or %s", anonymizeOff, anonymizeAllow, anonymizeForce)
+}
+
+// wantAnonymous decides whether a read request gets authorship stripped,
+// combining the server mode with the caller's ?anonymize= parameter.
+func (a *App) wantAnonymous(r *http.Request) (bool, error) {
+	raw := strings.TrimSpace(r.URL.Query().Get("anonymize"))
+	requested := false
+	if raw != "" {
+		b, err := strconv.ParseBool(raw)
+		if err != nil {
+			return false, errors.New("anonymize must be a boolean")
+		}
+		requested = b
+	}
+	switch a.anonymizeMode {
+	case anonymizeForce:
+		return true, nil
+	case anonymizeAllow:
+		return requested, nil
+	}
+	if requested {
+		return false, errAnonymizeDisabled
+	}
+	return false, nil
+}
+
+// anonymousName hides human usernames. System and service actors (compaction,
+// importers) are not individuals and keep their names.
+func anonymousName(name string) string {
+	if isReservedUsername(name) {
+		return name
+	}
+	return anonymousUser
+}
+
+// scrubMentions rewrites @username mentions to @teammate.
+func scrubMentions(content string) string {
+	return mentionRe.ReplaceAllStringFunc(content, func(m string) string {
+		at := strings.IndexByte(m, '@')
+		return m[:at] + "@" + anonymousUser
+	})
+}
+
+// anonymizeCompactText rewrites the author of every "[ts][user] content" line
+// of a daily compact and drops the per-user meeting-load trailer.
+func anonymizeCompactText(content string) string {
+	if i := strings.Index(content, meetingLoadHeader); i >= 0 {
+		content = content[:i]
+	}
+	lines := strings.Split(content, "\n")
+	for i, line := range lines {
+		m := compactLineRe.FindStringSubmatch(line)
+		if m == nil {
+			continue
+		}
+		lines[i] = "[" + m[1] + "][" + anonymousName(m[2]) + "] " + scrubMentions(m[3])
+	}
+	return strings.Join(lines, "\n")
+}
+
+func anonymizeEntry(e entryRow) entryRow {
+	e.User = anonymousName(e.User)
+	if isCompactType(e.EntryType) {
+		e.Content = anonymizeCompactText(e.Content)
+	} else {
+		e.Content = scrubMentions(e.Content)
+	}
+	return e
+}
+
+func anonymizeLinks(links []entryLinkRef) {
+	for i := range links {
+		links[i].User = anonymousName(links[i].User)
+	}
+}
+
+func anonymizeNoteEntries(entries []noteEntry) {
+	for i := range entries {
+		entries[i].User = anonymousName(entries[i].User)
+		entries[i].Content = scrubMentions(entries[i].Content)
+	}
+}
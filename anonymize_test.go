@@ -0,0 +1,60 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAPIAnonymousMode(t *testing.T) {
+	app := newTestApp(t)
+	h := newTestMux(app)
+	createUser(t, app, "alice", "PUDANONAAA2")
+	createUser(t, app, "bob", "PUDANONBBB2")
+
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, authedReq(t, http.MethodPost, "/api/entries", map[string]string{"content": "retro: deploys were slow, ask @bob"}, "PUDANONAAA2"))
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("expected 201, got %d body=%s", rr.Code, rr.Body.String())
+	}
+	day := time.Now().UTC().Format("2006-01-02")
+
+	rr = httptest.NewRecorder()
+	h.ServeHTTP(rr, authedReq(t, http.MethodGet, "/api/entries?anonymize=1&day="+day, nil, "PUDANONBBB2"))
+	if rr.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400 while anonymous mode is off, got %d body=%s", rr.Code, rr.Body.String())
+	}
+
+	app.anonymizeMode = anonymizeAllow
+	rr = httptest.NewRecorder()
+	h.ServeHTTP(rr, authedReq(t, http.MethodGet, "/api/entries?day="+day, nil, "PUDANONBBB2"))
+	if !strings.Contains(rr.Body.String(), `"user":"alice"`) {
+		t.Fatalf("expected attributed list without ?anonymize, got %s", rr.Body.String())
+	}
+	rr = httptest.NewRecorder()
+	h.ServeHTTP(rr, authedReq(t, http.MethodGet, "/api/entries?anonymize=1&day="+day, nil, "PUDANONBBB2"))
+	body := rr.Body.String()
+	if rr.Code != http.StatusOK || !strings.Contains(body, `"user":"teammate"`) || strings.Contains(body, "alice") || strings.Contains(body, "@bob") {
+		t.Fatalf("expected anonymized list, got %d body=%s", rr.Code, body)
+	}
+
+	if err := app.compactDay(day); err != nil {
+		t.Fatalf("compactDay: %v", err)
+	}
+	app.anonymizeMode = anonymizeForce
+	rr = httptest.NewRecorder()
+	h.ServeHTTP(rr, authedReq(t, http.MethodGet, "/api/entries?day="+day, nil, "PUDANONBBB2"))
+	body = rr.Body.String()
+	if !strings.Contains(body, `"user":"system"`) || !strings.Contains(body, "][teammate] retro: deploys were slow, ask @teammate") || strings.Contains(body, "alice") {
+		t.Fatalf("expected anonymized compact, got %s", body)
+	}
+
+	rr = httptest.NewRecorder()
+	h.ServeHTTP(rr, authedReq(t, http.MethodGet, "/api/export/daily-note?day="+day, nil, "PUDANONBBB2"))
+	note := rr.Body.String()
+	if !strings.Contains(note, "authors: [teammate]") || !strings.Contains(note, "## teammate") || strings.Contains(note, "alice") {
+		t.Fatalf("expected anonymized daily note, got:\n%s", note)
+	}
+}
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"database/sql"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -10,11 +11,114 @@ import (
 	"time"
 )
 
+// defaultMaxEntrySize is the largest entry content accepted, in bytes, until
+// an admin changes max_entry_size in the org settings.
+const defaultMaxEntrySize = 20000
+
+// handler routes the API server: every endpoint behind the request context,
+// request log, CORS and API version middleware. Tests serve the same handler,
+// so a route or middleware cannot go missing from one and not the other.
+func (a *App) handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/health", a.handleHealth)
+	mux.HandleFunc("/api/ready", a.handleReady)
+	mux.HandleFunc("/api/openapi.json", a.handleOpenAPI)
+	mux.HandleFunc("/metrics", a.handleMetrics)
+	mux.HandleFunc("/api/setup", a.guardWrites("/api/setup", a.handleSetup))
+	mux.HandleFunc("/api/admin/compactions", a.withAuth(a.authorize(actionCompactionsRead, a.handleAdminCompactions)))
+	mux.HandleFunc("/api/admin/compact", a.guardWrites("/api/admin/compact", a.withAuth(a.authorize(actionCompactionsRun, a.handleAdminCompact))))
+	mux.HandleFunc("/api/admin/uncompact", a.guardWrites("/api/admin/uncompact", a.withAuth(a.authorize(actionCompactionsRun, a.handleAdminUncompact))))
+	mux.HandleFunc("/api/admin/maintenance", a.withAuth(a.authorize(actionMaintenance, a.handleAdminMaintenance)))
+	mux.HandleFunc("/api/admin/snapshot", a.withAuth(a.authorize(actionDBSnapshot, a.handleAdminSnapshot)))
+	mux.HandleFunc("/api/admin/integrity", a.withAuth(a.authorize(actionIntegrityRead, a.handleAdminIntegrity)))
+	mux.HandleFunc("/api/admin/usage", a.withAuth(a.authorize(actionUsageRead, a.handleAdminUsage)))
+	mux.HandleFunc("/api/admin/settings", a.withAuth(a.authorize(actionSettings, a.handleAdminSettings)))
+	mux.HandleFunc("/api/admin/pending", a.withAuth(a.authorize(actionEntriesModerate, a.handleAdminPending)))
+	mux.HandleFunc("/api/admin/pending/{id}/approve", a.guardWrites("/api/admin/pending/{id}/approve", a.withAuth(a.authorize(actionEntriesModerate, a.handleApprovePending))))
+	mux.HandleFunc("/api/admin/pending/{id}/reject", a.guardWrites("/api/admin/pending/{id}/reject", a.withAuth(a.authorize(actionEntriesModerate, a.handleRejectPending))))
+	mux.HandleFunc("/api/me/pending", a.withAuth(a.authorize(actionEntriesWrite, a.handleMyPending)))
+	mux.HandleFunc("/api/admin/backup", a.withAuth(a.authorize(actionBackups, a.handleAdminOnlineBackup)))
+	mux.HandleFunc("/api/admin/backups", a.withAuth(a.authorize(actionBackups, a.handleAdminBackups)))
+	mux.HandleFunc("/api/admin/backups/{name}", a.withAuth(a.authorize(actionBackups, a.handleAdminBackup)))
+	mux.HandleFunc("/api/admin/backups/{name}/restore", a.withAuth(a.authorize(actionBackups, a.handleAdminRestoreBackup)))
+	mux.HandleFunc("/api/admin/backups/staging/entries", a.withAuth(a.authorize(actionBackups, a.handleAdminStagingEntries)))
+	mux.HandleFunc("/api/admin/users", a.guardWrites("/api/admin/users", a.withAuth(a.authorize(actionUsersManage, a.handleAdminUsers))))
+	mux.HandleFunc("/api/admin/users/{username}", a.guardWrites("/api/admin/users/{username}", a.withAuth(a.authorize(actionUsersManage, a.handleAdminUser)), http.MethodPatch))
+	mux.HandleFunc("/api/admin/users/{username}/erase", a.guardWrites("/api/admin/users/{username}/erase", a.withAuth(a.authorize(actionUsersErase, a.handleAdminEraseUser))))
+	mux.HandleFunc("/api/admin/erasures", a.withAuth(a.authorize(actionUsersErase, a.handleAdminErasures)))
+	mux.HandleFunc("/api/admin/identity-links", a.guardWrites("/api/admin/identity-links", a.withAuth(a.authorize(actionIdentityLinks, a.handleAdminIdentityLinks))))
+	mux.HandleFunc("/api/admin/compacts/rerender", a.guardWrites("/api/admin/compacts/rerender", a.withAuth(a.authorize(actionCompactsRerender, a.handleAdminRerenderCompacts))))
+	mux.HandleFunc("/api/me", a.withAuth(a.handleMe))
+	mux.HandleFunc("/api/me/usage", a.withAuth(a.handleMyUsage))
+	mux.HandleFunc("/api/ui-config", a.withAuth(a.handleUIConfig))
+	mux.HandleFunc("/api/ws", a.withAuth(a.authorize(actionEntriesRead, a.handleLive)))
+	mux.HandleFunc("/api/me/token/rotate", a.guardWrites("/api/me/token/rotate", a.withAuth(a.authorize(actionAccountManage, a.handleRotateToken))))
+	mux.HandleFunc("/api/search", a.withAuth(a.authorize(actionEntriesRead, a.handleSearch)))
+	mux.HandleFunc("/api/handoff", a.withAuth(a.authorize(actionEntriesRead, a.handleHandoff)))
+	mux.HandleFunc("/api/grafana", a.withAuth(a.authorize(actionEntriesRead, a.handleGrafanaRoot)))
+	mux.HandleFunc("/api/grafana/{$}", a.withAuth(a.authorize(actionEntriesRead, a.handleGrafanaRoot)))
+	mux.HandleFunc("/api/grafana/metrics", a.withAuth(a.authorize(actionEntriesRead, a.handleGrafanaMetrics)))
+	mux.HandleFunc("/api/grafana/search", a.withAuth(a.authorize(actionEntriesRead, a.handleGrafanaMetrics)))
+	mux.HandleFunc("/api/grafana/query", a.withAuth(a.authorize(actionEntriesRead, a.handleGrafanaQuery)))
+	mux.HandleFunc("/api/auth/exchange", a.guardWrites("/api/auth/exchange", a.withAuth(a.handleAuthExchange)))
+	mux.HandleFunc("/api/me/tokens", a.guardWrites("/api/me/tokens", a.withAuth(a.authorize(actionAccountManage, a.handleMyTokens))))
+	mux.HandleFunc("/api/me/tokens/{id}", a.guardWrites("/api/me/tokens/{id}", a.withAuth(a.authorize(actionAccountManage, a.handleMyToken))))
+	mux.HandleFunc("/api/entries", a.guardWrites("/api/entries", a.withAuth(a.authorizeRW(actionEntriesRead, actionEntriesWrite, a.handleEntries))))
+	mux.HandleFunc("/api/entries/{id}", a.guardWrites("/api/entries/{id}", a.withAuth(a.authorizeRW(actionEntriesRead, actionEntriesWrite, a.handleEntry))))
+	mux.HandleFunc("/api/entries/{id}/restore", a.guardWrites("/api/entries/{id}/restore", a.withAuth(a.authorize(actionEntriesWrite, a.handleRestoreEntry))))
+	mux.HandleFunc("/api/trash", a.withAuth(a.authorize(actionEntriesRead, a.handleTrash)))
+	mux.HandleFunc("/api/entries/{id}/attachments", a.guardWrites("/api/entries/{id}/attachments", a.withAuth(a.authorize(actionEntriesWrite, a.handleEntryAttachments))))
+	mux.HandleFunc("/api/attachments/{sha256}", a.withAuth(a.authorize(actionEntriesRead, a.handleAttachment)))
+	mux.HandleFunc("/api/suggest", a.withAuth(a.authorize(actionEntriesRead, a.handleSuggest)))
+	mux.HandleFunc("/api/presence", a.withAuth(a.authorizeRW(actionEntriesRead, actionEntriesWrite, a.handlePresence)))
+	mux.HandleFunc("/api/stats", a.withAuth(a.authorize(actionEntriesRead, a.handleStats)))
+	mux.HandleFunc("/api/quick", a.guardWrites("/api/quick", a.withAuth(a.authorize(actionEntriesWrite, a.handleQuick)), http.MethodGet, http.MethodPost))
+	mux.HandleFunc("/api/export", a.withAuth(a.authorize(actionEntriesRead, a.handleExportEntries)))
+	mux.HandleFunc("/api/export/daily-note", a.withAuth(a.authorize(actionEntriesRead, a.handleExportDailyNote)))
+	mux.HandleFunc("/api/me/calendar", a.guardWrites("/api/me/calendar", a.withAuth(a.authorize(actionAccountManage, a.handleMyCalendar))))
+	mux.HandleFunc("/api/me/views", a.guardWrites("/api/me/views", a.withAuth(a.authorize(actionAccountManage, a.handleMyViews))))
+	mux.HandleFunc("/api/me/views/{id}", a.guardWrites("/api/me/views/{id}", a.withAuth(a.authorize(actionAccountManage, a.handleMyView))))
+	mux.HandleFunc("/api/me/views/{id}/entries", a.withAuth(a.authorize(actionEntriesRead, a.handleRunView)))
+	mux.HandleFunc("/api/me/private-entries", a.guardWrites("/api/me/private-entries", a.withAuth(a.authorize(actionAccountManage, a.handleMyPrivateEntries))))
+	mux.HandleFunc("/api/me/private-entries/{id}", a.guardWrites("/api/me/private-entries/{id}", a.withAuth(a.authorize(actionAccountManage, a.handleMyPrivateEntry))))
+	mux.HandleFunc("/api/me/private-key", a.guardWrites("/api/me/private-key", a.withAuth(a.authorize(actionAccountManage, a.handleMyPrivateKey))))
+	mux.HandleFunc("/api/me/preferences", a.guardWrites("/api/me/preferences", a.withAuth(a.authorize(actionAccountManage, a.handlePreferences))))
+	mux.HandleFunc("/api/me/quiet-hours", a.guardWrites("/api/me/quiet-hours", a.withAuth(a.authorize(actionAccountManage, a.handleMyQuietHours))))
+	mux.HandleFunc("/api/me/export", a.withAuth(a.authorize(actionAccountManage, a.handleMyExport)))
+	mux.HandleFunc("/api/archive", a.withAuth(a.authorize(actionEntriesRead, a.handleArchive)))
+	mux.HandleFunc("/api/calendar/callback", a.handleCalendarCallback)
+	mux.HandleFunc("/api/inbound/email", a.guardWrites("/api/inbound/email", a.handleInboundEmail))
+	mux.HandleFunc("/api/inbound/ci", a.guardWrites("/api/inbound/ci", a.handleInboundCI))
+	mux.HandleFunc("/api/share", a.withAuth(a.authorize(actionShareCreate, a.handleCreateShare)))
+	mux.HandleFunc("/api/embed", a.withAuth(a.authorize(actionShareCreate, a.handleCreateEmbed)))
+	mux.HandleFunc("/api/shared", a.handleShared)
+	mux.HandleFunc("/api/claim", a.guardWrites("/api/claim", a.handleClaim))
+	return a.withRequestContext(a.withRequestLog(a.withCORS(a.withAPIVersion(mux))))
+}
+
+// withCORS answers browser preflights and sets the CORS headers. With
+// --cors-origins set, only listed origins are echoed back; others get no
+// Access-Control-Allow-Origin and the browser blocks the response.
 func (a *App) withCORS(next http.Handler) http.Handler {
+	anyOrigin := len(a.corsOrigins) == 0
+	allowed := map[string]bool{}
+	for _, o := range a.corsOrigins {
+		if o == "*" {
+			anyOrigin = true
+		}
+		allowed[strings.TrimRight(o, "/")] = true
+	}
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		w.Header().Set("Access-Control-Allow-Origin", "*")
-		w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization, X-Auth-Token")
-		w.Header().Set("Access-Control-Allow-Methods", "GET, POST, OPTIONS")
+		if anyOrigin {
+			w.Header().Set("Access-Control-Allow-Origin", "*")
+		} else {
+			w.Header().Add("Vary", "Origin")
+			if origin := r.Header.Get("Origin"); allowed[origin] {
+				w.Header().Set("Access-Control-Allow-Origin", origin)
+			}
+		}
+		w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization, X-Auth-Token, X-Impersonate-User, X-Private-Passphrase")
+		w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, PATCH, DELETE, OPTIONS")
 		if r.Method == http.MethodOptions {
 			w.WriteHeader(http.StatusNoContent)
 			return
@@ -30,6 +134,51 @@ func (a *App) withAuth(next func(http.ResponseWriter, *http.Request, AuthedUser)
 			jsonErr(w, http.StatusUnauthorized, "unauthorized")
 			return
 		}
+		noteRequestUser(r, u)
+		// Usage is metered against the token owner, also when impersonating.
+		now := time.Now()
+		u.ClientIP = a.clientIP(r)
+		rc := requestFrom(r)
+		u.RequestID, u.Route = rc.ID, rc.Route
+		a.touchLastUsed(u.TokenID, u.ClientIP, now)
+		if over, err := a.quotaExceeded(u.ID, now); err != nil {
+			jsonErr(w, http.StatusInternalServerError, "failed to check quota")
+			return
+		} else if over {
+			writeQuotaExceeded(w, now)
+			return
+		}
+		mw := &meteredWriter{ResponseWriter: w}
+		w = mw
+		var body *meteredBody
+		if r.Body != nil && r.Body != http.NoBody {
+			body = &meteredBody{ReadCloser: r.Body}
+			r.Body = body
+		}
+		defer func() {
+			c := usageCounts{Requests: 1, BytesOut: mw.n}
+			if body != nil {
+				c.BytesIn = body.n
+			}
+			a.meter.record(now.UTC().Format("2006-01-02"), u.ID, c)
+		}()
+		if target := strings.TrimSpace(r.Header.Get("X-Impersonate-User")); target != "" {
+			if !a.policy.allows(u.Role, actionImpersonate) {
+				jsonErr(w, http.StatusForbidden, "impersonation requires the "+actionImpersonate+" permission")
+				return
+			}
+			var t AuthedUser
+			err := a.db.QueryRow(`SELECT id, username, role FROM users WHERE username = ? AND kind = 'human' AND disabled = 0`, target).Scan(&t.ID, &t.Username, &t.Role)
+			if err != nil {
+				jsonErr(w, http.StatusNotFound, "impersonated user not found")
+				return
+			}
+			t.ImpersonatedBy = u.Username
+			t.ClientIP, t.RequestID, t.Route = u.ClientIP, u.RequestID, u.Route
+			_ = a.recordAction(auditRecord{ActorType: "api_admin", ActorUsername: u.Username, Impersonator: u.Username, Action: "impersonate", Metadata: fmt.Sprintf("target=%s method=%s path=%s", t.Username, r.Method, r.URL.Path), ClientIP: u.ClientIP, RequestID: u.RequestID, Route: u.Route})
+			u = t
+			noteRequestUser(r, u)
+		}
 		next(w, r, u)
 	}
 }
@@ -43,15 +192,12 @@ func (a *App) authUser(r *http.Request) (AuthedUser, error) {
 		}
 	}
 	if tok == "" {
-		return AuthedUser{}, errors.New("missing token")
+		tok = liveProtocolToken(r)
 	}
-	hash := hashToken(tok)
-	var u AuthedUser
-	err := a.db.QueryRow(`SELECT id, username FROM users WHERE token_hash = ?`, hash).Scan(&u.ID, &u.Username)
-	if err != nil {
-		return AuthedUser{}, err
+	if tok == "" {
+		return AuthedUser{}, errors.New("missing token")
 	}
-	return u, nil
+	return a.lookupToken(tok)
 }
 
 func (a *App) handleHealth(w http.ResponseWriter, _ *http.Request) {
@@ -59,8 +205,22 @@ func (a *App) handleHealth(w http.ResponseWriter, _ *http.Request) {
 }
 
 func (a *App) handleMe(w http.ResponseWriter, r *http.Request, u AuthedUser) {
-	_ = a.logAction("api_user", u.Username, "whoami", "path=/api/me")
-	jsonOut(w, http.StatusOK, u)
+	_ = a.logUserAction(u, "whoami", "path=/api/me")
+	resp := struct {
+		AuthedUser
+		LastUsedAt string `json:"last_used_at,omitempty"`
+		LastUsedIP string `json:"last_used_ip,omitempty"`
+	}{AuthedUser: u}
+	// The token in use; an impersonated user's most recently used one.
+	var lastUsed sql.NullString
+	err := a.db.QueryRow(`SELECT last_used_at, last_used_ip FROM tokens WHERE user_id = ? ORDER BY id = ? DESC, last_used_at DESC LIMIT 1`,
+		u.ID, u.TokenID).Scan(&lastUsed, &resp.LastUsedIP)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		jsonErr(w, http.StatusInternalServerError, "failed to query user")
+		return
+	}
+	resp.LastUsedAt = lastUsed.String
+	jsonOut(w, http.StatusOK, resp)
 }
 
 func (a *App) handleEntries(w http.ResponseWriter, r *http.Request, u AuthedUser) {
@@ -75,12 +235,10 @@ func (a *App) handleEntries(w http.ResponseWriter, r *http.Request, u AuthedUser
 }
 
 func (a *App) handleCreateEntry(w http.ResponseWriter, r *http.Request, u AuthedUser) {
-	if a.writeLocked.Load() {
-		jsonErr(w, http.StatusLocked, "writes are temporarily locked for daily compaction")
-		return
-	}
 	var req struct {
-		Content string `json:"content"`
+		Content  string `json:"content"`
+		Category string `json:"category"`
+		Source   string `json:"source"`
 	}
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 		jsonErr(w, http.StatusBadRequest, "invalid json")
@@ -91,48 +249,200 @@ func (a *App) handleCreateEntry(w http.ResponseWriter, r *http.Request, u Authed
 		jsonErr(w, http.StatusBadRequest, "content is required")
 		return
 	}
-	if len(req.Content) > 20000 {
+	settings := a.settings()
+	if len(req.Content) > settings.MaxEntrySize {
 		jsonErr(w, http.StatusBadRequest, "content too large")
 		return
 	}
-
-	res, err := a.db.Exec(`INSERT INTO entries(user_id, entry_type, content, created_at) VALUES(?, 'normal', ?, ?)`, u.ID, req.Content, nowUTC())
+	category, err := settings.checkCategory(req.Category)
+	if err != nil {
+		jsonErr(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	source, err := clientSource(req.Source)
+	if err != nil {
+		jsonErr(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	status, resp, err := a.storeUserEntry(u, req.Content, category, source)
 	if err != nil {
-		jsonErr(w, http.StatusInternalServerError, "failed to store entry")
+		jsonErr(w, status, err.Error())
 		return
 	}
-	id, _ := res.LastInsertId()
-	_ = a.logAction("api_user", u.Username, "create_entry", fmt.Sprintf("entry_id=%d size=%d", id, len(req.Content)))
-	jsonOut(w, http.StatusCreated, map[string]any{"id": id, "status": "created"})
+	jsonOut(w, status, resp)
 }
 
-func (a *App) handleListEntries(w http.ResponseWriter, r *http.Request, u AuthedUser) {
-	day := strings.TrimSpace(r.URL.Query().Get("day"))
-	if day == "" {
-		day = time.Now().Format("2006-01-02")
+// storeUserEntry is the shared write path for user-authored entries: secret
+// redaction, queueing during compaction, insert, audit and side effects.
+// source is the ingest channel (entrySources), stored with the entry and
+// named in the audit metadata; category must already be checked against the
+// org settings.
+// It returns the HTTP status and response body to send.
+func (a *App) storeUserEntry(u AuthedUser, content, category, source string) (int, map[string]any, error) {
+	viaMeta := " source=" + source
+	if category != "" {
+		viaMeta += " category=" + category
+	}
+	redacted, secrets := scanSecrets(content)
+	if len(secrets) > 0 && a.redactSecrets {
+		content = redacted
+	}
+
+	if needsApproval(u) {
+		pid, err := a.submitPending(u, content, category, source)
+		if err != nil {
+			return http.StatusInternalServerError, nil, errors.New("failed to store entry")
+		}
+		_ = a.logUserAction(u, "submit_entry", fmt.Sprintf("pending_id=%d size=%d%s", pid, len(content), viaMeta))
+		resp := map[string]any{"pending_id": pid, "status": "pending"}
+		if len(secrets) > 0 {
+			_ = a.logUserAction(u, "secret_detected", fmt.Sprintf("pending_id=%d kinds=%s redacted=%t", pid, strings.Join(secrets, ","), a.redactSecrets))
+			resp["secrets_detected"] = secrets
+			resp["redacted"] = a.redactSecrets
+		}
+		return http.StatusAccepted, resp, nil
 	}
-	if _, err := time.Parse("2006-01-02", day); err != nil {
-		jsonErr(w, http.StatusBadRequest, "day must be YYYY-MM-DD")
+
+	// During compaction the entry goes to the intake queue and is flushed
+	// into entries once the write lock is released.
+	if a.writeLocked.Load() {
+		qid, err := a.enqueueIntake(u, content, category, source, nowUTC())
+		if err != nil {
+			return http.StatusInternalServerError, nil, errors.New("failed to queue entry")
+		}
+		_ = a.logUserAction(u, "queue_entry", fmt.Sprintf("queue_id=%d size=%d%s", qid, len(content), viaMeta))
+		resp := map[string]any{"queue_id": qid, "status": "queued"}
+		if len(secrets) > 0 {
+			_ = a.logUserAction(u, "secret_detected", fmt.Sprintf("queue_id=%d kinds=%s redacted=%t", qid, strings.Join(secrets, ","), a.redactSecrets))
+			resp["secrets_detected"] = secrets
+			resp["redacted"] = a.redactSecrets
+		}
+		return http.StatusAccepted, resp, nil
+	}
+
+	createdAt := nowUTC()
+	id, err := a.insertEntry(u.ID, content, category, source, createdAt)
+	if err != nil {
+		return http.StatusInternalServerError, nil, errors.New("failed to store entry")
+	}
+	_ = a.logUserAction(u, "create_entry", fmt.Sprintf("entry_id=%d size=%d%s", id, len(content), viaMeta))
+	a.afterEntryCreated(u, id, content, createdAt)
+	resp := map[string]any{"id": id, "status": "created", "url": a.entryURL(id, createdAt)}
+	if len(secrets) > 0 {
+		_ = a.logUserAction(u, "secret_detected", fmt.Sprintf("entry_id=%d kinds=%s redacted=%t", id, strings.Join(secrets, ","), a.redactSecrets))
+		resp["secrets_detected"] = secrets
+		resp["redacted"] = a.redactSecrets
+	}
+	return http.StatusCreated, resp, nil
+}
+
+// afterEntryCreated runs the side effects shared by every path that stores a
+// user-authored entry.
+func (a *App) afterEntryCreated(u AuthedUser, id int64, content, createdAt string) {
+	a.presence.set(u.Username, false, time.Now())
+	a.publishLive(liveEvent{Type: "entry_created", Day: createdAt[:10], EntryID: id, User: u.Username})
+	a.recordEntryLinks(id, content)
+	a.fireKeywordAlerts(u, id, content)
+	a.notifyMentions(u, id, content)
+	go a.enrichEntryIssues(id, content)
+}
+
+// maxListRangeDays caps ?from=&to= on GET /api/entries; longer walks page
+// through consecutive ranges.
+const maxListRangeDays = 31
+
+// handleListEntries lists one day (?day=, default today) or a day range
+// (?from=&to=), newest first. ?cursor= continues after the previous page's
+// next_cursor, which is empty once the list is exhausted.
+func (a *App) handleListEntries(w http.ResponseWriter, r *http.Request, u AuthedUser) {
+	q := r.URL.Query()
+	day := strings.TrimSpace(q.Get("day"))
+	from, to := strings.TrimSpace(q.Get("from")), strings.TrimSpace(q.Get("to"))
+	ranged := from != "" || to != ""
+	switch {
+	case ranged && day != "":
+		jsonErr(w, http.StatusBadRequest, "use either day or from/to")
 		return
+	case ranged:
+		if from == "" || to == "" {
+			jsonErr(w, http.StatusBadRequest, "from and to are both required")
+			return
+		}
+		if err := validDayRange(from, to); err != nil {
+			jsonErr(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		f, _ := time.Parse("2006-01-02", from)
+		t, _ := time.Parse("2006-01-02", to)
+		if t.Sub(f) >= maxListRangeDays*24*time.Hour {
+			jsonErr(w, http.StatusBadRequest, fmt.Sprintf("range must span at most %d days", maxListRangeDays))
+			return
+		}
+	default:
+		if day == "" {
+			day = time.Now().Format("2006-01-02")
+		}
+		if _, err := time.Parse("2006-01-02", day); err != nil {
+			jsonErr(w, http.StatusBadRequest, "day must be YYYY-MM-DD")
+			return
+		}
+		from, to = day, day
 	}
 	limit := 200
-	if raw := strings.TrimSpace(r.URL.Query().Get("limit")); raw != "" {
+	if raw := strings.TrimSpace(q.Get("limit")); raw != "" {
 		if n, err := strconv.Atoi(raw); err == nil && n > 0 && n <= 1000 {
 			limit = n
 		}
 	}
+	var cursor *entryCursor
+	if raw := strings.TrimSpace(q.Get("cursor")); raw != "" {
+		c, err := decodeEntryCursor(raw)
+		if err != nil {
+			jsonErr(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		cursor = &c
+	}
+	anonymous, err := a.wantAnonymous(r)
+	if err != nil {
+		jsonErr(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	sources, err := parseSourceFilter(q.Get("source"))
+	if err != nil {
+		jsonErr(w, http.StatusBadRequest, err.Error())
+		return
+	}
 
+	where := `date(e.created_at) BETWEEN ? AND ? AND e.deleted_at IS NULL`
+	args := []any{from, to}
+	if len(sources) > 0 {
+		where += ` AND e.source IN (` + placeholders(len(sources)) + `)`
+		for _, src := range sources {
+			args = append(args, src)
+		}
+	}
+	countWhere, countArgs := where, args
+	if cursor != nil {
+		where += ` AND (e.created_at < ? OR (e.created_at = ? AND e.id < ?))`
+		args = append(args, cursor.CreatedAt, cursor.CreatedAt, cursor.ID)
+	}
+	// One extra row tells whether another page follows.
 	rows, err := a.db.Query(`
 SELECT e.id,
-       COALESCE(u.username, 'system') AS username,
+       u.username,
+       u.kind,
        e.entry_type,
+       e.category,
+       e.source,
        e.content,
-       e.created_at
+       e.created_at,
+       COALESCE(e.edited_at, '')
 FROM entries e
-LEFT JOIN users u ON u.id = e.user_id
-WHERE date(e.created_at) = ?
+JOIN users u ON u.id = e.user_id
+WHERE `+where+`
 ORDER BY e.created_at DESC, e.id DESC
-LIMIT ?`, day, limit)
+LIMIT ?`, append(args, limit+1)...)
 	if err != nil {
 		jsonErr(w, http.StatusInternalServerError, "failed to query entries")
 		return
@@ -142,14 +452,123 @@ LIMIT ?`, day, limit)
 	entries := make([]entryRow, 0, 32)
 	for rows.Next() {
 		var e entryRow
-		if err := rows.Scan(&e.ID, &e.User, &e.EntryType, &e.Content, &e.CreatedAt); err != nil {
+		if err := rows.Scan(&e.ID, &e.User, &e.UserKind, &e.EntryType, &e.Category, &e.Source, &e.Content, &e.CreatedAt, &e.EditedAt); err != nil {
 			jsonErr(w, http.StatusInternalServerError, "failed to parse entries")
 			return
 		}
 		entries = append(entries, e)
 	}
-	_ = a.logAction("api_user", u.Username, "list_entries", fmt.Sprintf("day=%s limit=%d", day, limit))
-	jsonOut(w, http.StatusOK, map[string]any{"entries": entries, "day": day})
+	_ = rows.Close()
+	nextCursor := ""
+	if len(entries) > limit {
+		entries = entries[:limit]
+		last := entries[limit-1]
+		nextCursor = entryCursor{CreatedAt: last.CreatedAt, ID: last.ID}.encode()
+	}
+
+	ids := make([]int64, len(entries))
+	for i, e := range entries {
+		ids[i] = e.ID
+	}
+	issues, err := a.entryIssues(ids)
+	if err != nil {
+		jsonErr(w, http.StatusInternalServerError, "failed to query issues")
+		return
+	}
+	for i := range entries {
+		entries[i].Issues = issues[entries[i].ID]
+		entries[i].URL = a.entryURL(entries[i].ID, entries[i].CreatedAt)
+		if anonymous {
+			entries[i] = anonymizeEntry(entries[i])
+		}
+	}
+	quarantine, err := a.rangeIntegrityIssues(from, to)
+	if err != nil {
+		jsonErr(w, http.StatusInternalServerError, "failed to query integrity issues")
+		return
+	}
+	total, err := a.rangeEntryCount(from, to)
+	if len(sources) > 0 {
+		// entry_counts does not split by source.
+		err = a.db.QueryRow(`SELECT COUNT(*) FROM entries e WHERE `+countWhere, countArgs...).Scan(&total)
+	}
+	if err != nil {
+		jsonErr(w, http.StatusInternalServerError, "failed to count entries")
+		return
+	}
+	if total < len(entries) {
+		total = len(entries)
+	}
+	truncated := nextCursor != ""
+	scope := "day=" + day
+	if ranged {
+		scope = fmt.Sprintf("from=%s to=%s", from, to)
+	}
+	if len(sources) > 0 {
+		scope += " source=" + strings.Join(sources, ",")
+	}
+	_ = a.logUserAction(u, "list_entries", fmt.Sprintf("%s limit=%d cursor=%t anonymous=%t", scope, limit, cursor != nil, anonymous))
+
+	// Rows are read before writing: with one SQLite connection, a slow client
+	// must not hold it. Encoding is streamed per entry instead of building the
+	// whole document, which is what dominates memory on days with big compacts.
+	ndjson := wantNDJSON(r)
+	if ndjson {
+		w.Header().Set("Content-Type", ndjsonContentType)
+		if ranged {
+			w.Header().Set("X-Devlog-From", from)
+			w.Header().Set("X-Devlog-To", to)
+		} else {
+			w.Header().Set("X-Devlog-Day", day)
+		}
+		w.Header().Set("X-Devlog-Total-Count", strconv.Itoa(total))
+		w.Header().Set("X-Devlog-Truncated", strconv.FormatBool(truncated))
+		w.Header().Set("X-Devlog-Next-Cursor", nextCursor)
+		if len(quarantine) > 0 {
+			w.Header().Set("X-Devlog-Quarantined", "true")
+		}
+	} else {
+		w.Header().Set("Content-Type", "application/json")
+	}
+	out, closeOut := a.compressResponse(w, r)
+	w.WriteHeader(http.StatusOK)
+	head := []jsonField{{"day", day}, {"url", a.dayURL(day)}}
+	if ranged {
+		head = []jsonField{{"from", from}, {"to", to}}
+	}
+	head = append(head, jsonField{"total_count", total}, jsonField{"truncated", truncated}, jsonField{"next_cursor", nextCursor})
+	if len(quarantine) > 0 {
+		head = append(head, jsonField{"integrity_issues", quarantine}, jsonField{"quarantined", true})
+	}
+	stream := startJSONArrayStream(out, ndjson, head, "entries")
+	for i := range entries {
+		stream.add(entries[i])
+		entries[i] = entryRow{}
+	}
+	err = stream.finish(nil)
+	closeOut()
+	if err != nil {
+		a.logger.Printf("event=list_entries_stream_failed from=%s to=%s err=%v", from, to, err)
+	}
+}
+
+// entryByID loads one entry with its issue references.
+func (a *App) entryByID(id int64) (entryRow, error) {
+	var e entryRow
+	err := a.db.QueryRow(`
+SELECT e.id, u.username, u.kind, e.entry_type, e.category, e.source, e.content, e.created_at, COALESCE(e.edited_at, '')
+FROM entries e
+JOIN users u ON u.id = e.user_id
+WHERE e.id = ? AND e.deleted_at IS NULL`, id).Scan(&e.ID, &e.User, &e.UserKind, &e.EntryType, &e.Category, &e.Source, &e.Content, &e.CreatedAt, &e.EditedAt)
+	if err != nil {
+		return entryRow{}, err
+	}
+	issues, err := a.entryIssues([]int64{id})
+	if err != nil {
+		return entryRow{}, err
+	}
+	e.Issues = issues[id]
+	return e, nil
 }
 
 func jsonOut(w http.ResponseWriter, code int, v any) {
@@ -161,3 +580,56 @@ func jsonOut(w http.ResponseWriter, code int, v any) {
 func jsonErr(w http.ResponseWriter, code int, msg string) {
 	jsonOut(w, code, map[string]string{"error": msg})
 }
+
+type compactionRow struct {
+	Day         string `json:"day"`
+	RanAt       string `json:"ran_at"`
+	MergedCount int64  `json:"merged_count"`
+	BytesBefore int64  `json:"bytes_before"`
+	BytesAfter  int64  `json:"bytes_after"`
+	DurationMS  int64  `json:"duration_ms"`
+	Phase       string `json:"phase"`
+	URL         string `json:"url"`
+}
+
+func (a *App) handleAdminCompactions(w http.ResponseWriter, r *http.Request, u AuthedUser) {
+	if r.Method != http.MethodGet {
+		jsonErr(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	limit := 30
+	if raw := strings.TrimSpace(r.URL.Query().Get("limit")); raw != "" {
+		if n, err := strconv.Atoi(raw); err == nil && n > 0 && n <= 1000 {
+			limit = n
+		}
+	}
+	rows, err := a.db.Query(`
+SELECT day, ran_at, merged_count, bytes_before, bytes_after, duration_ms, phase
+FROM compactions
+ORDER BY day DESC
+LIMIT ?`, limit)
+	if err != nil {
+		jsonErr(w, http.StatusInternalServerError, "failed to query compactions")
+		return
+	}
+	defer rows.Close()
+
+	out := make([]compactionRow, 0, limit)
+	for rows.Next() {
+		var c compactionRow
+		if err := rows.Scan(&c.Day, &c.RanAt, &c.MergedCount, &c.BytesBefore, &c.BytesAfter, &c.DurationMS, &c.Phase); err != nil {
+			jsonErr(w, http.StatusInternalServerError, "failed to parse compactions")
+			return
+		}
+		c.URL = a.dayURL(c.Day)
+		out = append(out, c)
+	}
+	_ = rows.Close()
+	var total int
+	if err := a.db.QueryRow(`SELECT COUNT(*) FROM compactions`).Scan(&total); err != nil {
+		jsonErr(w, http.StatusInternalServerError, "failed to count compactions")
+		return
+	}
+	_ = a.logUserAction(u, "list_compactions", fmt.Sprintf("limit=%d", limit))
+	jsonOut(w, http.StatusOK, map[string]any{"compactions": out, "total_count": total, "truncated": total > len(out)})
+}
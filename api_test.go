@@ -32,24 +32,30 @@ func newTestApp(t *testing.T) *App {
 }
 
 func newTestMux(app *App) http.Handler {
-	mux := http.NewServeMux()
-	mux.HandleFunc("/api/health", app.handleHealth)
-	mux.HandleFunc("/api/me", app.withAuth(app.handleMe))
-	mux.HandleFunc("/api/entries", app.withAuth(app.handleEntries))
-	return app.withCORS(mux)
+	return app.handler()
 }
 
 func createUser(t *testing.T, app *App, username, token string) {
 	t.Helper()
-	_, err := app.db.Exec(
-		`INSERT INTO users(username, token_hash, created_at) VALUES(?, ?, ?)`,
+	res, err := app.db.Exec(
+		`INSERT INTO users(username, created_at) VALUES(?, ?)`,
 		username,
-		hashToken(token),
 		nowUTC(),
 	)
 	if err != nil {
 		t.Fatalf("createUser: %v", err)
 	}
+	id, _ := res.LastInsertId()
+	_, err = app.db.Exec(
+		`INSERT INTO tokens(user_id, name, token_hash, created_at) VALUES(?, ?, ?, ?)`,
+		id,
+		defaultTokenName,
+		hashToken(token),
+		nowUTC(),
+	)
+	if err != nil {
+		t.Fatalf("createUser token: %v", err)
+	}
 }
 
 func authedReq(t *testing.T, method, path string, body any, token string) *http.Request {
@@ -160,34 +166,57 @@ func TestAPICreateAndListEntries(t *testing.T) {
 	}
 }
 
-func TestAPICreateEntryLocked(t *testing.T) {
+func TestAPIListEntriesInvalidDay(t *testing.T) {
 	app := newTestApp(t)
 	h := newTestMux(app)
-	token := "PUDQWERTYU1"
-	createUser(t, app, "eve", token)
-	app.writeLocked.Store(true)
+	token := "PUDDAYTEST1"
+	createUser(t, app, "chris", token)
 
 	rr := httptest.NewRecorder()
-	h.ServeHTTP(rr, authedReq(t, http.MethodPost, "/api/entries", map[string]string{
-		"content": "blocked write",
-	}, token))
+	h.ServeHTTP(rr, authedReq(t, http.MethodGet, "/api/entries?day=17-02-2026", nil, token))
 
-	if rr.Code != http.StatusLocked {
-		t.Fatalf("expected 423, got %d body=%s", rr.Code, rr.Body.String())
+	if rr.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400, got %d body=%s", rr.Code, rr.Body.String())
 	}
 }
 
-func TestAPIListEntriesInvalidDay(t *testing.T) {
+func TestAPIAdminImpersonation(t *testing.T) {
 	app := newTestApp(t)
 	h := newTestMux(app)
-	token := "PUDDAYTEST1"
-	createUser(t, app, "chris", token)
+	createUser(t, app, "root", "PUDADMIN234")
+	createUser(t, app, "ivy", "PUDMEMBER23")
+	if _, err := app.db.Exec(`UPDATE users SET role = 'admin' WHERE username = 'root'`); err != nil {
+		t.Fatalf("promote admin: %v", err)
+	}
 
+	req := authedReq(t, http.MethodPost, "/api/entries", map[string]string{"content": "migrated note"}, "PUDADMIN234")
+	req.Header.Set("X-Impersonate-User", "ivy")
 	rr := httptest.NewRecorder()
-	h.ServeHTTP(rr, authedReq(t, http.MethodGet, "/api/entries?day=17-02-2026", nil, token))
+	h.ServeHTTP(rr, req)
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("expected 201, got %d body=%s", rr.Code, rr.Body.String())
+	}
 
-	if rr.Code != http.StatusBadRequest {
-		t.Fatalf("expected 400, got %d body=%s", rr.Code, rr.Body.String())
+	var author string
+	if err := app.db.QueryRow(`SELECT u.username FROM entries e JOIN users u ON u.id = e.user_id`).Scan(&author); err != nil {
+		t.Fatalf("query entry author: %v", err)
+	}
+	if author != "ivy" {
+		t.Fatalf("expected entry attributed to ivy, got %q", author)
+	}
+	var impersonator string
+	if err := app.db.QueryRow(`SELECT impersonator FROM action_logs WHERE action = 'create_entry'`).Scan(&impersonator); err != nil {
+		t.Fatalf("query action log: %v", err)
+	}
+	if impersonator != "root" {
+		t.Fatalf("expected impersonator root, got %q", impersonator)
 	}
-}
 
+	req = authedReq(t, http.MethodGet, "/api/me", nil, "PUDMEMBER23")
+	req.Header.Set("X-Impersonate-User", "root")
+	rr = httptest.NewRecorder()
+	h.ServeHTTP(rr, req)
+	if rr.Code != http.StatusForbidden {
+		t.Fatalf("expected 403 for non-admin impersonation, got %d", rr.Code)
+	}
+}
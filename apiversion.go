@@ -0,0 +1,94 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// API versioning: every route is served under /api/v1 as well, which is the
+// stable contract for tooling. The unversioned /api/... paths still work but
+// are deprecated: responses carry Deprecation, a successor-version Link and,
+// once --api-unversioned-cutoff is set, Sunset; from the cutoff day on they
+// answer 410. A few paths are held by systems outside the operator's
+// control (share links already handed out, OAuth redirects, webhooks,
+// probes) and stay unversioned without a warning.
+
+const apiV1Prefix = "/api/v1"
+
+// unversionedStable are the /api paths exempt from deprecation.
+var unversionedStable = []string{"/api/health", "/api/ready", "/api/shared", "/api/calendar/callback", "/api/inbound/"}
+
+// unversionedPath maps /api/v1/x to /api/x and returns other paths as is.
+func unversionedPath(p string) string {
+	if rest, ok := strings.CutPrefix(p, apiV1Prefix); ok && (rest == "" || rest[0] == '/') {
+		return "/api" + rest
+	}
+	return p
+}
+
+func isStableUnversioned(p string) bool {
+	for _, s := range unversionedStable {
+		if p == s || (strings.HasSuffix(s, "/") && strings.HasPrefix(p, s)) {
+			return true
+		}
+	}
+	return false
+}
+
+// withAPIVersion serves /api/v1/... from next's unversioned routes and
+// applies the deprecation policy to unversioned calls.
+func (a *App) withAPIVersion(next http.Handler) http.Handler {
+	var seen sync.Map
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		p := r.URL.Path
+		if p == apiV1Prefix || strings.HasPrefix(p, apiV1Prefix+"/") {
+			r2 := new(http.Request)
+			*r2 = *r
+			u := *r.URL
+			u.Path = unversionedPath(p)
+			u.RawPath = ""
+			r2.URL = &u
+			next.ServeHTTP(w, r2)
+			return
+		}
+		if !strings.HasPrefix(p, "/api/") || isStableUnversioned(p) || r.Method == http.MethodOptions {
+			next.ServeHTTP(w, r)
+			return
+		}
+		// Logged once per path per process, to find callers still on it.
+		if _, dup := seen.LoadOrStore(p, true); !dup {
+			a.logger.Printf("event=unversioned_api path=%s user_agent=%q", p, r.UserAgent())
+		}
+		successor := apiV1Prefix + strings.TrimPrefix(p, "/api")
+		w.Header().Set("Deprecation", "true")
+		w.Header().Set("Link", fmt.Sprintf("<%s>; rel=\"successor-version\"", successor))
+		if !a.unversionedCutoff.IsZero() {
+			w.Header().Set("Sunset", a.unversionedCutoff.Format(http.TimeFormat))
+			if !time.Now().Before(a.unversionedCutoff) {
+				jsonOut(w, http.StatusGone, map[string]string{
+					"error":     fmt.Sprintf("unversioned API paths were retired on %s; use %s", a.unversionedCutoff.Format("2006-01-02"), successor),
+					"successor": successor,
+				})
+				return
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// parseAPICutoff parses --api-unversioned-cutoff: a YYYY-MM-DD day (UTC
+// midnight) or "" for none.
+func parseAPICutoff(v string) (time.Time, error) {
+	v = strings.TrimSpace(v)
+	if v == "" {
+		return time.Time{}, nil
+	}
+	t, err := time.Parse("2006-01-02", v)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid --api-unversioned-cutoff %q (want YYYY-MM-DD)", v)
+	}
+	return t, nil
+}
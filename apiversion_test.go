@@ -0,0 +1,62 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAPIVersioning(t *testing.T) {
+	app := newTestApp(t)
+	h := newTestMux(app)
+	createUser(t, app, "alice", "PUDVERSION1")
+	do := func(method, path string, body any) *httptest.ResponseRecorder {
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, authedReq(t, method, path, body, "PUDVERSION1"))
+		return rr
+	}
+
+	rr := do(http.MethodPost, "/api/v1/entries", map[string]string{"content": "versioned write"})
+	if rr.Code != http.StatusCreated || rr.Header().Get("Deprecation") != "" {
+		t.Fatalf("v1 create: %d deprecation=%q %s", rr.Code, rr.Header().Get("Deprecation"), rr.Body.String())
+	}
+	var created struct {
+		ID int64 `json:"id"`
+	}
+	_ = json.Unmarshal(rr.Body.Bytes(), &created)
+	// Path parameters survive the rewrite.
+	if rr := do(http.MethodGet, fmt.Sprintf("/api/v1/entries/%d", created.ID), nil); rr.Code != http.StatusOK {
+		t.Fatalf("v1 get entry: %d %s", rr.Code, rr.Body.String())
+	}
+
+	rr = do(http.MethodGet, "/api/entries", nil)
+	if rr.Code != http.StatusOK || rr.Header().Get("Deprecation") != "true" || rr.Header().Get("Link") != `</api/v1/entries>; rel="successor-version"` || rr.Header().Get("Sunset") != "" {
+		t.Fatalf("unversioned list: %d %v", rr.Code, rr.Header())
+	}
+	if rr := do(http.MethodGet, "/api/health", nil); rr.Header().Get("Deprecation") != "" {
+		t.Fatal("stable path /api/health marked deprecated")
+	}
+
+	app.unversionedCutoff = time.Now().UTC().Add(24 * time.Hour).Truncate(24 * time.Hour)
+	rr = do(http.MethodGet, "/api/me", nil)
+	if rr.Code != http.StatusOK || rr.Header().Get("Sunset") != app.unversionedCutoff.Format(http.TimeFormat) {
+		t.Fatalf("before cutoff: %d sunset=%q", rr.Code, rr.Header().Get("Sunset"))
+	}
+	app.unversionedCutoff = time.Now().UTC().Add(-24 * time.Hour)
+	if rr := do(http.MethodGet, "/api/me", nil); rr.Code != http.StatusGone || !strings.Contains(rr.Body.String(), "/api/v1/me") {
+		t.Fatalf("after cutoff: %d %s", rr.Code, rr.Body.String())
+	}
+	if rr := do(http.MethodGet, "/api/v1/me", nil); rr.Code != http.StatusOK {
+		t.Fatalf("v1 after cutoff: %d", rr.Code)
+	}
+	if rr := do(http.MethodGet, "/api/ready", nil); rr.Code == http.StatusGone {
+		t.Fatal("stable path /api/ready retired")
+	}
+	if unversionedPath("/api/v10/x") != "/api/v10/x" || unversionedPath("/api/v1") != "/api" {
+		t.Fatal("unversionedPath prefix handling")
+	}
+}
@@ -0,0 +1,241 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// Approval workflow: entries written by contributor-role users (interns,
+// vendors) do not go into entries. storeUserEntry parks them in
+// pending_entries, where they are invisible to lists, search, exports and
+// compaction, until an entries.moderate holder approves or rejects them.
+// Approval moves the row into entries with its original timestamp, unless
+// that day has been compacted meanwhile, in which case it is stamped with
+// the approval time so it is picked up by a later compaction.
+
+const roleContributor = "contributor"
+
+var errPendingNotFound = errors.New("pending entry not found")
+
+type pendingEntry struct {
+	ID        int64  `json:"id"`
+	User      string `json:"user"`
+	Category  string `json:"category,omitempty"`
+	Content   string `json:"content"`
+	Via       string `json:"via,omitempty"`
+	CreatedAt string `json:"created_at"`
+}
+
+// needsApproval reports whether entries by u wait for a moderator.
+func needsApproval(u AuthedUser) bool {
+	return u.Role == roleContributor
+}
+
+// submitPending stores a contributor's entry for review.
+func (a *App) submitPending(u AuthedUser, content, category, via string) (int64, error) {
+	res, err := a.db.Exec(`INSERT INTO pending_entries(user_id, content, category, via, created_at) VALUES(?, ?, ?, ?, ?)`, u.ID, content, category, via, nowUTC())
+	if err != nil {
+		return 0, err
+	}
+	return res.LastInsertId()
+}
+
+// listPending returns pending entries oldest first, only userID's when it is
+// non-zero.
+func (a *App) listPending(userID int64) ([]pendingEntry, error) {
+	where, args := "", []any{}
+	if userID != 0 {
+		where, args = "WHERE p.user_id = ?", append(args, userID)
+	}
+	rows, err := a.db.Query(`
+SELECT p.id, u.username, p.category, p.content, p.via, p.created_at
+FROM pending_entries p
+JOIN users u ON u.id = p.user_id
+`+where+`
+ORDER BY p.created_at ASC, p.id ASC`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	out := []pendingEntry{}
+	for rows.Next() {
+		var p pendingEntry
+		if err := rows.Scan(&p.ID, &p.User, &p.Category, &p.Content, &p.Via, &p.CreatedAt); err != nil {
+			return nil, err
+		}
+		out = append(out, p)
+	}
+	return out, rows.Err()
+}
+
+// approvedEntry is a pending entry after approvePending stored it.
+type approvedEntry struct {
+	ID        int64
+	Author    AuthedUser
+	Content   string
+	CreatedAt string
+}
+
+// approvePending moves pending entry id into entries. It runs under
+// compactMu so the day cannot be compacted between the phase check and the
+// insert.
+func (a *App) approvePending(id int64) (approvedEntry, error) {
+	a.compactMu.Lock()
+	defer a.compactMu.Unlock()
+
+	var e approvedEntry
+	var category, via string
+	err := a.db.QueryRow(`
+SELECT u.id, u.username, u.role, p.content, p.category, p.via, p.created_at
+FROM pending_entries p
+JOIN users u ON u.id = p.user_id
+WHERE p.id = ?`, id).Scan(&e.Author.ID, &e.Author.Username, &e.Author.Role, &e.Content, &category, &via, &e.CreatedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return e, errPendingNotFound
+	}
+	if err != nil {
+		return e, err
+	}
+	phase, err := a.compactionPhase(e.CreatedAt[:10])
+	if err != nil {
+		return e, err
+	}
+	if phase != "" {
+		e.CreatedAt = nowUTC()
+	}
+
+	tx, err := a.db.Begin()
+	if err != nil {
+		return e, err
+	}
+	defer func() { _ = tx.Rollback() }()
+	res, err := tx.Exec(`INSERT INTO entries(user_id, entry_type, content, category, source, created_at) VALUES(?, 'normal', ?, ?, ?, ?)`, e.Author.ID, e.Content, category, pendingSource(via), e.CreatedAt)
+	if err != nil {
+		return e, err
+	}
+	e.ID, _ = res.LastInsertId()
+	if _, err := tx.Exec(`DELETE FROM pending_entries WHERE id = ?`, id); err != nil {
+		return e, err
+	}
+	return e, tx.Commit()
+}
+
+// rejectPending deletes pending entry id and returns its author's name.
+func (a *App) rejectPending(id int64) (string, error) {
+	var username string
+	err := a.db.QueryRow(`SELECT u.username FROM pending_entries p JOIN users u ON u.id = p.user_id WHERE p.id = ?`, id).Scan(&username)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", errPendingNotFound
+	}
+	if err != nil {
+		return "", err
+	}
+	res, err := a.db.Exec(`DELETE FROM pending_entries WHERE id = ?`, id)
+	if err != nil {
+		return "", err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return "", errPendingNotFound
+	}
+	return username, nil
+}
+
+// handleAdminPending serves GET /api/admin/pending: every entry awaiting
+// approval.
+func (a *App) handleAdminPending(w http.ResponseWriter, r *http.Request, u AuthedUser) {
+	if r.Method != http.MethodGet {
+		jsonErr(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	items, err := a.listPending(0)
+	if err != nil {
+		jsonErr(w, http.StatusInternalServerError, "failed to query pending entries")
+		return
+	}
+	jsonOut(w, http.StatusOK, map[string]any{"pending": items})
+}
+
+// handleMyPending serves GET /api/me/pending: the caller's own entries
+// awaiting approval.
+func (a *App) handleMyPending(w http.ResponseWriter, r *http.Request, u AuthedUser) {
+	if r.Method != http.MethodGet {
+		jsonErr(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	items, err := a.listPending(u.ID)
+	if err != nil {
+		jsonErr(w, http.StatusInternalServerError, "failed to query pending entries")
+		return
+	}
+	jsonOut(w, http.StatusOK, map[string]any{"pending": items})
+}
+
+// handleApprovePending serves POST /api/admin/pending/{id}/approve.
+func (a *App) handleApprovePending(w http.ResponseWriter, r *http.Request, u AuthedUser) {
+	if r.Method != http.MethodPost {
+		jsonErr(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil || id <= 0 {
+		jsonErr(w, http.StatusBadRequest, "invalid pending id")
+		return
+	}
+	e, err := a.approvePending(id)
+	if errors.Is(err, errPendingNotFound) {
+		jsonErr(w, http.StatusNotFound, err.Error())
+		return
+	}
+	if err != nil {
+		a.logger.Printf("event=approve_entry_failed pending_id=%d err=%v", id, err)
+		jsonErr(w, http.StatusInternalServerError, "failed to approve entry")
+		return
+	}
+	_ = a.logUserAction(u, "approve_entry", fmt.Sprintf("pending_id=%d entry_id=%d author=%s", id, e.ID, e.Author.Username))
+	// The side effects of afterEntryCreated, minus presence: the author was
+	// not active now.
+	a.recordEntryLinks(e.ID, e.Content)
+	a.fireKeywordAlerts(e.Author, e.ID, e.Content)
+	a.notifyMentions(e.Author, e.ID, e.Content)
+	go a.enrichEntryIssues(e.ID, e.Content)
+	a.publishLive(liveEvent{Type: "entry_created", Day: e.CreatedAt[:10], EntryID: e.ID, User: e.Author.Username})
+	jsonOut(w, http.StatusOK, map[string]any{"id": e.ID, "status": "approved", "created_at": e.CreatedAt, "url": a.entryURL(e.ID, e.CreatedAt)})
+}
+
+// handleRejectPending serves POST /api/admin/pending/{id}/reject with an
+// optional {"reason": "..."} body, which is kept in the audit log only.
+func (a *App) handleRejectPending(w http.ResponseWriter, r *http.Request, u AuthedUser) {
+	if r.Method != http.MethodPost {
+		jsonErr(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil || id <= 0 {
+		jsonErr(w, http.StatusBadRequest, "invalid pending id")
+		return
+	}
+	var req struct {
+		Reason string `json:"reason"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && !errors.Is(err, io.EOF) {
+		jsonErr(w, http.StatusBadRequest, "invalid json")
+		return
+	}
+	author, err := a.rejectPending(id)
+	if errors.Is(err, errPendingNotFound) {
+		jsonErr(w, http.StatusNotFound, err.Error())
+		return
+	}
+	if err != nil {
+		jsonErr(w, http.StatusInternalServerError, "failed to reject entry")
+		return
+	}
+	_ = a.logUserAction(u, "reject_entry", fmt.Sprintf("pending_id=%d author=%s reason=%q", id, author, strings.TrimSpace(req.Reason)))
+	jsonOut(w, http.StatusOK, map[string]any{"id": id, "status": "rejected"})
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestContributorApproval(t *testing.T) {
+	app := newTestApp(t)
+	h := newTestMux(app)
+	createUser(t, app, "root", "PUDAPPROVE1")
+	createUser(t, app, "intern", "PUDAPPROVE2")
+	if _, err := app.db.Exec(`UPDATE users SET role = 'admin' WHERE username = 'root'`); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := app.db.Exec(`UPDATE users SET role = ? WHERE username = 'intern'`, roleContributor); err != nil {
+		t.Fatal(err)
+	}
+	do := func(method, path string, body any, token string) *httptest.ResponseRecorder {
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, authedReq(t, method, path, body, token))
+		return rr
+	}
+	listed := func() int {
+		rr := do(http.MethodGet, "/api/entries", nil, "PUDAPPROVE1")
+		var resp struct {
+			Entries []map[string]any `json:"entries"`
+		}
+		if err := json.Unmarshal(rr.Body.Bytes(), &resp); err != nil {
+			t.Fatalf("list: %d %s", rr.Code, rr.Body.String())
+		}
+		return len(resp.Entries)
+	}
+	submit := func(content string) int64 {
+		rr := do(http.MethodPost, "/api/entries", map[string]string{"content": content}, "PUDAPPROVE2")
+		var resp struct {
+			PendingID int64  `json:"pending_id"`
+			Status    string `json:"status"`
+		}
+		if err := json.Unmarshal(rr.Body.Bytes(), &resp); err != nil || rr.Code != http.StatusAccepted || resp.Status != "pending" {
+			t.Fatalf("submit: %d %s", rr.Code, rr.Body.String())
+		}
+		return resp.PendingID
+	}
+
+	first := submit("fixed the flaky deploy script")
+	second := submit("lunch")
+	if n := listed(); n != 0 {
+		t.Fatalf("pending entries listed: %d", n)
+	}
+	if rr := do(http.MethodGet, "/api/admin/pending", nil, "PUDAPPROVE2"); rr.Code != http.StatusForbidden {
+		t.Fatalf("contributor listing the queue: %d", rr.Code)
+	}
+	if rr := do(http.MethodPost, fmt.Sprintf("/api/admin/pending/%d/approve", first), nil, "PUDAPPROVE2"); rr.Code != http.StatusForbidden {
+		t.Fatalf("contributor approving: %d", rr.Code)
+	}
+	rr := do(http.MethodGet, "/api/me/pending", nil, "PUDAPPROVE2")
+	var mine struct {
+		Pending []pendingEntry `json:"pending"`
+	}
+	if err := json.Unmarshal(rr.Body.Bytes(), &mine); err != nil || len(mine.Pending) != 2 || mine.Pending[0].ID != first {
+		t.Fatalf("own pending: %d %s", rr.Code, rr.Body.String())
+	}
+
+	rr = do(http.MethodPost, fmt.Sprintf("/api/admin/pending/%d/approve", first), nil, "PUDAPPROVE1")
+	var live struct {
+		ID int64 `json:"id"`
+	}
+	if err := json.Unmarshal(rr.Body.Bytes(), &live); err != nil || rr.Code != http.StatusOK || live.ID == 0 {
+		t.Fatalf("approve: %d %s", rr.Code, rr.Body.String())
+	}
+	if rr := do(http.MethodPost, fmt.Sprintf("/api/admin/pending/%d/approve", first), nil, "PUDAPPROVE1"); rr.Code != http.StatusNotFound {
+		t.Fatalf("approve twice: %d", rr.Code)
+	}
+	if rr := do(http.MethodPost, fmt.Sprintf("/api/admin/pending/%d/reject", second), map[string]string{"reason": "not work"}, "PUDAPPROVE1"); rr.Code != http.StatusOK {
+		t.Fatalf("reject: %d %s", rr.Code, rr.Body.String())
+	}
+	if n := listed(); n != 1 {
+		t.Fatalf("entries after review: %d, want 1", n)
+	}
+	var author string
+	if err := app.db.QueryRow(`SELECT u.username FROM entries e JOIN users u ON u.id = e.user_id WHERE e.id = ?`, live.ID).Scan(&author); err != nil || author != "intern" {
+		t.Fatalf("approved entry author: %q %v", author, err)
+	}
+
+	// Approved content cannot be rewritten by its contributor; a moderator
+	// still can.
+	entryPath := fmt.Sprintf("/api/entries/%d", live.ID)
+	for _, method := range []string{http.MethodPut, http.MethodPatch} {
+		if rr := do(method, entryPath, map[string]string{"content": "unreviewed rewrite"}, "PUDAPPROVE2"); rr.Code != http.StatusForbidden {
+			t.Fatalf("contributor %s of an approved entry: %d %s", method, rr.Code, rr.Body.String())
+		}
+	}
+	var content string
+	if err := app.db.QueryRow(`SELECT content FROM entries WHERE id = ?`, live.ID).Scan(&content); err != nil || content != "fixed the flaky deploy script" {
+		t.Fatalf("approved entry content after contributor edits: %q %v", content, err)
+	}
+	if rr := do(http.MethodPatch, entryPath, map[string]string{"content": "fixed the flaky deploy script (reviewed)"}, "PUDAPPROVE1"); rr.Code != http.StatusOK {
+		t.Fatalf("moderator edit: %d %s", rr.Code, rr.Body.String())
+	}
+
+	// A day compacted while the entry waited: it is stamped with the
+	// approval time instead of landing in the finished day.
+	third := submit("late note")
+	if _, err := app.db.Exec(`UPDATE pending_entries SET created_at = '2026-02-17T10:00:00Z' WHERE id = ?`, third); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := app.db.Exec(`INSERT INTO compactions(day, ran_at, phase) VALUES('2026-02-17', ?, 'done')`, nowUTC()); err != nil {
+		t.Fatal(err)
+	}
+	rr = do(http.MethodPost, fmt.Sprintf("/api/admin/pending/%d/approve", third), nil, "PUDAPPROVE1")
+	var approved struct {
+		CreatedAt string `json:"created_at"`
+	}
+	if err := json.Unmarshal(rr.Body.Bytes(), &approved); err != nil || strings.HasPrefix(approved.CreatedAt, "2026-02-17") {
+		t.Fatalf("approve into compacted day: %d %s", rr.Code, rr.Body.String())
+	}
+
+	var logged int
+	if err := app.db.QueryRow(`SELECT COUNT(*) FROM action_logs WHERE action IN ('submit_entry', 'approve_entry', 'reject_entry')`).Scan(&logged); err != nil || logged != 6 {
+		t.Fatalf("approval audit rows: %d %v", logged, err)
+	}
+}
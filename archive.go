@@ -0,0 +1,105 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Archive mode: with the compaction_mode setting at "archive", the verify
+// phase copies a day's source entries into entries_archive before removing
+// them from entries, so the daily compact still replaces them everywhere
+// (lists, search, exports) but the per-entry rows stay available for later
+// analysis through /api/archive. "delete" (the default) drops them as before.
+
+const (
+	compactionModeDelete  = "delete"
+	compactionModeArchive = "archive"
+)
+
+// archiveSources copies the live source entries of one compact part (userID 0
+// for the whole day) into entries_archive, tagged with the compact that
+// replaced them. The caller deletes them.
+func archiveSources(tx execer, day string, compactID, userID int64) (int64, error) {
+	merged, args := partSources(day, userID)
+	res, err := tx.Exec(`
+INSERT OR IGNORE INTO entries_archive(id, user_id, content, category, source, created_at, edited_at, compact_id, archived_at)
+SELECT id, user_id, content, category, source, created_at, edited_at, ?, ?
+FROM entries
+WHERE id IN `+merged, append([]any{compactID, nowUTC()}, args...)...)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+type archivedEntry struct {
+	entryRow
+	CompactID  int64  `json:"compact_id"`
+	ArchivedAt string `json:"archived_at"`
+}
+
+// handleArchive serves GET /api/archive?day=YYYY-MM-DD[&q=] with the original
+// entries of a day compacted in archive mode, oldest first.
+func (a *App) handleArchive(w http.ResponseWriter, r *http.Request, u AuthedUser) {
+	if r.Method != http.MethodGet {
+		jsonErr(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	day := strings.TrimSpace(r.URL.Query().Get("day"))
+	if _, err := time.Parse("2006-01-02", day); err != nil {
+		jsonErr(w, http.StatusBadRequest, "day must be YYYY-MM-DD")
+		return
+	}
+	anonymous, err := a.wantAnonymous(r)
+	if err != nil {
+		jsonErr(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	where := `date(e.created_at) = ?`
+	args := []any{day}
+	q := strings.TrimSpace(r.URL.Query().Get("q"))
+	if q != "" {
+		where += ` AND e.content LIKE ? ESCAPE '\'`
+		args = append(args, "%"+likePrefix(q))
+	}
+	rows, err := a.db.Query(`
+SELECT e.id,
+       u.username,
+       u.kind,
+       e.category,
+       e.content,
+       e.created_at,
+       COALESCE(e.edited_at, ''),
+       e.compact_id,
+       e.archived_at
+FROM entries_archive e
+JOIN users u ON u.id = e.user_id
+WHERE `+where+`
+ORDER BY e.created_at ASC, e.id ASC`, args...)
+	if err != nil {
+		jsonErr(w, http.StatusInternalServerError, "failed to query archive")
+		return
+	}
+	defer rows.Close()
+	entries := []archivedEntry{}
+	for rows.Next() {
+		var e archivedEntry
+		if err := rows.Scan(&e.ID, &e.User, &e.UserKind, &e.Category, &e.Content, &e.CreatedAt, &e.EditedAt, &e.CompactID, &e.ArchivedAt); err != nil {
+			jsonErr(w, http.StatusInternalServerError, "failed to parse archive")
+			return
+		}
+		e.EntryType = "normal"
+		if anonymous {
+			e.entryRow = anonymizeEntry(e.entryRow)
+		}
+		entries = append(entries, e)
+	}
+	if err := rows.Err(); err != nil {
+		jsonErr(w, http.StatusInternalServerError, "failed to query archive")
+		return
+	}
+	_ = a.logUserAction(u, "list_archive", fmt.Sprintf("day=%s count=%d", day, len(entries)))
+	jsonOut(w, http.StatusOK, map[string]any{"day": day, "count": len(entries), "entries": entries})
+}
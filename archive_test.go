@@ -0,0 +1,63 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCompactionArchiveMode(t *testing.T) {
+	app := newTestApp(t)
+	h := newTestMux(app)
+	createUser(t, app, "alice", "PUDARCHIVE1")
+	bad := "shred"
+	if _, _, err := app.updateSettings(settingsPatch{CompactionMode: &bad}, "admin"); err == nil {
+		t.Fatalf("expected invalid compaction_mode to be rejected")
+	}
+	mode := compactionModeArchive
+	if _, _, err := app.updateSettings(settingsPatch{CompactionMode: &mode}, "admin"); err != nil {
+		t.Fatalf("updateSettings: %v", err)
+	}
+	for _, content := range []string{"shipped the parser", "reviewed #42"} {
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, authedReq(t, http.MethodPost, "/api/entries", map[string]string{"content": content}, "PUDARCHIVE1"))
+		if rr.Code != http.StatusCreated {
+			t.Fatalf("post: %d %s", rr.Code, rr.Body.String())
+		}
+	}
+	day := time.Now().UTC().Format("2006-01-02")
+	if err := app.compactDay(day); err != nil {
+		t.Fatalf("compactDay: %v", err)
+	}
+	var live int
+	if err := app.db.QueryRow(`SELECT COUNT(*) FROM entries WHERE entry_type = 'normal'`).Scan(&live); err != nil || live != 0 {
+		t.Fatalf("expected sources removed from entries, got %d err=%v", live, err)
+	}
+
+	get := func(query string) (int, []archivedEntry) {
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, authedReq(t, http.MethodGet, "/api/archive?"+query, nil, "PUDARCHIVE1"))
+		var body struct {
+			Entries []archivedEntry `json:"entries"`
+		}
+		_ = json.Unmarshal(rr.Body.Bytes(), &body)
+		return rr.Code, body.Entries
+	}
+	code, got := get("day=" + day)
+	if code != http.StatusOK || len(got) != 2 || got[0].Content != "shipped the parser" || got[0].User != "alice" || got[0].CompactID == 0 {
+		t.Fatalf("archive: %d %+v", code, got)
+	}
+	if code, got := get("day=" + day + "&q=%2342"); code != http.StatusOK || len(got) != 1 || got[0].Content != "reviewed #42" {
+		t.Fatalf("archive search: %d %+v", code, got)
+	}
+	if code, _ := get("day=today"); code != http.StatusBadRequest {
+		t.Fatalf("expected 400 for bad day, got %d", code)
+	}
+	var detail string
+	if err := app.db.QueryRow(`SELECT detail FROM compaction_journal WHERE day = ? AND step = ?`, day, journalDone).Scan(&detail); err != nil || !strings.Contains(detail, "2 source entries archived") {
+		t.Fatalf("journal: %q err=%v", detail, err)
+	}
+}
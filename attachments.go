@@ -0,0 +1,294 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	defaultAttachmentMaxBytes = 10 << 20
+	// blobGCGrace keeps fresh unreferenced blobs, so gc never races an
+	// upload that stored the blob but has not linked it to its entry yet.
+	blobGCGrace = time.Hour
+)
+
+var sha256HexRe = regexp.MustCompile(`^[0-9a-f]{64}$`)
+
+// attachmentRef is an attachment as listed on an entry.
+type attachmentRef struct {
+	SHA256      string `json:"sha256"`
+	Filename    string `json:"filename"`
+	Size        int64  `json:"size"`
+	ContentType string `json:"content_type"`
+}
+
+// attachmentKey is the content-addressed blob key for a digest. The two-char
+// fan-out keeps filesystem stores from growing one huge directory.
+func attachmentKey(sum string) string {
+	return "attachments/" + sum[:2] + "/" + sum
+}
+
+// storeAttachment stores data once per digest and links it to entryID.
+// Reference counts on blobs are kept by triggers on entry_attachments.
+func (a *App) storeAttachment(ctx context.Context, entryID int64, filename, contentType string, data []byte) (attachmentRef, error) {
+	sum := sha256Hex(data)
+	ref := attachmentRef{SHA256: sum, Filename: filename, Size: int64(len(data)), ContentType: contentType}
+	var n int
+	if err := a.db.QueryRow(`SELECT COUNT(*) FROM blobs WHERE sha256 = ?`, sum).Scan(&n); err != nil {
+		return ref, err
+	}
+	if n == 0 {
+		if err := a.blobs.Put(ctx, attachmentKey(sum), data, contentType); err != nil {
+			return ref, err
+		}
+		if _, err := a.db.Exec(`INSERT OR IGNORE INTO blobs(sha256, size, content_type, ref_count, created_at) VALUES(?, ?, ?, 0, ?)`,
+			sum, len(data), contentType, nowUTC()); err != nil {
+			return ref, err
+		}
+	}
+	_, err := a.db.Exec(`INSERT OR IGNORE INTO entry_attachments(entry_id, sha256, filename, created_at) VALUES(?, ?, ?, ?)`,
+		entryID, sum, filename, nowUTC())
+	return ref, err
+}
+
+func (a *App) entryAttachments(entryID int64) ([]attachmentRef, error) {
+	rows, err := a.db.Query(`
+SELECT b.sha256, ea.filename, b.size, b.content_type
+FROM entry_attachments ea
+JOIN blobs b ON b.sha256 = ea.sha256
+WHERE ea.entry_id = ?
+ORDER BY ea.created_at ASC, ea.filename ASC`, entryID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	out := []attachmentRef{}
+	for rows.Next() {
+		var r attachmentRef
+		if err := rows.Scan(&r.SHA256, &r.Filename, &r.Size, &r.ContentType); err != nil {
+			return nil, err
+		}
+		out = append(out, r)
+	}
+	return out, rows.Err()
+}
+
+// handleEntryAttachments uploads a file (multipart field "file") to one of
+// the caller's own entries.
+func (a *App) handleEntryAttachments(w http.ResponseWriter, r *http.Request, u AuthedUser) {
+	if a.blobs == nil {
+		jsonErr(w, http.StatusNotFound, "attachments are not configured")
+		return
+	}
+	if r.Method != http.MethodPost {
+		jsonErr(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil || id <= 0 {
+		jsonErr(w, http.StatusBadRequest, "invalid entry id")
+		return
+	}
+	owner, entryType, _, deletedAt, err := a.ownEntry(id)
+	if errors.Is(err, sql.ErrNoRows) || (err == nil && deletedAt.Valid) {
+		jsonErr(w, http.StatusNotFound, "entry not found")
+		return
+	}
+	if err != nil {
+		jsonErr(w, http.StatusInternalServerError, "failed to query entry")
+		return
+	}
+	if owner != u.ID || entryType != "normal" {
+		jsonErr(w, http.StatusForbidden, "only the author can attach files to this entry")
+		return
+	}
+
+	maxBytes := a.attachmentMaxBytes
+	if maxBytes <= 0 {
+		maxBytes = defaultAttachmentMaxBytes
+	}
+	r.Body = http.MaxBytesReader(w, r.Body, maxBytes+(1<<20))
+	file, header, err := r.FormFile("file")
+	if err != nil {
+		jsonErr(w, http.StatusBadRequest, "multipart field 'file' is required")
+		return
+	}
+	defer file.Close()
+	data, err := io.ReadAll(io.LimitReader(file, maxBytes+1))
+	if err != nil {
+		jsonErr(w, http.StatusBadRequest, "failed to read upload")
+		return
+	}
+	if int64(len(data)) > maxBytes {
+		jsonErr(w, http.StatusRequestEntityTooLarge, "attachment too large")
+		return
+	}
+	if len(data) == 0 {
+		jsonErr(w, http.StatusBadRequest, "attachment is empty")
+		return
+	}
+	filename := filepath.Base(strings.ReplaceAll(header.Filename, `\`, "/"))
+	contentType := header.Header.Get("Content-Type")
+	if mt, _, err := mime.ParseMediaType(contentType); err != nil || mt == "application/octet-stream" {
+		contentType = http.DetectContentType(data)
+	}
+
+	ref, err := a.storeAttachment(r.Context(), id, filename, contentType, data)
+	if err != nil {
+		a.logger.Printf("event=attachment_store_failed entry_id=%d err=%v", id, err)
+		jsonErr(w, http.StatusInternalServerError, "failed to store attachment")
+		return
+	}
+	_ = a.logUserAction(u, "attach_file", fmt.Sprintf("entry_id=%d sha256=%s size=%d", id, ref.SHA256, ref.Size))
+	jsonOut(w, http.StatusCreated, ref)
+}
+
+// handleAttachment streams a blob by digest. Only blobs still referenced by a
+// live entry are served.
+func (a *App) handleAttachment(w http.ResponseWriter, r *http.Request, u AuthedUser) {
+	if a.blobs == nil {
+		jsonErr(w, http.StatusNotFound, "attachments are not configured")
+		return
+	}
+	if r.Method != http.MethodGet {
+		jsonErr(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	sum := r.PathValue("sha256")
+	if !sha256HexRe.MatchString(sum) {
+		jsonErr(w, http.StatusBadRequest, "invalid digest")
+		return
+	}
+	var contentType string
+	err := a.db.QueryRow(`
+SELECT b.content_type FROM blobs b
+WHERE b.sha256 = ? AND EXISTS (
+	SELECT 1 FROM entry_attachments ea JOIN entries e ON e.id = ea.entry_id
+	WHERE ea.sha256 = b.sha256 AND e.deleted_at IS NULL)`, sum).Scan(&contentType)
+	if errors.Is(err, sql.ErrNoRows) {
+		jsonErr(w, http.StatusNotFound, "attachment not found")
+		return
+	}
+	if err != nil {
+		jsonErr(w, http.StatusInternalServerError, "failed to query attachment")
+		return
+	}
+	rc, err := a.blobs.Get(r.Context(), attachmentKey(sum))
+	if err != nil {
+		a.logger.Printf("event=attachment_read_failed sha256=%s err=%v", sum, err)
+		jsonErr(w, http.StatusBadGateway, "failed to read attachment")
+		return
+	}
+	defer rc.Close()
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Cache-Control", "private, max-age=31536000, immutable")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	_, _ = io.Copy(w, rc)
+}
+
+// gcBlobs deletes unreferenced blobs older than grace from the store and the
+// blobs table. It returns the number of blobs and bytes (to be) reclaimed.
+func (a *App) gcBlobs(ctx context.Context, store BlobStore, grace time.Duration, dryRun bool) (int, int64, error) {
+	cutoff := time.Now().UTC().Add(-grace).Format(time.RFC3339)
+	rows, err := a.db.Query(`SELECT sha256, size FROM blobs WHERE ref_count <= 0 AND created_at <= ? ORDER BY sha256`, cutoff)
+	if err != nil {
+		return 0, 0, err
+	}
+	type blob struct {
+		sum  string
+		size int64
+	}
+	var victims []blob
+	for rows.Next() {
+		var b blob
+		if err := rows.Scan(&b.sum, &b.size); err != nil {
+			_ = rows.Close()
+			return 0, 0, err
+		}
+		victims = append(victims, b)
+	}
+	if err := rows.Err(); err != nil {
+		_ = rows.Close()
+		return 0, 0, err
+	}
+	_ = rows.Close()
+
+	count, bytes := 0, int64(0)
+	for _, b := range victims {
+		if !dryRun {
+			// Drop the row first (only if still unreferenced) so a concurrent
+			// upload of the same content re-stores the object.
+			res, err := a.db.Exec(`DELETE FROM blobs WHERE sha256 = ? AND ref_count <= 0`, b.sum)
+			if err != nil {
+				return count, bytes, err
+			}
+			if n, _ := res.RowsAffected(); n == 0 {
+				continue
+			}
+			if err := store.Delete(ctx, attachmentKey(b.sum)); err != nil {
+				return count, bytes, err
+			}
+		}
+		count++
+		bytes += b.size
+	}
+	return count, bytes, nil
+}
+
+func runAdminBlobGC(args []string) error {
+	fs := flag.NewFlagSet("admin blob-gc", flag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s admin blob-gc --store <url> [options]\n\n", binName())
+		fmt.Fprintln(fs.Output(), "Deletes attachment blobs no entry references anymore. Use the same --store")
+		fmt.Fprintln(fs.Output(), "settings the server runs with.")
+		fmt.Fprintln(fs.Output())
+		fmt.Fprintln(fs.Output(), "Options:")
+		fs.PrintDefaults()
+	}
+	store := addBlobFlags(fs)
+	grace := fs.Duration("grace", blobGCGrace, "keep unreferenced blobs younger than this")
+	dryRun := fs.Bool("dry-run", false, "report what would be deleted without deleting")
+	dbPath := fs.String("db", defaultDBPath, "sqlite db path")
+	logPath := fs.String("log", defaultLogPath, "log target path ('-' for stdout only)")
+	if err := fs.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return nil
+		}
+		return err
+	}
+	blobs, err := openBlobStore(*store)
+	if err != nil {
+		return err
+	}
+
+	app, closeApp, err := openAdminApp(*dbPath, *logPath)
+	if err != nil {
+		return err
+	}
+	defer closeApp()
+
+	n, size, err := app.gcBlobs(context.Background(), blobs, *grace, *dryRun)
+	if err != nil {
+		return err
+	}
+	if !*dryRun {
+		_ = app.logAction("admin_cli", "admin", "blob_gc", fmt.Sprintf("deleted=%d bytes=%d", n, size))
+	}
+	verb := "deleted"
+	if *dryRun {
+		verb = "would delete"
+	}
+	fmt.Printf("%s %d blob(s), %d bytes\n", verb, n, size)
+	return nil
+}
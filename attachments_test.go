@@ -0,0 +1,107 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestAPIAttachmentsDedupAndGC(t *testing.T) {
+	app := newTestApp(t)
+	storeDir := t.TempDir()
+	app.blobs = &FSBlobStore{Root: storeDir}
+	h := newTestMux(app)
+	createUser(t, app, "alice", "PUDABCDEF12")
+
+	post := func(content string) int64 {
+		t.Helper()
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, authedReq(t, http.MethodPost, "/api/entries", map[string]string{"content": content}, "PUDABCDEF12"))
+		var out struct {
+			ID int64 `json:"id"`
+		}
+		if rr.Code != http.StatusCreated || json.Unmarshal(rr.Body.Bytes(), &out) != nil {
+			t.Fatalf("create entry: %d %s", rr.Code, rr.Body.String())
+		}
+		return out.ID
+	}
+	screenshot := []byte("\x89PNG\r\n\x1a\nnot really a png")
+	attach := func(id int64) attachmentRef {
+		t.Helper()
+		var buf bytes.Buffer
+		mw := multipart.NewWriter(&buf)
+		fw, _ := mw.CreateFormFile("file", "screenshot.png")
+		_, _ = fw.Write(screenshot)
+		_ = mw.Close()
+		req := httptest.NewRequest(http.MethodPost, fmt.Sprintf("/api/entries/%d/attachments", id), &buf)
+		req.Header.Set("Content-Type", mw.FormDataContentType())
+		req.Header.Set("Authorization", "Bearer PUDABCDEF12")
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, req)
+		var ref attachmentRef
+		if rr.Code != http.StatusCreated || json.Unmarshal(rr.Body.Bytes(), &ref) != nil {
+			t.Fatalf("attach: %d %s", rr.Code, rr.Body.String())
+		}
+		return ref
+	}
+	refCount := func(sum string) int {
+		t.Helper()
+		var n int
+		if err := app.db.QueryRow(`SELECT ref_count FROM blobs WHERE sha256 = ?`, sum).Scan(&n); err != nil {
+			t.Fatalf("ref_count: %v", err)
+		}
+		return n
+	}
+
+	first, second := post("broken layout"), post("still broken")
+	ref := attach(first)
+	if again := attach(second); again.SHA256 != ref.SHA256 {
+		t.Fatalf("expected same digest, got %s and %s", ref.SHA256, again.SHA256)
+	}
+	if n := refCount(ref.SHA256); n != 2 {
+		t.Fatalf("expected ref_count 2, got %d", n)
+	}
+	files, _ := filepath.Glob(filepath.Join(storeDir, "attachments", "*", "*"))
+	if len(files) != 1 {
+		t.Fatalf("expected one stored object, got %v", files)
+	}
+
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, authedReq(t, http.MethodGet, "/api/attachments/"+ref.SHA256, nil, "PUDABCDEF12"))
+	if rr.Code != http.StatusOK || !bytes.Equal(rr.Body.Bytes(), screenshot) || rr.Header().Get("Content-Type") != "image/png" {
+		t.Fatalf("download: %d %q %s", rr.Code, rr.Header().Get("Content-Type"), rr.Body.String())
+	}
+
+	for _, id := range []int64{first, second} {
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, authedReq(t, http.MethodDelete, fmt.Sprintf("/api/entries/%d", id), nil, "PUDABCDEF12"))
+		if rr.Code != http.StatusOK {
+			t.Fatalf("trash %d: %d", id, rr.Code)
+		}
+	}
+	if _, err := app.purgeTrash(time.Now().Add(app.trashRetention() + time.Hour)); err != nil {
+		t.Fatalf("purgeTrash: %v", err)
+	}
+	if n := refCount(ref.SHA256); n != 0 {
+		t.Fatalf("expected ref_count 0 after purge, got %d", n)
+	}
+
+	ctx := context.Background()
+	if n, _, err := app.gcBlobs(ctx, app.blobs, time.Hour, false); err != nil || n != 0 {
+		t.Fatalf("expected grace period to keep fresh blob, n=%d err=%v", n, err)
+	}
+	n, size, err := app.gcBlobs(ctx, app.blobs, 0, false)
+	if err != nil || n != 1 || size != int64(len(screenshot)) {
+		t.Fatalf("expected 1 blob collected, n=%d size=%d err=%v", n, size, err)
+	}
+	if files, _ := filepath.Glob(filepath.Join(storeDir, "attachments", "*", "*")); len(files) != 0 {
+		t.Fatalf("expected object deleted, got %v", files)
+	}
+}
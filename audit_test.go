@@ -0,0 +1,172 @@
+package main
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLegalHoldBlocksCompaction(t *testing.T) {
+	app := newTestApp(t)
+	createUser(t, app, "kim", "PUDHOLDAAA2")
+	day := time.Now().UTC().Format("2006-01-02")
+	if _, err := app.insertEntry(1, "evidence", "", sourceAPI, nowUTC()); err != nil {
+		t.Fatalf("insertEntry: %v", err)
+	}
+	if _, err := app.db.Exec(`INSERT INTO legal_holds(start_day, end_day, reason, created_at) VALUES(?, ?, 'incident 42', ?)`, day, day, nowUTC()); err != nil {
+		t.Fatalf("insert hold: %v", err)
+	}
+
+	if err := app.compactDay(day); !errors.Is(err, errDayOnHold) {
+		t.Fatalf("expected errDayOnHold, got %v", err)
+	}
+	var n int
+	if err := app.db.QueryRow(`SELECT COUNT(*) FROM entries WHERE entry_type = 'normal'`).Scan(&n); err != nil {
+		t.Fatalf("count entries: %v", err)
+	}
+	if n != 1 {
+		t.Fatalf("expected held entry to survive, got %d normal entries", n)
+	}
+}
+
+func TestAuditExportVerify(t *testing.T) {
+	app := newTestApp(t)
+	for _, action := range []string{"create_user", "create_entry", "list_entries"} {
+		if err := app.logAction("admin_cli", "admin", action, "-"); err != nil {
+			t.Fatalf("logAction: %v", err)
+		}
+	}
+	day := time.Now().UTC().Format("2006-01-02")
+	key := []byte("s3cret")
+
+	var buf bytes.Buffer
+	tr, err := app.writeAuditExport(&buf, day, day, key, nil)
+	if err != nil {
+		t.Fatalf("writeAuditExport: %v", err)
+	}
+	if tr.Records != 3 || tr.Signature == "" {
+		t.Fatalf("unexpected trailer: %+v", tr)
+	}
+	if _, err := verifyAuditExport(bytes.NewReader(buf.Bytes()), key, nil); err != nil {
+		t.Fatalf("verify untouched export: %v", err)
+	}
+
+	tampered := strings.Replace(buf.String(), `"action":"create_entry"`, `"action":"delete_entry"`, 1)
+	if _, err := verifyAuditExport(strings.NewReader(tampered), key, nil); err == nil {
+		t.Fatalf("expected tampered export to fail verification")
+	}
+	if _, err := verifyAuditExport(bytes.NewReader(buf.Bytes()), []byte("wrong"), nil); err == nil {
+		t.Fatalf("expected wrong key to fail verification")
+	}
+}
+
+func TestAuditChainDetectsTampering(t *testing.T) {
+	app := newTestApp(t)
+	for _, action := range []string{"create_user", "create_entry", "list_entries", "export_notes"} {
+		if err := app.logAction("admin_cli", "admin", action, "-"); err != nil {
+			t.Fatalf("logAction: %v", err)
+		}
+	}
+	n, problems, err := app.verifyAuditChain()
+	if err != nil || n != 4 || len(problems) != 0 {
+		t.Fatalf("expected clean chain of 4 rows, got n=%d problems=%+v err=%v", n, problems, err)
+	}
+
+	if _, err := app.db.Exec(`UPDATE action_logs SET action = 'delete_entry' WHERE action = 'create_entry'`); err != nil {
+		t.Fatalf("tamper: %v", err)
+	}
+	if _, problems, _ = app.verifyAuditChain(); len(problems) == 0 {
+		t.Fatalf("expected edited row to be reported")
+	}
+	if _, err := app.db.Exec(`UPDATE action_logs SET action = 'create_entry' WHERE action = 'delete_entry'`); err != nil {
+		t.Fatalf("restore: %v", err)
+	}
+
+	if _, err := app.db.Exec(`DELETE FROM action_logs WHERE action = 'list_entries'`); err != nil {
+		t.Fatalf("delete: %v", err)
+	}
+	_, problems, _ = app.verifyAuditChain()
+	if len(problems) == 0 || !strings.HasPrefix(problems[0].Reason, "gap") {
+		t.Fatalf("expected gap to be reported, got %+v", problems)
+	}
+}
+
+func TestAuditExportRetentionCertificate(t *testing.T) {
+	app := newTestApp(t)
+	for _, action := range []string{"create_user", "create_entry", "list_entries"} {
+		if err := app.logAction("admin_cli", "admin", action, "-"); err != nil {
+			t.Fatalf("logAction: %v", err)
+		}
+	}
+	dir := t.TempDir()
+	writeKey := func(name string) (string, string) {
+		pub, priv, err := ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			t.Fatal(err)
+		}
+		privDER, _ := x509.MarshalPKCS8PrivateKey(priv)
+		pubDER, _ := x509.MarshalPKIXPublicKey(pub)
+		privPath, pubPath := filepath.Join(dir, name+".pem"), filepath.Join(dir, name+".pub.pem")
+		if err := os.WriteFile(privPath, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: privDER}), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(pubPath, pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: pubDER}), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		return privPath, pubPath
+	}
+	privPath, pubPath := writeKey("audit")
+	_, otherPubPath := writeKey("other")
+	signer, err := readSigningKey(privPath)
+	if err != nil {
+		t.Fatalf("readSigningKey: %v", err)
+	}
+	pub, err := readVerifyKey(pubPath)
+	if err != nil {
+		t.Fatalf("readVerifyKey: %v", err)
+	}
+	other, err := readVerifyKey(otherPubPath)
+	if err != nil {
+		t.Fatalf("readVerifyKey: %v", err)
+	}
+	if _, err := readSigningKey(pubPath); err == nil {
+		t.Fatalf("expected a public key to be rejected as signing key")
+	}
+
+	day := time.Now().UTC().Format("2006-01-02")
+	var buf bytes.Buffer
+	tr, err := app.writeAuditExport(&buf, day, day, nil, signer)
+	if err != nil {
+		t.Fatalf("writeAuditExport: %v", err)
+	}
+	c := tr.Certificate
+	if c == nil || c.Records != 3 || c.FirstID != 1 || c.LastID != 3 || c.IDGaps != 0 || c.ChainHead != tr.ChainHead || c.LogHash == "" || tr.CertificateSignature == "" {
+		t.Fatalf("unexpected certificate: %+v", c)
+	}
+	if _, err := verifyAuditExport(bytes.NewReader(buf.Bytes()), nil, pub); err != nil {
+		t.Fatalf("verify certified export: %v", err)
+	}
+	if _, err := verifyAuditExport(bytes.NewReader(buf.Bytes()), nil, other); err == nil {
+		t.Fatalf("expected another key to fail verification")
+	}
+	forged := strings.Replace(buf.String(), `"issued_at":"`, `"issued_at":"1`, 1)
+	if _, err := verifyAuditExport(strings.NewReader(forged), nil, pub); err == nil {
+		t.Fatalf("expected an edited certificate to fail verification")
+	}
+
+	var plain bytes.Buffer
+	if _, err := app.writeAuditExport(&plain, day, day, nil, nil); err != nil {
+		t.Fatalf("writeAuditExport: %v", err)
+	}
+	if _, err := verifyAuditExport(bytes.NewReader(plain.Bytes()), nil, pub); err == nil {
+		t.Fatalf("expected an uncertified export to fail with --pubkey")
+	}
+}
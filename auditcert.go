@@ -0,0 +1,125 @@
+package main
+
+import (
+	"crypto/ed25519"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+)
+
+// Retention certificates: with --sign, an audit export's trailer carries a
+// certificate stating which action_logs rows the period held (id range, id
+// gaps, chain heads) and when it was issued, signed with an Ed25519 key.
+// Unlike the HMAC signature it verifies offline with only the public key, so
+// auditors can check an export without being trusted with a secret.
+
+const auditCertVersion = 1
+
+// retentionCertificate is the signed statement in an export's trailer. Field
+// order is the canonical serialization the signature covers.
+type retentionCertificate struct {
+	Version   int    `json:"version"`
+	Algorithm string `json:"algorithm"`
+	KeyID     string `json:"key_id"`
+	From      string `json:"from"`
+	To        string `json:"to"`
+	Records   int    `json:"records"`
+	FirstID   int64  `json:"first_id"`
+	LastID    int64  `json:"last_id"`
+	// IDGaps counts ids missing between FirstID and LastID; zero means no
+	// row of the period was removed from action_logs.
+	IDGaps int `json:"id_gaps"`
+	// ChainHead is the export's own chain head; LogHash is the stored
+	// action_logs.hash of LastID, tying the export to the live chain.
+	ChainHead string `json:"chain_head"`
+	LogHash   string `json:"log_hash"`
+	IssuedAt  string `json:"issued_at"`
+}
+
+// auditKeyID is a short fingerprint of a public key, printed next to
+// verification results so auditors can tell which key signed.
+func auditKeyID(pub ed25519.PublicKey) string {
+	sum := sha256.Sum256(pub)
+	return hex.EncodeToString(sum[:8])
+}
+
+func signCertificate(priv ed25519.PrivateKey, c retentionCertificate) (string, error) {
+	b, err := json.Marshal(c)
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(ed25519.Sign(priv, b)), nil
+}
+
+func verifyCertificate(pub ed25519.PublicKey, c retentionCertificate, sig string) error {
+	raw, err := hex.DecodeString(sig)
+	if err != nil {
+		return errors.New("certificate signature is not hex")
+	}
+	b, err := json.Marshal(c)
+	if err != nil {
+		return err
+	}
+	if c.KeyID != auditKeyID(pub) {
+		return fmt.Errorf("certificate was signed by key %s, not %s", c.KeyID, auditKeyID(pub))
+	}
+	if !ed25519.Verify(pub, b, raw) {
+		return errors.New("certificate signature mismatch")
+	}
+	return nil
+}
+
+// readSigningKey loads a PEM "PRIVATE KEY" (PKCS #8) Ed25519 key, as written
+// by `openssl genpkey -algorithm ed25519`.
+func readSigningKey(path string) (ed25519.PrivateKey, error) {
+	if path == "" {
+		return nil, nil
+	}
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(b)
+	if block == nil || block.Type != "PRIVATE KEY" {
+		return nil, fmt.Errorf("%s: want a PEM PRIVATE KEY block", path)
+	}
+	k, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	priv, ok := k.(ed25519.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("%s: not an Ed25519 key", path)
+	}
+	return priv, nil
+}
+
+// readVerifyKey loads a PEM "PUBLIC KEY" (PKIX) Ed25519 key, as written by
+// `openssl pkey -pubout`.
+func readVerifyKey(path string) (ed25519.PublicKey, error) {
+	if path == "" {
+		return nil, nil
+	}
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(b)
+	if block == nil || block.Type != "PUBLIC KEY" {
+		return nil, fmt.Errorf("%s: want a PEM PUBLIC KEY block", path)
+	}
+	k, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	pub, ok := k.(ed25519.PublicKey)
+	if !ok {
+		return nil, fmt.Errorf("%s: not an Ed25519 key", path)
+	}
+	return pub, nil
+}
@@ -0,0 +1,359 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Audit forwarding tails action_logs by id, one persisted cursor per sink,
+// so rows written by the admin CLI are forwarded too and nothing is lost
+// across restarts or while a sink is down. Delivery is at-least-once: a
+// batch that fails part-way is sent again from the cursor.
+
+const (
+	auditForwardInterval = 2 * time.Second
+	auditForwardBatch    = 200
+	// auditForwardMaxBatches bounds one tick so a large backlog does not
+	// starve the next health report.
+	auditForwardMaxBatches = 25
+	subsystemAuditForward  = "audit_forward"
+
+	// syslogPRI is facility 13 ("log audit") at severity 5
+	// ("notice"): PRI = 13*8 + 5.
+	syslogPRI     = 13*8 + 5
+	syslogAppName = "team-dev-log"
+	syslogSDID    = "devlog@32473"
+)
+
+// auditSink delivers a batch of audit rows to one external system.
+type auditSink interface {
+	name() string
+	send(ctx context.Context, lines []auditLine) error
+}
+
+// auditTarget is a sink plus the action patterns it receives.
+type auditTarget struct {
+	sink      auditSink
+	actions   []string
+	forwarded atomic.Int64
+	failed    atomic.Int64
+	// mu serializes passes, since a watchdog restart can overlap a wedged one.
+	mu sync.Mutex
+}
+
+// parseAuditTargets builds the sinks configured by the --audit-* flags.
+func parseAuditTargets(syslogURL, syslogActions, httpURL, httpTokenFile, httpActions string) ([]*auditTarget, error) {
+	var out []*auditTarget
+	if strings.TrimSpace(syslogURL) != "" {
+		sink, err := parseSyslogTarget(syslogURL)
+		if err != nil {
+			return nil, err
+		}
+		actions, err := parseActionPatterns(syslogActions)
+		if err != nil {
+			return nil, fmt.Errorf("--audit-syslog-actions: %w", err)
+		}
+		out = append(out, &auditTarget{sink: sink, actions: actions})
+	}
+	if strings.TrimSpace(httpURL) != "" {
+		u, err := url.Parse(strings.TrimSpace(httpURL))
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return nil, fmt.Errorf("invalid --audit-http %q (want an http(s) URL)", httpURL)
+		}
+		token, err := readKeyFile(httpTokenFile)
+		if err != nil {
+			return nil, err
+		}
+		actions, err := parseActionPatterns(httpActions)
+		if err != nil {
+			return nil, fmt.Errorf("--audit-http-actions: %w", err)
+		}
+		out = append(out, &auditTarget{sink: &httpAuditSink{url: u.String(), token: string(token)}, actions: actions})
+	}
+	return out, nil
+}
+
+// parseActionPatterns parses a comma-separated list of action globs
+// ("impersonate,update_settings,*_user"); empty means every action.
+func parseActionPatterns(v string) ([]string, error) {
+	patterns := splitList(v)
+	for _, p := range patterns {
+		if _, err := path.Match(p, ""); err != nil {
+			return nil, fmt.Errorf("invalid action pattern %q", p)
+		}
+	}
+	if len(patterns) == 0 {
+		patterns = []string{"*"}
+	}
+	return patterns, nil
+}
+
+func (t *auditTarget) wants(action string) bool {
+	for _, p := range t.actions {
+		if ok, _ := path.Match(p, action); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// forwardAudit runs one forwarding pass over every target.
+func (a *App) forwardAudit(ctx context.Context) error {
+	var errs []error
+	for _, t := range a.auditTargets {
+		if err := a.forwardAuditTarget(ctx, t); err != nil {
+			t.failed.Add(1)
+			a.logger.Printf("event=audit_forward_failed sink=%s err=%v", t.sink.name(), err)
+			errs = append(errs, fmt.Errorf("%s: %w", t.sink.name(), err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func (a *App) forwardAuditTarget(ctx context.Context, t *auditTarget) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	cursor, err := a.auditCursor(t.sink.name())
+	if err != nil {
+		return err
+	}
+	for i := 0; i < auditForwardMaxBatches; i++ {
+		lines, err := a.auditRowsAfter(cursor, auditForwardBatch)
+		if err != nil || len(lines) == 0 {
+			return err
+		}
+		var matched []auditLine
+		for _, l := range lines {
+			if t.wants(l.Action) {
+				matched = append(matched, l)
+			}
+		}
+		if len(matched) > 0 {
+			if err := t.sink.send(ctx, matched); err != nil {
+				return err
+			}
+			t.forwarded.Add(int64(len(matched)))
+		}
+		cursor = lines[len(lines)-1].ID
+		if _, err := a.db.Exec(`UPDATE audit_forward_cursors SET last_id = ?, updated_at = ? WHERE sink = ?`, cursor, nowUTC(), t.sink.name()); err != nil {
+			return err
+		}
+		if len(lines) < auditForwardBatch {
+			return nil
+		}
+	}
+	return nil
+}
+
+// auditCursor returns the last forwarded id for sink. A new sink starts at
+// the current end of the log; 'admin export-audit' covers older rows.
+func (a *App) auditCursor(sink string) (int64, error) {
+	var id int64
+	err := a.db.QueryRow(`SELECT last_id FROM audit_forward_cursors WHERE sink = ?`, sink).Scan(&id)
+	if err == nil {
+		return id, nil
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		return 0, err
+	}
+	if err := a.db.QueryRow(`SELECT COALESCE(MAX(id), 0) FROM action_logs`).Scan(&id); err != nil {
+		return 0, err
+	}
+	_, err = a.db.Exec(`INSERT INTO audit_forward_cursors(sink, last_id, updated_at) VALUES(?, ?, ?)`, sink, id, nowUTC())
+	return id, err
+}
+
+func (a *App) auditRowsAfter(id int64, limit int) ([]auditLine, error) {
+	rows, err := a.db.Query(`
+SELECT id, actor_type, actor_username, impersonator, action, metadata, created_at, client_ip, request_id, route, prev_hash, hash
+FROM action_logs WHERE id > ? ORDER BY id ASC LIMIT ?`, id, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var out []auditLine
+	for rows.Next() {
+		var l auditLine
+		if err := rows.Scan(&l.ID, &l.ActorType, &l.ActorUsername, &l.Impersonator, &l.Action, &l.Metadata, &l.CreatedAt, &l.ClientIP, &l.RequestID, &l.Route, &l.PrevHash, &l.Hash); err != nil {
+			return nil, err
+		}
+		out = append(out, l)
+	}
+	return out, rows.Err()
+}
+
+func (a *App) auditForwardLoop(ctx context.Context) {
+	ticker := time.NewTicker(auditForwardInterval)
+	defer ticker.Stop()
+	a.health.register(subsystemAuditForward, auditForwardInterval, time.Now())
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			err := a.forwardAudit(ctx)
+			a.health.record(subsystemAuditForward, time.Now(), err)
+		}
+	}
+}
+
+// syslogSink sends RFC 5424 messages over UDP, TCP or TLS. Stream
+// transports use octet-counting framing (RFC 6587).
+type syslogSink struct {
+	network  string // udp, tcp or tls
+	addr     string
+	hostname string
+	conn     net.Conn
+}
+
+// parseSyslogTarget parses --audit-syslog: udp://, tcp:// or tls://host:port.
+func parseSyslogTarget(s string) (*syslogSink, error) {
+	u, err := url.Parse(strings.TrimSpace(s))
+	if err != nil || (u.Scheme != "udp" && u.Scheme != "tcp" && u.Scheme != "tls") || u.Port() == "" || u.Hostname() == "" {
+		return nil, fmt.Errorf("invalid --audit-syslog %q (want udp://, tcp:// or tls://host:port)", s)
+	}
+	host, err := os.Hostname()
+	if err != nil || host == "" {
+		host = "-"
+	}
+	return &syslogSink{network: u.Scheme, addr: u.Host, hostname: host}, nil
+}
+
+func (s *syslogSink) name() string { return "syslog" }
+
+func (s *syslogSink) dial(ctx context.Context) error {
+	if s.conn != nil {
+		return nil
+	}
+	d := &net.Dialer{Timeout: 10 * time.Second}
+	var err error
+	switch s.network {
+	case "tls":
+		host, _, _ := net.SplitHostPort(s.addr)
+		td := &tls.Dialer{NetDialer: d, Config: &tls.Config{ServerName: host, MinVersion: tls.VersionTLS12}}
+		s.conn, err = td.DialContext(ctx, "tcp", s.addr)
+	default:
+		s.conn, err = d.DialContext(ctx, s.network, s.addr)
+	}
+	return err
+}
+
+func (s *syslogSink) send(ctx context.Context, lines []auditLine) error {
+	if err := s.dial(ctx); err != nil {
+		return err
+	}
+	_ = s.conn.SetWriteDeadline(time.Now().Add(10 * time.Second))
+	for _, l := range lines {
+		msg := formatSyslog(l, s.hostname)
+		if s.network != "udp" {
+			msg = fmt.Sprintf("%d %s", len(msg), msg)
+		}
+		if _, err := s.conn.Write([]byte(msg)); err != nil {
+			_ = s.conn.Close()
+			s.conn = nil
+			return err
+		}
+	}
+	return nil
+}
+
+// formatSyslog renders one audit row as an RFC 5424 message: MSGID is the
+// action, structured data carries the actor and chain fields and MSG is the
+// row's metadata.
+func formatSyslog(l auditLine, hostname string) string {
+	sd := fmt.Sprintf(`[%s id="%d" actor_type="%s" actor="%s" impersonator="%s" client_ip="%s" request_id="%s" route="%s" hash="%s" prev_hash="%s"]`,
+		syslogSDID, l.ID, sdEscape(l.ActorType), sdEscape(l.ActorUsername), sdEscape(l.Impersonator), sdEscape(l.ClientIP), sdEscape(l.RequestID), sdEscape(l.Route), l.Hash, l.PrevHash)
+	ts := l.CreatedAt
+	if ts == "" {
+		ts = "-"
+	}
+	return fmt.Sprintf("<%d>1 %s %s %s %d %s %s %s", syslogPRI, ts, syslogField(hostname, 255), syslogAppName, os.Getpid(), syslogField(l.Action, 32), sd, l.Metadata)
+}
+
+// sdEscape escapes an RFC 5424 PARAM-VALUE.
+func sdEscape(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, `]`, `\]`).Replace(s)
+}
+
+// syslogField makes a header field printable US-ASCII without spaces.
+func syslogField(s string, max int) string {
+	var b strings.Builder
+	for _, r := range s {
+		if r > 32 && r < 127 {
+			b.WriteRune(r)
+		}
+	}
+	out := b.String()
+	if out == "" {
+		return "-"
+	}
+	if len(out) > max {
+		out = out[:max]
+	}
+	return out
+}
+
+// httpAuditSink POSTs batches as newline-delimited JSON, one chained audit
+// row per line, which most SIEM HTTP collectors ingest directly.
+type httpAuditSink struct {
+	url    string
+	token  string
+	client *http.Client
+}
+
+func (h *httpAuditSink) name() string { return "http" }
+
+func (h *httpAuditSink) send(ctx context.Context, lines []auditLine) error {
+	var body bytes.Buffer
+	enc := json.NewEncoder(&body)
+	for _, l := range lines {
+		if err := enc.Encode(l); err != nil {
+			return err
+		}
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, h.url, &body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-ndjson")
+	if h.token != "" {
+		req.Header.Set("Authorization", "Bearer "+h.token)
+	}
+	client := h.client
+	if client == nil {
+		client = &http.Client{Timeout: 10 * time.Second}
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("siem returned %s", resp.Status)
+	}
+	return nil
+}
+
+// auditForwardMetrics reports per-sink forwarding counters.
+func (a *App) auditForwardMetrics() (forwarded, failed map[string]float64) {
+	forwarded, failed = map[string]float64{}, map[string]float64{}
+	for _, t := range a.auditTargets {
+		forwarded[t.sink.name()] = float64(t.forwarded.Load())
+		failed[t.sink.name()] = float64(t.failed.Load())
+	}
+	return forwarded, failed
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestAuditForwarding(t *testing.T) {
+	app := newTestApp(t)
+	ctx := context.Background()
+	// Rows from before a sink is configured are not backfilled.
+	if err := app.writeActionLog("user", "alice", "", "", "create_entry", "entry_id=1"); err != nil {
+		t.Fatal(err)
+	}
+
+	udp, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer udp.Close()
+	var mu sync.Mutex
+	var posted []auditLine
+	var auth string
+	siem := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		auth = r.Header.Get("Authorization")
+		dec := json.NewDecoder(r.Body)
+		for {
+			var l auditLine
+			if err := dec.Decode(&l); err != nil {
+				break
+			}
+			posted = append(posted, l)
+		}
+	}))
+	defer siem.Close()
+
+	tokenFile := filepath.Join(t.TempDir(), "siem-token")
+	if err := os.WriteFile(tokenFile, []byte("s3cret\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	targets, err := parseAuditTargets("udp://"+udp.LocalAddr().String(), "impersonate", siem.URL, tokenFile, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	app.auditTargets = targets
+	if err := app.forwardAudit(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := app.writeActionLog("user", "root", "", "10.0.0.5", "impersonate", `target="b]ob"`); err != nil {
+		t.Fatal(err)
+	}
+	if err := app.writeActionLog("user", "alice", "", "", "update_entry", "entry_id=1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := app.forwardAudit(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	buf := make([]byte, 4096)
+	_ = udp.SetReadDeadline(time.Now().Add(2 * time.Second))
+	n, _, err := udp.ReadFrom(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg := string(buf[:n])
+	if !strings.HasPrefix(msg, "<109>1 ") || !strings.Contains(msg, " team-dev-log ") || !strings.Contains(msg, " impersonate [devlog@32473 ") ||
+		!strings.Contains(msg, `actor="root"`) || !strings.Contains(msg, `client_ip="10.0.0.5"`) || !strings.HasSuffix(msg, `target="b]ob"`) {
+		t.Fatalf("syslog message: %q", msg)
+	}
+	// update_entry is filtered out of the syslog sink.
+	_ = udp.SetReadDeadline(time.Now().Add(100 * time.Millisecond))
+	if n, _, err := udp.ReadFrom(buf); err == nil {
+		t.Fatalf("unexpected syslog message: %q", buf[:n])
+	}
+
+	mu.Lock()
+	if auth != "Bearer s3cret" {
+		t.Fatalf("authorization: %q", auth)
+	}
+	if len(posted) != 2 || posted[0].Action != "impersonate" || posted[1].Action != "update_entry" || posted[0].Hash == "" || posted[1].PrevHash != posted[0].Hash {
+		t.Fatalf("posted: %+v", posted)
+	}
+	mu.Unlock()
+
+	// Cursors persist: a second pass sends nothing new.
+	if err := app.forwardAudit(ctx); err != nil {
+		t.Fatal(err)
+	}
+	mu.Lock()
+	if len(posted) != 2 {
+		t.Fatalf("resent rows: %d", len(posted))
+	}
+	mu.Unlock()
+
+	// A failing SIEM keeps its cursor so the rows are retried.
+	siem.Close()
+	if err := app.writeActionLog("user", "alice", "", "", "create_entry", "entry_id=2"); err != nil {
+		t.Fatal(err)
+	}
+	if err := app.forwardAudit(ctx); err == nil {
+		t.Fatal("expected forwarding error with the SIEM down")
+	}
+	var httpCursor, maxID int64
+	if err := app.db.QueryRow(`SELECT last_id FROM audit_forward_cursors WHERE sink = 'http'`).Scan(&httpCursor); err != nil {
+		t.Fatal(err)
+	}
+	if err := app.db.QueryRow(`SELECT MAX(id) FROM action_logs`).Scan(&maxID); err != nil {
+		t.Fatal(err)
+	}
+	if httpCursor != maxID-1 {
+		t.Fatalf("http cursor %d, want %d", httpCursor, maxID-1)
+	}
+	if _, failed := app.auditForwardMetrics(); failed["http"] != 1 || failed["syslog"] != 0 {
+		t.Fatalf("failure counters: %v", failed)
+	}
+
+	if _, err := parseAuditTargets("syslog://host:514", "", "", "", ""); err == nil {
+		t.Fatal("expected invalid scheme error")
+	}
+	if _, err := parseAuditTargets("", "", "http://siem", "", "[bad"); err == nil {
+		t.Fatal("expected invalid pattern error")
+	}
+}
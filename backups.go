@@ -0,0 +1,383 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Backup browser: serve --backup-dir points at the directory the nightly
+// `sqlite3 .backup` job writes to. Admins list and download those files and
+// restore one into a staging database next to them, which is only ever read:
+// the live database is never touched, so recovering yesterday's deleted
+// entries is a matter of reading them back from staging.
+//
+// Backups themselves are taken online: GET /api/admin/backup and
+// 'admin backup --out' both copy the live database with VACUUM INTO, which
+// reads one consistent snapshot while other connections keep writing. Copying
+// the file (and its -wal) by hand is never safe while serve runs.
+
+const (
+	backupSuffix    = ".db"
+	stagingFileName = ".staging.db"
+)
+
+type backupFile struct {
+	Name       string `json:"name"`
+	Bytes      int64  `json:"bytes"`
+	ModifiedAt string `json:"modified_at"`
+}
+
+// stagingInfo describes the backup currently restored into staging.
+type stagingInfo struct {
+	Backup     string `json:"backup"`
+	Bytes      int64  `json:"bytes"`
+	RestoredAt string `json:"restored_at"`
+}
+
+func (a *App) stagingPath() string {
+	return filepath.Join(a.backupDir, stagingFileName)
+}
+
+// listBackups returns the *.db files in the backup directory, newest first.
+// Hidden files (the staging copy among them) are skipped.
+func (a *App) listBackups() ([]backupFile, error) {
+	items, err := os.ReadDir(a.backupDir)
+	if err != nil {
+		return nil, err
+	}
+	out := []backupFile{}
+	for _, it := range items {
+		name := it.Name()
+		if !it.Type().IsRegular() || strings.HasPrefix(name, ".") || !strings.HasSuffix(name, backupSuffix) {
+			continue
+		}
+		info, err := it.Info()
+		if err != nil {
+			continue
+		}
+		out = append(out, backupFile{Name: name, Bytes: info.Size(), ModifiedAt: info.ModTime().UTC().Format(time.RFC3339)})
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].ModifiedAt != out[j].ModifiedAt {
+			return out[i].ModifiedAt > out[j].ModifiedAt
+		}
+		return out[i].Name > out[j].Name
+	})
+	return out, nil
+}
+
+// backupPath resolves a listed backup name to its file; anything that is not
+// a plain *.db name in the directory is errBackupNotFound.
+func (a *App) backupPath(name string) (string, error) {
+	if name == "" || strings.HasPrefix(name, ".") || name != filepath.Base(name) || !strings.HasSuffix(name, backupSuffix) {
+		return "", errBackupNotFound
+	}
+	p := filepath.Join(a.backupDir, name)
+	st, err := os.Stat(p)
+	if err != nil || !st.Mode().IsRegular() {
+		return "", errBackupNotFound
+	}
+	return p, nil
+}
+
+var errBackupNotFound = errors.New("backup not found")
+
+// readStaging reports the restored backup, or ok=false when staging is empty.
+func (a *App) readStaging() (stagingInfo, bool, error) {
+	st, err := os.Stat(a.stagingPath())
+	if errors.Is(err, os.ErrNotExist) {
+		return stagingInfo{}, false, nil
+	}
+	if err != nil {
+		return stagingInfo{}, false, err
+	}
+	info := stagingInfo{Bytes: st.Size(), RestoredAt: st.ModTime().UTC().Format(time.RFC3339)}
+	if b, err := os.ReadFile(a.stagingPath() + ".source"); err == nil {
+		info.Backup = strings.TrimSpace(string(b))
+	}
+	return info, true, nil
+}
+
+// restoreToStaging copies backup name over the staging database, checking
+// the copy's integrity before it replaces the previous one.
+func (a *App) restoreToStaging(name string) (stagingInfo, error) {
+	src, err := a.backupPath(name)
+	if err != nil {
+		return stagingInfo{}, err
+	}
+	in, err := os.Open(src)
+	if err != nil {
+		return stagingInfo{}, err
+	}
+	defer in.Close()
+	tmp := a.stagingPath() + ".incoming"
+	defer os.Remove(tmp)
+	out, err := os.OpenFile(tmp, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0o600)
+	if err != nil {
+		return stagingInfo{}, err
+	}
+	size, err := io.Copy(out, in)
+	if cerr := out.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return stagingInfo{}, err
+	}
+	if err := checkSnapshotIntegrity(tmp); err != nil {
+		return stagingInfo{}, err
+	}
+	if err := os.Rename(tmp, a.stagingPath()); err != nil {
+		return stagingInfo{}, err
+	}
+	if err := os.WriteFile(a.stagingPath()+".source", []byte(name+"\n"), 0o600); err != nil {
+		return stagingInfo{}, err
+	}
+	return stagingInfo{Backup: name, Bytes: size, RestoredAt: nowUTC()}, nil
+}
+
+// stagingEntry is an entry read back from the staging database. Only columns
+// every schema version has are read, so old backups work too.
+type stagingEntry struct {
+	ID        int64  `json:"id"`
+	User      string `json:"user"`
+	EntryType string `json:"entry_type"`
+	Content   string `json:"content"`
+	CreatedAt string `json:"created_at"`
+}
+
+func (a *App) stagingEntries(day string) ([]stagingEntry, error) {
+	db, err := sql.Open("sqlite3", "file:"+a.stagingPath()+"?mode=ro")
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+	rows, err := db.Query(`
+SELECT e.id, COALESCE(u.username, ''), e.entry_type, e.content, e.created_at
+FROM entries e
+LEFT JOIN users u ON u.id = e.user_id
+WHERE date(e.created_at) = ?
+ORDER BY e.created_at ASC, e.id ASC`, day)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	out := []stagingEntry{}
+	for rows.Next() {
+		var e stagingEntry
+		if err := rows.Scan(&e.ID, &e.User, &e.EntryType, &e.Content, &e.CreatedAt); err != nil {
+			return nil, err
+		}
+		out = append(out, e)
+	}
+	return out, rows.Err()
+}
+
+// handleAdminBackups serves GET /api/admin/backups: the backup files and the
+// backup currently restored into staging, if any.
+func (a *App) handleAdminBackups(w http.ResponseWriter, r *http.Request, u AuthedUser) {
+	if r.Method != http.MethodGet {
+		jsonErr(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	if a.backupDir == "" {
+		jsonErr(w, http.StatusNotFound, "backups are not configured (--backup-dir)")
+		return
+	}
+	backups, err := a.listBackups()
+	if err != nil {
+		a.logger.Printf("event=backup_list_failed dir=%s err=%v", a.backupDir, err)
+		jsonErr(w, http.StatusInternalServerError, "failed to list backups")
+		return
+	}
+	resp := map[string]any{"backups": backups, "staging": nil}
+	if st, ok, err := a.readStaging(); err != nil {
+		jsonErr(w, http.StatusInternalServerError, "failed to read staging database")
+		return
+	} else if ok {
+		resp["staging"] = st
+	}
+	_ = a.logUserAction(u, "list_backups", fmt.Sprintf("count=%d", len(backups)))
+	jsonOut(w, http.StatusOK, resp)
+}
+
+// handleAdminBackup serves GET /api/admin/backups/{name} (download).
+func (a *App) handleAdminBackup(w http.ResponseWriter, r *http.Request, u AuthedUser) {
+	if r.Method != http.MethodGet {
+		jsonErr(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	if a.backupDir == "" {
+		jsonErr(w, http.StatusNotFound, "backups are not configured (--backup-dir)")
+		return
+	}
+	name := r.PathValue("name")
+	p, err := a.backupPath(name)
+	if err != nil {
+		jsonErr(w, http.StatusNotFound, err.Error())
+		return
+	}
+	f, err := os.Open(p)
+	if err != nil {
+		jsonErr(w, http.StatusInternalServerError, "failed to read backup")
+		return
+	}
+	defer f.Close()
+	st, err := f.Stat()
+	if err != nil {
+		jsonErr(w, http.StatusInternalServerError, "failed to read backup")
+		return
+	}
+	_ = a.logUserAction(u, "download_backup", fmt.Sprintf("name=%s bytes=%d", name, st.Size()))
+	w.Header().Set("Content-Type", "application/vnd.sqlite3")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", name))
+	w.Header().Set("Cache-Control", "no-store")
+	http.ServeContent(w, r, "", st.ModTime(), f)
+}
+
+// handleAdminRestoreBackup serves POST /api/admin/backups/{name}/restore,
+// which replaces the staging database with that backup.
+func (a *App) handleAdminRestoreBackup(w http.ResponseWriter, r *http.Request, u AuthedUser) {
+	if r.Method != http.MethodPost {
+		jsonErr(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	if a.backupDir == "" {
+		jsonErr(w, http.StatusNotFound, "backups are not configured (--backup-dir)")
+		return
+	}
+	name := r.PathValue("name")
+	st, err := a.restoreToStaging(name)
+	if errors.Is(err, errBackupNotFound) {
+		jsonErr(w, http.StatusNotFound, err.Error())
+		return
+	}
+	if err != nil {
+		a.logger.Printf("event=backup_restore_failed name=%s err=%v", name, err)
+		jsonErr(w, http.StatusUnprocessableEntity, "failed to restore backup: "+err.Error())
+		return
+	}
+	_ = a.logUserAction(u, "restore_backup", fmt.Sprintf("name=%s bytes=%d target=staging", name, st.Bytes))
+	jsonOut(w, http.StatusOK, st)
+}
+
+// handleAdminStagingEntries serves GET /api/admin/backups/staging/entries?day=,
+// every entry of that day in the staging database, trashed ones included.
+func (a *App) handleAdminStagingEntries(w http.ResponseWriter, r *http.Request, u AuthedUser) {
+	if r.Method != http.MethodGet {
+		jsonErr(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	if a.backupDir == "" {
+		jsonErr(w, http.StatusNotFound, "backups are not configured (--backup-dir)")
+		return
+	}
+	day := strings.TrimSpace(r.URL.Query().Get("day"))
+	if _, err := time.Parse("2006-01-02", day); err != nil {
+		jsonErr(w, http.StatusBadRequest, "day must be YYYY-MM-DD")
+		return
+	}
+	st, ok, err := a.readStaging()
+	if err != nil {
+		jsonErr(w, http.StatusInternalServerError, "failed to read staging database")
+		return
+	}
+	if !ok {
+		jsonErr(w, http.StatusNotFound, "no backup restored to staging")
+		return
+	}
+	entries, err := a.stagingEntries(day)
+	if err != nil {
+		jsonErr(w, http.StatusInternalServerError, "failed to query staging database")
+		return
+	}
+	_ = a.logUserAction(u, "list_staging_entries", fmt.Sprintf("backup=%s day=%s count=%d", st.Backup, day, len(entries)))
+	jsonOut(w, http.StatusOK, map[string]any{"staging": st, "day": day, "entries": entries})
+}
+
+// handleAdminOnlineBackup serves GET /api/admin/backup: a consistent snapshot
+// of the live database, streamed as a download.
+func (a *App) handleAdminOnlineBackup(w http.ResponseWriter, r *http.Request, u AuthedUser) {
+	if r.Method != http.MethodGet {
+		jsonErr(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	name := "devlog-" + time.Now().UTC().Format("2006-01-02T150405Z") + backupSuffix
+	a.serveSnapshot(w, u, "create_backup", name)
+}
+
+// backupTo writes an online backup to out. The copy is made next to out,
+// integrity-checked and renamed over it, so out is never half-written.
+func (a *App) backupTo(out string) (string, int64, error) {
+	tmp := out + ".incoming"
+	if err := os.Remove(tmp); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return "", 0, err
+	}
+	defer os.Remove(tmp)
+	sum, size, err := a.writeSnapshot(tmp)
+	if err != nil {
+		return "", 0, err
+	}
+	if err := checkSnapshotIntegrity(tmp); err != nil {
+		return "", 0, err
+	}
+	if err := os.Rename(tmp, out); err != nil {
+		return "", 0, err
+	}
+	return sum, size, nil
+}
+
+func runAdminBackup(args []string) error {
+	fs := flag.NewFlagSet("admin backup", flag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s admin backup --out <file> [options]\n\n", binName())
+		fmt.Fprintln(fs.Output(), "Writes a consistent copy of the database with VACUUM INTO. Safe while serve")
+		fmt.Fprintln(fs.Output(), "is running: writes continue during the copy. An existing --out is replaced")
+		fmt.Fprintln(fs.Output(), "only after the new copy passes PRAGMA integrity_check.")
+		fmt.Fprintln(fs.Output())
+		fmt.Fprintln(fs.Output(), "Options:")
+		fs.PrintDefaults()
+	}
+	out := fs.String("out", "", "backup file to write")
+	dbPath := fs.String("db", defaultDBPath, "sqlite db path")
+	logPath := fs.String("log", defaultLogPath, "log target path ('-' for stdout only)")
+	if err := fs.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return nil
+		}
+		return err
+	}
+	if *out == "" {
+		return errors.New("--out is required")
+	}
+	if abs, err := filepath.Abs(*out); err == nil {
+		if db, err := filepath.Abs(*dbPath); err == nil && abs == db {
+			return errors.New("--out must not be the database itself")
+		}
+	}
+
+	app, closeApp, err := openAdminApp(*dbPath, *logPath)
+	if err != nil {
+		return err
+	}
+	defer closeApp()
+	sum, size, err := app.backupTo(*out)
+	if err != nil {
+		return fmt.Errorf("backup: %w", err)
+	}
+	_ = app.logAction("admin_cli", "admin", "create_backup", fmt.Sprintf("out=%s sha256=%s bytes=%d", *out, sum, size))
+	fmt.Printf("wrote %s (%d bytes, sha256 %s)\n", *out, size, sum)
+	return nil
+}
+
+func (a *App) handleBackupsUI(w http.ResponseWriter, _ *http.Request) {
+	renderUI(w, "templates/backups.html", uiPageData{Title: "PUD Dev Log Backups", Maintenance: a.maintenanceBanner(), Banner: a.settings().Banner, BasePath: a.basePath, APIBase: a.apiBase()})
+}
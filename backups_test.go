@@ -0,0 +1,164 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestAdminBackupBrowser(t *testing.T) {
+	app := newTestApp(t)
+	h := newTestMux(app)
+	createUser(t, app, "root", "PUDBACKUP01")
+	if _, err := app.db.Exec(`UPDATE users SET role = 'admin' WHERE username = 'root'`); err != nil {
+		t.Fatal(err)
+	}
+	root, err := app.lookupToken("PUDBACKUP01")
+	if err != nil {
+		t.Fatal(err)
+	}
+	id, err := app.insertEntry(root.ID, "entry deleted by accident", "", sourceAPI, "2026-02-17T10:00:00Z")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, authedReq(t, http.MethodGet, "/api/admin/backups", nil, "PUDBACKUP01"))
+	if rr.Code != http.StatusNotFound {
+		t.Fatalf("backups without --backup-dir: %d", rr.Code)
+	}
+	app.backupDir = t.TempDir()
+	if _, err := app.db.Exec(`VACUUM INTO ?`, filepath.Join(app.backupDir, "devlog-2026-02-17.db")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(app.backupDir, "broken.db"), []byte("not a database"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := app.db.Exec(`DELETE FROM entries WHERE id = ?`, id); err != nil {
+		t.Fatal(err)
+	}
+
+	rr = httptest.NewRecorder()
+	h.ServeHTTP(rr, authedReq(t, http.MethodGet, "/api/admin/backups", nil, "PUDBACKUP01"))
+	var list struct {
+		Backups []backupFile `json:"backups"`
+		Staging *stagingInfo `json:"staging"`
+	}
+	if err := json.Unmarshal(rr.Body.Bytes(), &list); err != nil || rr.Code != http.StatusOK || len(list.Backups) != 2 || list.Staging != nil {
+		t.Fatalf("list: %d %s", rr.Code, rr.Body.String())
+	}
+
+	rr = httptest.NewRecorder()
+	h.ServeHTTP(rr, authedReq(t, http.MethodGet, "/api/admin/backups/devlog-2026-02-17.db", nil, "PUDBACKUP01"))
+	if rr.Code != http.StatusOK || !bytes.HasPrefix(rr.Body.Bytes(), []byte("SQLite format 3")) {
+		t.Fatalf("download: %d", rr.Code)
+	}
+	for _, name := range []string{"..%2Ftest.db", ".staging.db", "missing.db"} {
+		rr = httptest.NewRecorder()
+		h.ServeHTTP(rr, authedReq(t, http.MethodGet, "/api/admin/backups/"+name, nil, "PUDBACKUP01"))
+		if rr.Code != http.StatusNotFound {
+			t.Fatalf("download %s: %d", name, rr.Code)
+		}
+	}
+
+	rr = httptest.NewRecorder()
+	h.ServeHTTP(rr, authedReq(t, http.MethodGet, "/api/admin/backups/staging/entries?day=2026-02-17", nil, "PUDBACKUP01"))
+	if rr.Code != http.StatusNotFound {
+		t.Fatalf("staging before restore: %d", rr.Code)
+	}
+	rr = httptest.NewRecorder()
+	h.ServeHTTP(rr, authedReq(t, http.MethodPost, "/api/admin/backups/broken.db/restore", nil, "PUDBACKUP01"))
+	if rr.Code != http.StatusUnprocessableEntity {
+		t.Fatalf("restore broken backup: %d %s", rr.Code, rr.Body.String())
+	}
+	rr = httptest.NewRecorder()
+	h.ServeHTTP(rr, authedReq(t, http.MethodPost, "/api/admin/backups/devlog-2026-02-17.db/restore", nil, "PUDBACKUP01"))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("restore: %d %s", rr.Code, rr.Body.String())
+	}
+	rr = httptest.NewRecorder()
+	h.ServeHTTP(rr, authedReq(t, http.MethodGet, "/api/admin/backups/staging/entries?day=2026-02-17", nil, "PUDBACKUP01"))
+	var staged struct {
+		Staging stagingInfo    `json:"staging"`
+		Entries []stagingEntry `json:"entries"`
+	}
+	if err := json.Unmarshal(rr.Body.Bytes(), &staged); err != nil || rr.Code != http.StatusOK {
+		t.Fatalf("staging entries: %d %s", rr.Code, rr.Body.String())
+	}
+	if staged.Staging.Backup != "devlog-2026-02-17.db" || len(staged.Entries) != 1 || staged.Entries[0].Content != "entry deleted by accident" || staged.Entries[0].User != "root" {
+		t.Fatalf("staging entries: %+v", staged)
+	}
+	var live int
+	if err := app.db.QueryRow(`SELECT COUNT(*) FROM entries WHERE id = ?`, id).Scan(&live); err != nil || live != 0 {
+		t.Fatalf("restore touched the live database: %d %v", live, err)
+	}
+	var logged int
+	if err := app.db.QueryRow(`SELECT COUNT(*) FROM action_logs WHERE action = 'restore_backup'`).Scan(&logged); err != nil || logged != 1 {
+		t.Fatalf("restore_backup audit rows: %d %v", logged, err)
+	}
+}
+
+func TestOnlineBackup(t *testing.T) {
+	app := newTestApp(t)
+	h := newTestMux(app)
+	createUser(t, app, "root", "PUDONLINE01")
+	createUser(t, app, "alice", "PUDONLINE02")
+	if _, err := app.db.Exec(`UPDATE users SET role = 'admin' WHERE username = 'root'`); err != nil {
+		t.Fatal(err)
+	}
+	root, err := app.lookupToken("PUDONLINE01")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := app.insertEntry(root.ID, "kept in the backup", "", sourceAPI, "2026-02-17T10:00:00Z"); err != nil {
+		t.Fatal(err)
+	}
+
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, authedReq(t, http.MethodGet, "/api/admin/backup", nil, "PUDONLINE02"))
+	if rr.Code != http.StatusForbidden {
+		t.Fatalf("member backup: %d", rr.Code)
+	}
+	rr = httptest.NewRecorder()
+	h.ServeHTTP(rr, authedReq(t, http.MethodGet, "/api/admin/backup", nil, "PUDONLINE01"))
+	if rr.Code != http.StatusOK || !bytes.HasPrefix(rr.Body.Bytes(), []byte("SQLite format 3")) {
+		t.Fatalf("backup: %d", rr.Code)
+	}
+	sum := sha256.Sum256(rr.Body.Bytes())
+	if rr.Header().Get(snapshotSHAHeader) != hex.EncodeToString(sum[:]) || !strings.Contains(rr.Header().Get("Content-Disposition"), "devlog-") {
+		t.Fatalf("backup headers: %v", rr.Header())
+	}
+
+	out := filepath.Join(t.TempDir(), "snapshot.db")
+	if err := os.WriteFile(out, []byte("previous backup"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := app.backupTo(out); err != nil {
+		t.Fatalf("backupTo: %v", err)
+	}
+	if _, err := os.Stat(out + ".incoming"); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("temporary copy left behind: %v", err)
+	}
+	db, err := sql.Open("sqlite3", "file:"+out+"?mode=ro")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	var n int
+	if err := db.QueryRow(`SELECT COUNT(*) FROM entries WHERE content = 'kept in the backup'`).Scan(&n); err != nil || n != 1 {
+		t.Fatalf("backup file entries: %d %v", n, err)
+	}
+	var logged int
+	if err := app.db.QueryRow(`SELECT COUNT(*) FROM action_logs WHERE action = 'create_backup'`).Scan(&logged); err != nil || logged != 1 {
+		t.Fatalf("create_backup audit rows: %d %v", logged, err)
+	}
+}
@@ -0,0 +1,252 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/blevesearch/bleve/v2"
+	"github.com/blevesearch/bleve/v2/analysis/analyzer/keyword"
+	"github.com/blevesearch/bleve/v2/analysis/analyzer/standard"
+	"github.com/blevesearch/bleve/v2/mapping"
+	"github.com/blevesearch/bleve/v2/search/query"
+)
+
+// Embedded Bleve search: --search bleve keeps an on-disk Bleve index next to
+// the database (--bleve-path) and answers /api/search from it with fuzzy and
+// prefix matching, so "deplyo" finds "deploy" and "pipe" finds "pipeline"
+// without running Elasticsearch. The index follows entries through the
+// search outbox like the Elasticsearch indexer does, under its own cursor.
+
+const (
+	searchBleve        = "bleve"
+	outboxBleve        = "bleve"
+	subsystemBleve     = "bleve_index"
+	bleveIndexInterval = 5 * time.Second
+)
+
+// bleveIndex is the open on-disk index.
+type bleveIndex struct {
+	path  string
+	index bleve.Index
+}
+
+// bleveMapping indexes content with the standard analyzer and user, day and
+// created_at as exact keywords; nothing else of an entry is stored.
+func bleveMapping() *mapping.IndexMappingImpl {
+	text := bleve.NewTextFieldMapping()
+	text.Analyzer = standard.Name
+	text.Store = false
+	kw := bleve.NewTextFieldMapping()
+	kw.Analyzer = keyword.Name
+	kw.Store = false
+	doc := bleve.NewDocumentStaticMapping()
+	doc.AddFieldMappingsAt("content", text)
+	doc.AddFieldMappingsAt("user", kw)
+	doc.AddFieldMappingsAt("day", kw)
+	doc.AddFieldMappingsAt("created_at", kw)
+	m := bleve.NewIndexMapping()
+	m.DefaultMapping = doc
+	return m
+}
+
+// openBleveIndex opens the index at path. When the outbox has no cursor for
+// it (first start, or serve ran without --search bleve since), the index may
+// have missed changes, so it is recreated from the live entries.
+func (a *App) openBleveIndex(path string) error {
+	var n int
+	if err := a.db.QueryRow(`SELECT COUNT(*) FROM search_outbox_cursors WHERE consumer = ?`, outboxBleve).Scan(&n); err != nil {
+		return err
+	}
+	if n == 0 {
+		if err := os.RemoveAll(path); err != nil {
+			return fmt.Errorf("reset bleve index: %w", err)
+		}
+	}
+	idx, err := bleve.Open(path)
+	if errors.Is(err, bleve.ErrorIndexPathDoesNotExist) {
+		idx, err = bleve.New(path, bleveMapping())
+	}
+	if err != nil {
+		return fmt.Errorf("open bleve index %s: %w", path, err)
+	}
+	a.bleve = &bleveIndex{path: path, index: idx}
+	if n == 0 {
+		count, err := a.rebuildBleveIndex()
+		if err != nil {
+			_ = idx.Close()
+			a.bleve = nil
+			return fmt.Errorf("build bleve index: %w", err)
+		}
+		a.logger.Printf("event=bleve_index_built path=%s docs=%d", path, count)
+	}
+	return nil
+}
+
+func (b *bleveIndex) Close() error {
+	return b.index.Close()
+}
+
+func bleveDocID(id int64) string {
+	return strconv.FormatInt(id, 10)
+}
+
+// put adds docs to batch; the index holds only what search needs.
+func (b *bleveIndex) put(batch *bleve.Batch, docs []esDocument) error {
+	for _, d := range docs {
+		if err := batch.Index(bleveDocID(d.EntryID), map[string]any{
+			"content":    d.Content,
+			"user":       d.User,
+			"day":        d.Day,
+			"created_at": d.CreatedAt,
+		}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// rebuildBleveIndex indexes every live entry, batch by batch, and returns how
+// many documents were written.
+func (a *App) rebuildBleveIndex() (int, error) {
+	b := a.bleve
+	var after int64
+	total := 0
+	for {
+		docs, err := a.searchDocuments(`e.id > ?`, searchIndexBatch, after)
+		if err != nil || len(docs) == 0 {
+			return total, err
+		}
+		batch := b.index.NewBatch()
+		if err := b.put(batch, docs); err != nil {
+			return total, err
+		}
+		if err := b.index.Batch(batch); err != nil {
+			return total, err
+		}
+		total += len(docs)
+		after = docs[len(docs)-1].EntryID
+	}
+}
+
+// drainBleveOutbox applies queued entry changes to the index until its
+// outbox cursor is caught up; ids whose entry is gone are deleted.
+func (a *App) drainBleveOutbox() error {
+	b := a.bleve
+	for {
+		ids, last, err := a.outboxBatch(outboxBleve)
+		if err != nil || len(ids) == 0 {
+			return err
+		}
+		args := make([]any, len(ids))
+		for i, id := range ids {
+			args[i] = id
+		}
+		docs, err := a.searchDocuments(`e.id IN (`+placeholders(len(ids))+`)`, 0, args...)
+		if err != nil {
+			return err
+		}
+		found := map[int64]bool{}
+		for _, d := range docs {
+			found[d.EntryID] = true
+		}
+		batch := b.index.NewBatch()
+		if err := b.put(batch, docs); err != nil {
+			return err
+		}
+		for _, id := range ids {
+			if !found[id] {
+				batch.Delete(bleveDocID(id))
+			}
+		}
+		if err := b.index.Batch(batch); err != nil {
+			return err
+		}
+		if err := a.advanceOutbox(outboxBleve, last); err != nil {
+			return err
+		}
+	}
+}
+
+func (a *App) bleveIndexLoop(ctx context.Context) {
+	ticker := time.NewTicker(bleveIndexInterval)
+	defer ticker.Stop()
+	a.health.register(subsystemBleve, bleveIndexInterval, time.Now())
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			err := a.drainBleveOutbox()
+			if err != nil {
+				a.logger.Printf("event=bleve_index_failed path=%s err=%v", a.bleve.path, err)
+			}
+			a.health.record(subsystemBleve, time.Now(), err)
+		}
+	}
+}
+
+// bleveFuzziness allows edits by word length, like Elasticsearch's AUTO:
+// none up to 2 characters, one up to 5, two beyond.
+func bleveFuzziness(word string) int {
+	switch n := len([]rune(word)); {
+	case n <= 2:
+		return 0
+	case n <= 5:
+		return 1
+	default:
+		return 2
+	}
+}
+
+// bleveSearch answers queries from the embedded index: every word must match
+// a content term within its edit distance or as a prefix. Hits are ranked by
+// score, newest first among equals.
+type bleveSearch struct {
+	b *bleveIndex
+}
+
+func (bleveSearch) Name() string { return searchBleve }
+
+func (s bleveSearch) Search(ctx context.Context, q searchQuery) ([]int64, error) {
+	var must []query.Query
+	for _, w := range strings.Fields(strings.ToLower(q.Text)) {
+		match := bleve.NewMatchQuery(w)
+		match.SetField("content")
+		match.SetFuzziness(bleveFuzziness(w))
+		prefix := bleve.NewPrefixQuery(w)
+		prefix.SetField("content")
+		must = append(must, bleve.NewDisjunctionQuery(match, prefix))
+	}
+	if len(must) == 0 {
+		return nil, nil
+	}
+	if q.User != "" {
+		user := bleve.NewTermQuery(q.User)
+		user.SetField("user")
+		must = append(must, user)
+	}
+	if q.From != "" || q.To != "" {
+		inclusive := true
+		day := bleve.NewTermRangeInclusiveQuery(q.From, q.To, &inclusive, &inclusive)
+		day.SetField("day")
+		must = append(must, day)
+	}
+	req := bleve.NewSearchRequestOptions(bleve.NewConjunctionQuery(must...), q.Limit, 0, false)
+	req.SortBy([]string{"-_score", "-created_at"})
+	res, err := s.b.index.SearchInContext(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	ids := make([]int64, 0, len(res.Hits))
+	for _, h := range res.Hits {
+		if id, err := strconv.ParseInt(h.ID, 10, 64); err == nil {
+			ids = append(ids, id)
+		}
+	}
+	return ids, nil
+}
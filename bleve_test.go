@@ -0,0 +1,89 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestBleveSearch(t *testing.T) {
+	app := newTestApp(t)
+	h := newTestMux(app)
+	createUser(t, app, "alice", "PUDFUZZY01")
+	createUser(t, app, "bob", "PUDFUZZY02")
+	post := func(token, content string) {
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, authedReq(t, http.MethodPost, "/api/entries", map[string]string{"content": content}, token))
+		if rr.Code != http.StatusCreated {
+			t.Fatalf("post: %d %s", rr.Code, rr.Body.String())
+		}
+	}
+	post("PUDFUZZY01", "Fixed the flaky deploy pipeline")
+
+	// A new index is built from the existing entries.
+	path := filepath.Join(t.TempDir(), "test.bleve")
+	if err := app.openBleveIndex(path); err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	defer app.bleve.Close()
+	if err := app.setSearchOutbox(outboxBleve); err != nil {
+		t.Fatal(err)
+	}
+	var err error
+	if app.search, err = app.newSearchProvider(searchBleve); err != nil {
+		t.Fatal(err)
+	}
+	post("PUDFUZZY02", "Reviewed the release checklist")
+	if err := app.drainBleveOutbox(); err != nil {
+		t.Fatalf("drain: %v", err)
+	}
+	search := func(query string) []entryRow {
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, authedReq(t, http.MethodGet, "/api/search?"+query, nil, "PUDFUZZY01"))
+		var got struct {
+			Provider string     `json:"provider"`
+			Entries  []entryRow `json:"entries"`
+		}
+		_ = json.Unmarshal(rr.Body.Bytes(), &got)
+		if rr.Code != http.StatusOK || got.Provider != searchBleve {
+			t.Fatalf("%s: %d %s", query, rr.Code, rr.Body.String())
+		}
+		return got.Entries
+	}
+
+	if got := search("q=deplyo"); len(got) != 1 || got[0].User != "alice" {
+		t.Fatalf("typo: %+v", got)
+	}
+	if got := search("q=pipe"); len(got) != 1 {
+		t.Fatalf("prefix: %+v", got)
+	}
+	if got := search("q=relase+checklst"); len(got) != 1 || got[0].User != "bob" {
+		t.Fatalf("entry added after build: %+v", got)
+	}
+	if got := search("q=release&user=alice"); len(got) != 0 {
+		t.Fatalf("user filter: %+v", got)
+	}
+	if got := search("q=deploy&to=2000-01-01"); len(got) != 0 {
+		t.Fatalf("day filter: %+v", got)
+	}
+
+	// Compaction replaces the originals in the index with the compact.
+	if err := app.compactDay(time.Now().UTC().Format("2006-01-02")); err != nil {
+		t.Fatalf("compactDay: %v", err)
+	}
+	if err := app.drainBleveOutbox(); err != nil {
+		t.Fatalf("drain after compaction: %v", err)
+	}
+	if n, err := app.bleve.index.DocCount(); err != nil || n != 1 {
+		t.Fatalf("docs after compaction: %d err=%v", n, err)
+	}
+	if got := search("q=deplyo+checklist"); len(got) != 1 || got[0].EntryType != "daily_compact" {
+		t.Fatalf("compact searchable: %+v", got)
+	}
+	if depth, err := app.searchOutboxDepth(outboxBleve); err != nil || depth != 0 {
+		t.Fatalf("outbox depth %d err=%v", depth, err)
+	}
+}
@@ -0,0 +1,377 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// BlobStore holds opaque objects (exports, backups, attachments) by key.
+// Keys are slash-separated and relative to the store's prefix.
+type BlobStore interface {
+	Put(ctx context.Context, key string, data []byte, contentType string) error
+	Get(ctx context.Context, key string) (io.ReadCloser, error)
+	// Delete removes key; deleting a missing key is not an error.
+	Delete(ctx context.Context, key string) error
+	// Describe returns a human-readable location for key (path or URL).
+	Describe(key string) string
+}
+
+var errBlobNotFound = errors.New("blob not found")
+
+// blobConfig is the shared --store* flag set of commands that write blobs.
+type blobConfig struct {
+	URL       string
+	Region    string
+	Endpoint  string
+	AccessKey string
+	SecretKey string
+}
+
+func addBlobFlags(fs *flag.FlagSet) *blobConfig {
+	c := &blobConfig{}
+	fs.StringVar(&c.URL, "store", "", "blob store URL: a directory, file:///dir, s3://bucket/prefix or gs://bucket/prefix")
+	fs.StringVar(&c.Region, "store-region", "us-east-1", "S3 region (GCS uses 'auto')")
+	fs.StringVar(&c.Endpoint, "store-endpoint", "", "S3-compatible endpoint override (e.g. http://127.0.0.1:9000 for MinIO)")
+	fs.StringVar(&c.AccessKey, "store-access-key", "", "S3 access key id or GCS HMAC access id")
+	fs.StringVar(&c.SecretKey, "store-secret-key", "", "S3 secret access key or GCS HMAC secret")
+	return c
+}
+
+// openBlobStore resolves a --store URL to an implementation. GCS is reached
+// through its S3-compatible XML API using HMAC interoperability keys.
+func openBlobStore(c blobConfig) (BlobStore, error) {
+	raw := strings.TrimSpace(c.URL)
+	if raw == "" {
+		return nil, errors.New("blob store URL is empty")
+	}
+	if !strings.Contains(raw, "://") {
+		return &FSBlobStore{Root: raw}, nil
+	}
+	u, err := url.Parse(raw)
+	if err != nil {
+		return nil, fmt.Errorf("parse store URL: %w", err)
+	}
+	switch u.Scheme {
+	case "file":
+		return &FSBlobStore{Root: u.Path}, nil
+	case "s3", "gs":
+		if u.Host == "" {
+			return nil, fmt.Errorf("store URL %q has no bucket", raw)
+		}
+		if c.AccessKey == "" || c.SecretKey == "" {
+			return nil, errors.New("--store-access-key and --store-secret-key are required for object storage")
+		}
+		s := &S3BlobStore{
+			Bucket:    u.Host,
+			Prefix:    strings.Trim(u.Path, "/"),
+			Region:    c.Region,
+			Endpoint:  c.Endpoint,
+			AccessKey: c.AccessKey,
+			SecretKey: c.SecretKey,
+		}
+		if u.Scheme == "gs" {
+			s.Scheme = "gs"
+			s.Region = "auto"
+			if s.Endpoint == "" {
+				s.Endpoint = "https://storage.googleapis.com"
+			}
+		}
+		return s, nil
+	default:
+		return nil, fmt.Errorf("unsupported store scheme %q", u.Scheme)
+	}
+}
+
+func cleanBlobKey(key string) (string, error) {
+	k := path.Clean("/" + strings.TrimSpace(key))[1:]
+	if k == "" || k != strings.TrimPrefix(key, "/") {
+		return "", fmt.Errorf("invalid blob key %q", key)
+	}
+	return k, nil
+}
+
+// FSBlobStore stores blobs as files under Root.
+type FSBlobStore struct {
+	Root string
+}
+
+func (f *FSBlobStore) Put(_ context.Context, key string, data []byte, _ string) error {
+	k, err := cleanBlobKey(key)
+	if err != nil {
+		return err
+	}
+	dst := filepath.Join(f.Root, filepath.FromSlash(k))
+	if err := os.MkdirAll(filepath.Dir(dst), 0o755); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(dst), ".blob-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		_ = tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), dst)
+}
+
+func (f *FSBlobStore) Get(_ context.Context, key string) (io.ReadCloser, error) {
+	k, err := cleanBlobKey(key)
+	if err != nil {
+		return nil, err
+	}
+	fh, err := os.Open(filepath.Join(f.Root, filepath.FromSlash(k)))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, errBlobNotFound
+	}
+	return fh, err
+}
+
+func (f *FSBlobStore) Delete(_ context.Context, key string) error {
+	k, err := cleanBlobKey(key)
+	if err != nil {
+		return err
+	}
+	err = os.Remove(filepath.Join(f.Root, filepath.FromSlash(k)))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	return err
+}
+
+func (f *FSBlobStore) Describe(key string) string {
+	return filepath.Join(f.Root, filepath.FromSlash(key))
+}
+
+// S3BlobStore talks to S3 (or any S3-compatible API such as GCS interop or
+// MinIO) with path-style requests signed using AWS Signature Version 4.
+type S3BlobStore struct {
+	Scheme    string // "s3" (default) or "gs", only used by Describe
+	Bucket    string
+	Prefix    string
+	Region    string
+	Endpoint  string
+	AccessKey string
+	SecretKey string
+	Client    *http.Client
+}
+
+func (s *S3BlobStore) objectKey(key string) (string, error) {
+	k, err := cleanBlobKey(key)
+	if err != nil {
+		return "", err
+	}
+	if s.Prefix != "" {
+		k = s.Prefix + "/" + k
+	}
+	return k, nil
+}
+
+func (s *S3BlobStore) objectURL(objectKey string) (*url.URL, error) {
+	endpoint := s.Endpoint
+	if endpoint == "" {
+		endpoint = "https://s3." + s.Region + ".amazonaws.com"
+	}
+	u, err := url.Parse(strings.TrimRight(endpoint, "/"))
+	if err != nil {
+		return nil, err
+	}
+	u.Path = u.Path + "/" + s.Bucket + "/" + objectKey
+	u.RawPath = awsEscape(u.Path)
+	return u, nil
+}
+
+func (s *S3BlobStore) Put(ctx context.Context, key string, data []byte, contentType string) error {
+	k, err := s.objectKey(key)
+	if err != nil {
+		return err
+	}
+	u, err := s.objectURL(k)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, u.String(), bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	res, err := s.do(req, data)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode >= 300 {
+		msg, _ := io.ReadAll(io.LimitReader(res.Body, 512))
+		return fmt.Errorf("put %s: unexpected status %d: %s", s.Describe(key), res.StatusCode, strings.TrimSpace(string(msg)))
+	}
+	return nil
+}
+
+func (s *S3BlobStore) Get(ctx context.Context, key string) (io.ReadCloser, error) {
+	k, err := s.objectKey(key)
+	if err != nil {
+		return nil, err
+	}
+	u, err := s.objectURL(k)
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	res, err := s.do(req, nil)
+	if err != nil {
+		return nil, err
+	}
+	if res.StatusCode == http.StatusNotFound {
+		res.Body.Close()
+		return nil, errBlobNotFound
+	}
+	if res.StatusCode >= 300 {
+		res.Body.Close()
+		return nil, fmt.Errorf("get %s: unexpected status %d", s.Describe(key), res.StatusCode)
+	}
+	return res.Body, nil
+}
+
+func (s *S3BlobStore) Delete(ctx context.Context, key string) error {
+	k, err := s.objectKey(key)
+	if err != nil {
+		return err
+	}
+	u, err := s.objectURL(k)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodDelete, u.String(), nil)
+	if err != nil {
+		return err
+	}
+	res, err := s.do(req, nil)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode >= 300 && res.StatusCode != http.StatusNotFound {
+		return fmt.Errorf("delete %s: unexpected status %d", s.Describe(key), res.StatusCode)
+	}
+	return nil
+}
+
+func (s *S3BlobStore) Describe(key string) string {
+	scheme := s.Scheme
+	if scheme == "" {
+		scheme = "s3"
+	}
+	k, err := s.objectKey(key)
+	if err != nil {
+		k = key
+	}
+	return scheme + "://" + s.Bucket + "/" + k
+}
+
+func (s *S3BlobStore) do(req *http.Request, payload []byte) (*http.Response, error) {
+	signAWSv4(req, payload, s.AccessKey, s.SecretKey, s.Region, "s3", time.Now().UTC())
+	client := s.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	return client.Do(req)
+}
+
+// signAWSv4 adds SigV4 headers for a request without query parameters.
+func signAWSv4(req *http.Request, payload []byte, accessKey, secretKey, region, service string, now time.Time) {
+	amzDate := now.Format("20060102T150405Z")
+	date := now.Format("20060102")
+	payloadHash := sha256Hex(payload)
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+
+	headers := map[string]string{"host": req.URL.Host}
+	for name := range req.Header {
+		lower := strings.ToLower(name)
+		if lower == "content-type" || strings.HasPrefix(lower, "x-amz-") {
+			headers[lower] = strings.TrimSpace(req.Header.Get(name))
+		}
+	}
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var canonHeaders strings.Builder
+	for _, name := range names {
+		canonHeaders.WriteString(name + ":" + headers[name] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	canonical := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		"",
+		canonHeaders.String(),
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+	scope := date + "/" + region + "/" + service + "/aws4_request"
+	toSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + sha256Hex([]byte(canonical))
+
+	key := hmacSHA256([]byte("AWS4"+secretKey), date)
+	key = hmacSHA256(key, region)
+	key = hmacSHA256(key, service)
+	key = hmacSHA256(key, "aws4_request")
+	sig := hex.EncodeToString(hmacSHA256(key, toSign))
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s", accessKey, scope, signedHeaders, sig))
+}
+
+func hmacSHA256(key []byte, msg string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(msg))
+	return mac.Sum(nil)
+}
+
+func sha256Hex(b []byte) string {
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:])
+}
+
+// awsEscape percent-encodes everything except RFC 3986 unreserved characters
+// and '/', as SigV4 canonical URIs require.
+func awsEscape(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if c == '/' || c == '-' || c == '_' || c == '.' || c == '~' ||
+			('a' <= c && c <= 'z') || ('A' <= c && c <= 'Z') || ('0' <= c && c <= '9') {
+			b.WriteByte(c)
+			continue
+		}
+		fmt.Fprintf(&b, "%%%02X", c)
+	}
+	return b.String()
+}
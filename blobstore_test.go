@@ -0,0 +1,107 @@
+package main
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestFSBlobStoreRoundTrip(t *testing.T) {
+	store, err := openBlobStore(blobConfig{URL: t.TempDir()})
+	if err != nil {
+		t.Fatalf("openBlobStore: %v", err)
+	}
+	ctx := context.Background()
+	if err := store.Put(ctx, "notes/2026-02-10.md", []byte("hello"), "text/markdown"); err != nil {
+		t.Fatalf("put: %v", err)
+	}
+	rc, err := store.Get(ctx, "notes/2026-02-10.md")
+	if err != nil {
+		t.Fatalf("get: %v", err)
+	}
+	defer rc.Close()
+	if b, _ := io.ReadAll(rc); string(b) != "hello" {
+		t.Fatalf("unexpected content %q", b)
+	}
+	if _, err := store.Get(ctx, "missing.md"); err != errBlobNotFound {
+		t.Fatalf("expected errBlobNotFound, got %v", err)
+	}
+	if err := store.Put(ctx, "../escape.md", []byte("x"), ""); err == nil {
+		t.Fatalf("expected key outside root to be rejected")
+	}
+	if err := store.Delete(ctx, "notes/2026-02-10.md"); err != nil {
+		t.Fatalf("delete: %v", err)
+	}
+	if _, err := store.Get(ctx, "notes/2026-02-10.md"); err != errBlobNotFound {
+		t.Fatalf("expected deleted blob to be gone, got %v", err)
+	}
+	if err := store.Delete(ctx, "notes/2026-02-10.md"); err != nil {
+		t.Fatalf("deleting a missing blob should succeed: %v", err)
+	}
+}
+
+func TestS3BlobStoreSignsRequests(t *testing.T) {
+	var mu sync.Mutex
+	objects := map[string]string{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		auth := r.Header.Get("Authorization")
+		if !strings.HasPrefix(auth, "AWS4-HMAC-SHA256 Credential=AKID/") || !strings.Contains(auth, "/us-east-1/s3/aws4_request") {
+			http.Error(w, "bad auth", http.StatusForbidden)
+			return
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		switch r.Method {
+		case http.MethodPut:
+			b, _ := io.ReadAll(r.Body)
+			objects[r.URL.EscapedPath()] = string(b)
+		case http.MethodGet:
+			body, ok := objects[r.URL.EscapedPath()]
+			if !ok {
+				http.NotFound(w, r)
+				return
+			}
+			_, _ = io.WriteString(w, body)
+		case http.MethodDelete:
+			delete(objects, r.URL.EscapedPath())
+			w.WriteHeader(http.StatusNoContent)
+		}
+	}))
+	defer srv.Close()
+
+	store, err := openBlobStore(blobConfig{URL: "s3://devlog/exports", Region: "us-east-1", Endpoint: srv.URL, AccessKey: "AKID", SecretKey: "secret"})
+	if err != nil {
+		t.Fatalf("openBlobStore: %v", err)
+	}
+	ctx := context.Background()
+	if err := store.Put(ctx, "audit 2026-02.jsonl", []byte("{}\n"), "application/x-ndjson"); err != nil {
+		t.Fatalf("put: %v", err)
+	}
+	if _, ok := objects["/devlog/exports/audit%202026-02.jsonl"]; !ok {
+		t.Fatalf("object stored under unexpected path: %v", objects)
+	}
+	rc, err := store.Get(ctx, "audit 2026-02.jsonl")
+	if err != nil {
+		t.Fatalf("get: %v", err)
+	}
+	defer rc.Close()
+	if b, _ := io.ReadAll(rc); string(b) != "{}\n" {
+		t.Fatalf("unexpected content %q", b)
+	}
+	if _, err := store.Get(ctx, "missing"); err != errBlobNotFound {
+		t.Fatalf("expected errBlobNotFound, got %v", err)
+	}
+	if err := store.Delete(ctx, "audit 2026-02.jsonl"); err != nil {
+		t.Fatalf("delete: %v", err)
+	}
+	if _, ok := objects["/devlog/exports/audit%202026-02.jsonl"]; ok {
+		t.Fatalf("object not deleted: %v", objects)
+	}
+	if got := store.Describe("a.jsonl"); got != "s3://devlog/exports/a.jsonl" {
+		t.Fatalf("unexpected describe %q", got)
+	}
+}
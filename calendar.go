@@ -0,0 +1,376 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	googleAuthURL     = "https://accounts.google.com/o/oauth2/v2/auth"
+	googleTokenURL    = "https://oauth2.googleapis.com/token"
+	googleCalendarAPI = "https://www.googleapis.com/calendar/v3"
+	calendarScope     = "https://www.googleapis.com/auth/calendar.readonly"
+)
+
+// GoogleCalendar holds OAuth client settings for per-user calendar consent.
+// Endpoint fields default to Google's production URLs when empty.
+type GoogleCalendar struct {
+	ClientID     string
+	ClientSecret string
+	RedirectURL  string
+	AuthURL      string
+	TokenURL     string
+	APIBase      string
+	Client       *http.Client
+}
+
+// Refresh tokens grant read access to a user's calendar for as long as the
+// consent stands, so calendar_links stores them sealed (seal.go) with
+// calendarKey, read from --calendar-key-file or generated next to the
+// database. Losing the key only means users connect their calendars again.
+
+// loadCalendarKey reads the calendar key file, generating the default one
+// (<db>.calendar-key) on first use.
+func loadCalendarKey(path, dbPath string, logger *log.Logger) ([]byte, error) {
+	var key []byte
+	var err error
+	if path != "" {
+		key, err = readKeyFile(path)
+	} else {
+		var created bool
+		path = dbPath + ".calendar-key"
+		key, created, err = readOrCreateKeyFile(path)
+		if created {
+			logger.Printf("event=calendar_key_generated path=%s", path)
+		}
+	}
+	if err != nil {
+		return nil, fmt.Errorf("calendar key: %w", err)
+	}
+	return deriveKey(key, "calendar refresh tokens"), nil
+}
+
+func calendarTokenAAD(userID int64) string {
+	return fmt.Sprintf("calendar_links:%d", userID)
+}
+
+// sealCalendarLinks seals refresh tokens stored before they were encrypted.
+func (a *App) sealCalendarLinks() error {
+	if a.calendarKey == nil {
+		return nil
+	}
+	rows, err := a.db.Query(`SELECT user_id, refresh_token FROM calendar_links WHERE refresh_token NOT LIKE ?`, sealedPrefix+"%")
+	if err != nil {
+		return err
+	}
+	plain := map[int64]string{}
+	for rows.Next() {
+		var id int64
+		var token string
+		if err := rows.Scan(&id, &token); err != nil {
+			_ = rows.Close()
+			return err
+		}
+		plain[id] = token
+	}
+	_ = rows.Close()
+	for id, token := range plain {
+		sealed, err := sealString(a.calendarKey, calendarTokenAAD(id), token)
+		if err != nil {
+			return err
+		}
+		if _, err := a.db.Exec(`UPDATE calendar_links SET refresh_token = ? WHERE user_id = ?`, sealed, id); err != nil {
+			return err
+		}
+	}
+	if len(plain) > 0 {
+		a.logger.Printf("event=calendar_links_sealed count=%d", len(plain))
+	}
+	return nil
+}
+
+type meetingLoad struct {
+	Meetings int
+	Duration time.Duration
+}
+
+func (g *GoogleCalendar) httpClient() *http.Client {
+	if g.Client != nil {
+		return g.Client
+	}
+	return http.DefaultClient
+}
+
+func orDefault(v, def string) string {
+	if v == "" {
+		return def
+	}
+	return v
+}
+
+func (g *GoogleCalendar) consentURL(state string) string {
+	q := url.Values{}
+	q.Set("client_id", g.ClientID)
+	q.Set("redirect_uri", g.RedirectURL)
+	q.Set("response_type", "code")
+	q.Set("scope", calendarScope)
+	q.Set("access_type", "offline")
+	q.Set("prompt", "consent")
+	q.Set("state", state)
+	return orDefault(g.AuthURL, googleAuthURL) + "?" + q.Encode()
+}
+
+// token exchanges either an authorization code or a refresh token.
+func (g *GoogleCalendar) token(ctx context.Context, form url.Values) (accessToken, refreshToken string, err error) {
+	form.Set("client_id", g.ClientID)
+	form.Set("client_secret", g.ClientSecret)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, orDefault(g.TokenURL, googleTokenURL), strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", "", err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	res, err := g.httpClient().Do(req)
+	if err != nil {
+		return "", "", err
+	}
+	defer res.Body.Close()
+	if res.StatusCode >= 300 {
+		return "", "", fmt.Errorf("token endpoint status %d", res.StatusCode)
+	}
+	var body struct {
+		AccessToken  string `json:"access_token"`
+		RefreshToken string `json:"refresh_token"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&body); err != nil {
+		return "", "", err
+	}
+	return body.AccessToken, body.RefreshToken, nil
+}
+
+// dayLoad counts timed, busy events on the primary calendar for day (UTC).
+func (g *GoogleCalendar) dayLoad(ctx context.Context, refreshToken, day string) (meetingLoad, error) {
+	access, _, err := g.token(ctx, url.Values{"grant_type": {"refresh_token"}, "refresh_token": {refreshToken}})
+	if err != nil {
+		return meetingLoad{}, err
+	}
+	start, err := time.Parse("2006-01-02", day)
+	if err != nil {
+		return meetingLoad{}, err
+	}
+	q := url.Values{}
+	q.Set("timeMin", start.Format(time.RFC3339))
+	q.Set("timeMax", start.AddDate(0, 0, 1).Format(time.RFC3339))
+	q.Set("singleEvents", "true")
+	q.Set("maxResults", "250")
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, orDefault(g.APIBase, googleCalendarAPI)+"/calendars/primary/events?"+q.Encode(), nil)
+	if err != nil {
+		return meetingLoad{}, err
+	}
+	req.Header.Set("Authorization", "Bearer "+access)
+	res, err := g.httpClient().Do(req)
+	if err != nil {
+		return meetingLoad{}, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode >= 300 {
+		return meetingLoad{}, fmt.Errorf("calendar events status %d", res.StatusCode)
+	}
+	var body struct {
+		Items []struct {
+			Status       string `json:"status"`
+			Transparency string `json:"transparency"`
+			Start        struct {
+				DateTime string `json:"dateTime"`
+			} `json:"start"`
+			End struct {
+				DateTime string `json:"dateTime"`
+			} `json:"end"`
+		} `json:"items"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&body); err != nil {
+		return meetingLoad{}, err
+	}
+	var load meetingLoad
+	for _, it := range body.Items {
+		if it.Status == "cancelled" || it.Transparency == "transparent" || it.Start.DateTime == "" {
+			continue
+		}
+		s, err1 := time.Parse(time.RFC3339, it.Start.DateTime)
+		e, err2 := time.Parse(time.RFC3339, it.End.DateTime)
+		if err1 != nil || err2 != nil || !e.After(s) {
+			continue
+		}
+		load.Meetings++
+		load.Duration += e.Sub(s)
+	}
+	return load, nil
+}
+
+// meetingLoadSummary fetches every linked user's calendar for day and renders
+// the "Meeting load" trailer appended to the daily compact. It returns an
+// empty string when calendars are not configured or nobody is linked.
+func (a *App) meetingLoadSummary(ctx context.Context, day string) string {
+	if a.calendar == nil {
+		return ""
+	}
+	rows, err := a.db.Query(`
+SELECT u.id, u.username, c.refresh_token
+FROM calendar_links c
+JOIN users u ON u.id = c.user_id
+ORDER BY u.username ASC`)
+	if err != nil {
+		a.logger.Printf("event=calendar_links_error day=%s err=%v", day, err)
+		return ""
+	}
+	type link struct {
+		userID            int64
+		username, refresh string
+	}
+	var links []link
+	for rows.Next() {
+		var l link
+		if err := rows.Scan(&l.userID, &l.username, &l.refresh); err != nil {
+			_ = rows.Close()
+			return ""
+		}
+		links = append(links, l)
+	}
+	_ = rows.Close()
+
+	loads := map[string]meetingLoad{}
+	for _, l := range links {
+		refresh, err := openString(a.calendarKey, calendarTokenAAD(l.userID), l.refresh)
+		if err != nil {
+			a.logger.Printf("event=calendar_token_unreadable day=%s user=%s err=%v", day, l.username, err)
+			continue
+		}
+		load, err := a.calendar.dayLoad(ctx, refresh, day)
+		if err != nil {
+			a.logger.Printf("event=calendar_fetch_failed day=%s user=%s err=%v", day, l.username, err)
+			continue
+		}
+		loads[l.username] = load
+	}
+	if len(loads) == 0 {
+		return ""
+	}
+	users := make([]string, 0, len(loads))
+	for u := range loads {
+		users = append(users, u)
+	}
+	sort.Strings(users)
+	var b strings.Builder
+	b.WriteString(meetingLoadHeader)
+	for _, u := range users {
+		fmt.Fprintf(&b, "- %s: %d meeting(s), %s\n", u, loads[u].Meetings, loads[u].Duration.Round(time.Minute))
+	}
+	return b.String()
+}
+
+// meetingLoadHeader starts the meeting-load trailer appended to compacts.
+const meetingLoadHeader = "\nMeeting load:\n"
+
+func (a *App) handleMyCalendar(w http.ResponseWriter, r *http.Request, u AuthedUser) {
+	if a.calendar == nil {
+		jsonErr(w, http.StatusNotFound, "calendar integration is not configured")
+		return
+	}
+	switch r.Method {
+	case http.MethodGet:
+		var n int
+		if err := a.db.QueryRow(`SELECT COUNT(*) FROM calendar_links WHERE user_id = ?`, u.ID).Scan(&n); err != nil {
+			jsonErr(w, http.StatusInternalServerError, "failed to query calendar link")
+			return
+		}
+		jsonOut(w, http.StatusOK, map[string]any{"connected": n > 0})
+	case http.MethodPost:
+		state, err := randomHex(16)
+		if err != nil {
+			jsonErr(w, http.StatusInternalServerError, "failed to start calendar consent")
+			return
+		}
+		if _, err := a.db.Exec(`INSERT INTO oauth_states(state, user_id, created_at) VALUES(?, ?, ?)`, state, u.ID, nowUTC()); err != nil {
+			jsonErr(w, http.StatusInternalServerError, "failed to start calendar consent")
+			return
+		}
+		_ = a.logUserAction(u, "calendar_connect_start", "-")
+		jsonOut(w, http.StatusOK, map[string]string{"auth_url": a.calendar.consentURL(state)})
+	case http.MethodDelete:
+		if _, err := a.db.Exec(`DELETE FROM calendar_links WHERE user_id = ?`, u.ID); err != nil {
+			jsonErr(w, http.StatusInternalServerError, "failed to remove calendar link")
+			return
+		}
+		_ = a.logUserAction(u, "calendar_disconnect", "-")
+		jsonOut(w, http.StatusOK, map[string]string{"status": "disconnected"})
+	default:
+		jsonErr(w, http.StatusMethodNotAllowed, "method not allowed")
+	}
+}
+
+// handleCalendarCallback completes the OAuth consent started by
+// POST /api/me/calendar. The one-time state identifies the user.
+func (a *App) handleCalendarCallback(w http.ResponseWriter, r *http.Request) {
+	if a.calendar == nil {
+		jsonErr(w, http.StatusNotFound, "calendar integration is not configured")
+		return
+	}
+	state := r.URL.Query().Get("state")
+	code := r.URL.Query().Get("code")
+	if state == "" || code == "" {
+		jsonErr(w, http.StatusBadRequest, "state and code are required")
+		return
+	}
+	var userID int64
+	var createdAt string
+	err := a.db.QueryRow(`SELECT user_id, created_at FROM oauth_states WHERE state = ?`, state).Scan(&userID, &createdAt)
+	if err != nil {
+		jsonErr(w, http.StatusBadRequest, "unknown or expired state")
+		return
+	}
+	_, _ = a.db.Exec(`DELETE FROM oauth_states WHERE state = ?`, state)
+	if t, err := time.Parse(time.RFC3339, createdAt); err != nil || time.Since(t) > 15*time.Minute {
+		jsonErr(w, http.StatusBadRequest, "unknown or expired state")
+		return
+	}
+
+	_, refresh, err := a.calendar.token(r.Context(), url.Values{
+		"grant_type":   {"authorization_code"},
+		"code":         {code},
+		"redirect_uri": {a.calendar.RedirectURL},
+	})
+	if err != nil || refresh == "" {
+		jsonErr(w, http.StatusBadGateway, "failed to exchange authorization code")
+		return
+	}
+	sealed, err := sealString(a.calendarKey, calendarTokenAAD(userID), refresh)
+	if err != nil {
+		jsonErr(w, http.StatusInternalServerError, "failed to store calendar link")
+		return
+	}
+	if _, err := a.db.Exec(`
+INSERT INTO calendar_links(user_id, refresh_token, created_at) VALUES(?, ?, ?)
+ON CONFLICT(user_id) DO UPDATE SET refresh_token = excluded.refresh_token, created_at = excluded.created_at`, userID, sealed, nowUTC()); err != nil {
+		jsonErr(w, http.StatusInternalServerError, "failed to store calendar link")
+		return
+	}
+	_ = a.logActorAction(actorCalendar, "calendar_connected", fmt.Sprintf("user_id=%d", userID))
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	_, _ = w.Write([]byte("Calendar connected. You can close this tab.\n"))
+}
+
+func randomHex(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
@@ -0,0 +1,259 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeGoogle serves the OAuth token endpoint and the events list. Codes and
+// refresh tokens map to users; events are keyed by the access token minted
+// from a refresh token.
+type fakeGoogle struct {
+	codes  map[string]string // authorization code -> refresh token
+	events map[string]string // refresh token -> events JSON
+}
+
+func (f *fakeGoogle) server(t *testing.T) *httptest.Server {
+	t.Helper()
+	mux := http.NewServeMux()
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		_ = r.ParseForm()
+		if r.Form.Get("client_id") != "cid" || r.Form.Get("client_secret") != "csecret" {
+			http.Error(w, "bad client", http.StatusUnauthorized)
+			return
+		}
+		switch r.Form.Get("grant_type") {
+		case "authorization_code":
+			refresh, ok := f.codes[r.Form.Get("code")]
+			if !ok || r.Form.Get("redirect_uri") != "https://devlog.example.com/api/calendar/callback" {
+				http.Error(w, "bad code", http.StatusBadRequest)
+				return
+			}
+			_ = json.NewEncoder(w).Encode(map[string]string{"access_token": "first", "refresh_token": refresh})
+		case "refresh_token":
+			if _, ok := f.events[r.Form.Get("refresh_token")]; !ok {
+				http.Error(w, "invalid_grant", http.StatusBadRequest)
+				return
+			}
+			_ = json.NewEncoder(w).Encode(map[string]string{"access_token": "access-" + r.Form.Get("refresh_token")})
+		default:
+			http.Error(w, "bad grant", http.StatusBadRequest)
+		}
+	})
+	mux.HandleFunc("/calendars/primary/events", func(w http.ResponseWriter, r *http.Request) {
+		refresh := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer access-")
+		body, ok := f.events[refresh]
+		if !ok {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		if r.URL.Query().Get("timeMin") != "2026-02-17T00:00:00Z" || r.URL.Query().Get("singleEvents") != "true" {
+			http.Error(w, "bad window", http.StatusBadRequest)
+			return
+		}
+		_, _ = w.Write([]byte(body))
+	})
+	srv := httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func newCalendarApp(t *testing.T, f *fakeGoogle) *App {
+	t.Helper()
+	app := newTestApp(t)
+	srv := f.server(t)
+	app.calendar = &GoogleCalendar{
+		ClientID: "cid", ClientSecret: "csecret", RedirectURL: "https://devlog.example.com/api/calendar/callback",
+		AuthURL: srv.URL + "/auth", TokenURL: srv.URL + "/token", APIBase: srv.URL, Client: srv.Client(),
+	}
+	app.calendarKey = deriveKey([]byte("test calendar key"), "calendar refresh tokens")
+	return app
+}
+
+const aliceEvents = `{"items":[
+ {"status":"confirmed","start":{"dateTime":"2026-02-17T09:00:00Z"},"end":{"dateTime":"2026-02-17T10:00:00Z"}},
+ {"status":"confirmed","start":{"dateTime":"2026-02-17T14:00:00Z"},"end":{"dateTime":"2026-02-17T14:30:00Z"}},
+ {"status":"cancelled","start":{"dateTime":"2026-02-17T11:00:00Z"},"end":{"dateTime":"2026-02-17T12:00:00Z"}},
+ {"status":"confirmed","transparency":"transparent","start":{"dateTime":"2026-02-17T12:00:00Z"},"end":{"dateTime":"2026-02-17T13:00:00Z"}},
+ {"status":"confirmed","start":{"date":"2026-02-17"},"end":{"date":"2026-02-18"}}
+]}`
+
+// connectCalendar runs the consent flow for token's user and returns the
+// callback response.
+func connectCalendar(t *testing.T, app *App, h http.Handler, token, code string) *httptest.ResponseRecorder {
+	t.Helper()
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, authedReq(t, http.MethodPost, "/api/me/calendar", nil, token))
+	var start struct {
+		AuthURL string `json:"auth_url"`
+	}
+	if err := json.Unmarshal(rr.Body.Bytes(), &start); err != nil || rr.Code != http.StatusOK {
+		t.Fatalf("start consent: %d %s", rr.Code, rr.Body.String())
+	}
+	u, err := url.Parse(start.AuthURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if u.Query().Get("access_type") != "offline" || u.Query().Get("scope") != calendarScope {
+		t.Fatalf("consent url %s", start.AuthURL)
+	}
+	cb := httptest.NewRecorder()
+	h.ServeHTTP(cb, httptest.NewRequest(http.MethodGet, "/api/calendar/callback?"+url.Values{"state": {u.Query().Get("state")}, "code": {code}}.Encode(), nil))
+	return cb
+}
+
+func TestCalendarCallback(t *testing.T) {
+	f := &fakeGoogle{codes: map[string]string{"good": "refresh-alice"}, events: map[string]string{"refresh-alice": aliceEvents}}
+	app := newCalendarApp(t, f)
+	h := newTestMux(app)
+	createUser(t, app, "alice", "PUDCALALIC1")
+
+	if rr := connectCalendar(t, app, h, "PUDCALALIC1", "good"); rr.Code != http.StatusOK {
+		t.Fatalf("callback: %d %s", rr.Code, rr.Body.String())
+	}
+	var userID int64
+	var stored string
+	if err := app.db.QueryRow(`SELECT user_id, refresh_token FROM calendar_links`).Scan(&userID, &stored); err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(stored, "refresh-alice") || !isSealed(stored) {
+		t.Fatalf("refresh token stored unsealed: %q", stored)
+	}
+	if got, err := openString(app.calendarKey, calendarTokenAAD(userID), stored); err != nil || got != "refresh-alice" {
+		t.Fatalf("open stored token = %q, %v", got, err)
+	}
+	// Sealed values are bound to their row.
+	if _, err := openString(app.calendarKey, calendarTokenAAD(userID+1), stored); err == nil {
+		t.Fatal("sealed token opened for another user")
+	}
+
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, authedReq(t, http.MethodGet, "/api/me/calendar", nil, "PUDCALALIC1"))
+	if !strings.Contains(rr.Body.String(), `"connected":true`) {
+		t.Fatalf("status: %s", rr.Body.String())
+	}
+
+	if _, err := app.db.Exec(`INSERT INTO oauth_states(state, user_id, created_at) VALUES('stale', ?, ?)`, userID, time.Now().Add(-time.Hour).UTC().Format(time.RFC3339)); err != nil {
+		t.Fatal(err)
+	}
+	for _, tc := range []struct {
+		name, query string
+		want        int
+	}{
+		{"missing code", "state=abc", http.StatusBadRequest},
+		{"unknown state", "state=nope&code=good", http.StatusBadRequest},
+		{"expired state", "state=stale&code=good", http.StatusBadRequest},
+		// The stale state was consumed by the previous attempt.
+		{"reused state", "state=stale&code=good", http.StatusBadRequest},
+	} {
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/api/calendar/callback?"+tc.query, nil))
+		if rr.Code != tc.want {
+			t.Errorf("%s: %d %s", tc.name, rr.Code, rr.Body.String())
+		}
+	}
+	if rr := connectCalendar(t, app, h, "PUDCALALIC1", "bad"); rr.Code != http.StatusBadGateway {
+		t.Fatalf("rejected code: %d %s", rr.Code, rr.Body.String())
+	}
+
+	app.calendar = nil
+	rr = httptest.NewRecorder()
+	h.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/api/calendar/callback?state=x&code=y", nil))
+	if rr.Code != http.StatusNotFound {
+		t.Fatalf("unconfigured callback: %d", rr.Code)
+	}
+}
+
+func TestMeetingLoadSummary(t *testing.T) {
+	f := &fakeGoogle{
+		codes:  map[string]string{"a": "refresh-alice", "b": "refresh-bob"},
+		events: map[string]string{"refresh-alice": aliceEvents},
+	}
+	app := newCalendarApp(t, f)
+	var logs bytes.Buffer
+	app.logger = log.New(&logs, "", 0)
+	h := newTestMux(app)
+	createUser(t, app, "alice", "PUDCALALIC2")
+	createUser(t, app, "bob", "PUDCALBOB02")
+	for token, code := range map[string]string{"PUDCALALIC2": "a", "PUDCALBOB02": "b"} {
+		if rr := connectCalendar(t, app, h, token, code); rr.Code != http.StatusOK {
+			t.Fatalf("connect: %d %s", rr.Code, rr.Body.String())
+		}
+	}
+
+	// bob's refresh token was revoked at Google: his line is skipped, not the summary.
+	got := app.meetingLoadSummary(context.Background(), "2026-02-17")
+	if got != meetingLoadHeader+"- alice: 2 meeting(s), 1h30m0s\n" {
+		t.Fatalf("summary = %q", got)
+	}
+	if !strings.Contains(logs.String(), "event=calendar_fetch_failed day=2026-02-17 user=bob") {
+		t.Fatalf("missing fetch failure log:\n%s", logs.String())
+	}
+
+	// The compact carries the trailer.
+	if _, err := app.db.Exec(`INSERT INTO entries(user_id, entry_type, content, created_at) VALUES(1, 'normal', 'retro prep', '2026-02-17T08:00:00Z')`); err != nil {
+		t.Fatal(err)
+	}
+	if err := app.compactDay("2026-02-17"); err != nil {
+		t.Fatalf("compactDay: %v", err)
+	}
+	var compact string
+	if err := app.db.QueryRow(`SELECT content FROM entries WHERE entry_type = 'daily_compact'`).Scan(&compact); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasSuffix(compact, meetingLoadHeader+"- alice: 2 meeting(s), 1h30m0s\n") {
+		t.Fatalf("compact:\n%s", compact)
+	}
+
+	// Links sealed under another key are skipped, and nobody linked means no trailer.
+	app.calendarKey = deriveKey([]byte("lost key"), "calendar refresh tokens")
+	if got := app.meetingLoadSummary(context.Background(), "2026-02-17"); got != "" {
+		t.Fatalf("summary with wrong key = %q", got)
+	}
+	if !strings.Contains(logs.String(), "event=calendar_token_unreadable") {
+		t.Fatalf("missing unreadable log:\n%s", logs.String())
+	}
+}
+
+func TestSealCalendarLinks(t *testing.T) {
+	app := newCalendarApp(t, &fakeGoogle{})
+	createUser(t, app, "alice", "PUDCALSEAL1")
+	if _, err := app.db.Exec(`INSERT INTO calendar_links(user_id, refresh_token, created_at) VALUES(1, 'plain-refresh', ?)`, nowUTC()); err != nil {
+		t.Fatal(err)
+	}
+	if err := app.sealCalendarLinks(); err != nil {
+		t.Fatalf("sealCalendarLinks: %v", err)
+	}
+	var stored string
+	if err := app.db.QueryRow(`SELECT refresh_token FROM calendar_links`).Scan(&stored); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := openString(app.calendarKey, calendarTokenAAD(1), stored); err != nil || got != "plain-refresh" {
+		t.Fatalf("sealed legacy token = %q, %v", got, err)
+	}
+}
+
+func TestLoadCalendarKey(t *testing.T) {
+	dbPath := filepath.Join(t.TempDir(), "devlog.db")
+	logger := log.New(&bytes.Buffer{}, "", 0)
+	first, err := loadCalendarKey("", dbPath, logger)
+	if err != nil {
+		t.Fatalf("generate: %v", err)
+	}
+	again, err := loadCalendarKey("", dbPath, logger)
+	if err != nil || !bytes.Equal(first, again) {
+		t.Fatalf("reload = %x, %v; want %x", again, err, first)
+	}
+	if _, err := loadCalendarKey(filepath.Join(t.TempDir(), "missing"), dbPath, logger); err == nil {
+		t.Fatal("an explicit key file that does not exist must not be generated")
+	}
+}
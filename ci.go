@@ -0,0 +1,250 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// CI build results: CI systems POST status events to /api/inbound/ci, signed
+// with --ci-secret-file. Builds are recorded in ci_builds and folded into one
+// rolling "[ci] Builds on <day>" entry per UTC day by the ci_builds service user,
+// rewritten on every event, so the day view shows pass/fail counts and links
+// instead of an entry per build. Once compaction merges the day's entry, the
+// next event starts a new one.
+
+const (
+	ciMaxBody       = 64 << 10
+	ciEntryBuilds   = 20
+	ciSignatureHead = "X-Devlog-Signature"
+	ciPassed        = "passed"
+	ciFailed        = "failed"
+)
+
+// ciOutcome maps the status words of common CI systems to passed or failed.
+// Non-final states (running, canceled, ...) return "" and are ignored; ok is
+// false for words it does not know.
+func ciOutcome(status string) (outcome string, ok bool) {
+	switch strings.ToLower(strings.TrimSpace(status)) {
+	case "success", "succeeded", "successful", "passed", "pass", "ok", "fixed", "green":
+		return ciPassed, true
+	case "failure", "failed", "fail", "error", "errored", "broken", "timed_out", "red":
+		return ciFailed, true
+	case "pending", "queued", "running", "started", "in_progress", "canceled", "cancelled", "aborted", "skipped", "neutral":
+		return "", true
+	}
+	return "", false
+}
+
+// verifyCISignature checks "sha256=" + hex(HMAC-SHA256(secret, body)), the
+// format GitHub-style webhooks use.
+func verifyCISignature(secret, body []byte, header string) bool {
+	got, ok := strings.CutPrefix(strings.TrimSpace(header), "sha256=")
+	if !ok {
+		return false
+	}
+	mac := hmac.New(sha256.New, secret)
+	mac.Write(body)
+	return hmac.Equal([]byte(hex.EncodeToString(mac.Sum(nil))), []byte(strings.ToLower(got)))
+}
+
+type ciEvent struct {
+	Pipeline string `json:"pipeline"`
+	BuildID  string `json:"build_id"`
+	Status   string `json:"status"`
+	URL      string `json:"url"`
+	Commit   string `json:"commit"`
+	Branch   string `json:"branch"`
+}
+
+// handleInboundCI serves POST /api/inbound/ci
+// {"pipeline","build_id","status","url","commit","branch"}.
+func (a *App) handleInboundCI(w http.ResponseWriter, r *http.Request) {
+	if len(a.ciSecret) == 0 {
+		jsonErr(w, http.StatusNotFound, "inbound CI events are not configured")
+		return
+	}
+	if r.Method != http.MethodPost {
+		jsonErr(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, ciMaxBody))
+	if err != nil {
+		jsonErr(w, http.StatusRequestEntityTooLarge, "body too large")
+		return
+	}
+	if !verifyCISignature(a.ciSecret, body, r.Header.Get(ciSignatureHead)) {
+		jsonErr(w, http.StatusUnauthorized, "invalid signature")
+		return
+	}
+	var ev ciEvent
+	if err := json.Unmarshal(body, &ev); err != nil {
+		jsonErr(w, http.StatusBadRequest, "invalid json")
+		return
+	}
+	ev.Pipeline = strings.TrimSpace(ev.Pipeline)
+	ev.BuildID = strings.TrimSpace(ev.BuildID)
+	ev.Branch = strings.TrimSpace(ev.Branch)
+	ev.Commit = strings.TrimSpace(ev.Commit)
+	if ev.Pipeline == "" || len(ev.Pipeline) > 200 || len(ev.BuildID) > 200 || len(ev.Branch) > 200 || len(ev.Commit) > 64 {
+		jsonErr(w, http.StatusBadRequest, "pipeline is required; pipeline, build_id and branch are at most 200 characters, commit 64")
+		return
+	}
+	if ev.URL = strings.TrimSpace(ev.URL); ev.URL != "" {
+		if u, err := url.Parse(ev.URL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			jsonErr(w, http.StatusBadRequest, "url must be an http(s) URL")
+			return
+		}
+	}
+	outcome, known := ciOutcome(ev.Status)
+	if !known {
+		jsonErr(w, http.StatusBadRequest, fmt.Sprintf("unknown status %q", ev.Status))
+		return
+	}
+	if outcome == "" {
+		jsonOut(w, http.StatusOK, map[string]string{"status": "ignored"})
+		return
+	}
+
+	a.ciMu.Lock()
+	defer a.ciMu.Unlock()
+	now := time.Now().UTC()
+	day := now.Format("2006-01-02")
+	if err := a.recordCIBuild(ev, outcome, day, now.Format(time.RFC3339)); err != nil {
+		jsonErr(w, http.StatusInternalServerError, "failed to record build")
+		return
+	}
+	meta := fmt.Sprintf("pipeline=%s build_id=%s status=%s", ev.Pipeline, ev.BuildID, outcome)
+	// The entry catches up with the next event once compaction is done.
+	if a.writeLocked.Load() {
+		_ = a.logActorAction(actorCI, "ci_build", meta+" entry=deferred")
+		jsonOut(w, http.StatusAccepted, map[string]string{"status": "recorded"})
+		return
+	}
+	id, created, err := a.updateCIEntry(day)
+	if err != nil {
+		jsonErr(w, http.StatusInternalServerError, "failed to update builds entry")
+		return
+	}
+	_ = a.logActorAction(actorCI, "ci_build", fmt.Sprintf("%s entry_id=%d", meta, id))
+	status, state := http.StatusOK, "updated"
+	if created {
+		status, state = http.StatusCreated, "created"
+	}
+	jsonOut(w, status, map[string]any{"status": state, "entry_id": id, "url": a.entryURL(id, day)})
+}
+
+// recordCIBuild stores a build; an event for a known pipeline and build id
+// (a retry or re-run) replaces the earlier result.
+func (a *App) recordCIBuild(ev ciEvent, outcome, day, at string) error {
+	if ev.BuildID != "" {
+		res, err := a.db.Exec(`UPDATE ci_builds SET day = ?, status = ?, url = ?, commit_sha = ?, branch = ?, received_at = ? WHERE pipeline = ? AND build_id = ?`,
+			day, outcome, ev.URL, ev.Commit, ev.Branch, at, ev.Pipeline, ev.BuildID)
+		if err != nil {
+			return err
+		}
+		if n, _ := res.RowsAffected(); n > 0 {
+			return nil
+		}
+	}
+	_, err := a.db.Exec(`INSERT INTO ci_builds(day, pipeline, build_id, status, url, commit_sha, branch, received_at) VALUES(?, ?, ?, ?, ?, ?, ?, ?)`,
+		day, ev.Pipeline, ev.BuildID, outcome, ev.URL, ev.Commit, ev.Branch, at)
+	return err
+}
+
+// renderCIDay formats the day's builds: counts, then the newest builds with
+// their links.
+func (a *App) renderCIDay(day string) (string, error) {
+	var passed, failed int
+	if err := a.db.QueryRow(`
+SELECT COALESCE(SUM(status = 'passed'), 0), COALESCE(SUM(status = 'failed'), 0)
+FROM ci_builds WHERE day = ?`, day).Scan(&passed, &failed); err != nil {
+		return "", err
+	}
+	rows, err := a.db.Query(`
+SELECT pipeline, build_id, status, url, commit_sha, branch
+FROM ci_builds WHERE day = ?
+ORDER BY received_at DESC, id DESC
+LIMIT ?`, day, ciEntryBuilds)
+	if err != nil {
+		return "", err
+	}
+	defer rows.Close()
+	var b strings.Builder
+	fmt.Fprintf(&b, "[ci] Builds on %s: %d passed, %d failed", day, passed, failed)
+	for rows.Next() {
+		var ev ciEvent
+		if err := rows.Scan(&ev.Pipeline, &ev.BuildID, &ev.Status, &ev.URL, &ev.Commit, &ev.Branch); err != nil {
+			return "", err
+		}
+		fmt.Fprintf(&b, "\n- %s %s", ev.Status, ev.Pipeline)
+		if ev.BuildID != "" {
+			b.WriteString(" #" + ev.BuildID)
+		}
+		var ref []string
+		if ev.Branch != "" {
+			ref = append(ref, ev.Branch)
+		}
+		if ev.Commit != "" {
+			ref = append(ref, ev.Commit[:min(len(ev.Commit), 7)])
+		}
+		if len(ref) > 0 {
+			b.WriteString(" (" + strings.Join(ref, " ") + ")")
+		}
+		if ev.URL != "" {
+			b.WriteString(" " + ev.URL)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return "", err
+	}
+	if more := passed + failed - ciEntryBuilds; more > 0 {
+		fmt.Fprintf(&b, "\n- ... and %d earlier", more)
+	}
+	return b.String(), nil
+}
+
+// updateCIEntry rewrites the day's builds entry, or creates it when there is
+// none yet or the previous one has been compacted or deleted.
+func (a *App) updateCIEntry(day string) (id int64, created bool, err error) {
+	content, err := a.renderCIDay(day)
+	if err != nil {
+		return 0, false, err
+	}
+	// Build names and URLs come from the CI system; scan them like any entry.
+	content, secrets := a.scanImportedSecrets(content)
+	defer func() {
+		if err == nil {
+			a.logImportedSecrets(actorCI, id, secrets)
+		}
+	}()
+	err = a.db.QueryRow(`SELECT entry_id FROM ci_days WHERE day = ?`, day).Scan(&id)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return 0, false, err
+	}
+	if err == nil {
+		res, err := a.db.Exec(`UPDATE entries SET content = ?, edited_at = ? WHERE id = ? AND deleted_at IS NULL AND entry_type = 'normal'`, content, nowUTC(), id)
+		if err != nil {
+			return 0, false, err
+		}
+		if n, _ := res.RowsAffected(); n > 0 {
+			return id, false, nil
+		}
+	}
+	if id, err = a.insertEntry(actorCI.ID, content, "", sourceCI, nowUTC()); err != nil {
+		return 0, false, err
+	}
+	if _, err := a.db.Exec(`INSERT INTO ci_days(day, entry_id) VALUES(?, ?) ON CONFLICT(day) DO UPDATE SET entry_id = excluded.entry_id`, day, id); err != nil {
+		return 0, false, err
+	}
+	return id, true, nil
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestInboundCIBuilds(t *testing.T) {
+	app := newTestApp(t)
+	app.ciSecret = []byte("ci-secret")
+	h := newTestMux(app)
+	post := func(ev map[string]string, secret string) *httptest.ResponseRecorder {
+		body, _ := json.Marshal(ev)
+		mac := hmac.New(sha256.New, []byte(secret))
+		mac.Write(body)
+		req := httptest.NewRequest(http.MethodPost, "/api/inbound/ci", bytes.NewReader(body))
+		req.Header.Set("X-Devlog-Signature", "sha256="+hex.EncodeToString(mac.Sum(nil)))
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, req)
+		return rr
+	}
+	build := func(id, status string) map[string]string {
+		return map[string]string{"pipeline": "api/main", "build_id": id, "status": status, "url": "https://ci.example/api/" + id, "commit": "0123456789abcdef", "branch": "main"}
+	}
+
+	if rr := post(build("1", "success"), "wrong"); rr.Code != http.StatusUnauthorized {
+		t.Fatalf("bad signature: expected 401, got %d", rr.Code)
+	}
+	if rr := post(build("1", "exploded"), "ci-secret"); rr.Code != http.StatusBadRequest {
+		t.Fatalf("unknown status: expected 400, got %d", rr.Code)
+	}
+	if rr := post(build("1", "running"), "ci-secret"); rr.Code != http.StatusOK || !strings.Contains(rr.Body.String(), "ignored") {
+		t.Fatalf("running: %d %s", rr.Code, rr.Body.String())
+	}
+	rr := post(build("1", "success"), "ci-secret")
+	var first struct {
+		EntryID int64 `json:"entry_id"`
+	}
+	if err := json.Unmarshal(rr.Body.Bytes(), &first); err != nil || rr.Code != http.StatusCreated {
+		t.Fatalf("first build: %d %s", rr.Code, rr.Body.String())
+	}
+	for _, ev := range []map[string]string{build("2", "failure"), build("3", "failed"), build("3", "passed")} {
+		if rr := post(ev, "ci-secret"); rr.Code != http.StatusOK || !strings.Contains(rr.Body.String(), fmt.Sprintf(`"entry_id":%d`, first.EntryID)) {
+			t.Fatalf("later build: %d %s", rr.Code, rr.Body.String())
+		}
+	}
+	var n int
+	var content, user string
+	if err := app.db.QueryRow(`SELECT COUNT(*), MAX(e.content), MAX(u.username) FROM entries e JOIN users u ON u.id = e.user_id`).Scan(&n, &content, &user); err != nil {
+		t.Fatal(err)
+	}
+	// One rolling entry; the re-run of build 3 replaced its failure.
+	if n != 1 || user != "ci_builds" || !strings.Contains(content, ": 2 passed, 1 failed") || !strings.Contains(content, "- failed api/main #2 (main 0123456) https://ci.example/api/2") {
+		t.Fatalf("entries=%d user=%s content:\n%s", n, user, content)
+	}
+
+	// After compaction merged it, the next build starts a new entry.
+	if err := app.compactDay(time.Now().UTC().Format("2006-01-02")); err != nil {
+		t.Fatalf("compactDay: %v", err)
+	}
+	if rr := post(build("4", "success"), "ci-secret"); rr.Code != http.StatusCreated {
+		t.Fatalf("build after compaction: %d %s", rr.Code, rr.Body.String())
+	}
+}
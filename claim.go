@@ -0,0 +1,198 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Claim links: 'admin create-user --claim-link' and POST /api/admin/users
+// with "claim_link" hand out a one-time URL instead of the token, so the
+// credential never sits in a chat history or ticket. The link is the UI's
+// /claim page with the code in the fragment, which browsers do not send and
+// chat unfurlers therefore cannot burn. The page reveals the token with
+// POST /api/claim; that call rotates the user's default token (the one
+// provisioned with the user was never shown to anyone), returns it and burns
+// the link in the same step. Only the SHA-256 of the code is stored.
+
+const (
+	defaultClaimTTL = 72 * time.Hour
+	maxClaimTTL     = 30 * 24 * time.Hour
+	claimCodeBytes  = 24
+	claimRateLimit  = 10 // claim attempts per client per minute
+)
+
+var (
+	errClaimNotFound = errors.New("claim link not found")
+	errClaimUsed     = errors.New("claim link was already used")
+	errClaimExpired  = errors.New("claim link has expired")
+	errClaimDisabled = errors.New("user is disabled")
+)
+
+func hashClaimCode(code string) string {
+	sum := sha256.Sum256([]byte(code))
+	return hex.EncodeToString(sum[:])
+}
+
+// parseClaimTTL reads a claim link lifetime like parseTokenTTL; "" is
+// defaultClaimTTL.
+func parseClaimTTL(s string) (time.Duration, error) {
+	if strings.TrimSpace(s) == "" {
+		return defaultClaimTTL, nil
+	}
+	ttl, err := parseTokenTTL(s)
+	if err != nil {
+		return 0, err
+	}
+	if ttl <= 0 || ttl > maxClaimTTL {
+		return 0, errors.New("claim link lifetime must be at most 30d")
+	}
+	return ttl, nil
+}
+
+// createClaimLink adds a claim link for userID and returns its id, URL and
+// expiry. The code exists only in the returned URL.
+func (a *App) createClaimLink(userID int64, createdBy string, ttl time.Duration) (int64, string, string, error) {
+	raw := make([]byte, claimCodeBytes)
+	if _, err := rand.Read(raw); err != nil {
+		return 0, "", "", err
+	}
+	code := base64.RawURLEncoding.EncodeToString(raw)
+	expires := time.Now().Add(ttl).UTC().Format(time.RFC3339)
+	res, err := a.db.Exec(`INSERT INTO claim_links(user_id, code_hash, created_by, created_at, expires_at) VALUES(?, ?, ?, ?, ?)`,
+		userID, hashClaimCode(code), createdBy, nowUTC(), expires)
+	if err != nil {
+		return 0, "", "", err
+	}
+	id, _ := res.LastInsertId()
+	return id, a.uiURL("/claim", nil, code), expires, nil
+}
+
+// claimedToken is what a successful claim reveals.
+type claimedToken struct {
+	Username  string `json:"username"`
+	Token     string `json:"token"`
+	ExpiresAt string `json:"expires_at,omitempty"`
+	claimID   int64
+}
+
+// claimToken burns the link for code and returns a fresh default token for
+// its user. The link is burned and the token minted in one transaction: two
+// concurrent claims cannot both succeed, and a failed rotation leaves the
+// link usable.
+func (a *App) claimToken(code, clientIP string, now time.Time) (claimedToken, error) {
+	var c claimedToken
+	var userID int64
+	var expiresAt string
+	var claimedAt sql.NullString
+	var disabled bool
+	tx, err := a.db.Begin()
+	if err != nil {
+		return c, err
+	}
+	defer func() { _ = tx.Rollback() }()
+	err = tx.QueryRow(`
+SELECT l.id, l.user_id, u.username, u.disabled, l.expires_at, l.claimed_at
+FROM claim_links l
+JOIN users u ON u.id = l.user_id
+WHERE l.code_hash = ?`, hashClaimCode(code)).Scan(&c.claimID, &userID, &c.Username, &disabled, &expiresAt, &claimedAt)
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		return c, errClaimNotFound
+	case err != nil:
+		return c, err
+	case claimedAt.Valid:
+		return c, errClaimUsed
+	case expiresAt <= now.UTC().Format(time.RFC3339):
+		return c, errClaimExpired
+	case disabled:
+		return c, errClaimDisabled
+	}
+	res, err := tx.Exec(`UPDATE claim_links SET claimed_at = ?, claimed_ip = ? WHERE id = ? AND claimed_at IS NULL`, nowUTC(), clientIP, c.claimID)
+	if err != nil {
+		return c, err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return c, errClaimUsed
+	}
+	token, err := a.rotateTokenTx(tx, userID, defaultTokenName)
+	if err != nil {
+		return c, err
+	}
+	var expires sql.NullString
+	if err := tx.QueryRow(`SELECT expires_at FROM tokens WHERE user_id = ? AND name = ?`, userID, defaultTokenName).Scan(&expires); err != nil {
+		return c, err
+	}
+	if err := tx.Commit(); err != nil {
+		return c, err
+	}
+	c.Token, c.ExpiresAt = token, expires.String
+	return c, nil
+}
+
+// handleClaim serves POST /api/claim {"code":...}. It needs no token: the
+// code is the credential.
+func (a *App) handleClaim(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		jsonErr(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	now := time.Now()
+	client := a.clientIP(r)
+	if !a.claimLimit.allow(client, now) {
+		a.logger.Printf("event=claim_rate_limited client=%s", client)
+		jsonErr(w, http.StatusTooManyRequests, "too many requests")
+		return
+	}
+	var req struct {
+		Code string `json:"code"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		jsonErr(w, http.StatusBadRequest, "invalid json")
+		return
+	}
+	code := strings.TrimPrefix(strings.TrimSpace(req.Code), "#")
+	if code == "" {
+		jsonErr(w, http.StatusBadRequest, "code is required")
+		return
+	}
+	c, err := a.claimToken(code, client, now)
+	switch {
+	case errors.Is(err, errClaimNotFound):
+		a.logger.Printf("event=claim_failed reason=not_found client=%s", client)
+		jsonErr(w, http.StatusNotFound, err.Error())
+		return
+	case errors.Is(err, errClaimUsed):
+		a.logger.Printf("event=claim_failed reason=used claim_id=%d client=%s", c.claimID, client)
+		jsonErr(w, http.StatusGone, err.Error())
+		return
+	case errors.Is(err, errClaimExpired):
+		a.logger.Printf("event=claim_failed reason=expired claim_id=%d client=%s", c.claimID, client)
+		jsonErr(w, http.StatusGone, err.Error())
+		return
+	case errors.Is(err, errClaimDisabled):
+		jsonErr(w, http.StatusForbidden, err.Error())
+		return
+	case err != nil:
+		a.logger.Printf("event=claim_failed claim_id=%d err=%v", c.claimID, err)
+		jsonErr(w, http.StatusInternalServerError, "failed to claim token")
+		return
+	}
+	_ = a.writeActionLog("api_user", c.Username, "", client, "claim_token", fmt.Sprintf("claim_id=%d expires_at=%s", c.claimID, c.ExpiresAt))
+	w.Header().Set("Cache-Control", "no-store")
+	jsonOut(w, http.StatusOK, c)
+}
+
+// handleClaimUI serves the /claim page that reveals a claim link's token.
+func (a *App) handleClaimUI(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Referrer-Policy", "no-referrer")
+	renderUI(w, "templates/claim.html", uiPageData{Title: "PUD Dev Log Token", BasePath: a.basePath, APIBase: a.apiBase()})
+}
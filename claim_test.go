@@ -0,0 +1,182 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// newClaimApp returns an app with an admin "root" and a function that
+// creates a user with a claim link and returns the link's code.
+func newClaimApp(t *testing.T) (*App, http.Handler, func(username string) string) {
+	t.Helper()
+	app := newTestApp(t)
+	app.externalURL = "https://devlog.example.com"
+	h := newTestMux(app)
+	createUser(t, app, "root", "PUDCLAIMADM")
+	if _, err := app.db.Exec(`UPDATE users SET role = 'admin' WHERE username = 'root'`); err != nil {
+		t.Fatalf("promote admin: %v", err)
+	}
+	newLink := func(username string) string {
+		t.Helper()
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, authedReq(t, http.MethodPost, "/api/admin/users", map[string]any{"username": username, "claim_link": true}, "PUDCLAIMADM"))
+		if rr.Code != http.StatusCreated {
+			t.Fatalf("create %s: %d %s", username, rr.Code, rr.Body.String())
+		}
+		var nu map[string]any
+		_ = json.Unmarshal(rr.Body.Bytes(), &nu)
+		link, _ := nu["claim_url"].(string)
+		if _, ok := nu["token"]; ok || !strings.HasPrefix(link, "https://devlog.example.com/claim#") || nu["claim_expires_at"] == nil {
+			t.Fatalf("create %s response: %s", username, rr.Body.String())
+		}
+		return link[strings.Index(link, "#")+1:]
+	}
+	return app, h, newLink
+}
+
+func postClaim(t *testing.T, h http.Handler, body any) *httptest.ResponseRecorder {
+	t.Helper()
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, authedReq(t, http.MethodPost, "/api/claim", body, ""))
+	return rr
+}
+
+func TestClaimLinks(t *testing.T) {
+	app, h, newLink := newClaimApp(t)
+	code := newLink("kim")
+	rr := postClaim(t, h, map[string]string{"code": "#" + code})
+	if rr.Code != http.StatusOK || rr.Header().Get("Cache-Control") != "no-store" {
+		t.Fatalf("claim: %d %s", rr.Code, rr.Body.String())
+	}
+	var got claimedToken
+	_ = json.Unmarshal(rr.Body.Bytes(), &got)
+	if got.Username != "kim" || got.Token == "" {
+		t.Fatalf("claimed %+v", got)
+	}
+	me := httptest.NewRecorder()
+	h.ServeHTTP(me, authedReq(t, http.MethodGet, "/api/me", nil, got.Token))
+	if me.Code != http.StatusOK {
+		t.Fatalf("claimed token on /api/me: %d %s", me.Code, me.Body.String())
+	}
+	var claimedAt, claimedIP string
+	if err := app.db.QueryRow(`SELECT claimed_at, claimed_ip FROM claim_links l JOIN users u ON u.id = l.user_id WHERE u.username = 'kim'`).Scan(&claimedAt, &claimedIP); err != nil || claimedAt == "" || claimedIP == "" {
+		t.Fatalf("burned link: at=%q ip=%q err=%v", claimedAt, claimedIP, err)
+	}
+	var audited int
+	if err := app.db.QueryRow(`SELECT COUNT(*) FROM action_logs WHERE action = 'claim_token' AND actor_username = 'kim'`).Scan(&audited); err != nil || audited != 1 {
+		t.Fatalf("claim_token audit rows = %d, %v", audited, err)
+	}
+}
+
+func TestClaimLinkErrors(t *testing.T) {
+	cases := []struct {
+		name  string
+		setup func(t *testing.T, app *App, h http.Handler, newLink func(string) string) any // returns the request body
+		want  int
+	}{
+		{"used", func(t *testing.T, _ *App, h http.Handler, newLink func(string) string) any {
+			code := newLink("kim")
+			if rr := postClaim(t, h, map[string]string{"code": code}); rr.Code != http.StatusOK {
+				t.Fatalf("first claim: %d %s", rr.Code, rr.Body.String())
+			}
+			return map[string]string{"code": code}
+		}, http.StatusGone},
+		{"expired", func(t *testing.T, app *App, _ http.Handler, newLink func(string) string) any {
+			code := newLink("lee")
+			if _, err := app.db.Exec(`UPDATE claim_links SET expires_at = '2000-01-01T00:00:00Z'`); err != nil {
+				t.Fatal(err)
+			}
+			return map[string]string{"code": code}
+		}, http.StatusGone},
+		{"disabled user", func(t *testing.T, app *App, _ http.Handler, newLink func(string) string) any {
+			code := newLink("max")
+			if _, err := app.db.Exec(`UPDATE users SET disabled = 1 WHERE username = 'max'`); err != nil {
+				t.Fatal(err)
+			}
+			return map[string]string{"code": code}
+		}, http.StatusForbidden},
+		{"unknown code", func(*testing.T, *App, http.Handler, func(string) string) any {
+			return map[string]string{"code": "not-a-code"}
+		}, http.StatusNotFound},
+		{"empty code", func(*testing.T, *App, http.Handler, func(string) string) any {
+			return map[string]string{"code": " # "}
+		}, http.StatusBadRequest},
+		{"invalid json", func(*testing.T, *App, http.Handler, func(string) string) any {
+			return []string{"code"}
+		}, http.StatusBadRequest},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			app, h, newLink := newClaimApp(t)
+			body := tc.setup(t, app, h, newLink)
+			var before int
+			if err := app.db.QueryRow(`SELECT COUNT(*) FROM claim_links WHERE claimed_at IS NOT NULL`).Scan(&before); err != nil {
+				t.Fatal(err)
+			}
+			rr := postClaim(t, h, body)
+			if rr.Code != tc.want || strings.Contains(rr.Body.String(), `"token"`) {
+				t.Fatalf("status = %d, want %d: %s", rr.Code, tc.want, rr.Body.String())
+			}
+			var after int
+			if err := app.db.QueryRow(`SELECT COUNT(*) FROM claim_links WHERE claimed_at IS NOT NULL`).Scan(&after); err != nil || after != before {
+				t.Fatalf("a failed claim burned a link: %d -> %d, %v", before, after, err)
+			}
+		})
+	}
+
+	_, h, _ := newClaimApp(t)
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/api/claim", nil))
+	if rr.Code != http.StatusMethodNotAllowed {
+		t.Fatalf("GET /api/claim: %d", rr.Code)
+	}
+}
+
+func TestClaimRotationFailureKeepsLink(t *testing.T) {
+	app, h, newLink := newClaimApp(t)
+	code := newLink("kim")
+	for _, trigger := range []string{
+		`CREATE TRIGGER fail_token_insert BEFORE INSERT ON tokens BEGIN SELECT RAISE(ABORT, 'token write failed'); END`,
+		`CREATE TRIGGER fail_token_update BEFORE UPDATE ON tokens BEGIN SELECT RAISE(ABORT, 'token write failed'); END`,
+	} {
+		if _, err := app.db.Exec(trigger); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if rr := postClaim(t, h, map[string]string{"code": code}); rr.Code != http.StatusInternalServerError {
+		t.Fatalf("claim with failing rotation: %d %s", rr.Code, rr.Body.String())
+	}
+	var burned int
+	if err := app.db.QueryRow(`SELECT COUNT(*) FROM claim_links WHERE claimed_at IS NOT NULL`).Scan(&burned); err != nil || burned != 0 {
+		t.Fatalf("failed rotation burned %d link(s), %v", burned, err)
+	}
+	if _, err := app.db.Exec(`DROP TRIGGER fail_token_insert; DROP TRIGGER fail_token_update`); err != nil {
+		t.Fatal(err)
+	}
+	if rr := postClaim(t, h, map[string]string{"code": code}); rr.Code != http.StatusOK {
+		t.Fatalf("retry: %d %s", rr.Code, rr.Body.String())
+	}
+}
+
+func TestParseClaimTTL(t *testing.T) {
+	for _, tc := range []struct {
+		in   string
+		want string
+		ok   bool
+	}{
+		{"", defaultClaimTTL.String(), true},
+		{"24h", "24h0m0s", true},
+		{"30d", maxClaimTTL.String(), true},
+		{"31d", "", false},
+		{"0", "", false},
+		{"soon", "", false},
+	} {
+		got, err := parseClaimTTL(tc.in)
+		if (err == nil) != tc.ok || (tc.ok && got.String() != tc.want) {
+			t.Errorf("parseClaimTTL(%q) = %v, %v", tc.in, got, err)
+		}
+	}
+}
@@ -0,0 +1,266 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCompactStoresStructuredData(t *testing.T) {
+	app := newTestApp(t)
+	h := newTestMux(app)
+	createUser(t, app, "alice", "PUDABCDEF12")
+
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, authedReq(t, http.MethodPost, "/api/entries", map[string]string{"content": "fixed login\nand wrote [tests]"}, "PUDABCDEF12"))
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("expected 201, got %d", rr.Code)
+	}
+	day := time.Now().UTC().Format("2006-01-02")
+	if err := app.compactDay(day); err != nil {
+		t.Fatalf("compactDay: %v", err)
+	}
+	legacy := "Daily compact for 2020-01-01\n\n[2020-01-01T09:00:00Z][bob] old\\nformat\n"
+	if _, err := app.db.Exec(`INSERT INTO entries(user_id, entry_type, content, created_at) VALUES(?, 'daily_compact', ?, '2020-01-01T17:00:00Z')`, actorSystem.ID, legacy); err != nil {
+		t.Fatalf("insert legacy compact: %v", err)
+	}
+	if err := app.backfillCompactData(); err != nil {
+		t.Fatalf("backfillCompactData: %v", err)
+	}
+
+	rows, err := app.db.Query(`SELECT compact_data FROM entries WHERE entry_type = 'daily_compact' ORDER BY created_at DESC`)
+	if err != nil {
+		t.Fatalf("query: %v", err)
+	}
+	defer rows.Close()
+	var got [][]compactSource
+	for rows.Next() {
+		var data string
+		if err := rows.Scan(&data); err != nil {
+			t.Fatalf("scan: %v", err)
+		}
+		var sources []compactSource
+		if err := json.Unmarshal([]byte(data), &sources); err != nil {
+			t.Fatalf("unmarshal %q: %v", data, err)
+		}
+		got = append(got, sources)
+	}
+	if len(got) != 2 || len(got[0]) != 1 || len(got[1]) != 1 {
+		t.Fatalf("unexpected compact data %+v", got)
+	}
+	if s := got[0][0]; s.User != "alice" || s.Content != "fixed login\nand wrote [tests]" || s.EntryID == 0 {
+		t.Fatalf("unexpected source %+v", s)
+	}
+	if s := got[1][0]; s.User != "bob" || s.Content != "old\nformat" {
+		t.Fatalf("unexpected backfilled source %+v", s)
+	}
+}
+
+func TestTwoPhaseCompactionResumesAndVerifies(t *testing.T) {
+	app := newTestApp(t)
+	h := newTestMux(app)
+	createUser(t, app, "alice", "PUDPHASEAL1")
+	for _, content := range []string{"first", "second"} {
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, authedReq(t, http.MethodPost, "/api/entries", map[string]string{"content": content}, "PUDPHASEAL1"))
+		if rr.Code != http.StatusCreated {
+			t.Fatalf("create: %d", rr.Code)
+		}
+	}
+	day := time.Now().UTC().Format("2006-01-02")
+	count := func(entryType string) int {
+		t.Helper()
+		var n int
+		if err := app.db.QueryRow(`SELECT COUNT(*) FROM entries WHERE entry_type = ?`, entryType).Scan(&n); err != nil {
+			t.Fatalf("count: %v", err)
+		}
+		return n
+	}
+
+	// A crash after the produce phase leaves the compact next to intact sources.
+	if done, err := app.produceCompact(day, "", time.Now()); err != nil || done {
+		t.Fatalf("produceCompact: done=%v err=%v", done, err)
+	}
+	if phase, _ := app.compactionPhase(day); phase != compactionProduced || count("normal") != 2 || count("daily_compact") != 1 {
+		t.Fatalf("after produce: phase=%q normal=%d compacts=%d", phase, count("normal"), count("daily_compact"))
+	}
+	if open, err := app.integritySelfCheck(); err != nil || len(open) != 0 {
+		t.Fatalf("a produced day is not an integrity issue: %+v %v", open, err)
+	}
+
+	// A source changed between the phases: the delete is aborted, sources are kept.
+	if _, err := app.db.Exec(`UPDATE entries SET content = 'tampered' WHERE content = 'first'`); err != nil {
+		t.Fatalf("tamper: %v", err)
+	}
+	if err := app.recoverCompactions(); err != nil {
+		t.Fatalf("recoverCompactions: %v", err)
+	}
+	if phase, _ := app.compactionPhase(day); phase != "" || count("normal") != 2 || count("daily_compact") != 0 {
+		t.Fatalf("after failed verify: phase=%q normal=%d compacts=%d", phase, count("normal"), count("daily_compact"))
+	}
+
+	// The next run starts over; resuming after a clean produce deletes the sources.
+	if _, err := app.produceCompact(day, "", time.Now()); err != nil {
+		t.Fatalf("produceCompact: %v", err)
+	}
+	if err := app.recoverCompactions(); err != nil {
+		t.Fatalf("recoverCompactions: %v", err)
+	}
+	if phase, _ := app.compactionPhase(day); phase != compactionDone || count("normal") != 0 || count("daily_compact") != 1 {
+		t.Fatalf("after resume: phase=%q normal=%d compacts=%d", phase, count("normal"), count("daily_compact"))
+	}
+	var content string
+	if err := app.db.QueryRow(`SELECT content FROM entries WHERE entry_type = 'daily_compact'`).Scan(&content); err != nil || !strings.Contains(content, "tampered") {
+		t.Fatalf("compact content = %q (%v)", content, err)
+	}
+	var actions int
+	if err := app.db.QueryRow(`SELECT COUNT(*) FROM action_logs WHERE action IN ('compaction_verify_failed', 'daily_compact')`).Scan(&actions); err != nil || actions != 2 {
+		t.Fatalf("expected one verify failure and one compaction in the audit log, got %d (%v)", actions, err)
+	}
+}
+
+func TestCompactionJournalRecovery(t *testing.T) {
+	app := newTestApp(t)
+	h := newTestMux(app)
+	createUser(t, app, "alice", "PUDJOURNAL1")
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, authedReq(t, http.MethodPost, "/api/entries", map[string]string{"content": "keep me"}, "PUDJOURNAL1"))
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("create: %d", rr.Code)
+	}
+	day := time.Now().UTC().Format("2006-01-02")
+	steps := func() []string {
+		t.Helper()
+		rows, err := app.compactionJournal(day)
+		if err != nil {
+			t.Fatalf("compactionJournal: %v", err)
+		}
+		var out []string
+		for _, j := range rows {
+			out = append(out, j.Step)
+		}
+		return out
+	}
+
+	// The process died inside the produce transaction: only "started" committed.
+	if err := journalCompaction(app.db, day, journalStarted, ""); err != nil {
+		t.Fatalf("journal: %v", err)
+	}
+	if err := app.recoverCompactions(); err != nil {
+		t.Fatalf("recoverCompactions: %v", err)
+	}
+	if got := strings.Join(steps(), ","); got != "started,rolled_back" {
+		t.Fatalf("journal after rollback = %s", got)
+	}
+
+	// The process died between produce and delete: recovery resumes the day.
+	if err := journalCompaction(app.db, day, journalStarted, ""); err != nil {
+		t.Fatalf("journal: %v", err)
+	}
+	if _, err := app.produceCompact(day, "", time.Now()); err != nil {
+		t.Fatalf("produceCompact: %v", err)
+	}
+	if err := app.recoverCompactions(); err != nil {
+		t.Fatalf("recoverCompactions: %v", err)
+	}
+	if got := strings.Join(steps(), ","); got != "started,rolled_back,started,produced,resumed,done" {
+		t.Fatalf("journal after resume = %s", got)
+	}
+	var outcomes string
+	if err := app.db.QueryRow(`SELECT group_concat(metadata, '|') FROM action_logs WHERE action = 'compaction_recovered'`).Scan(&outcomes); err != nil {
+		t.Fatalf("audit: %v", err)
+	}
+	if !strings.Contains(outcomes, "outcome=rolled_back sources=1") || !strings.Contains(outcomes, "outcome=done") {
+		t.Fatalf("unexpected recovery audit rows: %s", outcomes)
+	}
+
+	// Nothing is left to recover.
+	if err := app.recoverCompactions(); err != nil || len(steps()) != 6 {
+		t.Fatalf("second recovery changed the journal: %v %v", steps(), err)
+	}
+}
+
+func TestCompactionSchedule(t *testing.T) {
+	app := newTestApp(t)
+	createUser(t, app, "alice", "PUDSCHEDUL1")
+	if _, err := app.db.Exec(`INSERT INTO entries(user_id, entry_type, content, created_at) SELECT id, 'normal', 'late west coast work', '2026-03-10T20:00:00Z' FROM users WHERE username = 'alice'`); err != nil {
+		t.Fatal(err)
+	}
+	hour, minute, off, err := parseCompactAt("17:30")
+	if err != nil || hour != 17 || minute != 30 || off {
+		t.Fatalf("parseCompactAt: %d %d %t %v", hour, minute, off, err)
+	}
+	if _, _, _, err := parseCompactAt("25:00"); err == nil {
+		t.Fatal("invalid --compact-at accepted")
+	}
+	app.compactionHour, app.compactionMinute = &hour, minute
+	app.compactionTZ = time.FixedZone("UTC-5", -5*3600)
+	ran := func(at string) bool {
+		t.Helper()
+		now, _ := time.Parse(time.RFC3339, at)
+		if err := app.compactionTick(now); err != nil {
+			t.Fatalf("tick at %s: %v", at, err)
+		}
+		done, err := app.compactionAlreadyRan("2026-03-10")
+		if err != nil {
+			t.Fatal(err)
+		}
+		return done
+	}
+	// 17:29 in UTC-5 is 22:29 UTC, well past the default 17:00 UTC.
+	if ran("2026-03-10T22:29:00Z") {
+		t.Fatal("compacted before 17:30 in --compact-tz")
+	}
+	app.compactionOff = true
+	if ran("2026-03-10T22:30:00Z") {
+		t.Fatal("compacted with --compact-at off")
+	}
+	app.compactionOff = false
+	if !ran("2026-03-10T22:30:00Z") {
+		t.Fatal("not compacted at 17:30 in --compact-tz")
+	}
+}
+
+func TestAdminCompact(t *testing.T) {
+	app := newTestApp(t)
+	h := newTestMux(app)
+	createUser(t, app, "alice", "PUDCOMPACT1")
+	createUser(t, app, "root", "PUDCOMPACT2")
+	if _, err := app.db.Exec(`UPDATE users SET role = 'admin' WHERE username = 'root'`); err != nil {
+		t.Fatal(err)
+	}
+	do := func(method, path, token string) *httptest.ResponseRecorder {
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, authedReq(t, method, path, nil, token))
+		return rr
+	}
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, authedReq(t, http.MethodPost, "/api/entries", map[string]string{"content": "retry me"}, "PUDCOMPACT1"))
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("post: %d %s", rr.Code, rr.Body.String())
+	}
+	today := time.Now().Format("2006-01-02")
+	if rr := do(http.MethodPost, "/api/admin/compact?day="+today, "PUDCOMPACT1"); rr.Code != http.StatusForbidden {
+		t.Fatalf("member: expected 403, got %d", rr.Code)
+	}
+	for _, day := range []string{"", "yesterday", time.Now().AddDate(0, 0, 2).Format("2006-01-02")} {
+		if rr := do(http.MethodPost, "/api/admin/compact?day="+day, "PUDCOMPACT2"); rr.Code != http.StatusBadRequest {
+			t.Fatalf("day %q: expected 400, got %d", day, rr.Code)
+		}
+	}
+	var res struct {
+		Compaction compactionRow `json:"compaction"`
+		Already    bool          `json:"already_compacted"`
+	}
+	rr = do(http.MethodPost, "/api/admin/compact?day="+today, "PUDCOMPACT2")
+	if err := json.Unmarshal(rr.Body.Bytes(), &res); err != nil || rr.Code != http.StatusOK || res.Already || res.Compaction.MergedCount != 1 || res.Compaction.Phase != compactionDone {
+		t.Fatalf("compact: %d %s", rr.Code, rr.Body.String())
+	}
+	rr = do(http.MethodPost, "/api/admin/compact?day="+today, "PUDCOMPACT2")
+	if err := json.Unmarshal(rr.Body.Bytes(), &res); err != nil || rr.Code != http.StatusOK || !res.Already {
+		t.Fatalf("compact again: %d %s", rr.Code, rr.Body.String())
+	}
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+)
+
+// A serve config file holds flag values, so every serve flag can be set
+// from it without a second list of settings to keep in sync. Keys are flag
+// names ("api-addr" or "api_addr"); nested tables are joined with "-", so
+// [jira] url = "..." sets --jira-url. Lists become comma-separated values.
+//
+// Precedence: command-line flag > environment variable > config file >
+// built-in default.
+
+// flagEnv maps flags to the environment variables that can also set them.
+var flagEnv = map[string]string{
+	"api-addr": "DEVLOG_API_ADDR",
+	"ui-addr":  "DEVLOG_UI_ADDR",
+}
+
+// loadConfigFile parses a TOML (.toml) or YAML (.yaml, .yml) file into flat
+// flag name -> value pairs.
+func loadConfigFile(path string) (map[string]string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	raw := map[string]any{}
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".toml":
+		if err := toml.Unmarshal(data, &raw); err != nil {
+			return nil, fmt.Errorf("config %s: %w", path, err)
+		}
+	case ".yaml", ".yml":
+		if err := yaml.Unmarshal(data, &raw); err != nil {
+			return nil, fmt.Errorf("config %s: %w", path, err)
+		}
+	default:
+		return nil, fmt.Errorf("config %s: unsupported format (want .toml, .yaml or .yml)", path)
+	}
+	out := map[string]string{}
+	if err := flattenConfig("", raw, out); err != nil {
+		return nil, fmt.Errorf("config %s: %w", path, err)
+	}
+	return out, nil
+}
+
+func flattenConfig(prefix string, m map[string]any, out map[string]string) error {
+	for k, v := range m {
+		key := strings.ReplaceAll(strings.ToLower(strings.TrimSpace(k)), "_", "-")
+		if prefix != "" {
+			key = prefix + "-" + key
+		}
+		switch val := v.(type) {
+		case map[string]any:
+			if err := flattenConfig(key, val, out); err != nil {
+				return err
+			}
+		case []any:
+			items := make([]string, 0, len(val))
+			for _, item := range val {
+				switch item.(type) {
+				case map[string]any, []any:
+					return fmt.Errorf("%s: lists may only hold plain values", key)
+				}
+				items = append(items, fmt.Sprint(item))
+			}
+			out[key] = strings.Join(items, ",")
+		case nil:
+			out[key] = ""
+		default:
+			out[key] = fmt.Sprint(val)
+		}
+	}
+	return nil
+}
+
+// applyConfig sets every flag named in values that was not given on the
+// command line or through its environment variable. Unknown keys are an
+// error so a typo does not silently fall back to a default.
+func applyConfig(fs *flag.FlagSet, values map[string]string) error {
+	explicit := map[string]bool{}
+	fs.Visit(func(f *flag.Flag) { explicit[f.Name] = true })
+	keys := make([]string, 0, len(values))
+	for k := range values {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		f := fs.Lookup(k)
+		if f == nil || k == "config" {
+			return fmt.Errorf("config: unknown setting %q", k)
+		}
+		if explicit[k] {
+			continue
+		}
+		if env, ok := flagEnv[k]; ok && strings.TrimSpace(os.Getenv(env)) != "" {
+			continue
+		}
+		if err := fs.Set(k, values[k]); err != nil {
+			return fmt.Errorf("config: %s: %w", k, err)
+		}
+	}
+	return nil
+}
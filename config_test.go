@@ -0,0 +1,118 @@
+package main
+
+import (
+	"flag"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestServeConfigFile(t *testing.T) {
+	dir := t.TempDir()
+	tomlPath := filepath.Join(dir, "devlog.toml")
+	if err := os.WriteFile(tomlPath, []byte(`
+db = "/var/lib/devlog/devlog.db"
+api_addr = "127.0.0.1:9273"
+ui-addr = "127.0.0.1:9272"
+compaction_hour = 18
+cors_origins = ["https://devlog.example.com", "https://intranet.example.com"]
+git_repos = ["/srv/git/api", "/srv/git/web"]
+redact_secrets = false
+write_wait = "2s"
+
+[jira]
+url = "https://acme.atlassian.net"
+email = "bot@acme.com"
+`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	yamlPath := filepath.Join(dir, "devlog.yaml")
+	if err := os.WriteFile(yamlPath, []byte("db: /srv/devlog.db\njira:\n  url: https://acme.atlassian.net\ngit_repos:\n  - /srv/git/api\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	newFlags := func() (*flag.FlagSet, map[string]*string) {
+		fs := flag.NewFlagSet("serve", flag.ContinueOnError)
+		vals := map[string]*string{}
+		for _, name := range []string{"config", "db", "api-addr", "ui-addr", "cors-origins", "git-repos", "jira-url", "jira-email", "write-wait", "compaction-hour", "redact-secrets"} {
+			vals[name] = fs.String(name, "", "")
+		}
+		return fs, vals
+	}
+
+	fs, vals := newFlags()
+	if err := fs.Parse([]string{"--db", "/tmp/cli.db"}); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("DEVLOG_UI_ADDR", "127.0.0.1:9999")
+	values, err := loadConfigFile(tomlPath)
+	if err != nil {
+		t.Fatalf("load toml: %v", err)
+	}
+	if err := applyConfig(fs, values); err != nil {
+		t.Fatalf("apply: %v", err)
+	}
+	want := map[string]string{
+		"db":              "/tmp/cli.db", // flag wins
+		"api-addr":        "127.0.0.1:9273",
+		"ui-addr":         "", // env var wins (read by envOr as the flag default in serve)
+		"compaction-hour": "18",
+		"cors-origins":    "https://devlog.example.com,https://intranet.example.com",
+		"git-repos":       "/srv/git/api,/srv/git/web",
+		"redact-secrets":  "false",
+		"write-wait":      "2s",
+		"jira-url":        "https://acme.atlassian.net",
+		"jira-email":      "bot@acme.com",
+	}
+	for name, w := range want {
+		if got := *vals[name]; got != w {
+			t.Errorf("%s = %q, want %q", name, got, w)
+		}
+	}
+
+	fs, vals = newFlags()
+	values, err = loadConfigFile(yamlPath)
+	if err != nil {
+		t.Fatalf("load yaml: %v", err)
+	}
+	if err := applyConfig(fs, values); err != nil {
+		t.Fatalf("apply yaml: %v", err)
+	}
+	if *vals["db"] != "/srv/devlog.db" || *vals["jira-url"] != "https://acme.atlassian.net" || *vals["git-repos"] != "/srv/git/api" {
+		t.Fatalf("yaml values: db=%q jira=%q git=%q", *vals["db"], *vals["jira-url"], *vals["git-repos"])
+	}
+
+	if err := applyConfig(fs, map[string]string{"jira-ulr": "x"}); err == nil || !strings.Contains(err.Error(), "unknown setting") {
+		t.Fatalf("typo: expected unknown setting error, got %v", err)
+	}
+	if _, err := loadConfigFile(filepath.Join(dir, "devlog.ini")); err == nil {
+		t.Fatal("expected an error for a missing or unsupported file")
+	}
+}
+
+func TestCORSOrigins(t *testing.T) {
+	app := newTestApp(t)
+	app.corsOrigins = []string{"https://devlog.example.com"}
+	h := app.withCORS(http.HandlerFunc(app.handleHealth))
+	for origin, want := range map[string]string{
+		"https://devlog.example.com": "https://devlog.example.com",
+		"https://evil.example.com":   "",
+	} {
+		r := httptest.NewRequest(http.MethodOptions, "/api/health", nil)
+		r.Header.Set("Origin", origin)
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, r)
+		if got := rr.Header().Get("Access-Control-Allow-Origin"); got != want || rr.Code != http.StatusNoContent {
+			t.Errorf("%s: allow-origin %q (status %d), want %q", origin, got, rr.Code, want)
+		}
+	}
+	app.corsOrigins = nil
+	rr := httptest.NewRecorder()
+	app.withCORS(http.HandlerFunc(app.handleHealth)).ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/api/health", nil))
+	if got := rr.Header().Get("Access-Control-Allow-Origin"); got != "*" {
+		t.Fatalf("default: %q", got)
+	}
+}
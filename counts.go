@@ -0,0 +1,110 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// entry_counts holds live entries and content bytes per (day, user). Triggers
+// on entries keep it current inside the writing transaction, including the
+// delete+insert of compaction, so stats and list totals never scan a day.
+
+// entryCount is one entry_counts row as returned by /api/stats.
+type entryCount struct {
+	Day   string `json:"day"`
+	User  string `json:"user"`
+	Count int    `json:"count"`
+	Bytes int64  `json:"bytes"`
+}
+
+// backfillEntryCounts fills entry_counts for databases created before the
+// table existed. An empty table with live entries can only mean it was never
+// built.
+func (a *App) backfillEntryCounts() error {
+	var counted, live int
+	if err := a.db.QueryRow(`SELECT COUNT(*) FROM entry_counts`).Scan(&counted); err != nil {
+		return err
+	}
+	if counted > 0 {
+		return nil
+	}
+	if err := a.db.QueryRow(`SELECT COUNT(*) FROM entries WHERE deleted_at IS NULL`).Scan(&live); err != nil || live == 0 {
+		return err
+	}
+	_, err := a.db.Exec(`
+INSERT INTO entry_counts(day, user_id, count, bytes)
+SELECT date(created_at), COALESCE(user_id, -1), COUNT(*), SUM(length(CAST(content AS BLOB)))
+FROM entries WHERE deleted_at IS NULL
+GROUP BY date(created_at), COALESCE(user_id, -1)`)
+	return err
+}
+
+// dayEntryCount returns the number of live entries on day.
+func (a *App) dayEntryCount(day string) (int, error) {
+	return a.rangeEntryCount(day, day)
+}
+
+// rangeEntryCount returns the number of live entries from from to to, inclusive.
+func (a *App) rangeEntryCount(from, to string) (int, error) {
+	var n int
+	err := a.db.QueryRow(`SELECT COALESCE(SUM(count), 0) FROM entry_counts WHERE day BETWEEN ? AND ?`, from, to).Scan(&n)
+	return n, err
+}
+
+// dayRangeParams reads ?from=&to= days; to defaults to today and from to 29
+// days before to.
+func dayRangeParams(r *http.Request, today string) (string, string, error) {
+	to := strings.TrimSpace(r.URL.Query().Get("to"))
+	if to == "" {
+		to = today
+	}
+	from := strings.TrimSpace(r.URL.Query().Get("from"))
+	if from == "" {
+		if t, err := time.Parse("2006-01-02", to); err == nil {
+			from = t.AddDate(0, 0, -29).Format("2006-01-02")
+		}
+	}
+	return from, to, validDayRange(from, to)
+}
+
+// handleStats returns per-day, per-user entry counts and bytes for a day
+// range (default: the last 30 days), read from entry_counts.
+func (a *App) handleStats(w http.ResponseWriter, r *http.Request, u AuthedUser) {
+	if r.Method != http.MethodGet {
+		jsonErr(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	from, to, err := dayRangeParams(r, time.Now().Format("2006-01-02"))
+	if err != nil {
+		jsonErr(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	rows, err := a.db.Query(`
+SELECT c.day, u.username, c.count, c.bytes
+FROM entry_counts c
+JOIN users u ON u.id = c.user_id
+WHERE c.day BETWEEN ? AND ? AND c.count > 0
+ORDER BY c.day ASC, u.username ASC`, from, to)
+	if err != nil {
+		jsonErr(w, http.StatusInternalServerError, "failed to query stats")
+		return
+	}
+	defer rows.Close()
+	out := []entryCount{}
+	totalCount, totalBytes := 0, int64(0)
+	for rows.Next() {
+		var c entryCount
+		if err := rows.Scan(&c.Day, &c.User, &c.Count, &c.Bytes); err != nil {
+			jsonErr(w, http.StatusInternalServerError, "failed to parse stats")
+			return
+		}
+		totalCount += c.Count
+		totalBytes += c.Bytes
+		out = append(out, c)
+	}
+	_ = rows.Close()
+	_ = a.logUserAction(u, "get_stats", fmt.Sprintf("from=%s to=%s", from, to))
+	jsonOut(w, http.StatusOK, map[string]any{"from": from, "to": to, "counts": out, "total_count": totalCount, "total_bytes": totalBytes})
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestAPIListEntriesTruncationMetadata(t *testing.T) {
+	app := newTestApp(t)
+	h := newTestMux(app)
+	createUser(t, app, "alice", "PUDTRUNC123")
+	var ids []int64
+	for i := 0; i < 5; i++ {
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, authedReq(t, http.MethodPost, "/api/entries", map[string]string{"content": fmt.Sprintf("entry %d", i)}, "PUDTRUNC123"))
+		var created struct {
+			ID int64 `json:"id"`
+		}
+		if err := json.Unmarshal(rr.Body.Bytes(), &created); err != nil || rr.Code != http.StatusCreated {
+			t.Fatalf("create: %d %s", rr.Code, rr.Body.String())
+		}
+		ids = append(ids, created.ID)
+	}
+	day := time.Now().UTC().Format("2006-01-02")
+	list := func(limit int) (int, bool, int) {
+		t.Helper()
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, authedReq(t, http.MethodGet, fmt.Sprintf("/api/entries?day=%s&limit=%d", day, limit), nil, "PUDTRUNC123"))
+		var out struct {
+			Entries    []entryRow `json:"entries"`
+			TotalCount int        `json:"total_count"`
+			Truncated  bool       `json:"truncated"`
+		}
+		if err := json.Unmarshal(rr.Body.Bytes(), &out); err != nil {
+			t.Fatalf("decode list: %v body=%s", err, rr.Body.String())
+		}
+		return out.TotalCount, out.Truncated, len(out.Entries)
+	}
+	if total, truncated, n := list(2); total != 5 || !truncated || n != 2 {
+		t.Fatalf("limit 2: total=%d truncated=%t n=%d", total, truncated, n)
+	}
+	if total, truncated, n := list(10); total != 5 || truncated || n != 5 {
+		t.Fatalf("limit 10: total=%d truncated=%t n=%d", total, truncated, n)
+	}
+
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, authedReq(t, http.MethodDelete, fmt.Sprintf("/api/entries/%d", ids[0]), nil, "PUDTRUNC123"))
+	if total, _, _ := list(10); total != 4 {
+		t.Fatalf("expected 4 after trash, got %d", total)
+	}
+	rr = httptest.NewRecorder()
+	h.ServeHTTP(rr, authedReq(t, http.MethodPost, fmt.Sprintf("/api/entries/%d/restore", ids[0]), nil, "PUDTRUNC123"))
+	if total, _, _ := list(10); total != 5 {
+		t.Fatalf("expected 5 after restore, got %d", total)
+	}
+
+	if err := app.compactDay(day); err != nil {
+		t.Fatalf("compactDay: %v", err)
+	}
+	if total, truncated, n := list(10); total != 1 || truncated || n != 1 {
+		t.Fatalf("after compaction: total=%d truncated=%t n=%d", total, truncated, n)
+	}
+
+	if _, err := app.db.Exec(`DELETE FROM entry_counts`); err != nil {
+		t.Fatalf("reset counts: %v", err)
+	}
+	if err := app.backfillEntryCounts(); err != nil {
+		t.Fatalf("backfillEntryCounts: %v", err)
+	}
+	if n, err := app.dayEntryCount(day); err != nil || n != 1 {
+		t.Fatalf("backfilled count = %d err=%v", n, err)
+	}
+}
+
+func TestAPIStatsFromEntryCounts(t *testing.T) {
+	app := newTestApp(t)
+	h := newTestMux(app)
+	createUser(t, app, "alice", "PUDSTATSAL1")
+	createUser(t, app, "bob", "PUDSTATSBO1")
+	for token, content := range map[string]string{"PUDSTATSAL1": "12345", "PUDSTATSBO1": "héllo"} {
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, authedReq(t, http.MethodPost, "/api/entries", map[string]string{"content": content}, token))
+		if rr.Code != http.StatusCreated {
+			t.Fatalf("create: %d", rr.Code)
+		}
+	}
+	day := time.Now().UTC().Format("2006-01-02")
+	stats := func() map[string]entryCount {
+		t.Helper()
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, authedReq(t, http.MethodGet, "/api/stats?from="+day+"&to="+day, nil, "PUDSTATSAL1"))
+		var out struct {
+			Counts []entryCount `json:"counts"`
+		}
+		if err := json.Unmarshal(rr.Body.Bytes(), &out); err != nil || rr.Code != http.StatusOK {
+			t.Fatalf("stats: %d %s", rr.Code, rr.Body.String())
+		}
+		m := map[string]entryCount{}
+		for _, c := range out.Counts {
+			m[c.User] = c
+		}
+		return m
+	}
+	got := stats()
+	if got["alice"].Count != 1 || got["alice"].Bytes != 5 || got["bob"].Bytes != 6 {
+		t.Fatalf("unexpected stats before compaction: %+v", got)
+	}
+
+	if err := app.compactDay(day); err != nil {
+		t.Fatalf("compactDay: %v", err)
+	}
+	got = stats()
+	if _, ok := got["alice"]; ok || got["system"].Count != 1 {
+		t.Fatalf("expected counts to move to the compact, got %+v", got)
+	}
+	var compactBytes int64
+	if err := app.db.QueryRow(`SELECT length(CAST(content AS BLOB)) FROM entries WHERE entry_type = 'daily_compact'`).Scan(&compactBytes); err != nil {
+		t.Fatalf("compact bytes: %v", err)
+	}
+	if got["system"].Bytes != compactBytes {
+		t.Fatalf("compact bytes = %d, counter = %d", compactBytes, got["system"].Bytes)
+	}
+}
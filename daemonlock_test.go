@@ -0,0 +1,30 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestServeRefusesLockedDB(t *testing.T) {
+	dbPath := filepath.Join(t.TempDir(), "devlog.db")
+	release, err := acquireDBLock(dbPath)
+	if err != nil {
+		t.Fatalf("lock: %v", err)
+	}
+	if _, err := acquireDBLock(dbPath); !errors.Is(err, errDBLocked) || !strings.Contains(err.Error(), fmt.Sprintf("pid=%d", os.Getpid())) {
+		t.Fatalf("second lock: %v", err)
+	}
+	if err := runServe([]string{"--db", dbPath, "--log", "-"}); !errors.Is(err, errDBLocked) {
+		t.Fatalf("expected serve to refuse a locked db, got %v", err)
+	}
+	release()
+	release, err = acquireDBLock(dbPath)
+	if err != nil {
+		t.Fatalf("lock after release: %v", err)
+	}
+	release()
+}
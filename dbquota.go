@@ -0,0 +1,176 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sync/atomic"
+	"time"
+)
+
+// Database size quota: with --db-soft-quota-bytes and/or
+// --db-hard-quota-bytes the quota loop measures the database every
+// dbQuotaInterval and sends a db_quota_warning (soft) or db_quota_exceeded
+// (hard) integration event when the size crosses a limit upwards, and
+// db_quota_recovered when it drops back under the soft one. The size is the
+// pages in use (page_count - freelist_count): deleted rows leave free pages
+// that SQLite reuses, so the file stops growing once that drops, even though
+// it does not shrink without a VACUUM. Above the hard quota,
+// --db-quota-retention-days runs an extra retention pass with every rule
+// capped at that age, plus the housekeeping tables (archive, journal, usage)
+// even without a rule. Entries themselves are never deleted for space.
+
+const (
+	subsystemDBQuota = "db_quota"
+	dbQuotaInterval  = 10 * time.Minute
+)
+
+const (
+	dbQuotaOK = iota
+	dbQuotaSoft
+	dbQuotaHard
+)
+
+// dbQuotaHousekeeping are the targets an over-quota retention pass prunes
+// even when no retention rule names them.
+var dbQuotaHousekeeping = []string{"entries_archive", "compaction_journal", "api_usage"}
+
+// dbQuota is the configured limits and the last level measured.
+type dbQuota struct {
+	soft, hard    int64
+	retentionDays int
+	level         atomic.Int32
+	usedBytes     atomic.Int64
+}
+
+func (q *dbQuota) enabled() bool {
+	return q.soft > 0 || q.hard > 0
+}
+
+func (q *dbQuota) levelFor(used int64) int32 {
+	switch {
+	case q.hard > 0 && used >= q.hard:
+		return dbQuotaHard
+	case q.soft > 0 && used >= q.soft:
+		return dbQuotaSoft
+	}
+	return dbQuotaOK
+}
+
+// dbSizes returns the database file size and the bytes in pages in use.
+func (a *App) dbSizes() (file, used int64, err error) {
+	var pages, pageSize, free int64
+	if err := a.db.QueryRow(`PRAGMA page_count`).Scan(&pages); err != nil {
+		return 0, 0, err
+	}
+	if err := a.db.QueryRow(`PRAGMA page_size`).Scan(&pageSize); err != nil {
+		return 0, 0, err
+	}
+	if err := a.db.QueryRow(`PRAGMA freelist_count`).Scan(&free); err != nil {
+		return 0, 0, err
+	}
+	return pages * pageSize, (pages - free) * pageSize, nil
+}
+
+// checkDBQuota measures the database, reports level changes and, above the
+// hard quota, runs the over-quota retention pass.
+func (a *App) checkDBQuota(now time.Time) error {
+	q := &a.dbQuota
+	file, used, err := a.dbSizes()
+	if err != nil {
+		return err
+	}
+	q.usedBytes.Store(used)
+	level := q.levelFor(used)
+	prev := q.level.Swap(level)
+	meta := fmt.Sprintf("used_bytes=%d file_bytes=%d soft_bytes=%d hard_bytes=%d", used, file, q.soft, q.hard)
+	data := map[string]any{"used_bytes": used, "file_bytes": file, "soft_bytes": q.soft, "hard_bytes": q.hard}
+	switch {
+	case level > prev:
+		typ, msg := "db_quota_warning", fmt.Sprintf("Database uses %s of its %s soft quota.", humanBytes(used), humanBytes(q.soft))
+		if level == dbQuotaHard {
+			typ, msg = "db_quota_exceeded", fmt.Sprintf("Database uses %s, over its %s hard quota.", humanBytes(used), humanBytes(q.hard))
+		}
+		a.logger.Printf("event=%s %s", typ, meta)
+		_ = a.logActorAction(actorScheduler, typ, meta)
+		a.dispatcher.Dispatch(IntegrationEvent{Type: typ, User: actorScheduler.Username, Message: msg, Data: data})
+	case level == dbQuotaOK && prev != dbQuotaOK:
+		a.logger.Printf("event=db_quota_recovered %s", meta)
+		_ = a.logActorAction(actorScheduler, "db_quota_recovered", meta)
+		a.dispatcher.Dispatch(IntegrationEvent{Type: "db_quota_recovered", User: actorScheduler.Username, Message: fmt.Sprintf("Database is back under its soft quota (%s).", humanBytes(used)), Data: data})
+	}
+	if level == dbQuotaHard && q.retentionDays > 0 {
+		return a.quotaRetention(now)
+	}
+	return nil
+}
+
+// quotaRetention runs retention with every rule capped at
+// --db-quota-retention-days and the housekeeping targets added.
+func (a *App) quotaRetention(now time.Time) error {
+	days := a.dbQuota.retentionDays
+	rules := a.settings().RetentionRules
+	have := map[string]bool{}
+	for i := range rules {
+		have[rules[i].Target] = true
+		rules[i].MaxAgeDays = min(rules[i].MaxAgeDays, days)
+	}
+	for _, target := range dbQuotaHousekeeping {
+		if !have[target] {
+			rules = append(rules, retentionRule{Target: target, MaxAgeDays: days})
+		}
+	}
+	res, err := a.applyRetentionRules(now, rules)
+	deleted := 0
+	for _, r := range res {
+		deleted += r.Deleted
+	}
+	_ = a.logActorAction(actorScheduler, "db_quota_retention", fmt.Sprintf("max_age_days=%d rules=%s deleted=%d", days, retentionRulesMeta(rules), deleted))
+	return err
+}
+
+// dbQuotaLoop checks the quota once at startup and then every
+// dbQuotaInterval.
+func (a *App) dbQuotaLoop(ctx context.Context) {
+	ticker := time.NewTicker(dbQuotaInterval)
+	defer ticker.Stop()
+	a.health.register(subsystemDBQuota, dbQuotaInterval, time.Now())
+	for {
+		err := a.checkDBQuota(time.Now())
+		if err != nil {
+			a.logger.Printf("event=db_quota_check_failed err=%v", err)
+		}
+		a.health.record(subsystemDBQuota, time.Now(), err)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// dbQuotaMetrics writes the quota gauges when a quota is configured.
+func (a *App) dbQuotaMetrics(w io.Writer) {
+	q := &a.dbQuota
+	if !q.enabled() {
+		return
+	}
+	writeMetric(w, "devlog_db_used_bytes", "Bytes in database pages in use at the last quota check.", "gauge", float64(q.usedBytes.Load()))
+	writeMetric(w, "devlog_db_quota_soft_bytes", "Configured soft database quota (0 = none).", "gauge", float64(q.soft))
+	writeMetric(w, "devlog_db_quota_hard_bytes", "Configured hard database quota (0 = none).", "gauge", float64(q.hard))
+	writeMetric(w, "devlog_db_quota_level", "0 under quota, 1 over the soft quota, 2 over the hard quota.", "gauge", float64(q.level.Load()))
+}
+
+// humanBytes renders n in binary units for messages, e.g. "812.4 MiB".
+func humanBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
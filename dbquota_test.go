@@ -0,0 +1,79 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestDBQuotaWarnings(t *testing.T) {
+	app := newTestApp(t)
+	app.dispatcher = NewIntegrationDispatcher(app.logger, &WebhookIntegration{URL: "http://127.0.0.1:0"})
+	now := time.Now()
+	old := now.AddDate(0, 0, -10)
+	if _, err := app.db.Exec(`INSERT INTO compaction_journal(day, step, detail, at) VALUES(?, 'started', '', ?), (?, 'started', '', ?)`,
+		old.Format("2006-01-02"), old.UTC().Format(time.RFC3339), now.Format("2006-01-02"), now.UTC().Format(time.RFC3339)); err != nil {
+		t.Fatal(err)
+	}
+	_, used, err := app.dbSizes()
+	if err != nil || used <= 0 {
+		t.Fatalf("dbSizes: %d %v", used, err)
+	}
+	event := func() IntegrationEvent {
+		select {
+		case ev := <-app.dispatcher.queue:
+			return ev
+		default:
+			t.Fatal("no integration event")
+			return IntegrationEvent{}
+		}
+	}
+	noEvent := func() {
+		select {
+		case ev := <-app.dispatcher.queue:
+			t.Fatalf("unexpected event %+v", ev)
+		default:
+		}
+	}
+
+	app.dbQuota.soft, app.dbQuota.hard = used, used*100
+	if err := app.checkDBQuota(now); err != nil {
+		t.Fatal(err)
+	}
+	if ev := event(); ev.Type != "db_quota_warning" || ev.Data["used_bytes"] != used {
+		t.Fatalf("soft event: %+v", ev)
+	}
+	// Still over the soft quota: no repeat.
+	if err := app.checkDBQuota(now); err != nil {
+		t.Fatal(err)
+	}
+	noEvent()
+
+	app.dbQuota.hard, app.dbQuota.retentionDays = used, 7
+	if err := app.checkDBQuota(now); err != nil {
+		t.Fatal(err)
+	}
+	if ev := event(); ev.Type != "db_quota_exceeded" {
+		t.Fatalf("hard event: %+v", ev)
+	}
+	var journal int
+	_ = app.db.QueryRow(`SELECT COUNT(*) FROM compaction_journal`).Scan(&journal)
+	if journal != 1 {
+		t.Fatalf("journal rows after over-quota retention: %d", journal)
+	}
+	var audited int
+	_ = app.db.QueryRow(`SELECT COUNT(*) FROM action_logs WHERE action IN ('db_quota_warning', 'db_quota_exceeded', 'db_quota_retention')`).Scan(&audited)
+	if audited != 3 {
+		t.Fatalf("quota audit rows: %d", audited)
+	}
+
+	app.dbQuota.soft, app.dbQuota.hard = used*100, used*200
+	if err := app.checkDBQuota(now); err != nil {
+		t.Fatal(err)
+	}
+	if ev := event(); ev.Type != "db_quota_recovered" {
+		t.Fatalf("recovered event: %+v", ev)
+	}
+	if app.dbQuota.level.Load() != dbQuotaOK {
+		t.Fatalf("level: %d", app.dbQuota.level.Load())
+	}
+}
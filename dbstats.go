@@ -0,0 +1,159 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/mattn/go-sqlite3"
+)
+
+// SQLite contention shows up in two places: goroutines queueing for the
+// single pooled connection (database/sql wait stats), and statements that
+// sit in SQLite's busy handler while another connection or process holds
+// the write lock. Every write statement (Exec and Commit) is timed through
+// a driver wrapper so the second part is visible as a histogram; the time
+// includes the busy_timeout wait, which the driver does not report apart.
+
+// defaultSlowWrite is the --db-slow-write default.
+const defaultSlowWrite = 500 * time.Millisecond
+
+// dbWriteBuckets are the upper bounds, in seconds, of devlog_db_write_seconds.
+var dbWriteBuckets = []float64{0.001, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+// dbObserver accumulates write statement timings for every database opened
+// with openDB. The zero value records but never logs.
+type dbObserver struct {
+	buckets  [13]atomic.Int64 // len(dbWriteBuckets) + the +Inf bucket
+	count    atomic.Int64
+	sumNanos atomic.Int64
+	busy     atomic.Int64
+	slow     atomic.Int64
+
+	mu        sync.Mutex
+	logger    *log.Logger
+	threshold time.Duration
+}
+
+var dbContention dbObserver
+
+// setSlowLog logs writes slower than threshold to logger; 0 disables it.
+func (o *dbObserver) setSlowLog(logger *log.Logger, threshold time.Duration) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.logger, o.threshold = logger, threshold
+}
+
+func (o *dbObserver) observe(op, query string, d time.Duration, err error) {
+	secs := d.Seconds()
+	i := 0
+	for i < len(dbWriteBuckets) && secs > dbWriteBuckets[i] {
+		i++
+	}
+	o.buckets[i].Add(1)
+	o.count.Add(1)
+	o.sumNanos.Add(int64(d))
+	var serr sqlite3.Error
+	if errors.As(err, &serr) && (serr.Code == sqlite3.ErrBusy || serr.Code == sqlite3.ErrLocked) {
+		o.busy.Add(1)
+	}
+
+	o.mu.Lock()
+	logger, threshold := o.logger, o.threshold
+	o.mu.Unlock()
+	if logger == nil || threshold <= 0 || d < threshold {
+		return
+	}
+	o.slow.Add(1)
+	logger.Printf("event=db_slow_write op=%s duration_ms=%d err=%v sql=%q", op, d.Milliseconds(), err, statementHead(query))
+}
+
+// statementHead shortens a statement to its first line-collapsed 80 chars.
+func statementHead(query string) string {
+	q := strings.Join(strings.Fields(query), " ")
+	if len(q) > 80 {
+		q = q[:80] + "..."
+	}
+	return q
+}
+
+// writeMetrics emits the write histogram, busy/slow counters and the
+// connection pool wait stats of db.
+func (o *dbObserver) writeMetrics(w io.Writer, db *sql.DB) {
+	fmt.Fprintln(w, "# HELP devlog_db_write_seconds Time write statements (Exec and Commit) took, including SQLite busy waits.")
+	fmt.Fprintln(w, "# TYPE devlog_db_write_seconds histogram")
+	var cum int64
+	for i, le := range dbWriteBuckets {
+		cum += o.buckets[i].Load()
+		fmt.Fprintf(w, "devlog_db_write_seconds_bucket{le=\"%g\"} %d\n", le, cum)
+	}
+	cum += o.buckets[len(dbWriteBuckets)].Load()
+	fmt.Fprintf(w, "devlog_db_write_seconds_bucket{le=\"+Inf\"} %d\n", cum)
+	fmt.Fprintf(w, "devlog_db_write_seconds_sum %g\n", time.Duration(o.sumNanos.Load()).Seconds())
+	fmt.Fprintf(w, "devlog_db_write_seconds_count %d\n", o.count.Load())
+	writeMetric(w, "devlog_db_busy_total", "Statements that failed with SQLITE_BUSY or SQLITE_LOCKED.", "counter", float64(o.busy.Load()))
+	writeMetric(w, "devlog_db_slow_writes_total", "Write statements slower than --db-slow-write.", "counter", float64(o.slow.Load()))
+	if db == nil {
+		return
+	}
+	st := db.Stats()
+	writeMetric(w, "devlog_db_conn_waits_total", "Statements that waited for the pooled SQLite connection.", "counter", float64(st.WaitCount))
+	writeMetric(w, "devlog_db_conn_wait_seconds_total", "Time spent waiting for the pooled SQLite connection.", "counter", st.WaitDuration.Seconds())
+	writeMetric(w, "devlog_db_conn_in_use", "1 while the pooled SQLite connection is busy.", "gauge", float64(st.InUse))
+}
+
+// timedConnector opens sqlite3 connections whose writes report to obs.
+type timedConnector struct {
+	dsn string
+	obs *dbObserver
+}
+
+func (c timedConnector) Connect(context.Context) (driver.Conn, error) {
+	conn, err := (&sqlite3.SQLiteDriver{}).Open(c.dsn)
+	if err != nil {
+		return nil, err
+	}
+	return &timedConn{SQLiteConn: conn.(*sqlite3.SQLiteConn), obs: c.obs}, nil
+}
+
+func (c timedConnector) Driver() driver.Driver { return &sqlite3.SQLiteDriver{} }
+
+type timedConn struct {
+	*sqlite3.SQLiteConn
+	obs *dbObserver
+}
+
+func (c *timedConn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	start := time.Now()
+	res, err := c.SQLiteConn.ExecContext(ctx, query, args)
+	c.obs.observe("exec", query, time.Since(start), err)
+	return res, err
+}
+
+func (c *timedConn) BeginTx(ctx context.Context, opts driver.TxOptions) (driver.Tx, error) {
+	tx, err := c.SQLiteConn.BeginTx(ctx, opts)
+	if err != nil {
+		return nil, err
+	}
+	return &timedTx{Tx: tx, obs: c.obs}, nil
+}
+
+type timedTx struct {
+	driver.Tx
+	obs *dbObserver
+}
+
+func (t *timedTx) Commit() error {
+	start := time.Now()
+	err := t.Tx.Commit()
+	t.obs.observe("commit", "COMMIT", time.Since(start), err)
+	return err
+}
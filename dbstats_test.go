@@ -0,0 +1,69 @@
+package main
+
+import (
+	"bytes"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDBWriteContentionMetrics(t *testing.T) {
+	app := newTestApp(t)
+	var logs bytes.Buffer
+	dbContention.setSlowLog(log.New(&logs, "", 0), 50*time.Millisecond)
+	t.Cleanup(func() { dbContention.setSlowLog(nil, 0) })
+	before := dbContention.count.Load()
+
+	// A second connection, like an admin CLI run, holds the write lock for a while.
+	path := filepath.Join(t.TempDir(), "contended.db")
+	holder, err := openDB(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer holder.Close()
+	waiter, err := openDB(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer waiter.Close()
+	if _, err := holder.Exec(`CREATE TABLE t (v INTEGER)`); err != nil {
+		t.Fatal(err)
+	}
+	tx, err := holder.Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := tx.Exec(`INSERT INTO t(v) VALUES(1)`); err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		time.Sleep(150 * time.Millisecond)
+		_ = tx.Commit()
+	}()
+	start := time.Now()
+	if _, err := waiter.Exec(`INSERT INTO t(v) VALUES(2)`); err != nil {
+		t.Fatal(err)
+	}
+	if waited := time.Since(start); waited < 100*time.Millisecond {
+		t.Fatalf("insert did not wait for the lock (%s)", waited)
+	}
+	if dbContention.count.Load() <= before {
+		t.Fatal("write not observed")
+	}
+	if !strings.Contains(logs.String(), "event=db_slow_write op=exec") || !strings.Contains(logs.String(), `sql="INSERT INTO t(v) VALUES(2)"`) {
+		t.Fatalf("slow write log: %q", logs.String())
+	}
+
+	rr := httptest.NewRecorder()
+	newTestMux(app).ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	body := rr.Body.String()
+	for _, want := range []string{"# TYPE devlog_db_write_seconds histogram", `devlog_db_write_seconds_bucket{le="+Inf"}`, "devlog_db_write_seconds_count", "devlog_db_slow_writes_total", "devlog_db_conn_wait_seconds_total"} {
+		if !strings.Contains(body, want) {
+			t.Fatalf("metrics missing %q:\n%s", want, body)
+		}
+	}
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// Deep links point back into the web UI: a day is /entries-view?day=D and an
+// entry is its day's view with an #entry-N anchor, so links keep working
+// after compaction replaces the entry with the day's compact. The external
+// URL (org setting, else --external-url) makes them absolute for Slack and
+// webhooks; --base-path is the prefix the UI is served under behind the proxy.
+
+// parseExternalURL validates an external URL and strips a trailing slash.
+func parseExternalURL(s string) (string, error) {
+	s = strings.TrimRight(strings.TrimSpace(s), "/")
+	if s == "" {
+		return "", nil
+	}
+	u, err := url.Parse(s)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" || u.RawQuery != "" || u.Fragment != "" {
+		return "", fmt.Errorf("invalid external URL %q (want e.g. https://devlog.example.com)", s)
+	}
+	return s, nil
+}
+
+// normalizeBasePath turns --base-path into "" or "/prefix" without a
+// trailing slash.
+func normalizeBasePath(s string) (string, error) {
+	s = strings.Trim(strings.TrimSpace(s), "/")
+	if s == "" {
+		return "", nil
+	}
+	if strings.ContainsAny(s, "?#") || strings.Contains(s, "//") || strings.Contains(s, "..") {
+		return "", fmt.Errorf("invalid --base-path %q", s)
+	}
+	return "/" + s, nil
+}
+
+// uiURL builds a canonical UI link for a path under the base path.
+func (a *App) uiURL(p string, q url.Values, fragment string) string {
+	s := a.externalBase() + a.basePath + p
+	if len(q) > 0 {
+		s += "?" + q.Encode()
+	}
+	if fragment != "" {
+		s += "#" + fragment
+	}
+	return s
+}
+
+func (a *App) dayURL(day string) string {
+	return a.uiURL("/entries-view", url.Values{"day": {day}}, "")
+}
+
+// entryURL links an entry on its day; createdAt is its RFC3339 UTC timestamp.
+func (a *App) entryURL(id int64, createdAt string) string {
+	return a.uiURL("/entries-view", url.Values{"day": {entryDay(createdAt)}}, "entry-"+strconv.FormatInt(id, 10))
+}
+
+// entryDay is the UTC day an entry is listed under.
+func entryDay(createdAt string) string {
+	if len(createdAt) >= len("2006-01-02") {
+		return createdAt[:len("2006-01-02")]
+	}
+	return createdAt
+}
+
+// eventURL is the dispatcher's link hook: entry events link the entry, day
+// events the day.
+func (a *App) eventURL(ev IntegrationEvent) string {
+	switch {
+	case ev.EntryID > 0 && ev.Day != "":
+		return a.uiURL("/entries-view", url.Values{"day": {ev.Day}}, "entry-"+strconv.FormatInt(ev.EntryID, 10))
+	case ev.EntryID > 0:
+		return a.entryURL(ev.EntryID, ev.CreatedAt)
+	case ev.Day != "":
+		return a.dayURL(ev.Day)
+	}
+	return ""
+}
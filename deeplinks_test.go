@@ -0,0 +1,104 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDeepLinks(t *testing.T) {
+	app := newTestApp(t)
+	app.externalURL = "https://devlog.example.com"
+	app.basePath = "/team"
+	h := newTestMux(app)
+	createUser(t, app, "alice", "PUDLINKAL01")
+
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, authedReq(t, http.MethodPost, "/api/entries", map[string]string{"content": "linked"}, "PUDLINKAL01"))
+	var created struct {
+		ID  int64  `json:"id"`
+		URL string `json:"url"`
+	}
+	if err := json.Unmarshal(rr.Body.Bytes(), &created); err != nil || rr.Code != http.StatusCreated {
+		t.Fatalf("create: %d %s", rr.Code, rr.Body.String())
+	}
+	day := time.Now().UTC().Format("2006-01-02")
+	want := fmt.Sprintf("https://devlog.example.com/team/entries-view?day=%s#entry-%d", day, created.ID)
+	if created.URL != want {
+		t.Fatalf("create url = %q, want %q", created.URL, want)
+	}
+
+	rr = httptest.NewRecorder()
+	h.ServeHTTP(rr, authedReq(t, http.MethodGet, "/api/entries?day="+day, nil, "PUDLINKAL01"))
+	var list struct {
+		URL     string     `json:"url"`
+		Entries []entryRow `json:"entries"`
+	}
+	if err := json.Unmarshal(rr.Body.Bytes(), &list); err != nil || rr.Code != http.StatusOK {
+		t.Fatalf("list: %d %s", rr.Code, rr.Body.String())
+	}
+	if list.URL != "https://devlog.example.com/team/entries-view?day="+day || len(list.Entries) != 1 || list.Entries[0].URL != want {
+		t.Fatalf("unexpected list urls: %s %+v", list.URL, list.Entries)
+	}
+
+	d := NewIntegrationDispatcher(app.logger, &WebhookIntegration{URL: "http://127.0.0.1:0"})
+	d.SetLinker(app.eventURL)
+	d.Dispatch(IntegrationEvent{Type: "keyword_alert", EntryID: created.ID})
+	d.Dispatch(IntegrationEvent{Type: "daily_compact", Day: "2026-02-17"})
+	if ev := <-d.queue; ev.URL != want {
+		t.Fatalf("entry event url = %q", ev.URL)
+	}
+	if ev := <-d.queue; ev.URL != "https://devlog.example.com/team/entries-view?day=2026-02-17" {
+		t.Fatalf("day event url = %q", ev.URL)
+	}
+}
+
+func TestExternalURLSetting(t *testing.T) {
+	app := newTestApp(t)
+	app.externalURL = "http://flag.example.com"
+	app.shareKey = []byte("share-test-key")
+	h := newTestMux(app)
+	createUser(t, app, "alice", "PUDEXTAL001")
+	createUser(t, app, "root", "PUDEXTRO001")
+	if _, err := app.db.Exec(`UPDATE users SET role = 'admin' WHERE username = 'root'`); err != nil {
+		t.Fatalf("promote: %v", err)
+	}
+	if got := app.dayURL("2026-02-17"); got != "http://flag.example.com/entries-view?day=2026-02-17" {
+		t.Fatalf("flag fallback: %q", got)
+	}
+
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, authedReq(t, http.MethodPatch, "/api/admin/settings", map[string]any{"external_url": "ftp://nope"}, "PUDEXTRO001"))
+	if rr.Code != http.StatusBadRequest {
+		t.Fatalf("invalid external_url accepted: %d", rr.Code)
+	}
+	rr = httptest.NewRecorder()
+	h.ServeHTTP(rr, authedReq(t, http.MethodPatch, "/api/admin/settings", map[string]any{"external_url": "https://devlog.example.com/"}, "PUDEXTRO001"))
+	if rr.Code != http.StatusOK || !strings.Contains(rr.Body.String(), `"external_url":"https://devlog.example.com"`) {
+		t.Fatalf("set external_url: %d %s", rr.Code, rr.Body.String())
+	}
+
+	rr = httptest.NewRecorder()
+	h.ServeHTTP(rr, authedReq(t, http.MethodPost, "/api/entries", map[string]string{"content": "linked"}, "PUDEXTAL001"))
+	if rr.Code != http.StatusCreated || !strings.Contains(rr.Body.String(), `"url":"https://devlog.example.com/entries-view?day=`) {
+		t.Fatalf("entry url ignores the setting: %d %s", rr.Code, rr.Body.String())
+	}
+	rr = httptest.NewRecorder()
+	h.ServeHTTP(rr, authedReq(t, http.MethodPost, "/api/share", map[string]any{"day": "2026-02-17"}, "PUDEXTAL001"))
+	var share struct {
+		URL string `json:"url"`
+	}
+	if err := json.Unmarshal(rr.Body.Bytes(), &share); err != nil || !strings.HasPrefix(share.URL, "https://devlog.example.com/api/shared?") {
+		t.Fatalf("share url = %q (%d %v)", share.URL, rr.Code, err)
+	}
+
+	rr = httptest.NewRecorder()
+	h.ServeHTTP(rr, authedReq(t, http.MethodPatch, "/api/admin/settings", map[string]any{"external_url": ""}, "PUDEXTRO001"))
+	if rr.Code != http.StatusOK || app.externalBase() != "http://flag.example.com" {
+		t.Fatalf("clearing the setting should fall back to the flag: %d %q", rr.Code, app.externalBase())
+	}
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// serve --demo runs a public try-it instance: a throwaway database seeded
+// with sample entries, one shared member token printed in the banner, and an
+// hourly reset back to the seed so whatever visitors post is short-lived.
+
+const (
+	// demoToken is public by design; it only reaches the member-level demo user.
+	demoToken          = "PUDTRYTHEDEM"
+	demoUsername       = "demo"
+	demoResetInterval  = time.Hour
+	subsystemDemoReset = "demo_reset"
+)
+
+var demoUsers = []string{"alice", "bob", "carol"}
+
+// demoEntries are seeded relative to the reset time: daysAgo 0 is today
+// (UTC) and older days are compacted right away.
+var demoEntries = []struct {
+	user     string
+	daysAgo  int
+	at       string
+	category string
+	content  string
+}{
+	{"alice", 2, "09:12", "feature", "kicked off the export rework, sketching the streaming encoder"},
+	{"bob", 2, "10:40", "ops", "rotated staging certs, nothing else on fire"},
+	{"carol", 2, "15:05", "bugfix", "PROJ-42 login timeout reproduced: session refresh races the idle check"},
+	{"alice", 1, "09:30", "feature", "streaming encoder works for lists, compacts next #export"},
+	{"carol", 1, "11:02", "bugfix", "PROJ-42 fixed, @bob can you review?"},
+	{"bob", 1, "16:20", "", "reviewed PROJ-42, merged. waiting on db creds for the load test #blocker"},
+	{"alice", 0, "08:55", "", "pairing with @carol on the compact renderer today"},
+	{"bob", 0, "09:20", "ops", "load test running against staging, dashboards look flat so far"},
+	{"carol", 0, "10:15", "feature", "first pass of saved views: filters by #blocker and user"},
+}
+
+// resetDemo wipes every table and seeds the demo dataset as of now.
+func (a *App) resetDemo(now time.Time) error {
+	a.compactMu.Lock()
+	err := a.seedDemo(now)
+	a.compactMu.Unlock()
+	if err != nil {
+		return err
+	}
+	for d := 2; d >= 1; d-- {
+		day := now.UTC().AddDate(0, 0, -d).Format("2006-01-02")
+		if err := a.compactDay(day); err != nil {
+			return fmt.Errorf("compact %s: %w", day, err)
+		}
+	}
+	return nil
+}
+
+func (a *App) seedDemo(now time.Time) error {
+	// The full-text index follows entries through its triggers.
+	rows, err := a.db.Query(`SELECT name FROM sqlite_master WHERE type = 'table' AND name NOT LIKE 'sqlite_%' AND name != 'entries' AND name NOT LIKE 'entries_fts%'`)
+	if err != nil {
+		return err
+	}
+	// entries go first so the entry_counts triggers fire before the counts are cleared.
+	tables := []string{"entries"}
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			_ = rows.Close()
+			return err
+		}
+		tables = append(tables, name)
+	}
+	if err := rows.Err(); err != nil {
+		_ = rows.Close()
+		return err
+	}
+	_ = rows.Close()
+
+	tx, err := a.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer func() { _ = tx.Rollback() }()
+	if _, err := tx.Exec(`PRAGMA defer_foreign_keys = ON`); err != nil {
+		return err
+	}
+	for _, t := range tables {
+		if _, err := tx.Exec(`DELETE FROM "` + t + `"`); err != nil {
+			return fmt.Errorf("clear %s: %w", t, err)
+		}
+	}
+	if _, err := tx.Exec(`DELETE FROM sqlite_sequence`); err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+
+	if err := a.seedActors(); err != nil {
+		return err
+	}
+	userIDs := map[string]int64{}
+	for _, name := range append([]string{demoUsername}, demoUsers...) {
+		// Only the demo user has a known token; the others cannot sign in.
+		tok := demoToken
+		if name != demoUsername {
+			if tok, err = generateToken(); err != nil {
+				return err
+			}
+		}
+		if userIDs[name], err = a.insertHumanUser(name, roleMember, tok, sql.NullString{}); err != nil {
+			return err
+		}
+	}
+	for _, e := range demoEntries {
+		day := now.UTC().AddDate(0, 0, -e.daysAgo).Format("2006-01-02")
+		at := day + "T" + e.at + ":00Z"
+		if e.daysAgo == 0 && at > now.UTC().Format(time.RFC3339) {
+			// Keep today's entries in the past so the UI shows them as posted.
+			at = now.UTC().Add(-time.Minute).Format(time.RFC3339)
+		}
+		if _, err := a.db.Exec(`INSERT INTO entries(user_id, entry_type, content, category, source, created_at) VALUES(?, 'normal', ?, ?, ?, ?)`,
+			userIDs[e.user], e.content, e.category, sourceWeb, at); err != nil {
+			return err
+		}
+	}
+	banner := fmt.Sprintf("Demo instance: sign in with token %s. Everything resets every hour.", demoToken)
+	cats := []string{"bugfix", "feature", "ops"}
+	if _, _, err := a.updateSettings(settingsPatch{Banner: &banner, AllowedCategories: &cats}, "demo"); err != nil {
+		return err
+	}
+	_ = a.logActorAction(actorSystem, "demo_reset", fmt.Sprintf("users=%s entries=%d", strings.Join(demoUsers, ","), len(demoEntries)))
+	return nil
+}
+
+func (a *App) demoResetLoop(ctx context.Context) {
+	ticker := time.NewTicker(demoResetInterval)
+	defer ticker.Stop()
+	a.health.register(subsystemDemoReset, demoResetInterval, time.Now())
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			err := a.resetDemo(time.Now())
+			if err != nil {
+				a.logger.Printf("event=demo_reset_failed err=%v", err)
+			} else {
+				a.logger.Printf("event=demo_reset")
+			}
+			a.health.record(subsystemDemoReset, time.Now(), err)
+		}
+	}
+}
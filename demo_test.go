@@ -0,0 +1,58 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDemoModeReset(t *testing.T) {
+	app := newTestApp(t)
+	h := newTestMux(app)
+	now := time.Date(2026, 3, 10, 12, 0, 0, 0, time.UTC)
+	if err := app.resetDemo(now); err != nil {
+		t.Fatalf("resetDemo: %v", err)
+	}
+
+	var compacts int
+	if err := app.db.QueryRow(`SELECT COUNT(*) FROM entries WHERE entry_type = 'daily_compact'`).Scan(&compacts); err != nil || compacts != 2 {
+		t.Fatalf("compacts: %d %v", compacts, err)
+	}
+	if !strings.Contains(app.settings().Banner, demoToken) {
+		t.Fatalf("banner: %q", app.settings().Banner)
+	}
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, authedReq(t, http.MethodGet, "/api/me", nil, demoToken))
+	if rr.Code != http.StatusOK || !strings.Contains(rr.Body.String(), `"role":"member"`) {
+		t.Fatalf("demo token: %d %s", rr.Code, rr.Body.String())
+	}
+	rr = httptest.NewRecorder()
+	h.ServeHTTP(rr, authedReq(t, http.MethodGet, "/api/admin/usage", nil, demoToken))
+	if rr.Code != http.StatusForbidden {
+		t.Fatalf("demo token on admin route: %d", rr.Code)
+	}
+
+	rr = httptest.NewRecorder()
+	h.ServeHTTP(rr, authedReq(t, http.MethodPost, "/api/entries", map[string]string{"content": "visitor scribble"}, demoToken))
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("demo post: %d %s", rr.Code, rr.Body.String())
+	}
+	if err := app.resetDemo(now); err != nil {
+		t.Fatalf("second resetDemo: %v", err)
+	}
+	var scribbles, users int
+	if err := app.db.QueryRow(`SELECT COUNT(*) FROM entries WHERE content = 'visitor scribble'`).Scan(&scribbles); err != nil || scribbles != 0 {
+		t.Fatalf("visitor entry survived reset: %d %v", scribbles, err)
+	}
+	if err := app.db.QueryRow(`SELECT COUNT(*) FROM users WHERE kind = 'human'`).Scan(&users); err != nil || users != 1+len(demoUsers) {
+		t.Fatalf("users after reset: %d %v", users, err)
+	}
+	if n, err := app.dayEntryCount("2026-03-10"); err != nil || n != 3 {
+		t.Fatalf("today's count after reset: %d %v", n, err)
+	}
+	if _, problems, err := app.verifyAuditChain(); err != nil || len(problems) != 0 {
+		t.Fatalf("audit chain after reset: %v %v", problems, err)
+	}
+}
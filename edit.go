@@ -0,0 +1,191 @@
+package main
+
+import (
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// errEntryImmutable is returned for entries past the edit window or on a
+// compacted day.
+var errEntryImmutable = errors.New("entry is immutable")
+
+// checkMutable enforces the immutability rule shared by edits and deletes:
+// an entry is frozen edit_window_hours after creation (0 disables the
+// window) and once its day has a compaction row, whatever the caller's role.
+func (a *App) checkMutable(createdAt string, now time.Time) error {
+	if hours := a.settings().EditWindowHours; hours > 0 {
+		created, err := time.Parse(time.RFC3339, createdAt)
+		if err != nil {
+			return err
+		}
+		if now.Sub(created) >= time.Duration(hours)*time.Hour {
+			return fmt.Errorf("%w: edit window of %dh has passed", errEntryImmutable, hours)
+		}
+	}
+	var n int
+	if err := a.db.QueryRow(`SELECT COUNT(*) FROM compactions WHERE day = ?`, entryDay(createdAt)).Scan(&n); err != nil {
+		return err
+	}
+	if n > 0 {
+		return fmt.Errorf("%w: its day is compacted", errEntryImmutable)
+	}
+	return nil
+}
+
+// writeMutableErr answers a checkMutable failure.
+func writeMutableErr(w http.ResponseWriter, err error) {
+	if errors.Is(err, errEntryImmutable) {
+		jsonErr(w, http.StatusForbidden, err.Error())
+		return
+	}
+	jsonErr(w, http.StatusInternalServerError, "failed to query entry")
+}
+
+// canModerate reports whether u may edit or delete entries written by others.
+func (a *App) canModerate(u AuthedUser) bool {
+	return a.policy.allows(u.Role, actionEntriesModerate)
+}
+
+// handleEditEntry serves PUT and PATCH /api/entries/{id}. PUT replaces the
+// content and category; PATCH changes only the fields sent. Only live normal
+// entries can be edited, by their author or an entries.moderate holder.
+// Contributors cannot edit: their content goes live only through approval.
+// Edits do not re-send mentions or keyword alerts.
+func (a *App) handleEditEntry(w http.ResponseWriter, r *http.Request, u AuthedUser, id int64) {
+	var req struct {
+		Content  *string `json:"content"`
+		Category *string `json:"category"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		jsonErr(w, http.StatusBadRequest, "invalid json")
+		return
+	}
+	if r.Method == http.MethodPut {
+		if req.Content == nil {
+			jsonErr(w, http.StatusBadRequest, "content is required")
+			return
+		}
+		if req.Category == nil {
+			req.Category = new(string)
+		}
+	}
+	if req.Content == nil && req.Category == nil {
+		jsonErr(w, http.StatusBadRequest, "content or category is required")
+		return
+	}
+
+	var owner int64
+	var entryType, content, category, createdAt string
+	var deletedAt sql.NullString
+	err := a.db.QueryRow(`SELECT user_id, entry_type, content, category, created_at, deleted_at FROM entries WHERE id = ?`, id).
+		Scan(&owner, &entryType, &content, &category, &createdAt, &deletedAt)
+	if errors.Is(err, sql.ErrNoRows) || (err == nil && deletedAt.Valid) {
+		jsonErr(w, http.StatusNotFound, "entry not found")
+		return
+	}
+	if err != nil {
+		jsonErr(w, http.StatusInternalServerError, "failed to query entry")
+		return
+	}
+	if entryType != "normal" || (owner != u.ID && !a.canModerate(u)) {
+		jsonErr(w, http.StatusForbidden, "only the author can edit this entry")
+		return
+	}
+	if needsApproval(u) {
+		jsonErr(w, http.StatusForbidden, "contributors cannot edit entries; submit a new entry for approval")
+		return
+	}
+	if err := a.checkMutable(createdAt, time.Now()); err != nil {
+		writeMutableErr(w, err)
+		return
+	}
+	// The day being compacted is checksummed between produce and verify;
+	// refusing edits meanwhile keeps the compact in step with its sources.
+	if a.writeLocked.Load() {
+		w.Header().Set("Retry-After", "5")
+		jsonErr(w, http.StatusConflict, "compaction in progress; retry shortly")
+		return
+	}
+
+	settings := a.settings()
+	newContent, newCategory := content, category
+	var secrets []string
+	if req.Content != nil {
+		newContent = strings.TrimSpace(*req.Content)
+		if newContent == "" {
+			jsonErr(w, http.StatusBadRequest, "content is required")
+			return
+		}
+		if len(newContent) > settings.MaxEntrySize {
+			jsonErr(w, http.StatusBadRequest, "content too large")
+			return
+		}
+		var redacted string
+		redacted, secrets = scanSecrets(newContent)
+		if len(secrets) > 0 && a.redactSecrets {
+			newContent = redacted
+		}
+	}
+	if req.Category != nil {
+		newCategory, err = settings.checkCategory(*req.Category)
+		if err != nil {
+			jsonErr(w, http.StatusBadRequest, err.Error())
+			return
+		}
+	}
+
+	editedAt := nowUTC()
+	res, err := a.db.Exec(`UPDATE entries SET content = ?, category = ?, edited_at = ? WHERE id = ? AND deleted_at IS NULL AND entry_type = 'normal'`,
+		newContent, newCategory, editedAt, id)
+	if err != nil {
+		jsonErr(w, http.StatusInternalServerError, "failed to update entry")
+		return
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		// Compacted or trashed since the read above.
+		jsonErr(w, http.StatusNotFound, "entry not found")
+		return
+	}
+	if newContent != content {
+		if _, err := a.db.Exec(`DELETE FROM entry_links WHERE source_id = ?`, id); err != nil {
+			a.logger.Printf("event=entry_link_failed entry_id=%d err=%v", id, err)
+		}
+		a.recordEntryLinks(id, newContent)
+		if _, err := a.db.Exec(`DELETE FROM issue_refs WHERE entry_id = ?`, id); err != nil {
+			a.logger.Printf("event=issue_refs_reset_failed entry_id=%d err=%v", id, err)
+		}
+		go a.enrichEntryIssues(id, newContent)
+	}
+
+	meta := fmt.Sprintf("entry_id=%d size=%d prev_size=%d prev_sha256=%s", id, len(newContent), len(content), contentDigest(content))
+	if newCategory != category {
+		meta += fmt.Sprintf(" category=%s prev_category=%s", newCategory, category)
+	}
+	if owner != u.ID {
+		meta += fmt.Sprintf(" owner_id=%d", owner)
+	}
+	_ = a.logUserAction(u, "edit_entry", meta)
+	a.publishLive(liveEvent{Type: "entry_edited", Day: createdAt[:10], EntryID: id, User: u.Username})
+	resp := map[string]any{"id": id, "status": "updated", "edited_at": editedAt, "url": a.entryURL(id, createdAt)}
+	if len(secrets) > 0 {
+		_ = a.logUserAction(u, "secret_detected", fmt.Sprintf("entry_id=%d kinds=%s redacted=%t", id, strings.Join(secrets, ","), a.redactSecrets))
+		resp["secrets_detected"] = secrets
+		resp["redacted"] = a.redactSecrets
+	}
+	jsonOut(w, http.StatusOK, resp)
+}
+
+// contentDigest is the hex SHA-256 of an entry's previous content. Audit rows
+// record it instead of the text so edits that remove a leaked secret do not
+// copy it into the action log.
+func contentDigest(content string) string {
+	sum := sha256.Sum256([]byte(content))
+	return hex.EncodeToString(sum[:])
+}
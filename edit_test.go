@@ -0,0 +1,141 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestEntryEditing(t *testing.T) {
+	app := newTestApp(t)
+	h := newTestMux(app)
+	createUser(t, app, "alice", "PUDEDITAL01")
+	createUser(t, app, "bob", "PUDEDITBO01")
+	createUser(t, app, "carol", "PUDEDITCA01")
+	if _, err := app.db.Exec(`UPDATE users SET role = 'admin' WHERE username = 'carol'`); err != nil {
+		t.Fatalf("promote: %v", err)
+	}
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, authedReq(t, http.MethodPost, "/api/entries", map[string]string{"content": "fixd the typo"}, "PUDEDITAL01"))
+	var created struct {
+		ID int64 `json:"id"`
+	}
+	if err := json.Unmarshal(rr.Body.Bytes(), &created); err != nil || rr.Code != http.StatusCreated {
+		t.Fatalf("create: %d %s", rr.Code, rr.Body.String())
+	}
+	path := fmt.Sprintf("/api/entries/%d", created.ID)
+	edit := func(method, token string, body any) int {
+		t.Helper()
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, authedReq(t, method, path, body, token))
+		return rr.Code
+	}
+
+	if code := edit(http.MethodPatch, "PUDEDITBO01", map[string]string{"content": "hijacked"}); code != http.StatusForbidden {
+		t.Fatalf("other members must not edit, got %d", code)
+	}
+	if code := edit(http.MethodPut, "PUDEDITAL01", map[string]string{"category": "x"}); code != http.StatusBadRequest {
+		t.Fatalf("PUT without content: expected 400, got %d", code)
+	}
+	if code := edit(http.MethodPut, "PUDEDITAL01", map[string]string{"content": "fixed the typo"}); code != http.StatusOK {
+		t.Fatalf("author PUT: %d", code)
+	}
+	if code := edit(http.MethodPatch, "PUDEDITCA01", map[string]string{"category": "ops"}); code != http.StatusOK {
+		t.Fatalf("admin PATCH: %d", code)
+	}
+	e, err := app.entryByID(created.ID)
+	if err != nil || e.Content != "fixed the typo" || e.Category != "ops" || e.EditedAt == "" {
+		t.Fatalf("unexpected entry after edits: %+v %v", e, err)
+	}
+	var bytes int
+	if err := app.db.QueryRow(`SELECT bytes FROM entry_counts WHERE user_id = 1`).Scan(&bytes); err != nil || bytes != len("fixed the typo") {
+		t.Fatalf("entry_counts bytes = %d (%v)", bytes, err)
+	}
+
+	if code := edit(http.MethodDelete, "PUDEDITBO01", nil); code != http.StatusForbidden {
+		t.Fatalf("other members must not delete, got %d", code)
+	}
+	if code := edit(http.MethodDelete, "PUDEDITCA01", nil); code != http.StatusOK {
+		t.Fatalf("admin delete: %d", code)
+	}
+	if code := edit(http.MethodPatch, "PUDEDITAL01", map[string]string{"content": "too late"}); code != http.StatusNotFound {
+		t.Fatalf("editing a trashed entry: expected 404, got %d", code)
+	}
+	var edits, trashed int
+	if err := app.db.QueryRow(`SELECT COUNT(*) FROM action_logs WHERE action = 'edit_entry'`).Scan(&edits); err != nil || edits != 2 {
+		t.Fatalf("edit_entry rows = %d (%v)", edits, err)
+	}
+	if err := app.db.QueryRow(`SELECT COUNT(*) FROM action_logs WHERE action = 'trash_entry' AND actor_username = 'carol' AND metadata LIKE '%owner_id=1%'`).Scan(&trashed); err != nil || trashed != 1 {
+		t.Fatalf("trash_entry rows = %d (%v)", trashed, err)
+	}
+}
+
+func TestEntryImmutability(t *testing.T) {
+	app := newTestApp(t)
+	h := newTestMux(app)
+	createUser(t, app, "alice", "PUDFROZAL01")
+	createUser(t, app, "carol", "PUDFROZCA01")
+	if _, err := app.db.Exec(`UPDATE users SET role = 'admin' WHERE username = 'carol'`); err != nil {
+		t.Fatalf("promote: %v", err)
+	}
+	do := func(method, path, token string, body any) *httptest.ResponseRecorder {
+		t.Helper()
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, authedReq(t, method, path, body, token))
+		return rr
+	}
+	create := func(content string) string {
+		t.Helper()
+		rr := do(http.MethodPost, "/api/entries", "PUDFROZAL01", map[string]string{"content": content})
+		var created struct {
+			ID int64 `json:"id"`
+		}
+		if err := json.Unmarshal(rr.Body.Bytes(), &created); err != nil || rr.Code != http.StatusCreated {
+			t.Fatalf("create: %d %s", rr.Code, rr.Body.String())
+		}
+		return fmt.Sprintf("/api/entries/%d", created.ID)
+	}
+
+	if rr := do(http.MethodPatch, "/api/admin/settings", "PUDFROZCA01", map[string]int{"edit_window_hours": -1}); rr.Code != http.StatusBadRequest {
+		t.Fatalf("negative window: expected 400, got %d", rr.Code)
+	}
+	if rr := do(http.MethodPatch, "/api/admin/settings", "PUDFROZCA01", map[string]int{"edit_window_hours": 1}); rr.Code != http.StatusOK {
+		t.Fatalf("set window: %d %s", rr.Code, rr.Body.String())
+	}
+
+	fresh := create("still editable")
+	if rr := do(http.MethodPatch, fresh, "PUDFROZAL01", map[string]string{"content": "edited in time"}); rr.Code != http.StatusOK {
+		t.Fatalf("edit inside window: %d %s", rr.Code, rr.Body.String())
+	}
+
+	old := create("written a while ago")
+	if _, err := app.db.Exec(`UPDATE entries SET created_at = ? WHERE id = ?`,
+		time.Now().UTC().Add(-2*time.Hour).Format(time.RFC3339), strings.TrimPrefix(old, "/api/entries/")); err != nil {
+		t.Fatalf("backdate: %v", err)
+	}
+	rr := do(http.MethodPatch, old, "PUDFROZAL01", map[string]string{"content": "rewritten"})
+	if rr.Code != http.StatusForbidden || !strings.Contains(rr.Body.String(), "immutable") {
+		t.Fatalf("edit past window: expected 403 immutable, got %d %s", rr.Code, rr.Body.String())
+	}
+	if rr := do(http.MethodDelete, old, "PUDFROZCA01", nil); rr.Code != http.StatusForbidden {
+		t.Fatalf("moderators must not delete past the window, got %d", rr.Code)
+	}
+
+	// A compacted day freezes entries even with the window disabled.
+	if rr := do(http.MethodPatch, "/api/admin/settings", "PUDFROZCA01", map[string]int{"edit_window_hours": 0}); rr.Code != http.StatusOK {
+		t.Fatalf("clear window: %d", rr.Code)
+	}
+	if rr := do(http.MethodPatch, old, "PUDFROZAL01", map[string]string{"content": "rewritten"}); rr.Code != http.StatusOK {
+		t.Fatalf("edit with window disabled: %d %s", rr.Code, rr.Body.String())
+	}
+	if _, err := app.db.Exec(`INSERT INTO compactions(day, ran_at, phase) VALUES(?, ?, 'done')`, time.Now().UTC().Format("2006-01-02"), nowUTC()); err != nil {
+		t.Fatalf("insert compaction: %v", err)
+	}
+	if rr := do(http.MethodDelete, fresh, "PUDFROZAL01", nil); rr.Code != http.StatusForbidden {
+		t.Fatalf("delete on compacted day: expected 403, got %d", rr.Code)
+	}
+}
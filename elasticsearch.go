@@ -0,0 +1,379 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Elasticsearch/OpenSearch indexing: with --es-url, a supervised loop reads
+// the entry ids queued in search_outbox (search.go) and sends them to the
+// index with the bulk API. An id whose entry is gone (compacted, purged,
+// trashed) becomes a delete, so the index follows compaction without special
+// cases. 'admin es-backfill' indexes entries that predate the outbox.
+
+const (
+	searchIndexInterval = 5 * time.Second
+	searchIndexBatch    = 500
+	subsystemSearch     = "search_index"
+	defaultESIndex      = "devlog"
+	outboxElasticsearch = "elasticsearch"
+)
+
+// esIndexer writes entry documents to one Elasticsearch/OpenSearch index.
+type esIndexer struct {
+	baseURL  string
+	index    string
+	username string
+	password string
+	apiKey   string
+	client   *http.Client
+
+	indexed atomic.Int64
+	failed  atomic.Int64
+
+	// mu serializes drains, since a watchdog restart can overlap a wedged one.
+	mu            sync.Mutex
+	templateReady bool
+}
+
+// parseESIndexer builds the indexer for --es-url; credentials in the URL are
+// sent as basic auth, an API key file as "Authorization: ApiKey".
+func parseESIndexer(rawURL, index, apiKeyFile string) (*esIndexer, error) {
+	if strings.TrimSpace(rawURL) == "" {
+		return nil, nil
+	}
+	u, err := url.Parse(strings.TrimSpace(rawURL))
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("invalid --es-url %q (want an http(s) URL)", rawURL)
+	}
+	index = strings.TrimSpace(index)
+	if index == "" || index != strings.ToLower(index) || strings.ContainsAny(index, `/\*?"<>| ,#`) || strings.HasPrefix(index, "_") {
+		return nil, fmt.Errorf("invalid --es-index %q (lowercase, no spaces or /\\*?\"<>|,#)", index)
+	}
+	key, err := readKeyFile(apiKeyFile)
+	if err != nil {
+		return nil, err
+	}
+	ix := &esIndexer{index: index, apiKey: string(key)}
+	if u.User != nil {
+		ix.username = u.User.Username()
+		ix.password, _ = u.User.Password()
+		u.User = nil
+	}
+	ix.baseURL = strings.TrimRight(u.String(), "/")
+	return ix, nil
+}
+
+// esDocument is the indexed form of an entry; the document id is the entry id.
+type esDocument struct {
+	EntryID   int64  `json:"entry_id"`
+	User      string `json:"user"`
+	UserKind  string `json:"user_kind"`
+	EntryType string `json:"entry_type"`
+	Category  string `json:"category,omitempty"`
+	Content   string `json:"content"`
+	Day       string `json:"day"`
+	CreatedAt string `json:"created_at"`
+	EditedAt  string `json:"edited_at,omitempty"`
+	URL       string `json:"url,omitempty"`
+}
+
+// esIndexTemplate is applied to the index name before the first write, so
+// the index gets keyword fields for filters and text for content.
+func (ix *esIndexer) esIndexTemplate() map[string]any {
+	return map[string]any{
+		"index_patterns": []string{ix.index},
+		"template": map[string]any{
+			"mappings": map[string]any{
+				"dynamic": "strict",
+				"properties": map[string]any{
+					"entry_id":   map[string]string{"type": "long"},
+					"user":       map[string]string{"type": "keyword"},
+					"user_kind":  map[string]string{"type": "keyword"},
+					"entry_type": map[string]string{"type": "keyword"},
+					"category":   map[string]string{"type": "keyword"},
+					"content":    map[string]string{"type": "text"},
+					"day":        map[string]string{"type": "date", "format": "yyyy-MM-dd"},
+					"created_at": map[string]string{"type": "date"},
+					"edited_at":  map[string]string{"type": "date"},
+					"url":        map[string]any{"type": "keyword", "index": false},
+				},
+			},
+		},
+	}
+}
+
+func (ix *esIndexer) do(ctx context.Context, method, path, contentType string, body []byte) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, method, ix.baseURL+path, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", contentType)
+	switch {
+	case ix.apiKey != "":
+		req.Header.Set("Authorization", "ApiKey "+ix.apiKey)
+	case ix.username != "":
+		req.SetBasicAuth(ix.username, ix.password)
+	}
+	client := ix.client
+	if client == nil {
+		client = &http.Client{Timeout: 30 * time.Second}
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	out, err := io.ReadAll(io.LimitReader(resp.Body, 8<<20))
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode/100 != 2 {
+		return nil, fmt.Errorf("%s %s: %s: %s", method, path, resp.Status, strings.TrimSpace(string(out[:min(len(out), 512)])))
+	}
+	return out, nil
+}
+
+// ensureTemplate puts the index template once per process.
+func (ix *esIndexer) ensureTemplate(ctx context.Context) error {
+	if ix.templateReady {
+		return nil
+	}
+	body, err := json.Marshal(ix.esIndexTemplate())
+	if err != nil {
+		return err
+	}
+	if _, err := ix.do(ctx, http.MethodPut, "/_index_template/"+url.PathEscape(ix.index), "application/json", body); err != nil {
+		return fmt.Errorf("put index template: %w", err)
+	}
+	ix.templateReady = true
+	return nil
+}
+
+// bulk sends index actions for docs and delete actions for deletes. Items
+// rejected with 429 or 5xx fail the call so the batch is retried; other
+// per-item errors (a document the mapping refuses) are counted and dropped.
+func (ix *esIndexer) bulk(ctx context.Context, docs []esDocument, deletes []int64) error {
+	var body bytes.Buffer
+	enc := json.NewEncoder(&body)
+	for _, d := range docs {
+		_ = enc.Encode(map[string]any{"index": map[string]string{"_index": ix.index, "_id": strconv.FormatInt(d.EntryID, 10)}})
+		if err := enc.Encode(d); err != nil {
+			return err
+		}
+	}
+	for _, id := range deletes {
+		_ = enc.Encode(map[string]any{"delete": map[string]string{"_index": ix.index, "_id": strconv.FormatInt(id, 10)}})
+	}
+	if body.Len() == 0 {
+		return nil
+	}
+	out, err := ix.do(ctx, http.MethodPost, "/_bulk", "application/x-ndjson", body.Bytes())
+	if err != nil {
+		return err
+	}
+	var resp struct {
+		Errors bool                    `json:"errors"`
+		Items  []map[string]esBulkItem `json:"items"`
+	}
+	if err := json.Unmarshal(out, &resp); err != nil {
+		return fmt.Errorf("parse bulk response: %w", err)
+	}
+	var retry int
+	for _, item := range resp.Items {
+		for op, r := range item {
+			switch {
+			case r.Status/100 == 2, op == "delete" && r.Status == http.StatusNotFound:
+				ix.indexed.Add(1)
+			case r.Status == http.StatusTooManyRequests || r.Status >= 500:
+				retry++
+			default:
+				ix.failed.Add(1)
+			}
+		}
+	}
+	if retry > 0 {
+		ix.failed.Add(int64(retry))
+		return fmt.Errorf("bulk: %d item(s) rejected with a retryable status", retry)
+	}
+	return nil
+}
+
+type esBulkItem struct {
+	Status int `json:"status"`
+}
+
+// drainSearchOutbox indexes queued entries until the outbox is empty or a
+// bulk request fails; a failed batch is retried on the next run.
+func (a *App) drainSearchOutbox(ctx context.Context) error {
+	ix := a.esIndexer
+	ix.mu.Lock()
+	defer ix.mu.Unlock()
+	if err := ix.ensureTemplate(ctx); err != nil {
+		return err
+	}
+	for {
+		ids, last, err := a.outboxBatch(outboxElasticsearch)
+		if err != nil || len(ids) == 0 {
+			return err
+		}
+		args := make([]any, len(ids))
+		for i, id := range ids {
+			args[i] = id
+		}
+		docs, err := a.searchDocuments(`e.id IN (`+placeholders(len(ids))+`)`, 0, args...)
+		if err != nil {
+			return err
+		}
+		found := map[int64]bool{}
+		for _, d := range docs {
+			found[d.EntryID] = true
+		}
+		var deletes []int64
+		for _, id := range ids {
+			if !found[id] {
+				deletes = append(deletes, id)
+			}
+		}
+		if err := ix.bulk(ctx, docs, deletes); err != nil {
+			return err
+		}
+		if err := a.advanceOutbox(outboxElasticsearch, last); err != nil {
+			return err
+		}
+	}
+}
+
+// searchDocuments loads live entries matching where as index documents, in
+// id order; limit 0 means all of them.
+func (a *App) searchDocuments(where string, limit int, args ...any) ([]esDocument, error) {
+	q := `
+SELECT e.id, u.username, u.kind, e.entry_type, e.category, e.content, e.created_at, COALESCE(e.edited_at, '')
+FROM entries e
+JOIN users u ON u.id = e.user_id
+WHERE e.deleted_at IS NULL AND ` + where + `
+ORDER BY e.id ASC`
+	if limit > 0 {
+		q += ` LIMIT ?`
+		args = append(args, limit)
+	}
+	rows, err := a.db.Query(q, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var out []esDocument
+	for rows.Next() {
+		var d esDocument
+		if err := rows.Scan(&d.EntryID, &d.User, &d.UserKind, &d.EntryType, &d.Category, &d.Content, &d.CreatedAt, &d.EditedAt); err != nil {
+			return nil, err
+		}
+		if len(d.CreatedAt) >= 10 {
+			d.Day = d.CreatedAt[:10]
+		}
+		d.URL = a.entryURL(d.EntryID, d.CreatedAt)
+		out = append(out, d)
+	}
+	return out, rows.Err()
+}
+
+func (a *App) searchIndexLoop(ctx context.Context) {
+	ticker := time.NewTicker(searchIndexInterval)
+	defer ticker.Stop()
+	a.health.register(subsystemSearch, searchIndexInterval, time.Now())
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			err := a.drainSearchOutbox(ctx)
+			if err != nil {
+				a.logger.Printf("event=search_index_failed index=%s err=%v", a.esIndexer.index, err)
+			}
+			a.health.record(subsystemSearch, time.Now(), err)
+		}
+	}
+}
+
+// backfillSearchIndex indexes every live entry, batch by batch, and returns
+// how many documents were sent. Re-running it overwrites the same ids.
+func (a *App) backfillSearchIndex(ctx context.Context) (int, error) {
+	ix := a.esIndexer
+	ix.mu.Lock()
+	defer ix.mu.Unlock()
+	if err := ix.ensureTemplate(ctx); err != nil {
+		return 0, err
+	}
+	var after int64
+	total := 0
+	for {
+		docs, err := a.searchDocuments(`e.id > ?`, searchIndexBatch, after)
+		if err != nil {
+			return total, err
+		}
+		if len(docs) == 0 {
+			return total, nil
+		}
+		if err := ix.bulk(ctx, docs, nil); err != nil {
+			return total, err
+		}
+		total += len(docs)
+		after = docs[len(docs)-1].EntryID
+	}
+}
+
+func runAdminESBackfill(args []string) error {
+	fs := flag.NewFlagSet("admin es-backfill", flag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s admin es-backfill --es-url <url> [options]\n\n", binName())
+		fmt.Fprintln(fs.Output(), "Puts the index template and indexes every live entry and compact into Elasticsearch/OpenSearch.")
+		fmt.Fprintln(fs.Output(), "Run once after enabling serve --es-url; later changes are indexed by the server.")
+		fmt.Fprintln(fs.Output())
+		fmt.Fprintln(fs.Output(), "Options:")
+		fs.PrintDefaults()
+	}
+	esURL := fs.String("es-url", "", "Elasticsearch/OpenSearch base URL (credentials in the URL are sent as basic auth)")
+	esIndex := fs.String("es-index", defaultESIndex, "index name")
+	esAPIKeyFile := fs.String("es-api-key-file", "", "file holding an Elasticsearch API key (Authorization: ApiKey)")
+	dbPath := fs.String("db", defaultDBPath, "sqlite db path")
+	logPath := fs.String("log", defaultLogPath, "log target path ('-' for stdout only)")
+	if err := fs.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return nil
+		}
+		return err
+	}
+	if strings.TrimSpace(*esURL) == "" {
+		return errors.New("--es-url is required")
+	}
+	ix, err := parseESIndexer(*esURL, *esIndex, *esAPIKeyFile)
+	if err != nil {
+		return err
+	}
+	app, closeApp, err := openAdminApp(*dbPath, *logPath)
+	if err != nil {
+		return err
+	}
+	defer closeApp()
+	app.esIndexer = ix
+
+	n, err := app.backfillSearchIndex(context.Background())
+	if err != nil {
+		return fmt.Errorf("backfill after %d document(s): %w", n, err)
+	}
+	_ = app.logAction("admin_cli", "admin", "es_backfill", fmt.Sprintf("index=%s documents=%d", ix.index, n))
+	fmt.Printf("indexed %d document(s) into %s\n", n, ix.index)
+	return nil
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestSearchIndexer(t *testing.T) {
+	var mu sync.Mutex
+	docs := map[string]map[string]any{}
+	var templates int
+	es := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if user, pass, _ := r.BasicAuth(); user != "elastic" || pass != "s3cret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		switch {
+		case r.Method == http.MethodPut && r.URL.Path == "/_index_template/devlog-test":
+			templates++
+			_, _ = io.WriteString(w, `{"acknowledged":true}`)
+		case r.Method == http.MethodPost && r.URL.Path == "/_bulk":
+			dec := json.NewDecoder(r.Body)
+			var items []map[string]map[string]int
+			for {
+				var action map[string]map[string]string
+				if err := dec.Decode(&action); err != nil {
+					break
+				}
+				for op, meta := range action {
+					status := http.StatusOK
+					if op == "index" {
+						var doc map[string]any
+						_ = dec.Decode(&doc)
+						docs[meta["_id"]] = doc
+					} else if _, ok := docs[meta["_id"]]; ok {
+						delete(docs, meta["_id"])
+					} else {
+						status = http.StatusNotFound
+					}
+					items = append(items, map[string]map[string]int{op: {"status": status}})
+				}
+			}
+			_ = json.NewEncoder(w).Encode(map[string]any{"errors": false, "items": items})
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer es.Close()
+
+	app := newTestApp(t)
+	h := newTestMux(app)
+	createUser(t, app, "alice", "PUDSEARCH01")
+	post := func(content string) {
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, authedReq(t, http.MethodPost, "/api/entries", map[string]string{"content": content}, "PUDSEARCH01"))
+		if rr.Code != http.StatusCreated {
+			t.Fatalf("post: %d %s", rr.Code, rr.Body.String())
+		}
+	}
+	post("written before indexing was enabled")
+
+	ix, err := parseESIndexer(strings.Replace(es.URL, "http://", "http://elastic:s3cret@", 1), "devlog-test", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	app.esIndexer = ix
+	if err := app.setSearchOutbox(outboxElasticsearch); err != nil {
+		t.Fatal(err)
+	}
+	post("fixed the flaky deploy")
+	if err := app.drainSearchOutbox(context.Background()); err != nil {
+		t.Fatalf("drain: %v", err)
+	}
+	mu.Lock()
+	if templates != 1 || len(docs) != 1 {
+		t.Fatalf("after drain: templates=%d docs=%v", templates, docs)
+	}
+	mu.Unlock()
+
+	if n, err := app.backfillSearchIndex(context.Background()); err != nil || n != 2 {
+		t.Fatalf("backfill: n=%d err=%v", n, err)
+	}
+	if err := app.compactDay(time.Now().UTC().Format("2006-01-02")); err != nil {
+		t.Fatalf("compactDay: %v", err)
+	}
+	if err := app.drainSearchOutbox(context.Background()); err != nil {
+		t.Fatalf("drain after compaction: %v", err)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if len(docs) != 1 {
+		t.Fatalf("after compaction want only the compact indexed, got %v", docs)
+	}
+	for _, d := range docs {
+		if d["entry_type"] != "daily_compact" || !strings.Contains(d["content"].(string), "flaky deploy") {
+			t.Fatalf("indexed compact: %v", d)
+		}
+	}
+	if depth, err := app.searchOutboxDepth(outboxElasticsearch); err != nil || depth != 0 {
+		t.Fatalf("outbox depth %d err=%v", depth, err)
+	}
+	if templates != 1 {
+		t.Fatalf("template put %d times", templates)
+	}
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"flag"
+	"fmt"
+	"net/http"
+	"net/mail"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// mailgunMaxSkew bounds how old a signed Mailgun webhook may be, so a captured
+// request cannot be replayed later.
+const mailgunMaxSkew = 15 * time.Minute
+
+// verifyMailgunSignature checks Mailgun's webhook signature:
+// hex(HMAC-SHA256(signing key, timestamp + token)).
+func verifyMailgunSignature(key, timestamp, token, signature string, now time.Time) bool {
+	ts, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return false
+	}
+	if d := now.Sub(time.Unix(ts, 0)); d > mailgunMaxSkew || d < -mailgunMaxSkew {
+		return false
+	}
+	mac := hmac.New(sha256.New, []byte(key))
+	mac.Write([]byte(timestamp + token))
+	return hmac.Equal([]byte(hex.EncodeToString(mac.Sum(nil))), []byte(signature))
+}
+
+// emailEntryContent turns a message into entry text: the subject as first line,
+// then the reply-stripped body (falling back to the full plain-text body).
+func emailEntryContent(subject, stripped, plain string) string {
+	body := strings.TrimSpace(stripped)
+	if body == "" {
+		body = strings.TrimSpace(plain)
+	}
+	subject = strings.TrimSpace(subject)
+	switch {
+	case subject == "":
+		return body
+	case body == "":
+		return subject
+	default:
+		return subject + "\n" + body
+	}
+}
+
+// handleInboundEmail receives Mailgun "store and notify"/route forwards and
+// turns each message into an entry attributed through "email" identity links. Mailgun
+// retries on 5xx and gives up on 406, so permanent rejections use 406.
+func (a *App) handleInboundEmail(w http.ResponseWriter, r *http.Request) {
+	if a.mailgunSigningKey == "" {
+		jsonErr(w, http.StatusNotFound, "inbound email is not configured")
+		return
+	}
+	if r.Method != http.MethodPost {
+		jsonErr(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	r.Body = http.MaxBytesReader(w, r.Body, 10<<20)
+	if err := r.ParseMultipartForm(1 << 20); err != nil && !errors.Is(err, http.ErrNotMultipart) {
+		jsonErr(w, http.StatusBadRequest, "invalid form")
+		return
+	}
+	if !verifyMailgunSignature(a.mailgunSigningKey, r.FormValue("timestamp"), r.FormValue("token"), r.FormValue("signature"), time.Now()) {
+		jsonErr(w, http.StatusUnauthorized, "invalid signature")
+		return
+	}
+
+	raw := r.FormValue("sender")
+	if raw == "" {
+		raw = r.FormValue("from")
+	}
+	parsed, err := mail.ParseAddress(raw)
+	if err != nil {
+		_ = a.logActorAction(actorEmailGateway, "inbound_email_rejected", fmt.Sprintf("reason=bad_sender sender=%q", raw))
+		jsonErr(w, http.StatusNotAcceptable, "invalid sender address")
+		return
+	}
+	addr := strings.ToLower(parsed.Address)
+	u, err := a.resolveIdentity(providerEmail, addr)
+	if errors.Is(err, errUnknownIdentity) {
+		_ = a.logActorAction(actorEmailGateway, "inbound_email_rejected", fmt.Sprintf("reason=unmapped_sender sender=%s", addr))
+		jsonErr(w, http.StatusNotAcceptable, "unknown sender")
+		return
+	}
+	if err != nil {
+		jsonErr(w, http.StatusInternalServerError, "failed to resolve sender")
+		return
+	}
+
+	content := emailEntryContent(r.FormValue("subject"), r.FormValue("stripped-text"), r.FormValue("body-plain"))
+	if content == "" {
+		_ = a.logActorAction(actorEmailGateway, "inbound_email_rejected", fmt.Sprintf("reason=empty sender=%s", addr))
+		jsonErr(w, http.StatusNotAcceptable, "empty message")
+		return
+	}
+	if len(content) > a.settings().MaxEntrySize {
+		_ = a.logActorAction(actorEmailGateway, "inbound_email_rejected", fmt.Sprintf("reason=too_large sender=%s size=%d", addr, len(content)))
+		jsonErr(w, http.StatusNotAcceptable, "content too large")
+		return
+	}
+	status, resp, err := a.storeUserEntry(u, content, "", sourceEmail)
+	if err != nil {
+		jsonErr(w, status, err.Error())
+		return
+	}
+	jsonOut(w, status, resp)
+}
+
+// runAdminMapEmailSender is kept for existing scripts; it manages "email"
+// identity links like 'admin link-identity --provider email'.
+func runAdminMapEmailSender(args []string) error {
+	fs := flag.NewFlagSet("admin map-email-sender", flag.ContinueOnError)
+	email := fs.String("email", "", "sender address allowed to post by email")
+	username := fs.String("username", "", "user the address maps to")
+	remove := fs.Bool("remove", false, "remove the mapping instead of creating it")
+	dbPath := fs.String("db", defaultDBPath, "sqlite db path")
+	logPath := fs.String("log", defaultLogPath, "log target path ('-' for stdout only)")
+	if err := fs.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return nil
+		}
+		return err
+	}
+	if strings.TrimSpace(*email) == "" {
+		return errors.New("--email is required")
+	}
+	return runIdentityLinkCommand(providerEmail, *email, *username, *remove, false, *dbPath, *logPath)
+}
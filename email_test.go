@@ -0,0 +1,65 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAPIInboundEmail(t *testing.T) {
+	app := newTestApp(t)
+	app.mailgunSigningKey = "mg-key"
+	h := newTestMux(app)
+	createUser(t, app, "alice", "PUDABCDEF12")
+	if _, err := app.db.Exec(`INSERT INTO identity_links(provider, external_id, user_id, created_at) SELECT 'email', 'alice@team.example', id, '' FROM users WHERE username = 'alice'`); err != nil {
+		t.Fatalf("map sender: %v", err)
+	}
+
+	post := func(from, signature string) *httptest.ResponseRecorder {
+		ts := strconv.FormatInt(time.Now().Unix(), 10)
+		if signature == "" {
+			mac := hmac.New(sha256.New, []byte("mg-key"))
+			mac.Write([]byte(ts + "tok"))
+			signature = hex.EncodeToString(mac.Sum(nil))
+		}
+		form := url.Values{
+			"timestamp":     {ts},
+			"token":         {"tok"},
+			"signature":     {signature},
+			"sender":        {from},
+			"subject":       {"fixed flaky deploy"},
+			"stripped-text": {"retry was missing on the health check"},
+		}
+		req := httptest.NewRequest(http.MethodPost, "/api/inbound/email", strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, req)
+		return rr
+	}
+
+	if rr := post("Alice <Alice@team.example>", "bad"); rr.Code != http.StatusUnauthorized {
+		t.Fatalf("expected 401 for bad signature, got %d", rr.Code)
+	}
+	if rr := post("mallory@evil.example", ""); rr.Code != http.StatusNotAcceptable {
+		t.Fatalf("expected 406 for unmapped sender, got %d", rr.Code)
+	}
+	rr := post("Alice <Alice@team.example>", "")
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("expected 201, got %d body=%s", rr.Code, rr.Body.String())
+	}
+
+	var content, username string
+	if err := app.db.QueryRow(`SELECT e.content, u.username FROM entries e JOIN users u ON u.id = e.user_id`).Scan(&content, &username); err != nil {
+		t.Fatalf("query entry: %v", err)
+	}
+	if username != "alice" || content != "fixed flaky deploy\nretry was missing on the health check" {
+		t.Fatalf("unexpected entry %q by %q", content, username)
+	}
+}
@@ -0,0 +1,279 @@
+package main
+
+import (
+	"crypto/hmac"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Embeddable widget: POST /api/embed mints a signed, expiring read-only link
+// to /embed.js (served by the UI server, like the pages it links to). The
+// script carries the latest entries inline and renders them into the page
+// that includes it, so dashboards (wiki pages, Grafana text panels) need no
+// CORS, token or fetch. Links use the share key and rate limit; rotating the
+// key revokes them along with share links.
+
+const (
+	embedDefaultLimit = 10
+	embedMaxLimit     = 50
+	embedDefaultTTL   = 7 * 24 * time.Hour
+	embedCacheSeconds = 60
+)
+
+// embedParams are the signed parts of an embed link.
+type embedParams struct {
+	Limit     int
+	User      string
+	Anonymous bool
+}
+
+func (p embedParams) target() string {
+	return fmt.Sprintf("embed:limit=%d:user=%s:anon=%t", p.Limit, p.User, p.Anonymous)
+}
+
+// handleCreateEmbed serves POST /api/embed {"limit","user","anonymize","ttl"}.
+func (a *App) handleCreateEmbed(w http.ResponseWriter, r *http.Request, u AuthedUser) {
+	if len(a.shareKey) == 0 {
+		jsonErr(w, http.StatusNotFound, "embeds are not enabled (--share-key-file)")
+		return
+	}
+	if r.Method != http.MethodPost {
+		jsonErr(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	var req struct {
+		Limit     int    `json:"limit"`
+		User      string `json:"user"`
+		Anonymize bool   `json:"anonymize"`
+		TTL       string `json:"ttl"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		jsonErr(w, http.StatusBadRequest, "invalid json")
+		return
+	}
+	p := embedParams{Limit: req.Limit, User: strings.TrimPrefix(strings.TrimSpace(req.User), "@"), Anonymous: req.Anonymize}
+	if p.Limit == 0 {
+		p.Limit = embedDefaultLimit
+	}
+	if p.Limit < 1 || p.Limit > embedMaxLimit {
+		jsonErr(w, http.StatusBadRequest, fmt.Sprintf("limit must be 1..%d", embedMaxLimit))
+		return
+	}
+	switch a.anonymizeMode {
+	case anonymizeForce:
+		p.Anonymous = true
+	case anonymizeAllow:
+	default:
+		if p.Anonymous {
+			jsonErr(w, http.StatusBadRequest, errAnonymizeDisabled.Error())
+			return
+		}
+	}
+	if p.User != "" {
+		if p.Anonymous {
+			jsonErr(w, http.StatusBadRequest, "user filter is not available in anonymous mode")
+			return
+		}
+		var n int
+		if err := a.db.QueryRow(`SELECT COUNT(*) FROM users WHERE username = ?`, p.User).Scan(&n); err != nil {
+			jsonErr(w, http.StatusInternalServerError, "failed to query users")
+			return
+		}
+		if n == 0 {
+			jsonErr(w, http.StatusNotFound, "user not found")
+			return
+		}
+	}
+	ttl := embedDefaultTTL
+	if strings.TrimSpace(req.TTL) != "" {
+		d, err := time.ParseDuration(strings.TrimSpace(req.TTL))
+		if err != nil || d <= 0 {
+			jsonErr(w, http.StatusBadRequest, "ttl must be a positive duration (e.g. 168h)")
+			return
+		}
+		ttl = d
+	}
+	if a.shareMaxTTL > 0 && ttl > a.shareMaxTTL {
+		if strings.TrimSpace(req.TTL) != "" {
+			jsonErr(w, http.StatusBadRequest, fmt.Sprintf("ttl must not exceed %s", a.shareMaxTTL))
+			return
+		}
+		ttl = a.shareMaxTTL
+	}
+	expires := time.Now().UTC().Add(ttl).Truncate(time.Second)
+	q := url.Values{}
+	q.Set("limit", strconv.Itoa(p.Limit))
+	if p.User != "" {
+		q.Set("user", p.User)
+	}
+	if p.Anonymous {
+		q.Set("anon", "1")
+	}
+	q.Set("exp", strconv.FormatInt(expires.Unix(), 10))
+	q.Set("sig", shareSignature(a.shareKey, p.target(), expires.Unix()))
+	src := a.uiURL("/embed.js", q, "")
+	_ = a.logUserAction(u, "create_embed_link", fmt.Sprintf("limit=%d user=%s anonymous=%t expires_at=%s", p.Limit, p.User, p.Anonymous, expires.Format(time.RFC3339)))
+	jsonOut(w, http.StatusCreated, map[string]string{
+		"url":        src,
+		"snippet":    fmt.Sprintf(`<div id="devlog-embed"></div><script src="%s" data-target="devlog-embed" data-title="Dev log" data-theme="light" async></script>`, strings.ReplaceAll(src, "&", "&amp;")),
+		"expires_at": expires.Format(time.RFC3339),
+	})
+}
+
+// embedEntry is what the widget shows of an entry.
+type embedEntry struct {
+	User      string `json:"user"`
+	Content   string `json:"content"`
+	CreatedAt string `json:"created_at"`
+	URL       string `json:"url"`
+}
+
+// latestEntries returns the newest live entries, daily compacts included,
+// optionally of one user.
+func (a *App) latestEntries(p embedParams) ([]embedEntry, error) {
+	where := `e.deleted_at IS NULL`
+	args := []any{}
+	if p.User != "" {
+		where += ` AND u.username = ?`
+		args = append(args, p.User)
+	}
+	rows, err := a.db.Query(`
+SELECT e.id, u.username, u.kind, e.entry_type, e.category, e.content, e.created_at, COALESCE(e.edited_at, '')
+FROM entries e
+JOIN users u ON u.id = e.user_id
+WHERE `+where+`
+ORDER BY e.created_at DESC, e.id DESC
+LIMIT ?`, append(args, p.Limit)...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	out := []embedEntry{}
+	for rows.Next() {
+		var e entryRow
+		if err := rows.Scan(&e.ID, &e.User, &e.UserKind, &e.EntryType, &e.Category, &e.Content, &e.CreatedAt, &e.EditedAt); err != nil {
+			return nil, err
+		}
+		if p.Anonymous {
+			e = anonymizeEntry(e)
+		}
+		out = append(out, embedEntry{User: e.User, Content: e.Content, CreatedAt: e.CreatedAt, URL: a.entryURL(e.ID, e.CreatedAt)})
+	}
+	return out, rows.Err()
+}
+
+// embedError answers a script request with a status and a script that only
+// logs why, so the embedding page shows nothing instead of breaking.
+func embedError(w http.ResponseWriter, status int, msg string) {
+	b, _ := json.Marshal("devlog embed: " + msg)
+	w.Header().Set("Content-Type", "text/javascript; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	fmt.Fprintf(w, "console.error(%s);\n", b)
+}
+
+// handleEmbedJS serves GET /embed.js?limit=&user=&anon=&exp=&sig= without
+// token auth, rate limited per client like share links.
+func (a *App) handleEmbedJS(w http.ResponseWriter, r *http.Request) {
+	if len(a.shareKey) == 0 {
+		embedError(w, http.StatusNotFound, "embeds are not enabled")
+		return
+	}
+	if r.Method != http.MethodGet {
+		embedError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	now := time.Now()
+	client := a.clientIP(r)
+	if !a.shareLimit.allow(client, now) {
+		a.logger.Printf("event=embed_rate_limited client=%s", client)
+		w.Header().Set("Retry-After", strconv.Itoa(int(shareRateWindow.Seconds())))
+		embedError(w, http.StatusTooManyRequests, "too many requests")
+		return
+	}
+	q := r.URL.Query()
+	limit, err := strconv.Atoi(q.Get("limit"))
+	p := embedParams{Limit: limit, User: q.Get("user"), Anonymous: q.Get("anon") == "1"}
+	exp, expErr := strconv.ParseInt(q.Get("exp"), 10, 64)
+	if err != nil || expErr != nil || p.Limit < 1 || p.Limit > embedMaxLimit ||
+		!hmac.Equal([]byte(shareSignature(a.shareKey, p.target(), exp)), []byte(q.Get("sig"))) {
+		embedError(w, http.StatusForbidden, "invalid embed link")
+		return
+	}
+	if now.After(time.Unix(exp, 0)) {
+		embedError(w, http.StatusGone, "embed link expired")
+		return
+	}
+	if a.anonymizeMode == anonymizeForce {
+		p.Anonymous = true
+	}
+	entries, err := a.latestEntries(p)
+	if err != nil {
+		embedError(w, http.StatusInternalServerError, "failed to query entries")
+		return
+	}
+	a.logger.Printf("event=embed_view target=%s client=%s", p.target(), client)
+	// json.Marshal escapes <, > and &, so the data cannot close the script.
+	data, err := json.Marshal(map[string]any{"entries": entries, "more": a.uiURL("/", nil, "")})
+	if err != nil {
+		embedError(w, http.StatusInternalServerError, "failed to encode entries")
+		return
+	}
+	w.Header().Set("Content-Type", "text/javascript; charset=utf-8")
+	w.Header().Set("Cache-Control", fmt.Sprintf("private, max-age=%d", embedCacheSeconds))
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	fmt.Fprintf(w, "(%s)(document.currentScript, %s);\n", embedWidgetJS, data)
+}
+
+// embedWidgetJS renders the data next to (or into data-target of) the script
+// tag that loaded it. Text is only ever set with textContent. data-title and
+// data-theme (light or dark) customize it.
+const embedWidgetJS = `function (script, data) {
+  var opts = (script && script.dataset) || {};
+  var host = opts.target && document.getElementById(opts.target);
+  if (!host) {
+    host = document.createElement('div');
+    if (script && script.parentNode) script.parentNode.insertBefore(host, script.nextSibling);
+    else document.body.appendChild(host);
+  }
+  var dark = opts.theme === 'dark';
+  var box = document.createElement('div');
+  box.className = 'devlog-embed';
+  box.style.cssText = 'font:13px/1.4 system-ui,sans-serif;padding:8px 12px;border-radius:6px;border:1px solid ' +
+    (dark ? '#444;background:#1f1f1f;color:#ddd' : '#ddd;background:#fff;color:#222');
+  var title = document.createElement('a');
+  title.href = data.more;
+  title.target = '_blank';
+  title.rel = 'noopener';
+  title.textContent = opts.title || 'Dev log';
+  title.style.cssText = 'display:block;font-weight:600;margin-bottom:4px;color:inherit;text-decoration:none';
+  box.appendChild(title);
+  if (!data.entries.length) {
+    var empty = document.createElement('div');
+    empty.textContent = 'No entries yet';
+    empty.style.opacity = '0.7';
+    box.appendChild(empty);
+  }
+  data.entries.forEach(function (e) {
+    var row = document.createElement('div');
+    row.style.cssText = 'margin:6px 0';
+    var meta = document.createElement('a');
+    meta.href = e.url;
+    meta.target = '_blank';
+    meta.rel = 'noopener';
+    meta.textContent = e.user + ' · ' + e.created_at.replace('T', ' ').slice(0, 16);
+    meta.style.cssText = 'font-size:11px;opacity:0.7;color:inherit';
+    var text = document.createElement('div');
+    text.textContent = e.content;
+    text.style.cssText = 'white-space:pre-wrap;overflow-wrap:anywhere';
+    row.appendChild(meta);
+    row.appendChild(text);
+    box.appendChild(row);
+  });
+  host.replaceChildren(box);
+}`
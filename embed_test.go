@@ -0,0 +1,85 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestEmbedWidget(t *testing.T) {
+	app := newTestApp(t)
+	app.shareKey = []byte("share-test-key")
+	app.shareMaxTTL = 48 * time.Hour
+	h := newTestMux(app)
+	createUser(t, app, "alice", "PUDEMBED001")
+	createUser(t, app, "bob", "PUDEMBED002")
+	for _, p := range []struct{ token, content string }{
+		{"PUDEMBED001", "first alice entry"},
+		{"PUDEMBED002", "bob says </script><script>alert(1)</script>"},
+		{"PUDEMBED001", "second alice entry"},
+	} {
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, authedReq(t, http.MethodPost, "/api/entries", map[string]string{"content": p.content}, p.token))
+		if rr.Code != http.StatusCreated {
+			t.Fatalf("post: %d %s", rr.Code, rr.Body.String())
+		}
+	}
+	create := func(body map[string]any) (int, string) {
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, authedReq(t, http.MethodPost, "/api/embed", body, "PUDEMBED001"))
+		var link struct {
+			URL     string `json:"url"`
+			Snippet string `json:"snippet"`
+		}
+		_ = json.Unmarshal(rr.Body.Bytes(), &link)
+		if rr.Code == http.StatusCreated && !strings.Contains(link.Snippet, `data-target="devlog-embed"`) {
+			t.Fatalf("snippet: %s", link.Snippet)
+		}
+		return rr.Code, link.URL
+	}
+	script := func(src string) *httptest.ResponseRecorder {
+		rr := httptest.NewRecorder()
+		app.handleEmbedJS(rr, httptest.NewRequest(http.MethodGet, src, nil))
+		return rr
+	}
+
+	for _, body := range []map[string]any{{"limit": 51}, {"user": "nobody"}, {"ttl": "72h"}, {"anonymize": true}} {
+		if code, _ := create(body); code == http.StatusCreated {
+			t.Fatalf("%v accepted", body)
+		}
+	}
+	code, src := create(map[string]any{"limit": 2})
+	if code != http.StatusCreated || !strings.HasPrefix(src, "/embed.js?") {
+		t.Fatalf("create: %d %s", code, src)
+	}
+	rr := script(src)
+	js := rr.Body.String()
+	if rr.Code != http.StatusOK || !strings.HasPrefix(rr.Header().Get("Content-Type"), "text/javascript") {
+		t.Fatalf("embed.js: %d %s", rr.Code, js)
+	}
+	if !strings.Contains(js, "second alice entry") || strings.Contains(js, "first alice entry") || strings.Contains(js, "</script>") {
+		t.Fatalf("embed.js content: %s", js)
+	}
+
+	_, src = create(map[string]any{"user": "@bob"})
+	if js := script(src).Body.String(); !strings.Contains(js, `"user":"bob"`) || strings.Contains(js, "alice entry") {
+		t.Fatalf("user embed: %s", js)
+	}
+	if rr := script(strings.Replace(src, "user=bob", "user=alice", 1)); rr.Code != http.StatusForbidden || !strings.Contains(rr.Body.String(), "console.error") {
+		t.Fatalf("tampered: %d %s", rr.Code, rr.Body.String())
+	}
+	exp := time.Now().Add(-time.Minute).Unix()
+	p := embedParams{Limit: 5}
+	if rr := script(fmt.Sprintf("/embed.js?limit=5&exp=%d&sig=%s", exp, shareSignature(app.shareKey, p.target(), exp))); rr.Code != http.StatusGone {
+		t.Fatalf("expired: %d", rr.Code)
+	}
+
+	app.anonymizeMode = anonymizeForce
+	if js := script(src).Body.String(); strings.Contains(js, `"user":"bob"`) {
+		t.Fatalf("force anonymize: %s", js)
+	}
+}
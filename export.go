@@ -0,0 +1,533 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"database/sql"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path"
+	"regexp"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type noteEntry struct {
+	User      string
+	Content   string
+	CreatedAt string
+	// Compacted is set for lines recovered from a compact.
+	Compacted bool
+}
+
+var compactLineRe = regexp.MustCompile(`^\[([^\]]+)\]\[([^\]]+)\] (.*)$`)
+
+// compactViaRe and compactIssueRe match the trailers renderCompact appends to
+// a source line, last first: the source, then one label per issue.
+var (
+	compactViaRe   = regexp.MustCompile(` \(via ([a-z]+)\)$`)
+	compactIssueRe = regexp.MustCompile(` \[([A-Z][A-Z0-9]{1,9}-[0-9]+): [^\]]*\]$`)
+)
+
+// parseCompactContent recovers the per-entry lines written by compactDay,
+// splitting the issue labels and "(via ...)" source back off the content.
+// A trailing label only counts when the rest of the line mentions its key,
+// as every label renderCompact writes does.
+func parseCompactContent(content string) []compactSource {
+	var out []compactSource
+	for _, line := range strings.Split(content, "\n") {
+		m := compactLineRe.FindStringSubmatch(line)
+		if m == nil {
+			continue
+		}
+		s := compactSource{User: m[2], CreatedAt: m[1]}
+		text := m[3]
+		if v := compactViaRe.FindStringSubmatchIndex(text); v != nil && slices.Contains(entrySources, text[v[2]:v[3]]) {
+			s.Source = text[v[2]:v[3]]
+			text = text[:v[0]]
+		}
+		for {
+			im := compactIssueRe.FindStringSubmatchIndex(text)
+			if im == nil || !strings.Contains(text[:im[0]], text[im[2]:im[3]]) {
+				break
+			}
+			s.Issues = append([]string{text[im[0]+2 : im[1]-1]}, s.Issues...)
+			text = text[:im[0]]
+		}
+		s.Content = strings.ReplaceAll(text, "\\n", "\n")
+		out = append(out, s)
+	}
+	return out
+}
+
+// compactSources decodes entries.compact_data, falling back to parsing the
+// rendered text for compacts that predate the column.
+func compactSources(data sql.NullString, content string) []compactSource {
+	if data.Valid {
+		var out []compactSource
+		if err := json.Unmarshal([]byte(data.String), &out); err == nil {
+			return out
+		}
+	}
+	return parseCompactContent(content)
+}
+
+// backfillCompactData stores parsed sources for compacts written before
+// compact_data existed.
+func (a *App) backfillCompactData() error {
+	rows, err := a.db.Query(`SELECT id, content FROM entries WHERE entry_type = 'daily_compact' AND compact_data IS NULL`)
+	if err != nil {
+		return err
+	}
+	updates := map[int64]string{}
+	for rows.Next() {
+		var id int64
+		var content string
+		if err := rows.Scan(&id, &content); err != nil {
+			_ = rows.Close()
+			return err
+		}
+		sources := compactSources(sql.NullString{}, content)
+		if sources == nil {
+			sources = []compactSource{}
+		}
+		b, err := json.Marshal(sources)
+		if err != nil {
+			_ = rows.Close()
+			return err
+		}
+		updates[id] = string(b)
+	}
+	if err := rows.Err(); err != nil {
+		_ = rows.Close()
+		return err
+	}
+	_ = rows.Close()
+	for id, data := range updates {
+		if _, err := a.db.Exec(`UPDATE entries SET compact_data = ? WHERE id = ?`, data, id); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// dayNoteEntries returns every entry for day in chronological order, expanding
+// compacts back into their source lines. A day's compacts are found through
+// its compactions row (they are written when the day is compacted, usually
+// the next day) as well as by date for compacts that predate compact_id; a
+// day rolled up into a weekly or monthly compact takes its lines from the
+// rollup. Only the source lines of day itself are kept from any of them.
+func (a *App) dayNoteEntries(day string) ([]noteEntry, error) {
+	rows, err := a.db.Query(`
+SELECT u.username,
+       e.entry_type,
+       e.content,
+       e.compact_data,
+       e.created_at
+FROM entries e
+JOIN users u ON u.id = e.user_id
+WHERE e.deleted_at IS NULL
+  AND (date(e.created_at) = ?
+       OR e.id IN (SELECT compact_id FROM compactions WHERE day = ? UNION SELECT rollup_id FROM compactions WHERE day = ? UNION SELECT compact_id FROM compact_parts WHERE day = ?))
+ORDER BY e.created_at ASC, e.id ASC`, day, day, day, day)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []noteEntry
+	for rows.Next() {
+		var e noteEntry
+		var entryType string
+		var data sql.NullString
+		if err := rows.Scan(&e.User, &entryType, &e.Content, &data, &e.CreatedAt); err != nil {
+			return nil, err
+		}
+		if isCompactType(entryType) {
+			for _, s := range compactSources(data, e.Content) {
+				if strings.HasPrefix(s.CreatedAt, day) {
+					out = append(out, noteEntry{User: s.User, Content: s.Content, CreatedAt: s.CreatedAt, Compacted: true})
+				}
+			}
+			continue
+		}
+		out = append(out, e)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	sort.SliceStable(out, func(i, j int) bool { return out[i].CreatedAt < out[j].CreatedAt })
+	return out, nil
+}
+
+// renderDailyNote formats a day as an Obsidian/Foam daily note: YAML front
+// matter followed by one heading per author.
+func renderDailyNote(day string, entries []noteEntry) string {
+	byUser := map[string][]noteEntry{}
+	var users []string
+	for _, e := range entries {
+		if _, ok := byUser[e.User]; !ok {
+			users = append(users, e.User)
+		}
+		byUser[e.User] = append(byUser[e.User], e)
+	}
+	sort.Strings(users)
+
+	var b strings.Builder
+	b.WriteString("---\n")
+	b.WriteString("date: " + day + "\n")
+	b.WriteString("tags: [devlog]\n")
+	b.WriteString("authors: [" + strings.Join(users, ", ") + "]\n")
+	fmt.Fprintf(&b, "entries: %d\n", len(entries))
+	b.WriteString("---\n\n")
+	b.WriteString("# Dev log " + day + "\n")
+	for _, user := range users {
+		b.WriteString("\n## " + user + "\n\n")
+		for _, e := range byUser[user] {
+			at := e.CreatedAt
+			if t, err := time.Parse(time.RFC3339, e.CreatedAt); err == nil {
+				at = t.Format("15:04")
+			}
+			b.WriteString("- " + at + " " + strings.ReplaceAll(e.Content, "\n", "\n  ") + "\n")
+		}
+	}
+	return b.String()
+}
+
+func (a *App) handleExportDailyNote(w http.ResponseWriter, r *http.Request, u AuthedUser) {
+	if r.Method != http.MethodGet {
+		jsonErr(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	day := strings.TrimSpace(r.URL.Query().Get("day"))
+	if day == "" {
+		day = time.Now().Format("2006-01-02")
+	}
+	if _, err := time.Parse("2006-01-02", day); err != nil {
+		jsonErr(w, http.StatusBadRequest, "day must be YYYY-MM-DD")
+		return
+	}
+	anonymous, err := a.wantAnonymous(r)
+	if err != nil {
+		jsonErr(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	entries, err := a.dayNoteEntries(day)
+	if err != nil {
+		jsonErr(w, http.StatusInternalServerError, "failed to query entries")
+		return
+	}
+	if anonymous {
+		anonymizeNoteEntries(entries)
+	}
+	_ = a.logUserAction(u, "export_daily_note", fmt.Sprintf("day=%s entries=%d anonymous=%t", day, len(entries), anonymous))
+	w.Header().Set("Content-Type", "text/markdown; charset=utf-8")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", day+".md"))
+	w.WriteHeader(http.StatusOK)
+	_, _ = w.Write([]byte(renderDailyNote(day, entries)))
+}
+
+func runAdminExportNotes(args []string) error {
+	fs := flag.NewFlagSet("admin export-notes", flag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s admin export-notes --out <dir> [options]\n\n", binName())
+		fmt.Fprintln(fs.Output(), "Writes one Obsidian-compatible Markdown file per day (YYYY-MM-DD.md).")
+		fmt.Fprintln(fs.Output(), "Days without entries are skipped. With --store the files are uploaded to a")
+		fmt.Fprintln(fs.Output(), "blob store (directory, S3 or GCS) and --out becomes an optional key prefix.")
+		fmt.Fprintln(fs.Output())
+		fmt.Fprintln(fs.Output(), "Options:")
+		fs.PrintDefaults()
+	}
+	today := time.Now().Format("2006-01-02")
+	from := fs.String("from", today, "first day to export (YYYY-MM-DD)")
+	to := fs.String("to", today, "last day to export (YYYY-MM-DD)")
+	outDir := fs.String("out", "", "directory to write daily note files into (key prefix with --store)")
+	anonymous := fs.Bool("anonymize", false, "replace authors and @mentions with \"teammate\" (for sharing retros outside the team)")
+	store := addBlobFlags(fs)
+	dbPath := fs.String("db", defaultDBPath, "sqlite db path")
+	logPath := fs.String("log", defaultLogPath, "log target path ('-' for stdout only)")
+	if err := fs.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return nil
+		}
+		return err
+	}
+	if strings.TrimSpace(*outDir) == "" && store.URL == "" {
+		return errors.New("--out or --store is required")
+	}
+	start, err := time.Parse("2006-01-02", *from)
+	if err != nil {
+		return errors.New("--from must be YYYY-MM-DD")
+	}
+	end, err := time.Parse("2006-01-02", *to)
+	if err != nil {
+		return errors.New("--to must be YYYY-MM-DD")
+	}
+	if end.Before(start) {
+		return errors.New("--to must not be before --from")
+	}
+
+	app, closeApp, err := openAdminApp(*dbPath, *logPath)
+	if err != nil {
+		return err
+	}
+	defer closeApp()
+
+	var blobs BlobStore = &FSBlobStore{Root: *outDir}
+	prefix := ""
+	if store.URL != "" {
+		if blobs, err = openBlobStore(*store); err != nil {
+			return err
+		}
+		prefix = strings.Trim(*outDir, "/")
+	}
+	ctx := context.Background()
+	written := 0
+	for d := start; !d.After(end); d = d.AddDate(0, 0, 1) {
+		day := d.Format("2006-01-02")
+		entries, err := app.dayNoteEntries(day)
+		if err != nil {
+			return err
+		}
+		if len(entries) == 0 {
+			continue
+		}
+		if *anonymous {
+			anonymizeNoteEntries(entries)
+		}
+		key := path.Join(prefix, day+".md")
+		if err := blobs.Put(ctx, key, []byte(renderDailyNote(day, entries)), "text/markdown; charset=utf-8"); err != nil {
+			return err
+		}
+		fmt.Println(blobs.Describe(key))
+		written++
+	}
+	_ = app.logAction("admin_cli", "admin", "export_notes", fmt.Sprintf("from=%s to=%s files=%d dest=%q anonymous=%t", *from, *to, written, blobs.Describe(prefix), *anonymous))
+	fmt.Printf("exported %d daily notes\n", written)
+	return nil
+}
+
+// Range export: GET /api/export and 'admin export' write every entry between
+// two days, compacted ones expanded back into their source lines, as JSON,
+// CSV or one Markdown document. Days are read one at a time, so a long range
+// never holds the database connection while the client reads.
+
+const (
+	exportFormatJSON     = "json"
+	exportFormatCSV      = "csv"
+	exportFormatMarkdown = "md"
+
+	// maxExportRangeDays bounds ?from=&to= on GET /api/export (ten years).
+	maxExportRangeDays = 3660
+)
+
+var exportContentTypes = map[string]string{
+	exportFormatJSON:     "application/json",
+	exportFormatCSV:      "text/csv; charset=utf-8",
+	exportFormatMarkdown: "text/markdown; charset=utf-8",
+}
+
+// exportEntry is one exported line.
+type exportEntry struct {
+	Day       string `json:"day"`
+	User      string `json:"user"`
+	CreatedAt string `json:"created_at"`
+	Content   string `json:"content"`
+	Compacted bool   `json:"compacted"`
+}
+
+// checkExportRange validates an export request's days and format.
+func checkExportRange(from, to, format string) error {
+	if err := validDayRange(from, to); err != nil {
+		return err
+	}
+	if _, ok := exportContentTypes[format]; !ok {
+		return fmt.Errorf("format must be %s, %s or %s", exportFormatJSON, exportFormatCSV, exportFormatMarkdown)
+	}
+	f, _ := time.Parse("2006-01-02", from)
+	t, _ := time.Parse("2006-01-02", to)
+	if t.Sub(f) >= maxExportRangeDays*24*time.Hour {
+		return fmt.Errorf("range must span at most %d days", maxExportRangeDays)
+	}
+	return nil
+}
+
+// writeEntryExport writes the entries of from..to to w in format and returns
+// how many it wrote.
+func (a *App) writeEntryExport(w io.Writer, format, from, to string, anonymous bool) (int, error) {
+	start, _ := time.Parse("2006-01-02", from)
+	end, _ := time.Parse("2006-01-02", to)
+	var (
+		js *jsonArrayStream
+		cw *csv.Writer
+	)
+	switch format {
+	case exportFormatJSON:
+		js = startJSONArrayStream(w, false, []jsonField{{"from", from}, {"to", to}}, "entries")
+	case exportFormatCSV:
+		cw = csv.NewWriter(w)
+		_ = cw.Write([]string{"day", "user", "created_at", "compacted", "content"})
+	case exportFormatMarkdown:
+		if _, err := fmt.Fprintf(w, "# Dev log %s to %s\n", from, to); err != nil {
+			return 0, err
+		}
+	}
+	n := 0
+	for d := start; !d.After(end); d = d.AddDate(0, 0, 1) {
+		day := d.Format("2006-01-02")
+		entries, err := a.dayNoteEntries(day)
+		if err != nil {
+			return n, err
+		}
+		if anonymous {
+			anonymizeNoteEntries(entries)
+		}
+		if format == exportFormatMarkdown && len(entries) > 0 {
+			if _, err := fmt.Fprintf(w, "\n## %s\n\n", day); err != nil {
+				return n, err
+			}
+		}
+		for _, e := range entries {
+			switch format {
+			case exportFormatJSON:
+				js.add(exportEntry{Day: day, User: e.User, CreatedAt: e.CreatedAt, Content: e.Content, Compacted: e.Compacted})
+			case exportFormatCSV:
+				_ = cw.Write([]string{day, e.User, e.CreatedAt, strconv.FormatBool(e.Compacted), e.Content})
+			case exportFormatMarkdown:
+				at := e.CreatedAt
+				if t, err := time.Parse(time.RFC3339, e.CreatedAt); err == nil {
+					at = t.Format("15:04")
+				}
+				if _, err := fmt.Fprintf(w, "- %s **%s** %s\n", at, e.User, strings.ReplaceAll(e.Content, "\n", "\n  ")); err != nil {
+					return n, err
+				}
+			}
+			n++
+		}
+		if cw != nil {
+			cw.Flush()
+			if err := cw.Error(); err != nil {
+				return n, err
+			}
+		}
+		if js != nil && js.err != nil {
+			return n, js.err
+		}
+	}
+	if js != nil {
+		return n, js.finish([]jsonField{{"count", n}})
+	}
+	return n, nil
+}
+
+// handleExportEntries serves GET /api/export?from=&to=&format=json|csv|md.
+func (a *App) handleExportEntries(w http.ResponseWriter, r *http.Request, u AuthedUser) {
+	if r.Method != http.MethodGet {
+		jsonErr(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	q := r.URL.Query()
+	today := time.Now().Format("2006-01-02")
+	from, to := strings.TrimSpace(q.Get("from")), strings.TrimSpace(q.Get("to"))
+	if from == "" {
+		from = today
+	}
+	if to == "" {
+		to = today
+	}
+	format := strings.ToLower(strings.TrimSpace(q.Get("format")))
+	if format == "" {
+		format = exportFormatJSON
+	}
+	if err := checkExportRange(from, to, format); err != nil {
+		jsonErr(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	anonymous, err := a.wantAnonymous(r)
+	if err != nil {
+		jsonErr(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	w.Header().Set("Content-Type", exportContentTypes[format])
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", fmt.Sprintf("devlog-%s_%s.%s", from, to, format)))
+	out, closeOut := a.compressResponse(w, r)
+	w.WriteHeader(http.StatusOK)
+	n, err := a.writeEntryExport(out, format, from, to, anonymous)
+	closeOut()
+	if err != nil {
+		// The status line is gone; the truncated body is all the client gets.
+		a.logger.Printf("event=export_entries_failed from=%s to=%s format=%s written=%d err=%v", from, to, format, n, err)
+	}
+	_ = a.logUserAction(u, "export_entries", fmt.Sprintf("from=%s to=%s format=%s entries=%d anonymous=%t", from, to, format, n, anonymous))
+}
+
+func runAdminExport(args []string) error {
+	fs := flag.NewFlagSet("admin export", flag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s admin export --out <file> [options]\n\n", binName())
+		fmt.Fprintln(fs.Output(), "Writes every entry between --from and --to, compacted ones included, with")
+		fmt.Fprintln(fs.Output(), "author and timestamp, as JSON, CSV or a single Markdown document.")
+		fmt.Fprintln(fs.Output())
+		fmt.Fprintln(fs.Output(), "Options:")
+		fs.PrintDefaults()
+	}
+	today := time.Now().Format("2006-01-02")
+	from := fs.String("from", today, "first day to export (YYYY-MM-DD)")
+	to := fs.String("to", today, "last day to export (YYYY-MM-DD)")
+	format := fs.String("format", exportFormatJSON, "json, csv or md")
+	out := fs.String("out", "", "output file ('-' for stdout)")
+	anonymous := fs.Bool("anonymize", false, "replace authors and @mentions with \"teammate\"")
+	dbPath := fs.String("db", defaultDBPath, "sqlite db path")
+	logPath := fs.String("log", defaultLogPath, "log target path ('-' for stdout only)")
+	if err := fs.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return nil
+		}
+		return err
+	}
+	if *out == "" {
+		return errors.New("--out is required")
+	}
+	*format = strings.ToLower(strings.TrimSpace(*format))
+	if err := checkExportRange(*from, *to, *format); err != nil {
+		return err
+	}
+
+	app, closeApp, err := openAdminApp(*dbPath, *logPath)
+	if err != nil {
+		return err
+	}
+	defer closeApp()
+
+	var w io.Writer = os.Stdout
+	if *out != "-" {
+		f, err := os.Create(*out)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		w = f
+	}
+	bw := bufio.NewWriter(w)
+	n, err := app.writeEntryExport(bw, *format, *from, *to, *anonymous)
+	if ferr := bw.Flush(); err == nil {
+		err = ferr
+	}
+	if err != nil {
+		return err
+	}
+	_ = app.logAction("admin_cli", "admin", "export_entries", fmt.Sprintf("from=%s to=%s format=%s entries=%d anonymous=%t", *from, *to, *format, n, *anonymous))
+	if *out != "-" {
+		fmt.Printf("exported %d entries to %s\n", n, *out)
+	}
+	return nil
+}
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseCompactContentRoundTrip(t *testing.T) {
+	cases := []struct {
+		name   string
+		source compactSource
+	}{
+		{"plain", compactSource{User: "alice", CreatedAt: "2026-02-17T09:00:00Z", Content: "fixed the login bug"}},
+		{"multi-line", compactSource{User: "bob", CreatedAt: "2026-02-17T10:00:00Z", Content: "first\nsecond"}},
+		{"issue", compactSource{User: "alice", CreatedAt: "2026-02-17T11:00:00Z", Content: "closed ACME-12", Issues: []string{"ACME-12: Login fails (Done)"}}},
+		{"issues and source", compactSource{User: "bob", CreatedAt: "2026-02-17T12:00:00Z", Content: "ACME-3 blocks ENG-7", Source: sourceCLI,
+			Issues: []string{"ACME-3: Flaky deploy (In Progress)", "ENG-7: Retry queue"}}},
+		// A bracket the author wrote is content, not a label: its key is not in the text.
+		{"look-alike label", compactSource{User: "carol", CreatedAt: "2026-02-17T13:00:00Z", Content: "see [OPS-1: runbook]"}},
+		{"look-alike source", compactSource{User: "carol", CreatedAt: "2026-02-17T14:00:00Z", Content: "posted (via pigeon)"}},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			got := parseCompactContent(renderCompact("2026-02-17", []compactSource{tc.source}))
+			if len(got) != 1 {
+				t.Fatalf("parsed %d lines, want 1: %+v", len(got), got)
+			}
+			g := got[0]
+			if g.User != tc.source.User || g.CreatedAt != tc.source.CreatedAt || g.Content != tc.source.Content ||
+				g.Source != tc.source.Source || !slices.Equal(g.Issues, tc.source.Issues) {
+				t.Fatalf("round trip = %+v, want %+v", g, tc.source)
+			}
+		})
+	}
+}
+
+func TestExportDailyNoteLegacyCompactWithIssues(t *testing.T) {
+	app := newTestApp(t)
+	day := "2026-02-17"
+	sources := []compactSource{
+		{User: "alice", CreatedAt: day + "T09:00:00Z", Content: "closed ACME-12", Issues: []string{"ACME-12: Login fails (Done)"}},
+		{User: "alice", CreatedAt: day + "T10:00:00Z", Content: "shipped", Source: sourceCLI},
+	}
+	// Compacts written before compact_data existed only have the text.
+	if _, err := app.db.Exec(`INSERT INTO entries(user_id, entry_type, content, created_at) VALUES(?, 'daily_compact', ?, ?)`,
+		actorSystem.ID, renderCompact(day, sources), day+"T17:00:00Z"); err != nil {
+		t.Fatal(err)
+	}
+	entries, err := app.dayNoteEntries(day)
+	if err != nil {
+		t.Fatalf("dayNoteEntries: %v", err)
+	}
+	note := renderDailyNote(day, entries)
+	if !strings.Contains(note, "- 09:00 closed ACME-12\n") || !strings.Contains(note, "- 10:00 shipped\n") {
+		t.Fatalf("note lines:\n%s", note)
+	}
+	if strings.Contains(note, "Login fails") || strings.Contains(note, "(via") {
+		t.Fatalf("note kept compact trailers:\n%s", note)
+	}
+}
+
+func TestAPIExportDailyNoteAfterCompaction(t *testing.T) {
+	app := newTestApp(t)
+	h := newTestMux(app)
+	createUser(t, app, "alice", "PUDNOTEAAA2")
+	createUser(t, app, "bob", "PUDNOTEBBB2")
+
+	for token, content := range map[string]string{
+		"PUDNOTEAAA2": "fixed login\nand wrote tests",
+		"PUDNOTEBBB2": "reviewed PRs",
+	} {
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, authedReq(t, http.MethodPost, "/api/entries", map[string]string{"content": content}, token))
+		if rr.Code != http.StatusCreated {
+			t.Fatalf("expected 201, got %d body=%s", rr.Code, rr.Body.String())
+		}
+	}
+	day := time.Now().UTC().Format("2006-01-02")
+	if err := app.compactDay(day); err != nil {
+		t.Fatalf("compactDay: %v", err)
+	}
+
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, authedReq(t, http.MethodGet, "/api/export/daily-note?day="+day, nil, "PUDNOTEAAA2"))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d body=%s", rr.Code, rr.Body.String())
+	}
+	note := rr.Body.String()
+	for _, want := range []string{"date: " + day, "authors: [alice, bob]", "entries: 2", "## alice", "fixed login\n  and wrote tests", "## bob"} {
+		if !bytes.Contains([]byte(note), []byte(want)) {
+			t.Fatalf("daily note missing %q:\n%s", want, note)
+		}
+	}
+}
+
+func TestExportEntriesRange(t *testing.T) {
+	app := newTestApp(t)
+	h := newTestMux(app)
+	createUser(t, app, "alice", "PUDEXPORT01")
+	alice, err := app.lookupToken("PUDEXPORT01")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for content, at := range map[string]string{
+		"fixed login, finally":   "2026-02-16T09:00:00Z",
+		"wrote tests\nfor login": "2026-02-16T10:00:00Z",
+		"reviewed PRs":           "2026-02-17T11:00:00Z",
+	} {
+		if _, err := app.insertEntry(alice.ID, content, "", sourceAPI, at); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := app.compactDay("2026-02-16"); err != nil {
+		t.Fatalf("compactDay: %v", err)
+	}
+	get := func(query string) *httptest.ResponseRecorder {
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, authedReq(t, http.MethodGet, "/api/export?"+query, nil, "PUDEXPORT01"))
+		return rr
+	}
+
+	rr := get("from=2026-02-16&to=2026-02-17")
+	var doc struct {
+		Entries []exportEntry `json:"entries"`
+		Count   int           `json:"count"`
+	}
+	if err := json.Unmarshal(rr.Body.Bytes(), &doc); err != nil || rr.Code != http.StatusOK || doc.Count != 3 || len(doc.Entries) != 3 {
+		t.Fatalf("json export: %d %s", rr.Code, rr.Body.String())
+	}
+	if !doc.Entries[0].Compacted || doc.Entries[0].User != "alice" || doc.Entries[2].Compacted || doc.Entries[2].Day != "2026-02-17" {
+		t.Fatalf("json entries: %+v", doc.Entries)
+	}
+
+	rr = get("from=2026-02-16&to=2026-02-17&format=csv")
+	records, err := csv.NewReader(rr.Body).ReadAll()
+	if err != nil || len(records) != 4 || records[0][0] != "day" {
+		t.Fatalf("csv export: %v %q", err, records)
+	}
+	if records[1][4] != "fixed login, finally" || records[2][4] != "wrote tests\nfor login" || records[1][3] != "true" {
+		t.Fatalf("csv rows: %q", records)
+	}
+
+	rr = get("from=2026-02-16&to=2026-02-17&format=md")
+	for _, want := range []string{"## 2026-02-16", "## 2026-02-17", "- 09:00 **alice** fixed login, finally", "wrote tests\n  for login"} {
+		if !strings.Contains(rr.Body.String(), want) {
+			t.Fatalf("markdown export missing %q:\n%s", want, rr.Body.String())
+		}
+	}
+
+	for _, q := range []string{"from=2026-02-17&to=2026-02-16", "format=xml", "from=2000-01-01&to=2026-02-17"} {
+		if rr := get(q); rr.Code != http.StatusBadRequest {
+			t.Fatalf("%s: %d", q, rr.Code)
+		}
+	}
+	var logged int
+	if err := app.db.QueryRow(`SELECT COUNT(*) FROM action_logs WHERE action = 'export_entries'`).Scan(&logged); err != nil || logged != 3 {
+		t.Fatalf("export_entries audit rows: %d %v", logged, err)
+	}
+}
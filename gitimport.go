@@ -0,0 +1,301 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+type gitCommit struct {
+	SHA   string
+	Email string
+	// CommittedAt is the committer date, which git log --since/--until select
+	// on; the author date of a rebased or cherry-picked commit can be days older.
+	CommittedAt time.Time
+	Subject     string
+}
+
+// errDayCompacted is returned by importGitDay for a day whose compaction has
+// already run; the hourly loop treats it as nothing to do.
+var errDayCompacted = errors.New("already compacted")
+
+// gitCommitsForDay lists non-merge commits on any ref whose commit date falls
+// on day (UTC), oldest commit first.
+func gitCommitsForDay(ctx context.Context, repo, day string) ([]gitCommit, error) {
+	start, err := time.Parse("2006-01-02", day)
+	if err != nil {
+		return nil, err
+	}
+	end := start.AddDate(0, 0, 1)
+	cmd := exec.CommandContext(ctx, "git", "-C", repo, "log", "--all", "--no-merges",
+		"--since="+start.Format(time.RFC3339), "--until="+end.Format(time.RFC3339),
+		"--format=%H%x1f%ae%x1f%cI%x1f%s")
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("git log %s: %v: %s", repo, err, strings.TrimSpace(stderr.String()))
+	}
+	var commits []gitCommit
+	for _, line := range strings.Split(strings.TrimSpace(string(out)), "\n") {
+		parts := strings.SplitN(line, "\x1f", 4)
+		if len(parts) != 4 {
+			continue
+		}
+		at, err := time.Parse(time.RFC3339, parts[2])
+		if err != nil {
+			continue
+		}
+		commits = append(commits, gitCommit{SHA: parts[0], Email: strings.ToLower(parts[1]), CommittedAt: at, Subject: parts[3]})
+	}
+	sort.Slice(commits, func(i, j int) bool { return commits[i].CommittedAt.Before(commits[j].CommittedAt) })
+	return commits, nil
+}
+
+// gitEntryTime stamps the entry for commits imported on day with the last
+// commit time, kept inside day: --until is inclusive, and an entry dated on
+// another day could land in one that is already compacted.
+func gitEntryTime(day string, cs []gitCommit) string {
+	start, _ := time.Parse("2006-01-02", day)
+	at := cs[len(cs)-1].CommittedAt.UTC()
+	if at.Before(start) {
+		at = start
+	}
+	if last := start.AddDate(0, 0, 1).Add(-time.Second); at.After(last) {
+		at = last
+	}
+	return at.Format(time.RFC3339)
+}
+
+// importGitDay creates one entry per mapped author per repository for commits
+// on day that have not been imported yet. It returns the number of entries created.
+func (a *App) importGitDay(ctx context.Context, repos []string, day string) (int, error) {
+	ran, err := a.compactionAlreadyRan(day)
+	if err != nil {
+		return 0, err
+	}
+	if ran {
+		return 0, fmt.Errorf("day %s is %w", day, errDayCompacted)
+	}
+
+	created := 0
+	for _, repo := range repos {
+		commits, err := gitCommitsForDay(ctx, repo, day)
+		if err != nil {
+			return created, err
+		}
+		byUser := map[int64][]gitCommit{}
+		var userIDs []int64
+		for _, c := range commits {
+			var seen int
+			if err := a.db.QueryRow(`SELECT COUNT(*) FROM imported_commits WHERE sha = ?`, c.SHA).Scan(&seen); err != nil {
+				return created, err
+			}
+			if seen > 0 {
+				continue
+			}
+			author, err := a.resolveIdentity(providerEmail, c.Email)
+			if errors.Is(err, errUnknownIdentity) {
+				continue
+			}
+			if err != nil {
+				return created, err
+			}
+			uid := author.ID
+			if _, ok := byUser[uid]; !ok {
+				userIDs = append(userIDs, uid)
+			}
+			byUser[uid] = append(byUser[uid], c)
+		}
+
+		name := filepath.Base(filepath.Clean(repo))
+		for _, uid := range userIDs {
+			cs := byUser[uid]
+			var b strings.Builder
+			fmt.Fprintf(&b, "[git] %s: %d commit(s)", name, len(cs))
+			for _, c := range cs {
+				b.WriteString("\n- ")
+				b.WriteString(c.SHA[:7])
+				b.WriteString(" ")
+				b.WriteString(c.Subject)
+			}
+			createdAt := gitEntryTime(day, cs)
+			// Bulk imports share the global write limiter with API writers.
+			release, err := a.writeLimit.acquire(ctx)
+			if err != nil {
+				return created, err
+			}
+			err = a.insertGitEntry(uid, b.String(), createdAt, cs)
+			release()
+			if err != nil {
+				return created, err
+			}
+			created++
+		}
+	}
+	_ = a.logActorAction(actorGitImporter, "import_git", fmt.Sprintf("day=%s repos=%d entries=%d", day, len(repos), created))
+	return created, nil
+}
+
+// gitImportLoop re-imports the current UTC day hourly so commits show up
+// even when nobody writes an entry.
+func (a *App) gitImportLoop(ctx context.Context, repos []string) {
+	ticker := time.NewTicker(time.Hour)
+	defer ticker.Stop()
+	a.health.register(subsystemGitImport, time.Hour, time.Now())
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if a.writeLocked.Load() {
+				a.health.record(subsystemGitImport, time.Now(), nil)
+				continue
+			}
+			day := time.Now().UTC().Format("2006-01-02")
+			_, err := a.importGitDay(ctx, repos, day)
+			if errors.Is(err, errDayCompacted) {
+				err = nil
+			}
+			if err != nil {
+				a.logger.Printf("event=git_import_failed day=%s err=%v", day, err)
+			}
+			a.health.record(subsystemGitImport, time.Now(), err)
+		}
+	}
+}
+
+// insertGitEntry stores an entry and its imported_commits rows in one
+// transaction, so a failure in between cannot import the commits again.
+func (a *App) insertGitEntry(uid int64, content, createdAt string, cs []gitCommit) error {
+	content, secrets := a.scanImportedSecrets(content)
+	tx, err := a.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer func() { _ = tx.Rollback() }()
+	res, err := tx.Exec(`INSERT INTO entries(user_id, entry_type, content, category, source, created_at) VALUES(?, 'normal', ?, '', ?, ?)`, uid, content, sourceGit, createdAt)
+	if err != nil {
+		return err
+	}
+	id, err := res.LastInsertId()
+	if err != nil {
+		return err
+	}
+	for _, c := range cs {
+		if _, err := tx.Exec(`INSERT INTO imported_commits(sha, entry_id, imported_at) VALUES(?, ?, ?)`, c.SHA, id, nowUTC()); err != nil {
+			return err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	a.logImportedSecrets(actorGitImporter, id, secrets)
+	return nil
+}
+
+func runImport(args []string) error {
+	if len(args) == 0 || args[0] == "help" || args[0] == "-h" || args[0] == "--help" {
+		printImportUsage()
+		return nil
+	}
+	switch args[0] {
+	case "git":
+		return runImportGit(args[1:])
+	case wikiNotion, wikiConfluence:
+		return runImportWiki(args[0], args[1:])
+	default:
+		printImportUsage()
+		return fmt.Errorf("unknown import source: %s", args[0])
+	}
+}
+
+func printImportUsage() {
+	fmt.Printf("Usage: %s import <source> [options]\n\n", binName())
+	fmt.Println("Sources:")
+	fmt.Println("  git         Create entries from commits in local git repositories")
+	fmt.Println("  notion      Create dated entries from a Notion Markdown export")
+	fmt.Println("  confluence  Create dated entries from a Confluence space XML export")
+	fmt.Println()
+	fmt.Printf("Try: %s import git --help\n", binName())
+}
+
+func runImportGit(args []string) error {
+	fs := flag.NewFlagSet("import git", flag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s import git --repo <path> [--repo <path>...] [options]\n\n", binName())
+		fmt.Fprintln(fs.Output(), "Creates one entry per author and repository from the day's commits.")
+		fmt.Fprintln(fs.Output(), "Authors are mapped by email with 'admin map-git-author'; unmapped commits are skipped.")
+		fmt.Fprintln(fs.Output(), "Already-imported commits are never imported twice.")
+		fmt.Fprintln(fs.Output())
+		fmt.Fprintln(fs.Output(), "Options:")
+		fs.PrintDefaults()
+	}
+	var repos stringList
+	fs.Var(&repos, "repo", "path to a git repository (repeatable)")
+	day := fs.String("day", time.Now().UTC().Format("2006-01-02"), "day to import (YYYY-MM-DD, UTC)")
+	dbPath := fs.String("db", defaultDBPath, "sqlite db path")
+	logPath := fs.String("log", defaultLogPath, "log target path ('-' for stdout only)")
+	if err := fs.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return nil
+		}
+		return err
+	}
+	if len(repos) == 0 {
+		return errors.New("at least one --repo is required")
+	}
+	if _, err := time.Parse("2006-01-02", *day); err != nil {
+		return errors.New("--day must be YYYY-MM-DD")
+	}
+
+	app, closeApp, err := openAdminApp(*dbPath, *logPath)
+	if err != nil {
+		return err
+	}
+	defer closeApp()
+
+	n, err := app.importGitDay(context.Background(), repos, *day)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("imported %d entries for %s\n", n, *day)
+	return nil
+}
+
+// runAdminMapGitAuthor is kept for existing scripts; it creates an "email"
+// identity link, which inbound email resolves through as well.
+func runAdminMapGitAuthor(args []string) error {
+	fs := flag.NewFlagSet("admin map-git-author", flag.ContinueOnError)
+	email := fs.String("email", "", "commit author email")
+	username := fs.String("username", "", "user the email maps to")
+	dbPath := fs.String("db", defaultDBPath, "sqlite db path")
+	logPath := fs.String("log", defaultLogPath, "log target path ('-' for stdout only)")
+	if err := fs.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return nil
+		}
+		return err
+	}
+	if strings.TrimSpace(*email) == "" || strings.TrimSpace(*username) == "" {
+		return errors.New("--email and --username are required")
+	}
+	return runIdentityLinkCommand(providerEmail, *email, *username, false, false, *dbPath, *logPath)
+}
+
+// stringList is a repeatable string flag.
+type stringList []string
+
+func (s *stringList) String() string { return strings.Join(*s, ",") }
+
+func (s *stringList) Set(v string) error {
+	*s = append(*s, v)
+	return nil
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"os/exec"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestImportGitDay(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not installed")
+	}
+	repo := t.TempDir()
+	now := time.Now().UTC().Format(time.RFC3339)
+	for _, args := range [][]string{
+		{"init", "-q"},
+		{"-c", "user.email=Alice@Example.com", "-c", "user.name=alice", "commit", "-q", "--allow-empty", "-m", "fix login timeout"},
+		{"-c", "user.email=stranger@example.com", "-c", "user.name=stranger", "commit", "-q", "--allow-empty", "-m", "unmapped work"},
+	} {
+		cmd := exec.Command("git", append([]string{"-C", repo}, args...)...)
+		cmd.Env = append(cmd.Environ(), "GIT_AUTHOR_DATE="+now, "GIT_COMMITTER_DATE="+now)
+		if out, err := cmd.CombinedOutput(); err != nil {
+			t.Fatalf("git %v: %v: %s", args, err, out)
+		}
+	}
+
+	app := newTestApp(t)
+	createUser(t, app, "alice", "PUDGITAAAA2")
+	if _, err := app.db.Exec(`INSERT INTO identity_links(provider, external_id, user_id, created_at) VALUES('email', 'alice@example.com', 1, '')`); err != nil {
+		t.Fatalf("map author: %v", err)
+	}
+
+	day := time.Now().UTC().Format("2006-01-02")
+	n, err := app.importGitDay(context.Background(), []string{repo}, day)
+	if err != nil {
+		t.Fatalf("importGitDay: %v", err)
+	}
+	if n != 1 {
+		t.Fatalf("expected 1 entry, got %d", n)
+	}
+	var content string
+	if err := app.db.QueryRow(`SELECT content FROM entries WHERE user_id = 1`).Scan(&content); err != nil {
+		t.Fatalf("query entry: %v", err)
+	}
+	if !strings.Contains(content, "1 commit(s)") || !strings.Contains(content, "fix login timeout") {
+		t.Fatalf("unexpected entry content: %q", content)
+	}
+
+	n, err = app.importGitDay(context.Background(), []string{repo}, day)
+	if err != nil {
+		t.Fatalf("second importGitDay: %v", err)
+	}
+	if n != 0 {
+		t.Fatalf("expected re-import to be a no-op, got %d entries", n)
+	}
+}
+
+func TestImportGitDayStampsRebasedCommitsOnTheDay(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not installed")
+	}
+	repo := t.TempDir()
+	now := time.Now().UTC()
+	authored := now.AddDate(0, 0, -5).Format(time.RFC3339)
+	for _, args := range [][]string{
+		{"init", "-q"},
+		{"-c", "user.email=alice@example.com", "-c", "user.name=alice", "commit", "-q", "--allow-empty", "-m", "rebased fix"},
+	} {
+		cmd := exec.Command("git", append([]string{"-C", repo}, args...)...)
+		cmd.Env = append(cmd.Environ(), "GIT_AUTHOR_DATE="+authored, "GIT_COMMITTER_DATE="+now.Format(time.RFC3339))
+		if out, err := cmd.CombinedOutput(); err != nil {
+			t.Fatalf("git %v: %v: %s", args, err, out)
+		}
+	}
+	app := newTestApp(t)
+	createUser(t, app, "alice", "PUDGITREB01")
+	if _, err := app.db.Exec(`INSERT INTO identity_links(provider, external_id, user_id, created_at) VALUES('email', 'alice@example.com', 1, '')`); err != nil {
+		t.Fatalf("map author: %v", err)
+	}
+	day := now.Format("2006-01-02")
+	if n, err := app.importGitDay(context.Background(), []string{repo}, day); err != nil || n != 1 {
+		t.Fatalf("importGitDay = %d, %v", n, err)
+	}
+	var createdAt string
+	if err := app.db.QueryRow(`SELECT created_at FROM entries WHERE source = 'git'`).Scan(&createdAt); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(createdAt, day) {
+		t.Fatalf("entry stamped %s, want a time on %s", createdAt, day)
+	}
+
+	if err := app.compactDay(day); err != nil {
+		t.Fatalf("compactDay: %v", err)
+	}
+	if _, err := app.importGitDay(context.Background(), []string{repo}, day); !errors.Is(err, errDayCompacted) {
+		t.Fatalf("import after compaction: %v, want errDayCompacted", err)
+	}
+}
+
+func TestGitEntryTime(t *testing.T) {
+	at := func(s string) []gitCommit {
+		ts, _ := time.Parse(time.RFC3339, s)
+		return []gitCommit{{CommittedAt: ts}}
+	}
+	for _, tc := range []struct {
+		commit, want string
+	}{
+		{"2026-02-17T10:30:00Z", "2026-02-17T10:30:00Z"},
+		{"2026-02-18T00:00:00Z", "2026-02-17T23:59:59Z"},
+		{"2026-02-17T01:00:00+02:00", "2026-02-17T00:00:00Z"},
+	} {
+		if got := gitEntryTime("2026-02-17", at(tc.commit)); got != tc.want {
+			t.Errorf("gitEntryTime(%s) = %s, want %s", tc.commit, got, tc.want)
+		}
+	}
+}
+
+func TestInsertGitEntryIsAtomic(t *testing.T) {
+	app := newTestApp(t)
+	createUser(t, app, "alice", "PUDGITTX001")
+	sha := strings.Repeat("ab", 20)
+	// The duplicate sha fails the second imported_commits insert.
+	err := app.insertGitEntry(1, "[git] repo: 2 commit(s)", nowUTC(), []gitCommit{{SHA: sha}, {SHA: sha}})
+	if err == nil {
+		t.Fatal("expected the duplicate commit to fail the import")
+	}
+	var entries, commits int
+	if err := app.db.QueryRow(`SELECT (SELECT COUNT(*) FROM entries), (SELECT COUNT(*) FROM imported_commits)`).Scan(&entries, &commits); err != nil {
+		t.Fatal(err)
+	}
+	if entries != 0 || commits != 0 {
+		t.Fatalf("failed import left %d entries and %d commits", entries, commits)
+	}
+}
@@ -0,0 +1,329 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Grafana datasource: /api/grafana speaks the JSON datasource protocol
+// (GET / to test, POST /metrics or /search to list targets, POST /query for
+// time series) and answers GET /api/grafana/query with flat rows for the
+// Infinity datasource. Series are daily: entries posted and participation
+// come from the action log, so compaction does not erase them; compaction
+// series come from the compactions table and need compactions.read.
+
+const maxGrafanaRange = 366 * 24 * time.Hour
+
+type grafanaMetric struct {
+	Value      string `json:"value"`
+	Label      string `json:"label"`
+	compaction bool
+}
+
+var grafanaMetrics = []grafanaMetric{
+	{Value: "entries", Label: "Entries posted per day"},
+	{Value: "entries_by_user", Label: "Entries posted per day, one series per user"},
+	{Value: "active_users", Label: "Users who posted per day"},
+	{Value: "participation", Label: "Share of human users who posted per day (0-1)"},
+	{Value: "compaction_merged", Label: "Entries merged by the day's compaction", compaction: true},
+	{Value: "compaction_bytes_before", Label: "Content bytes before the day's compaction", compaction: true},
+	{Value: "compaction_bytes_after", Label: "Compact bytes after the day's compaction", compaction: true},
+	{Value: "compaction_duration_ms", Label: "Duration of the day's compaction in milliseconds", compaction: true},
+}
+
+func findGrafanaMetric(name string) (grafanaMetric, bool) {
+	for _, m := range grafanaMetrics {
+		if m.Value == name {
+			return m, true
+		}
+	}
+	return grafanaMetric{}, false
+}
+
+// grafanaSeries is one time series; datapoints are [value, unix ms] pairs
+// at UTC midnight of each day.
+type grafanaSeries struct {
+	Target     string       `json:"target"`
+	Datapoints [][2]float64 `json:"datapoints"`
+}
+
+// grafanaRow is a flat data point for the Infinity datasource.
+type grafanaRow struct {
+	Time   string  `json:"time"`
+	Target string  `json:"target"`
+	Value  float64 `json:"value"`
+}
+
+// parseGrafanaTime accepts RFC 3339 (Grafana's range.from/to) or unix
+// milliseconds (${__from} and ${__to} in Infinity URLs).
+func parseGrafanaTime(s string) (time.Time, error) {
+	s = strings.TrimSpace(s)
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t, nil
+	}
+	if ms, err := strconv.ParseInt(s, 10, 64); err == nil {
+		return time.UnixMilli(ms), nil
+	}
+	return time.Time{}, fmt.Errorf("invalid time %q (want RFC 3339 or unix milliseconds)", s)
+}
+
+// grafanaDays lists the UTC days from from to to, inclusive.
+func grafanaDays(from, to time.Time) []time.Time {
+	var days []time.Time
+	y, m, d := from.UTC().Date()
+	last := to.UTC().Format("2006-01-02")
+	for day := time.Date(y, m, d, 0, 0, 0, 0, time.UTC); day.Format("2006-01-02") <= last; day = day.AddDate(0, 0, 1) {
+		days = append(days, day)
+	}
+	return days
+}
+
+// grafanaSeriesFor computes metric over the days of [from, to].
+func (a *App) grafanaSeriesFor(metric grafanaMetric, from, to time.Time) ([]grafanaSeries, error) {
+	days := grafanaDays(from, to)
+	if len(days) == 0 {
+		return []grafanaSeries{}, nil
+	}
+	first := days[0].Format("2006-01-02")
+	end := days[len(days)-1].AddDate(0, 0, 1).Format("2006-01-02")
+	if metric.compaction {
+		return a.grafanaCompactionSeries(metric.Value, first, days[len(days)-1].Format("2006-01-02"))
+	}
+
+	// Per day and poster, from the action log like admin usage.
+	rows, err := a.db.Query(`
+SELECT substr(created_at, 1, 10), actor_username, COUNT(*)
+FROM action_logs
+WHERE actor_type = 'api_user' AND action IN ('create_entry', 'queue_entry') AND created_at >= ? AND created_at < ?
+GROUP BY 1, 2`, first, end)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	perUser := map[string]map[string]float64{}
+	entries := map[string]float64{}
+	active := map[string]float64{}
+	for rows.Next() {
+		var day, user string
+		var n float64
+		if err := rows.Scan(&day, &user, &n); err != nil {
+			return nil, err
+		}
+		if perUser[user] == nil {
+			perUser[user] = map[string]float64{}
+		}
+		perUser[user][day] = n
+		entries[day] += n
+		active[day]++
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	fill := func(target string, values map[string]float64) grafanaSeries {
+		s := grafanaSeries{Target: target, Datapoints: make([][2]float64, 0, len(days))}
+		for _, d := range days {
+			s.Datapoints = append(s.Datapoints, [2]float64{values[d.Format("2006-01-02")], float64(d.UnixMilli())})
+		}
+		return s
+	}
+	switch metric.Value {
+	case "entries":
+		return []grafanaSeries{fill("entries", entries)}, nil
+	case "active_users":
+		return []grafanaSeries{fill("active_users", active)}, nil
+	case "participation":
+		var humans float64
+		if err := a.db.QueryRow(`SELECT COUNT(*) FROM users WHERE kind = ?`, kindHuman).Scan(&humans); err != nil {
+			return nil, err
+		}
+		ratio := map[string]float64{}
+		if humans > 0 {
+			for day, n := range active {
+				ratio[day] = n / humans
+			}
+		}
+		return []grafanaSeries{fill("participation", ratio)}, nil
+	}
+	users := make([]string, 0, len(perUser))
+	for user := range perUser {
+		users = append(users, user)
+	}
+	sort.Strings(users)
+	out := []grafanaSeries{}
+	for _, user := range users {
+		out = append(out, fill("entries:"+user, perUser[user]))
+	}
+	return out, nil
+}
+
+// grafanaCompactionSeries has a point for each compacted day in [first, last].
+func (a *App) grafanaCompactionSeries(metric, first, last string) ([]grafanaSeries, error) {
+	column := map[string]string{
+		"compaction_merged":       "merged_count",
+		"compaction_bytes_before": "bytes_before",
+		"compaction_bytes_after":  "bytes_after",
+		"compaction_duration_ms":  "duration_ms",
+	}[metric]
+	rows, err := a.db.Query(`SELECT day, `+column+` FROM compactions WHERE day >= ? AND day <= ? ORDER BY day ASC`, first, last)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	s := grafanaSeries{Target: metric, Datapoints: [][2]float64{}}
+	for rows.Next() {
+		var day string
+		var v float64
+		if err := rows.Scan(&day, &v); err != nil {
+			return nil, err
+		}
+		t, err := time.Parse("2006-01-02", day)
+		if err != nil {
+			continue
+		}
+		s.Datapoints = append(s.Datapoints, [2]float64{v, float64(t.UnixMilli())})
+	}
+	return []grafanaSeries{s}, rows.Err()
+}
+
+// handleGrafanaRoot answers the datasource connection test.
+func (a *App) handleGrafanaRoot(w http.ResponseWriter, r *http.Request, u AuthedUser) {
+	if r.Method != http.MethodGet {
+		jsonErr(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	jsonOut(w, http.StatusOK, map[string]string{"status": "ok"})
+}
+
+// handleGrafanaMetrics serves POST /api/grafana/metrics ([{value,label}])
+// and POST /api/grafana/search (names only), listing the targets u may query.
+func (a *App) handleGrafanaMetrics(w http.ResponseWriter, r *http.Request, u AuthedUser) {
+	if r.Method != http.MethodPost && r.Method != http.MethodGet {
+		jsonErr(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	withCompactions := a.policy.allows(u.Role, actionCompactionsRead)
+	metrics := []grafanaMetric{}
+	names := []string{}
+	for _, m := range grafanaMetrics {
+		if m.compaction && !withCompactions {
+			continue
+		}
+		metrics = append(metrics, m)
+		names = append(names, m.Value)
+	}
+	if strings.HasSuffix(r.URL.Path, "/search") {
+		jsonOut(w, http.StatusOK, names)
+		return
+	}
+	jsonOut(w, http.StatusOK, metrics)
+}
+
+// handleGrafanaQuery serves POST /api/grafana/query
+// {"range":{"from","to"},"targets":[{"target"}]} with time series, and
+// GET /api/grafana/query?target=&from=&to= with flat rows.
+func (a *App) handleGrafanaQuery(w http.ResponseWriter, r *http.Request, u AuthedUser) {
+	var targets []string
+	var rawFrom, rawTo string
+	switch r.Method {
+	case http.MethodPost:
+		var req struct {
+			Range struct {
+				From string `json:"from"`
+				To   string `json:"to"`
+			} `json:"range"`
+			Targets []struct {
+				Target string `json:"target"`
+				Hide   bool   `json:"hide"`
+			} `json:"targets"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			jsonErr(w, http.StatusBadRequest, "invalid json")
+			return
+		}
+		rawFrom, rawTo = req.Range.From, req.Range.To
+		for _, t := range req.Targets {
+			if !t.Hide && strings.TrimSpace(t.Target) != "" {
+				targets = append(targets, strings.TrimSpace(t.Target))
+			}
+		}
+	case http.MethodGet:
+		q := r.URL.Query()
+		rawFrom, rawTo = q.Get("from"), q.Get("to")
+		for _, t := range q["target"] {
+			targets = append(targets, splitList(t)...)
+		}
+	default:
+		jsonErr(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	now := time.Now()
+	from, to := now.AddDate(0, 0, -30), now
+	var err error
+	if strings.TrimSpace(rawFrom) != "" {
+		if from, err = parseGrafanaTime(rawFrom); err != nil {
+			jsonErr(w, http.StatusBadRequest, "from: "+err.Error())
+			return
+		}
+	}
+	if strings.TrimSpace(rawTo) != "" {
+		if to, err = parseGrafanaTime(rawTo); err != nil {
+			jsonErr(w, http.StatusBadRequest, "to: "+err.Error())
+			return
+		}
+	}
+	if to.Before(from) || to.Sub(from) > maxGrafanaRange {
+		jsonErr(w, http.StatusBadRequest, "range must end after it starts and span at most 366 days")
+		return
+	}
+	anonymous, err := a.wantAnonymous(r)
+	if err != nil {
+		jsonErr(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	metrics := make([]grafanaMetric, 0, len(targets))
+	for _, t := range targets {
+		m, ok := findGrafanaMetric(t)
+		if !ok {
+			jsonErr(w, http.StatusBadRequest, fmt.Sprintf("unknown target %q", t))
+			return
+		}
+		if m.compaction && !a.policy.allows(u.Role, actionCompactionsRead) {
+			jsonOut(w, http.StatusForbidden, map[string]string{"error": "permission denied", "action": actionCompactionsRead})
+			return
+		}
+		if m.Value == "entries_by_user" && anonymous {
+			jsonErr(w, http.StatusBadRequest, "entries_by_user is not available in anonymous mode")
+			return
+		}
+		metrics = append(metrics, m)
+	}
+
+	series := []grafanaSeries{}
+	for _, m := range metrics {
+		s, err := a.grafanaSeriesFor(m, from, to)
+		if err != nil {
+			jsonErr(w, http.StatusInternalServerError, "failed to query metrics")
+			return
+		}
+		series = append(series, s...)
+	}
+	_ = a.logUserAction(u, "grafana_query", fmt.Sprintf("targets=%s from=%s to=%s", strings.Join(targets, ","), from.UTC().Format(time.RFC3339), to.UTC().Format(time.RFC3339)))
+	if r.Method == http.MethodPost {
+		jsonOut(w, http.StatusOK, series)
+		return
+	}
+	rows := []grafanaRow{}
+	for _, s := range series {
+		for _, p := range s.Datapoints {
+			rows = append(rows, grafanaRow{Time: time.UnixMilli(int64(p[1])).UTC().Format(time.RFC3339), Target: s.Target, Value: p[0]})
+		}
+	}
+	jsonOut(w, http.StatusOK, rows)
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestGrafanaDatasource(t *testing.T) {
+	app := newTestApp(t)
+	h := newTestMux(app)
+	createUser(t, app, "alice", "PUDGRAFANA1")
+	createUser(t, app, "bob", "PUDGRAFANA2")
+	createUser(t, app, "root", "PUDGRAFANA3")
+	if _, err := app.db.Exec(`UPDATE users SET role = 'admin' WHERE username = 'root'`); err != nil {
+		t.Fatal(err)
+	}
+	do := func(method, path string, body any, token string) *httptest.ResponseRecorder {
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, authedReq(t, method, path, body, token))
+		return rr
+	}
+	for _, token := range []string{"PUDGRAFANA1", "PUDGRAFANA1", "PUDGRAFANA2"} {
+		if rr := do(http.MethodPost, "/api/entries", map[string]string{"content": "grafana point"}, token); rr.Code != http.StatusCreated {
+			t.Fatalf("post: %d %s", rr.Code, rr.Body.String())
+		}
+	}
+	if rr := do(http.MethodGet, "/api/grafana/", nil, "PUDGRAFANA1"); rr.Code != http.StatusOK {
+		t.Fatalf("connection test: %d %s", rr.Code, rr.Body.String())
+	}
+	var names []string
+	if rr := do(http.MethodPost, "/api/grafana/search", map[string]string{}, "PUDGRAFANA1"); json.Unmarshal(rr.Body.Bytes(), &names) != nil || strings.Contains(strings.Join(names, " "), "compaction_") || !strings.Contains(strings.Join(names, " "), "participation") {
+		t.Fatalf("member search: %d %s", rr.Code, rr.Body.String())
+	}
+
+	today := time.Now().UTC().Format("2006-01-02")
+	if err := app.compactDay(today); err != nil {
+		t.Fatalf("compactDay: %v", err)
+	}
+	now := time.Now().UTC()
+	query := map[string]any{
+		"range":   map[string]string{"from": now.Add(-48 * time.Hour).Format(time.RFC3339), "to": now.Format(time.RFC3339)},
+		"targets": []map[string]string{{"target": "entries"}, {"target": "entries_by_user"}, {"target": "participation"}},
+	}
+	rr := do(http.MethodPost, "/api/grafana/query", query, "PUDGRAFANA1")
+	var series []grafanaSeries
+	if err := json.Unmarshal(rr.Body.Bytes(), &series); err != nil || rr.Code != http.StatusOK {
+		t.Fatalf("query: %d %s", rr.Code, rr.Body.String())
+	}
+	got := map[string]float64{}
+	for _, s := range series {
+		if len(s.Datapoints) != 3 {
+			t.Fatalf("%s: want a point per day, got %v", s.Target, s.Datapoints)
+		}
+		got[s.Target] = s.Datapoints[2][0]
+	}
+	// Counts survive compaction; 2 of 3 humans posted today.
+	if got["entries"] != 3 || got["entries:alice"] != 2 || got["entries:bob"] != 1 || got["participation"] != 2.0/3 {
+		t.Fatalf("series: %v", got)
+	}
+
+	query["targets"] = []map[string]string{{"target": "compaction_merged"}}
+	if rr := do(http.MethodPost, "/api/grafana/query", query, "PUDGRAFANA1"); rr.Code != http.StatusForbidden {
+		t.Fatalf("member compaction query: expected 403, got %d", rr.Code)
+	}
+	rr = do(http.MethodGet, "/api/grafana/query?target=compaction_merged&from="+strconv.FormatInt(now.Add(-time.Hour).UnixMilli(), 10), nil, "PUDGRAFANA3")
+	var rows []grafanaRow
+	if err := json.Unmarshal(rr.Body.Bytes(), &rows); err != nil || rr.Code != http.StatusOK || len(rows) != 1 || rows[0].Value != 3 || rows[0].Time != today+"T00:00:00Z" {
+		t.Fatalf("infinity rows: %d %s", rr.Code, rr.Body.String())
+	}
+	if rr := do(http.MethodGet, "/api/grafana/query?target=nope", nil, "PUDGRAFANA3"); rr.Code != http.StatusBadRequest {
+		t.Fatalf("unknown target: expected 400, got %d", rr.Code)
+	}
+}
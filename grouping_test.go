@@ -0,0 +1,88 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCompactGroupingPerUser(t *testing.T) {
+	app := newTestApp(t)
+	h := newTestMux(app)
+	createUser(t, app, "alice", "PUDGROUPAL1")
+	createUser(t, app, "bob", "PUDGROUPBO1")
+	bad := "per_team"
+	if _, _, err := app.updateSettings(settingsPatch{CompactGrouping: &bad}, "admin"); err == nil {
+		t.Fatalf("expected invalid compact_grouping to be rejected")
+	}
+	grouping := compactGroupingPerUser
+	if _, _, err := app.updateSettings(settingsPatch{CompactGrouping: &grouping}, "admin"); err != nil {
+		t.Fatalf("updateSettings: %v", err)
+	}
+	post := func(token, content string) int64 {
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, authedReq(t, http.MethodPost, "/api/entries", map[string]string{"content": content}, token))
+		if rr.Code != http.StatusCreated {
+			t.Fatalf("post: %d %s", rr.Code, rr.Body.String())
+		}
+		var e entryRow
+		_ = json.Unmarshal(rr.Body.Bytes(), &e)
+		return e.ID
+	}
+	bobID := post("PUDGROUPBO1", "reviewed the parser")
+	post("PUDGROUPAL1", fmt.Sprintf("fixed what bob found in [[entry:%d]]", bobID))
+	post("PUDGROUPBO1", "deployed")
+	day := time.Now().UTC().Format("2006-01-02")
+	if err := app.compactDay(day); err != nil {
+		t.Fatalf("compactDay: %v", err)
+	}
+
+	rows, err := app.db.Query(`SELECT e.id, e.content, u.username FROM compact_parts p JOIN entries e ON e.id = p.compact_id JOIN users u ON u.id = p.user_id WHERE p.day = ? ORDER BY p.compact_id`, day)
+	if err != nil {
+		t.Fatal(err)
+	}
+	compacts := map[string]int64{}
+	for rows.Next() {
+		var id int64
+		var content, author string
+		_ = rows.Scan(&id, &content, &author)
+		compacts[author] = id
+		for _, other := range []string{"alice", "bob"} {
+			if other != author && strings.Contains(content, "]["+other+"]") {
+				t.Fatalf("compact for %s holds %s's lines: %q", author, other, content)
+			}
+		}
+	}
+	rows.Close()
+	if len(compacts) != 2 || compacts["bob"] == 0 || compacts["alice"] == 0 {
+		t.Fatalf("expected one compact per author, got %v", compacts)
+	}
+	var first int64
+	var phase string
+	if err := app.db.QueryRow(`SELECT compact_id, phase FROM compactions WHERE day = ?`, day).Scan(&first, &phase); err != nil || phase != compactionDone || first != compacts["bob"] {
+		t.Fatalf("compactions: compact_id=%d phase=%s err=%v", first, phase, err)
+	}
+	var links int
+	if err := app.db.QueryRow(`SELECT COUNT(*) FROM entry_links WHERE source_id = ? AND target_id = ?`, compacts["alice"], compacts["bob"]).Scan(&links); err != nil || links != 1 {
+		t.Fatalf("expected the cross-author link between the compacts, got %d err=%v", links, err)
+	}
+	if issues, err := app.findIntegrityIssues(); err != nil || len(issues) != 0 {
+		t.Fatalf("integrity: %+v err=%v", issues, err)
+	}
+
+	res, err := app.uncompactDay(day)
+	if err != nil || res.Restored != 3 || res.Compacts != 2 {
+		t.Fatalf("uncompactDay: %+v err=%v", res, err)
+	}
+	var left int
+	if err := app.db.QueryRow(`SELECT COUNT(*) FROM compact_parts`).Scan(&left); err != nil || left != 0 {
+		t.Fatalf("expected compact_parts cleared, got %d err=%v", left, err)
+	}
+	if err := app.db.QueryRow(`SELECT COUNT(*) FROM entry_links WHERE target_id = ?`, bobID).Scan(&links); err != nil || links != 1 {
+		t.Fatalf("expected the link restored between entries, got %d err=%v", links, err)
+	}
+}
@@ -0,0 +1,333 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Shift handoffs: GET /api/handoff?since= summarizes what changed in a time
+// window (new entries, edits to older ones, and #blocker entries among them)
+// for the team picking up next. With --handoff-times the same summary is
+// sent as a handoff integration event at each configured local time,
+// covering the window since the previous one.
+
+const (
+	subsystemHandoff    = "handoff"
+	handoffTickInterval = time.Minute
+	maxHandoffWindow    = 7 * 24 * time.Hour
+	handoffLimit        = 500
+	blockerTag          = "blocker"
+)
+
+// handoffEdit is an entry written before the window and edited within it.
+type handoffEdit struct {
+	viewHit
+	EditedAt string `json:"edited_at"`
+}
+
+type handoffSummary struct {
+	Since      string        `json:"since"`
+	Until      string        `json:"until"`
+	NewEntries []viewHit     `json:"new_entries"`
+	Edited     []handoffEdit `json:"edited"`
+	Blockers   []viewHit     `json:"blockers"`
+	Truncated  bool          `json:"truncated"`
+	Text       string        `json:"text"`
+}
+
+// parseHandoffTimes parses --handoff-times, comma-separated local HH:MM
+// times, into minutes after midnight, sorted and deduplicated.
+func parseHandoffTimes(s string) ([]int, error) {
+	var out []int
+	for _, raw := range splitList(s) {
+		t, err := time.Parse("15:04", raw)
+		if err != nil {
+			return nil, fmt.Errorf("invalid --handoff-times value %q (want HH:MM)", raw)
+		}
+		m := t.Hour()*60 + t.Minute()
+		if !containsInt(out, m) {
+			out = append(out, m)
+		}
+	}
+	sort.Ints(out)
+	return out, nil
+}
+
+func containsInt(list []int, v int) bool {
+	for _, x := range list {
+		if x == v {
+			return true
+		}
+	}
+	return false
+}
+
+// lastHandoffSlot is the latest configured handoff time at or before now,
+// zero when none are configured.
+func lastHandoffSlot(times []int, now time.Time) time.Time {
+	var best time.Time
+	y, mo, d := now.Date()
+	for back := 0; back <= 1 && best.IsZero(); back++ {
+		for _, m := range times {
+			at := time.Date(y, mo, d-back, m/60, m%60, 0, 0, now.Location())
+			if !at.After(now) && at.After(best) {
+				best = at
+			}
+		}
+	}
+	return best
+}
+
+// parseHandoffSince accepts an RFC 3339 timestamp or anything parseSince
+// does (8h, 1d, YYYY-MM-DD).
+func parseHandoffSince(s string, now time.Time) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, strings.TrimSpace(s)); err == nil {
+		return t, nil
+	}
+	t, err := parseSince(s, now)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid since %q (want an RFC 3339 time, e.g. 8h or YYYY-MM-DD)", s)
+	}
+	return t, nil
+}
+
+func hasTag(content, tag string) bool {
+	for _, m := range tagRe.FindAllStringSubmatch(content, -1) {
+		if strings.EqualFold(m[1], tag) {
+			return true
+		}
+	}
+	return false
+}
+
+// handoff builds the summary for [since, until). New entries include those
+// already merged into a daily compact; edits only count for entries written
+// before since, which are otherwise not in the summary.
+func (a *App) handoff(since, until time.Time) (handoffSummary, error) {
+	// Stored times have second precision: round until up so an entry written
+	// in the current second is inside the window.
+	if t := until.Truncate(time.Second); !t.Equal(until) {
+		until = t.Add(time.Second)
+	}
+	sum := handoffSummary{
+		Since:      since.UTC().Format(time.RFC3339),
+		Until:      until.UTC().Format(time.RFC3339),
+		NewEntries: []viewHit{},
+		Edited:     []handoffEdit{},
+		Blockers:   []viewHit{},
+	}
+	hits, truncated, err := a.runView(viewMatcher{from: sum.Since, to: sum.Until}, handoffLimit)
+	if err != nil {
+		return sum, err
+	}
+	sum.Truncated = truncated
+	for i := len(hits) - 1; i >= 0; i-- {
+		sum.NewEntries = append(sum.NewEntries, hits[i])
+	}
+
+	rows, err := a.db.Query(`
+SELECT e.id, u.username, e.category, e.content, e.created_at, e.edited_at
+FROM entries e
+JOIN users u ON u.id = e.user_id
+WHERE e.entry_type = 'normal' AND e.deleted_at IS NULL
+  AND e.edited_at >= ? AND e.edited_at < ? AND e.created_at < ?
+ORDER BY e.edited_at ASC, e.id ASC
+LIMIT ?`, sum.Since, sum.Until, sum.Since, handoffLimit+1)
+	if err != nil {
+		return sum, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var e handoffEdit
+		if err := rows.Scan(&e.EntryID, &e.User, &e.Category, &e.Content, &e.CreatedAt, &e.EditedAt); err != nil {
+			return sum, err
+		}
+		e.URL = a.entryURL(e.EntryID, e.CreatedAt)
+		sum.Edited = append(sum.Edited, e)
+	}
+	if err := rows.Err(); err != nil {
+		return sum, err
+	}
+	if len(sum.Edited) > handoffLimit {
+		sum.Edited = sum.Edited[:handoffLimit]
+		sum.Truncated = true
+	}
+
+	for _, h := range sum.NewEntries {
+		if hasTag(h.Content, blockerTag) {
+			sum.Blockers = append(sum.Blockers, h)
+		}
+	}
+	for _, e := range sum.Edited {
+		if hasTag(e.Content, blockerTag) {
+			sum.Blockers = append(sum.Blockers, e.viewHit)
+		}
+	}
+	sum.Text = sum.render()
+	return sum, nil
+}
+
+// render formats the summary as plain text for chat and email: counts, then
+// blockers, new entries and edits, one line each.
+func (s handoffSummary) render() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "Handoff %s to %s\n", handoffStamp(s.Since), handoffStamp(s.Until))
+	fmt.Fprintf(&b, "%d new, %d edited, %d blockers", len(s.NewEntries), len(s.Edited), len(s.Blockers))
+	if s.Truncated {
+		b.WriteString(" (truncated)")
+	}
+	b.WriteString("\n")
+	line := func(ts, user, content string) {
+		fmt.Fprintf(&b, "- [%s][%s] %s\n", handoffStamp(ts), user, strings.ReplaceAll(strings.TrimSpace(content), "\n", " "))
+	}
+	if len(s.Blockers) > 0 {
+		b.WriteString("\nBlockers:\n")
+		for _, h := range s.Blockers {
+			line(h.CreatedAt, h.User, h.Content)
+		}
+	}
+	if len(s.NewEntries) > 0 {
+		b.WriteString("\nNew:\n")
+		for _, h := range s.NewEntries {
+			line(h.CreatedAt, h.User, h.Content)
+		}
+	}
+	if len(s.Edited) > 0 {
+		b.WriteString("\nEdited:\n")
+		for _, e := range s.Edited {
+			line(e.EditedAt, e.User, e.Content)
+		}
+	}
+	return b.String()
+}
+
+// handoffStamp shortens an RFC 3339 UTC time to "2006-01-02 15:04Z".
+func handoffStamp(ts string) string {
+	t, err := time.Parse(time.RFC3339, ts)
+	if err != nil {
+		return ts
+	}
+	return t.UTC().Format("2006-01-02 15:04Z")
+}
+
+// handleHandoff serves GET /api/handoff?since=&format=json|text.
+func (a *App) handleHandoff(w http.ResponseWriter, r *http.Request, u AuthedUser) {
+	if r.Method != http.MethodGet {
+		jsonErr(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	params := r.URL.Query()
+	raw := strings.TrimSpace(params.Get("since"))
+	if raw == "" {
+		jsonErr(w, http.StatusBadRequest, "since is required")
+		return
+	}
+	now := time.Now()
+	since, err := parseHandoffSince(raw, now)
+	if err != nil {
+		jsonErr(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if !since.Before(now) || now.Sub(since) > maxHandoffWindow {
+		jsonErr(w, http.StatusBadRequest, "since must be in the past and at most 7 days ago")
+		return
+	}
+	format := strings.TrimSpace(params.Get("format"))
+	if format != "" && format != "json" && format != "text" {
+		jsonErr(w, http.StatusBadRequest, "format must be json or text")
+		return
+	}
+	anonymous, err := a.wantAnonymous(r)
+	if err != nil {
+		jsonErr(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	sum, err := a.handoff(since, now)
+	if err != nil {
+		jsonErr(w, http.StatusInternalServerError, "failed to build handoff")
+		return
+	}
+	if anonymous {
+		sum.anonymize()
+	}
+	_ = a.logUserAction(u, "handoff", fmt.Sprintf("since=%s new=%d edited=%d blockers=%d anonymous=%t", sum.Since, len(sum.NewEntries), len(sum.Edited), len(sum.Blockers), anonymous))
+	if format == "text" {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.Header().Set("Content-Length", strconv.Itoa(len(sum.Text)))
+		_, _ = w.Write([]byte(sum.Text))
+		return
+	}
+	jsonOut(w, http.StatusOK, sum)
+}
+
+func (s *handoffSummary) anonymize() {
+	scrub := func(h *viewHit) {
+		h.User = anonymousName(h.User)
+		h.Content = scrubMentions(h.Content)
+	}
+	for i := range s.NewEntries {
+		scrub(&s.NewEntries[i])
+	}
+	for i := range s.Edited {
+		scrub(&s.Edited[i].viewHit)
+	}
+	for i := range s.Blockers {
+		scrub(&s.Blockers[i])
+	}
+	s.Text = s.render()
+}
+
+// sendHandoff dispatches the summary for [since, until) as a handoff event.
+func (a *App) sendHandoff(since, until time.Time) error {
+	sum, err := a.handoff(since, until)
+	if err != nil {
+		return err
+	}
+	_ = a.logActorAction(actorScheduler, "handoff", fmt.Sprintf("since=%s until=%s new=%d edited=%d blockers=%d", sum.Since, sum.Until, len(sum.NewEntries), len(sum.Edited), len(sum.Blockers)))
+	a.dispatcher.Dispatch(IntegrationEvent{
+		Type:    "handoff",
+		User:    actorScheduler.Username,
+		Message: sum.Text,
+		Data: map[string]any{
+			"since":       sum.Since,
+			"until":       sum.Until,
+			"new_entries": len(sum.NewEntries),
+			"edited":      len(sum.Edited),
+			"blockers":    sum.Blockers,
+			"truncated":   sum.Truncated,
+		},
+	})
+	return nil
+}
+
+// handoffLoop sends a handoff at each configured time. It starts from the
+// latest slot already passed, so a restart neither repeats nor backfills
+// handoffs; each one covers the time since the previous slot.
+func (a *App) handoffLoop(ctx context.Context) {
+	ticker := time.NewTicker(handoffTickInterval)
+	defer ticker.Stop()
+	a.health.register(subsystemHandoff, handoffTickInterval, time.Now())
+	last := lastHandoffSlot(a.handoffTimes, time.Now())
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			var err error
+			slot := lastHandoffSlot(a.handoffTimes, time.Now())
+			if slot.After(last) {
+				if err = a.sendHandoff(last, slot); err != nil {
+					a.logger.Printf("event=handoff_failed slot=%s err=%v", slot.Format(time.RFC3339), err)
+				} else {
+					last = slot
+				}
+			}
+			a.health.record(subsystemHandoff, time.Now(), err)
+		}
+	}
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestHandoff(t *testing.T) {
+	app := newTestApp(t)
+	h := newTestMux(app)
+	createUser(t, app, "alice", "PUDHANDOFF1")
+	createUser(t, app, "bob", "PUDHANDOFF2")
+	old := time.Now().UTC().Add(-30 * time.Hour).Format(time.RFC3339)
+	res, err := app.db.Exec(`INSERT INTO entries(user_id, entry_type, content, created_at) SELECT id, 'normal', 'migration plan drafted', ? FROM users WHERE username = 'alice'`, old)
+	if err != nil {
+		t.Fatal(err)
+	}
+	oldID, _ := res.LastInsertId()
+	do := func(method, path string, body any, token string) *httptest.ResponseRecorder {
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, authedReq(t, method, path, body, token))
+		return rr
+	}
+	for _, p := range []struct{ token, content string }{
+		{"PUDHANDOFF1", "shipped the login fix"},
+		{"PUDHANDOFF2", "waiting on db creds for the load test #blocker"},
+	} {
+		if rr := do(http.MethodPost, "/api/entries", map[string]string{"content": p.content}, p.token); rr.Code != http.StatusCreated {
+			t.Fatalf("post: %d %s", rr.Code, rr.Body.String())
+		}
+	}
+	if rr := do(http.MethodPut, fmt.Sprintf("/api/entries/%d", oldID), map[string]string{"content": "migration plan drafted, needs review #Blocker"}, "PUDHANDOFF1"); rr.Code != http.StatusOK {
+		t.Fatalf("edit: %d %s", rr.Code, rr.Body.String())
+	}
+
+	rr := do(http.MethodGet, "/api/handoff?since=8h", nil, "PUDHANDOFF1")
+	var sum handoffSummary
+	if err := json.Unmarshal(rr.Body.Bytes(), &sum); err != nil || rr.Code != http.StatusOK {
+		t.Fatalf("handoff: %d %s", rr.Code, rr.Body.String())
+	}
+	if len(sum.NewEntries) != 2 || sum.NewEntries[0].Content != "shipped the login fix" {
+		t.Fatalf("new entries: %+v", sum.NewEntries)
+	}
+	if len(sum.Edited) != 1 || sum.Edited[0].EntryID != oldID || sum.Edited[0].EditedAt == "" {
+		t.Fatalf("edited: %+v", sum.Edited)
+	}
+	if len(sum.Blockers) != 2 || !strings.Contains(sum.Text, "Blockers:\n- [") || !strings.Contains(sum.Text, "2 new, 1 edited, 2 blockers") {
+		t.Fatalf("blockers: %+v\n%s", sum.Blockers, sum.Text)
+	}
+
+	// Compacted entries are still reported as new.
+	if err := app.compactDay(time.Now().UTC().Format("2006-01-02")); err != nil {
+		t.Fatalf("compactDay: %v", err)
+	}
+	rr = do(http.MethodGet, "/api/handoff?since=8h&format=text", nil, "PUDHANDOFF1")
+	if rr.Code != http.StatusOK || !strings.HasPrefix(rr.Header().Get("Content-Type"), "text/plain") || !strings.Contains(rr.Body.String(), "[bob] waiting on db creds") {
+		t.Fatalf("text after compaction: %d %s", rr.Code, rr.Body.String())
+	}
+	for _, q := range []string{"", "?since=soon", "?since=30d", "?since=8h&format=xml"} {
+		if rr := do(http.MethodGet, "/api/handoff"+q, nil, "PUDHANDOFF1"); rr.Code != http.StatusBadRequest {
+			t.Fatalf("%q: %d", q, rr.Code)
+		}
+	}
+
+	app.dispatcher = NewIntegrationDispatcher(app.logger, &WebhookIntegration{URL: "http://127.0.0.1:0"})
+	until := time.Now()
+	if err := app.sendHandoff(until.Add(-8*time.Hour), until); err != nil {
+		t.Fatalf("sendHandoff: %v", err)
+	}
+	ev := <-app.dispatcher.queue
+	if ev.Type != "handoff" || !strings.HasPrefix(ev.Message, "Handoff ") || ev.Data["new_entries"] != 2 {
+		t.Fatalf("event: %+v", ev)
+	}
+
+	times, err := parseHandoffTimes("16:00, 08:00,16:00")
+	if err != nil || len(times) != 2 || times[0] != 8*60 {
+		t.Fatalf("parseHandoffTimes: %v %v", times, err)
+	}
+	if _, err := parseHandoffTimes("25:00"); err == nil {
+		t.Fatal("invalid handoff time accepted")
+	}
+	now := time.Date(2026, 3, 2, 7, 30, 0, 0, time.UTC)
+	if got := lastHandoffSlot(times, now); !got.Equal(time.Date(2026, 3, 1, 16, 0, 0, 0, time.UTC)) {
+		t.Fatalf("slot before first time of day: %s", got)
+	}
+	if got := lastHandoffSlot(times, now.Add(time.Hour)); !got.Equal(time.Date(2026, 3, 2, 8, 0, 0, 0, time.UTC)) {
+		t.Fatalf("slot: %s", got)
+	}
+}
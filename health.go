@@ -0,0 +1,198 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Background subsystems report each run to App.health so /api/ready can show
+// a hung goroutine as stale instead of staying silent.
+const (
+	subsystemCompaction = "compaction"
+	subsystemTrashPurge = "trash_purge"
+	subsystemMeter      = "meter_flush"
+	subsystemGitImport  = "git_import"
+	subsystemBackup     = "backup"
+	subsystemDelivery   = "delivery"
+)
+
+const (
+	// staleAfterTicks is how many missed intervals mark a loop stale.
+	staleAfterTicks = 3
+	// slowDBLatency marks the database degraded.
+	slowDBLatency = time.Second
+	// integrationQueueHighWater is the queue fill ratio that suggests the
+	// delivery goroutine is stuck.
+	integrationQueueHighWater = 0.9
+)
+
+// subsystemRun is the last known activity of one subsystem.
+type subsystemRun struct {
+	interval    time.Duration
+	started     time.Time
+	lastTick    time.Time
+	lastSuccess time.Time
+	lastError   string
+	// restarts are watchdog restarts of the loop (watchdog.go).
+	restarts []time.Time
+	gaveUp   bool
+}
+
+// lastSeen is the later of the last run and the (re)start of the loop.
+func (r *subsystemRun) lastSeen() time.Time {
+	if r.lastTick.After(r.started) {
+		return r.lastTick
+	}
+	return r.started
+}
+
+// healthTracker records subsystem runs. The zero value is ready to use.
+type healthTracker struct {
+	mu   sync.Mutex
+	runs map[string]*subsystemRun
+}
+
+// register declares a subsystem expected to run every interval; 0 means it
+// runs on demand and is never stale.
+func (h *healthTracker) register(name string, interval time.Duration, now time.Time) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.runs == nil {
+		h.runs = map[string]*subsystemRun{}
+	}
+	if r, ok := h.runs[name]; ok {
+		r.interval = interval
+		return
+	}
+	h.runs[name] = &subsystemRun{interval: interval, started: now}
+}
+
+// record notes one run of name; err nil counts as a success.
+func (h *healthTracker) record(name string, now time.Time, err error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.runs == nil {
+		h.runs = map[string]*subsystemRun{}
+	}
+	r, ok := h.runs[name]
+	if !ok {
+		r = &subsystemRun{started: now}
+		h.runs[name] = r
+	}
+	r.lastTick = now
+	if err != nil {
+		r.lastError = err.Error()
+		return
+	}
+	r.lastSuccess, r.lastError = now, ""
+}
+
+// subsystemStatus is one entry of the readiness report.
+type subsystemStatus struct {
+	Status        string   `json:"status"`
+	IntervalS     int      `json:"interval_s,omitempty"`
+	LastTick      string   `json:"last_tick,omitempty"`
+	LastSuccess   string   `json:"last_success,omitempty"`
+	LastError     string   `json:"last_error,omitempty"`
+	Restarts      int      `json:"restarts,omitempty"`
+	LatencyMS     *float64 `json:"latency_ms,omitempty"`
+	QueueDepth    *int     `json:"queue_depth,omitempty"`
+	QueueCapacity int      `json:"queue_capacity,omitempty"`
+}
+
+// snapshot reports every registered subsystem as ok, stale (no run for
+// staleAfterTicks intervals), failing (last run errored) or unknown (an
+// on-demand subsystem that never ran).
+func (h *healthTracker) snapshot(now time.Time) map[string]subsystemStatus {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	out := map[string]subsystemStatus{}
+	for name, r := range h.runs {
+		s := subsystemStatus{Status: "ok", IntervalS: int(r.interval / time.Second), LastError: r.lastError, Restarts: len(r.restarts)}
+		if !r.lastTick.IsZero() {
+			s.LastTick = r.lastTick.UTC().Format(time.RFC3339)
+		}
+		if !r.lastSuccess.IsZero() {
+			s.LastSuccess = r.lastSuccess.UTC().Format(time.RFC3339)
+		}
+		switch {
+		case r.interval > 0 && now.Sub(r.lastSeen()) > staleAfterTicks*r.interval:
+			s.Status = "stale"
+		case r.lastError != "":
+			s.Status = "failing"
+		case r.interval == 0 && r.lastTick.IsZero():
+			s.Status = "unknown"
+		}
+		out[name] = s
+	}
+	return out
+}
+
+// QueueDepth reports the number of queued events and the queue capacity.
+func (d *IntegrationDispatcher) QueueDepth() (int, int) {
+	if d == nil {
+		return 0, 0
+	}
+	return len(d.queue), cap(d.queue)
+}
+
+// handleReady serves GET /api/ready: per-subsystem status plus a DB round
+// trip and the integration queue. Only a failing database answers 503 by
+// default, since a stuck background loop should not take the single node out
+// of rotation; ?strict=1 turns any unhealthy subsystem into a 503 for
+// monitors that only look at status codes.
+func (a *App) handleReady(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		jsonErr(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	now := time.Now()
+	subsystems := a.health.snapshot(now)
+
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+	start := time.Now()
+	var one int
+	err := a.db.QueryRowContext(ctx, `SELECT 1`).Scan(&one)
+	latency := float64(time.Since(start).Microseconds()) / 1000
+	db := subsystemStatus{Status: "ok", LatencyMS: &latency}
+	switch {
+	case err != nil:
+		db.Status, db.LastError = "failing", err.Error()
+	case time.Since(start) > slowDBLatency:
+		db.Status = "degraded"
+	}
+	subsystems["db"] = db
+
+	depth, capacity := a.dispatcher.QueueDepth()
+	q := subsystemStatus{Status: "ok", QueueDepth: &depth, QueueCapacity: capacity}
+	if capacity > 0 && float64(depth) >= integrationQueueHighWater*float64(capacity) {
+		q.Status = "degraded"
+	}
+	subsystems["integrations"] = q
+
+	status, code := "ok", http.StatusOK
+	unhealthy := []string{}
+	for name, s := range subsystems {
+		if s.Status != "ok" && s.Status != "unknown" {
+			unhealthy = append(unhealthy, name)
+		}
+	}
+	sort.Strings(unhealthy)
+	if len(unhealthy) > 0 {
+		status = "degraded"
+		if db.Status == "failing" || r.URL.Query().Get("strict") == "1" {
+			code = http.StatusServiceUnavailable
+		}
+		a.logger.Printf("event=not_ready subsystems=%v", unhealthy)
+	}
+	jsonOut(w, code, map[string]any{
+		"status":     status,
+		"checked_at": now.UTC().Format(time.RFC3339),
+		"unhealthy":  unhealthy,
+		"subsystems": subsystems,
+	})
+}
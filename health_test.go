@@ -0,0 +1,64 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestReadiness(t *testing.T) {
+	app := newTestApp(t)
+	h := newTestMux(app)
+	now := time.Now()
+	app.health.register(subsystemCompaction, compactionTickInterval, now.Add(-time.Hour))
+	app.health.register(subsystemBackup, 0, now)
+	app.health.record(subsystemCompaction, now, nil)
+
+	type report struct {
+		Status     string                     `json:"status"`
+		Unhealthy  []string                   `json:"unhealthy"`
+		Subsystems map[string]subsystemStatus `json:"subsystems"`
+	}
+	get := func(path string) (int, report) {
+		t.Helper()
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, path, nil))
+		var rep report
+		if err := json.Unmarshal(rr.Body.Bytes(), &rep); err != nil {
+			t.Fatalf("decode: %v %s", err, rr.Body.String())
+		}
+		return rr.Code, rep
+	}
+
+	code, rep := get("/api/ready")
+	if code != http.StatusOK || rep.Status != "ok" || len(rep.Unhealthy) != 0 {
+		t.Fatalf("healthy: %d %+v", code, rep)
+	}
+	if s := rep.Subsystems["db"]; s.Status != "ok" || s.LatencyMS == nil {
+		t.Fatalf("db: %+v", s)
+	}
+	if s := rep.Subsystems["backup"]; s.Status != "unknown" {
+		t.Fatalf("backup never ran should be unknown: %+v", s)
+	}
+	if s := rep.Subsystems["integrations"]; s.Status != "ok" || s.QueueDepth == nil {
+		t.Fatalf("integrations: %+v", s)
+	}
+
+	// A loop that stopped ticking shows up as stale; strict mode turns it into a 503.
+	app.health.record(subsystemCompaction, now.Add(-10*compactionTickInterval), nil)
+	app.health.record(subsystemTrashPurge, now, errors.New("disk full"))
+	code, rep = get("/api/ready")
+	if code != http.StatusOK || rep.Status != "degraded" || strings.Join(rep.Unhealthy, ",") != "compaction,trash_purge" {
+		t.Fatalf("degraded: %d %+v", code, rep)
+	}
+	if s := rep.Subsystems["trash_purge"]; s.Status != "failing" || s.LastError != "disk full" {
+		t.Fatalf("trash_purge: %+v", s)
+	}
+	if code, _ := get("/api/ready?strict=1"); code != http.StatusServiceUnavailable {
+		t.Fatalf("strict: expected 503, got %d", code)
+	}
+}
@@ -0,0 +1,275 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// Identity providers an external id can be linked from. Git commit authors
+// and inbound email senders share the "email" provider; Confluence page
+// creators are linked by Confluence username.
+const (
+	providerEmail      = "email"
+	providerSlack      = "slack"
+	providerGitHub     = "github"
+	providerConfluence = "confluence"
+)
+
+var identityProviders = []string{providerEmail, providerSlack, providerGitHub, providerConfluence}
+
+var errUnknownIdentity = errors.New("identity is not linked to a user")
+
+// identityLink maps an external identity to a pudnats user.
+type identityLink struct {
+	Provider   string `json:"provider"`
+	ExternalID string `json:"external_id"`
+	Username   string `json:"username"`
+	CreatedAt  string `json:"created_at"`
+}
+
+// normalizeIdentity canonicalizes an external id so lookups are stable:
+// emails and GitHub logins are case-insensitive, Slack ids are not.
+func normalizeIdentity(provider, externalID string) (string, string, error) {
+	provider = strings.ToLower(strings.TrimSpace(provider))
+	externalID = strings.TrimSpace(externalID)
+	if !containsString(identityProviders, provider) {
+		return "", "", fmt.Errorf("provider must be one of %s", strings.Join(identityProviders, ", "))
+	}
+	if externalID == "" {
+		return "", "", errors.New("external_id is required")
+	}
+	switch provider {
+	case providerEmail, providerGitHub, providerConfluence:
+		externalID = strings.ToLower(strings.TrimPrefix(externalID, "@"))
+	}
+	return provider, externalID, nil
+}
+
+// resolveIdentity returns the human user an external identity is linked to.
+// Every inbound integration resolves authors through this one table.
+func (a *App) resolveIdentity(provider, externalID string) (AuthedUser, error) {
+	provider, externalID, err := normalizeIdentity(provider, externalID)
+	if err != nil {
+		return AuthedUser{}, err
+	}
+	var u AuthedUser
+	err = a.db.QueryRow(`
+SELECT u.id, u.username, u.role
+FROM identity_links l
+JOIN users u ON u.id = l.user_id
+WHERE l.provider = ? AND l.external_id = ? AND u.kind = 'human' AND u.disabled = 0`, provider, externalID).Scan(&u.ID, &u.Username, &u.Role)
+	if errors.Is(err, sql.ErrNoRows) {
+		return AuthedUser{}, errUnknownIdentity
+	}
+	return u, err
+}
+
+// linkIdentity creates or repoints a link and returns the normalized form.
+func (a *App) linkIdentity(provider, externalID, username string) (identityLink, error) {
+	provider, externalID, err := normalizeIdentity(provider, externalID)
+	if err != nil {
+		return identityLink{}, err
+	}
+	username = strings.TrimSpace(username)
+	var uid int64
+	if err := a.db.QueryRow(`SELECT id FROM users WHERE username = ? AND kind = 'human'`, username).Scan(&uid); err != nil {
+		return identityLink{}, fmt.Errorf("unknown user: %s", username)
+	}
+	l := identityLink{Provider: provider, ExternalID: externalID, Username: username, CreatedAt: nowUTC()}
+	_, err = a.db.Exec(`
+INSERT INTO identity_links(provider, external_id, user_id, created_at) VALUES(?, ?, ?, ?)
+ON CONFLICT(provider, external_id) DO UPDATE SET user_id = excluded.user_id, created_at = excluded.created_at`,
+		l.Provider, l.ExternalID, uid, l.CreatedAt)
+	return l, err
+}
+
+func (a *App) unlinkIdentity(provider, externalID string) (bool, error) {
+	provider, externalID, err := normalizeIdentity(provider, externalID)
+	if err != nil {
+		return false, err
+	}
+	res, err := a.db.Exec(`DELETE FROM identity_links WHERE provider = ? AND external_id = ?`, provider, externalID)
+	if err != nil {
+		return false, err
+	}
+	n, _ := res.RowsAffected()
+	return n > 0, nil
+}
+
+func (a *App) listIdentityLinks(provider, username string) ([]identityLink, error) {
+	rows, err := a.db.Query(`
+SELECT l.provider, l.external_id, u.username, l.created_at
+FROM identity_links l
+JOIN users u ON u.id = l.user_id
+WHERE (? = '' OR l.provider = ?) AND (? = '' OR u.username = ?)
+ORDER BY u.username ASC, l.provider ASC, l.external_id ASC`, provider, provider, username, username)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	out := []identityLink{}
+	for rows.Next() {
+		var l identityLink
+		if err := rows.Scan(&l.Provider, &l.ExternalID, &l.Username, &l.CreatedAt); err != nil {
+			return nil, err
+		}
+		out = append(out, l)
+	}
+	return out, rows.Err()
+}
+
+// migrateIdentityTables folds the per-integration mapping tables of older
+// releases (git_authors, email_senders) into identity_links and drops them.
+func (a *App) migrateIdentityTables() error {
+	for _, table := range []string{"git_authors", "email_senders"} {
+		var n int
+		if err := a.db.QueryRow(`SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name = ?`, table).Scan(&n); err != nil {
+			return err
+		}
+		if n == 0 {
+			continue
+		}
+		tx, err := a.db.Begin()
+		if err != nil {
+			return err
+		}
+		if _, err := tx.Exec(`INSERT OR IGNORE INTO identity_links(provider, external_id, user_id, created_at) SELECT ?, lower(email), user_id, ? FROM `+table, providerEmail, nowUTC()); err != nil {
+			_ = tx.Rollback()
+			return fmt.Errorf("migrate %s: %w", table, err)
+		}
+		if _, err := tx.Exec(`DROP TABLE ` + table); err != nil {
+			_ = tx.Rollback()
+			return fmt.Errorf("migrate %s: %w", table, err)
+		}
+		if err := tx.Commit(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// handleAdminIdentityLinks lists (GET), creates (POST) and removes (DELETE)
+// identity links.
+func (a *App) handleAdminIdentityLinks(w http.ResponseWriter, r *http.Request, u AuthedUser) {
+	switch r.Method {
+	case http.MethodGet:
+		q := r.URL.Query()
+		links, err := a.listIdentityLinks(strings.ToLower(strings.TrimSpace(q.Get("provider"))), strings.TrimSpace(q.Get("user")))
+		if err != nil {
+			jsonErr(w, http.StatusInternalServerError, "failed to query identity links")
+			return
+		}
+		jsonOut(w, http.StatusOK, map[string]any{"links": links})
+	case http.MethodPost:
+		var req struct {
+			Provider   string `json:"provider"`
+			ExternalID string `json:"external_id"`
+			Username   string `json:"username"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			jsonErr(w, http.StatusBadRequest, "invalid json")
+			return
+		}
+		l, err := a.linkIdentity(req.Provider, req.ExternalID, req.Username)
+		if err != nil {
+			jsonErr(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		_ = a.logUserAction(u, "link_identity", fmt.Sprintf("provider=%s external_id=%s user=%s", l.Provider, l.ExternalID, l.Username))
+		jsonOut(w, http.StatusCreated, l)
+	case http.MethodDelete:
+		q := r.URL.Query()
+		removed, err := a.unlinkIdentity(q.Get("provider"), q.Get("external_id"))
+		if err != nil {
+			jsonErr(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		if !removed {
+			jsonErr(w, http.StatusNotFound, "identity link not found")
+			return
+		}
+		_ = a.logUserAction(u, "unlink_identity", fmt.Sprintf("provider=%s external_id=%s", q.Get("provider"), q.Get("external_id")))
+		jsonOut(w, http.StatusOK, map[string]string{"status": "deleted"})
+	default:
+		jsonErr(w, http.StatusMethodNotAllowed, "method not allowed")
+	}
+}
+
+func runAdminLinkIdentity(args []string) error {
+	fs := flag.NewFlagSet("admin link-identity", flag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s admin link-identity --provider email|slack|github --id <external id> --username <name> [options]\n\n", binName())
+		fmt.Fprintln(fs.Output(), "Links an external identity to a user. Git commit authors and inbound email")
+		fmt.Fprintln(fs.Output(), "senders resolve through 'email' links; chat integrations use 'slack'/'github'.")
+		fmt.Fprintln(fs.Output())
+		fmt.Fprintln(fs.Output(), "Options:")
+		fs.PrintDefaults()
+	}
+	provider := fs.String("provider", "", "identity provider (email|slack|github)")
+	id := fs.String("id", "", "external id: email address, Slack user id or GitHub login")
+	username := fs.String("username", "", "user the identity maps to")
+	remove := fs.Bool("remove", false, "remove the link instead of creating it")
+	list := fs.Bool("list", false, "list links, optionally filtered by --provider/--username")
+	dbPath := fs.String("db", defaultDBPath, "sqlite db path")
+	logPath := fs.String("log", defaultLogPath, "log target path ('-' for stdout only)")
+	if err := fs.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return nil
+		}
+		return err
+	}
+	return runIdentityLinkCommand(*provider, *id, *username, *remove, *list, *dbPath, *logPath)
+}
+
+// runIdentityLinkCommand is shared by link-identity and the older
+// map-git-author / map-email-sender commands.
+func runIdentityLinkCommand(provider, id, username string, remove, list bool, dbPath, logPath string) error {
+	if !list && (strings.TrimSpace(provider) == "" || strings.TrimSpace(id) == "") {
+		return errors.New("--provider and --id are required")
+	}
+	if !list && !remove && strings.TrimSpace(username) == "" {
+		return errors.New("--username is required")
+	}
+
+	app, closeApp, err := openAdminApp(dbPath, logPath)
+	if err != nil {
+		return err
+	}
+	defer closeApp()
+
+	switch {
+	case list:
+		links, err := app.listIdentityLinks(strings.ToLower(strings.TrimSpace(provider)), strings.TrimSpace(username))
+		if err != nil {
+			return err
+		}
+		for _, l := range links {
+			fmt.Printf("%s\t%s\t%s\n", l.Provider, l.ExternalID, l.Username)
+		}
+		return nil
+	case remove:
+		removed, err := app.unlinkIdentity(provider, id)
+		if err != nil {
+			return err
+		}
+		if !removed {
+			return fmt.Errorf("no %s link for %s", provider, id)
+		}
+		_ = app.logAction("admin_cli", "admin", "unlink_identity", fmt.Sprintf("provider=%s external_id=%s", provider, id))
+		fmt.Printf("removed %s:%s\n", provider, id)
+		return nil
+	default:
+		l, err := app.linkIdentity(provider, id, username)
+		if err != nil {
+			return err
+		}
+		_ = app.logAction("admin_cli", "admin", "link_identity", fmt.Sprintf("provider=%s external_id=%s user=%s", l.Provider, l.ExternalID, l.Username))
+		fmt.Printf("linked %s:%s -> %s\n", l.Provider, l.ExternalID, l.Username)
+		return nil
+	}
+}
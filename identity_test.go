@@ -0,0 +1,83 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestAPIAdminIdentityLinks(t *testing.T) {
+	app := newTestApp(t)
+	h := newTestMux(app)
+	createUser(t, app, "alice", "PUDABCDEF12")
+	createUser(t, app, "root", "PUDROOTROOT")
+	if _, err := app.db.Exec(`UPDATE users SET role = 'admin' WHERE username = 'root'`); err != nil {
+		t.Fatalf("promote root: %v", err)
+	}
+
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, authedReq(t, http.MethodPost, "/api/admin/identity-links", map[string]string{"provider": "github", "external_id": "@Alice-GH", "username": "alice"}, "PUDABCDEF12"))
+	if rr.Code != http.StatusForbidden {
+		t.Fatalf("expected 403 for member, got %d", rr.Code)
+	}
+	for _, body := range []map[string]string{
+		{"provider": "github", "external_id": "@Alice-GH", "username": "alice"},
+		{"provider": "slack", "external_id": "U012AB3CD", "username": "alice"},
+	} {
+		rr = httptest.NewRecorder()
+		h.ServeHTTP(rr, authedReq(t, http.MethodPost, "/api/admin/identity-links", body, "PUDROOTROOT"))
+		if rr.Code != http.StatusCreated {
+			t.Fatalf("expected 201, got %d body=%s", rr.Code, rr.Body.String())
+		}
+	}
+	rr = httptest.NewRecorder()
+	h.ServeHTTP(rr, authedReq(t, http.MethodPost, "/api/admin/identity-links", map[string]string{"provider": "irc", "external_id": "x", "username": "alice"}, "PUDROOTROOT"))
+	if rr.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400 for unknown provider, got %d", rr.Code)
+	}
+
+	u, err := app.resolveIdentity("github", "alice-gh")
+	if err != nil || u.Username != "alice" {
+		t.Fatalf("resolve github login: %+v %v", u, err)
+	}
+
+	rr = httptest.NewRecorder()
+	h.ServeHTTP(rr, authedReq(t, http.MethodGet, "/api/admin/identity-links?user=alice", nil, "PUDROOTROOT"))
+	var list struct {
+		Links []identityLink `json:"links"`
+	}
+	if err := json.Unmarshal(rr.Body.Bytes(), &list); err != nil || len(list.Links) != 2 {
+		t.Fatalf("expected 2 links, got %s (%v)", rr.Body.String(), err)
+	}
+
+	rr = httptest.NewRecorder()
+	h.ServeHTTP(rr, authedReq(t, http.MethodDelete, "/api/admin/identity-links?provider=slack&external_id=U012AB3CD", nil, "PUDROOTROOT"))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected 200 deleting link, got %d", rr.Code)
+	}
+	if _, err := app.resolveIdentity("slack", "U012AB3CD"); err != errUnknownIdentity {
+		t.Fatalf("expected unlinked slack id, got %v", err)
+	}
+}
+
+func TestMigrateLegacyIdentityTables(t *testing.T) {
+	app := newTestApp(t)
+	createUser(t, app, "alice", "PUDABCDEF12")
+	if _, err := app.db.Exec(`CREATE TABLE git_authors (email TEXT PRIMARY KEY, user_id INTEGER NOT NULL)`); err != nil {
+		t.Fatalf("create legacy table: %v", err)
+	}
+	if _, err := app.db.Exec(`INSERT INTO git_authors(email, user_id) SELECT 'Alice@Example.com', id FROM users WHERE username = 'alice'`); err != nil {
+		t.Fatalf("insert legacy mapping: %v", err)
+	}
+	if err := app.migrateIdentityTables(); err != nil {
+		t.Fatalf("migrateIdentityTables: %v", err)
+	}
+	if u, err := app.resolveIdentity(providerEmail, "alice@example.com"); err != nil || u.Username != "alice" {
+		t.Fatalf("expected migrated mapping, got %+v %v", u, err)
+	}
+	var n int
+	if err := app.db.QueryRow(`SELECT COUNT(*) FROM sqlite_master WHERE name = 'git_authors'`).Scan(&n); err != nil || n != 0 {
+		t.Fatalf("expected legacy table to be dropped, n=%d err=%v", n, err)
+	}
+}
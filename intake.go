@@ -0,0 +1,171 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// enqueueIntake stores an entry posted while compaction holds the write lock.
+// The insert waits for the compaction transaction to release the single DB
+// connection, so if the lock is already gone by then the queue is flushed
+// immediately instead of waiting for the next compaction.
+func (a *App) enqueueIntake(u AuthedUser, content, category, source, createdAt string) (int64, error) {
+	res, err := a.db.Exec(`INSERT INTO intake_queue(user_id, content, category, source, created_at, queued_at) VALUES(?, ?, ?, ?, ?, ?)`, u.ID, content, category, source, createdAt, nowUTC())
+	if err != nil {
+		return 0, err
+	}
+	id, _ := res.LastInsertId()
+	if !a.writeLocked.Load() {
+		if _, err := a.flushIntake(); err != nil {
+			a.logger.Printf("event=intake_flush_failed err=%v", err)
+		}
+	}
+	return id, nil
+}
+
+type intakeItem struct {
+	ID        int64
+	User      AuthedUser
+	Content   string
+	Category  string
+	Source    string
+	CreatedAt string
+}
+
+// flushIntake moves queued entries into entries, keeping their original
+// timestamps, and returns how many were moved. Entries posted while their own
+// day was being compacted land on a day that is already compacted; that day
+// is compacted again (recompactDay) so they join the compact instead of
+// staying live on it. Entries queued during that run are flushed the same
+// way, for at most maxRecompactRounds rounds.
+func (a *App) flushIntake() (int, error) {
+	total := 0
+	for round := 0; ; round++ {
+		n, late, err := a.moveIntake()
+		total += n
+		if err != nil || len(late) == 0 {
+			return total, err
+		}
+		if round == maxRecompactRounds {
+			a.logger.Printf("event=intake_late_entries_left days=%s", strings.Join(late, ","))
+			return total, nil
+		}
+		for _, day := range late {
+			if err := a.recompactDay(day); err != nil {
+				return total, err
+			}
+		}
+	}
+}
+
+// maxRecompactRounds bounds how often one flush compacts a day again, so a
+// steady stream of writes during the re-runs cannot keep it going.
+const maxRecompactRounds = 3
+
+// moveIntake moves queued entries into entries in one transaction and
+// returns how many were moved and the days among theirs whose compaction had
+// already finished.
+func (a *App) moveIntake() (int, []string, error) {
+	a.compactMu.Lock()
+	defer a.compactMu.Unlock()
+
+	tx, err := a.db.Begin()
+	if err != nil {
+		return 0, nil, err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	rows, err := tx.Query(`
+SELECT q.id, q.user_id, u.username, u.role, q.content, q.category, q.source, q.created_at
+FROM intake_queue q
+JOIN users u ON u.id = q.user_id
+ORDER BY q.id ASC`)
+	if err != nil {
+		return 0, nil, err
+	}
+	var items []intakeItem
+	for rows.Next() {
+		var it intakeItem
+		if err := rows.Scan(&it.ID, &it.User.ID, &it.User.Username, &it.User.Role, &it.Content, &it.Category, &it.Source, &it.CreatedAt); err != nil {
+			_ = rows.Close()
+			return 0, nil, err
+		}
+		items = append(items, it)
+	}
+	if err := rows.Err(); err != nil {
+		_ = rows.Close()
+		return 0, nil, err
+	}
+	_ = rows.Close()
+	if len(items) == 0 {
+		return 0, nil, nil
+	}
+
+	entryIDs := make([]int64, len(items))
+	compacted := map[string]bool{}
+	var late []string
+	for i, it := range items {
+		res, err := tx.Exec(`INSERT INTO entries(user_id, entry_type, content, category, source, created_at) VALUES(?, 'normal', ?, ?, ?, ?)`, it.User.ID, it.Content, it.Category, it.Source, it.CreatedAt)
+		if err != nil {
+			return 0, nil, err
+		}
+		entryIDs[i], _ = res.LastInsertId()
+		if _, err := tx.Exec(`DELETE FROM intake_queue WHERE id = ?`, it.ID); err != nil {
+			return 0, nil, err
+		}
+		day := it.CreatedAt[:10]
+		if _, seen := compacted[day]; seen {
+			continue
+		}
+		var phase string
+		err = tx.QueryRow(`SELECT phase FROM compactions WHERE day = ?`, day).Scan(&phase)
+		if err != nil && !errors.Is(err, sql.ErrNoRows) {
+			return 0, nil, err
+		}
+		compacted[day] = phase == compactionDone
+		if compacted[day] {
+			late = append(late, day)
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, nil, err
+	}
+
+	for i, it := range items {
+		_ = a.logActorAction(actorIntake, "flush_entry", fmt.Sprintf("queue_id=%d entry_id=%d user=%s", it.ID, entryIDs[i], it.User.Username))
+		a.afterEntryCreated(it.User, entryIDs[i], it.Content, it.CreatedAt)
+	}
+	a.logger.Printf("event=intake_flushed count=%d", len(items))
+	return len(items), late, nil
+}
+
+// recompactDay compacts an already compacted day again, after flushed
+// entries landed on it: the day is un-compacted and compacted, so the
+// compact gets a new id. Held, quarantined and rolled-up days are left as
+// they are, with the entries live, like an entry restored from trash.
+func (a *App) recompactDay(day string) error {
+	quarantined, err := a.dayQuarantined(day)
+	if err != nil {
+		return err
+	}
+	if quarantined {
+		a.logger.Printf("event=recompact_skipped day=%s reason=quarantined", day)
+		return nil
+	}
+	res, err := a.uncompactDay(day)
+	if errors.Is(err, errDayOnHold) || errors.Is(err, errDayRolledUp) {
+		a.logger.Printf("event=recompact_skipped day=%s err=%v", day, err)
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if err := a.compactDay(day); err != nil {
+		a.logger.Printf("event=recompact_failed day=%s err=%v", day, err)
+		return err
+	}
+	_ = a.logActorAction(actorIntake, "recompact_day", fmt.Sprintf("day=%s old_compact_id=%d restored=%d", day, res.CompactID, res.Restored))
+	return nil
+}
@@ -0,0 +1,210 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// IntegrationEvent is the payload fanned out to external systems.
+type IntegrationEvent struct {
+	Type      string         `json:"type"`
+	User      string         `json:"user,omitempty"`
+	Recipient string         `json:"recipient,omitempty"`
+	EntryID   int64          `json:"entry_id,omitempty"`
+	Day       string         `json:"day,omitempty"`
+	Message   string         `json:"message"`
+	Data      map[string]any `json:"data,omitempty"`
+	URL       string         `json:"url,omitempty"` // UI deep link, set on dispatch
+	CreatedAt string         `json:"created_at"`
+
+	released bool // already held back once by the deferrer
+}
+
+// Integration delivers events to one external system.
+type Integration interface {
+	Name() string
+	Send(ctx context.Context, ev IntegrationEvent) error
+}
+
+// IntegrationDispatcher queues events and delivers them to every configured
+// integration from a single background goroutine. A nil dispatcher drops events.
+// Events addressed to a Recipient are filtered through the router, which
+// enforces per-user notification preferences, and may be held back by the
+// deferrer during the recipient's quiet hours.
+type IntegrationDispatcher struct {
+	logger       *log.Logger
+	integrations []Integration
+	queue        chan IntegrationEvent
+	router       func(recipient, eventType, channel string) bool
+	linker       func(ev IntegrationEvent) string
+	deferrer     func(ev IntegrationEvent) bool
+	heartbeat    func(err error)
+}
+
+func NewIntegrationDispatcher(logger *log.Logger, integrations ...Integration) *IntegrationDispatcher {
+	return &IntegrationDispatcher{
+		logger:       logger,
+		integrations: integrations,
+		queue:        make(chan IntegrationEvent, 256),
+	}
+}
+
+// SetRouter installs the per-recipient routing hook.
+func (d *IntegrationDispatcher) SetRouter(route func(recipient, eventType, channel string) bool) {
+	if d != nil {
+		d.router = route
+	}
+}
+
+// SetLinker installs the hook that fills IntegrationEvent.URL.
+func (d *IntegrationDispatcher) SetLinker(link func(ev IntegrationEvent) string) {
+	if d != nil {
+		d.linker = link
+	}
+}
+
+// SetDeferrer installs the hook that may hold back a recipient's event for
+// later delivery; it returns true when it took the event.
+func (d *IntegrationDispatcher) SetDeferrer(deferEvent func(ev IntegrationEvent) bool) {
+	if d != nil {
+		d.deferrer = deferEvent
+	}
+}
+
+// SetHeartbeat installs the hook Run calls after each event and every
+// deliveryHeartbeat while idle, so the watchdog can tell idle from wedged.
+func (d *IntegrationDispatcher) SetHeartbeat(beat func(err error)) {
+	if d != nil {
+		d.heartbeat = beat
+	}
+}
+
+func (d *IntegrationDispatcher) beat() {
+	if d.heartbeat != nil {
+		d.heartbeat(nil)
+	}
+}
+
+// Dispatch enqueues ev without blocking the caller; events are dropped when the queue is full.
+func (d *IntegrationDispatcher) Dispatch(ev IntegrationEvent) {
+	if d == nil || len(d.integrations) == 0 {
+		return
+	}
+	if ev.CreatedAt == "" {
+		ev.CreatedAt = nowUTC()
+	}
+	if ev.Recipient != "" && !ev.released && d.deferrer != nil && d.deferrer(ev) {
+		return
+	}
+	if ev.URL == "" && d.linker != nil {
+		ev.URL = d.linker(ev)
+	}
+	select {
+	case d.queue <- ev:
+	default:
+		d.logger.Printf("event=integration_dropped type=%s reason=queue_full", ev.Type)
+	}
+}
+
+func (d *IntegrationDispatcher) Run(ctx context.Context) {
+	if d == nil {
+		return
+	}
+	idle := time.NewTicker(deliveryHeartbeat)
+	defer idle.Stop()
+	d.beat()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-idle.C:
+			d.beat()
+		case ev := <-d.queue:
+			for _, in := range d.integrations {
+				if ev.Recipient != "" && d.router != nil && !d.router(ev.Recipient, ev.Type, in.Name()) {
+					continue
+				}
+				sendCtx, cancel := context.WithTimeout(ctx, 10*time.Second)
+				err := in.Send(sendCtx, ev)
+				cancel()
+				if err != nil {
+					d.logger.Printf("event=integration_failed integration=%s type=%s err=%v", in.Name(), ev.Type, err)
+					continue
+				}
+				d.logger.Printf("event=integration_sent integration=%s type=%s", in.Name(), ev.Type)
+			}
+			d.beat()
+		}
+	}
+}
+
+// WebhookIntegration POSTs each event as JSON to a fixed URL.
+type WebhookIntegration struct {
+	URL    string
+	Client *http.Client
+}
+
+func (wh *WebhookIntegration) Name() string { return "webhook" }
+
+func (wh *WebhookIntegration) Send(ctx context.Context, ev IntegrationEvent) error {
+	body, err := json.Marshal(ev)
+	if err != nil {
+		return err
+	}
+	return postJSON(ctx, wh.Client, wh.URL, body)
+}
+
+// SlackIntegration posts each event to a Slack incoming webhook, which
+// delivers to the channel chosen when the webhook was created.
+type SlackIntegration struct {
+	WebhookURL string
+	Client     *http.Client
+}
+
+func (s *SlackIntegration) Name() string { return "slack" }
+
+func (s *SlackIntegration) Send(ctx context.Context, ev IntegrationEvent) error {
+	body, err := json.Marshal(map[string]string{"text": slackText(ev)})
+	if err != nil {
+		return err
+	}
+	return postJSON(ctx, s.Client, s.WebhookURL, body)
+}
+
+// slackEscape escapes the characters Slack's mrkdwn treats as control sequences.
+var slackEscape = strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;")
+
+// slackText renders ev as one mrkdwn line, linking to the UI when it has a URL.
+func slackText(ev IntegrationEvent) string {
+	text := slackEscape.Replace(ev.Message)
+	if ev.URL != "" {
+		text += fmt.Sprintf(" <%s|open>", ev.URL)
+	}
+	return text
+}
+
+func postJSON(ctx context.Context, client *http.Client, url string, body []byte) error {
+	if client == nil {
+		client = http.DefaultClient
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	res, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode >= 300 {
+		return fmt.Errorf("unexpected status %d", res.StatusCode)
+	}
+	return nil
+}
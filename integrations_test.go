@@ -0,0 +1,60 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestSlackDailyCompactEvent(t *testing.T) {
+	posts := make(chan map[string]string, 4)
+	slack := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body map[string]string
+		_ = json.NewDecoder(r.Body).Decode(&body)
+		posts <- body
+	}))
+	defer slack.Close()
+
+	app := newTestApp(t)
+	app.externalURL = "https://devlog.example.com"
+	app.dispatcher = NewIntegrationDispatcher(app.logger, &SlackIntegration{WebhookURL: slack.URL})
+	app.dispatcher.SetLinker(app.eventURL)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go app.dispatcher.Run(ctx)
+
+	h := newTestMux(app)
+	createUser(t, app, "alice", "PUDSLACK001")
+	for _, content := range []string{"shipped <login> fix", "reviewed PRs"} {
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, authedReq(t, http.MethodPost, "/api/entries", map[string]string{"content": content}, "PUDSLACK001"))
+		if rr.Code != http.StatusCreated {
+			t.Fatalf("create: %d %s", rr.Code, rr.Body.String())
+		}
+	}
+	day := time.Now().UTC().Format("2006-01-02")
+	if err := app.compactDay(day); err != nil {
+		t.Fatalf("compactDay: %v", err)
+	}
+	var compactID int64
+	if err := app.db.QueryRow(`SELECT id FROM entries WHERE entry_type = 'daily_compact'`).Scan(&compactID); err != nil {
+		t.Fatal(err)
+	}
+	want := fmt.Sprintf("Compacted 2 entries for %s <https://devlog.example.com/entries-view?day=%s#entry-%d|open>", day, day, compactID)
+	select {
+	case body := <-posts:
+		if body["text"] != want {
+			t.Fatalf("slack text = %q, want %q", body["text"], want)
+		}
+	case <-time.After(3 * time.Second):
+		t.Fatal("no slack post for daily_compact")
+	}
+
+	if got := slackText(IntegrationEvent{Message: "a <b> & c"}); got != "a &lt;b&gt; &amp; c" {
+		t.Fatalf("escape: %q", got)
+	}
+}
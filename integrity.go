@@ -0,0 +1,348 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"flag"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+// errDayQuarantined is returned when compaction targets a day with open
+// integrity issues.
+var errDayQuarantined = errors.New("day is quarantined by the integrity self-check")
+
+// Integrity issue kinds.
+const (
+	integrityForeignKey           = "foreign_key"           // PRAGMA foreign_key_check violation
+	integrityCompactMissing       = "compact_missing"       // compaction recorded and entries merged, but no compact row
+	integrityCompactionUnrecorded = "compaction_unrecorded" // compact row without a compactions row
+	integritySourcesNotRemoved    = "sources_not_removed"   // compact row while its source entries still exist
+	integrityMergedCountMismatch  = "merged_count_mismatch" // compactions.merged_count disagrees with the compact
+)
+
+type integrityIssue struct {
+	ID         int64  `json:"id"`
+	Kind       string `json:"kind"`
+	Day        string `json:"day,omitempty"`
+	Detail     string `json:"detail"`
+	FoundAt    string `json:"found_at"`
+	ResolvedAt string `json:"resolved_at,omitempty"`
+	ResolvedBy string `json:"resolved_by,omitempty"`
+}
+
+// findIntegrityIssues runs the checks without writing anything.
+func (a *App) findIntegrityIssues() ([]integrityIssue, error) {
+	var out []integrityIssue
+
+	rows, err := a.db.Query(`PRAGMA foreign_key_check`)
+	if err != nil {
+		return nil, err
+	}
+	for rows.Next() {
+		var table, parent string
+		var rowid sql.NullInt64
+		var fkid int
+		if err := rows.Scan(&table, &rowid, &parent, &fkid); err != nil {
+			_ = rows.Close()
+			return nil, err
+		}
+		out = append(out, integrityIssue{Kind: integrityForeignKey, Detail: fmt.Sprintf("table=%s rowid=%d parent=%s", table, rowid.Int64, parent)})
+	}
+	if err := rows.Err(); err != nil {
+		_ = rows.Close()
+		return nil, err
+	}
+	_ = rows.Close()
+
+	type compactRow struct {
+		id      int64
+		day     string
+		content string
+		data    sql.NullString
+	}
+	rows, err = a.db.Query(`SELECT id, content, compact_data FROM entries WHERE entry_type = 'daily_compact' ORDER BY id ASC`)
+	if err != nil {
+		return nil, err
+	}
+	compacts := map[string][]compactRow{}
+	var compactDays []string
+	for rows.Next() {
+		var c compactRow
+		if err := rows.Scan(&c.id, &c.content, &c.data); err != nil {
+			_ = rows.Close()
+			return nil, err
+		}
+		header, _, _ := strings.Cut(c.content, "\n")
+		c.day = strings.TrimPrefix(header, compactHeaderPrefix)
+		if c.day == header {
+			continue
+		}
+		if len(compacts[c.day]) == 0 {
+			compactDays = append(compactDays, c.day)
+		}
+		compacts[c.day] = append(compacts[c.day], c)
+	}
+	if err := rows.Err(); err != nil {
+		_ = rows.Close()
+		return nil, err
+	}
+	_ = rows.Close()
+
+	rows, err = a.db.Query(`SELECT day, merged_count, phase, rollup_id FROM compactions ORDER BY day ASC`)
+	if err != nil {
+		return nil, err
+	}
+	merged := map[string]int{}
+	pending := map[string]bool{}
+	rolledInto := map[string]int64{}
+	var days []string
+	for rows.Next() {
+		var day, phase string
+		var n int
+		var rollup sql.NullInt64
+		if err := rows.Scan(&day, &n, &phase, &rollup); err != nil {
+			_ = rows.Close()
+			return nil, err
+		}
+		merged[day] = n
+		pending[day] = phase == compactionProduced
+		if rollup.Valid {
+			rolledInto[day] = rollup.Int64
+		}
+		days = append(days, day)
+	}
+	if err := rows.Err(); err != nil {
+		_ = rows.Close()
+		return nil, err
+	}
+	_ = rows.Close()
+
+	for _, day := range days {
+		if id, ok := rolledInto[day]; ok {
+			// The day's compact was replaced by a weekly or monthly rollup.
+			var n int
+			if err := a.db.QueryRow(`SELECT COUNT(*) FROM entries WHERE id = ? AND entry_type IN `+compactTypesSQL, id).Scan(&n); err != nil {
+				return nil, err
+			}
+			if n == 0 {
+				out = append(out, integrityIssue{Kind: integrityCompactMissing, Day: day, Detail: fmt.Sprintf("day was rolled up into compact id=%d, which does not exist", id)})
+			}
+			continue
+		}
+		if merged[day] > 0 && len(compacts[day]) == 0 {
+			out = append(out, integrityIssue{Kind: integrityCompactMissing, Day: day, Detail: fmt.Sprintf("compaction merged %d entries but no daily compact exists", merged[day])})
+		}
+	}
+	sort.Strings(compactDays)
+	for _, day := range compactDays {
+		cs := compacts[day]
+		n, recorded := merged[day]
+		if !recorded {
+			out = append(out, integrityIssue{Kind: integrityCompactionUnrecorded, Day: day, Detail: fmt.Sprintf("compact id=%d has no compactions row", cs[0].id)})
+		}
+		if pending[day] {
+			// Produced but not yet verified (e.g. held): the sources are
+			// still expected to exist.
+			continue
+		}
+		sources := 0
+		for _, c := range cs {
+			srcs := compactSources(c.data, c.content)
+			sources += len(srcs)
+			var live []string
+			for _, s := range srcs {
+				if s.EntryID == 0 {
+					continue
+				}
+				var id int64
+				err := a.db.QueryRow(`SELECT id FROM entries WHERE id = ? AND entry_type = 'normal'`, s.EntryID).Scan(&id)
+				if errors.Is(err, sql.ErrNoRows) {
+					continue
+				}
+				if err != nil {
+					return nil, err
+				}
+				live = append(live, fmt.Sprint(id))
+			}
+			if len(live) > 0 {
+				out = append(out, integrityIssue{Kind: integritySourcesNotRemoved, Day: day, Detail: fmt.Sprintf("compact id=%d source entries still present: %s", c.id, strings.Join(live, ","))})
+			}
+		}
+		if recorded && n != sources {
+			out = append(out, integrityIssue{Kind: integrityMergedCountMismatch, Day: day, Detail: fmt.Sprintf("compactions.merged_count=%d compact sources=%d", n, sources)})
+		}
+	}
+	return out, nil
+}
+
+// integritySelfCheck records newly found issues in integrity_issues and marks
+// open issues that no longer reproduce as resolved. Days with open issues are
+// quarantined: compaction skips them and reads flag them.
+func (a *App) integritySelfCheck() ([]integrityIssue, error) {
+	found, err := a.findIntegrityIssues()
+	if err != nil {
+		return nil, err
+	}
+	now := nowUTC()
+	seen := map[string]bool{}
+	for _, is := range found {
+		seen[is.Kind+"\x00"+is.Day+"\x00"+is.Detail] = true
+		res, err := a.db.Exec(`
+INSERT INTO integrity_issues(kind, day, detail, found_at) VALUES(?, ?, ?, ?)
+ON CONFLICT(kind, day, detail) DO UPDATE SET resolved_at = NULL, resolved_by = NULL WHERE resolved_at IS NOT NULL`,
+			is.Kind, is.Day, is.Detail, now)
+		if err != nil {
+			return nil, err
+		}
+		if n, _ := res.RowsAffected(); n > 0 {
+			a.logger.Printf("event=integrity_issue kind=%s day=%s detail=%q", is.Kind, is.Day, is.Detail)
+		}
+	}
+
+	open, err := a.integrityIssues(true)
+	if err != nil {
+		return nil, err
+	}
+	var still []integrityIssue
+	for _, is := range open {
+		if seen[is.Kind+"\x00"+is.Day+"\x00"+is.Detail] {
+			still = append(still, is)
+			continue
+		}
+		if _, err := a.db.Exec(`UPDATE integrity_issues SET resolved_at = ?, resolved_by = ? WHERE id = ?`, now, actorSystem.Username, is.ID); err != nil {
+			return nil, err
+		}
+		a.logger.Printf("event=integrity_issue_cleared id=%d kind=%s day=%s", is.ID, is.Kind, is.Day)
+	}
+	return still, nil
+}
+
+func (a *App) integrityIssues(openOnly bool) ([]integrityIssue, error) {
+	query := `SELECT id, kind, day, detail, found_at, COALESCE(resolved_at, ''), COALESCE(resolved_by, '') FROM integrity_issues`
+	if openOnly {
+		query += ` WHERE resolved_at IS NULL`
+	}
+	rows, err := a.db.Query(query + ` ORDER BY id ASC`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	out := []integrityIssue{}
+	for rows.Next() {
+		var is integrityIssue
+		if err := rows.Scan(&is.ID, &is.Kind, &is.Day, &is.Detail, &is.FoundAt, &is.ResolvedAt, &is.ResolvedBy); err != nil {
+			return nil, err
+		}
+		out = append(out, is)
+	}
+	return out, rows.Err()
+}
+
+// dayIntegrityIssues returns the open issues quarantining day.
+func (a *App) dayIntegrityIssues(day string) ([]integrityIssue, error) {
+	return a.rangeIntegrityIssues(day, day)
+}
+
+// rangeIntegrityIssues returns the open issues quarantining days from from
+// to to, inclusive.
+func (a *App) rangeIntegrityIssues(from, to string) ([]integrityIssue, error) {
+	rows, err := a.db.Query(`SELECT id, kind, day, detail, found_at FROM integrity_issues WHERE day BETWEEN ? AND ? AND resolved_at IS NULL ORDER BY id ASC`, from, to)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var out []integrityIssue
+	for rows.Next() {
+		var is integrityIssue
+		if err := rows.Scan(&is.ID, &is.Kind, &is.Day, &is.Detail, &is.FoundAt); err != nil {
+			return nil, err
+		}
+		out = append(out, is)
+	}
+	return out, rows.Err()
+}
+
+func (a *App) dayQuarantined(day string) (bool, error) {
+	var n int
+	err := a.db.QueryRow(`SELECT COUNT(*) FROM integrity_issues WHERE day = ? AND resolved_at IS NULL`, day).Scan(&n)
+	return n > 0, err
+}
+
+// handleAdminIntegrity lists integrity issues (?all=1 includes resolved ones).
+func (a *App) handleAdminIntegrity(w http.ResponseWriter, r *http.Request, u AuthedUser) {
+	if r.Method != http.MethodGet {
+		jsonErr(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	issues, err := a.integrityIssues(r.URL.Query().Get("all") != "1")
+	if err != nil {
+		jsonErr(w, http.StatusInternalServerError, "failed to query integrity issues")
+		return
+	}
+	jsonOut(w, http.StatusOK, map[string]any{"issues": issues})
+}
+
+func runAdminIntegrity(args []string) error {
+	fs := flag.NewFlagSet("admin integrity", flag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s admin integrity [--resolve <id> --note <text>] [options]\n\n", binName())
+		fmt.Fprintln(fs.Output(), "Runs the integrity self-check (also run by serve on startup) and lists open")
+		fmt.Fprintln(fs.Output(), "issues. Days with open issues are quarantined: compaction skips them and list")
+		fmt.Fprintln(fs.Output(), "responses flag them. --resolve closes an issue after a manual repair.")
+		fmt.Fprintln(fs.Output())
+		fmt.Fprintln(fs.Output(), "Options:")
+		fs.PrintDefaults()
+	}
+	resolve := fs.Int64("resolve", 0, "mark an issue as repaired by id")
+	note := fs.String("note", "", "what was repaired (required with --resolve)")
+	dbPath := fs.String("db", defaultDBPath, "sqlite db path")
+	logPath := fs.String("log", defaultLogPath, "log target path ('-' for stdout only)")
+	if err := fs.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return nil
+		}
+		return err
+	}
+	if *resolve > 0 && strings.TrimSpace(*note) == "" {
+		return errors.New("--note is required with --resolve")
+	}
+
+	app, closeApp, err := openAdminApp(*dbPath, *logPath)
+	if err != nil {
+		return err
+	}
+	defer closeApp()
+
+	if *resolve > 0 {
+		res, err := app.db.Exec(`UPDATE integrity_issues SET resolved_at = ?, resolved_by = 'admin_cli' WHERE id = ? AND resolved_at IS NULL`, nowUTC(), *resolve)
+		if err != nil {
+			return err
+		}
+		if n, _ := res.RowsAffected(); n == 0 {
+			return fmt.Errorf("no open integrity issue with id %d", *resolve)
+		}
+		_ = app.logAction("admin_cli", "admin", "resolve_integrity_issue", fmt.Sprintf("issue_id=%d note=%q", *resolve, *note))
+		fmt.Printf("issue %d resolved\n", *resolve)
+		return nil
+	}
+
+	open, err := app.integritySelfCheck()
+	if err != nil {
+		return err
+	}
+	if len(open) == 0 {
+		fmt.Println("integrity: ok")
+		return nil
+	}
+	for _, is := range open {
+		day := is.Day
+		if day == "" {
+			day = "-"
+		}
+		fmt.Printf("%d\t%s\t%s\t%s\n", is.ID, is.Kind, day, is.Detail)
+	}
+	return fmt.Errorf("%d open integrity issue(s)", len(open))
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestIntegritySelfCheckQuarantinesDays(t *testing.T) {
+	app := newTestApp(t)
+	h := newTestMux(app)
+	createUser(t, app, "alice", "PUDINTEGAL1")
+	for _, content := range []string{"first", "second"} {
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, authedReq(t, http.MethodPost, "/api/entries", map[string]string{"content": content}, "PUDINTEGAL1"))
+		if rr.Code != http.StatusCreated {
+			t.Fatalf("expected 201, got %d body=%s", rr.Code, rr.Body.String())
+		}
+	}
+	day := time.Now().UTC().Format("2006-01-02")
+	if err := app.compactDay(day); err != nil {
+		t.Fatalf("compactDay: %v", err)
+	}
+	open, err := app.integritySelfCheck()
+	if err != nil || len(open) != 0 {
+		t.Fatalf("expected clean check, got %+v err=%v", open, err)
+	}
+
+	// Simulate a compaction that committed its bookkeeping but lost the compact row.
+	if _, err := app.db.Exec(`DELETE FROM entries WHERE entry_type = 'daily_compact'`); err != nil {
+		t.Fatalf("delete compact: %v", err)
+	}
+	if _, err := app.db.Exec(`PRAGMA foreign_keys=OFF; INSERT INTO entry_links(source_id, target_id, created_at) VALUES(9001, 9002, 'x'); PRAGMA foreign_keys=ON;`); err != nil {
+		t.Fatalf("insert orphan link: %v", err)
+	}
+	open, err = app.integritySelfCheck()
+	if err != nil {
+		t.Fatalf("integritySelfCheck: %v", err)
+	}
+	kinds := map[string]bool{}
+	for _, is := range open {
+		kinds[is.Kind] = true
+	}
+	if !kinds[integrityCompactMissing] || !kinds[integrityForeignKey] {
+		t.Fatalf("expected compact_missing and foreign_key issues, got %+v", open)
+	}
+
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, authedReq(t, http.MethodGet, "/api/entries?day="+day, nil, "PUDINTEGAL1"))
+	if !strings.Contains(rr.Body.String(), `"quarantined":true`) || !strings.Contains(rr.Body.String(), integrityCompactMissing) {
+		t.Fatalf("expected quarantined day in list, got %s", rr.Body.String())
+	}
+	if _, err := app.db.Exec(`DELETE FROM compactions WHERE day = ?`, day); err != nil {
+		t.Fatalf("delete compactions row: %v", err)
+	}
+	if err := app.compactDay(day); !errors.Is(err, errDayQuarantined) {
+		t.Fatalf("expected errDayQuarantined, got %v", err)
+	}
+
+	if _, err := app.db.Exec(`DELETE FROM entry_links WHERE source_id = 9001`); err != nil {
+		t.Fatalf("repair: %v", err)
+	}
+	open, err = app.integritySelfCheck()
+	if err != nil || len(open) != 0 {
+		t.Fatalf("expected issues to clear after repair, got %+v err=%v", open, err)
+	}
+}
@@ -0,0 +1,258 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+)
+
+var issueKeyRe = regexp.MustCompile(`\b[A-Z][A-Z0-9]{1,9}-[0-9]+\b`)
+
+// errIssueNotFound is returned by trackers when a key does not resolve.
+var errIssueNotFound = errors.New("issue not found")
+
+type Issue struct {
+	Key    string `json:"key"`
+	Title  string `json:"title"`
+	Status string `json:"status"`
+	URL    string `json:"url,omitempty"`
+}
+
+// Label is the human-facing "KEY: title" form used in listings and compacts.
+func (i Issue) Label() string {
+	if i.Status == "" {
+		return i.Key + ": " + i.Title
+	}
+	return i.Key + ": " + i.Title + " (" + i.Status + ")"
+}
+
+// IssueTracker resolves issue keys against an external tracker.
+type IssueTracker interface {
+	Name() string
+	Lookup(ctx context.Context, key string) (Issue, error)
+}
+
+// extractIssueKeys returns unique issue keys in content, optionally limited to
+// the given project prefixes.
+func extractIssueKeys(content string, projects []string) []string {
+	seen := map[string]bool{}
+	var keys []string
+	for _, k := range issueKeyRe.FindAllString(content, -1) {
+		if seen[k] {
+			continue
+		}
+		if len(projects) > 0 {
+			prefix := k[:strings.IndexByte(k, '-')]
+			allowed := false
+			for _, p := range projects {
+				if p == prefix {
+					allowed = true
+					break
+				}
+			}
+			if !allowed {
+				continue
+			}
+		}
+		seen[k] = true
+		keys = append(keys, k)
+	}
+	return keys
+}
+
+// enrichEntryIssues resolves issue keys referenced by an entry and records
+// them in issue_refs. It runs off the request path; failures are logged.
+func (a *App) enrichEntryIssues(entryID int64, content string) {
+	if a.issueTracker == nil {
+		return
+	}
+	keys := extractIssueKeys(content, a.issueProjects)
+	if len(keys) == 0 {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	for _, key := range keys {
+		issue, err := a.resolveIssue(ctx, key)
+		if errors.Is(err, errIssueNotFound) {
+			continue
+		}
+		if err != nil {
+			a.logger.Printf("event=issue_lookup_failed tracker=%s key=%s err=%v", a.issueTracker.Name(), key, err)
+			continue
+		}
+		if _, err := a.db.Exec(`INSERT OR IGNORE INTO issue_refs(entry_id, issue_key) VALUES(?, ?)`, entryID, issue.Key); err != nil {
+			a.logger.Printf("event=issue_ref_insert_failed entry_id=%d key=%s err=%v", entryID, key, err)
+		}
+	}
+}
+
+// resolveIssue returns a cached issue when fresh, otherwise fetches and caches it.
+func (a *App) resolveIssue(ctx context.Context, key string) (Issue, error) {
+	var is Issue
+	var fetchedAt string
+	err := a.db.QueryRow(`SELECT issue_key, title, status, url, fetched_at FROM issues WHERE issue_key = ?`, key).Scan(&is.Key, &is.Title, &is.Status, &is.URL, &fetchedAt)
+	if err == nil {
+		if t, perr := time.Parse(time.RFC3339, fetchedAt); perr == nil && time.Since(t) < time.Hour {
+			return is, nil
+		}
+	} else if !errors.Is(err, sql.ErrNoRows) {
+		return Issue{}, err
+	}
+
+	is, err = a.issueTracker.Lookup(ctx, key)
+	if err != nil {
+		return Issue{}, err
+	}
+	_, err = a.db.Exec(`
+INSERT INTO issues(issue_key, title, status, url, fetched_at) VALUES(?, ?, ?, ?, ?)
+ON CONFLICT(issue_key) DO UPDATE SET title = excluded.title, status = excluded.status, url = excluded.url, fetched_at = excluded.fetched_at`,
+		is.Key, is.Title, is.Status, is.URL, nowUTC())
+	return is, err
+}
+
+// entryIssues loads resolved issues for the given entry ids.
+func (a *App) entryIssues(ids []int64) (map[int64][]Issue, error) {
+	out := map[int64][]Issue{}
+	if len(ids) == 0 {
+		return out, nil
+	}
+	args := make([]any, len(ids))
+	for i, id := range ids {
+		args[i] = id
+	}
+	rows, err := a.db.Query(`
+SELECT r.entry_id, i.issue_key, i.title, i.status, i.url
+FROM issue_refs r
+JOIN issues i ON i.issue_key = r.issue_key
+WHERE r.entry_id IN (`+placeholders(len(ids))+`)
+ORDER BY r.entry_id, i.issue_key`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var id int64
+		var is Issue
+		if err := rows.Scan(&id, &is.Key, &is.Title, &is.Status, &is.URL); err != nil {
+			return nil, err
+		}
+		out[id] = append(out[id], is)
+	}
+	return out, rows.Err()
+}
+
+func placeholders(n int) string {
+	if n <= 0 {
+		return ""
+	}
+	return strings.Repeat("?, ", n-1) + "?"
+}
+
+// JiraTracker looks issues up through the Jira REST API v2.
+type JiraTracker struct {
+	BaseURL string
+	Email   string
+	Token   string
+	Client  *http.Client
+}
+
+func (j *JiraTracker) Name() string { return "jira" }
+
+func (j *JiraTracker) Lookup(ctx context.Context, key string) (Issue, error) {
+	base := strings.TrimRight(j.BaseURL, "/")
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, base+"/rest/api/2/issue/"+url.PathEscape(key)+"?fields=summary,status", nil)
+	if err != nil {
+		return Issue{}, err
+	}
+	req.SetBasicAuth(j.Email, j.Token)
+	req.Header.Set("Accept", "application/json")
+	var body struct {
+		Key    string `json:"key"`
+		Fields struct {
+			Summary string `json:"summary"`
+			Status  struct {
+				Name string `json:"name"`
+			} `json:"status"`
+		} `json:"fields"`
+	}
+	if err := doTrackerRequest(j.Client, req, &body); err != nil {
+		return Issue{}, err
+	}
+	return Issue{Key: body.Key, Title: body.Fields.Summary, Status: body.Fields.Status.Name, URL: base + "/browse/" + body.Key}, nil
+}
+
+// LinearTracker looks issues up through the Linear GraphQL API.
+type LinearTracker struct {
+	APIKey   string
+	Endpoint string
+	Client   *http.Client
+}
+
+func (l *LinearTracker) Name() string { return "linear" }
+
+func (l *LinearTracker) Lookup(ctx context.Context, key string) (Issue, error) {
+	endpoint := l.Endpoint
+	if endpoint == "" {
+		endpoint = "https://api.linear.app/graphql"
+	}
+	payload, err := json.Marshal(map[string]any{
+		"query":     `query($id: String!) { issue(id: $id) { identifier title url state { name } } }`,
+		"variables": map[string]string{"id": key},
+	})
+	if err != nil {
+		return Issue{}, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, bytes.NewReader(payload))
+	if err != nil {
+		return Issue{}, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", l.APIKey)
+	var body struct {
+		Data struct {
+			Issue *struct {
+				Identifier string `json:"identifier"`
+				Title      string `json:"title"`
+				URL        string `json:"url"`
+				State      struct {
+					Name string `json:"name"`
+				} `json:"state"`
+			} `json:"issue"`
+		} `json:"data"`
+	}
+	if err := doTrackerRequest(l.Client, req, &body); err != nil {
+		return Issue{}, err
+	}
+	if body.Data.Issue == nil {
+		return Issue{}, errIssueNotFound
+	}
+	is := body.Data.Issue
+	return Issue{Key: is.Identifier, Title: is.Title, Status: is.State.Name, URL: is.URL}, nil
+}
+
+func doTrackerRequest(client *http.Client, req *http.Request, out any) error {
+	if client == nil {
+		client = http.DefaultClient
+	}
+	res, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode == http.StatusNotFound {
+		return errIssueNotFound
+	}
+	if res.StatusCode >= 300 {
+		return fmt.Errorf("unexpected status %d", res.StatusCode)
+	}
+	return json.NewDecoder(res.Body).Decode(out)
+}
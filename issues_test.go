@@ -0,0 +1,62 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestAPIListEntriesIssueEnrichment(t *testing.T) {
+	jira := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/rest/api/2/issue/PROJ-123" {
+			http.NotFound(w, r)
+			return
+		}
+		_, _ = io.WriteString(w, `{"key":"PROJ-123","fields":{"summary":"Fix login timeout","status":{"name":"In Progress"}}}`)
+	}))
+	defer jira.Close()
+
+	app := newTestApp(t)
+	app.issueTracker = &JiraTracker{BaseURL: jira.URL}
+	h := newTestMux(app)
+	token := "PUDISSUE234"
+	createUser(t, app, "gina", token)
+
+	res, err := app.db.Exec(`INSERT INTO entries(user_id, entry_type, content, created_at) VALUES(1, 'normal', 'picked up PROJ-123 and PROJ-999', ?)`, nowUTC())
+	if err != nil {
+		t.Fatalf("insert entry: %v", err)
+	}
+	id, _ := res.LastInsertId()
+	app.enrichEntryIssues(id, "picked up PROJ-123 and PROJ-999")
+
+	day := time.Now().UTC().Format("2006-01-02")
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, authedReq(t, http.MethodGet, "/api/entries?day="+day, nil, token))
+	var got struct {
+		Entries []entryRow `json:"entries"`
+	}
+	if err := json.Unmarshal(rr.Body.Bytes(), &got); err != nil {
+		t.Fatalf("unmarshal list response: %v", err)
+	}
+	if len(got.Entries) != 1 || len(got.Entries[0].Issues) != 1 {
+		t.Fatalf("expected one enriched issue, got %+v", got.Entries)
+	}
+	if label := got.Entries[0].Issues[0].Label(); label != "PROJ-123: Fix login timeout (In Progress)" {
+		t.Fatalf("unexpected issue label: %q", label)
+	}
+
+	if err := app.compactDay(day); err != nil {
+		t.Fatalf("compactDay: %v", err)
+	}
+	var compact string
+	if err := app.db.QueryRow(`SELECT content FROM entries WHERE entry_type = 'daily_compact'`).Scan(&compact); err != nil {
+		t.Fatalf("query compact: %v", err)
+	}
+	if !bytes.Contains([]byte(compact), []byte("[PROJ-123: Fix login timeout (In Progress)]")) {
+		t.Fatalf("compact missing issue label:\n%s", compact)
+	}
+}
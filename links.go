@@ -0,0 +1,157 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strconv"
+)
+
+// entryLinkRe matches cross-links such as [[entry:123]].
+var entryLinkRe = regexp.MustCompile(`\[\[entry:(\d+)\]\]`)
+
+// entryLinkRef is one side of a cross-link as returned by the entry API.
+type entryLinkRef struct {
+	ID        int64  `json:"id"`
+	User      string `json:"user"`
+	EntryType string `json:"entry_type"`
+	CreatedAt string `json:"created_at"`
+	URL       string `json:"url"`
+}
+
+// parseEntryLinks returns the distinct entry ids referenced in content, in
+// order of first appearance.
+func parseEntryLinks(content string) []int64 {
+	var ids []int64
+	seen := map[int64]bool{}
+	for _, m := range entryLinkRe.FindAllStringSubmatch(content, -1) {
+		id, err := strconv.ParseInt(m[1], 10, 64)
+		if err != nil || seen[id] {
+			continue
+		}
+		seen[id] = true
+		ids = append(ids, id)
+	}
+	return ids
+}
+
+// recordEntryLinks stores the cross-links of a new entry. Links to missing
+// entries and to the entry itself are dropped.
+func (a *App) recordEntryLinks(id int64, content string) {
+	for _, target := range parseEntryLinks(content) {
+		if target == id {
+			continue
+		}
+		if _, err := a.db.Exec(`
+INSERT OR IGNORE INTO entry_links(source_id, target_id, created_at)
+SELECT ?, id, ? FROM entries WHERE id = ? AND deleted_at IS NULL`, id, nowUTC(), target); err != nil {
+			a.logger.Printf("event=entry_link_failed entry_id=%d target=%d err=%v", id, target, err)
+		}
+	}
+}
+
+// moveEntryLinks repoints links of a day's normal entries (one author's when
+// userID is set) at the compact that replaces them, so the graph survives compaction. Links between two merged
+// entries would become self-links and are dropped by the cascade instead.
+func moveEntryLinks(tx *sql.Tx, day string, compactID, userID int64) error {
+	merged, args := partSources(day, userID)
+	both := append(append([]any{compactID}, args...), args...)
+	if _, err := tx.Exec(`UPDATE OR IGNORE entry_links SET source_id = ? WHERE source_id IN `+merged+` AND target_id NOT IN `+merged, both...); err != nil {
+		return err
+	}
+	_, err := tx.Exec(`UPDATE OR IGNORE entry_links SET target_id = ? WHERE target_id IN `+merged+` AND source_id NOT IN `+merged, both...)
+	return err
+}
+
+func (a *App) linkedEntries(query string, id int64) ([]entryLinkRef, error) {
+	rows, err := a.db.Query(query, id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	out := []entryLinkRef{}
+	for rows.Next() {
+		var l entryLinkRef
+		if err := rows.Scan(&l.ID, &l.User, &l.EntryType, &l.CreatedAt); err != nil {
+			return nil, err
+		}
+		l.URL = a.entryURL(l.ID, l.CreatedAt)
+		out = append(out, l)
+	}
+	return out, rows.Err()
+}
+
+// handleEntry serves /api/entries/{id}: GET returns the entry with its links,
+// PUT/PATCH edit it and DELETE moves it to the trash.
+func (a *App) handleEntry(w http.ResponseWriter, r *http.Request, u AuthedUser) {
+	id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil || id <= 0 {
+		jsonErr(w, http.StatusBadRequest, "invalid entry id")
+		return
+	}
+	switch r.Method {
+	case http.MethodGet:
+		anonymous, err := a.wantAnonymous(r)
+		if err != nil {
+			jsonErr(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		a.handleGetEntry(w, u, id, anonymous)
+	case http.MethodPut, http.MethodPatch:
+		a.handleEditEntry(w, r, u, id)
+	case http.MethodDelete:
+		a.handleTrashEntry(w, u, id)
+	default:
+		jsonErr(w, http.StatusMethodNotAllowed, "method not allowed")
+	}
+}
+
+// handleGetEntry returns one entry with its outgoing links and backlinks.
+func (a *App) handleGetEntry(w http.ResponseWriter, u AuthedUser, id int64, anonymous bool) {
+	e, err := a.entryByID(id)
+	if errors.Is(err, sql.ErrNoRows) {
+		jsonErr(w, http.StatusNotFound, "entry not found")
+		return
+	}
+	if err != nil {
+		jsonErr(w, http.StatusInternalServerError, "failed to query entry")
+		return
+	}
+	links, err := a.linkedEntries(`
+SELECT e.id, u.username, e.entry_type, e.created_at
+FROM entry_links l
+JOIN entries e ON e.id = l.target_id
+JOIN users u ON u.id = e.user_id
+WHERE l.source_id = ? AND e.deleted_at IS NULL
+ORDER BY e.id ASC`, id)
+	if err != nil {
+		jsonErr(w, http.StatusInternalServerError, "failed to query links")
+		return
+	}
+	backlinks, err := a.linkedEntries(`
+SELECT e.id, u.username, e.entry_type, e.created_at
+FROM entry_links l
+JOIN entries e ON e.id = l.source_id
+JOIN users u ON u.id = e.user_id
+WHERE l.target_id = ? AND e.deleted_at IS NULL
+ORDER BY e.id ASC`, id)
+	if err != nil {
+		jsonErr(w, http.StatusInternalServerError, "failed to query backlinks")
+		return
+	}
+	attachments, err := a.entryAttachments(id)
+	if err != nil {
+		jsonErr(w, http.StatusInternalServerError, "failed to query attachments")
+		return
+	}
+	e.URL = a.entryURL(e.ID, e.CreatedAt)
+	if anonymous {
+		e = anonymizeEntry(e)
+		anonymizeLinks(links)
+		anonymizeLinks(backlinks)
+	}
+	_ = a.logUserAction(u, "get_entry", fmt.Sprintf("entry_id=%d anonymous=%t", id, anonymous))
+	jsonOut(w, http.StatusOK, map[string]any{"entry": e, "links": links, "backlinks": backlinks, "attachments": attachments})
+}
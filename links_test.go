@@ -0,0 +1,81 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestAPIEntryLinksAndBacklinks(t *testing.T) {
+	app := newTestApp(t)
+	h := newTestMux(app)
+	createUser(t, app, "alice", "PUDABCDEF12")
+
+	yesterday := time.Now().UTC().AddDate(0, 0, -1).Format("2006-01-02")
+	res, err := app.db.Exec(`INSERT INTO entries(user_id, entry_type, content, created_at) VALUES(1, 'normal', 'root cause notes', ?)`, yesterday+"T10:00:00Z")
+	if err != nil {
+		t.Fatalf("insert old entry: %v", err)
+	}
+	oldID, _ := res.LastInsertId()
+
+	post := func(content string) int64 {
+		t.Helper()
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, authedReq(t, http.MethodPost, "/api/entries", map[string]string{"content": content}, "PUDABCDEF12"))
+		var out struct {
+			ID int64 `json:"id"`
+		}
+		if rr.Code != http.StatusCreated || json.Unmarshal(rr.Body.Bytes(), &out) != nil {
+			t.Fatalf("create entry: %d %s", rr.Code, rr.Body.String())
+		}
+		return out.ID
+	}
+	first := post("deploy plan")
+	second := post(fmt.Sprintf("follow-up to [[entry:%d]] and [[entry:%d]], see [[entry:99999]]", first, oldID))
+
+	type linkResp struct {
+		Entry     entryRow       `json:"entry"`
+		Links     []entryLinkRef `json:"links"`
+		Backlinks []entryLinkRef `json:"backlinks"`
+	}
+	get := func(id int64) (int, linkResp) {
+		t.Helper()
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, authedReq(t, http.MethodGet, fmt.Sprintf("/api/entries/%d", id), nil, "PUDABCDEF12"))
+		var out linkResp
+		_ = json.Unmarshal(rr.Body.Bytes(), &out)
+		return rr.Code, out
+	}
+
+	code, out := get(first)
+	if code != http.StatusOK || len(out.Backlinks) != 1 || out.Backlinks[0].ID != second {
+		t.Fatalf("expected backlink from %d, got %d %+v", second, code, out)
+	}
+	code, out = get(second)
+	if code != http.StatusOK || len(out.Links) != 2 || len(out.Backlinks) != 0 {
+		t.Fatalf("expected 2 outgoing links (missing target dropped), got %d %+v", code, out)
+	}
+	if code, _ := get(99999); code != http.StatusNotFound {
+		t.Fatalf("expected 404 for missing entry, got %d", code)
+	}
+
+	if err := app.compactDay(yesterday); err != nil {
+		t.Fatalf("compactDay: %v", err)
+	}
+	_, out = get(second)
+	var compactLinked bool
+	for _, l := range out.Links {
+		if l.EntryType == "daily_compact" {
+			compactLinked = true
+		}
+		if l.ID == oldID {
+			t.Fatalf("link still points at compacted entry %d", oldID)
+		}
+	}
+	if !compactLinked {
+		t.Fatalf("expected link moved to the compact, got %+v", out.Links)
+	}
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+)
+
+const (
+	defaultAPIAddr = ":9173"
+	defaultUIAddr  = ":9172"
+)
+
+// parseListenAddr validates a --api-addr/--ui-addr value. A bare port is
+// shorthand for all interfaces ("9173" -> ":9173").
+func parseListenAddr(flagName, s string) (string, error) {
+	s = strings.TrimSpace(s)
+	if _, err := strconv.Atoi(s); err == nil {
+		s = ":" + s
+	}
+	host, port, err := net.SplitHostPort(s)
+	if err != nil {
+		return "", fmt.Errorf("invalid %s %q (want host:port, e.g. 127.0.0.1:9173)", flagName, s)
+	}
+	if n, err := strconv.Atoi(port); err != nil || n < 1 || n > 65535 {
+		return "", fmt.Errorf("invalid %s %q: port must be 1-65535", flagName, s)
+	}
+	return net.JoinHostPort(host, port), nil
+}
+
+// apiBaseURL is the origin the web UI's scripts call for an API listen
+// address; wildcard and empty hosts map to localhost.
+func apiBaseURL(addr string) string {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return "http://localhost" + defaultAPIAddr
+	}
+	switch host {
+	case "", "0.0.0.0", "::":
+		host = "localhost"
+	}
+	return "http://" + net.JoinHostPort(host, port)
+}
+
+// apiBase is uiAPIBase, defaulting to the standard API port when serve did
+// not set it (tests, admin tooling).
+func (a *App) apiBase() string {
+	if a.uiAPIBase != "" {
+		return a.uiAPIBase
+	}
+	return apiBaseURL(defaultAPIAddr)
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestListenAddrs(t *testing.T) {
+	for _, tc := range []struct{ in, want, base string }{
+		{":9173", ":9173", "http://localhost:9173"},
+		{"9180", ":9180", "http://localhost:9180"},
+		{"127.0.0.1:9273", "127.0.0.1:9273", "http://127.0.0.1:9273"},
+		{"0.0.0.0:9173", "0.0.0.0:9173", "http://localhost:9173"},
+		{"[::1]:9173", "[::1]:9173", "http://[::1]:9173"},
+	} {
+		got, err := parseListenAddr("--api-addr", tc.in)
+		if err != nil || got != tc.want {
+			t.Errorf("parseListenAddr(%q) = %q, %v; want %q", tc.in, got, err, tc.want)
+			continue
+		}
+		if base := apiBaseURL(got); base != tc.base {
+			t.Errorf("apiBaseURL(%q) = %q; want %q", got, base, tc.base)
+		}
+	}
+	for _, bad := range []string{"", "localhost", "host:0", ":70000", "host:http"} {
+		if _, err := parseListenAddr("--ui-addr", bad); err == nil {
+			t.Errorf("parseListenAddr(%q): expected an error", bad)
+		}
+	}
+	t.Setenv("DEVLOG_TEST_ADDR", " 127.0.0.1:9999 ")
+	if got := envOr("DEVLOG_TEST_ADDR", defaultAPIAddr); got != "127.0.0.1:9999" {
+		t.Fatalf("envOr: %q", got)
+	}
+	if got := envOr("DEVLOG_TEST_UNSET_ADDR", defaultAPIAddr); got != defaultAPIAddr {
+		t.Fatalf("envOr default: %q", got)
+	}
+
+	// The UI scripts call the configured API origin.
+	app := newTestApp(t)
+	app.uiAPIBase = apiBaseURL("127.0.0.1:9273")
+	rr := httptest.NewRecorder()
+	app.handleUI(rr, httptest.NewRequest(http.MethodGet, "/", nil))
+	if !strings.Contains(rr.Body.String(), `const api = 'http:\/\/127.0.0.1:9273';`) {
+		t.Fatalf("UI does not use the API address:\n%s", rr.Body.String())
+	}
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Live updates: GET /api/ws upgrades to a WebSocket on which the server
+// pushes entry_created, entry_edited and compaction events as JSON text
+// messages, so open boards refresh during standup without polling. Events
+// carry ids and the day, not content: a client reloads the day it shows
+// through the normal list endpoint, which applies anonymous mode and every
+// other read rule. Browsers cannot set headers on a WebSocket, so the token
+// may also come as a "bearer.<token>" subprotocol (authUser).
+
+const (
+	liveProtocol     = "devlog"
+	liveTokenPrefix  = "bearer."
+	liveSubBuffer    = 32
+	livePingInterval = 30 * time.Second
+)
+
+type liveEvent struct {
+	Type      string `json:"type"`
+	Day       string `json:"day"`
+	EntryID   int64  `json:"entry_id,omitempty"`
+	CompactID int64  `json:"compact_id,omitempty"`
+	User      string `json:"user,omitempty"`
+	At        string `json:"at"`
+}
+
+// liveHub fans events out to subscribers. The zero value is ready to use.
+type liveHub struct {
+	mu   sync.Mutex
+	subs map[chan liveEvent]struct{}
+}
+
+// subscribe registers a subscriber. Its channel is closed when it falls
+// liveSubBuffer events behind, so the client reconnects and reloads rather
+// than silently missing events; cancel unregisters it.
+func (h *liveHub) subscribe() (<-chan liveEvent, func()) {
+	ch := make(chan liveEvent, liveSubBuffer)
+	h.mu.Lock()
+	if h.subs == nil {
+		h.subs = map[chan liveEvent]struct{}{}
+	}
+	h.subs[ch] = struct{}{}
+	h.mu.Unlock()
+	return ch, func() {
+		h.mu.Lock()
+		defer h.mu.Unlock()
+		if _, ok := h.subs[ch]; ok {
+			delete(h.subs, ch)
+			close(ch)
+		}
+	}
+}
+
+func (h *liveHub) publish(ev liveEvent) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for ch := range h.subs {
+		select {
+		case ch <- ev:
+		default:
+			delete(h.subs, ch)
+			close(ch)
+		}
+	}
+}
+
+func (h *liveHub) count() int {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return len(h.subs)
+}
+
+// publishLive stamps ev and sends it to every live subscriber. Authors are
+// left out under --anonymize force.
+func (a *App) publishLive(ev liveEvent) {
+	ev.At = nowUTC()
+	if a.anonymizeMode == anonymizeForce {
+		ev.User = ""
+	}
+	a.live.publish(ev)
+}
+
+// liveProtocolToken returns the token offered as a "bearer.<token>"
+// subprotocol on a WebSocket upgrade, or "".
+func liveProtocolToken(r *http.Request) string {
+	if !isWebSocketUpgrade(r) {
+		return ""
+	}
+	for _, v := range r.Header.Values("Sec-WebSocket-Protocol") {
+		for _, p := range strings.Split(v, ",") {
+			if tok, ok := strings.CutPrefix(strings.TrimSpace(p), liveTokenPrefix); ok {
+				return strings.TrimSpace(tok)
+			}
+		}
+	}
+	return ""
+}
+
+// liveOriginAllowed applies --cors-origins to the browser's Origin: the
+// same-origin policy does not cover WebSockets.
+func (a *App) liveOriginAllowed(origin string) bool {
+	if origin == "" || len(a.corsOrigins) == 0 {
+		return true
+	}
+	for _, o := range a.corsOrigins {
+		if o == "*" || strings.TrimRight(o, "/") == origin {
+			return true
+		}
+	}
+	return false
+}
+
+// handleLive serves GET /api/ws.
+func (a *App) handleLive(w http.ResponseWriter, r *http.Request, u AuthedUser) {
+	if r.Method != http.MethodGet {
+		jsonErr(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	if !a.liveOriginAllowed(r.Header.Get("Origin")) {
+		jsonErr(w, http.StatusForbidden, "origin not allowed")
+		return
+	}
+	conn, err := upgradeWebSocket(w, r, liveProtocol)
+	if err != nil {
+		return
+	}
+	defer conn.close()
+	events, cancel := a.live.subscribe()
+	defer cancel()
+	a.logger.Printf("event=live_connected user=%s subscribers=%d", u.Username, a.live.count())
+
+	done := make(chan error, 1)
+	go func() { done <- conn.readLoop() }()
+	ping := time.NewTicker(livePingInterval)
+	defer ping.Stop()
+	for {
+		select {
+		case ev, ok := <-events:
+			if !ok {
+				// Fell behind: close so the client reconnects and reloads.
+				_ = conn.writeFrame(wsOpClose, []byte{0x03, 0xF5}) // 1013 try again later
+				return
+			}
+			b, _ := json.Marshal(ev)
+			if err := conn.writeText(b); err != nil {
+				return
+			}
+		case <-ping.C:
+			if err := conn.writeFrame(wsOpPing, nil); err != nil {
+				return
+			}
+		case <-done:
+			return
+		}
+	}
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLiveUpdates(t *testing.T) {
+	app := newTestApp(t)
+	srv := httptest.NewServer(newTestMux(app))
+	defer srv.Close()
+	createUser(t, app, "alice", "PUDLIVEALI1")
+
+	dial := func(protocols string) (net.Conn, *bufio.Reader, string) {
+		conn, err := net.Dial("tcp", strings.TrimPrefix(srv.URL, "http://"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		key := base64.StdEncoding.EncodeToString([]byte("0123456789abcdef"))
+		fmt.Fprintf(conn, "GET /api/ws HTTP/1.1\r\nHost: x\r\nUpgrade: websocket\r\nConnection: Upgrade\r\nSec-WebSocket-Version: 13\r\nSec-WebSocket-Key: %s\r\nSec-WebSocket-Protocol: %s\r\n\r\n", key, protocols)
+		br := bufio.NewReader(conn)
+		res, err := http.ReadResponse(br, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if res.StatusCode == http.StatusSwitchingProtocols {
+			if got := res.Header.Get("Sec-WebSocket-Accept"); got != wsAcceptKey(key) {
+				t.Fatalf("accept key %q", got)
+			}
+			if got := res.Header.Get("Sec-WebSocket-Protocol"); got != liveProtocol {
+				t.Fatalf("subprotocol %q", got)
+			}
+		}
+		return conn, br, res.Status
+	}
+	if conn, _, status := dial("devlog, bearer.PUDWRONG000"); !strings.HasPrefix(status, "401") {
+		t.Fatalf("bad token upgrade: %s", status)
+	} else {
+		conn.Close()
+	}
+
+	conn, br, status := dial("devlog, bearer.PUDLIVEALI1")
+	if !strings.HasPrefix(status, "101") {
+		t.Fatalf("upgrade: %s", status)
+	}
+	defer conn.Close()
+	_ = conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	readFrame := func() (byte, []byte) {
+		var hdr [2]byte
+		if _, err := io.ReadFull(br, hdr[:]); err != nil {
+			t.Fatalf("read frame: %v", err)
+		}
+		n := int(hdr[1] & 0x7F)
+		if n == 126 {
+			var ext [2]byte
+			_, _ = io.ReadFull(br, ext[:])
+			n = int(ext[0])<<8 | int(ext[1])
+		}
+		payload := make([]byte, n)
+		if _, err := io.ReadFull(br, payload); err != nil {
+			t.Fatalf("read payload: %v", err)
+		}
+		return hdr[0] & 0x0F, payload
+	}
+	readEvent := func() liveEvent {
+		op, payload := readFrame()
+		var ev liveEvent
+		if op != wsOpText || json.Unmarshal(payload, &ev) != nil {
+			t.Fatalf("frame op=%d %q", op, payload)
+		}
+		return ev
+	}
+	// Wait for the subscription before writing.
+	for i := 0; app.live.count() == 0 && i < 100; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	post := func(method, path string, body any) map[string]any {
+		b, _ := json.Marshal(body)
+		req, _ := http.NewRequest(method, srv.URL+path, bytes.NewReader(b))
+		req.Header.Set("Authorization", "Bearer PUDLIVEALI1")
+		res, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer res.Body.Close()
+		var out map[string]any
+		_ = json.NewDecoder(res.Body).Decode(&out)
+		return out
+	}
+	created := post(http.MethodPost, "/api/entries", map[string]string{"content": "shipped the live board"})
+	id := int64(created["id"].(float64))
+	ev := readEvent()
+	if ev.Type != "entry_created" || ev.EntryID != id || ev.User != "alice" || ev.Day != time.Now().UTC().Format("2006-01-02") {
+		t.Fatalf("created event: %+v", ev)
+	}
+	post(http.MethodPatch, fmt.Sprintf("/api/entries/%d", id), map[string]string{"content": "shipped the live board, really"})
+	if ev := readEvent(); ev.Type != "entry_edited" || ev.EntryID != id {
+		t.Fatalf("edited event: %+v", ev)
+	}
+
+	// A masked client ping is answered with a pong carrying its payload.
+	mask := []byte{1, 2, 3, 4}
+	ping := []byte{0x80 | wsOpPing, 0x80 | 2}
+	ping = append(ping, mask...)
+	ping = append(ping, 'h'^mask[0], 'i'^mask[1])
+	if _, err := conn.Write(ping); err != nil {
+		t.Fatal(err)
+	}
+	if op, payload := readFrame(); op != wsOpPong || string(payload) != "hi" {
+		t.Fatalf("pong: op=%d %q", op, payload)
+	}
+	if _, err := conn.Write([]byte{0x80 | wsOpClose, 0x80, 0, 0, 0, 0}); err != nil {
+		t.Fatal(err)
+	}
+	if op, _ := readFrame(); op != wsOpClose {
+		t.Fatalf("close reply op=%d", op)
+	}
+	for i := 0; app.live.count() != 0 && i < 100; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if n := app.live.count(); n != 0 {
+		t.Fatalf("subscribers after close: %d", n)
+	}
+}
@@ -2,15 +2,15 @@ package main
 
 import (
 	"context"
-	"crypto/sha256"
 	"database/sql"
-	"encoding/hex"
+	"encoding/json"
 	"errors"
 	"flag"
 	"fmt"
 	"io"
 	"log"
 	"net/http"
+	"net/netip"
 	"os"
 	"os/signal"
 	"path/filepath"
@@ -33,19 +33,165 @@ type App struct {
 	logger      *log.Logger
 	writeLocked atomic.Bool
 	compactMu   sync.Mutex
+	auditMu     sync.Mutex
+	setupMu     sync.Mutex
+
+	redactSecrets bool
+	dispatcher    *IntegrationDispatcher
+	issueTracker  IssueTracker
+	issueProjects []string
+	calendar      *GoogleCalendar
+	// calendarKey seals refresh tokens in calendar_links (calendar.go).
+	calendarKey []byte
+
+	mailgunSigningKey string
+
+	// ciSecret (--ci-secret-file) verifies /api/inbound/ci events; ciMu
+	// serializes updates of the daily builds entry (ci.go).
+	ciSecret []byte
+	ciMu     sync.Mutex
+
+	shareKey        []byte
+	shareMaxTTL     time.Duration
+	shareLimit      *clientRateLimiter
+	claimLimit      *clientRateLimiter
+	privateKeyLimit *clientRateLimiter
+
+	trashDays int
+
+	// demo is set by serve --demo, where every visitor shares demoToken.
+	demo bool
+
+	policy *Policy
+
+	// anonymizeMode is anonymizeOff, anonymizeAllow or anonymizeForce.
+	anonymizeMode string
+
+	// compress enables zstd/gzip encoding of large list responses.
+	compress bool
+
+	// markdown (--markdown) tells the web UI to render entry content as
+	// Markdown (uiconfig.go).
+	markdown bool
+
+	presence presenceTracker
+	// live fans entry and compaction events out to /api/ws (live.go).
+	live liveHub
+
+	// meter counts requests and bytes per token owner; quotaRequests and
+	// quotaBytes are per-user daily limits fed from it (0 = unlimited).
+	meter         usageMeter
+	quotaRequests int64
+	quotaBytes    int64
+
+	// externalURL (--external-url, overridden by the external_url setting) and
+	// basePath make up the canonical UI links (deeplinks.go).
+	externalURL string
+	basePath    string
+
+	// tokenPepper (--token-pepper-file) keys token hashes (tokens.go).
+	tokenPepper []byte
+
+	// trustedProxies are the peers whose forwarding headers clientIP honors.
+	trustedProxies []netip.Prefix
+
+	// uiAPIBase is the API origin the web UI's scripts call, derived from
+	// --api-addr.
+	uiAPIBase string
+
+	// orgSettings caches the admin-editable settings table. compactionHour
+	// (--compaction-hour) replaces the built-in default until an admin saves
+	// the setting; nil keeps defaultCompactionHour.
+	orgSettings    settingsCache
+	compactionHour *int
+	// compactionMinute (--compact-at) is added to the compaction hour;
+	// compactionTZ (--compact-tz, nil = server local time) is the zone both
+	// are read in. compactionOff (--compact-at off) disables compaction.
+	compactionMinute int
+	compactionTZ     *time.Location
+	compactionOff    bool
+
+	// unversionedCutoff (--api-unversioned-cutoff) is when the deprecated
+	// unversioned /api paths stop answering; zero keeps them (apiversion.go).
+	unversionedCutoff time.Time
+
+	// corsOrigins are the browser origins withCORS allows; empty or "*"
+	// allows any.
+	corsOrigins []string
+
+	// health tracks background loop runs for /api/ready; loops stalled
+	// longer than watchdogStall (--watchdog-stall, 0 = off) are restarted.
+	health        healthTracker
+	watchdogStall time.Duration
+
+	// dbQuota holds --db-soft-quota-bytes and --db-hard-quota-bytes and the
+	// last measured level (dbquota.go).
+	dbQuota dbQuota
+
+	// auditTargets forward new action_logs rows to syslog or a SIEM
+	// (auditforward.go).
+	auditTargets []*auditTarget
+
+	// backupDir (--backup-dir) holds the SQLite backups admins can browse
+	// and restore into staging (backups.go).
+	backupDir string
+
+	// esIndexer (--es-url) mirrors entries into Elasticsearch/OpenSearch
+	// (elasticsearch.go).
+	esIndexer *esIndexer
+	// search answers /api/search (--search, search.go).
+	search searchProvider
+	// bleve is the embedded index behind --search bleve (bleve.go).
+	bleve *bleveIndex
+	// requestLog logs each API request (--request-log, reqlog.go); nil when off.
+	requestLog *requestLogger
+	// handoffTimes are the local --handoff-times, in minutes after midnight,
+	// at which a handoff event is sent (handoff.go).
+	handoffTimes []int
+
+	blobs              BlobStore
+	attachmentMaxBytes int64
+
+	writeLimit       *writeLimiter
+	routeWriteLimits map[string]*writeLimiter
 }
 
 type AuthedUser struct {
 	ID       int64  `json:"id"`
 	Username string `json:"username"`
+	Role     string `json:"role"`
+	// ImpersonatedBy is the admin acting as this user via X-Impersonate-User.
+	ImpersonatedBy string `json:"impersonated_by,omitempty"`
+	// ClientIP is the request's client address (clientIP), recorded on audit rows.
+	ClientIP string `json:"-"`
+	// RequestID and Route identify the HTTP request (requestFrom), recorded
+	// on audit rows.
+	RequestID string `json:"-"`
+	Route     string `json:"-"`
+	// TokenID is the tokens row the request authenticated with.
+	TokenID int64 `json:"-"`
+	// Exchanged is set when that token is a short-lived browser token from
+	// /api/auth/exchange.
+	Exchanged bool `json:"-"`
 }
 
+const (
+	roleAdmin  = "admin"
+	roleMember = "member"
+)
+
 type entryRow struct {
-	ID        int64  `json:"id"`
-	User      string `json:"user"`
-	EntryType string `json:"entry_type"`
-	Content   string `json:"content"`
-	CreatedAt string `json:"created_at"`
+	ID        int64   `json:"id"`
+	User      string  `json:"user"`
+	UserKind  string  `json:"user_kind"`
+	EntryType string  `json:"entry_type"`
+	Category  string  `json:"category,omitempty"`
+	Source    string  `json:"source,omitempty"`
+	Content   string  `json:"content"`
+	CreatedAt string  `json:"created_at"`
+	EditedAt  string  `json:"edited_at,omitempty"`
+	URL       string  `json:"url,omitempty"`
+	Issues    []Issue `json:"issues,omitempty"`
 }
 
 func main() {
@@ -62,6 +208,14 @@ func run() error {
 			return runServe(os.Args[2:])
 		case "admin":
 			return runAdmin(os.Args[2:])
+		case "import":
+			return runImport(os.Args[2:])
+		case "standby":
+			return runStandby(os.Args[2:])
+		case "self-update":
+			return runSelfUpdate(os.Args[2:])
+		case "version", "--version":
+			return runVersion()
 		case "help", "-h", "--help":
 			printRootUsage(os.Stdout)
 			return nil
@@ -74,19 +228,138 @@ func runServe(args []string) error {
 	fs := flag.NewFlagSet("serve", flag.ContinueOnError)
 	fs.Usage = func() {
 		fmt.Fprintf(fs.Output(), "Usage: %s serve [options]\n\n", binName())
-		fmt.Fprintln(fs.Output(), "Runs API (:9173) and web UI (:9172).")
+		fmt.Fprintln(fs.Output(), "Runs API (:9173) and web UI (:9172); see --api-addr and --ui-addr.")
+		fmt.Fprintln(fs.Output(), "Every option can also be set in a TOML/YAML file passed with --config.")
 		fmt.Fprintln(fs.Output())
 		fmt.Fprintln(fs.Output(), "Options:")
 		fs.PrintDefaults()
 	}
+	configPath := fs.String("config", envOr("DEVLOG_CONFIG", ""), "TOML (.toml) or YAML (.yaml) file with option values; flags and env vars override it (env DEVLOG_CONFIG)")
 	dbPath := fs.String("db", defaultDBPath, "sqlite db path")
 	logPath := fs.String("log", defaultLogPath, "log target path ('-' for stdout only)")
+	demo := fs.Bool("demo", false, "public demo: temporary seeded database, shared member token "+demoToken+", hourly reset")
+	compactionHour := fs.Int("compaction-hour", defaultCompactionHour, "local hour daily compaction runs at until the compaction_hour org setting is saved")
+	compactAt := fs.String("compact-at", "", "local HH:MM daily compaction runs at (replaces --compaction-hour), or 'off' to disable compaction")
+	compactTZ := fs.String("compact-tz", "", "IANA time zone for --compact-at and the compaction_hour setting (default: server local time)")
+	corsOrigins := fs.String("cors-origins", "*", "comma-separated browser origins allowed to call the API ('*' allows any)")
+	apiAddr := fs.String("api-addr", envOr("DEVLOG_API_ADDR", defaultAPIAddr), "API listen address, e.g. 127.0.0.1:9173 (env DEVLOG_API_ADDR)")
+	uiAddr := fs.String("ui-addr", envOr("DEVLOG_UI_ADDR", defaultUIAddr), "web UI listen address, e.g. 127.0.0.1:9172 (env DEVLOG_UI_ADDR)")
+	redactSecrets := fs.Bool("redact-secrets", true, "redact detected credentials from entry content before storage")
+	webhookURL := fs.String("webhook-url", "", "POST integration events (keyword alerts, ...) as JSON to this URL")
+	slackWebhookURL := fs.String("slack-webhook-url", "", "post integration events to this Slack incoming webhook URL")
+	jiraURL := fs.String("jira-url", "", "Jira base URL used to enrich issue keys (e.g. https://acme.atlassian.net)")
+	jiraEmail := fs.String("jira-email", "", "Jira account email for API auth")
+	jiraToken := fs.String("jira-token", "", "Jira API token")
+	linearKey := fs.String("linear-api-key", "", "Linear API key used to enrich issue keys (ignored when --jira-url is set)")
+	issueProjects := fs.String("issue-projects", "", "comma-separated project keys to enrich (default: any KEY-123 pattern)")
+	gitRepos := fs.String("git-repos", "", "comma-separated git repositories to import commits from hourly")
+	requestLogOn := fs.Bool("request-log", true, "log method, path, status, bytes, duration and user of every API request")
+	requestLogSample := fs.Float64("request-log-sample", 1, "fraction (0..1) of successful API requests to log; errors are always logged")
+	requestLogExclude := fs.String("request-log-exclude", "", "comma-separated API paths never request-logged (e.g. /api/health,/metrics)")
+	handoffTimesFlag := fs.String("handoff-times", "", "comma-separated local HH:MM times to send a shift handoff integration event (e.g. 08:00,16:00)")
+	googleClientID := fs.String("google-client-id", "", "Google OAuth client id for optional calendar meeting-load import")
+	googleClientSecret := fs.String("google-client-secret", "", "Google OAuth client secret")
+	googleRedirectURL := fs.String("google-redirect-url", "", "OAuth redirect URL, routed to /api/calendar/callback")
+	calendarKeyFile := fs.String("calendar-key-file", "", "file holding the key that encrypts stored calendar refresh tokens (default: <db>.calendar-key, generated if missing)")
+	writeConcurrency := fs.Int("write-concurrency", 4, "max concurrent write requests admitted to the database")
+	writeQueue := fs.Int("write-queue", 128, "max write requests waiting for a slot before new ones get 503")
+	writeWait := fs.Duration("write-wait", 5*time.Second, "max time a write request waits for a slot")
+	routeWriteLimits := fs.String("route-write-limits", "", "extra per-route write concurrency caps (e.g. /api/inbound/email=1,/api/quick=2)")
+	mailgunSigningKey := fs.String("mailgun-signing-key", "", "Mailgun webhook signing key; enables inbound email at /api/inbound/email")
+	ciSecretFile := fs.String("ci-secret-file", "", "file holding the HMAC secret CI systems sign build events with; enables /api/inbound/ci")
+	tokenPepperFile := fs.String("token-pepper-file", "", "file holding a secret that keys API token hashes (default <db>.pepper, generated on first start); existing tokens are rehashed on first use")
+	shareKeyFile := fs.String("share-key-file", "", "file holding the HMAC key for public share links; enables /api/share and /api/embed")
+	shareMaxTTL := fs.Duration("share-max-ttl", 7*24*time.Hour, "longest lifetime a share link may be minted with")
+	shareRate := fs.Int("share-rate", 30, "max share link views per client address per minute")
+	policyFile := fs.String("policy-file", "", "JSON role x action overrides for the authorization policy")
+	compress := fs.Bool("compress", true, "zstd/gzip-encode entry list responses when the client accepts it")
+	markdown := fs.Bool("markdown", false, "render entry content as Markdown in the web UI")
+	anonymize := fs.String("anonymize", anonymizeOff, "anonymous mode for list/entry/export reads: off, allow (?anonymize=1) or force")
+	backupDir := fs.String("backup-dir", "", "directory of SQLite backup files (*.db) admins can list, download and restore into a staging copy")
+	esURL := fs.String("es-url", "", "Elasticsearch/OpenSearch base URL; enables indexing entries and compacts there")
+	esIndex := fs.String("es-index", defaultESIndex, "Elasticsearch/OpenSearch index name for --es-url")
+	esAPIKeyFile := fs.String("es-api-key-file", "", "file holding an Elasticsearch API key for --es-url (instead of URL credentials)")
+	searchName := fs.String("search", searchSQLite, "search provider for /api/search: sqlite (built-in full-text index), bleve (embedded typo-tolerant index) or elasticsearch (needs --es-url)")
+	blevePath := fs.String("bleve-path", "", "directory of the --search bleve index (default: <db>.bleve)")
+	trashDays := fs.Int("trash-days", defaultTrashDays, "days a deleted entry stays restorable before it is purged")
+	attachmentStore := addBlobFlags(fs)
+	attachmentMaxBytes := fs.Int64("attachment-max-bytes", defaultAttachmentMaxBytes, "largest accepted attachment upload; attachments need --store")
+	quotaRequests := fs.Int64("quota-requests", 0, "per-user daily (UTC) API request quota; 0 disables")
+	quotaBytes := fs.Int64("quota-bytes", 0, "per-user daily (UTC) API request+response byte quota; 0 disables")
+	externalURL := fs.String("external-url", "", "public URL of the web UI (e.g. https://devlog.example.com); makes deep links absolute")
+	basePath := fs.String("base-path", "", "path prefix the web UI is served under (e.g. /devlog)")
+	apiCutoff := fs.String("api-unversioned-cutoff", "", "YYYY-MM-DD from which unversioned /api paths answer 410 (use /api/v1); '' keeps them, deprecated")
+	dbSoftQuota := fs.Int64("db-soft-quota-bytes", 0, "warn (integration event) when the database's pages in use reach this size; 0 disables")
+	dbHardQuota := fs.Int64("db-hard-quota-bytes", 0, "send db_quota_exceeded at this size and, with --db-quota-retention-days, prune harder; 0 disables")
+	dbQuotaRetention := fs.Int("db-quota-retention-days", 0, "over the hard quota, cap every retention rule (and archive, journal and usage rows) at this age; 0 disables")
+	slowWrite := fs.Duration("db-slow-write", defaultSlowWrite, "log write statements (including SQLite lock waits) slower than this; 0 disables")
+	watchdogStall := fs.Duration("watchdog-stall", defaultWatchdogStall, "restart a background loop with no heartbeat for this long (at least 3 intervals); 0 disables")
+	auditSyslog := fs.String("audit-syslog", "", "forward audit rows as RFC 5424 syslog to udp://, tcp:// or tls://host:port")
+	auditSyslogActions := fs.String("audit-syslog-actions", "*", "comma-separated action patterns forwarded to --audit-syslog (e.g. impersonate,*_user)")
+	auditHTTP := fs.String("audit-http", "", "forward audit rows as newline-delimited JSON POSTs to this SIEM URL")
+	auditHTTPTokenFile := fs.String("audit-http-token-file", "", "file holding a bearer token for --audit-http")
+	auditHTTPActions := fs.String("audit-http-actions", "*", "comma-separated action patterns forwarded to --audit-http")
+	trustedProxiesFlag := fs.String("trusted-proxies", defaultTrustedProxies, "comma-separated proxy IPs/CIDRs whose X-Forwarded-For/X-Real-IP headers are trusted ('' trusts none)")
 	if err := fs.Parse(args); err != nil {
 		if errors.Is(err, flag.ErrHelp) {
 			return nil
 		}
 		return err
 	}
+	if *configPath != "" {
+		values, err := loadConfigFile(*configPath)
+		if err != nil {
+			return err
+		}
+		if err := applyConfig(fs, values); err != nil {
+			return err
+		}
+	}
+	if *compactionHour < 0 || *compactionHour > 23 {
+		return errors.New("--compaction-hour must be between 0 and 23")
+	}
+	compactHour, compactMinute, compactOff, err := parseCompactAt(*compactAt)
+	if err != nil {
+		return err
+	}
+	if *compactAt != "" {
+		hourSet := false
+		fs.Visit(func(f *flag.Flag) { hourSet = hourSet || f.Name == "compaction-hour" })
+		if hourSet {
+			return errors.New("use either --compact-at or --compaction-hour, not both")
+		}
+		*compactionHour = compactHour
+	}
+	var compactLoc *time.Location
+	if *compactTZ != "" {
+		if compactLoc, err = time.LoadLocation(*compactTZ); err != nil {
+			return fmt.Errorf("invalid --compact-tz %q: %w", *compactTZ, err)
+		}
+	}
+	if *demo {
+		dbSet := false
+		fs.Visit(func(f *flag.Flag) { dbSet = dbSet || f.Name == "db" })
+		if dbSet {
+			return errors.New("--demo runs on a temporary database; drop --db")
+		}
+		dir, err := os.MkdirTemp("", "devlog-demo-")
+		if err != nil {
+			return err
+		}
+		defer os.RemoveAll(dir)
+		*dbPath = filepath.Join(dir, "demo.db")
+	}
+
+	if st, ok, err := readStandbyState(*dbPath); err != nil {
+		return err
+	} else if ok {
+		return fmt.Errorf("%s is a warm standby of %s; run '%s admin promote-standby --db %s' first", *dbPath, st.Primary, binName(), *dbPath)
+	}
+	releaseLock, err := acquireDBLock(*dbPath)
+	if err != nil {
+		return err
+	}
+	defer releaseLock()
 
 	logger, closeLog, err := buildLogger(*logPath)
 	if err != nil {
@@ -94,42 +367,265 @@ func runServe(args []string) error {
 	}
 	defer closeLog()
 
+	dbContention.setSlowLog(logger, *slowWrite)
 	db, err := openDB(*dbPath)
 	if err != nil {
 		return err
 	}
 	defer db.Close()
 
-	app := &App{db: db, logger: logger}
+	routeLimits, err := parseRouteWriteLimits(*routeWriteLimits, *writeQueue, *writeWait)
+	if err != nil {
+		return err
+	}
+	shareKey, err := readKeyFile(*shareKeyFile)
+	if err != nil {
+		return err
+	}
+	tokenPepper, err := loadTokenPepper(*tokenPepperFile, *dbPath, true, logger)
+	if err != nil {
+		return err
+	}
+	ciSecret, err := readKeyFile(*ciSecretFile)
+	if err != nil {
+		return err
+	}
+	policy, err := loadPolicy(*policyFile)
+	if err != nil {
+		return err
+	}
+	anonymizeMode, err := parseAnonymizeMode(*anonymize)
+	if err != nil {
+		return err
+	}
+	extURL, err := parseExternalURL(*externalURL)
+	if err != nil {
+		return err
+	}
+	uiBase, err := normalizeBasePath(*basePath)
+	if err != nil {
+		return err
+	}
+	unversionedCutoff, err := parseAPICutoff(*apiCutoff)
+	if err != nil {
+		return err
+	}
+	if *dbSoftQuota < 0 || *dbHardQuota < 0 || *dbQuotaRetention < 0 {
+		return errors.New("--db-soft-quota-bytes, --db-hard-quota-bytes and --db-quota-retention-days must not be negative")
+	}
+	if *dbSoftQuota > 0 && *dbHardQuota > 0 && *dbSoftQuota >= *dbHardQuota {
+		return errors.New("--db-soft-quota-bytes must be below --db-hard-quota-bytes")
+	}
+	if *dbQuotaRetention > 0 && *dbHardQuota == 0 {
+		return errors.New("--db-quota-retention-days needs --db-hard-quota-bytes")
+	}
+	trustedProxies, err := parseTrustedProxies(*trustedProxiesFlag)
+	if err != nil {
+		return err
+	}
+	listenAPI, err := parseListenAddr("--api-addr", *apiAddr)
+	if err != nil {
+		return err
+	}
+	listenUI, err := parseListenAddr("--ui-addr", *uiAddr)
+	if err != nil {
+		return err
+	}
+	if listenAPI == listenUI {
+		return fmt.Errorf("--api-addr and --ui-addr must differ (both %s)", listenAPI)
+	}
+	auditTargets, err := parseAuditTargets(*auditSyslog, *auditSyslogActions, *auditHTTP, *auditHTTPTokenFile, *auditHTTPActions)
+	if err != nil {
+		return err
+	}
+	esIndexer, err := parseESIndexer(*esURL, *esIndex, *esAPIKeyFile)
+	if err != nil {
+		return err
+	}
+	handoffTimes, err := parseHandoffTimes(*handoffTimesFlag)
+	if err != nil {
+		return err
+	}
+	requestLog, err := newRequestLogger(*requestLogOn, *requestLogSample, splitList(*requestLogExclude))
+	if err != nil {
+		return err
+	}
+
+	var integrations []Integration
+	if *webhookURL != "" {
+		integrations = append(integrations, &WebhookIntegration{URL: *webhookURL})
+	}
+	if *slackWebhookURL != "" {
+		integrations = append(integrations, &SlackIntegration{WebhookURL: *slackWebhookURL})
+	}
+
+	app := &App{
+		db:                 db,
+		logger:             logger,
+		redactSecrets:      *redactSecrets,
+		dispatcher:         NewIntegrationDispatcher(logger, integrations...),
+		issueProjects:      splitList(*issueProjects),
+		mailgunSigningKey:  *mailgunSigningKey,
+		ciSecret:           ciSecret,
+		writeLimit:         newWriteLimiter("global", *writeConcurrency, *writeQueue, *writeWait),
+		routeWriteLimits:   routeLimits,
+		shareKey:           shareKey,
+		tokenPepper:        tokenPepper,
+		shareMaxTTL:        *shareMaxTTL,
+		shareLimit:         newClientRateLimiter(*shareRate, shareRateWindow),
+		claimLimit:         newClientRateLimiter(claimRateLimit, time.Minute),
+		privateKeyLimit:    newClientRateLimiter(privateKeyRateLimit, time.Minute),
+		trashDays:          *trashDays,
+		demo:               *demo,
+		backupDir:          *backupDir,
+		esIndexer:          esIndexer,
+		handoffTimes:       handoffTimes,
+		requestLog:         requestLog,
+		policy:             policy,
+		anonymizeMode:      anonymizeMode,
+		compress:           *compress,
+		markdown:           *markdown,
+		attachmentMaxBytes: *attachmentMaxBytes,
+		quotaRequests:      *quotaRequests,
+		quotaBytes:         *quotaBytes,
+		externalURL:        extURL,
+		basePath:           uiBase,
+		unversionedCutoff:  unversionedCutoff,
+		trustedProxies:     trustedProxies,
+		uiAPIBase:          apiBaseURL(listenAPI),
+		watchdogStall:      *watchdogStall,
+		compactionHour:     compactionHour,
+		compactionMinute:   compactMinute,
+		compactionTZ:       compactLoc,
+		compactionOff:      compactOff,
+		corsOrigins:        splitList(*corsOrigins),
+		auditTargets:       auditTargets,
+	}
+	if attachmentStore.URL != "" {
+		if app.blobs, err = openBlobStore(*attachmentStore); err != nil {
+			return err
+		}
+	}
+	app.dbQuota.soft, app.dbQuota.hard, app.dbQuota.retentionDays = *dbSoftQuota, *dbHardQuota, *dbQuotaRetention
+	app.dispatcher.SetRouter(app.notificationAllowed)
+	app.dispatcher.SetLinker(app.eventURL)
+	app.dispatcher.SetDeferrer(app.deferNotification)
+	if *googleClientID != "" {
+		app.calendar = &GoogleCalendar{ClientID: *googleClientID, ClientSecret: *googleClientSecret, RedirectURL: *googleRedirectURL}
+		if app.calendarKey, err = loadCalendarKey(*calendarKeyFile, *dbPath, logger); err != nil {
+			return err
+		}
+	}
+	switch {
+	case *jiraURL != "":
+		app.issueTracker = &JiraTracker{BaseURL: *jiraURL, Email: *jiraEmail, Token: *jiraToken}
+	case *linearKey != "":
+		app.issueTracker = &LinearTracker{APIKey: *linearKey}
+	}
 	if err := app.initSchema(); err != nil {
 		return err
 	}
+	if err := app.checkTokenPepper(); err != nil {
+		return err
+	}
+	if err := app.sealCalendarLinks(); err != nil {
+		return err
+	}
+	var outboxConsumers []string
+	if app.esIndexer != nil {
+		outboxConsumers = append(outboxConsumers, outboxElasticsearch)
+	}
+	if strings.TrimSpace(*searchName) == searchBleve {
+		if *blevePath == "" {
+			*blevePath = *dbPath + ".bleve"
+		}
+		if err := app.openBleveIndex(*blevePath); err != nil {
+			return err
+		}
+		defer app.bleve.Close()
+		outboxConsumers = append(outboxConsumers, outboxBleve)
+	}
+	if err := app.setSearchOutbox(outboxConsumers...); err != nil {
+		return err
+	}
+	if app.search, err = app.newSearchProvider(*searchName); err != nil {
+		return err
+	}
+	if *demo {
+		if err := app.resetDemo(time.Now()); err != nil {
+			return fmt.Errorf("seed demo: %w", err)
+		}
+		logger.Printf("event=demo_mode db=%s token=%s reset_every=%s", *dbPath, demoToken, demoResetInterval)
+	}
+	if needed, err := app.needsSetup(); err != nil {
+		return err
+	} else if needed {
+		logger.Printf("event=setup_required url=%s hint=%q", apiBaseURL(listenUI)+app.basePath+"/setup", "open the URL on this host, or run '"+binName()+" admin create-user --role admin'")
+	}
+
+	// Entries queued before an unclean shutdown are flushed before serving.
+	if _, err := app.flushIntake(); err != nil {
+		return err
+	}
+	// Interrupted compactions are rolled back or finished first, as journaled.
+	if err := app.recoverCompactions(); err != nil {
+		return err
+	}
+	// Inconsistent days are quarantined and reported rather than served as-is.
+	if open, err := app.integritySelfCheck(); err != nil {
+		return err
+	} else if len(open) > 0 {
+		logger.Printf("event=integrity_check_failed open_issues=%d hint=%q", len(open), binName()+" admin integrity")
+	}
 
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
-	go app.compactionLoop(ctx)
-
-	apiMux := http.NewServeMux()
-	apiMux.HandleFunc("/api/health", app.handleHealth)
-	apiMux.HandleFunc("/api/me", app.withAuth(app.handleMe))
-	apiMux.HandleFunc("/api/entries", app.withAuth(app.handleEntries))
-
-	uiMux := http.NewServeMux()
-	uiMux.HandleFunc("/", app.handleUI)
-	uiMux.HandleFunc("/entries-view", app.handleEntriesViewUI)
-	uiMux.HandleFunc("/assets/oat.min.css", app.handleOatCSS)
-	uiMux.HandleFunc("/assets/oat.min.js", app.handleOatJS)
+	app.health.register(subsystemBackup, 0, time.Now())
+	app.dispatcher.SetHeartbeat(func(err error) { app.health.record(subsystemDelivery, time.Now(), err) })
+	if app.compactionOff {
+		logger.Printf("event=compaction_disabled")
+	} else {
+		app.supervise(ctx, subsystemCompaction, compactionTickInterval, app.compactionLoop)
+		app.supervise(ctx, subsystemRollup, rollupInterval, app.rollupLoop)
+	}
+	app.supervise(ctx, subsystemDelivery, deliveryHeartbeat, app.dispatcher.Run)
+	app.supervise(ctx, subsystemTrashPurge, time.Hour, app.trashPurgeLoop)
+	app.supervise(ctx, subsystemRetention, retentionInterval, app.retentionLoop)
+	app.supervise(ctx, subsystemMeter, meterFlushInterval, app.meterLoop)
+	app.supervise(ctx, subsystemTokenPrune, tokenPruneInterval, app.tokenPruneLoop)
+	app.supervise(ctx, subsystemQuietHours, quietReleaseInterval, app.quietHoursLoop)
+	if repos := splitList(*gitRepos); len(repos) > 0 {
+		app.supervise(ctx, subsystemGitImport, time.Hour, func(ctx context.Context) { app.gitImportLoop(ctx, repos) })
+	}
+	if *demo {
+		app.supervise(ctx, subsystemDemoReset, demoResetInterval, app.demoResetLoop)
+	}
+	if len(app.auditTargets) > 0 {
+		app.supervise(ctx, subsystemAuditForward, auditForwardInterval, app.auditForwardLoop)
+	}
+	if app.esIndexer != nil {
+		app.supervise(ctx, subsystemSearch, searchIndexInterval, app.searchIndexLoop)
+	}
+	if app.bleve != nil {
+		app.supervise(ctx, subsystemBleve, bleveIndexInterval, app.bleveIndexLoop)
+	}
+	if len(app.handoffTimes) > 0 {
+		app.supervise(ctx, subsystemHandoff, handoffTickInterval, app.handoffLoop)
+	}
+	if app.dbQuota.enabled() {
+		app.supervise(ctx, subsystemDBQuota, dbQuotaInterval, app.dbQuotaLoop)
+	}
 
-	apiServer := &http.Server{Addr: ":9173", Handler: app.withCORS(apiMux)}
-	uiServer := &http.Server{Addr: ":9172", Handler: uiMux}
+	apiServer := &http.Server{Addr: listenAPI, Handler: app.handler()}
+	uiServer := &http.Server{Addr: listenUI, Handler: app.uiHandler()}
 
 	errCh := make(chan error, 2)
 	go func() {
-		app.logger.Printf("event=server_start kind=api port=9173")
+		app.logger.Printf("event=server_start kind=api addr=%s", listenAPI)
 		errCh <- apiServer.ListenAndServe()
 	}()
 	go func() {
-		app.logger.Printf("event=server_start kind=ui port=9172")
+		app.logger.Printf("event=server_start kind=ui addr=%s", listenUI)
 		errCh <- uiServer.ListenAndServe()
 	}()
 
@@ -149,6 +645,9 @@ func runServe(args []string) error {
 	defer shutdownCancel()
 	_ = apiServer.Shutdown(shutdownCtx)
 	_ = uiServer.Shutdown(shutdownCtx)
+	if err := app.meter.flush(app.db); err != nil {
+		app.logger.Printf("event=meter_flush_failed err=%v", err)
+	}
 	return nil
 }
 
@@ -157,6 +656,10 @@ func printRootUsage(w io.Writer) {
 	fmt.Fprintln(w, "Commands:")
 	fmt.Fprintln(w, "  serve        Run API and web UI servers (default if no command is provided)")
 	fmt.Fprintln(w, "  admin        Administrative commands (user/token management)")
+	fmt.Fprintln(w, "  import       Import entries from external sources (git)")
+	fmt.Fprintln(w, "  standby      Keep a warm standby copy of a primary's database")
+	fmt.Fprintln(w, "  self-update  Replace this binary with a newer signed release")
+	fmt.Fprintln(w, "  version      Print the build version and platform")
 	fmt.Fprintln(w, "  help         Show this help")
 	fmt.Fprintln(w)
 	fmt.Fprintf(w, "Try: %s admin --help\n", binName())
@@ -180,11 +683,9 @@ func buildLogger(path string) (*log.Logger, func(), error) {
 	return logger, func() { _ = f.Close() }, nil
 }
 
+// openDB opens the database with write statements timed into dbContention.
 func openDB(path string) (*sql.DB, error) {
-	db, err := sql.Open("sqlite3", path)
-	if err != nil {
-		return nil, err
-	}
+	db := sql.OpenDB(timedConnector{dsn: path, obs: &dbContention})
 	db.SetMaxOpenConns(1)
 	db.SetMaxIdleConns(1)
 	if _, err := db.Exec(`PRAGMA journal_mode=WAL; PRAGMA busy_timeout=5000; PRAGMA foreign_keys=ON;`); err != nil {
@@ -199,63 +700,533 @@ func (a *App) initSchema() error {
 CREATE TABLE IF NOT EXISTS users (
 	id INTEGER PRIMARY KEY AUTOINCREMENT,
 	username TEXT NOT NULL UNIQUE,
-	token_hash TEXT NOT NULL UNIQUE,
+	role TEXT NOT NULL DEFAULT 'member',
+	kind TEXT NOT NULL DEFAULT 'human',
 	created_at TEXT NOT NULL
 );
+CREATE TABLE IF NOT EXISTS tokens (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	user_id INTEGER NOT NULL,
+	name TEXT NOT NULL,
+	token_hash TEXT NOT NULL UNIQUE,
+	token_scheme TEXT NOT NULL DEFAULT 'sha256',
+	created_at TEXT NOT NULL,
+	last_used_at TEXT,
+	last_used_ip TEXT NOT NULL DEFAULT '',
+	expires_at TEXT,
+	UNIQUE(user_id, name),
+	FOREIGN KEY(user_id) REFERENCES users(id)
+);
 CREATE TABLE IF NOT EXISTS entries (
 	id INTEGER PRIMARY KEY AUTOINCREMENT,
 	user_id INTEGER,
 	entry_type TEXT NOT NULL DEFAULT 'normal',
 	content TEXT NOT NULL,
+	compact_data TEXT,
 	created_at TEXT NOT NULL,
+	deleted_at TEXT,
 	FOREIGN KEY(user_id) REFERENCES users(id)
 );
 CREATE INDEX IF NOT EXISTS idx_entries_created_at ON entries(created_at);
+CREATE TABLE IF NOT EXISTS entries_archive (
+	id INTEGER PRIMARY KEY,
+	user_id INTEGER,
+	content TEXT NOT NULL,
+	category TEXT NOT NULL DEFAULT '',
+	created_at TEXT NOT NULL,
+	edited_at TEXT,
+	compact_id INTEGER NOT NULL,
+	archived_at TEXT NOT NULL
+);
+CREATE INDEX IF NOT EXISTS idx_entries_archive_created_at ON entries_archive(created_at);
+CREATE TABLE IF NOT EXISTS compact_parts (
+	compact_id INTEGER PRIMARY KEY REFERENCES entries(id) ON DELETE CASCADE,
+	day TEXT NOT NULL,
+	user_id INTEGER NOT NULL
+);
+CREATE INDEX IF NOT EXISTS idx_compact_parts_day ON compact_parts(day);
 CREATE TABLE IF NOT EXISTS action_logs (
 	id INTEGER PRIMARY KEY AUTOINCREMENT,
 	actor_type TEXT NOT NULL,
 	actor_username TEXT NOT NULL,
+	impersonator TEXT NOT NULL DEFAULT '',
 	action TEXT NOT NULL,
 	metadata TEXT NOT NULL,
-	created_at TEXT NOT NULL
+	created_at TEXT NOT NULL,
+	prev_hash TEXT NOT NULL DEFAULT '',
+	hash TEXT NOT NULL DEFAULT ''
 );
 CREATE TABLE IF NOT EXISTS compactions (
 	day TEXT PRIMARY KEY,
-	ran_at TEXT NOT NULL
+	ran_at TEXT NOT NULL,
+	merged_count INTEGER NOT NULL DEFAULT 0,
+	bytes_before INTEGER NOT NULL DEFAULT 0,
+	bytes_after INTEGER NOT NULL DEFAULT 0,
+	duration_ms INTEGER NOT NULL DEFAULT 0,
+	phase TEXT NOT NULL DEFAULT 'done',
+	compact_id INTEGER,
+	source_sha256 TEXT NOT NULL DEFAULT ''
+);
+CREATE TABLE IF NOT EXISTS api_usage (
+	day TEXT NOT NULL,
+	user_id INTEGER NOT NULL,
+	requests INTEGER NOT NULL DEFAULT 0,
+	bytes_in INTEGER NOT NULL DEFAULT 0,
+	bytes_out INTEGER NOT NULL DEFAULT 0,
+	PRIMARY KEY(day, user_id)
+);
+CREATE TABLE IF NOT EXISTS compaction_journal (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	day TEXT NOT NULL,
+	step TEXT NOT NULL,
+	detail TEXT NOT NULL,
+	at TEXT NOT NULL
+);
+CREATE INDEX IF NOT EXISTS idx_compaction_journal_day ON compaction_journal(day, id);
+CREATE TABLE IF NOT EXISTS issues (
+	issue_key TEXT PRIMARY KEY,
+	title TEXT NOT NULL,
+	status TEXT NOT NULL,
+	url TEXT NOT NULL,
+	fetched_at TEXT NOT NULL
+);
+CREATE TABLE IF NOT EXISTS issue_refs (
+	entry_id INTEGER NOT NULL,
+	issue_key TEXT NOT NULL,
+	PRIMARY KEY(entry_id, issue_key),
+	FOREIGN KEY(entry_id) REFERENCES entries(id) ON DELETE CASCADE
+);
+CREATE TABLE IF NOT EXISTS entry_links (
+	source_id INTEGER NOT NULL,
+	target_id INTEGER NOT NULL,
+	created_at TEXT NOT NULL,
+	PRIMARY KEY(source_id, target_id),
+	FOREIGN KEY(source_id) REFERENCES entries(id) ON DELETE CASCADE,
+	FOREIGN KEY(target_id) REFERENCES entries(id) ON DELETE CASCADE
+);
+CREATE INDEX IF NOT EXISTS idx_entry_links_target ON entry_links(target_id);
+CREATE TABLE IF NOT EXISTS blobs (
+	sha256 TEXT PRIMARY KEY,
+	size INTEGER NOT NULL,
+	content_type TEXT NOT NULL,
+	ref_count INTEGER NOT NULL DEFAULT 0,
+	created_at TEXT NOT NULL
+);
+CREATE TABLE IF NOT EXISTS entry_attachments (
+	entry_id INTEGER NOT NULL,
+	sha256 TEXT NOT NULL,
+	filename TEXT NOT NULL,
+	created_at TEXT NOT NULL,
+	PRIMARY KEY(entry_id, sha256),
+	FOREIGN KEY(entry_id) REFERENCES entries(id) ON DELETE CASCADE,
+	FOREIGN KEY(sha256) REFERENCES blobs(sha256)
+);
+CREATE TRIGGER IF NOT EXISTS entry_attachments_ref_add AFTER INSERT ON entry_attachments
+BEGIN
+	UPDATE blobs SET ref_count = ref_count + 1 WHERE sha256 = NEW.sha256;
+END;
+CREATE TRIGGER IF NOT EXISTS entry_attachments_ref_drop AFTER DELETE ON entry_attachments
+BEGIN
+	UPDATE blobs SET ref_count = ref_count - 1 WHERE sha256 = OLD.sha256;
+END;
+CREATE TABLE IF NOT EXISTS entry_counts (
+	day TEXT NOT NULL,
+	user_id INTEGER NOT NULL,
+	count INTEGER NOT NULL DEFAULT 0,
+	bytes INTEGER NOT NULL DEFAULT 0,
+	PRIMARY KEY(day, user_id)
+);
+-- Ownerless legacy compacts count as the system user (-1), which seedActors assigns them to.
+CREATE TRIGGER IF NOT EXISTS entry_counts_insert AFTER INSERT ON entries WHEN NEW.deleted_at IS NULL
+BEGIN
+	INSERT INTO entry_counts(day, user_id, count, bytes) VALUES(date(NEW.created_at), COALESCE(NEW.user_id, -1), 1, length(CAST(NEW.content AS BLOB)))
+	ON CONFLICT(day, user_id) DO UPDATE SET count = count + 1, bytes = bytes + excluded.bytes;
+END;
+CREATE TRIGGER IF NOT EXISTS entry_counts_delete AFTER DELETE ON entries WHEN OLD.deleted_at IS NULL
+BEGIN
+	UPDATE entry_counts SET count = count - 1, bytes = bytes - length(CAST(OLD.content AS BLOB))
+	WHERE day = date(OLD.created_at) AND user_id = COALESCE(OLD.user_id, -1);
+END;
+CREATE TRIGGER IF NOT EXISTS entry_counts_trash AFTER UPDATE OF deleted_at ON entries WHEN OLD.deleted_at IS NULL AND NEW.deleted_at IS NOT NULL
+BEGIN
+	UPDATE entry_counts SET count = count - 1, bytes = bytes - length(CAST(OLD.content AS BLOB))
+	WHERE day = date(OLD.created_at) AND user_id = COALESCE(OLD.user_id, -1);
+END;
+CREATE TRIGGER IF NOT EXISTS entry_counts_restore AFTER UPDATE OF deleted_at ON entries WHEN OLD.deleted_at IS NOT NULL AND NEW.deleted_at IS NULL
+BEGIN
+	INSERT INTO entry_counts(day, user_id, count, bytes) VALUES(date(NEW.created_at), COALESCE(NEW.user_id, -1), 1, length(CAST(NEW.content AS BLOB)))
+	ON CONFLICT(day, user_id) DO UPDATE SET count = count + 1, bytes = bytes + excluded.bytes;
+END;
+CREATE TRIGGER IF NOT EXISTS entry_counts_rewrite AFTER UPDATE OF content ON entries WHEN OLD.deleted_at IS NULL AND NEW.deleted_at IS NULL
+BEGIN
+	UPDATE entry_counts SET bytes = bytes - length(CAST(OLD.content AS BLOB)) + length(CAST(NEW.content AS BLOB))
+	WHERE day = date(OLD.created_at) AND user_id = COALESCE(OLD.user_id, -1);
+END;
+CREATE TABLE IF NOT EXISTS identity_links (
+	provider TEXT NOT NULL,
+	external_id TEXT NOT NULL,
+	user_id INTEGER NOT NULL,
+	created_at TEXT NOT NULL,
+	PRIMARY KEY(provider, external_id),
+	FOREIGN KEY(user_id) REFERENCES users(id)
+);
+CREATE TABLE IF NOT EXISTS imported_commits (
+	sha TEXT PRIMARY KEY,
+	entry_id INTEGER NOT NULL,
+	imported_at TEXT NOT NULL
+);
+CREATE TABLE IF NOT EXISTS ci_builds (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	day TEXT NOT NULL,
+	pipeline TEXT NOT NULL,
+	build_id TEXT NOT NULL DEFAULT '',
+	status TEXT NOT NULL,
+	url TEXT NOT NULL DEFAULT '',
+	commit_sha TEXT NOT NULL DEFAULT '',
+	branch TEXT NOT NULL DEFAULT '',
+	received_at TEXT NOT NULL
+);
+CREATE INDEX IF NOT EXISTS idx_ci_builds_day ON ci_builds(day);
+CREATE INDEX IF NOT EXISTS idx_ci_builds_build ON ci_builds(pipeline, build_id);
+CREATE TABLE IF NOT EXISTS ci_days (
+	day TEXT PRIMARY KEY,
+	entry_id INTEGER NOT NULL
+);
+CREATE TABLE IF NOT EXISTS erasures (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	user_id INTEGER NOT NULL,
+	pseudonym TEXT NOT NULL,
+	subject_sha256 TEXT NOT NULL,
+	reason TEXT NOT NULL,
+	erased_by TEXT NOT NULL,
+	entries_kept INTEGER NOT NULL DEFAULT 0,
+	compacts_rewritten INTEGER NOT NULL DEFAULT 0,
+	mentions_rewritten INTEGER NOT NULL DEFAULT 0,
+	skipped_held TEXT NOT NULL DEFAULT '[]',
+	erased_at TEXT NOT NULL
+);
+CREATE TABLE IF NOT EXISTS deferred_notifications (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	recipient TEXT NOT NULL,
+	event_type TEXT NOT NULL,
+	payload TEXT NOT NULL,
+	release_at TEXT NOT NULL,
+	created_at TEXT NOT NULL
+);
+CREATE INDEX IF NOT EXISTS idx_deferred_notifications_release ON deferred_notifications(release_at);
+CREATE TABLE IF NOT EXISTS imported_pages (
+	source TEXT NOT NULL,
+	external_id TEXT NOT NULL,
+	entry_id INTEGER NOT NULL,
+	imported_at TEXT NOT NULL,
+	PRIMARY KEY(source, external_id)
+);
+CREATE TABLE IF NOT EXISTS calendar_links (
+	user_id INTEGER PRIMARY KEY,
+	refresh_token TEXT NOT NULL,
+	created_at TEXT NOT NULL,
+	FOREIGN KEY(user_id) REFERENCES users(id)
+);
+CREATE TABLE IF NOT EXISTS oauth_states (
+	state TEXT PRIMARY KEY,
+	user_id INTEGER NOT NULL,
+	created_at TEXT NOT NULL
+);
+CREATE TABLE IF NOT EXISTS notification_prefs (
+	user_id INTEGER NOT NULL,
+	event_type TEXT NOT NULL,
+	channel TEXT NOT NULL,
+	enabled INTEGER NOT NULL,
+	PRIMARY KEY(user_id, event_type, channel),
+	FOREIGN KEY(user_id) REFERENCES users(id)
+);
+CREATE TABLE IF NOT EXISTS intake_queue (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	user_id INTEGER NOT NULL,
+	content TEXT NOT NULL,
+	created_at TEXT NOT NULL,
+	queued_at TEXT NOT NULL,
+	FOREIGN KEY(user_id) REFERENCES users(id)
+);
+CREATE TABLE IF NOT EXISTS claim_links (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	user_id INTEGER NOT NULL,
+	code_hash TEXT NOT NULL UNIQUE,
+	created_by TEXT NOT NULL,
+	created_at TEXT NOT NULL,
+	expires_at TEXT NOT NULL,
+	claimed_at TEXT,
+	claimed_ip TEXT NOT NULL DEFAULT '',
+	FOREIGN KEY(user_id) REFERENCES users(id)
+);
+CREATE TABLE IF NOT EXISTS pending_entries (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	user_id INTEGER NOT NULL,
+	content TEXT NOT NULL,
+	category TEXT NOT NULL DEFAULT '',
+	via TEXT NOT NULL DEFAULT '',
+	created_at TEXT NOT NULL,
+	FOREIGN KEY(user_id) REFERENCES users(id)
+);
+CREATE TABLE IF NOT EXISTS integrity_issues (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	kind TEXT NOT NULL,
+	day TEXT NOT NULL DEFAULT '',
+	detail TEXT NOT NULL,
+	found_at TEXT NOT NULL,
+	resolved_at TEXT,
+	resolved_by TEXT,
+	UNIQUE(kind, day, detail)
+);
+CREATE TABLE IF NOT EXISTS audit_anchor (
+	id INTEGER PRIMARY KEY CHECK (id = 1),
+	last_id INTEGER NOT NULL,
+	last_hash TEXT NOT NULL,
+	pruned_at TEXT NOT NULL
+);
+CREATE TABLE IF NOT EXISTS legal_holds (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	start_day TEXT NOT NULL,
+	end_day TEXT NOT NULL,
+	reason TEXT NOT NULL,
+	created_at TEXT NOT NULL,
+	released_at TEXT
+);
+CREATE TABLE IF NOT EXISTS saved_views (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	user_id INTEGER NOT NULL,
+	name TEXT NOT NULL,
+	query TEXT NOT NULL DEFAULT '',
+	tags TEXT NOT NULL DEFAULT '[]',
+	users TEXT NOT NULL DEFAULT '[]',
+	range_spec TEXT NOT NULL DEFAULT '',
+	created_at TEXT NOT NULL,
+	updated_at TEXT NOT NULL,
+	UNIQUE(user_id, name),
+	FOREIGN KEY(user_id) REFERENCES users(id)
+);
+CREATE TABLE IF NOT EXISTS private_entries (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	user_id INTEGER NOT NULL,
+	content TEXT NOT NULL,
+	created_at TEXT NOT NULL,
+	FOREIGN KEY(user_id) REFERENCES users(id)
+);
+CREATE INDEX IF NOT EXISTS idx_private_entries_user ON private_entries(user_id, created_at);
+CREATE TABLE IF NOT EXISTS private_keys (
+	user_id INTEGER PRIMARY KEY,
+	salt TEXT NOT NULL,
+	iterations INTEGER NOT NULL,
+	wrapped_key TEXT NOT NULL,
+	created_at TEXT NOT NULL,
+	updated_at TEXT NOT NULL,
+	FOREIGN KEY(user_id) REFERENCES users(id)
+);
+CREATE TABLE IF NOT EXISTS settings (
+	key TEXT PRIMARY KEY,
+	value TEXT NOT NULL,
+	updated_by TEXT NOT NULL DEFAULT '',
+	updated_at TEXT NOT NULL DEFAULT ''
+);
+CREATE TABLE IF NOT EXISTS maintenance (
+	id INTEGER PRIMARY KEY CHECK (id = 1),
+	enabled INTEGER NOT NULL DEFAULT 0,
+	message TEXT NOT NULL DEFAULT '',
+	updated_by TEXT NOT NULL DEFAULT '',
+	updated_at TEXT NOT NULL DEFAULT ''
+);
+CREATE TABLE IF NOT EXISTS alert_rules (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	keyword TEXT NOT NULL UNIQUE,
+	created_at TEXT NOT NULL
+);
+CREATE TABLE IF NOT EXISTS audit_forward_cursors (
+	sink TEXT PRIMARY KEY,
+	last_id INTEGER NOT NULL,
+	updated_at TEXT NOT NULL
+);
+CREATE TABLE IF NOT EXISTS search_outbox (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	entry_id INTEGER NOT NULL,
+	queued_at TEXT NOT NULL
+);
+CREATE TABLE IF NOT EXISTS search_outbox_cursors (
+	consumer TEXT PRIMARY KEY,
+	last_id INTEGER NOT NULL,
+	updated_at TEXT NOT NULL
 );
 `
-	_, err := a.db.Exec(schema)
+	if _, err := a.db.Exec(schema); err != nil {
+		return err
+	}
+	if err := a.migrateSchema(); err != nil {
+		return err
+	}
+	if _, err := a.db.Exec(fmt.Sprintf(`PRAGMA user_version = %d`, schemaVersion)); err != nil {
+		return err
+	}
+	return a.loadSettings()
+}
+
+// schemaVersion is written to PRAGMA user_version once migrateSchema has run.
+// Bump it with every migration so 'admin restore' can refuse a snapshot made
+// by a newer release. Databases from before versioning read as 0.
+const schemaVersion = 5
+
+// migrateSchema adds columns introduced after a table was first created, so
+// databases from older releases keep working with CREATE TABLE IF NOT EXISTS.
+func (a *App) migrateSchema() error {
+	columns := []struct{ table, column, decl string }{
+		{"users", "role", "TEXT NOT NULL DEFAULT 'member'"},
+		{"action_logs", "impersonator", "TEXT NOT NULL DEFAULT ''"},
+		{"compactions", "merged_count", "INTEGER NOT NULL DEFAULT 0"},
+		{"compactions", "bytes_before", "INTEGER NOT NULL DEFAULT 0"},
+		{"compactions", "bytes_after", "INTEGER NOT NULL DEFAULT 0"},
+		{"compactions", "duration_ms", "INTEGER NOT NULL DEFAULT 0"},
+		{"action_logs", "prev_hash", "TEXT NOT NULL DEFAULT ''"},
+		{"action_logs", "hash", "TEXT NOT NULL DEFAULT ''"},
+		{"users", "kind", "TEXT NOT NULL DEFAULT 'human'"},
+		{"entries", "compact_data", "TEXT"},
+		{"entries", "deleted_at", "TEXT"},
+		{"compactions", "phase", "TEXT NOT NULL DEFAULT 'done'"},
+		{"compactions", "compact_id", "INTEGER"},
+		{"compactions", "source_sha256", "TEXT NOT NULL DEFAULT ''"},
+		{"entries", "category", "TEXT NOT NULL DEFAULT ''"},
+		{"intake_queue", "category", "TEXT NOT NULL DEFAULT ''"},
+		{"entries", "edited_at", "TEXT"},
+		// users.last_used_*/token_scheme predate the tokens table; migrateUserTokens copies them over.
+		{"users", "last_used_at", "TEXT"},
+		{"users", "last_used_ip", "TEXT NOT NULL DEFAULT ''"},
+		{"users", "token_scheme", "TEXT NOT NULL DEFAULT 'sha256'"},
+		{"action_logs", "client_ip", "TEXT NOT NULL DEFAULT ''"},
+		{"users", "disabled", "INTEGER NOT NULL DEFAULT 0"},
+		{"users", "disabled_at", "TEXT"},
+		{"users", "timezone", "TEXT NOT NULL DEFAULT ''"},
+		{"users", "quiet_start", "TEXT NOT NULL DEFAULT ''"},
+		{"users", "quiet_end", "TEXT NOT NULL DEFAULT ''"},
+		{"tokens", "parent_id", "INTEGER"},
+		{"compactions", "rollup_id", "INTEGER"},
+		{"action_logs", "request_id", "TEXT NOT NULL DEFAULT ''"},
+		{"action_logs", "route", "TEXT NOT NULL DEFAULT ''"},
+		{"entries", "source", "TEXT NOT NULL DEFAULT ''"},
+		{"entries_archive", "source", "TEXT NOT NULL DEFAULT ''"},
+		{"intake_queue", "source", "TEXT NOT NULL DEFAULT ''"},
+	}
+	for _, c := range columns {
+		if err := a.ensureColumn(c.table, c.column, c.decl); err != nil {
+			return fmt.Errorf("migrate %s.%s: %w", c.table, c.column, err)
+		}
+	}
+	if err := a.backfillAuditChain(); err != nil {
+		return err
+	}
+	if err := a.migrateUserTokens(); err != nil {
+		return err
+	}
+	if err := a.ensureSearchIndex(); err != nil {
+		return err
+	}
+	if err := a.seedActors(); err != nil {
+		return err
+	}
+	if err := a.migrateIdentityTables(); err != nil {
+		return err
+	}
+	if err := a.backfillEntryCounts(); err != nil {
+		return err
+	}
+	return a.backfillCompactData()
+}
+
+func (a *App) ensureColumn(table, column, decl string) error {
+	rows, err := a.db.Query(`SELECT name FROM pragma_table_info(?)`, table)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return err
+		}
+		if name == column {
+			return nil
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	_ = rows.Close()
+	_, err = a.db.Exec(`ALTER TABLE ` + table + ` ADD COLUMN ` + column + ` ` + decl)
 	return err
 }
 
+const compactionTickInterval = 30 * time.Second
+
 func (a *App) compactionLoop(ctx context.Context) {
-	ticker := time.NewTicker(30 * time.Second)
+	ticker := time.NewTicker(compactionTickInterval)
 	defer ticker.Stop()
+	a.health.register(subsystemCompaction, compactionTickInterval, time.Now())
 	for {
 		select {
 		case <-ctx.Done():
 			return
 		case <-ticker.C:
-			now := time.Now()
-			if now.Hour() < 17 {
-				continue
-			}
-			day := now.Format("2006-01-02")
-			ran, err := a.compactionAlreadyRan(day)
-			if err != nil {
-				a.logger.Printf("event=compaction_check_error day=%s err=%v", day, err)
-				continue
-			}
-			if ran {
-				continue
-			}
-			if err := a.compactDay(day); err != nil {
-				a.logger.Printf("event=compaction_failed day=%s err=%v", day, err)
-			}
+			err := a.compactionTick(time.Now())
+			a.health.record(subsystemCompaction, time.Now(), err)
 		}
 	}
 }
 
+// parseCompactAt parses --compact-at: "" (keep the compaction hour), "off",
+// or a local HH:MM time.
+func parseCompactAt(s string) (hour, minute int, off bool, err error) {
+	switch s = strings.TrimSpace(s); strings.ToLower(s) {
+	case "":
+		return 0, 0, false, nil
+	case "off", "never", "disabled":
+		return 0, 0, true, nil
+	}
+	t, err := time.Parse("15:04", s)
+	if err != nil {
+		return 0, 0, false, fmt.Errorf("invalid --compact-at value %q (want HH:MM or off)", s)
+	}
+	return t.Hour(), t.Minute(), false, nil
+}
+
+// compactionTick compacts today once the compaction time (the compaction
+// hour plus --compact-at minutes, in --compact-tz) has passed. Held and
+// quarantined days are not failures of the loop itself.
+func (a *App) compactionTick(now time.Time) error {
+	if a.compactionOff {
+		return nil
+	}
+	if a.compactionTZ != nil {
+		now = now.In(a.compactionTZ)
+	}
+	if now.Hour()*60+now.Minute() < a.settings().CompactionHour*60+a.compactionMinute {
+		return nil
+	}
+	day := now.Format("2006-01-02")
+	ran, err := a.compactionAlreadyRan(day)
+	if err != nil {
+		a.logger.Printf("event=compaction_check_error day=%s err=%v", day, err)
+		return err
+	}
+	if ran {
+		return nil
+	}
+	if err := a.compactDay(day); err != nil && !errors.Is(err, errDayOnHold) && !errors.Is(err, errDayQuarantined) {
+		a.logger.Printf("event=compaction_failed day=%s err=%v", day, err)
+		return err
+	}
+	if _, err := a.flushIntake(); err != nil {
+		a.logger.Printf("event=intake_flush_failed err=%v", err)
+		return err
+	}
+	return nil
+}
+
 func (a *App) compactionAlreadyRan(day string) (bool, error) {
 	var v string
 	err := a.db.QueryRow(`SELECT day FROM compactions WHERE day = ?`, day).Scan(&v)
@@ -269,123 +1240,379 @@ func (a *App) compactionAlreadyRan(day string) (bool, error) {
 }
 
 func (a *App) compactDay(day string) error {
+	held, err := a.dayOnHold(day)
+	if err != nil {
+		return err
+	}
+	if held {
+		return errDayOnHold
+	}
+	quarantined, err := a.dayQuarantined(day)
+	if err != nil {
+		return err
+	}
+	if quarantined {
+		return errDayQuarantined
+	}
+
+	phase, err := a.compactionPhase(day)
+	if err != nil {
+		return err
+	}
+	if phase == compactionDone {
+		return nil
+	}
+	// Calendar lookups hit the network, so they run before the write lock is
+	// taken, and only when the compact still has to be produced.
+	fetched := phase == ""
+	var meetings string
+	if fetched {
+		calCtx, calCancel := context.WithTimeout(context.Background(), time.Minute)
+		meetings = a.meetingLoadSummary(calCtx, day)
+		calCancel()
+	}
+
 	a.compactMu.Lock()
 	defer a.compactMu.Unlock()
 
 	a.writeLocked.Store(true)
 	defer a.writeLocked.Store(false)
+	lockedAt := time.Now()
 
-	tx, err := a.db.Begin()
+	// Compaction runs in two transactions: produce writes the compact and the
+	// checksum of its sources, finishCompaction verifies both and only then
+	// deletes the sources. A crash in between leaves a "produced" day with
+	// every source intact, which recoverCompactions picks up on startup.
+	phase, err = a.compactionPhase(day)
 	if err != nil {
 		return err
 	}
-	defer func() { _ = tx.Rollback() }()
-
-	var exists string
-	err = tx.QueryRow(`SELECT day FROM compactions WHERE day = ?`, day).Scan(&exists)
-	if err == nil {
+	switch {
+	case phase == compactionDone:
+		return nil
+	case phase == "" && !fetched:
+		// A concurrent run discarded its compact; the next run starts over.
 		return nil
+	case phase == "":
+		if err := journalCompaction(a.db, day, journalStarted, ""); err != nil {
+			return err
+		}
+		done, err := a.produceCompact(day, meetings, lockedAt)
+		if err != nil || done {
+			return err
+		}
 	}
-	if !errors.Is(err, sql.ErrNoRows) {
-		return err
+	return a.finishCompaction(day, lockedAt)
+}
+
+// produceCompact is the first compaction phase: it writes the daily compact
+// and a "produced" compactions row holding the checksum of the sources, and
+// leaves the sources in place. A day without entries is recorded as done.
+func (a *App) produceCompact(day, meetings string, lockedAt time.Time) (bool, error) {
+	tx, err := a.db.Begin()
+	if err != nil {
+		return false, err
 	}
+	defer func() { _ = tx.Rollback() }()
 
 	rows, err := tx.Query(`
 SELECT e.id,
-       COALESCE(u.username, 'system') AS username,
+       e.user_id,
+       u.username,
        e.content,
+       e.source,
        e.created_at
 FROM entries e
-LEFT JOIN users u ON u.id = e.user_id
+JOIN users u ON u.id = e.user_id
 WHERE date(e.created_at) = ?
   AND e.entry_type = 'normal'
+  AND e.deleted_at IS NULL
 ORDER BY e.created_at ASC, e.id ASC`, day)
 	if err != nil {
-		return err
+		return false, err
 	}
 
 	type sourceEntry struct {
 		ID        int64
+		UserID    int64
 		Username  string
 		Content   string
+		Source    string
 		CreatedAt string
 	}
 	entries := make([]sourceEntry, 0, 64)
 	for rows.Next() {
 		var e sourceEntry
-		if err := rows.Scan(&e.ID, &e.Username, &e.Content, &e.CreatedAt); err != nil {
+		if err := rows.Scan(&e.ID, &e.UserID, &e.Username, &e.Content, &e.Source, &e.CreatedAt); err != nil {
 			_ = rows.Close()
-			return err
+			return false, err
 		}
 		entries = append(entries, e)
 	}
 	if err := rows.Err(); err != nil {
 		_ = rows.Close()
-		return err
+		return false, err
 	}
 	_ = rows.Close()
 
-	if len(entries) > 0 {
-		var b strings.Builder
-		b.WriteString("Daily compact for ")
-		b.WriteString(day)
-		b.WriteString("\n\n")
-		for _, e := range entries {
-			b.WriteString("[")
-			b.WriteString(e.CreatedAt)
-			b.WriteString("][")
-			b.WriteString(e.Username)
-			b.WriteString("] ")
-			b.WriteString(strings.ReplaceAll(e.Content, "\n", "\\n"))
-			b.WriteString("\n")
-		}
-		if _, err := tx.Exec(`INSERT INTO entries(user_id, entry_type, content, created_at) VALUES(NULL, 'daily_compact', ?, ?)`, b.String(), nowUTC()); err != nil {
-			return err
+	issueLabels, err := compactIssueLabels(tx, day)
+	if err != nil {
+		return false, err
+	}
+	attachments, err := compactAttachments(tx, day)
+	if err != nil {
+		return false, err
+	}
+
+	bytesBefore, bytesAfter := 0, 0
+	for _, e := range entries {
+		bytesBefore += len(e.Content)
+	}
+
+	if len(entries) == 0 {
+		durationMS := time.Since(lockedAt).Milliseconds()
+		if _, err := tx.Exec(`INSERT INTO compactions(day, ran_at, merged_count, bytes_before, bytes_after, duration_ms, phase) VALUES(?, ?, 0, 0, 0, ?, ?)`, day, nowUTC(), durationMS, compactionDone); err != nil {
+			return false, err
 		}
-		if _, err := tx.Exec(`DELETE FROM entries WHERE date(created_at) = ? AND entry_type = 'normal'`, day); err != nil {
-			return err
+		if err := journalCompaction(tx, day, journalDone, "no entries"); err != nil {
+			return false, err
+		}
+		if err := tx.Commit(); err != nil {
+			return false, err
 		}
+		_ = a.logActorAction(actorScheduler, "daily_compact", fmt.Sprintf("day=%s merged=0 bytes_before=0 bytes_after=0 duration_ms=%d", day, durationMS))
+		return true, nil
 	}
 
-	if _, err := tx.Exec(`INSERT INTO compactions(day, ran_at) VALUES(?, ?)`, day, nowUTC()); err != nil {
-		return err
+	sources := make([]compactSource, 0, len(entries))
+	userIDs := make([]int64, 0, len(entries))
+	for _, e := range entries {
+		userIDs = append(userIDs, e.UserID)
+		sources = append(sources, compactSource{
+			EntryID:     e.ID,
+			User:        e.Username,
+			CreatedAt:   e.CreatedAt,
+			Content:     e.Content,
+			Source:      e.Source,
+			Issues:      issueLabels[e.ID],
+			Attachments: attachments[e.ID],
+		})
 	}
-	if _, err := tx.Exec(`INSERT INTO action_logs(actor_type, actor_username, action, metadata, created_at) VALUES('system', 'scheduler', 'daily_compact', ?, ?)`, fmt.Sprintf("day=%s merged=%d", day, len(entries)), nowUTC()); err != nil {
-		return err
+	grouping := a.settings().CompactGrouping
+	var compactID int64
+	groups := groupSources(grouping, sources, userIDs)
+	for i, g := range groups {
+		data, err := json.Marshal(g.sources)
+		if err != nil {
+			return false, err
+		}
+		text := renderCompact(day, g.sources)
+		if i == 0 {
+			// The meeting-load trailer covers the whole day; it goes on the
+			// first compact only.
+			text += meetings
+		}
+		bytesAfter += len(text)
+		res, err := tx.Exec(`INSERT INTO entries(user_id, entry_type, content, compact_data, created_at) VALUES(?, 'daily_compact', ?, ?, ?)`, actorSystem.ID, text, string(data), nowUTC())
+		if err != nil {
+			return false, err
+		}
+		id, _ := res.LastInsertId()
+		if i == 0 {
+			compactID = id
+		}
+		if grouping == compactGroupingPerUser {
+			if _, err := tx.Exec(`INSERT INTO compact_parts(compact_id, day, user_id) VALUES(?, ?, ?)`, id, day, g.userID); err != nil {
+				return false, err
+			}
+		}
 	}
 
-	if err := tx.Commit(); err != nil {
-		return err
+	durationMS := time.Since(lockedAt).Milliseconds()
+	sum := sourceChecksum(sources)
+	if _, err := tx.Exec(`
+INSERT INTO compactions(day, ran_at, merged_count, bytes_before, bytes_after, duration_ms, phase, compact_id, source_sha256)
+VALUES(?, ?, ?, ?, ?, ?, ?, ?, ?)`, day, nowUTC(), len(entries), bytesBefore, bytesAfter, durationMS, compactionProduced, compactID, sum); err != nil {
+		return false, err
 	}
-	a.logger.Printf("event=daily_compact day=%s merged=%d", day, len(entries))
-	return nil
+	if err := journalCompaction(tx, day, journalProduced, fmt.Sprintf("compact_id=%d sources=%d sha256=%s compacts=%d", compactID, len(entries), sum, len(groups))); err != nil {
+		return false, err
+	}
+	return false, tx.Commit()
 }
 
-func (a *App) logAction(actorType, actorUsername, action, metadata string) error {
-	if actorType == "" {
-		actorType = "unknown"
+// compactSource is one source entry of a daily compact. The compact stores the
+// list as JSON in entries.compact_data next to the rendered text, so
+// re-rendering, search and exports never have to parse the text format.
+type compactSource struct {
+	EntryID   int64    `json:"entry_id"`
+	User      string   `json:"user"`
+	CreatedAt string   `json:"created_at"`
+	Content   string   `json:"content"`
+	Source    string   `json:"source,omitempty"`
+	Issues    []string `json:"issues,omitempty"`
+	// Attachments are the SHA-256s of the entry's attachments, which move to
+	// the compact; uncompactDay hands them back.
+	Attachments []string `json:"attachments,omitempty"`
+}
+
+// renderCompact renders the text body of a daily compact: a header and one
+// "[ts][user] content [ISSUE] (via source)" line per source, newlines escaped
+// as \n. Web entries, the default, carry no "(via ...)".
+func renderCompact(day string, sources []compactSource) string {
+	var b strings.Builder
+	b.WriteString(compactHeaderPrefix)
+	b.WriteString(day)
+	b.WriteString("\n\n")
+	for _, e := range sources {
+		b.WriteString("[")
+		b.WriteString(e.CreatedAt)
+		b.WriteString("][")
+		b.WriteString(e.User)
+		b.WriteString("] ")
+		b.WriteString(strings.ReplaceAll(e.Content, "\n", "\\n"))
+		for _, label := range e.Issues {
+			b.WriteString(" [")
+			b.WriteString(label)
+			b.WriteString("]")
+		}
+		if e.Source != "" && e.Source != sourceWeb {
+			b.WriteString(" (via ")
+			b.WriteString(e.Source)
+			b.WriteString(")")
+		}
+		b.WriteString("\n")
 	}
-	if actorUsername == "" {
-		actorUsername = "unknown"
+	return b.String()
+}
+
+// compactAttachments returns the attachment digests of the day's normal
+// entries, keyed by entry id, read inside the compaction transaction.
+func compactAttachments(tx *sql.Tx, day string) (map[int64][]string, error) {
+	rows, err := tx.Query(`
+SELECT a.entry_id, a.sha256
+FROM entry_attachments a
+JOIN entries e ON e.id = a.entry_id
+WHERE date(e.created_at) = ? AND e.entry_type = 'normal' AND e.deleted_at IS NULL
+ORDER BY a.entry_id, a.created_at`, day)
+	if err != nil {
+		return nil, err
 	}
-	if action == "" {
-		action = "unknown"
+	defer rows.Close()
+	out := map[int64][]string{}
+	for rows.Next() {
+		var id int64
+		var sha string
+		if err := rows.Scan(&id, &sha); err != nil {
+			return nil, err
+		}
+		out[id] = append(out[id], sha)
 	}
-	if metadata == "" {
-		metadata = "-"
+	return out, rows.Err()
+}
+
+// compactIssueLabels returns "KEY: title (status)" labels for the day's normal
+// entries, keyed by entry id, read inside the compaction transaction.
+func compactIssueLabels(tx *sql.Tx, day string) (map[int64][]string, error) {
+	rows, err := tx.Query(`
+SELECT r.entry_id, i.issue_key, i.title, i.status
+FROM issue_refs r
+JOIN issues i ON i.issue_key = r.issue_key
+JOIN entries e ON e.id = r.entry_id
+WHERE date(e.created_at) = ?
+  AND e.entry_type = 'normal'
+  AND e.deleted_at IS NULL
+ORDER BY r.entry_id, i.issue_key`, day)
+	if err != nil {
+		return nil, err
 	}
-	_, err := a.db.Exec(`INSERT INTO action_logs(actor_type, actor_username, action, metadata, created_at) VALUES(?, ?, ?, ?, ?)`, actorType, actorUsername, action, metadata, nowUTC())
+	defer rows.Close()
+	out := map[int64][]string{}
+	for rows.Next() {
+		var id int64
+		var is Issue
+		if err := rows.Scan(&id, &is.Key, &is.Title, &is.Status); err != nil {
+			return nil, err
+		}
+		out[id] = append(out[id], is.Label())
+	}
+	return out, rows.Err()
+}
+
+// insertEntry stores a normal entry and returns its id. category may be "";
+// source is one of entrySources.
+func (a *App) insertEntry(userID int64, content, category, source, createdAt string) (int64, error) {
+	res, err := a.db.Exec(`INSERT INTO entries(user_id, entry_type, content, category, source, created_at) VALUES(?, 'normal', ?, ?, ?, ?)`, userID, content, category, source, createdAt)
 	if err != nil {
-		a.logger.Printf("event=action_log_insert_failed actor_type=%s actor_username=%s action=%s err=%v", actorType, actorUsername, action, err)
+		return 0, err
+	}
+	return res.LastInsertId()
+}
+
+func (a *App) logAction(actorType, actorUsername, action, metadata string) error {
+	return a.writeActionLog(actorType, actorUsername, "", "", action, metadata)
+}
+
+// logUserAction records an API action by u, keeping the admin identity when
+// the request is impersonated.
+func (a *App) logUserAction(u AuthedUser, action, metadata string) error {
+	return a.recordAction(auditRecord{ActorType: "api_user", ActorUsername: u.Username, Impersonator: u.ImpersonatedBy, Action: action, Metadata: metadata, ClientIP: u.ClientIP, RequestID: u.RequestID, Route: u.Route})
+}
+
+func (a *App) writeActionLog(actorType, actorUsername, impersonator, clientIP, action, metadata string) error {
+	return a.recordAction(auditRecord{ActorType: actorType, ActorUsername: actorUsername, Impersonator: impersonator, Action: action, Metadata: metadata, ClientIP: clientIP})
+}
+
+// recordAction appends rec to action_logs, stamped now, and logs it. The
+// request id and route are set for actions taken in an API request.
+func (a *App) recordAction(rec auditRecord) error {
+	if rec.ActorType == "" {
+		rec.ActorType = "unknown"
+	}
+	if rec.ActorUsername == "" {
+		rec.ActorUsername = "unknown"
+	}
+	if rec.Action == "" {
+		rec.Action = "unknown"
+	}
+	if rec.Metadata == "" {
+		rec.Metadata = "-"
+	}
+	rec.CreatedAt = nowUTC()
+	if err := a.appendAuditRow(rec); err != nil {
+		a.logger.Printf("event=action_log_insert_failed actor_type=%s actor_username=%s action=%s err=%v", rec.ActorType, rec.ActorUsername, rec.Action, err)
 		return err
 	}
-	a.logger.Printf("event=action actor_type=%s actor_username=%s action=%s metadata=%q", actorType, actorUsername, action, metadata)
+	line := fmt.Sprintf("event=action actor_type=%s actor_username=%s", rec.ActorType, rec.ActorUsername)
+	if rec.Impersonator != "" {
+		line += " impersonator=" + rec.Impersonator
+	}
+	line += fmt.Sprintf(" action=%s metadata=%q", rec.Action, rec.Metadata)
+	if rec.RequestID != "" {
+		line += " request_id=" + rec.RequestID
+	}
+	a.logger.Print(line)
 	return nil
 }
 
-func hashToken(token string) string {
-	sum := sha256.Sum256([]byte(token))
-	return hex.EncodeToString(sum[:])
+// envOr returns the environment variable key, or def when it is unset or
+// empty. Flags given on the command line still win.
+func envOr(key, def string) string {
+	if v := strings.TrimSpace(os.Getenv(key)); v != "" {
+		return v
+	}
+	return def
+}
+
+// splitList parses a comma-separated flag value, dropping empty items.
+func splitList(v string) []string {
+	var out []string
+	for _, part := range strings.Split(v, ",") {
+		if part = strings.TrimSpace(part); part != "" {
+			out = append(out, part)
+		}
+	}
+	return out
 }
 
 func nowUTC() string {
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+const defaultMaintenanceMessage = "Dev log is in maintenance mode; writes are temporarily disabled."
+
+// maintenanceState is the soft maintenance switch. Unlike the compaction write
+// lock it is operator-controlled, persists across restarts and rejects writes
+// (503) instead of queueing them.
+type maintenanceState struct {
+	Enabled   bool   `json:"enabled"`
+	Message   string `json:"message"`
+	UpdatedBy string `json:"updated_by,omitempty"`
+	UpdatedAt string `json:"updated_at,omitempty"`
+}
+
+func (a *App) maintenance() (maintenanceState, error) {
+	var m maintenanceState
+	var enabled int
+	err := a.db.QueryRow(`SELECT enabled, message, updated_by, updated_at FROM maintenance WHERE id = 1`).Scan(&enabled, &m.Message, &m.UpdatedBy, &m.UpdatedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return maintenanceState{}, nil
+	}
+	m.Enabled = enabled == 1
+	return m, err
+}
+
+func (a *App) setMaintenance(enabled bool, message, by string) (maintenanceState, error) {
+	message = strings.TrimSpace(message)
+	if message == "" {
+		message = defaultMaintenanceMessage
+	}
+	m := maintenanceState{Enabled: enabled, Message: message, UpdatedBy: by, UpdatedAt: nowUTC()}
+	_, err := a.db.Exec(`
+INSERT INTO maintenance(id, enabled, message, updated_by, updated_at) VALUES(1, ?, ?, ?, ?)
+ON CONFLICT(id) DO UPDATE SET enabled = excluded.enabled, message = excluded.message, updated_by = excluded.updated_by, updated_at = excluded.updated_at`,
+		boolInt(enabled), m.Message, m.UpdatedBy, m.UpdatedAt)
+	return m, err
+}
+
+func boolInt(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// maintenanceBanner is the message the UI shows, or "" when maintenance is off.
+func (a *App) maintenanceBanner() string {
+	m, err := a.maintenance()
+	if err != nil || !m.Enabled {
+		return ""
+	}
+	return m.Message
+}
+
+// handleAdminMaintenance reads (GET) or switches (PUT) maintenance mode. It is
+// not behind guardWrites so an admin can always turn maintenance off.
+func (a *App) handleAdminMaintenance(w http.ResponseWriter, r *http.Request, u AuthedUser) {
+	switch r.Method {
+	case http.MethodGet:
+		m, err := a.maintenance()
+		if err != nil {
+			jsonErr(w, http.StatusInternalServerError, "failed to read maintenance state")
+			return
+		}
+		jsonOut(w, http.StatusOK, m)
+	case http.MethodPut:
+		var req struct {
+			Enabled bool   `json:"enabled"`
+			Message string `json:"message"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			jsonErr(w, http.StatusBadRequest, "invalid json")
+			return
+		}
+		m, err := a.setMaintenance(req.Enabled, req.Message, u.Username)
+		if err != nil {
+			jsonErr(w, http.StatusInternalServerError, "failed to update maintenance state")
+			return
+		}
+		_ = a.logUserAction(u, "set_maintenance", fmt.Sprintf("enabled=%t message=%q", m.Enabled, m.Message))
+		jsonOut(w, http.StatusOK, m)
+	default:
+		jsonErr(w, http.StatusMethodNotAllowed, "method not allowed")
+	}
+}
+
+func runAdminMaintenance(args []string) error {
+	fs := flag.NewFlagSet("admin maintenance", flag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s admin maintenance [--on [--message <text>] | --off] [options]\n\n", binName())
+		fmt.Fprintln(fs.Output(), "Shows or switches maintenance mode: reads keep working, writes get 503 with")
+		fmt.Fprintln(fs.Output(), "the message and the UI shows it as a banner. Takes effect on the running server.")
+		fmt.Fprintln(fs.Output())
+		fmt.Fprintln(fs.Output(), "Options:")
+		fs.PrintDefaults()
+	}
+	on := fs.Bool("on", false, "enable maintenance mode")
+	off := fs.Bool("off", false, "disable maintenance mode")
+	message := fs.String("message", "", "message shown to clients (default: generic maintenance notice)")
+	dbPath := fs.String("db", defaultDBPath, "sqlite db path")
+	logPath := fs.String("log", defaultLogPath, "log target path ('-' for stdout only)")
+	if err := fs.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return nil
+		}
+		return err
+	}
+	if *on && *off {
+		return errors.New("--on and --off are mutually exclusive")
+	}
+
+	app, closeApp, err := openAdminApp(*dbPath, *logPath)
+	if err != nil {
+		return err
+	}
+	defer closeApp()
+
+	m, err := app.maintenance()
+	if err != nil {
+		return err
+	}
+	if *on || *off {
+		if m, err = app.setMaintenance(*on, *message, "admin_cli"); err != nil {
+			return err
+		}
+		_ = app.logAction("admin_cli", "admin", "set_maintenance", fmt.Sprintf("enabled=%t message=%q", m.Enabled, m.Message))
+	}
+	if !m.Enabled {
+		fmt.Println("maintenance: off")
+		return nil
+	}
+	fmt.Printf("maintenance: on (since %s by %s)\n%s\n", m.UpdatedAt, m.UpdatedBy, m.Message)
+	return nil
+}
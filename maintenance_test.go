@@ -0,0 +1,56 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestAPIMaintenanceMode(t *testing.T) {
+	app := newTestApp(t)
+	h := newTestMux(app)
+	createUser(t, app, "alice", "PUDABCDEF12")
+	createUser(t, app, "root", "PUDROOTROOT")
+	if _, err := app.db.Exec(`UPDATE users SET role = 'admin' WHERE username = 'root'`); err != nil {
+		t.Fatalf("promote root: %v", err)
+	}
+
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, authedReq(t, http.MethodPut, "/api/admin/maintenance", map[string]any{"enabled": true}, "PUDABCDEF12"))
+	if rr.Code != http.StatusForbidden {
+		t.Fatalf("expected 403 for member, got %d", rr.Code)
+	}
+	rr = httptest.NewRecorder()
+	h.ServeHTTP(rr, authedReq(t, http.MethodPut, "/api/admin/maintenance", map[string]any{"enabled": true, "message": "restoring backup until 18:00"}, "PUDROOTROOT"))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected 200 enabling maintenance, got %d body=%s", rr.Code, rr.Body.String())
+	}
+
+	rr = httptest.NewRecorder()
+	h.ServeHTTP(rr, authedReq(t, http.MethodPost, "/api/entries", map[string]string{"content": "hello"}, "PUDABCDEF12"))
+	if rr.Code != http.StatusServiceUnavailable || !strings.Contains(rr.Body.String(), "restoring backup until 18:00") {
+		t.Fatalf("expected 503 with maintenance message, got %d body=%s", rr.Code, rr.Body.String())
+	}
+	rr = httptest.NewRecorder()
+	h.ServeHTTP(rr, authedReq(t, http.MethodGet, "/api/entries", nil, "PUDABCDEF12"))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected reads during maintenance, got %d", rr.Code)
+	}
+	rr = httptest.NewRecorder()
+	app.handleUI(rr, httptest.NewRequest(http.MethodGet, "/", nil))
+	if !strings.Contains(rr.Body.String(), "restoring backup until 18:00") {
+		t.Fatalf("expected UI banner during maintenance")
+	}
+
+	rr = httptest.NewRecorder()
+	h.ServeHTTP(rr, authedReq(t, http.MethodPut, "/api/admin/maintenance", map[string]any{"enabled": false}, "PUDROOTROOT"))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected 200 disabling maintenance, got %d", rr.Code)
+	}
+	rr = httptest.NewRecorder()
+	h.ServeHTTP(rr, authedReq(t, http.MethodPost, "/api/entries", map[string]string{"content": "hello"}, "PUDABCDEF12"))
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("expected 201 after maintenance, got %d", rr.Code)
+	}
+}
@@ -0,0 +1,328 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// meterFlushInterval bounds how long request counters stay in memory only.
+const meterFlushInterval = 10 * time.Second
+
+// usageCounts are the metered totals for one user and UTC day.
+type usageCounts struct {
+	Requests int64 `json:"requests"`
+	BytesIn  int64 `json:"bytes_in"`
+	BytesOut int64 `json:"bytes_out"`
+}
+
+func (c *usageCounts) add(o usageCounts) {
+	c.Requests += o.Requests
+	c.BytesIn += o.BytesIn
+	c.BytesOut += o.BytesOut
+}
+
+type meterKey struct {
+	day    string
+	userID int64
+}
+
+// usageMeter counts authenticated requests and bytes per token owner and UTC
+// day. Counters are buffered in memory and upserted into api_usage by flush,
+// so metering adds no write per request on the single connection. The zero
+// value is ready to use.
+type usageMeter struct {
+	mu      sync.Mutex
+	pending map[meterKey]usageCounts // not yet in api_usage
+	today   map[meterKey]usageCounts // day totals loaded for quota checks, kept current by record
+}
+
+func (m *usageMeter) record(day string, userID int64, c usageCounts) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.pending == nil {
+		m.pending = map[meterKey]usageCounts{}
+	}
+	k := meterKey{day, userID}
+	p := m.pending[k]
+	p.add(c)
+	m.pending[k] = p
+	if t, ok := m.today[k]; ok {
+		t.add(c)
+		m.today[k] = t
+	}
+}
+
+// flush upserts buffered counters into api_usage in one transaction.
+func (m *usageMeter) flush(db *sql.DB) error {
+	m.mu.Lock()
+	pending := m.pending
+	m.pending = nil
+	m.mu.Unlock()
+	if len(pending) == 0 {
+		return nil
+	}
+	err := func() error {
+		tx, err := db.Begin()
+		if err != nil {
+			return err
+		}
+		defer func() { _ = tx.Rollback() }()
+		for k, c := range pending {
+			if _, err := tx.Exec(`
+INSERT INTO api_usage(day, user_id, requests, bytes_in, bytes_out) VALUES(?, ?, ?, ?, ?)
+ON CONFLICT(day, user_id) DO UPDATE SET
+	requests = requests + excluded.requests,
+	bytes_in = bytes_in + excluded.bytes_in,
+	bytes_out = bytes_out + excluded.bytes_out`, k.day, k.userID, c.Requests, c.BytesIn, c.BytesOut); err != nil {
+				return err
+			}
+		}
+		return tx.Commit()
+	}()
+	if err != nil {
+		// Put the counters back so the next flush retries them.
+		m.mu.Lock()
+		if m.pending == nil {
+			m.pending = map[meterKey]usageCounts{}
+		}
+		for k, c := range pending {
+			p := m.pending[k]
+			p.add(c)
+			m.pending[k] = p
+		}
+		m.mu.Unlock()
+	}
+	return err
+}
+
+// dayTotal returns the user's totals for day, loading the flushed part from
+// api_usage on first use and serving later calls from memory.
+func (m *usageMeter) dayTotal(db *sql.DB, day string, userID int64) (usageCounts, error) {
+	k := meterKey{day, userID}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if t, ok := m.today[k]; ok {
+		return t, nil
+	}
+	// The lock is held across the query so a concurrent flush cannot move
+	// counters between api_usage and pending while they are summed.
+	var t usageCounts
+	err := db.QueryRow(`SELECT requests, bytes_in, bytes_out FROM api_usage WHERE day = ? AND user_id = ?`, day, userID).Scan(&t.Requests, &t.BytesIn, &t.BytesOut)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return usageCounts{}, err
+	}
+	t.add(m.pending[k])
+	if m.today == nil {
+		m.today = map[meterKey]usageCounts{}
+	}
+	for old := range m.today {
+		if old.day != day {
+			delete(m.today, old)
+		}
+	}
+	m.today[k] = t
+	return t, nil
+}
+
+// meterLoop flushes counters periodically; serve flushes once more after
+// the HTTP servers have shut down.
+func (a *App) meterLoop(ctx context.Context) {
+	ticker := time.NewTicker(meterFlushInterval)
+	defer ticker.Stop()
+	a.health.register(subsystemMeter, meterFlushInterval, time.Now())
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			err := a.meter.flush(a.db)
+			if err != nil {
+				a.logger.Printf("event=meter_flush_failed err=%v", err)
+			}
+			a.health.record(subsystemMeter, time.Now(), err)
+		}
+	}
+}
+
+// quotaExceeded reports whether the token owner has used up a daily quota.
+// A zero quota is unlimited.
+func (a *App) quotaExceeded(userID int64, now time.Time) (bool, error) {
+	if a.quotaRequests <= 0 && a.quotaBytes <= 0 {
+		return false, nil
+	}
+	t, err := a.meter.dayTotal(a.db, now.UTC().Format("2006-01-02"), userID)
+	if err != nil {
+		return false, err
+	}
+	return (a.quotaRequests > 0 && t.Requests >= a.quotaRequests) ||
+		(a.quotaBytes > 0 && t.BytesIn+t.BytesOut >= a.quotaBytes), nil
+}
+
+// writeQuotaExceeded answers 429 with Retry-After set to the next UTC midnight.
+func writeQuotaExceeded(w http.ResponseWriter, now time.Time) {
+	now = now.UTC()
+	reset := time.Date(now.Year(), now.Month(), now.Day()+1, 0, 0, 0, 0, time.UTC)
+	w.Header().Set("Retry-After", strconv.Itoa(int(reset.Sub(now).Seconds())+1))
+	jsonErr(w, http.StatusTooManyRequests, "daily quota exceeded")
+}
+
+// meteredWriter counts response body bytes as written to the wire, i.e.
+// after compression.
+type meteredWriter struct {
+	http.ResponseWriter
+	n int64
+}
+
+func (w *meteredWriter) Write(b []byte) (int, error) {
+	n, err := w.ResponseWriter.Write(b)
+	w.n += int64(n)
+	return n, err
+}
+
+func (w *meteredWriter) Unwrap() http.ResponseWriter { return w.ResponseWriter }
+
+// meteredBody counts request body bytes read by the handler.
+type meteredBody struct {
+	io.ReadCloser
+	n int64
+}
+
+func (b *meteredBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	b.n += int64(n)
+	return n, err
+}
+
+// handleMyUsage serves GET /api/me/usage?from&to: the caller's metered
+// requests and bytes per UTC day plus the configured quotas.
+func (a *App) handleMyUsage(w http.ResponseWriter, r *http.Request, u AuthedUser) {
+	if r.Method != http.MethodGet {
+		jsonErr(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	now := time.Now().UTC()
+	from, to, err := dayRangeParams(r, now.Format("2006-01-02"))
+	if err != nil {
+		jsonErr(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if err := a.meter.flush(a.db); err != nil {
+		jsonErr(w, http.StatusInternalServerError, "failed to flush usage")
+		return
+	}
+	rows, err := a.db.Query(`SELECT day, requests, bytes_in, bytes_out FROM api_usage WHERE user_id = ? AND day BETWEEN ? AND ? ORDER BY day ASC`, u.ID, from, to)
+	if err != nil {
+		jsonErr(w, http.StatusInternalServerError, "failed to query usage")
+		return
+	}
+	defer rows.Close()
+	type dayUsage struct {
+		Day string `json:"day"`
+		usageCounts
+	}
+	days := []dayUsage{}
+	var total usageCounts
+	for rows.Next() {
+		var d dayUsage
+		if err := rows.Scan(&d.Day, &d.Requests, &d.BytesIn, &d.BytesOut); err != nil {
+			jsonErr(w, http.StatusInternalServerError, "failed to parse usage")
+			return
+		}
+		total.add(d.usageCounts)
+		days = append(days, d)
+	}
+	_ = rows.Close()
+	today, err := a.meter.dayTotal(a.db, now.Format("2006-01-02"), u.ID)
+	if err != nil {
+		jsonErr(w, http.StatusInternalServerError, "failed to query usage")
+		return
+	}
+	jsonOut(w, http.StatusOK, map[string]any{
+		"from":  from,
+		"to":    to,
+		"days":  days,
+		"total": total,
+		"quota": map[string]any{"requests_per_day": a.quotaRequests, "bytes_per_day": a.quotaBytes, "today": today},
+	})
+}
+
+// handleAdminUsage serves GET /api/admin/usage?from&to: metered usage per
+// user over the range, and global totals per day.
+func (a *App) handleAdminUsage(w http.ResponseWriter, r *http.Request, u AuthedUser) {
+	if r.Method != http.MethodGet {
+		jsonErr(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	from, to, err := dayRangeParams(r, time.Now().UTC().Format("2006-01-02"))
+	if err != nil {
+		jsonErr(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if err := a.meter.flush(a.db); err != nil {
+		jsonErr(w, http.StatusInternalServerError, "failed to flush usage")
+		return
+	}
+	rows, err := a.db.Query(`
+SELECT u.username, SUM(m.requests), SUM(m.bytes_in), SUM(m.bytes_out)
+FROM api_usage m
+JOIN users u ON u.id = m.user_id
+WHERE m.day BETWEEN ? AND ?
+GROUP BY m.user_id
+ORDER BY SUM(m.requests) DESC, u.username ASC`, from, to)
+	if err != nil {
+		jsonErr(w, http.StatusInternalServerError, "failed to query usage")
+		return
+	}
+	type userUsage struct {
+		User string `json:"user"`
+		usageCounts
+	}
+	users := []userUsage{}
+	var total usageCounts
+	for rows.Next() {
+		var uu userUsage
+		if err := rows.Scan(&uu.User, &uu.Requests, &uu.BytesIn, &uu.BytesOut); err != nil {
+			_ = rows.Close()
+			jsonErr(w, http.StatusInternalServerError, "failed to parse usage")
+			return
+		}
+		total.add(uu.usageCounts)
+		users = append(users, uu)
+	}
+	_ = rows.Close()
+
+	rows, err = a.db.Query(`
+SELECT day, SUM(requests), SUM(bytes_in), SUM(bytes_out)
+FROM api_usage
+WHERE day BETWEEN ? AND ?
+GROUP BY day
+ORDER BY day ASC`, from, to)
+	if err != nil {
+		jsonErr(w, http.StatusInternalServerError, "failed to query usage")
+		return
+	}
+	defer rows.Close()
+	type dayUsage struct {
+		Day string `json:"day"`
+		usageCounts
+	}
+	days := []dayUsage{}
+	for rows.Next() {
+		var d dayUsage
+		if err := rows.Scan(&d.Day, &d.Requests, &d.BytesIn, &d.BytesOut); err != nil {
+			jsonErr(w, http.StatusInternalServerError, "failed to parse usage")
+			return
+		}
+		days = append(days, d)
+	}
+	_ = rows.Close()
+	_ = a.logUserAction(u, "get_usage_rollup", fmt.Sprintf("from=%s to=%s", from, to))
+	jsonOut(w, http.StatusOK, map[string]any{"from": from, "to": to, "users": users, "days": days, "total": total})
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestAPIUsageMeteringAndQuota(t *testing.T) {
+	app := newTestApp(t)
+	h := newTestMux(app)
+	createUser(t, app, "alice", "PUDMETERAL1")
+	createUser(t, app, "bob", "PUDMETERBO1")
+	if _, err := app.db.Exec(`UPDATE users SET role = 'admin' WHERE username = 'bob'`); err != nil {
+		t.Fatalf("promote: %v", err)
+	}
+	for i := 0; i < 2; i++ {
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, authedReq(t, http.MethodPost, "/api/entries", map[string]string{"content": "metered"}, "PUDMETERAL1"))
+		if rr.Code != http.StatusCreated {
+			t.Fatalf("create: %d", rr.Code)
+		}
+	}
+
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, authedReq(t, http.MethodGet, "/api/me/usage", nil, "PUDMETERAL1"))
+	var mine struct {
+		Days  []map[string]any `json:"days"`
+		Total usageCounts      `json:"total"`
+	}
+	if err := json.Unmarshal(rr.Body.Bytes(), &mine); err != nil || rr.Code != http.StatusOK {
+		t.Fatalf("me/usage: %d %s", rr.Code, rr.Body.String())
+	}
+	if mine.Total.Requests != 2 || mine.Total.BytesIn == 0 || mine.Total.BytesOut == 0 || len(mine.Days) != 1 {
+		t.Fatalf("unexpected own usage: %+v", mine)
+	}
+
+	rr = httptest.NewRecorder()
+	h.ServeHTTP(rr, authedReq(t, http.MethodGet, "/api/admin/usage", nil, "PUDMETERAL1"))
+	if rr.Code != http.StatusForbidden {
+		t.Fatalf("members must not read the rollup, got %d", rr.Code)
+	}
+	rr = httptest.NewRecorder()
+	h.ServeHTTP(rr, authedReq(t, http.MethodGet, "/api/admin/usage", nil, "PUDMETERBO1"))
+	var rollup struct {
+		Users []struct {
+			User     string `json:"user"`
+			Requests int64  `json:"requests"`
+		} `json:"users"`
+		Total usageCounts `json:"total"`
+	}
+	if err := json.Unmarshal(rr.Body.Bytes(), &rollup); err != nil || rr.Code != http.StatusOK {
+		t.Fatalf("admin/usage: %d %s", rr.Code, rr.Body.String())
+	}
+	// alice: 2 creates + me/usage + the forbidden rollup; bob: nothing flushed yet.
+	if len(rollup.Users) != 1 || rollup.Users[0].User != "alice" || rollup.Users[0].Requests != 4 {
+		t.Fatalf("unexpected rollup: %+v", rollup)
+	}
+
+	app.quotaRequests = 5
+	codes := []int{}
+	for i := 0; i < 2; i++ {
+		rr = httptest.NewRecorder()
+		h.ServeHTTP(rr, authedReq(t, http.MethodGet, "/api/me", nil, "PUDMETERAL1"))
+		codes = append(codes, rr.Code)
+	}
+	if codes[0] != http.StatusOK || codes[1] != http.StatusTooManyRequests || rr.Header().Get("Retry-After") == "" {
+		t.Fatalf("expected the sixth request to hit the quota, got %v", codes)
+	}
+	rr = httptest.NewRecorder()
+	h.ServeHTTP(rr, authedReq(t, http.MethodGet, "/api/me", nil, "PUDMETERBO1"))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("quota is per user, bob got %d", rr.Code)
+	}
+}
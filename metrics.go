@@ -0,0 +1,111 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"time"
+)
+
+// writeMetric emits one sample in the Prometheus text exposition format.
+func writeMetric(w io.Writer, name, help, typ string, value float64) {
+	fmt.Fprintf(w, "# HELP %s %s\n", name, help)
+	fmt.Fprintf(w, "# TYPE %s %s\n", name, typ)
+	fmt.Fprintf(w, "%s %g\n", name, value)
+}
+
+// handleMetrics serves Prometheus metrics. Values are read from the database
+// at scrape time, so they survive restarts.
+func (a *App) handleMetrics(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		jsonErr(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	var total, mergedSum, durationSum int64
+	if err := a.db.QueryRow(`SELECT COUNT(*), COALESCE(SUM(merged_count), 0), COALESCE(SUM(duration_ms), 0) FROM compactions WHERE phase = 'done'`).Scan(&total, &mergedSum, &durationSum); err != nil {
+		http.Error(w, "failed to query metrics", http.StatusInternalServerError)
+		return
+	}
+	var lastMerged, lastBefore, lastAfter, lastDuration int64
+	err := a.db.QueryRow(`SELECT merged_count, bytes_before, bytes_after, duration_ms FROM compactions WHERE phase = 'done' ORDER BY day DESC LIMIT 1`).Scan(&lastMerged, &lastBefore, &lastAfter, &lastDuration)
+	if err != nil && total > 0 {
+		http.Error(w, "failed to query metrics", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	writeMetric(w, "devlog_compactions_total", "Completed daily compactions.", "counter", float64(total))
+	writeMetric(w, "devlog_compaction_merged_entries_total", "Entries merged by all compactions.", "counter", float64(mergedSum))
+	writeMetric(w, "devlog_compaction_duration_seconds_total", "Total write-lock time spent compacting.", "counter", float64(durationSum)/1000)
+	writeMetric(w, "devlog_compaction_last_duration_seconds", "Write-lock duration of the most recent compaction.", "gauge", float64(lastDuration)/1000)
+	writeMetric(w, "devlog_compaction_last_merged_entries", "Entries merged by the most recent compaction.", "gauge", float64(lastMerged))
+	writeMetric(w, "devlog_compaction_last_bytes_before", "Source content bytes of the most recent compaction.", "gauge", float64(lastBefore))
+	writeMetric(w, "devlog_compaction_last_bytes_after", "Compact content bytes of the most recent compaction.", "gauge", float64(lastAfter))
+	writeMetric(w, "devlog_write_locked", "1 while compaction holds the write lock.", "gauge", boolFloat(a.writeLocked.Load()))
+	a.writeLimiterMetrics(w)
+	writeLabeledMetric(w, "devlog_watchdog_restarts_total", "Background loops restarted by the watchdog since startup.", "counter", "subsystem", a.health.restartCounts())
+	dbContention.writeMetrics(w, a.db)
+	a.dbQuotaMetrics(w)
+	forwarded, failed := a.auditForwardMetrics()
+	writeLabeledMetric(w, "devlog_audit_forwarded_total", "Audit rows forwarded to external sinks.", "counter", "sink", forwarded)
+	writeLabeledMetric(w, "devlog_audit_forward_errors_total", "Failed audit forwarding attempts.", "counter", "sink", failed)
+	if ix := a.esIndexer; ix != nil {
+		writeMetric(w, "devlog_search_indexed_total", "Search index bulk items accepted since startup.", "counter", float64(ix.indexed.Load()))
+		writeMetric(w, "devlog_search_index_errors_total", "Search index bulk items rejected since startup.", "counter", float64(ix.failed.Load()))
+		if depth, err := a.searchOutboxDepth(outboxElasticsearch); err == nil {
+			writeMetric(w, "devlog_search_outbox_depth", "Entry changes queued for the search index.", "gauge", float64(depth))
+		}
+	}
+	if b := a.bleve; b != nil {
+		if depth, err := a.searchOutboxDepth(outboxBleve); err == nil {
+			writeMetric(w, "devlog_bleve_outbox_depth", "Entry changes queued for the Bleve index.", "gauge", float64(depth))
+		}
+		if n, err := b.index.DocCount(); err == nil {
+			writeMetric(w, "devlog_bleve_documents", "Documents in the Bleve index.", "gauge", float64(n))
+		}
+	}
+}
+
+// writeLabeledMetric emits one sample per label value, sorted by label.
+func writeLabeledMetric(w io.Writer, name, help, typ, label string, values map[string]float64) {
+	if len(values) == 0 {
+		return
+	}
+	fmt.Fprintf(w, "# HELP %s %s\n", name, help)
+	fmt.Fprintf(w, "# TYPE %s %s\n", name, typ)
+	keys := make([]string, 0, len(values))
+	for k := range values {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		fmt.Fprintf(w, "%s{%s=%q} %g\n", name, label, k, values[k])
+	}
+}
+
+// writeLimiterMetrics reports in-memory write limiter state; these counters
+// reset on restart.
+func (a *App) writeLimiterMetrics(w io.Writer) {
+	inflight, waiting, admitted, waitSeconds := map[string]float64{}, map[string]float64{}, map[string]float64{}, map[string]float64{}
+	rejected := map[string]float64{}
+	for _, l := range a.writeLimiters() {
+		inflight[l.name] = float64(len(l.slots))
+		waiting[l.name] = float64(l.waiting.Load())
+		admitted[l.name] = float64(l.admitted.Load())
+		waitSeconds[l.name] = time.Duration(l.waitNanoTotal.Load()).Seconds()
+		rejected[l.name] = float64(l.rejectedFull.Load() + l.rejectedWait.Load())
+	}
+	writeLabeledMetric(w, "devlog_write_inflight", "Write requests currently holding a slot.", "gauge", "limiter", inflight)
+	writeLabeledMetric(w, "devlog_write_waiting", "Write requests queued for a slot.", "gauge", "limiter", waiting)
+	writeLabeledMetric(w, "devlog_write_admitted_total", "Write requests admitted.", "counter", "limiter", admitted)
+	writeLabeledMetric(w, "devlog_write_rejected_total", "Write requests rejected with 503 (queue full or wait timeout).", "counter", "limiter", rejected)
+	writeLabeledMetric(w, "devlog_write_wait_seconds_total", "Time write requests spent queued for a slot.", "counter", "limiter", waitSeconds)
+}
+
+func boolFloat(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestAPIAdminCompactionMetrics(t *testing.T) {
+	app := newTestApp(t)
+	h := newTestMux(app)
+	createUser(t, app, "root", "PUDADMIN234")
+	createUser(t, app, "jo", "PUDMEMBER23")
+	if _, err := app.db.Exec(`UPDATE users SET role = 'admin' WHERE username = 'root'`); err != nil {
+		t.Fatalf("promote admin: %v", err)
+	}
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, authedReq(t, http.MethodPost, "/api/entries", map[string]string{"content": "twelve bytes"}, "PUDMEMBER23"))
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("expected 201, got %d", rr.Code)
+	}
+	day := time.Now().UTC().Format("2006-01-02")
+	if err := app.compactDay(day); err != nil {
+		t.Fatalf("compactDay: %v", err)
+	}
+
+	rr = httptest.NewRecorder()
+	h.ServeHTTP(rr, authedReq(t, http.MethodGet, "/api/admin/compactions", nil, "PUDMEMBER23"))
+	if rr.Code != http.StatusForbidden {
+		t.Fatalf("expected 403 for member, got %d", rr.Code)
+	}
+
+	rr = httptest.NewRecorder()
+	h.ServeHTTP(rr, authedReq(t, http.MethodGet, "/api/admin/compactions", nil, "PUDADMIN234"))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d body=%s", rr.Code, rr.Body.String())
+	}
+	var got struct {
+		Compactions []compactionRow `json:"compactions"`
+	}
+	if err := json.Unmarshal(rr.Body.Bytes(), &got); err != nil {
+		t.Fatalf("unmarshal compactions: %v", err)
+	}
+	if len(got.Compactions) != 1 || got.Compactions[0].MergedCount != 1 || got.Compactions[0].BytesBefore != 12 || got.Compactions[0].BytesAfter == 0 {
+		t.Fatalf("unexpected compactions: %+v", got.Compactions)
+	}
+
+	rr = httptest.NewRecorder()
+	h.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	if !bytes.Contains(rr.Body.Bytes(), []byte("devlog_compactions_total 1\n")) {
+		t.Fatalf("metrics missing compaction counter:\n%s", rr.Body.String())
+	}
+}
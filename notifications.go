@@ -0,0 +1,157 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// notificationEvents are the per-user event types a user can route: mentions
+// of the user, and under per_user compact grouping the daily_compact of the
+// user's own entries. Team-wide events carry no Recipient and are not routed.
+var notificationEvents = []string{"mention", "daily_compact"}
+
+// notificationChannels are the integration names a preference can target.
+var notificationChannels = []string{"webhook", "slack"}
+
+var mentionRe = regexp.MustCompile(`(?:^|[^\w@])@([A-Za-z0-9_.-]+)`)
+
+func containsString(list []string, v string) bool {
+	for _, s := range list {
+		if s == v {
+			return true
+		}
+	}
+	return false
+}
+
+// notificationAllowed is the dispatcher routing hook: an event addressed to a
+// user reaches a channel unless the user disabled that combination.
+func (a *App) notificationAllowed(username, eventType, channel string) bool {
+	var enabled bool
+	err := a.db.QueryRow(`
+SELECT p.enabled
+FROM notification_prefs p
+JOIN users u ON u.id = p.user_id
+WHERE u.username = ? AND p.event_type = ? AND p.channel = ?`, username, eventType, channel).Scan(&enabled)
+	if err != nil {
+		return true
+	}
+	return enabled
+}
+
+// userPreferences returns event type -> enabled channels, applying the
+// enabled-by-default rule for combinations without a stored row.
+func (a *App) userPreferences(userID int64) (map[string][]string, error) {
+	disabled := map[string]bool{}
+	rows, err := a.db.Query(`SELECT event_type, channel FROM notification_prefs WHERE user_id = ? AND enabled = 0`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var ev, ch string
+		if err := rows.Scan(&ev, &ch); err != nil {
+			return nil, err
+		}
+		disabled[ev+"/"+ch] = true
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	out := map[string][]string{}
+	for _, ev := range notificationEvents {
+		out[ev] = []string{}
+		for _, ch := range notificationChannels {
+			if !disabled[ev+"/"+ch] {
+				out[ev] = append(out[ev], ch)
+			}
+		}
+	}
+	return out, nil
+}
+
+func (a *App) handlePreferences(w http.ResponseWriter, r *http.Request, u AuthedUser) {
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPut:
+		var req map[string][]string
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			jsonErr(w, http.StatusBadRequest, "invalid json")
+			return
+		}
+		for ev, chans := range req {
+			if !containsString(notificationEvents, ev) {
+				jsonErr(w, http.StatusBadRequest, "unknown event: "+ev)
+				return
+			}
+			for _, ch := range chans {
+				if !containsString(notificationChannels, ch) {
+					jsonErr(w, http.StatusBadRequest, "unknown channel: "+ch)
+					return
+				}
+			}
+		}
+		tx, err := a.db.Begin()
+		if err != nil {
+			jsonErr(w, http.StatusInternalServerError, "failed to store preferences")
+			return
+		}
+		defer func() { _ = tx.Rollback() }()
+		events := make([]string, 0, len(req))
+		for ev, chans := range req {
+			events = append(events, ev)
+			for _, ch := range notificationChannels {
+				if _, err := tx.Exec(`
+INSERT INTO notification_prefs(user_id, event_type, channel, enabled) VALUES(?, ?, ?, ?)
+ON CONFLICT(user_id, event_type, channel) DO UPDATE SET enabled = excluded.enabled`, u.ID, ev, ch, containsString(chans, ch)); err != nil {
+					jsonErr(w, http.StatusInternalServerError, "failed to store preferences")
+					return
+				}
+			}
+		}
+		if err := tx.Commit(); err != nil {
+			jsonErr(w, http.StatusInternalServerError, "failed to store preferences")
+			return
+		}
+		sort.Strings(events)
+		_ = a.logUserAction(u, "update_preferences", "events="+strings.Join(events, ","))
+	default:
+		jsonErr(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	prefs, err := a.userPreferences(u.ID)
+	if err != nil {
+		jsonErr(w, http.StatusInternalServerError, "failed to query preferences")
+		return
+	}
+	jsonOut(w, http.StatusOK, prefs)
+}
+
+// notifyMentions dispatches a mention event to every existing user
+// referenced as @username in content, except the author.
+func (a *App) notifyMentions(author AuthedUser, entryID int64, content string) {
+	seen := map[string]bool{}
+	for _, m := range mentionRe.FindAllStringSubmatch(content, -1) {
+		name := m[1]
+		if seen[name] || name == author.Username {
+			continue
+		}
+		seen[name] = true
+		var n int
+		if err := a.db.QueryRow(`SELECT COUNT(*) FROM users WHERE username = ? AND kind = 'human'`, name).Scan(&n); err != nil || n == 0 {
+			continue
+		}
+		a.dispatcher.Dispatch(IntegrationEvent{
+			Type:      "mention",
+			User:      author.Username,
+			Recipient: name,
+			EntryID:   entryID,
+			Message:   fmt.Sprintf("%s mentioned @%s", author.Username, name),
+			Data:      map[string]any{"content": content},
+		})
+	}
+}
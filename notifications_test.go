@@ -0,0 +1,161 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// recordingIntegration collects delivered events.
+type recordingIntegration struct {
+	name string
+	mu   sync.Mutex
+	got  []IntegrationEvent
+}
+
+func (r *recordingIntegration) Name() string { return r.name }
+
+func (r *recordingIntegration) Send(_ context.Context, ev IntegrationEvent) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.got = append(r.got, ev)
+	return nil
+}
+
+func (r *recordingIntegration) events() []IntegrationEvent {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]IntegrationEvent(nil), r.got...)
+}
+
+func TestPreferencesValidation(t *testing.T) {
+	app := newTestApp(t)
+	h := newTestMux(app)
+	createUser(t, app, "hank", "PUDPREFVAL1")
+	for _, tc := range []struct {
+		name string
+		body any
+		want int
+	}{
+		{"known event", map[string][]string{"daily_compact": {"slack"}}, http.StatusOK},
+		{"unknown channel", map[string][]string{"mention": {"carrier-pigeon"}}, http.StatusBadRequest},
+		// Events without a per-user producer are not routable.
+		{"comment", map[string][]string{"comment": {}}, http.StatusBadRequest},
+		{"reminder", map[string][]string{"reminder": {}}, http.StatusBadRequest},
+		{"not a map", []string{"mention"}, http.StatusBadRequest},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			rr := httptest.NewRecorder()
+			h.ServeHTTP(rr, authedReq(t, http.MethodPut, "/api/me/preferences", tc.body, "PUDPREFVAL1"))
+			if rr.Code != tc.want {
+				t.Fatalf("status = %d, want %d: %s", rr.Code, tc.want, rr.Body.String())
+			}
+		})
+	}
+
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, authedReq(t, http.MethodGet, "/api/me/preferences", nil, "PUDPREFVAL1"))
+	var got map[string][]string
+	if err := json.Unmarshal(rr.Body.Bytes(), &got); err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != len(notificationEvents) || strings.Join(got["daily_compact"], ",") != "slack" || len(got["mention"]) != len(notificationChannels) {
+		t.Fatalf("preferences = %+v", got)
+	}
+}
+
+func TestPerUserCompactRoutedToAuthor(t *testing.T) {
+	app := newTestApp(t)
+	webhook, slack := &recordingIntegration{name: "webhook"}, &recordingIntegration{name: "slack"}
+	app.dispatcher = NewIntegrationDispatcher(app.logger, webhook, slack)
+	app.dispatcher.SetRouter(app.notificationAllowed)
+	h := newTestMux(app)
+	createUser(t, app, "alice", "PUDROUTEAL1")
+	createUser(t, app, "bob", "PUDROUTEBO1")
+	grouping := compactGroupingPerUser
+	if _, _, err := app.updateSettings(settingsPatch{CompactGrouping: &grouping}, "admin"); err != nil {
+		t.Fatalf("updateSettings: %v", err)
+	}
+	// bob keeps his compact out of the shared webhook.
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, authedReq(t, http.MethodPut, "/api/me/preferences", map[string][]string{"daily_compact": {"slack"}}, "PUDROUTEBO1"))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("preferences: %d %s", rr.Code, rr.Body.String())
+	}
+	for token, content := range map[string]string{"PUDROUTEAL1": "fixed the parser", "PUDROUTEBO1": "reviewed the parser"} {
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, authedReq(t, http.MethodPost, "/api/entries", map[string]string{"content": content}, token))
+		if rr.Code != http.StatusCreated {
+			t.Fatalf("post: %d %s", rr.Code, rr.Body.String())
+		}
+	}
+	if err := app.compactDay(time.Now().UTC().Format("2006-01-02")); err != nil {
+		t.Fatalf("compactDay: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go app.dispatcher.Run(ctx)
+	deadline := time.Now().Add(5 * time.Second)
+	for len(slack.events()) < 2 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	recipients := func(in *recordingIntegration) map[string]bool {
+		out := map[string]bool{}
+		for _, ev := range in.events() {
+			if ev.Type == "daily_compact" {
+				out[ev.Recipient] = true
+			}
+		}
+		return out
+	}
+	if got := recipients(slack); len(got) != 2 || !got["alice"] || !got["bob"] {
+		t.Fatalf("slack compacts for %v, want alice and bob", got)
+	}
+	if got := recipients(webhook); len(got) != 1 || !got["alice"] {
+		t.Fatalf("webhook compacts for %v, want only alice", got)
+	}
+}
+
+func TestAPIPreferencesRouteNotifications(t *testing.T) {
+	app := newTestApp(t)
+	h := newTestMux(app)
+	token := "PUDPREFS234"
+	createUser(t, app, "hank", token)
+
+	if !app.notificationAllowed("hank", "mention", "webhook") {
+		t.Fatalf("expected notifications enabled by default")
+	}
+
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, authedReq(t, http.MethodPut, "/api/me/preferences", map[string][]string{
+		"mention": {},
+	}, token))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d body=%s", rr.Code, rr.Body.String())
+	}
+	var got map[string][]string
+	if err := json.Unmarshal(rr.Body.Bytes(), &got); err != nil {
+		t.Fatalf("unmarshal preferences: %v", err)
+	}
+	if len(got["mention"]) != 0 || len(got["daily_compact"]) != len(notificationChannels) {
+		t.Fatalf("unexpected preferences: %+v", got)
+	}
+	if app.notificationAllowed("hank", "mention", "webhook") {
+		t.Fatalf("expected mention via webhook to be disabled")
+	}
+
+	rr = httptest.NewRecorder()
+	h.ServeHTTP(rr, authedReq(t, http.MethodPut, "/api/me/preferences", map[string][]string{
+		"mention": {"carrier-pigeon"},
+	}, token))
+	if rr.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400 for unknown channel, got %d", rr.Code)
+	}
+}
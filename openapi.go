@@ -0,0 +1,478 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// OpenAPI: GET /api/openapi.json serves an OpenAPI 3.0 description of the
+// /api/v1 surface for client generators. The operations are declared in
+// apiOperations below, next to the mux in main.go; request and response
+// schemas are derived from the Go types the handlers encode, so a renamed
+// json tag shows up in the document without a second edit. Bodies that
+// handlers build as ad hoc maps are described by the *Doc types here.
+// TestOpenAPISpec fails when a route is registered without an operation.
+
+// Authentication kinds of an operation; the default is a bearer token.
+const (
+	apiAuthToken  = ""
+	apiAuthNone   = "none"
+	apiAuthSigned = "signed" // request signature or signed link instead of a token
+)
+
+type apiParam struct {
+	Name     string
+	Desc     string
+	Type     string // "string" (default), "integer" or "boolean"
+	Enum     []string
+	Required bool
+}
+
+type apiOperation struct {
+	ID      string // operationId
+	Method  string
+	Path    string // unversioned mux pattern
+	Tag     string
+	Summary string
+	Auth    string
+	// Permission is the policy action checked, "" when any authenticated
+	// user may call it.
+	Permission string
+	Query      []apiParam
+	// Body and Response are zero values of the JSON request and success
+	// body types; nil means none (Body) or a free-form object (Response).
+	Body     any
+	Response any
+	// Status is the success status, 200 when zero; ContentType is set for
+	// non-JSON success bodies.
+	Status      int
+	ContentType string
+}
+
+// Response and request shapes that handlers build inline.
+type (
+	errorDoc struct {
+		Error string `json:"error"`
+	}
+	statusDoc struct {
+		Status string `json:"status"`
+	}
+	entryCreateDoc struct {
+		Content  string `json:"content"`
+		Category string `json:"category,omitempty"`
+		Source   string `json:"source,omitempty"`
+	}
+	entryCreatedDoc struct {
+		ID              int64    `json:"id,omitempty"`
+		PendingID       int64    `json:"pending_id,omitempty"`
+		QueueID         int64    `json:"queue_id,omitempty"`
+		Status          string   `json:"status"`
+		URL             string   `json:"url,omitempty"`
+		SecretsDetected []string `json:"secrets_detected,omitempty"`
+		Redacted        bool     `json:"redacted,omitempty"`
+	}
+	entryListDoc struct {
+		Day             string           `json:"day,omitempty"`
+		URL             string           `json:"url,omitempty"`
+		From            string           `json:"from,omitempty"`
+		To              string           `json:"to,omitempty"`
+		TotalCount      int              `json:"total_count"`
+		Truncated       bool             `json:"truncated"`
+		NextCursor      string           `json:"next_cursor"`
+		IntegrityIssues []integrityIssue `json:"integrity_issues,omitempty"`
+		Quarantined     bool             `json:"quarantined,omitempty"`
+		Entries         []entryRow       `json:"entries"`
+	}
+	entryUpdateDoc struct {
+		Content  string `json:"content,omitempty"`
+		Category string `json:"category,omitempty"`
+	}
+	meDoc struct {
+		AuthedUser
+		LastUsedAt string `json:"last_used_at,omitempty"`
+		LastUsedIP string `json:"last_used_ip,omitempty"`
+	}
+	compactionListDoc struct {
+		Compactions []compactionRow `json:"compactions"`
+		TotalCount  int             `json:"total_count"`
+		Truncated   bool            `json:"truncated"`
+	}
+	userCreateDoc struct {
+		Username  string `json:"username"`
+		Role      string `json:"role,omitempty"`
+		TTL       string `json:"ttl,omitempty"`
+		ClaimLink bool   `json:"claim_link,omitempty"`
+		ClaimTTL  string `json:"claim_ttl,omitempty"`
+	}
+	userPatchDoc struct {
+		Disabled bool `json:"disabled"`
+	}
+	userEraseDoc struct {
+		Reason  string `json:"reason"`
+		Confirm string `json:"confirm"`
+	}
+	setupDoc struct {
+		Username string `json:"username"`
+	}
+	claimDoc struct {
+		Code string `json:"code"`
+	}
+	privateEntryDoc struct {
+		Content string `json:"content"`
+	}
+	passphraseDoc struct {
+		Passphrase string `json:"passphrase"`
+	}
+	maintenanceDoc struct {
+		Enabled bool   `json:"enabled"`
+		Message string `json:"message,omitempty"`
+	}
+	presenceDoc struct {
+		Composing bool `json:"composing"`
+	}
+	shareCreateDoc struct {
+		Day     string `json:"day,omitempty"`
+		EntryID int64  `json:"entry_id,omitempty"`
+		TTL     string `json:"ttl,omitempty"`
+	}
+	embedCreateDoc struct {
+		Limit     int    `json:"limit,omitempty"`
+		User      string `json:"user,omitempty"`
+		Anonymize bool   `json:"anonymize,omitempty"`
+		TTL       string `json:"ttl,omitempty"`
+	}
+)
+
+var (
+	dayParam       = apiParam{Name: "day", Desc: "UTC day, YYYY-MM-DD"}
+	fromParam      = apiParam{Name: "from", Desc: "First day, YYYY-MM-DD"}
+	toParam        = apiParam{Name: "to", Desc: "Last day, YYYY-MM-DD"}
+	anonymizeParam = apiParam{Name: "anonymize", Desc: "Hide authors", Type: "integer", Enum: []string{"0", "1"}}
+	limitParam     = apiParam{Name: "limit", Desc: "Maximum rows", Type: "integer"}
+	sourceParam    = apiParam{Name: "source", Desc: "Comma-separated entry sources: " + strings.Join(entrySources, ", ")}
+)
+
+// apiOperations is the documented API. Every pattern on the API mux
+// except /metrics (Prometheus text, not under /api/v1) has at least one
+// operation here.
+var apiOperations = []apiOperation{
+	{ID: "getHealth", Method: "GET", Path: "/api/health", Tag: "system", Summary: "Liveness check", Auth: apiAuthNone, Response: statusDoc{}},
+	{ID: "getReady", Method: "GET", Path: "/api/ready", Tag: "system", Summary: "Per-subsystem readiness", Auth: apiAuthNone,
+		Query: []apiParam{{Name: "strict", Desc: "Fail on any unhealthy subsystem", Type: "integer", Enum: []string{"0", "1"}}}},
+	{ID: "getSetup", Method: "GET", Path: "/api/setup", Tag: "system", Summary: "Whether first-run setup is pending (loopback only)", Auth: apiAuthNone},
+	{ID: "runSetup", Method: "POST", Path: "/api/setup", Tag: "system", Summary: "Create the first admin (loopback only)", Auth: apiAuthNone, Body: setupDoc{}, Response: newUser{}, Status: http.StatusCreated},
+	{ID: "getOpenAPI", Method: "GET", Path: "/api/openapi.json", Tag: "system", Summary: "This document", Auth: apiAuthNone},
+
+	{ID: "getMe", Method: "GET", Path: "/api/me", Tag: "account", Summary: "The authenticated user", Response: meDoc{}},
+	{ID: "getMyUsage", Method: "GET", Path: "/api/me/usage", Tag: "account", Summary: "The caller's metered usage", Query: []apiParam{fromParam, toParam}},
+	{ID: "getUIConfig", Method: "GET", Path: "/api/ui-config", Tag: "account", Summary: "Deployment feature flags for the web UI", Response: uiConfig{}},
+	{ID: "rotateMyToken", Method: "POST", Path: "/api/me/token/rotate", Tag: "account", Summary: "Replace the caller's token; the new one is returned once", Permission: actionAccountManage},
+	{ID: "claimToken", Method: "POST", Path: "/api/claim", Tag: "account", Summary: "Reveal a new user's token once through a claim link code", Auth: apiAuthSigned, Body: claimDoc{}, Response: claimedToken{}},
+	{ID: "exchangeToken", Method: "POST", Path: "/api/auth/exchange", Tag: "account", Summary: "Trade a long-lived token for a short-lived browser token", Status: http.StatusCreated},
+	{ID: "listMyTokens", Method: "GET", Path: "/api/me/tokens", Tag: "account", Summary: "The caller's named tokens", Permission: actionAccountManage},
+	{ID: "createMyToken", Method: "POST", Path: "/api/me/tokens", Tag: "account", Summary: "Create a named token; it is returned once", Permission: actionAccountManage, Status: http.StatusCreated},
+	{ID: "deleteMyToken", Method: "DELETE", Path: "/api/me/tokens/{id}", Tag: "account", Summary: "Revoke a named token", Permission: actionAccountManage},
+	{ID: "listMyPending", Method: "GET", Path: "/api/me/pending", Tag: "entries", Summary: "The caller's entries awaiting approval", Permission: actionEntriesWrite},
+	{ID: "getMyCalendar", Method: "GET", Path: "/api/me/calendar", Tag: "account", Summary: "Calendar connection status", Permission: actionAccountManage},
+	{ID: "connectMyCalendar", Method: "POST", Path: "/api/me/calendar", Tag: "account", Summary: "Start connecting a calendar", Permission: actionAccountManage},
+	{ID: "disconnectMyCalendar", Method: "DELETE", Path: "/api/me/calendar", Tag: "account", Summary: "Disconnect the calendar", Permission: actionAccountManage},
+	{ID: "getMyPreferences", Method: "GET", Path: "/api/me/preferences", Tag: "account", Summary: "The caller's preferences", Permission: actionAccountManage},
+	{ID: "putMyPreferences", Method: "PUT", Path: "/api/me/preferences", Tag: "account", Summary: "Replace the caller's preferences", Permission: actionAccountManage},
+	{ID: "getMyQuietHours", Method: "GET", Path: "/api/me/quiet-hours", Tag: "account", Summary: "The caller's quiet hours", Permission: actionAccountManage, Response: quietHours{}},
+	{ID: "putMyQuietHours", Method: "PUT", Path: "/api/me/quiet-hours", Tag: "account", Summary: "Replace the caller's quiet hours", Permission: actionAccountManage, Body: quietHours{}, Response: quietHours{}},
+	{ID: "exportMyData", Method: "GET", Path: "/api/me/export", Tag: "account", Summary: "Zip of the caller's data", Permission: actionAccountManage, ContentType: "application/zip"},
+	{ID: "listMyViews", Method: "GET", Path: "/api/me/views", Tag: "views", Summary: "The caller's saved views", Permission: actionAccountManage},
+	{ID: "createMyView", Method: "POST", Path: "/api/me/views", Tag: "views", Summary: "Save a view", Permission: actionAccountManage, Status: http.StatusCreated},
+	{ID: "getMyView", Method: "GET", Path: "/api/me/views/{id}", Tag: "views", Summary: "A saved view", Permission: actionAccountManage},
+	{ID: "putMyView", Method: "PUT", Path: "/api/me/views/{id}", Tag: "views", Summary: "Replace a saved view", Permission: actionAccountManage},
+	{ID: "deleteMyView", Method: "DELETE", Path: "/api/me/views/{id}", Tag: "views", Summary: "Delete a saved view", Permission: actionAccountManage},
+	{ID: "runMyView", Method: "GET", Path: "/api/me/views/{id}/entries", Tag: "views", Summary: "Entries matching a saved view", Permission: actionEntriesRead, Query: []apiParam{limitParam, anonymizeParam}},
+	{ID: "listMyPrivateEntries", Method: "GET", Path: "/api/me/private-entries", Tag: "account", Summary: "The caller's private entries; sealed ones need X-Private-Passphrase", Permission: actionAccountManage, Query: []apiParam{dayParam}},
+	{ID: "createMyPrivateEntry", Method: "POST", Path: "/api/me/private-entries", Tag: "account", Summary: "Add a private entry, sealed when encryption is on", Permission: actionAccountManage, Body: privateEntryDoc{}, Status: http.StatusCreated},
+	{ID: "deleteMyPrivateEntry", Method: "DELETE", Path: "/api/me/private-entries/{id}", Tag: "account", Summary: "Delete a private entry", Permission: actionAccountManage},
+	{ID: "getMyPrivateKey", Method: "GET", Path: "/api/me/private-key", Tag: "account", Summary: "Whether the caller's private entries are encrypted", Permission: actionAccountManage},
+	{ID: "putMyPrivateKey", Method: "PUT", Path: "/api/me/private-key", Tag: "account", Summary: "Encrypt private entries under a passphrase, or change it", Permission: actionAccountManage, Body: passphraseDoc{}},
+	{ID: "deleteMyPrivateKey", Method: "DELETE", Path: "/api/me/private-key", Tag: "account", Summary: "Decrypt private entries and drop the key", Permission: actionAccountManage},
+
+	{ID: "listEntries", Method: "GET", Path: "/api/entries", Tag: "entries", Summary: "Entries of a day or a day range", Permission: actionEntriesRead, Response: entryListDoc{},
+		Query: []apiParam{dayParam, fromParam, toParam, limitParam, {Name: "cursor", Desc: "next_cursor of the previous page"}, sourceParam, anonymizeParam, {Name: "format", Enum: []string{"ndjson"}}}},
+	{ID: "createEntry", Method: "POST", Path: "/api/entries", Tag: "entries", Summary: "Post an entry; 202 when queued or awaiting approval", Permission: actionEntriesWrite, Body: entryCreateDoc{}, Response: entryCreatedDoc{}, Status: http.StatusCreated},
+	{ID: "getEntry", Method: "GET", Path: "/api/entries/{id}", Tag: "entries", Summary: "An entry with its links and backlinks", Permission: actionEntriesRead},
+	{ID: "putEntry", Method: "PUT", Path: "/api/entries/{id}", Tag: "entries", Summary: "Edit an entry (author or entries.moderate)", Permission: actionEntriesWrite, Body: entryUpdateDoc{}},
+	{ID: "patchEntry", Method: "PATCH", Path: "/api/entries/{id}", Tag: "entries", Summary: "Edit an entry (author or entries.moderate)", Permission: actionEntriesWrite, Body: entryUpdateDoc{}},
+	{ID: "deleteEntry", Method: "DELETE", Path: "/api/entries/{id}", Tag: "entries", Summary: "Move an entry to the trash (author or entries.moderate)", Permission: actionEntriesWrite},
+	{ID: "restoreEntry", Method: "POST", Path: "/api/entries/{id}/restore", Tag: "entries", Summary: "Restore a trashed entry (author only)", Permission: actionEntriesWrite},
+	{ID: "listTrash", Method: "GET", Path: "/api/trash", Tag: "entries", Summary: "The caller's trashed entries", Permission: actionEntriesRead},
+	{ID: "uploadAttachment", Method: "POST", Path: "/api/entries/{id}/attachments", Tag: "entries", Summary: "Attach a file (multipart field \"file\", author only)", Permission: actionEntriesWrite, Status: http.StatusCreated},
+	{ID: "getAttachment", Method: "GET", Path: "/api/attachments/{sha256}", Tag: "entries", Summary: "Download an attachment", Permission: actionEntriesRead, ContentType: "application/octet-stream"},
+	{ID: "suggest", Method: "GET", Path: "/api/suggest", Tag: "entries", Summary: "Autocomplete tags, users and references", Permission: actionEntriesRead,
+		Query: []apiParam{{Name: "kind", Enum: []string{"tag", "user", "reference"}, Required: true}, {Name: "q"}}},
+	{ID: "getPresence", Method: "GET", Path: "/api/presence", Tag: "entries", Summary: "Who is composing an entry", Permission: actionEntriesRead},
+	{ID: "setPresence", Method: "POST", Path: "/api/presence", Tag: "entries", Summary: "Report that the caller is composing", Permission: actionEntriesWrite, Body: presenceDoc{}},
+	{ID: "getStats", Method: "GET", Path: "/api/stats", Tag: "entries", Summary: "Entry counts per user and day", Permission: actionEntriesRead, Query: []apiParam{fromParam, toParam}},
+	{ID: "quickEntryForm", Method: "GET", Path: "/api/quick", Tag: "entries", Summary: "Post an entry from a bookmarklet", Permission: actionEntriesWrite,
+		Query: []apiParam{{Name: "content"}, {Name: "url"}, {Name: "title"}}},
+	{ID: "quickEntry", Method: "POST", Path: "/api/quick", Tag: "entries", Summary: "Post an entry from a bookmarklet", Permission: actionEntriesWrite},
+	{ID: "search", Method: "GET", Path: "/api/search", Tag: "entries", Summary: "Full-text search", Permission: actionEntriesRead,
+		Query: []apiParam{{Name: "q", Required: true}, {Name: "user"}, fromParam, toParam, limitParam, sourceParam}},
+	{ID: "getHandoff", Method: "GET", Path: "/api/handoff", Tag: "entries", Summary: "Shift handoff summary", Permission: actionEntriesRead, Response: handoffSummary{},
+		Query: []apiParam{{Name: "since", Desc: "RFC 3339 time or duration"}, {Name: "format", Enum: []string{"json", "text"}}}},
+	{ID: "getArchive", Method: "GET", Path: "/api/archive", Tag: "entries", Summary: "Originals of archive-mode compactions", Permission: actionEntriesRead,
+		Query: []apiParam{dayParam, {Name: "q"}, anonymizeParam}},
+	{ID: "exportEntries", Method: "GET", Path: "/api/export", Tag: "entries", Summary: "Export a day range", Permission: actionEntriesRead,
+		Query: []apiParam{fromParam, toParam, {Name: "format", Enum: []string{"json", "csv", "md"}}, anonymizeParam}},
+	{ID: "exportDailyNote", Method: "GET", Path: "/api/export/daily-note", Tag: "entries", Summary: "A day as a Markdown daily note", Permission: actionEntriesRead,
+		Query: []apiParam{dayParam, anonymizeParam}, ContentType: "text/markdown"},
+	{ID: "liveUpdates", Method: "GET", Path: "/api/ws", Tag: "entries", Summary: "WebSocket of entry and compaction events", Permission: actionEntriesRead, Response: liveEvent{}, Status: http.StatusSwitchingProtocols},
+
+	{ID: "grafanaTest", Method: "GET", Path: "/api/grafana/", Tag: "grafana", Summary: "Grafana datasource test", Permission: actionEntriesRead},
+	{ID: "grafanaMetrics", Method: "POST", Path: "/api/grafana/metrics", Tag: "grafana", Summary: "Grafana metric names", Permission: actionEntriesRead},
+	{ID: "grafanaSearch", Method: "POST", Path: "/api/grafana/search", Tag: "grafana", Summary: "Grafana metric names (legacy)", Permission: actionEntriesRead},
+	{ID: "grafanaQueryGet", Method: "GET", Path: "/api/grafana/query", Tag: "grafana", Summary: "Grafana time series", Permission: actionEntriesRead},
+	{ID: "grafanaQuery", Method: "POST", Path: "/api/grafana/query", Tag: "grafana", Summary: "Grafana time series", Permission: actionEntriesRead},
+
+	{ID: "createShare", Method: "POST", Path: "/api/share", Tag: "sharing", Summary: "Create a signed read-only link", Permission: actionShareCreate, Body: shareCreateDoc{}},
+	{ID: "createEmbed", Method: "POST", Path: "/api/embed", Tag: "sharing", Summary: "Create a signed embed script link", Permission: actionShareCreate, Body: embedCreateDoc{}},
+	{ID: "getShared", Method: "GET", Path: "/api/shared", Tag: "sharing", Summary: "Read a shared day or entry", Auth: apiAuthSigned,
+		Query: []apiParam{dayParam, {Name: "entry", Type: "integer"}, {Name: "exp", Required: true}, {Name: "sig", Required: true}}},
+
+	{ID: "calendarCallback", Method: "GET", Path: "/api/calendar/callback", Tag: "integrations", Summary: "OAuth redirect target", Auth: apiAuthNone},
+	{ID: "inboundEmail", Method: "POST", Path: "/api/inbound/email", Tag: "integrations", Summary: "Mailgun inbound route", Auth: apiAuthSigned},
+	{ID: "inboundCI", Method: "POST", Path: "/api/inbound/ci", Tag: "integrations", Summary: "CI webhook (X-Devlog-Signature)", Auth: apiAuthSigned},
+
+	{ID: "listCompactions", Method: "GET", Path: "/api/admin/compactions", Tag: "admin", Summary: "Compaction history", Permission: actionCompactionsRead, Query: []apiParam{limitParam}, Response: compactionListDoc{}},
+	{ID: "compactDay", Method: "POST", Path: "/api/admin/compact", Tag: "admin", Summary: "Compact a day now", Permission: actionCompactionsRun, Query: []apiParam{dayParam}},
+	{ID: "uncompactDay", Method: "POST", Path: "/api/admin/uncompact", Tag: "admin", Summary: "Restore a compacted day", Permission: actionCompactionsRun, Query: []apiParam{dayParam}},
+	{ID: "getMaintenance", Method: "GET", Path: "/api/admin/maintenance", Tag: "admin", Summary: "Maintenance mode", Permission: actionMaintenance},
+	{ID: "putMaintenance", Method: "PUT", Path: "/api/admin/maintenance", Tag: "admin", Summary: "Turn maintenance mode on or off", Permission: actionMaintenance, Body: maintenanceDoc{}},
+	{ID: "getSnapshot", Method: "GET", Path: "/api/admin/snapshot", Tag: "admin", Summary: "Stream a SQLite snapshot", Permission: actionDBSnapshot, ContentType: "application/vnd.sqlite3"},
+	{ID: "getIntegrity", Method: "GET", Path: "/api/admin/integrity", Tag: "admin", Summary: "Integrity issues", Permission: actionIntegrityRead,
+		Query: []apiParam{{Name: "all", Desc: "Include resolved issues", Type: "integer", Enum: []string{"0", "1"}}}},
+	{ID: "getUsage", Method: "GET", Path: "/api/admin/usage", Tag: "admin", Summary: "Metered usage per user", Permission: actionUsageRead, Query: []apiParam{fromParam, toParam}},
+	{ID: "getSettings", Method: "GET", Path: "/api/admin/settings", Tag: "admin", Summary: "Organization settings", Permission: actionSettings, Response: orgSettings{}},
+	{ID: "patchSettings", Method: "PATCH", Path: "/api/admin/settings", Tag: "admin", Summary: "Change organization settings", Permission: actionSettings, Body: settingsPatch{}, Response: orgSettings{}},
+	{ID: "listPending", Method: "GET", Path: "/api/admin/pending", Tag: "admin", Summary: "Entries awaiting approval", Permission: actionEntriesModerate},
+	{ID: "approvePending", Method: "POST", Path: "/api/admin/pending/{id}/approve", Tag: "admin", Summary: "Approve a pending entry", Permission: actionEntriesModerate},
+	{ID: "rejectPending", Method: "POST", Path: "/api/admin/pending/{id}/reject", Tag: "admin", Summary: "Reject a pending entry", Permission: actionEntriesModerate},
+	{ID: "downloadBackup", Method: "GET", Path: "/api/admin/backup", Tag: "admin", Summary: "Download an online backup", Permission: actionBackups, ContentType: "application/vnd.sqlite3"},
+	{ID: "listBackups", Method: "GET", Path: "/api/admin/backups", Tag: "admin", Summary: "Backups in --backup-dir", Permission: actionBackups},
+	{ID: "getBackup", Method: "GET", Path: "/api/admin/backups/{name}", Tag: "admin", Summary: "Download a stored backup", Permission: actionBackups, ContentType: "application/vnd.sqlite3"},
+	{ID: "restoreBackup", Method: "POST", Path: "/api/admin/backups/{name}/restore", Tag: "admin", Summary: "Stage a backup for restore", Permission: actionBackups},
+	{ID: "listStagingEntries", Method: "GET", Path: "/api/admin/backups/staging/entries", Tag: "admin", Summary: "Entries of the staged backup", Permission: actionBackups, Query: []apiParam{dayParam}},
+	{ID: "createUser", Method: "POST", Path: "/api/admin/users", Tag: "admin", Summary: "Create a user; the token or a one-time claim link is returned once", Permission: actionUsersManage, Body: userCreateDoc{}, Response: newUser{}, Status: http.StatusCreated},
+	{ID: "patchUser", Method: "PATCH", Path: "/api/admin/users/{username}", Tag: "admin", Summary: "Disable or enable a user", Permission: actionUsersManage, Body: userPatchDoc{}},
+	{ID: "eraseUser", Method: "POST", Path: "/api/admin/users/{username}/erase", Tag: "admin", Summary: "Erase a user's personal data", Permission: actionUsersErase, Body: userEraseDoc{}, Response: erasureRecord{}},
+	{ID: "listErasures", Method: "GET", Path: "/api/admin/erasures", Tag: "admin", Summary: "Erasure records", Permission: actionUsersErase, Query: []apiParam{{Name: "username"}}},
+	{ID: "listIdentityLinks", Method: "GET", Path: "/api/admin/identity-links", Tag: "admin", Summary: "External identity links", Permission: actionIdentityLinks},
+	{ID: "createIdentityLink", Method: "POST", Path: "/api/admin/identity-links", Tag: "admin", Summary: "Link an external identity to a user", Permission: actionIdentityLinks, Body: identityLink{}},
+	{ID: "deleteIdentityLink", Method: "DELETE", Path: "/api/admin/identity-links", Tag: "admin", Summary: "Remove an identity link", Permission: actionIdentityLinks},
+	{ID: "rerenderCompacts", Method: "POST", Path: "/api/admin/compacts/rerender", Tag: "admin", Summary: "Re-render compact entries", Permission: actionCompactsRerender},
+}
+
+var apiPathParamRe = regexp.MustCompile(`\{(\w+)\}`)
+
+// openAPISchemas builds component schemas from Go types.
+type openAPISchemas struct {
+	components map[string]any
+}
+
+func openAPIName(t reflect.Type) string {
+	n := strings.TrimSuffix(t.Name(), "Doc")
+	return strings.ToUpper(n[:1]) + n[1:]
+}
+
+func (s *openAPISchemas) schemaFor(t reflect.Type) map[string]any {
+	if t == reflect.TypeOf(json.RawMessage(nil)) {
+		return map[string]any{}
+	}
+	switch t.Kind() {
+	case reflect.Pointer:
+		return s.schemaFor(t.Elem())
+	case reflect.String:
+		return map[string]any{"type": "string"}
+	case reflect.Bool:
+		return map[string]any{"type": "boolean"}
+	case reflect.Int64, reflect.Uint64:
+		return map[string]any{"type": "integer", "format": "int64"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32:
+		return map[string]any{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]any{"type": "number"}
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return map[string]any{"type": "string", "format": "byte"}
+		}
+		return map[string]any{"type": "array", "items": s.schemaFor(t.Elem())}
+	case reflect.Map:
+		return map[string]any{"type": "object", "additionalProperties": s.schemaFor(t.Elem())}
+	case reflect.Struct:
+		if t.Name() == "" {
+			return s.objectSchema(t)
+		}
+		name := openAPIName(t)
+		if _, ok := s.components[name]; !ok {
+			s.components[name] = map[string]any{} // placeholder for recursive types
+			s.components[name] = s.objectSchema(t)
+		}
+		return map[string]any{"$ref": "#/components/schemas/" + name}
+	}
+	return map[string]any{}
+}
+
+// objectSchema follows encoding/json: exported fields under their json
+// names, embedded structs flattened, "-" skipped. Fields without omitempty
+// are required.
+func (s *openAPISchemas) objectSchema(t reflect.Type) map[string]any {
+	props := map[string]any{}
+	var required []string
+	var walk func(t reflect.Type)
+	walk = func(t reflect.Type) {
+		for i := 0; i < t.NumField(); i++ {
+			f := t.Field(i)
+			tag := f.Tag.Get("json")
+			if tag == "-" || (!f.IsExported() && !f.Anonymous) {
+				continue
+			}
+			name, opts, _ := strings.Cut(tag, ",")
+			if f.Anonymous && name == "" && f.Type.Kind() == reflect.Struct {
+				walk(f.Type)
+				continue
+			}
+			if name == "" {
+				name = f.Name
+			}
+			props[name] = s.schemaFor(f.Type)
+			if !strings.Contains(opts, "omitempty") && f.Type.Kind() != reflect.Pointer {
+				required = append(required, name)
+			}
+		}
+	}
+	walk(t)
+	out := map[string]any{"type": "object", "properties": props}
+	if len(required) > 0 {
+		sort.Strings(required)
+		out["required"] = required
+	}
+	return out
+}
+
+func (s *openAPISchemas) jsonContent(v any) map[string]any {
+	schema := map[string]any{"type": "object"}
+	if v != nil {
+		schema = s.schemaFor(reflect.TypeOf(v))
+	}
+	return map[string]any{"application/json": map[string]any{"schema": schema}}
+}
+
+func (op apiOperation) parameters() []any {
+	var params []any
+	for _, m := range apiPathParamRe.FindAllStringSubmatch(op.Path, -1) {
+		params = append(params, map[string]any{"name": m[1], "in": "path", "required": true, "schema": map[string]any{"type": "string"}})
+	}
+	for _, p := range op.Query {
+		schema := map[string]any{"type": "string"}
+		if p.Type != "" {
+			schema["type"] = p.Type
+		}
+		if len(p.Enum) > 0 {
+			schema["enum"] = p.Enum
+		}
+		param := map[string]any{"name": p.Name, "in": "query", "schema": schema}
+		if p.Desc != "" {
+			param["description"] = p.Desc
+		}
+		if p.Required {
+			param["required"] = true
+		}
+		params = append(params, param)
+	}
+	return params
+}
+
+// openAPIDocument returns the OpenAPI 3.0 document for apiOperations.
+func openAPIDocument() map[string]any {
+	s := &openAPISchemas{components: map[string]any{}}
+	errResp := map[string]any{"description": "Error", "content": s.jsonContent(errorDoc{})}
+	paths := map[string]any{}
+	tags := map[string]bool{}
+	for _, op := range apiOperations {
+		status := op.Status
+		if status == 0 {
+			status = http.StatusOK
+		}
+		ok := map[string]any{"description": http.StatusText(status)}
+		switch {
+		case op.ContentType != "":
+			ok["content"] = map[string]any{op.ContentType: map[string]any{"schema": map[string]any{"type": "string", "format": "binary"}}}
+		case status != http.StatusSwitchingProtocols:
+			ok["content"] = s.jsonContent(op.Response)
+		}
+		o := map[string]any{
+			"operationId": op.ID,
+			"summary":     op.Summary,
+			"tags":        []string{op.Tag},
+			"responses":   map[string]any{strconv.Itoa(status): ok, "default": errResp},
+		}
+		tags[op.Tag] = true
+		if params := op.parameters(); len(params) > 0 {
+			o["parameters"] = params
+		}
+		if op.Body != nil {
+			o["requestBody"] = map[string]any{"required": true, "content": s.jsonContent(op.Body)}
+		}
+		if op.Auth != apiAuthToken {
+			o["security"] = []any{}
+		}
+		if op.Permission != "" {
+			o["x-permission"] = op.Permission
+			o["description"] = "Requires the `" + op.Permission + "` permission."
+		}
+		path := strings.TrimPrefix(op.Path, "/api")
+		item, _ := paths[path].(map[string]any)
+		if item == nil {
+			item = map[string]any{}
+			paths[path] = item
+		}
+		item[strings.ToLower(op.Method)] = o
+	}
+	names := make([]string, 0, len(tags))
+	for t := range tags {
+		names = append(names, t)
+	}
+	sort.Strings(names)
+	tagList := make([]any, 0, len(names))
+	for _, t := range names {
+		tagList = append(tagList, map[string]any{"name": t})
+	}
+	return map[string]any{
+		"openapi": "3.0.3",
+		"info": map[string]any{
+			"title":   "team-dev-log API",
+			"version": version,
+		},
+		"servers": []any{map[string]any{"url": apiV1Prefix}},
+		"tags":    tagList,
+		"paths":   paths,
+		"components": map[string]any{
+			"schemas": s.components,
+			"securitySchemes": map[string]any{
+				"bearerAuth": map[string]any{"type": "http", "scheme": "bearer"},
+			},
+		},
+		"security": []any{map[string]any{"bearerAuth": []string{}}},
+	}
+}
+
+// handleOpenAPI serves GET /api/openapi.json.
+func (a *App) handleOpenAPI(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		jsonErr(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	jsonOut(w, http.StatusOK, openAPIDocument())
+}
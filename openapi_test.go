@@ -0,0 +1,84 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func TestOpenAPISpec(t *testing.T) {
+	app := newTestApp(t)
+	h := newTestMux(app)
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/api/v1/openapi.json", nil))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("openapi.json: %d %s", rr.Code, rr.Body.String())
+	}
+	var doc struct {
+		OpenAPI    string                               `json:"openapi"`
+		Servers    []struct{ URL string }               `json:"servers"`
+		Paths      map[string]map[string]map[string]any `json:"paths"`
+		Components struct {
+			Schemas map[string]map[string]any `json:"schemas"`
+		} `json:"components"`
+	}
+	if err := json.Unmarshal(rr.Body.Bytes(), &doc); err != nil {
+		t.Fatal(err)
+	}
+	if doc.OpenAPI != "3.0.3" || len(doc.Servers) != 1 || doc.Servers[0].URL != "/api/v1" {
+		t.Fatalf("header: %s %+v", doc.OpenAPI, doc.Servers)
+	}
+
+	// Every route the API server registers is documented.
+	src, err := os.ReadFile("api.go")
+	if err != nil {
+		t.Fatal(err)
+	}
+	re := regexp.MustCompile(`mux\.HandleFunc\("([^"]+)"`)
+	routes := re.FindAllStringSubmatch(string(src), -1)
+	if len(routes) < 60 {
+		t.Fatalf("found only %d routes in api.go", len(routes))
+	}
+	for _, m := range routes {
+		p := strings.TrimSuffix(m[1], "{$}")
+		if p == "/metrics" {
+			continue
+		}
+		p = strings.TrimPrefix(p, "/api")
+		if doc.Paths[p] == nil && doc.Paths[p+"/"] == nil {
+			t.Errorf("route %s is not in the OpenAPI document", m[1])
+		}
+	}
+
+	ids := map[string]bool{}
+	for p, item := range doc.Paths {
+		for method, op := range item {
+			id, _ := op["operationId"].(string)
+			if id == "" || ids[id] {
+				t.Errorf("%s %s: missing or duplicate operationId %q", method, p, id)
+			}
+			ids[id] = true
+		}
+	}
+	create := doc.Paths["/entries"]["post"]
+	if create["x-permission"] != actionEntriesWrite || create["requestBody"] == nil {
+		t.Fatalf("createEntry: %+v", create)
+	}
+	if doc.Paths["/health"]["get"]["security"] == nil {
+		t.Fatal("health should not require a token")
+	}
+	// Schemas come from the Go types and every reference resolves.
+	props, _ := doc.Components.Schemas["EntryRow"]["properties"].(map[string]any)
+	if props["content"] == nil || props["created_at"] == nil {
+		t.Fatalf("EntryRow schema: %+v", doc.Components.Schemas["EntryRow"])
+	}
+	for _, ref := range regexp.MustCompile(`"#/components/schemas/(\w+)"`).FindAllStringSubmatch(rr.Body.String(), -1) {
+		if doc.Components.Schemas[ref[1]] == nil {
+			t.Errorf("dangling $ref %s", ref[1])
+		}
+	}
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"encoding/base64"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var errInvalidCursor = errors.New("invalid cursor")
+
+// entryCursor marks the last entry of a list page. Lists are ordered by
+// (created_at, id) descending, so the next page starts strictly below it;
+// entries posted meanwhile sort above the cursor and never shift a page.
+type entryCursor struct {
+	CreatedAt string
+	ID        int64
+}
+
+// encode renders the cursor as an opaque URL-safe token.
+func (c entryCursor) encode() string {
+	return base64.RawURLEncoding.EncodeToString([]byte(c.CreatedAt + "|" + strconv.FormatInt(c.ID, 10)))
+}
+
+func decodeEntryCursor(s string) (entryCursor, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return entryCursor{}, errInvalidCursor
+	}
+	createdAt, id, ok := strings.Cut(string(raw), "|")
+	if !ok {
+		return entryCursor{}, errInvalidCursor
+	}
+	n, err := strconv.ParseInt(id, 10, 64)
+	if err != nil || n <= 0 {
+		return entryCursor{}, errInvalidCursor
+	}
+	if _, err := time.Parse(time.RFC3339Nano, createdAt); err != nil {
+		return entryCursor{}, errInvalidCursor
+	}
+	return entryCursor{CreatedAt: createdAt, ID: n}, nil
+}
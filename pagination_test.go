@@ -0,0 +1,80 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestListEntriesCursorPagination(t *testing.T) {
+	app := newTestApp(t)
+	h := newTestMux(app)
+	createUser(t, app, "alice", "PUDPAGE0001")
+	var aliceID int64
+	if err := app.db.QueryRow(`SELECT id FROM users WHERE username = 'alice'`).Scan(&aliceID); err != nil {
+		t.Fatal(err)
+	}
+	// Five entries on one day, three sharing a timestamp, and one the day before.
+	for _, ts := range []string{"2026-03-02T09:00:00Z", "2026-03-02T10:00:00Z", "2026-03-02T10:00:00Z", "2026-03-02T10:00:00Z", "2026-03-02T11:00:00Z", "2026-03-01T23:00:00Z"} {
+		if _, err := app.db.Exec(`INSERT INTO entries(user_id, entry_type, content, created_at) VALUES(?, 'normal', ?, ?)`, aliceID, "at "+ts, ts); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	type page struct {
+		TotalCount int        `json:"total_count"`
+		Truncated  bool       `json:"truncated"`
+		NextCursor string     `json:"next_cursor"`
+		Entries    []entryRow `json:"entries"`
+	}
+	walk := func(query string) ([]int64, int) {
+		t.Helper()
+		var ids []int64
+		cursor, pages := "", 0
+		for {
+			rr := httptest.NewRecorder()
+			h.ServeHTTP(rr, authedReq(t, http.MethodGet, "/api/entries?"+query+"&limit=2&cursor="+cursor, nil, "PUDPAGE0001"))
+			var p page
+			if rr.Code != http.StatusOK || json.Unmarshal(rr.Body.Bytes(), &p) != nil {
+				t.Fatalf("page %d: %d %s", pages, rr.Code, rr.Body.String())
+			}
+			pages++
+			for _, e := range p.Entries {
+				ids = append(ids, e.ID)
+			}
+			if p.Truncated != (p.NextCursor != "") {
+				t.Fatalf("truncated=%t with next_cursor=%q", p.Truncated, p.NextCursor)
+			}
+			if p.NextCursor == "" {
+				break
+			}
+			if pages == 1 {
+				// A new entry at the top must not shift later pages.
+				if _, err := app.db.Exec(`INSERT INTO entries(user_id, entry_type, content, created_at) VALUES(?, 'normal', 'late', '2026-03-02T12:00:00Z')`, aliceID); err != nil {
+					t.Fatal(err)
+				}
+			}
+			cursor = p.NextCursor
+		}
+		return ids, pages
+	}
+
+	ids, pages := walk("day=2026-03-02")
+	if want := []int64{5, 4, 3, 2, 1}; fmt.Sprint(ids) != fmt.Sprint(want) || pages != 3 {
+		t.Fatalf("day walk: ids=%v pages=%d", ids, pages)
+	}
+	ids, _ = walk("from=2026-03-01&to=2026-03-02")
+	if want := []int64{7, 5, 4, 3, 2, 1, 6}; fmt.Sprint(ids) != fmt.Sprint(want) {
+		t.Fatalf("range walk: ids=%v", ids)
+	}
+
+	for _, q := range []string{"cursor=bogus", "cursor=" + entryCursor{CreatedAt: "yesterday", ID: 3}.encode(), "day=2026-03-02&from=2026-03-01&to=2026-03-02", "from=2026-03-01", "from=2026-01-01&to=2026-03-02"} {
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, authedReq(t, http.MethodGet, "/api/entries?"+q, nil, "PUDPAGE0001"))
+		if rr.Code != http.StatusBadRequest {
+			t.Fatalf("%s: expected 400, got %d", q, rr.Code)
+		}
+	}
+}
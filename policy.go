@@ -0,0 +1,170 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// Actions checked by the policy layer. Routes declare the action they need
+// and handlers no longer compare roles themselves.
+const (
+	actionEntriesRead      = "entries.read"
+	actionEntriesWrite     = "entries.write"
+	actionEntriesModerate  = "entries.moderate"
+	actionShareCreate      = "share.create"
+	actionAccountManage    = "account.manage"
+	actionImpersonate      = "users.impersonate"
+	actionCompactionsRead  = "compactions.read"
+	actionCompactionsRun   = "compactions.run"
+	actionMaintenance      = "maintenance.manage"
+	actionIdentityLinks    = "identity_links.manage"
+	actionCompactsRerender = "compacts.rerender"
+	actionDBSnapshot       = "db.snapshot"
+	actionIntegrityRead    = "integrity.read"
+	actionUsageRead        = "usage.read"
+	actionSettings         = "settings.manage"
+	actionUsersManage      = "users.manage"
+	actionUsersErase       = "users.erase"
+	actionBackups          = "backups.manage"
+
+	// actionAll grants every action.
+	actionAll = "*"
+)
+
+var policyActions = []string{
+	actionEntriesRead,
+	actionEntriesWrite,
+	actionEntriesModerate,
+	actionShareCreate,
+	actionAccountManage,
+	actionImpersonate,
+	actionCompactionsRead,
+	actionCompactionsRun,
+	actionMaintenance,
+	actionIdentityLinks,
+	actionCompactsRerender,
+	actionDBSnapshot,
+	actionIntegrityRead,
+	actionUsageRead,
+	actionSettings,
+	actionUsersManage,
+	actionUsersErase,
+	actionBackups,
+}
+
+var roleNameRe = regexp.MustCompile(`^[a-z][a-z0-9_-]{0,31}$`)
+
+// Policy is a role x action matrix.
+type Policy struct {
+	grants map[string]map[string]bool
+}
+
+// defaultPolicy is used when no --policy-file is given and is the base the
+// file's overrides apply to.
+func defaultPolicy() *Policy {
+	p := &Policy{grants: map[string]map[string]bool{}}
+	p.set(roleMember, []string{actionEntriesRead, actionEntriesWrite, actionShareCreate, actionAccountManage})
+	p.set(roleContributor, []string{actionEntriesRead, actionEntriesWrite, actionAccountManage})
+	p.set(roleAdmin, []string{actionAll})
+	return p
+}
+
+func (p *Policy) set(role string, actions []string) {
+	g := map[string]bool{}
+	for _, act := range actions {
+		g[act] = true
+	}
+	p.grants[role] = g
+}
+
+// allows reports whether role may perform action. A nil policy is the
+// default one.
+func (p *Policy) allows(role, action string) bool {
+	if p == nil {
+		p = defaultPolicy()
+	}
+	g := p.grants[role]
+	return g[actionAll] || g[action]
+}
+
+// hasRole reports whether role appears in the matrix.
+func (p *Policy) hasRole(role string) bool {
+	if p == nil {
+		p = defaultPolicy()
+	}
+	_, ok := p.grants[role]
+	return ok
+}
+
+func (p *Policy) roles() []string {
+	if p == nil {
+		p = defaultPolicy()
+	}
+	out := make([]string, 0, len(p.grants))
+	for role := range p.grants {
+		out = append(out, role)
+	}
+	sort.Strings(out)
+	return out
+}
+
+// loadPolicy reads overrides from a JSON file of the form
+// {"roles":{"auditor":["entries.read","compactions.read"],"bot":["entries.write"]}}.
+// Each listed role replaces its default grants; unlisted roles keep them.
+func loadPolicy(path string) (*Policy, error) {
+	p := defaultPolicy()
+	if path == "" {
+		return p, nil
+	}
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var file struct {
+		Roles map[string][]string `json:"roles"`
+	}
+	dec := json.NewDecoder(bytes.NewReader(b))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&file); err != nil {
+		return nil, fmt.Errorf("parse policy %s: %w", path, err)
+	}
+	for role, actions := range file.Roles {
+		if !roleNameRe.MatchString(role) {
+			return nil, fmt.Errorf("policy %s: invalid role name %q", path, role)
+		}
+		for _, act := range actions {
+			if act != actionAll && !containsString(policyActions, act) {
+				return nil, fmt.Errorf("policy %s: role %s: unknown action %q (known: %s)", path, role, act, strings.Join(policyActions, ", "))
+			}
+		}
+		p.set(role, actions)
+	}
+	return p, nil
+}
+
+// authorize wraps an authenticated handler with a policy check for action.
+func (a *App) authorize(action string, next func(http.ResponseWriter, *http.Request, AuthedUser)) func(http.ResponseWriter, *http.Request, AuthedUser) {
+	return a.authorizeRW(action, action, next)
+}
+
+// authorizeRW checks read for GET/HEAD requests and write for everything else.
+func (a *App) authorizeRW(read, write string, next func(http.ResponseWriter, *http.Request, AuthedUser)) func(http.ResponseWriter, *http.Request, AuthedUser) {
+	return func(w http.ResponseWriter, r *http.Request, u AuthedUser) {
+		action := write
+		if r.Method == http.MethodGet || r.Method == http.MethodHead {
+			action = read
+		}
+		if !a.policy.allows(u.Role, action) {
+			a.logger.Printf("event=permission_denied user=%s role=%s action=%s path=%s", u.Username, u.Role, action, r.URL.Path)
+			jsonOut(w, http.StatusForbidden, map[string]string{"error": "permission denied", "action": action})
+			return
+		}
+		next(w, r, u)
+	}
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestAPIPolicyCustomRoles(t *testing.T) {
+	app := newTestApp(t)
+	policyPath := filepath.Join(t.TempDir(), "policy.json")
+	if err := os.WriteFile(policyPath, []byte(`{"roles":{"auditor":["entries.read","compactions.read"],"bot":["entries.write"]}}`), 0o600); err != nil {
+		t.Fatalf("write policy: %v", err)
+	}
+	policy, err := loadPolicy(policyPath)
+	if err != nil {
+		t.Fatalf("loadPolicy: %v", err)
+	}
+	app.policy = policy
+	h := newTestMux(app)
+	createUser(t, app, "alice", "PUDABCDEF12")
+	createUser(t, app, "audra", "PUDAUDRA123")
+	createUser(t, app, "ci", "PUDCIBOT123")
+	if _, err := app.db.Exec(`UPDATE users SET role = CASE username WHEN 'audra' THEN 'auditor' WHEN 'ci' THEN 'bot' ELSE role END`); err != nil {
+		t.Fatalf("set roles: %v", err)
+	}
+
+	cases := []struct {
+		method, path, token string
+		body                any
+		want                int
+	}{
+		{http.MethodGet, "/api/admin/compactions", "PUDAUDRA123", nil, http.StatusOK},
+		{http.MethodGet, "/api/entries", "PUDAUDRA123", nil, http.StatusOK},
+		{http.MethodPost, "/api/entries", "PUDAUDRA123", map[string]string{"content": "x"}, http.StatusForbidden},
+		{http.MethodGet, "/api/admin/maintenance", "PUDAUDRA123", nil, http.StatusForbidden},
+		{http.MethodPost, "/api/entries", "PUDCIBOT123", map[string]string{"content": "deploy 42 finished"}, http.StatusCreated},
+		{http.MethodGet, "/api/entries", "PUDCIBOT123", nil, http.StatusForbidden},
+		{http.MethodGet, "/api/admin/compactions", "PUDABCDEF12", nil, http.StatusForbidden},
+		{http.MethodGet, "/api/me", "PUDCIBOT123", nil, http.StatusOK},
+	}
+	for _, tc := range cases {
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, authedReq(t, tc.method, tc.path, tc.body, tc.token))
+		if rr.Code != tc.want {
+			t.Fatalf("%s %s as %s: expected %d, got %d body=%s", tc.method, tc.path, tc.token, tc.want, rr.Code, rr.Body.String())
+		}
+	}
+
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, authedReq(t, http.MethodPost, "/api/entries", map[string]string{"content": "x"}, "PUDAUDRA123"))
+	if !strings.Contains(rr.Body.String(), `"action":"entries.write"`) {
+		t.Fatalf("expected denied action in body, got %s", rr.Body.String())
+	}
+
+	if err := os.WriteFile(policyPath, []byte(`{"roles":{"bot":["entries.delete"]}}`), 0o600); err != nil {
+		t.Fatalf("write policy: %v", err)
+	}
+	if _, err := loadPolicy(policyPath); err == nil {
+		t.Fatalf("expected unknown action to be rejected")
+	}
+}
+
+// TestAdminRoutesRequireRole guards against /api/admin/* routes being
+// registered without an authorize wrapper: members are refused on every
+// method and anonymous callers are unauthorized.
+func TestAdminRoutesRequireRole(t *testing.T) {
+	app := newTestApp(t)
+	h := newTestMux(app)
+	createUser(t, app, "alice", "PUDRBACAL01")
+	createUser(t, app, "root", "PUDRBACRO01")
+	if _, err := app.db.Exec(`UPDATE users SET role = 'admin' WHERE username = 'root'`); err != nil {
+		t.Fatalf("promote: %v", err)
+	}
+	routes := []string{
+		"/api/admin/compactions",
+		"/api/admin/maintenance",
+		"/api/admin/snapshot",
+		"/api/admin/backup",
+		"/api/admin/integrity",
+		"/api/admin/usage",
+		"/api/admin/settings",
+		"/api/admin/identity-links",
+		"/api/admin/compacts/rerender",
+		"/api/admin/users",
+		"/api/admin/backups",
+		"/api/admin/backups/devlog-2026-02-17.db",
+		"/api/admin/backups/devlog-2026-02-17.db/restore",
+		"/api/admin/backups/staging/entries",
+	}
+	for _, path := range routes {
+		for _, method := range []string{http.MethodGet, http.MethodPost, http.MethodPatch} {
+			rr := httptest.NewRecorder()
+			h.ServeHTTP(rr, httptest.NewRequest(method, path, nil))
+			if rr.Code != http.StatusUnauthorized {
+				t.Errorf("%s %s without token: expected 401, got %d", method, path, rr.Code)
+			}
+			rr = httptest.NewRecorder()
+			h.ServeHTTP(rr, authedReq(t, method, path, map[string]string{}, "PUDRBACAL01"))
+			if rr.Code != http.StatusForbidden {
+				t.Errorf("%s %s as member: expected 403, got %d", method, path, rr.Code)
+			}
+		}
+	}
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, authedReq(t, http.MethodGet, "/api/admin/settings", nil, "PUDRBACRO01"))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("admin settings: expected 200, got %d", rr.Code)
+	}
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+// presenceTTL is how long a composing signal lasts without a refresh. The UI
+// refreshes every few seconds while the user types.
+const presenceTTL = 8 * time.Second
+
+// presenceTracker keeps "who is composing" signals in memory only: they are
+// worthless after a few seconds and never touch the database or audit log.
+// The zero value is ready to use.
+type presenceTracker struct {
+	mu        sync.Mutex
+	composing map[string]time.Time // username -> expiry
+}
+
+func (p *presenceTracker) set(user string, composing bool, now time.Time) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.composing == nil {
+		p.composing = map[string]time.Time{}
+	}
+	if composing {
+		p.composing[user] = now.Add(presenceTTL)
+	} else {
+		delete(p.composing, user)
+	}
+}
+
+// active returns users currently composing, sorted, dropping expired signals.
+func (p *presenceTracker) active(now time.Time) []string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	out := []string{}
+	for user, exp := range p.composing {
+		if !now.Before(exp) {
+			delete(p.composing, user)
+			continue
+		}
+		out = append(out, user)
+	}
+	sort.Strings(out)
+	return out
+}
+
+// handlePresence serves /api/presence. POST {"composing":true|false} sets the
+// caller's signal; GET lists the other users currently composing. Until the
+// UI has a push channel it polls this endpoint.
+func (a *App) handlePresence(w http.ResponseWriter, r *http.Request, u AuthedUser) {
+	switch r.Method {
+	case http.MethodGet:
+		others := []string{}
+		for _, user := range a.presence.active(time.Now()) {
+			if user != u.Username {
+				others = append(others, user)
+			}
+		}
+		jsonOut(w, http.StatusOK, map[string]any{"composing": others, "ttl_seconds": int(presenceTTL.Seconds())})
+	case http.MethodPost:
+		var req struct {
+			Composing bool `json:"composing"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			jsonErr(w, http.StatusBadRequest, "invalid json")
+			return
+		}
+		a.presence.set(u.Username, req.Composing, time.Now())
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		jsonErr(w, http.StatusMethodNotAllowed, "method not allowed")
+	}
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestAPIPresenceComposing(t *testing.T) {
+	app := newTestApp(t)
+	h := newTestMux(app)
+	createUser(t, app, "alice", "PUDPRESALI1")
+	createUser(t, app, "bob", "PUDPRESBOB1")
+
+	composing := func(token string) []string {
+		t.Helper()
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, authedReq(t, http.MethodGet, "/api/presence", nil, token))
+		var out struct {
+			Composing []string `json:"composing"`
+		}
+		if err := json.Unmarshal(rr.Body.Bytes(), &out); err != nil || rr.Code != http.StatusOK {
+			t.Fatalf("presence: %d %s", rr.Code, rr.Body.String())
+		}
+		return out.Composing
+	}
+
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, authedReq(t, http.MethodPost, "/api/presence", map[string]bool{"composing": true}, "PUDPRESALI1"))
+	if rr.Code != http.StatusNoContent {
+		t.Fatalf("expected 204, got %d", rr.Code)
+	}
+	if got := composing("PUDPRESBOB1"); len(got) != 1 || got[0] != "alice" {
+		t.Fatalf("bob should see alice composing, got %v", got)
+	}
+	if got := composing("PUDPRESALI1"); len(got) != 0 {
+		t.Fatalf("callers should not see their own signal, got %v", got)
+	}
+
+	rr = httptest.NewRecorder()
+	h.ServeHTTP(rr, authedReq(t, http.MethodPost, "/api/entries", map[string]string{"content": "done"}, "PUDPRESALI1"))
+	if got := composing("PUDPRESBOB1"); len(got) != 0 {
+		t.Fatalf("posting should clear the signal, got %v", got)
+	}
+
+	app.presence.set("bob", true, time.Now().Add(-presenceTTL))
+	if got := app.presence.active(time.Now()); len(got) != 0 {
+		t.Fatalf("expected expired signal to be dropped, got %v", got)
+	}
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPersonalDataExportAndErasure(t *testing.T) {
+	app := newTestApp(t)
+	h := newTestMux(app)
+	createUser(t, app, "alice", "PUDERASEAL1")
+	createUser(t, app, "bob", "PUDERASEBO1")
+	createUser(t, app, "root", "PUDERASERO1")
+	if _, err := app.db.Exec(`UPDATE users SET role = 'admin' WHERE username = 'root'`); err != nil {
+		t.Fatal(err)
+	}
+	do := func(method, path string, body any, token string) *httptest.ResponseRecorder {
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, authedReq(t, method, path, body, token))
+		return rr
+	}
+	for _, p := range []struct{ token, content string }{
+		{"PUDERASEAL1", "paired with @bob on the parser"},
+		{"PUDERASEBO1", "thanks @alice, merged"},
+	} {
+		if rr := do(http.MethodPost, "/api/entries", map[string]string{"content": p.content}, p.token); rr.Code != http.StatusCreated {
+			t.Fatalf("post: %d %s", rr.Code, rr.Body.String())
+		}
+	}
+	if err := app.compactDay(time.Now().UTC().Format("2006-01-02")); err != nil {
+		t.Fatalf("compactDay: %v", err)
+	}
+	if rr := do(http.MethodPost, "/api/entries", map[string]string{"content": "ping @alice about the release"}, "PUDERASEBO1"); rr.Code != http.StatusCreated {
+		t.Fatalf("post: %d %s", rr.Code, rr.Body.String())
+	}
+
+	rr := do(http.MethodGet, "/api/me/export", nil, "PUDERASEAL1")
+	if rr.Code != http.StatusOK || rr.Header().Get("Content-Type") != "application/zip" {
+		t.Fatalf("export: %d %s", rr.Code, rr.Header().Get("Content-Type"))
+	}
+	zr, err := zip.NewReader(bytes.NewReader(rr.Body.Bytes()), int64(rr.Body.Len()))
+	if err != nil {
+		t.Fatalf("zip: %v", err)
+	}
+	files := map[string]string{}
+	for _, f := range zr.File {
+		rc, err := f.Open()
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, _ := io.ReadAll(rc)
+		rc.Close()
+		files[f.Name] = string(b)
+	}
+	if !strings.Contains(files["compacted_entries.json"], "paired with @bob") || strings.Contains(files["compacted_entries.json"], "thanks @alice") {
+		t.Fatalf("compacted_entries.json: %s", files["compacted_entries.json"])
+	}
+	if !strings.Contains(files["action_logs.json"], `"create_entry"`) || !strings.Contains(files["profile.json"], `"username": "alice"`) {
+		t.Fatalf("export files: %v", files)
+	}
+
+	var aliceID int64
+	if err := app.db.QueryRow(`SELECT id FROM users WHERE username = 'alice'`).Scan(&aliceID); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, _, err := app.createClaimLink(aliceID, "root", time.Hour); err != nil {
+		t.Fatalf("createClaimLink: %v", err)
+	}
+
+	erase := func(username, confirm, token string) *httptest.ResponseRecorder {
+		return do(http.MethodPost, "/api/admin/users/"+username+"/erase", map[string]string{"reason": "left the company", "confirm": confirm}, token)
+	}
+	if rr := erase("alice", "alice", "PUDERASEBO1"); rr.Code != http.StatusForbidden {
+		t.Fatalf("member: expected 403, got %d", rr.Code)
+	}
+	if rr := erase("alice", "bob", "PUDERASERO1"); rr.Code != http.StatusBadRequest {
+		t.Fatalf("confirm mismatch: expected 400, got %d", rr.Code)
+	}
+	if rr := erase("root", "root", "PUDERASERO1"); rr.Code != http.StatusBadRequest {
+		t.Fatalf("self: expected 400, got %d", rr.Code)
+	}
+	rr = erase("alice", "alice", "PUDERASERO1")
+	var rec erasureRecord
+	if err := json.Unmarshal(rr.Body.Bytes(), &rec); err != nil || rr.Code != http.StatusOK {
+		t.Fatalf("erase: %d %s", rr.Code, rr.Body.String())
+	}
+	if rec.Pseudonym != fmt.Sprintf("erased-%d", rec.UserID) || rec.CompactsRewritten != 1 || rec.MentionsRewritten != 1 || rec.EntriesKept != 0 {
+		t.Fatalf("unexpected erasure record %+v", rec)
+	}
+	if rr := do(http.MethodGet, "/api/me", nil, "PUDERASEAL1"); rr.Code != http.StatusUnauthorized {
+		t.Fatalf("erased token: expected 401, got %d", rr.Code)
+	}
+	var leftover int
+	if err := app.db.QueryRow(`SELECT COUNT(*) FROM entries WHERE content LIKE '%alice%' OR compact_data LIKE '%alice%'`).Scan(&leftover); err != nil || leftover != 0 {
+		t.Fatalf("expected no entry to name alice, got %d err=%v", leftover, err)
+	}
+	if err := app.db.QueryRow(`SELECT COUNT(*) FROM claim_links WHERE user_id = ?`, rec.UserID).Scan(&leftover); err != nil || leftover != 0 {
+		t.Fatalf("expected claim links to be deleted, got %d err=%v", leftover, err)
+	}
+	var compact string
+	if err := app.db.QueryRow(`SELECT content FROM entries WHERE entry_type = 'daily_compact'`).Scan(&compact); err != nil || !strings.Contains(compact, "]["+rec.Pseudonym+"] paired with @bob") || !strings.Contains(compact, "thanks @"+rec.Pseudonym+",") {
+		t.Fatalf("compact not rewritten: %q err=%v", compact, err)
+	}
+	if rr := erase("alice", "alice", "PUDERASERO1"); rr.Code != http.StatusNotFound {
+		t.Fatalf("erase again: expected 404, got %d", rr.Code)
+	}
+	if rr := erase(rec.Pseudonym, rec.Pseudonym, "PUDERASERO1"); rr.Code != http.StatusConflict {
+		t.Fatalf("erase pseudonym: expected 409, got %d", rr.Code)
+	}
+	rr = do(http.MethodGet, "/api/admin/erasures?username=alice", nil, "PUDERASERO1")
+	if rr.Code != http.StatusOK || !strings.Contains(rr.Body.String(), `"pseudonym":"`+rec.Pseudonym+`"`) || strings.Contains(rr.Body.String(), `"alice"`) {
+		t.Fatalf("erasures: %d %s", rr.Code, rr.Body.String())
+	}
+}
@@ -0,0 +1,479 @@
+package main
+
+import (
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Private entries are personal notes only their author can read. They live in
+// private_entries, apart from entries, so nothing that reads the team log
+// (lists, search, compaction, exports, share links, alerts, mentions) sees
+// them, and admins cannot open them by impersonating the author.
+//
+// Encryption is optional and per user. PUT /api/me/private-key with a
+// passphrase generates a random data key, seals the user's private entries
+// with it and stores it wrapped by a key derived from the passphrase
+// (PBKDF2-SHA256, see seal.go). The passphrase is never stored: requests that
+// read or write sealed content send it in X-Private-Passphrase, so a copy of
+// the database shows only ciphertext. Changing the passphrase rewraps the
+// data key; a lost passphrase cannot be recovered.
+
+const (
+	privatePassphraseHeader = "X-Private-Passphrase"
+	minPassphraseLen        = 12
+	privateKeySaltBytes     = 16
+	privateImpersonationMsg = "private entries are not available while impersonating"
+	privateKeyRateLimit     = 10 // passphrase derivations per user per minute
+)
+
+// privateKeyIterations is the PBKDF2 work factor for new and rewrapped keys.
+// Each key row stores its own, so raising it does not lock anyone out.
+var privateKeyIterations = 600_000
+
+var (
+	errPassphraseRequired = errors.New("private entries are encrypted: send the passphrase in " + privatePassphraseHeader)
+	errPassphraseWrong    = errors.New("wrong passphrase")
+	errPassphraseLimited  = errors.New("too many passphrase attempts, try again later")
+)
+
+// privateEntry is one private entry as returned to its author. Sealed is set
+// when the content is encrypted and no passphrase was sent; Content is then
+// empty.
+type privateEntry struct {
+	ID        int64  `json:"id"`
+	Content   string `json:"content"`
+	Sealed    bool   `json:"sealed,omitempty"`
+	CreatedAt string `json:"created_at"`
+}
+
+func privateEntryAAD(userID int64) string {
+	return fmt.Sprintf("private_entries:%d", userID)
+}
+
+func privateKeyAAD(userID int64) string {
+	return fmt.Sprintf("private_keys:%d", userID)
+}
+
+// privateKeyRow is a user's wrapped data key.
+type privateKeyRow struct {
+	Salt       string
+	Iterations int
+	Wrapped    string
+	UpdatedAt  string
+}
+
+// loadPrivateKey returns userID's key row; ok is false when the user's
+// private entries are not encrypted.
+func (a *App) loadPrivateKey(userID int64) (k privateKeyRow, ok bool, err error) {
+	err = a.db.QueryRow(`SELECT salt, iterations, wrapped_key, updated_at FROM private_keys WHERE user_id = ?`, userID).
+		Scan(&k.Salt, &k.Iterations, &k.Wrapped, &k.UpdatedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return k, false, nil
+	}
+	return k, err == nil, err
+}
+
+// open unwraps the data key with passphrase.
+func (k privateKeyRow) open(userID int64, passphrase string) ([]byte, error) {
+	if passphrase == "" {
+		return nil, errPassphraseRequired
+	}
+	salt, err := hex.DecodeString(k.Salt)
+	if err != nil {
+		return nil, err
+	}
+	kek := pbkdf2SHA256([]byte(passphrase), salt, k.Iterations, 32)
+	plain, err := openString(kek, privateKeyAAD(userID), k.Wrapped)
+	if err != nil {
+		return nil, errPassphraseWrong
+	}
+	return hex.DecodeString(plain)
+}
+
+// wrapPrivateKey wraps dataKey under passphrase with a fresh salt.
+func wrapPrivateKey(userID int64, passphrase string, dataKey []byte) (privateKeyRow, error) {
+	salt := make([]byte, privateKeySaltBytes)
+	if _, err := rand.Read(salt); err != nil {
+		return privateKeyRow{}, err
+	}
+	k := privateKeyRow{Salt: hex.EncodeToString(salt), Iterations: privateKeyIterations, UpdatedAt: nowUTC()}
+	kek := pbkdf2SHA256([]byte(passphrase), salt, k.Iterations, 32)
+	var err error
+	k.Wrapped, err = sealString(kek, privateKeyAAD(userID), hex.EncodeToString(dataKey))
+	return k, err
+}
+
+// allowPassphrase charges one passphrase derivation to u. PBKDF2 is slow on
+// purpose, so derivations are capped per user (privateKeyRateLimit a minute)
+// to keep a stolen token from guessing passphrases or tying up the CPU.
+func (a *App) allowPassphrase(u AuthedUser) error {
+	if a.privateKeyLimit.allow(u.Username, time.Now()) {
+		return nil
+	}
+	a.logger.Printf("event=private_key_rate_limited user=%s", u.Username)
+	return errPassphraseLimited
+}
+
+// privateDataKey returns the caller's data key, or nil when their private
+// entries are not encrypted.
+func (a *App) privateDataKey(u AuthedUser, passphrase string) ([]byte, error) {
+	k, ok, err := a.loadPrivateKey(u.ID)
+	if err != nil || !ok {
+		return nil, err
+	}
+	if passphrase != "" {
+		if err := a.allowPassphrase(u); err != nil {
+			return nil, err
+		}
+	}
+	return k.open(u.ID, passphrase)
+}
+
+// privateKeyErr answers a failed privateDataKey.
+func privateKeyErr(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, errPassphraseRequired):
+		jsonErr(w, http.StatusBadRequest, err.Error())
+	case errors.Is(err, errPassphraseWrong):
+		jsonErr(w, http.StatusForbidden, err.Error())
+	case errors.Is(err, errPassphraseLimited):
+		w.Header().Set("Retry-After", "60")
+		jsonErr(w, http.StatusTooManyRequests, err.Error())
+	default:
+		jsonErr(w, http.StatusInternalServerError, "failed to read private key")
+	}
+}
+
+// privateEntries lists userID's private entries, newest first, optionally
+// only those of day. Sealed content is opened with key, or withheld when key
+// is nil.
+func (a *App) privateEntries(userID int64, day string, key []byte) ([]privateEntry, error) {
+	query := `SELECT id, content, created_at FROM private_entries WHERE user_id = ?`
+	args := []any{userID}
+	if day != "" {
+		query += ` AND date(created_at) = ?`
+		args = append(args, day)
+	}
+	rows, err := a.db.Query(query+` ORDER BY created_at DESC, id DESC`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	out := []privateEntry{}
+	for rows.Next() {
+		var e privateEntry
+		if err := rows.Scan(&e.ID, &e.Content, &e.CreatedAt); err != nil {
+			return nil, err
+		}
+		if isSealed(e.Content) {
+			if key == nil {
+				e.Content, e.Sealed = "", true
+			} else if e.Content, err = openString(key, privateEntryAAD(userID), e.Content); err != nil {
+				return nil, fmt.Errorf("private entry %d: %w", e.ID, err)
+			}
+		}
+		out = append(out, e)
+	}
+	return out, rows.Err()
+}
+
+// handleMyPrivateEntries lists (GET, ?day=) or creates (POST {"content"})
+// the caller's private entries.
+func (a *App) handleMyPrivateEntries(w http.ResponseWriter, r *http.Request, u AuthedUser) {
+	if u.ImpersonatedBy != "" {
+		jsonErr(w, http.StatusForbidden, privateImpersonationMsg)
+		return
+	}
+	passphrase := r.Header.Get(privatePassphraseHeader)
+	switch r.Method {
+	case http.MethodGet:
+		day := strings.TrimSpace(r.URL.Query().Get("day"))
+		if day != "" {
+			if _, err := time.Parse("2006-01-02", day); err != nil {
+				jsonErr(w, http.StatusBadRequest, "invalid day (want YYYY-MM-DD)")
+				return
+			}
+		}
+		// Without a passphrase sealed entries are listed without content.
+		var key []byte
+		var err error
+		if passphrase != "" {
+			if key, err = a.privateDataKey(u, passphrase); err != nil {
+				privateKeyErr(w, err)
+				return
+			}
+		}
+		entries, err := a.privateEntries(u.ID, day, key)
+		if err != nil {
+			a.logger.Printf("event=private_entries_failed user=%s err=%v", u.Username, err)
+			jsonErr(w, http.StatusInternalServerError, "failed to query private entries")
+			return
+		}
+		jsonOut(w, http.StatusOK, map[string]any{"entries": entries})
+	case http.MethodPost:
+		var req struct {
+			Content string `json:"content"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			jsonErr(w, http.StatusBadRequest, "invalid json")
+			return
+		}
+		content := strings.TrimSpace(req.Content)
+		if content == "" {
+			jsonErr(w, http.StatusBadRequest, "content is required")
+			return
+		}
+		if len(content) > a.settings().MaxEntrySize {
+			jsonErr(w, http.StatusBadRequest, "content too large")
+			return
+		}
+		redacted, secrets := scanSecrets(content)
+		if len(secrets) > 0 && a.redactSecrets {
+			content = redacted
+		}
+		key, err := a.privateDataKey(u, passphrase)
+		if err != nil {
+			privateKeyErr(w, err)
+			return
+		}
+		stored := content
+		if key != nil {
+			if stored, err = sealString(key, privateEntryAAD(u.ID), content); err != nil {
+				jsonErr(w, http.StatusInternalServerError, "failed to store private entry")
+				return
+			}
+		}
+		e := privateEntry{Content: content, CreatedAt: nowUTC()}
+		res, err := a.db.Exec(`INSERT INTO private_entries(user_id, content, created_at) VALUES(?, ?, ?)`, u.ID, stored, e.CreatedAt)
+		if err != nil {
+			jsonErr(w, http.StatusInternalServerError, "failed to store private entry")
+			return
+		}
+		e.ID, _ = res.LastInsertId()
+		_ = a.logUserAction(u, "create_private_entry", fmt.Sprintf("private_id=%d size=%d sealed=%t", e.ID, len(content), key != nil))
+		resp := map[string]any{"id": e.ID, "content": e.Content, "created_at": e.CreatedAt, "sealed": key != nil}
+		if len(secrets) > 0 {
+			_ = a.logUserAction(u, "secret_detected", fmt.Sprintf("private_id=%d kinds=%s redacted=%t", e.ID, strings.Join(secrets, ","), a.redactSecrets))
+			resp["secrets_detected"] = secrets
+			resp["redacted"] = a.redactSecrets
+		}
+		jsonOut(w, http.StatusCreated, resp)
+	default:
+		jsonErr(w, http.StatusMethodNotAllowed, "method not allowed")
+	}
+}
+
+// handleMyPrivateEntry deletes (DELETE) one of the caller's private entries.
+// No passphrase is needed; other users' entries answer 404.
+func (a *App) handleMyPrivateEntry(w http.ResponseWriter, r *http.Request, u AuthedUser) {
+	if u.ImpersonatedBy != "" {
+		jsonErr(w, http.StatusForbidden, privateImpersonationMsg)
+		return
+	}
+	if r.Method != http.MethodDelete {
+		jsonErr(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil || id <= 0 {
+		jsonErr(w, http.StatusBadRequest, "invalid private entry id")
+		return
+	}
+	res, err := a.db.Exec(`DELETE FROM private_entries WHERE id = ? AND user_id = ?`, id, u.ID)
+	if err != nil {
+		jsonErr(w, http.StatusInternalServerError, "failed to delete private entry")
+		return
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		jsonErr(w, http.StatusNotFound, "private entry not found")
+		return
+	}
+	_ = a.logUserAction(u, "delete_private_entry", fmt.Sprintf("private_id=%d", id))
+	jsonOut(w, http.StatusOK, map[string]any{"id": id, "status": "deleted"})
+}
+
+// handleMyPrivateKey reports (GET), sets or changes (PUT {"passphrase"}) or
+// removes (DELETE) the caller's private entry encryption. Changing or
+// removing it needs the current passphrase in X-Private-Passphrase.
+func (a *App) handleMyPrivateKey(w http.ResponseWriter, r *http.Request, u AuthedUser) {
+	if u.ImpersonatedBy != "" {
+		jsonErr(w, http.StatusForbidden, privateImpersonationMsg)
+		return
+	}
+	cur, encrypted, err := a.loadPrivateKey(u.ID)
+	if err != nil {
+		jsonErr(w, http.StatusInternalServerError, "failed to read private key")
+		return
+	}
+	switch r.Method {
+	case http.MethodGet:
+		resp := map[string]any{"encrypted": encrypted}
+		if encrypted {
+			resp["updated_at"] = cur.UpdatedAt
+		}
+		jsonOut(w, http.StatusOK, resp)
+		return
+	case http.MethodPut, http.MethodDelete:
+	default:
+		jsonErr(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	// Opening the current key and wrapping a new one both derive from a
+	// passphrase; one request is charged once.
+	passphrase := r.Header.Get(privatePassphraseHeader)
+	if passphrase != "" || r.Method == http.MethodPut {
+		if err := a.allowPassphrase(u); err != nil {
+			privateKeyErr(w, err)
+			return
+		}
+	}
+	var dataKey []byte
+	if encrypted {
+		if dataKey, err = cur.open(u.ID, passphrase); err != nil {
+			privateKeyErr(w, err)
+			return
+		}
+	} else if r.Method == http.MethodDelete {
+		jsonErr(w, http.StatusNotFound, "private entries are not encrypted")
+		return
+	}
+	var next privateKeyRow
+	if r.Method == http.MethodPut {
+		var req struct {
+			Passphrase string `json:"passphrase"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			jsonErr(w, http.StatusBadRequest, "invalid json")
+			return
+		}
+		if len(req.Passphrase) < minPassphraseLen {
+			jsonErr(w, http.StatusBadRequest, fmt.Sprintf("passphrase must be at least %d bytes", minPassphraseLen))
+			return
+		}
+		if dataKey == nil {
+			dataKey = make([]byte, 32)
+			if _, err := rand.Read(dataKey); err != nil {
+				jsonErr(w, http.StatusInternalServerError, "failed to store private key")
+				return
+			}
+		}
+		if next, err = wrapPrivateKey(u.ID, req.Passphrase, dataKey); err != nil {
+			jsonErr(w, http.StatusInternalServerError, "failed to store private key")
+			return
+		}
+	}
+
+	resealed, err := a.rekeyPrivateEntries(u.ID, cur, encrypted, dataKey, next)
+	if errors.Is(err, errPrivateKeyChanged) {
+		jsonErr(w, http.StatusConflict, err.Error())
+		return
+	}
+	if err != nil {
+		a.logger.Printf("event=private_key_update_failed user=%s err=%v", u.Username, err)
+		jsonErr(w, http.StatusInternalServerError, "failed to store private key")
+		return
+	}
+	action := "remove_private_key"
+	switch {
+	case r.Method == http.MethodPut && encrypted:
+		action = "change_private_key"
+	case r.Method == http.MethodPut:
+		action = "set_private_key"
+	}
+	_ = a.logUserAction(u, action, fmt.Sprintf("entries=%d", resealed))
+	jsonOut(w, http.StatusOK, map[string]any{"encrypted": r.Method == http.MethodPut, "entries": resealed})
+}
+
+var errPrivateKeyChanged = errors.New("the private key changed during the request, try again")
+
+// rekeyPrivateEntries applies a key change in one transaction. With next set
+// the key row becomes next, and plaintext entries are sealed with dataKey
+// when encryption is being turned on. Without next the key row is removed
+// and every sealed entry is opened back to plaintext. cur/encrypted are the
+// row the caller verified the passphrase against; the passphrase work runs
+// before the transaction, so a row changed meanwhile is refused.
+func (a *App) rekeyPrivateEntries(userID int64, cur privateKeyRow, encrypted bool, dataKey []byte, next privateKeyRow) (int, error) {
+	tx, err := a.db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer func() { _ = tx.Rollback() }()
+	var wrapped string
+	err = tx.QueryRow(`SELECT wrapped_key FROM private_keys WHERE user_id = ?`, userID).Scan(&wrapped)
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		if encrypted {
+			return 0, errPrivateKeyChanged
+		}
+	case err != nil:
+		return 0, err
+	case !encrypted || wrapped != cur.Wrapped:
+		return 0, errPrivateKeyChanged
+	}
+
+	type row struct {
+		id      int64
+		content string
+	}
+	var rows []row
+	if next.Wrapped == "" || !encrypted {
+		rs, err := tx.Query(`SELECT id, content FROM private_entries WHERE user_id = ? ORDER BY id`, userID)
+		if err != nil {
+			return 0, err
+		}
+		for rs.Next() {
+			var r row
+			if err := rs.Scan(&r.id, &r.content); err != nil {
+				_ = rs.Close()
+				return 0, err
+			}
+			rows = append(rows, r)
+		}
+		if err := rs.Err(); err != nil {
+			_ = rs.Close()
+			return 0, err
+		}
+		_ = rs.Close()
+	}
+	changed := 0
+	for _, r := range rows {
+		var content string
+		switch {
+		case next.Wrapped == "" && isSealed(r.content):
+			content, err = openString(dataKey, privateEntryAAD(userID), r.content)
+		case next.Wrapped != "" && !isSealed(r.content):
+			content, err = sealString(dataKey, privateEntryAAD(userID), r.content)
+		default:
+			continue
+		}
+		if err != nil {
+			return 0, fmt.Errorf("private entry %d: %w", r.id, err)
+		}
+		if _, err := tx.Exec(`UPDATE private_entries SET content = ? WHERE id = ?`, content, r.id); err != nil {
+			return 0, err
+		}
+		changed++
+	}
+
+	if next.Wrapped == "" {
+		_, err = tx.Exec(`DELETE FROM private_keys WHERE user_id = ?`, userID)
+	} else {
+		_, err = tx.Exec(`
+INSERT INTO private_keys(user_id, salt, iterations, wrapped_key, created_at, updated_at) VALUES(?, ?, ?, ?, ?, ?)
+ON CONFLICT(user_id) DO UPDATE SET salt = excluded.salt, iterations = excluded.iterations, wrapped_key = excluded.wrapped_key, updated_at = excluded.updated_at`,
+			userID, next.Salt, next.Iterations, next.Wrapped, next.UpdatedAt, next.UpdatedAt)
+	}
+	if err != nil {
+		return 0, err
+	}
+	return changed, tx.Commit()
+}
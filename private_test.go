@@ -0,0 +1,304 @@
+package main
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fastPrivateKeys lowers the PBKDF2 work factor for the test.
+func fastPrivateKeys(t *testing.T) {
+	old := privateKeyIterations
+	privateKeyIterations = 1000
+	t.Cleanup(func() { privateKeyIterations = old })
+}
+
+func privateReq(t *testing.T, method, path string, body any, token, passphrase string) *http.Request {
+	t.Helper()
+	req := authedReq(t, method, path, body, token)
+	if passphrase != "" {
+		req.Header.Set(privatePassphraseHeader, passphrase)
+	}
+	return req
+}
+
+func listPrivate(t *testing.T, h http.Handler, token, passphrase string) (int, []privateEntry) {
+	t.Helper()
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, privateReq(t, http.MethodGet, "/api/me/private-entries", nil, token, passphrase))
+	var resp struct {
+		Entries []privateEntry `json:"entries"`
+	}
+	_ = json.Unmarshal(rr.Body.Bytes(), &resp)
+	return rr.Code, resp.Entries
+}
+
+func TestPrivateEntries(t *testing.T) {
+	fastPrivateKeys(t)
+	app := newTestApp(t)
+	h := newTestMux(app)
+	createUser(t, app, "alice", "PUDPRIVALI1")
+	createUser(t, app, "bob", "PUDPRIVBOB1")
+
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, privateReq(t, http.MethodPost, "/api/me/private-entries", map[string]string{"content": "1:1 notes: ask about promo"}, "PUDPRIVALI1", ""))
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("create: %d %s", rr.Code, rr.Body.String())
+	}
+	if code, got := listPrivate(t, h, "PUDPRIVALI1", ""); code != http.StatusOK || len(got) != 1 || got[0].Content != "1:1 notes: ask about promo" {
+		t.Fatalf("alice's list: %d %+v", code, got)
+	}
+	// Nobody else sees them, on their own list or the team's.
+	if code, got := listPrivate(t, h, "PUDPRIVBOB1", ""); code != http.StatusOK || len(got) != 0 {
+		t.Fatalf("bob's list: %d %+v", code, got)
+	}
+	rr = httptest.NewRecorder()
+	h.ServeHTTP(rr, authedReq(t, http.MethodGet, "/api/entries", nil, "PUDPRIVBOB1"))
+	if strings.Contains(rr.Body.String(), "promo") {
+		t.Fatalf("private entry in the team list: %s", rr.Body.String())
+	}
+	var id int64
+	if err := app.db.QueryRow(`SELECT id FROM private_entries`).Scan(&id); err != nil {
+		t.Fatal(err)
+	}
+	rr = httptest.NewRecorder()
+	h.ServeHTTP(rr, authedReq(t, http.MethodDelete, "/api/me/private-entries/"+strconv.FormatInt(id, 10), nil, "PUDPRIVBOB1"))
+	if rr.Code != http.StatusNotFound {
+		t.Fatalf("bob deleting alice's entry: %d", rr.Code)
+	}
+
+	// An admin impersonating alice is refused.
+	if _, err := app.db.Exec(`UPDATE users SET role = 'admin' WHERE username = 'bob'`); err != nil {
+		t.Fatal(err)
+	}
+	req := authedReq(t, http.MethodGet, "/api/me/private-entries", nil, "PUDPRIVBOB1")
+	req.Header.Set("X-Impersonate-User", "alice")
+	rr = httptest.NewRecorder()
+	h.ServeHTTP(rr, req)
+	if rr.Code != http.StatusForbidden {
+		t.Fatalf("impersonated list: %d %s", rr.Code, rr.Body.String())
+	}
+
+	rr = httptest.NewRecorder()
+	h.ServeHTTP(rr, authedReq(t, http.MethodDelete, "/api/me/private-entries/"+strconv.FormatInt(id, 10), nil, "PUDPRIVALI1"))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("delete: %d %s", rr.Code, rr.Body.String())
+	}
+	var audited int
+	if err := app.db.QueryRow(`SELECT COUNT(*) FROM action_logs WHERE action IN ('create_private_entry', 'delete_private_entry') AND metadata NOT LIKE '%promo%'`).Scan(&audited); err != nil || audited != 2 {
+		t.Fatalf("audit rows = %d, %v", audited, err)
+	}
+
+	for _, tc := range []struct {
+		name   string
+		method string
+		path   string
+		body   any
+		want   int
+	}{
+		{"empty content", http.MethodPost, "/api/me/private-entries", map[string]string{"content": " "}, http.StatusBadRequest},
+		{"too large", http.MethodPost, "/api/me/private-entries", map[string]string{"content": strings.Repeat("x", defaultMaxEntrySize+1)}, http.StatusBadRequest},
+		{"invalid json", http.MethodPost, "/api/me/private-entries", []string{"x"}, http.StatusBadRequest},
+		{"invalid day", http.MethodGet, "/api/me/private-entries?day=tuesday", nil, http.StatusBadRequest},
+		{"invalid id", http.MethodDelete, "/api/me/private-entries/x", nil, http.StatusBadRequest},
+		{"method", http.MethodPut, "/api/me/private-entries", nil, http.StatusMethodNotAllowed},
+		{"not encrypted", http.MethodDelete, "/api/me/private-key", nil, http.StatusNotFound},
+		{"short passphrase", http.MethodPut, "/api/me/private-key", map[string]string{"passphrase": "short"}, http.StatusBadRequest},
+	} {
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, authedReq(t, tc.method, tc.path, tc.body, "PUDPRIVALI1"))
+		if rr.Code != tc.want {
+			t.Errorf("%s: %d, want %d: %s", tc.name, rr.Code, tc.want, rr.Body.String())
+		}
+	}
+}
+
+func TestPrivateEntryEncryption(t *testing.T) {
+	fastPrivateKeys(t)
+	app := newTestApp(t)
+	h := newTestMux(app)
+	createUser(t, app, "alice", "PUDPRIVENC1")
+	const pass, next = "correct horse battery", "staple staple staple"
+	do := func(method, path string, body any, passphrase string) *httptest.ResponseRecorder {
+		t.Helper()
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, privateReq(t, method, path, body, "PUDPRIVENC1", passphrase))
+		return rr
+	}
+	storedContent := func() []string {
+		t.Helper()
+		rows, err := app.db.Query(`SELECT content FROM private_entries ORDER BY id`)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer rows.Close()
+		var out []string
+		for rows.Next() {
+			var c string
+			_ = rows.Scan(&c)
+			out = append(out, c)
+		}
+		return out
+	}
+
+	if rr := do(http.MethodPost, "/api/me/private-entries", map[string]string{"content": "written before encryption"}, ""); rr.Code != http.StatusCreated {
+		t.Fatalf("create: %d %s", rr.Code, rr.Body.String())
+	}
+	// Turning encryption on seals what is already there.
+	if rr := do(http.MethodPut, "/api/me/private-key", map[string]string{"passphrase": pass}, ""); rr.Code != http.StatusOK || !strings.Contains(rr.Body.String(), `"entries":1`) {
+		t.Fatalf("set key: %d %s", rr.Code, rr.Body.String())
+	}
+	if rr := do(http.MethodPost, "/api/me/private-entries", map[string]string{"content": "written after"}, pass); rr.Code != http.StatusCreated || !strings.Contains(rr.Body.String(), `"sealed":true`) {
+		t.Fatalf("sealed create: %d %s", rr.Code, rr.Body.String())
+	}
+	for _, c := range storedContent() {
+		if !isSealed(c) || strings.Contains(c, "written") {
+			t.Fatalf("stored in the clear: %q", c)
+		}
+	}
+	var wrapped string
+	if err := app.db.QueryRow(`SELECT wrapped_key FROM private_keys`).Scan(&wrapped); err != nil || !isSealed(wrapped) {
+		t.Fatalf("wrapped key %q, %v", wrapped, err)
+	}
+
+	if code, got := listPrivate(t, h, "PUDPRIVENC1", pass); code != http.StatusOK || len(got) != 2 || got[0].Content != "written after" || got[1].Content != "written before encryption" {
+		t.Fatalf("list with passphrase: %d %+v", code, got)
+	}
+	if code, got := listPrivate(t, h, "PUDPRIVENC1", ""); code != http.StatusOK || len(got) != 2 || !got[0].Sealed || got[0].Content != "" {
+		t.Fatalf("list without passphrase: %d %+v", code, got)
+	}
+
+	for _, tc := range []struct {
+		name       string
+		method     string
+		path       string
+		body       any
+		passphrase string
+		want       int
+	}{
+		{"list, wrong passphrase", http.MethodGet, "/api/me/private-entries", nil, "not the passphrase", http.StatusForbidden},
+		{"create, no passphrase", http.MethodPost, "/api/me/private-entries", map[string]string{"content": "x"}, "", http.StatusBadRequest},
+		{"create, wrong passphrase", http.MethodPost, "/api/me/private-entries", map[string]string{"content": "x"}, "not the passphrase", http.StatusForbidden},
+		{"change, no passphrase", http.MethodPut, "/api/me/private-key", map[string]string{"passphrase": next}, "", http.StatusBadRequest},
+		{"change, wrong passphrase", http.MethodPut, "/api/me/private-key", map[string]string{"passphrase": next}, "not the passphrase", http.StatusForbidden},
+		{"remove, wrong passphrase", http.MethodDelete, "/api/me/private-key", nil, "not the passphrase", http.StatusForbidden},
+	} {
+		if rr := do(tc.method, tc.path, tc.body, tc.passphrase); rr.Code != tc.want {
+			t.Errorf("%s: %d, want %d: %s", tc.name, rr.Code, tc.want, rr.Body.String())
+		}
+	}
+
+	// Changing the passphrase rewraps the key; the entries are untouched.
+	before := storedContent()
+	if rr := do(http.MethodPut, "/api/me/private-key", map[string]string{"passphrase": next}, pass); rr.Code != http.StatusOK {
+		t.Fatalf("change key: %d %s", rr.Code, rr.Body.String())
+	}
+	if strings.Join(storedContent(), ",") != strings.Join(before, ",") {
+		t.Fatal("changing the passphrase rewrote the entries")
+	}
+	if code, _ := listPrivate(t, h, "PUDPRIVENC1", pass); code != http.StatusForbidden {
+		t.Fatalf("old passphrase after change: %d", code)
+	}
+	if code, got := listPrivate(t, h, "PUDPRIVENC1", next); code != http.StatusOK || len(got) != 2 || got[1].Content != "written before encryption" {
+		t.Fatalf("list with new passphrase: %d %+v", code, got)
+	}
+
+	// Removing the key opens everything back up.
+	if rr := do(http.MethodDelete, "/api/me/private-key", nil, next); rr.Code != http.StatusOK || !strings.Contains(rr.Body.String(), `"entries":2`) {
+		t.Fatalf("remove key: %d %s", rr.Code, rr.Body.String())
+	}
+	if got := strings.Join(storedContent(), ","); got != "written before encryption,written after" {
+		t.Fatalf("after removal: %q", got)
+	}
+	var actions string
+	if err := app.db.QueryRow(`SELECT group_concat(action) FROM action_logs WHERE action LIKE '%private_key'`).Scan(&actions); err != nil || actions != "set_private_key,change_private_key,remove_private_key" {
+		t.Fatalf("key audit = %q, %v", actions, err)
+	}
+}
+
+// Passphrase derivations are capped per user, so a stolen token cannot
+// guess passphrases or keep the CPU busy with PBKDF2.
+func TestPrivatePassphraseRateLimit(t *testing.T) {
+	fastPrivateKeys(t)
+	app := newTestApp(t)
+	app.privateKeyLimit = newClientRateLimiter(3, time.Minute)
+	h := newTestMux(app)
+	createUser(t, app, "alice", "PUDPRIVLIM1")
+	createUser(t, app, "bob", "PUDPRIVLIM2")
+	const pass = "correct horse battery"
+	do := func(method, path string, body any, token, passphrase string) *httptest.ResponseRecorder {
+		t.Helper()
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, privateReq(t, method, path, body, token, passphrase))
+		return rr
+	}
+	// Setting the key is the first derivation.
+	if rr := do(http.MethodPut, "/api/me/private-key", map[string]string{"passphrase": pass}, "PUDPRIVLIM1", ""); rr.Code != http.StatusOK {
+		t.Fatalf("set key: %d %s", rr.Code, rr.Body.String())
+	}
+	for i, want := range []int{http.StatusForbidden, http.StatusOK, http.StatusTooManyRequests} {
+		p := pass
+		if i == 0 {
+			p = "not the passphrase"
+		}
+		if code, _ := listPrivate(t, h, "PUDPRIVLIM1", p); code != want {
+			t.Fatalf("list %d: %d, want %d", i, code, want)
+		}
+	}
+	rr := do(http.MethodDelete, "/api/me/private-key", nil, "PUDPRIVLIM1", pass)
+	if rr.Code != http.StatusTooManyRequests || rr.Header().Get("Retry-After") == "" {
+		t.Fatalf("remove key over the limit: %d %v", rr.Code, rr.Header())
+	}
+	// Listing without a passphrase derives nothing; other users have their own budget.
+	if code, got := listPrivate(t, h, "PUDPRIVLIM1", ""); code != http.StatusOK || len(got) != 0 {
+		t.Fatalf("list without passphrase: %d %+v", code, got)
+	}
+	if rr := do(http.MethodPut, "/api/me/private-key", map[string]string{"passphrase": pass}, "PUDPRIVLIM2", ""); rr.Code != http.StatusOK {
+		t.Fatalf("bob set key: %d %s", rr.Code, rr.Body.String())
+	}
+}
+
+func TestPrivateEntriesErasedWithUser(t *testing.T) {
+	fastPrivateKeys(t)
+	app := newTestApp(t)
+	h := newTestMux(app)
+	createUser(t, app, "alice", "PUDPRIVERA1")
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, authedReq(t, http.MethodPut, "/api/me/private-key", map[string]string{"passphrase": "correct horse battery"}, "PUDPRIVERA1"))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("set key: %d %s", rr.Code, rr.Body.String())
+	}
+	rr = httptest.NewRecorder()
+	h.ServeHTTP(rr, privateReq(t, http.MethodPost, "/api/me/private-entries", map[string]string{"content": "note"}, "PUDPRIVERA1", "correct horse battery"))
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("create: %d %s", rr.Code, rr.Body.String())
+	}
+	if _, err := app.eraseUser("alice", "admin", "test"); err != nil {
+		t.Fatalf("eraseUser: %v", err)
+	}
+	var n int
+	if err := app.db.QueryRow(`SELECT (SELECT COUNT(*) FROM private_entries) + (SELECT COUNT(*) FROM private_keys)`).Scan(&n); err != nil || n != 0 {
+		t.Fatalf("rows left after erasure: %d, %v", n, err)
+	}
+}
+
+func TestPBKDF2SHA256(t *testing.T) {
+	// RFC 7914 section 11, PBKDF2-HMAC-SHA256 vectors.
+	for _, tc := range []struct {
+		pass, salt string
+		iter       int
+		want       string
+	}{
+		{"passwd", "salt", 1, "55ac046e56e3089fec1691c22544b605f94185216dde0465e68b9d57c20dacbc49ca9cccf179b645991664b39d77ef317c71b845b1e30bd509112041d3a19783"},
+		{"Password", "NaCl", 80000, "4ddcd8f60b98be21830cee5ef22701f9641a4418d04c0414aeff08876b34ab56a1d425a1225833549adb841b51c9b3176a272bdebba1d078478f62b397f33c8d"},
+	} {
+		if got := hex.EncodeToString(pbkdf2SHA256([]byte(tc.pass), []byte(tc.salt), tc.iter, 64)); got != tc.want {
+			t.Errorf("pbkdf2(%q, %q, %d) = %s", tc.pass, tc.salt, tc.iter, got)
+		}
+	}
+}
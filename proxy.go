@@ -0,0 +1,111 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+	"time"
+)
+
+// defaultTrustedProxies covers the bundled Caddy reverse proxy on loopback.
+const defaultTrustedProxies = "127.0.0.1/32,::1/128"
+
+// lastUsedInterval throttles tokens.last_used_at writes for a token seen again from
+// the same address.
+const lastUsedInterval = time.Minute
+
+// parseTrustedProxies parses --trusted-proxies: comma-separated IPs or CIDRs.
+// An empty value trusts no peer, so forwarding headers are always ignored.
+func parseTrustedProxies(v string) ([]netip.Prefix, error) {
+	var out []netip.Prefix
+	for _, item := range splitList(v) {
+		if p, err := netip.ParsePrefix(item); err == nil {
+			out = append(out, p.Masked())
+			continue
+		}
+		addr, err := netip.ParseAddr(item)
+		if err != nil {
+			return nil, fmt.Errorf("invalid --trusted-proxies entry %q (want an IP or CIDR)", item)
+		}
+		addr = addr.Unmap()
+		out = append(out, netip.PrefixFrom(addr, addr.BitLen()))
+	}
+	return out, nil
+}
+
+func (a *App) trustedProxy(addr netip.Addr) bool {
+	for _, p := range a.trustedProxies {
+		if p.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// parseHop parses one address from RemoteAddr or a forwarding header, with
+// or without a port.
+func parseHop(s string) (netip.Addr, bool) {
+	s = strings.TrimSpace(s)
+	if ap, err := netip.ParseAddrPort(s); err == nil {
+		return ap.Addr().Unmap(), true
+	}
+	if host, _, err := net.SplitHostPort(s); err == nil {
+		s = host
+	}
+	addr, err := netip.ParseAddr(strings.Trim(s, "[]"))
+	if err != nil {
+		return netip.Addr{}, false
+	}
+	return addr.Unmap(), true
+}
+
+// clientIP returns the caller's address for rate limits, audit rows and
+// last-used tracking. Forwarding headers are only honored when the peer is a
+// trusted proxy: X-Forwarded-For is walked right to left past trusted hops
+// and the first untrusted one wins, so a client cannot spoof its address by
+// prepending entries. X-Real-IP is the fallback when X-Forwarded-For is absent.
+func (a *App) clientIP(r *http.Request) string {
+	peer, ok := parseHop(r.RemoteAddr)
+	if !ok {
+		return r.RemoteAddr
+	}
+	if !a.trustedProxy(peer) {
+		return peer.String()
+	}
+	var hops []string
+	for _, v := range r.Header.Values("X-Forwarded-For") {
+		hops = append(hops, strings.Split(v, ",")...)
+	}
+	if len(hops) == 0 {
+		if real, ok := parseHop(r.Header.Get("X-Real-IP")); ok {
+			return real.String()
+		}
+		return peer.String()
+	}
+	client := peer
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop, ok := parseHop(hops[i])
+		if !ok {
+			// Garbage left of a trusted hop: keep the last address we can vouch for.
+			break
+		}
+		client = hop
+		if !a.trustedProxy(hop) {
+			break
+		}
+	}
+	return client.String()
+}
+
+// touchLastUsed records when and from where token tokenID was last used.
+// Repeat requests from the same address within lastUsedInterval skip the write.
+func (a *App) touchLastUsed(tokenID int64, ip string, now time.Time) {
+	cutoff := now.Add(-lastUsedInterval).UTC().Format(time.RFC3339)
+	_, err := a.db.Exec(`UPDATE tokens SET last_used_at = ?, last_used_ip = ? WHERE id = ? AND (last_used_ip != ? OR last_used_at IS NULL OR last_used_at < ?)`,
+		now.UTC().Format(time.RFC3339), ip, tokenID, ip, cutoff)
+	if err != nil {
+		a.logger.Printf("event=last_used_failed token_id=%d err=%v", tokenID, err)
+	}
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestTrustedProxyClientIP(t *testing.T) {
+	app := newTestApp(t)
+	proxies, err := parseTrustedProxies("127.0.0.1, 10.0.0.0/8")
+	if err != nil {
+		t.Fatalf("parseTrustedProxies: %v", err)
+	}
+	app.trustedProxies = proxies
+	if _, err := parseTrustedProxies("10.0.0.0/33"); err == nil {
+		t.Fatal("expected an error for an invalid CIDR")
+	}
+
+	cases := []struct {
+		name, remote, xff, realIP, want string
+	}{
+		{"direct client ignores headers", "203.0.113.9:4000", "198.51.100.1", "198.51.100.2", "203.0.113.9"},
+		{"trusted peer without headers", "127.0.0.1:4000", "", "", "127.0.0.1"},
+		{"x-real-ip fallback", "127.0.0.1:4000", "", "198.51.100.2", "198.51.100.2"},
+		{"single hop", "127.0.0.1:4000", "198.51.100.1", "", "198.51.100.1"},
+		{"spoofed prefix is skipped", "127.0.0.1:4000", "6.6.6.6, 198.51.100.1, 10.1.2.3", "", "198.51.100.1"},
+		{"all hops trusted", "127.0.0.1:4000", "10.0.0.5, 10.0.0.6", "", "10.0.0.5"},
+		{"garbage hop", "127.0.0.1:4000", "not-an-ip, 10.0.0.6", "", "10.0.0.6"},
+		{"ipv6 peer untrusted", "[2001:db8::1]:4000", "198.51.100.1", "", "2001:db8::1"},
+	}
+	for _, tc := range cases {
+		r := httptest.NewRequest(http.MethodGet, "/api/me", nil)
+		r.RemoteAddr = tc.remote
+		if tc.xff != "" {
+			r.Header.Set("X-Forwarded-For", tc.xff)
+		}
+		if tc.realIP != "" {
+			r.Header.Set("X-Real-IP", tc.realIP)
+		}
+		if got := app.clientIP(r); got != tc.want {
+			t.Errorf("%s: got %q want %q", tc.name, got, tc.want)
+		}
+	}
+
+	// The resolved address reaches audit rows and last-used tracking.
+	h := newTestMux(app)
+	createUser(t, app, "alice", "PUDPROXYAL1")
+	req := authedReq(t, http.MethodGet, "/api/me", nil, "PUDPROXYAL1")
+	req.RemoteAddr = "127.0.0.1:4000"
+	req.Header.Set("X-Forwarded-For", "198.51.100.7")
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("me: %d %s", rr.Code, rr.Body.String())
+	}
+	var me struct {
+		LastUsedAt string `json:"last_used_at"`
+		LastUsedIP string `json:"last_used_ip"`
+	}
+	if err := json.Unmarshal(rr.Body.Bytes(), &me); err != nil || me.LastUsedIP != "198.51.100.7" || me.LastUsedAt == "" {
+		t.Fatalf("last used: %+v %s", me, rr.Body.String())
+	}
+	var ip string
+	if err := app.db.QueryRow(`SELECT client_ip FROM action_logs WHERE action = 'whoami' ORDER BY id DESC LIMIT 1`).Scan(&ip); err != nil || ip != "198.51.100.7" {
+		t.Fatalf("audit client_ip: %q %v", ip, err)
+	}
+	if n, problems, err := app.verifyAuditChain(); err != nil || len(problems) > 0 || n == 0 {
+		t.Fatalf("audit chain: n=%d problems=%v err=%v", n, problems, err)
+	}
+}
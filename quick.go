@@ -0,0 +1,111 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"mime"
+	"net/http"
+	"net/url"
+	"strings"
+	"unicode/utf8"
+)
+
+// quickPost is what a bookmarklet or browser extension sends: a note plus the
+// page it was written on.
+type quickPost struct {
+	Content string `json:"content"`
+	URL     string `json:"url"`
+	Title   string `json:"title"`
+}
+
+// readQuickPost accepts query parameters (GET bookmarklets), form posts and
+// JSON bodies. The body is capped well above the max entry size so oversized
+// selections are truncated rather than rejected.
+func readQuickPost(w http.ResponseWriter, r *http.Request) (quickPost, error) {
+	var q quickPost
+	if r.Method == http.MethodGet {
+		v := r.URL.Query()
+		return quickPost{Content: v.Get("content"), URL: v.Get("url"), Title: v.Get("title")}, nil
+	}
+	r.Body = http.MaxBytesReader(w, r.Body, 1<<20)
+	ct, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	switch ct {
+	case "application/x-www-form-urlencoded", "multipart/form-data":
+		if err := r.ParseMultipartForm(1 << 20); err != nil && !errors.Is(err, http.ErrNotMultipart) {
+			return q, err
+		}
+		return quickPost{Content: r.FormValue("content"), URL: r.FormValue("url"), Title: r.FormValue("title")}, nil
+	default:
+		err := json.NewDecoder(r.Body).Decode(&q)
+		return q, err
+	}
+}
+
+// quickContent formats a quick post as entry text: the note, then the page as
+// a Markdown link. Content is cut to maxSize on a rune boundary.
+func quickContent(q quickPost, maxSize int) (string, bool) {
+	note := strings.TrimSpace(q.Content)
+	title := strings.Join(strings.Fields(q.Title), " ")
+	link := ""
+	if u, err := url.Parse(strings.TrimSpace(q.URL)); err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != "" {
+		if title == "" {
+			title = u.Host + u.Path
+		}
+		link = "[" + strings.NewReplacer("[", "(", "]", ")").Replace(title) + "](" + u.String() + ")"
+	} else if title != "" {
+		link = title
+	}
+
+	var content string
+	switch {
+	case note == "":
+		content = link
+	case link == "":
+		content = note
+	default:
+		content = note + "\n" + link
+	}
+	if len(content) <= maxSize {
+		return content, false
+	}
+	// Trim the note and keep the link intact, unless the link alone is huge.
+	keep, suffix := note, ""
+	if link != "" && len(link) < maxSize/2 {
+		suffix = "\n" + link
+	} else {
+		keep = content
+	}
+	cut := maxSize - len("…") - len(suffix)
+	for cut > 0 && !utf8.RuneStart(keep[cut]) {
+		cut--
+	}
+	return keep[:cut] + "…" + suffix, true
+}
+
+// handleQuick is the bookmarklet/extension endpoint: GET or POST with the
+// token in a header, "read this / fixed this" style notes about a page.
+func (a *App) handleQuick(w http.ResponseWriter, r *http.Request, u AuthedUser) {
+	if r.Method != http.MethodGet && r.Method != http.MethodPost {
+		jsonErr(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	q, err := readQuickPost(w, r)
+	if err != nil {
+		jsonErr(w, http.StatusBadRequest, "invalid request body")
+		return
+	}
+	content, truncated := quickContent(q, a.settings().MaxEntrySize)
+	if content == "" {
+		jsonErr(w, http.StatusBadRequest, "content or url is required")
+		return
+	}
+	status, resp, err := a.storeUserEntry(u, content, "", sourceWeb)
+	if err != nil {
+		jsonErr(w, status, err.Error())
+		return
+	}
+	if truncated {
+		resp["truncated"] = true
+	}
+	jsonOut(w, status, resp)
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+func TestAPIQuickPost(t *testing.T) {
+	app := newTestApp(t)
+	h := newTestMux(app)
+	createUser(t, app, "alice", "PUDABCDEF12")
+
+	req := authedReq(t, http.MethodGet, "/api/quick?content=read+this&url=https%3A%2F%2Fgo.dev%2Fblog%2Fsqlite&title=SQLite+%5Bnotes%5D", nil, "PUDABCDEF12")
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, req)
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("expected 201, got %d body=%s", rr.Code, rr.Body.String())
+	}
+
+	long := strings.Repeat("é", defaultMaxEntrySize)
+	rr = httptest.NewRecorder()
+	h.ServeHTTP(rr, authedReq(t, http.MethodPost, "/api/quick", map[string]string{"content": long, "url": "https://example.com/x"}, "PUDABCDEF12"))
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("expected 201 for oversized post, got %d body=%s", rr.Code, rr.Body.String())
+	}
+	var resp map[string]any
+	if err := json.Unmarshal(rr.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("unmarshal: %v", err)
+	}
+	if resp["truncated"] != true {
+		t.Fatalf("expected truncated=true, got %v", resp)
+	}
+
+	rows, err := app.db.Query(`SELECT content FROM entries ORDER BY id ASC`)
+	if err != nil {
+		t.Fatalf("query: %v", err)
+	}
+	defer rows.Close()
+	var contents []string
+	for rows.Next() {
+		var c string
+		if err := rows.Scan(&c); err != nil {
+			t.Fatalf("scan: %v", err)
+		}
+		contents = append(contents, c)
+	}
+	if len(contents) != 2 {
+		t.Fatalf("expected 2 entries, got %d", len(contents))
+	}
+	if contents[0] != "read this\n[SQLite (notes)](https://go.dev/blog/sqlite)" {
+		t.Fatalf("unexpected quick entry %q", contents[0])
+	}
+	if len(contents[1]) > defaultMaxEntrySize || !utf8.ValidString(contents[1]) || !strings.HasSuffix(contents[1], "…\n[example.com/x](https://example.com/x)") {
+		t.Fatalf("unexpected truncated entry (len=%d) suffix %q", len(contents[1]), contents[1][len(contents[1])-60:])
+	}
+}
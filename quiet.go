@@ -0,0 +1,263 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Quiet hours: non-critical notifications addressed to a user (mentions)
+// that fall inside the user's quiet window are held in
+// deferred_notifications and delivered when the window ends. A user's own
+// window (/api/me/quiet-hours) wins over the org's quiet_hours setting; the
+// window is read in the user's profile time zone, else the org's, else the
+// server's.
+
+const (
+	subsystemQuietHours  = "quiet_hours"
+	quietReleaseInterval = time.Minute
+)
+
+// quietEvents are the event types quiet hours delay; anything else is
+// delivered right away.
+var quietEvents = []string{"mention"}
+
+// quietHours is a daily local window; start == end (or both empty) means
+// none. A window may wrap midnight (22:00-07:00).
+type quietHours struct {
+	Start    string `json:"start"`
+	End      string `json:"end"`
+	Timezone string `json:"timezone"`
+}
+
+// normalize validates q in place, clearing a window whose start equals its end.
+func (q *quietHours) normalize() error {
+	q.Start, q.End, q.Timezone = strings.TrimSpace(q.Start), strings.TrimSpace(q.End), strings.TrimSpace(q.Timezone)
+	if (q.Start == "") != (q.End == "") {
+		return errors.New("quiet hours need both start and end (HH:MM), or neither")
+	}
+	for _, v := range []string{q.Start, q.End} {
+		if v == "" {
+			continue
+		}
+		if _, err := time.Parse("15:04", v); err != nil {
+			return fmt.Errorf("invalid time %q (want HH:MM)", v)
+		}
+	}
+	if q.Start == q.End {
+		q.Start, q.End = "", ""
+	}
+	if q.Timezone != "" {
+		if _, err := time.LoadLocation(q.Timezone); err != nil {
+			return fmt.Errorf("unknown timezone %q", q.Timezone)
+		}
+	}
+	return nil
+}
+
+// quietUntil returns when the window around now ends, or the zero time when
+// now is outside it.
+func quietUntil(now time.Time, start, end string, loc *time.Location) time.Time {
+	s, err1 := time.Parse("15:04", start)
+	e, err2 := time.Parse("15:04", end)
+	if err1 != nil || err2 != nil {
+		return time.Time{}
+	}
+	local := now.In(loc)
+	y, mo, d := local.Date()
+	m := local.Hour()*60 + local.Minute()
+	sm, em := s.Hour()*60+s.Minute(), e.Hour()*60+e.Minute()
+	at := func(day int) time.Time { return time.Date(y, mo, day, e.Hour(), e.Minute(), 0, 0, loc) }
+	switch {
+	case sm == em:
+		return time.Time{}
+	case sm < em && m >= sm && m < em:
+		return at(d)
+	case sm > em && m >= sm:
+		return at(d + 1)
+	case sm > em && m < em:
+		return at(d)
+	}
+	return time.Time{}
+}
+
+// effectiveQuietHours combines a user's window and time zone with the org
+// setting; source is "user", "org" or "" when no window applies.
+func (a *App) effectiveQuietHours(username string) (q quietHours, source string, err error) {
+	var user quietHours
+	err = a.db.QueryRow(`SELECT quiet_start, quiet_end, timezone FROM users WHERE username = ? AND kind = ?`, username, kindHuman).
+		Scan(&user.Start, &user.End, &user.Timezone)
+	if err != nil {
+		return q, "", err
+	}
+	org := a.settings().QuietHours
+	switch {
+	case user.Start != "":
+		q, source = user, "user"
+	case org.Start != "":
+		q, source = org, "org"
+	default:
+		return quietHours{Timezone: user.Timezone}, "", nil
+	}
+	q.Timezone = user.Timezone
+	if q.Timezone == "" {
+		q.Timezone = org.Timezone
+	}
+	return q, source, nil
+}
+
+func quietLocation(tz string) *time.Location {
+	if tz == "" {
+		return time.Local
+	}
+	loc, err := time.LoadLocation(tz)
+	if err != nil {
+		return time.Local
+	}
+	return loc
+}
+
+// deferNotification is the dispatcher's defer hook: it stores a quiet-hours
+// event for later delivery and reports whether it did.
+func (a *App) deferNotification(ev IntegrationEvent) bool {
+	if !containsString(quietEvents, ev.Type) {
+		return false
+	}
+	q, source, err := a.effectiveQuietHours(ev.Recipient)
+	if err != nil || source == "" {
+		return false
+	}
+	until := quietUntil(time.Now(), q.Start, q.End, quietLocation(q.Timezone))
+	if until.IsZero() {
+		return false
+	}
+	payload, err := json.Marshal(ev)
+	if err != nil {
+		return false
+	}
+	release := until.UTC().Format(time.RFC3339)
+	if _, err := a.db.Exec(`INSERT INTO deferred_notifications(recipient, event_type, payload, release_at, created_at) VALUES(?, ?, ?, ?, ?)`,
+		ev.Recipient, ev.Type, string(payload), release, nowUTC()); err != nil {
+		a.logger.Printf("event=notification_defer_failed type=%s recipient=%s err=%v", ev.Type, ev.Recipient, err)
+		return false
+	}
+	a.logger.Printf("event=notification_deferred type=%s recipient=%s until=%s source=%s", ev.Type, ev.Recipient, release, source)
+	return true
+}
+
+// releaseDeferred dispatches the held notifications that are due, oldest
+// first, and returns how many it sent.
+func (a *App) releaseDeferred(now time.Time) (int, error) {
+	rows, err := a.db.Query(`SELECT id, payload FROM deferred_notifications WHERE release_at <= ? ORDER BY id ASC`, now.UTC().Format(time.RFC3339))
+	if err != nil {
+		return 0, err
+	}
+	type held struct {
+		id      int64
+		payload string
+	}
+	var due []held
+	for rows.Next() {
+		var h held
+		if err := rows.Scan(&h.id, &h.payload); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		due = append(due, h)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+	sent := 0
+	for _, h := range due {
+		var ev IntegrationEvent
+		if err := json.Unmarshal([]byte(h.payload), &ev); err == nil {
+			ev.released = true
+			a.dispatcher.Dispatch(ev)
+			sent++
+		}
+		if _, err := a.db.Exec(`DELETE FROM deferred_notifications WHERE id = ?`, h.id); err != nil {
+			return sent, err
+		}
+	}
+	return sent, nil
+}
+
+func (a *App) quietHoursLoop(ctx context.Context) {
+	ticker := time.NewTicker(quietReleaseInterval)
+	defer ticker.Stop()
+	a.health.register(subsystemQuietHours, quietReleaseInterval, time.Now())
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			n, err := a.releaseDeferred(time.Now())
+			if err != nil {
+				a.logger.Printf("event=notification_release_failed err=%v", err)
+			} else if n > 0 {
+				a.logger.Printf("event=notifications_released count=%d", n)
+			}
+			a.health.record(subsystemQuietHours, time.Now(), err)
+		}
+	}
+}
+
+// handleMyQuietHours serves GET|PUT /api/me/quiet-hours
+// {"start":"22:00","end":"07:00","timezone":"Europe/Berlin"}.
+func (a *App) handleMyQuietHours(w http.ResponseWriter, r *http.Request, u AuthedUser) {
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPut:
+		var q quietHours
+		if err := json.NewDecoder(r.Body).Decode(&q); err != nil {
+			jsonErr(w, http.StatusBadRequest, "invalid json")
+			return
+		}
+		if err := q.normalize(); err != nil {
+			jsonErr(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		if _, err := a.db.Exec(`UPDATE users SET quiet_start = ?, quiet_end = ?, timezone = ? WHERE id = ?`, q.Start, q.End, q.Timezone, u.ID); err != nil {
+			jsonErr(w, http.StatusInternalServerError, "failed to store quiet hours")
+			return
+		}
+		_ = a.logUserAction(u, "update_quiet_hours", fmt.Sprintf("start=%s end=%s timezone=%s", q.Start, q.End, q.Timezone))
+	default:
+		jsonErr(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	var own quietHours
+	if err := a.db.QueryRow(`SELECT quiet_start, quiet_end, timezone FROM users WHERE id = ?`, u.ID).Scan(&own.Start, &own.End, &own.Timezone); err != nil {
+		jsonErr(w, http.StatusInternalServerError, "failed to query quiet hours")
+		return
+	}
+	eff, source, err := a.effectiveQuietHours(u.Username)
+	if err != nil {
+		jsonErr(w, http.StatusInternalServerError, "failed to query quiet hours")
+		return
+	}
+	var held int
+	if err := a.db.QueryRow(`SELECT COUNT(*) FROM deferred_notifications WHERE recipient = ?`, u.Username).Scan(&held); err != nil {
+		jsonErr(w, http.StatusInternalServerError, "failed to query quiet hours")
+		return
+	}
+	resp := map[string]any{
+		"start":     own.Start,
+		"end":       own.End,
+		"timezone":  own.Timezone,
+		"effective": map[string]string{"start": eff.Start, "end": eff.End, "timezone": eff.Timezone, "source": source},
+		"deferred":  held,
+	}
+	if source != "" {
+		if until := quietUntil(time.Now(), eff.Start, eff.End, quietLocation(eff.Timezone)); !until.IsZero() {
+			resp["quiet_until"] = until.UTC().Format(time.RFC3339)
+		}
+	}
+	jsonOut(w, http.StatusOK, resp)
+}
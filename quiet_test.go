@@ -0,0 +1,83 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestQuietHours(t *testing.T) {
+	app := newTestApp(t)
+	app.dispatcher = NewIntegrationDispatcher(app.logger, &WebhookIntegration{URL: "http://127.0.0.1:0"})
+	app.dispatcher.SetDeferrer(app.deferNotification)
+	h := newTestMux(app)
+	createUser(t, app, "quinn", "PUDQUIET001")
+
+	put := func(body map[string]string) *httptest.ResponseRecorder {
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, authedReq(t, http.MethodPut, "/api/me/quiet-hours", body, "PUDQUIET001"))
+		return rr
+	}
+	for _, bad := range []map[string]string{
+		{"start": "25:00", "end": "07:00"},
+		{"start": "22:00"},
+		{"start": "22:00", "end": "07:00", "timezone": "Mars/Olympus"},
+	} {
+		if rr := put(bad); rr.Code != http.StatusBadRequest {
+			t.Fatalf("expected 400 for %v, got %d", bad, rr.Code)
+		}
+	}
+
+	// A two-hour window around now, in UTC.
+	hour := time.Now().UTC().Hour()
+	window := map[string]string{"start": fmt.Sprintf("%02d:00", (hour+23)%24), "end": fmt.Sprintf("%02d:00", (hour+1)%24), "timezone": "UTC"}
+	if rr := put(window); rr.Code != http.StatusOK || !strings.Contains(rr.Body.String(), `"source":"user"`) || !strings.Contains(rr.Body.String(), `"quiet_until"`) {
+		t.Fatalf("expected 200 with user window, got %d body=%s", rr.Code, rr.Body.String())
+	}
+
+	app.dispatcher.Dispatch(IntegrationEvent{Type: "mention", Recipient: "quinn", Message: "ping"})
+	app.dispatcher.Dispatch(IntegrationEvent{Type: "daily_compact", Message: "compacted"})
+	if n := len(app.dispatcher.queue); n != 1 {
+		t.Fatalf("expected only the daily_compact event queued, got %d", n)
+	}
+	if ev := <-app.dispatcher.queue; ev.Type != "daily_compact" {
+		t.Fatalf("unexpected event %+v", ev)
+	}
+	var held int
+	if err := app.db.QueryRow(`SELECT COUNT(*) FROM deferred_notifications WHERE recipient = 'quinn'`).Scan(&held); err != nil || held != 1 {
+		t.Fatalf("expected one deferred notification, got %d err=%v", held, err)
+	}
+
+	if n, err := app.releaseDeferred(time.Now()); err != nil || n != 0 {
+		t.Fatalf("expected nothing due yet, got %d err=%v", n, err)
+	}
+	if _, err := app.db.Exec(`UPDATE deferred_notifications SET release_at = ?`, time.Now().Add(-time.Minute).UTC().Format(time.RFC3339)); err != nil {
+		t.Fatalf("backdate: %v", err)
+	}
+	if n, err := app.releaseDeferred(time.Now()); err != nil || n != 1 {
+		t.Fatalf("expected one released notification, got %d err=%v", n, err)
+	}
+	if ev := <-app.dispatcher.queue; ev.Type != "mention" || ev.Recipient != "quinn" || ev.Message != "ping" {
+		t.Fatalf("unexpected released event %+v", ev)
+	}
+
+	// Without a window of their own, the user falls back to the org's.
+	if rr := put(map[string]string{"timezone": "UTC"}); rr.Code != http.StatusOK || !strings.Contains(rr.Body.String(), `"source":""`) {
+		t.Fatalf("expected cleared window, got %d body=%s", rr.Code, rr.Body.String())
+	}
+	if _, _, err := app.updateSettings(settingsPatch{QuietHours: &quietHours{Start: window["start"], End: window["end"]}}, "admin"); err != nil {
+		t.Fatalf("updateSettings: %v", err)
+	}
+	app.dispatcher.Dispatch(IntegrationEvent{Type: "mention", Recipient: "quinn", Message: "standup"})
+	if n := len(app.dispatcher.queue); n != 0 {
+		t.Fatalf("expected mention held by org quiet hours, got %d queued", n)
+	}
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, authedReq(t, http.MethodGet, "/api/me/quiet-hours", nil, "PUDQUIET001"))
+	if !strings.Contains(rr.Body.String(), `"source":"org"`) || !strings.Contains(rr.Body.String(), `"deferred":1`) {
+		t.Fatalf("expected org window with one held notification, got %s", rr.Body.String())
+	}
+}
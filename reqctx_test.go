@@ -0,0 +1,58 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestRequestContextOnAuditRows(t *testing.T) {
+	app := newTestApp(t)
+	h := newTestMux(app)
+	createUser(t, app, "alice", "PUDREQCTXAL")
+	post := func(requestID string) string {
+		req := authedReq(t, http.MethodPost, "/api/entries", map[string]string{"content": "traced"}, "PUDREQCTXAL")
+		if requestID != "" {
+			req.Header.Set("X-Request-Id", requestID)
+		}
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, req)
+		if rr.Code != http.StatusCreated {
+			t.Fatalf("post: %d %s", rr.Code, rr.Body.String())
+		}
+		return rr.Header().Get("X-Request-Id")
+	}
+	lastRow := func() (string, string) {
+		var id, route string
+		if err := app.db.QueryRow(`SELECT request_id, route FROM action_logs WHERE action = 'create_entry' ORDER BY id DESC LIMIT 1`).Scan(&id, &route); err != nil {
+			t.Fatal(err)
+		}
+		return id, route
+	}
+
+	// The peer is not a trusted proxy, so its X-Request-Id is replaced.
+	id := post("spoofed")
+	if len(id) != 16 {
+		t.Fatalf("expected a generated request id, got %q", id)
+	}
+	if got, route := lastRow(); got != id || route != "POST /api/entries" {
+		t.Fatalf("audit row: request_id=%q route=%q, want %q", got, route, id)
+	}
+	proxies, err := parseTrustedProxies("192.0.2.0/24")
+	if err != nil {
+		t.Fatal(err)
+	}
+	app.trustedProxies = proxies
+	if id := post("edge-7f3a"); id != "edge-7f3a" {
+		t.Fatalf("expected the trusted proxy's request id, got %q", id)
+	}
+	if got, _ := lastRow(); got != "edge-7f3a" {
+		t.Fatalf("audit row: request_id=%q", got)
+	}
+	if id := post("bad id with spaces"); id == "bad id with spaces" || id == "" {
+		t.Fatalf("expected a malformed request id to be replaced, got %q", id)
+	}
+	if _, problems, err := app.verifyAuditChain(); err != nil || len(problems) != 0 {
+		t.Fatalf("audit chain: %+v err=%v", problems, err)
+	}
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"math/rand/v2"
+	"net/http"
+	"time"
+)
+
+// Request logging: with --request-log (on by default) every API request is
+// logged once it has been answered, with its status, response bytes,
+// duration and, for authenticated requests, the username. --request-log-sample
+// keeps only a fraction of successful requests (errors are always logged)
+// and --request-log-exclude skips noisy paths such as /api/health entirely.
+
+// requestLogger is the --request-log configuration; nil disables logging.
+type requestLogger struct {
+	sample  float64
+	exclude map[string]bool
+}
+
+func newRequestLogger(enabled bool, sample float64, exclude []string) (*requestLogger, error) {
+	if !enabled {
+		return nil, nil
+	}
+	if sample < 0 || sample > 1 {
+		return nil, fmt.Errorf("--request-log-sample must be between 0 and 1, got %g", sample)
+	}
+	l := &requestLogger{sample: sample, exclude: map[string]bool{}}
+	for _, p := range exclude {
+		l.exclude[p] = true
+	}
+	return l, nil
+}
+
+// requestLogInfo is filled in while a request is served; withAuth records
+// who made it.
+type requestLogInfo struct {
+	user         string
+	impersonator string
+}
+
+type requestLogKey struct{}
+
+// noteRequestUser records the authenticated user for the request log.
+func noteRequestUser(r *http.Request, u AuthedUser) {
+	if info, ok := r.Context().Value(requestLogKey{}).(*requestLogInfo); ok {
+		info.user = u.Username
+		info.impersonator = u.ImpersonatedBy
+	}
+}
+
+// statusWriter captures the status code and body size of a response.
+type statusWriter struct {
+	http.ResponseWriter
+	status int
+	n      int64
+}
+
+func (w *statusWriter) WriteHeader(code int) {
+	if w.status == 0 {
+		w.status = code
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *statusWriter) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	n, err := w.ResponseWriter.Write(b)
+	w.n += int64(n)
+	return n, err
+}
+
+func (w *statusWriter) Unwrap() http.ResponseWriter { return w.ResponseWriter }
+
+// withRequestLog logs every request next serves, subject to the sample rate
+// and exclusions.
+func (a *App) withRequestLog(next http.Handler) http.Handler {
+	l := a.requestLog
+	if l == nil {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if l.exclude[r.URL.Path] || l.exclude[unversionedPath(r.URL.Path)] {
+			next.ServeHTTP(w, r)
+			return
+		}
+		start := time.Now()
+		info := &requestLogInfo{}
+		sw := &statusWriter{ResponseWriter: w}
+		next.ServeHTTP(sw, r.WithContext(context.WithValue(r.Context(), requestLogKey{}, info)))
+		status := sw.status
+		if status == 0 {
+			status = http.StatusOK
+		}
+		if status < http.StatusBadRequest && l.sample < 1 && rand.Float64() >= l.sample {
+			return
+		}
+		user := info.user
+		if user == "" {
+			user = "-"
+		}
+		line := fmt.Sprintf("event=http_request method=%s path=%s status=%d bytes=%d duration_ms=%.1f user=%s client=%s",
+			r.Method, r.URL.EscapedPath(), status, sw.n, float64(time.Since(start).Microseconds())/1000, user, a.clientIP(r))
+		if info.impersonator != "" {
+			line += " impersonator=" + info.impersonator
+		}
+		if rc := requestFrom(r); rc.ID != "" {
+			line += " request_id=" + rc.ID
+		}
+		a.logger.Print(line)
+	})
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"bytes"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestRequestLog(t *testing.T) {
+	app := newTestApp(t)
+	var buf bytes.Buffer
+	app.logger = log.New(&buf, "", 0)
+	var err error
+	if app.requestLog, err = newRequestLogger(true, 1, []string{"/api/health"}); err != nil {
+		t.Fatal(err)
+	}
+	h := newTestMux(app)
+	createUser(t, app, "alice", "PUDREQLOG01")
+	createUser(t, app, "root", "PUDREQLOG02")
+	if _, err := app.db.Exec(`UPDATE users SET role = 'admin' WHERE username = 'root'`); err != nil {
+		t.Fatal(err)
+	}
+	serve := func(req *http.Request) string {
+		buf.Reset()
+		h.ServeHTTP(httptest.NewRecorder(), req)
+		for _, line := range strings.Split(buf.String(), "\n") {
+			if strings.HasPrefix(line, "event=http_request ") {
+				return line
+			}
+		}
+		return ""
+	}
+
+	line := serve(authedReq(t, http.MethodPost, "/api/entries", map[string]string{"content": "logged request"}, "PUDREQLOG01"))
+	for _, want := range []string{"method=POST", "path=/api/entries", "status=201", "user=alice", "duration_ms="} {
+		if !strings.Contains(line, want) {
+			t.Fatalf("missing %q in %q", want, line)
+		}
+	}
+	if strings.Contains(line, "bytes=0 ") {
+		t.Fatalf("response bytes not counted: %q", line)
+	}
+	if line := serve(httptest.NewRequest(http.MethodGet, "/api/me", nil)); !strings.Contains(line, "status=401") || !strings.Contains(line, "user=-") {
+		t.Fatalf("unauthenticated: %q", line)
+	}
+	req := authedReq(t, http.MethodGet, "/api/me", nil, "PUDREQLOG02")
+	req.Header.Set("X-Impersonate-User", "alice")
+	if line := serve(req); !strings.Contains(line, "user=alice") || !strings.Contains(line, "impersonator=root") {
+		t.Fatalf("impersonated: %q", line)
+	}
+	if line := serve(httptest.NewRequest(http.MethodGet, "/api/health", nil)); line != "" {
+		t.Fatalf("excluded path logged: %q", line)
+	}
+
+	// With sampling off, only errors are logged.
+	app.requestLog.sample = 0
+	if line := serve(authedReq(t, http.MethodGet, "/api/me", nil, "PUDREQLOG01")); line != "" {
+		t.Fatalf("sampled out request logged: %q", line)
+	}
+	if line := serve(httptest.NewRequest(http.MethodGet, "/api/me", nil)); !strings.Contains(line, "status=401") {
+		t.Fatalf("error not logged: %q", line)
+	}
+	if _, err := newRequestLogger(true, 1.5, nil); err == nil {
+		t.Fatal("sample above 1 accepted")
+	}
+}
@@ -0,0 +1,182 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const compactHeaderPrefix = "Daily compact for "
+
+// rerenderResult summarizes a re-render run.
+type rerenderResult struct {
+	Checked     int      `json:"checked"`
+	Updated     int      `json:"updated"`
+	SkippedHeld []string `json:"skipped_held"`
+	DryRun      bool     `json:"dry_run"`
+}
+
+// rerenderCompacts rewrites the text of daily_compact entries for days in
+// [from, to] from their compact_data with the current renderCompact, keeping
+// any meeting-load trailer. Days under legal hold are left untouched.
+func (a *App) rerenderCompacts(from, to string, dryRun bool) (rerenderResult, error) {
+	res := rerenderResult{SkippedHeld: []string{}, DryRun: dryRun}
+	a.compactMu.Lock()
+	defer a.compactMu.Unlock()
+
+	rows, err := a.db.Query(`SELECT id, content, compact_data FROM entries WHERE entry_type = 'daily_compact' ORDER BY id ASC`)
+	if err != nil {
+		return res, err
+	}
+	type compactRow struct {
+		id      int64
+		day     string
+		content string
+		data    sql.NullString
+	}
+	var compacts []compactRow
+	for rows.Next() {
+		var c compactRow
+		if err := rows.Scan(&c.id, &c.content, &c.data); err != nil {
+			_ = rows.Close()
+			return res, err
+		}
+		header, _, _ := strings.Cut(c.content, "\n")
+		c.day = strings.TrimPrefix(header, compactHeaderPrefix)
+		if c.day == header || c.day < from || c.day > to {
+			continue
+		}
+		compacts = append(compacts, c)
+	}
+	if err := rows.Err(); err != nil {
+		_ = rows.Close()
+		return res, err
+	}
+	_ = rows.Close()
+
+	for _, c := range compacts {
+		res.Checked++
+		held, err := a.dayOnHold(c.day)
+		if err != nil {
+			return res, err
+		}
+		if held {
+			res.SkippedHeld = append(res.SkippedHeld, c.day)
+			continue
+		}
+		trailer := ""
+		if i := strings.Index(c.content, meetingLoadHeader); i >= 0 {
+			trailer = c.content[i:]
+		}
+		text := renderCompact(c.day, compactSources(c.data, c.content)) + trailer
+		if text == c.content {
+			continue
+		}
+		res.Updated++
+		if dryRun {
+			continue
+		}
+		if _, err := a.db.Exec(`UPDATE entries SET content = ? WHERE id = ?`, text, c.id); err != nil {
+			return res, err
+		}
+	}
+	return res, nil
+}
+
+func validDayRange(from, to string) error {
+	if _, err := time.Parse("2006-01-02", from); err != nil {
+		return errors.New("from must be YYYY-MM-DD")
+	}
+	if _, err := time.Parse("2006-01-02", to); err != nil {
+		return errors.New("to must be YYYY-MM-DD")
+	}
+	if to < from {
+		return errors.New("to must not be before from")
+	}
+	return nil
+}
+
+func (a *App) handleAdminRerenderCompacts(w http.ResponseWriter, r *http.Request, u AuthedUser) {
+	if r.Method != http.MethodPost {
+		jsonErr(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	var req struct {
+		From   string `json:"from"`
+		To     string `json:"to"`
+		DryRun bool   `json:"dry_run"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		jsonErr(w, http.StatusBadRequest, "invalid json")
+		return
+	}
+	if req.To == "" {
+		req.To = req.From
+	}
+	if err := validDayRange(req.From, req.To); err != nil {
+		jsonErr(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	res, err := a.rerenderCompacts(req.From, req.To, req.DryRun)
+	if err != nil {
+		jsonErr(w, http.StatusInternalServerError, "failed to re-render compacts")
+		return
+	}
+	_ = a.logUserAction(u, "rerender_compacts", fmt.Sprintf("from=%s to=%s checked=%d updated=%d held=%d dry_run=%t", req.From, req.To, res.Checked, res.Updated, len(res.SkippedHeld), req.DryRun))
+	jsonOut(w, http.StatusOK, res)
+}
+
+func runAdminRerenderCompacts(args []string) error {
+	fs := flag.NewFlagSet("admin rerender-compacts", flag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s admin rerender-compacts --from YYYY-MM-DD [--to YYYY-MM-DD] [options]\n\n", binName())
+		fmt.Fprintln(fs.Output(), "Rewrites daily_compact text from the stored source entries with the current")
+		fmt.Fprintln(fs.Output(), "format. Days under legal hold are skipped.")
+		fmt.Fprintln(fs.Output())
+		fmt.Fprintln(fs.Output(), "Options:")
+		fs.PrintDefaults()
+	}
+	from := fs.String("from", "", "first compact day (YYYY-MM-DD)")
+	to := fs.String("to", "", "last compact day (YYYY-MM-DD, defaults to --from)")
+	dryRun := fs.Bool("dry-run", false, "report what would change without writing")
+	dbPath := fs.String("db", defaultDBPath, "sqlite db path")
+	logPath := fs.String("log", defaultLogPath, "log target path ('-' for stdout only)")
+	if err := fs.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return nil
+		}
+		return err
+	}
+	if *to == "" {
+		*to = *from
+	}
+	if err := validDayRange(*from, *to); err != nil {
+		return fmt.Errorf("--%w", err)
+	}
+
+	app, closeApp, err := openAdminApp(*dbPath, *logPath)
+	if err != nil {
+		return err
+	}
+	defer closeApp()
+
+	res, err := app.rerenderCompacts(*from, *to, *dryRun)
+	if err != nil {
+		return err
+	}
+	_ = app.logAction("admin_cli", "admin", "rerender_compacts", fmt.Sprintf("from=%s to=%s checked=%d updated=%d held=%d dry_run=%t", *from, *to, res.Checked, res.Updated, len(res.SkippedHeld), *dryRun))
+	for _, day := range res.SkippedHeld {
+		fmt.Printf("skipped %s (legal hold)\n", day)
+	}
+	verb := "updated"
+	if *dryRun {
+		verb = "would update"
+	}
+	fmt.Printf("checked %d compacts, %s %d\n", res.Checked, verb, res.Updated)
+	return nil
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAPIAdminRerenderCompacts(t *testing.T) {
+	app := newTestApp(t)
+	h := newTestMux(app)
+	createUser(t, app, "alice", "PUDABCDEF12")
+	createUser(t, app, "root", "PUDROOTROOT")
+	if _, err := app.db.Exec(`UPDATE users SET role = 'admin' WHERE username = 'root'`); err != nil {
+		t.Fatalf("promote root: %v", err)
+	}
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, authedReq(t, http.MethodPost, "/api/entries", map[string]string{"content": "shipped it"}, "PUDABCDEF12"))
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("expected 201, got %d", rr.Code)
+	}
+	day := time.Now().UTC().Format("2006-01-02")
+	if err := app.compactDay(day); err != nil {
+		t.Fatalf("compactDay: %v", err)
+	}
+	var want string
+	if err := app.db.QueryRow(`SELECT content FROM entries WHERE entry_type = 'daily_compact'`).Scan(&want); err != nil {
+		t.Fatalf("query compact: %v", err)
+	}
+	// Simulate a compact rendered by an older template, with a meeting trailer.
+	trailer := meetingLoadHeader + "- alice: 2 meeting(s), 1h0m0s\n"
+	if _, err := app.db.Exec(`UPDATE entries SET content = ? WHERE entry_type = 'daily_compact'`, compactHeaderPrefix+day+"\n\nalice: shipped it\n"+trailer); err != nil {
+		t.Fatalf("rewrite compact: %v", err)
+	}
+
+	rerender := func(token string) *httptest.ResponseRecorder {
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, authedReq(t, http.MethodPost, "/api/admin/compacts/rerender", map[string]any{"from": day, "to": day}, token))
+		return rr
+	}
+	if rr := rerender("PUDABCDEF12"); rr.Code != http.StatusForbidden {
+		t.Fatalf("expected 403 for member, got %d", rr.Code)
+	}
+	rr = rerender("PUDROOTROOT")
+	if rr.Code != http.StatusOK || !strings.Contains(rr.Body.String(), `"updated":1`) {
+		t.Fatalf("expected one updated compact, got %d body=%s", rr.Code, rr.Body.String())
+	}
+	var got string
+	if err := app.db.QueryRow(`SELECT content FROM entries WHERE entry_type = 'daily_compact'`).Scan(&got); err != nil {
+		t.Fatalf("query compact: %v", err)
+	}
+	if got != want+trailer {
+		t.Fatalf("unexpected re-rendered compact:\n%s", got)
+	}
+
+	if _, err := app.db.Exec(`INSERT INTO legal_holds(start_day, end_day, reason, created_at) VALUES(?, ?, 'audit', ?)`, day, day, nowUTC()); err != nil {
+		t.Fatalf("insert hold: %v", err)
+	}
+	rr = rerender("PUDROOTROOT")
+	if rr.Code != http.StatusOK || !strings.Contains(rr.Body.String(), `"skipped_held":["`+day+`"]`) {
+		t.Fatalf("expected held day to be skipped, got body=%s", rr.Body.String())
+	}
+}
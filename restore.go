@@ -0,0 +1,191 @@
+package main
+
+import (
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// Disaster recovery: 'admin restore --from <snapshot>' replaces the live
+// database with a backup taken by 'admin backup' or GET /api/admin/backup.
+// It takes the serve lock first, so it cannot run under a live server, and
+// checks the snapshot (integrity, core tables, schema version no newer than
+// this binary) before anything is touched. The current database is kept as
+// <db>.pre-restore-<time>.db and the new file is renamed into place, so an
+// interrupted restore leaves either the old database or the new one.
+
+// restoreRequiredTables are the tables any devlog database has had since the
+// first release; a snapshot without them is not a devlog backup.
+var restoreRequiredTables = []string{"users", "entries", "action_logs"}
+
+// checkRestoreSnapshot validates path as a restore source and returns its
+// schema version.
+func checkRestoreSnapshot(path string) (int, error) {
+	if err := checkSnapshotIntegrity(path); err != nil {
+		return 0, err
+	}
+	db, err := sql.Open("sqlite3", "file:"+path+"?mode=ro")
+	if err != nil {
+		return 0, err
+	}
+	defer db.Close()
+	var version int
+	if err := db.QueryRow(`PRAGMA user_version`).Scan(&version); err != nil {
+		return 0, err
+	}
+	if version > schemaVersion {
+		return version, fmt.Errorf("snapshot schema version %d is newer than this binary's (%d); restore it with a newer release", version, schemaVersion)
+	}
+	for _, table := range restoreRequiredTables {
+		var n int
+		if err := db.QueryRow(`SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name = ?`, table).Scan(&n); err != nil {
+			return version, err
+		}
+		if n == 0 {
+			return version, fmt.Errorf("snapshot has no %s table; not a devlog database", table)
+		}
+	}
+	return version, nil
+}
+
+// copyForRestore copies src to dst, synced to disk, and returns its SHA-256.
+func copyForRestore(src, dst string) (string, error) {
+	in, err := os.Open(src)
+	if err != nil {
+		return "", err
+	}
+	defer in.Close()
+	out, err := os.OpenFile(dst, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0o600)
+	if err != nil {
+		return "", err
+	}
+	h := sha256.New()
+	_, err = io.Copy(io.MultiWriter(out, h), in)
+	if err == nil {
+		err = out.Sync()
+	}
+	if cerr := out.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// restoreDatabase swaps snapshot in as dbPath. The caller holds the serve
+// lock. It returns the snapshot's SHA-256 and where the previous database was
+// kept ("" when there was none).
+func restoreDatabase(dbPath, snapshot string) (string, string, error) {
+	tmp := dbPath + ".restoring"
+	defer os.Remove(tmp)
+	sum, err := copyForRestore(snapshot, tmp)
+	if err != nil {
+		return "", "", fmt.Errorf("copy snapshot: %w", err)
+	}
+	// Check the copy, not the source: it is the file that goes live.
+	if _, err := checkRestoreSnapshot(tmp); err != nil {
+		return "", "", err
+	}
+
+	previous := ""
+	if _, err := os.Stat(dbPath); err == nil {
+		previous = fmt.Sprintf("%s.pre-restore-%s.db", dbPath, time.Now().UTC().Format("20060102T150405Z"))
+		db, err := openDB(dbPath)
+		if err != nil {
+			return "", "", err
+		}
+		_, err = db.Exec(`VACUUM INTO ?`, previous)
+		_ = db.Close()
+		if err != nil {
+			return "", "", fmt.Errorf("keep current database: %w", err)
+		}
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return "", "", err
+	}
+
+	// The old WAL belongs to the old file and would be replayed onto the new
+	// one; its contents are in the pre-restore copy.
+	for _, suffix := range []string{"-wal", "-shm"} {
+		if err := os.Remove(dbPath + suffix); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return "", "", err
+		}
+	}
+	if err := os.Rename(tmp, dbPath); err != nil {
+		return "", "", err
+	}
+	return sum, previous, nil
+}
+
+func runAdminRestore(args []string) error {
+	fs := flag.NewFlagSet("admin restore", flag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s admin restore --from <snapshot.db> [options]\n\n", binName())
+		fmt.Fprintln(fs.Output(), "Replaces the database with a backup from 'admin backup' or GET /api/admin/backup.")
+		fmt.Fprintln(fs.Output(), "Stop serve first: the restore refuses to run while serve holds the database.")
+		fmt.Fprintln(fs.Output(), "The snapshot must pass PRAGMA integrity_check and must not come from a newer")
+		fmt.Fprintln(fs.Output(), "release. The current database is kept as <db>.pre-restore-<time>.db.")
+		fmt.Fprintln(fs.Output())
+		fmt.Fprintln(fs.Output(), "Options:")
+		fs.PrintDefaults()
+	}
+	from := fs.String("from", "", "snapshot file to restore")
+	dbPath := fs.String("db", defaultDBPath, "sqlite db path")
+	logPath := fs.String("log", defaultLogPath, "log target path ('-' for stdout only)")
+	if err := fs.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return nil
+		}
+		return err
+	}
+	if *from == "" {
+		return errors.New("--from is required")
+	}
+	if abs, err := filepath.Abs(*from); err == nil {
+		if db, err := filepath.Abs(*dbPath); err == nil && abs == db {
+			return errors.New("--from must not be the database itself")
+		}
+	}
+	if st, ok, err := readStandbyState(*dbPath); err != nil {
+		return err
+	} else if ok {
+		return fmt.Errorf("%s is a warm standby of %s; the standby loop would overwrite a restore", *dbPath, st.Primary)
+	}
+	version, err := checkRestoreSnapshot(*from)
+	if err != nil {
+		return fmt.Errorf("refusing to restore %s: %w", *from, err)
+	}
+
+	releaseLock, err := acquireDBLock(*dbPath)
+	if err != nil {
+		if errors.Is(err, errDBLocked) {
+			return fmt.Errorf("%w; stop serve before restoring", err)
+		}
+		return err
+	}
+	defer releaseLock()
+	sum, previous, err := restoreDatabase(*dbPath, *from)
+	if err != nil {
+		return fmt.Errorf("restore: %w", err)
+	}
+
+	// Opening runs the migrations, bringing an older snapshot up to date.
+	app, closeApp, err := openAdminApp(*dbPath, *logPath)
+	if err != nil {
+		return err
+	}
+	defer closeApp()
+	_ = app.logAction("admin_cli", "admin", "restore_database", fmt.Sprintf("from=%s sha256=%s snapshot_schema=%d previous=%s", *from, sum, version, previous))
+	fmt.Printf("restored %s from %s (sha256 %s, schema %d)\n", *dbPath, *from, sum, version)
+	if previous != "" {
+		fmt.Printf("previous database kept as %s\n", previous)
+	}
+	return nil
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestAdminRestore(t *testing.T) {
+	dir := t.TempDir()
+	dbPath := filepath.Join(dir, "devlog.db")
+	snapshot := filepath.Join(dir, "snapshot.db")
+	count := func(path string) int {
+		t.Helper()
+		db, err := sql.Open("sqlite3", "file:"+path+"?mode=ro")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer db.Close()
+		var n int
+		if err := db.QueryRow(`SELECT COUNT(*) FROM entries`).Scan(&n); err != nil {
+			t.Fatal(err)
+		}
+		return n
+	}
+
+	app, closeApp, err := openAdminApp(dbPath, "-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	createUser(t, app, "root", "PUDRESTORE1")
+	root, err := app.lookupToken("PUDRESTORE1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := app.insertEntry(root.ID, "before the backup", "", sourceAPI, "2026-02-17T10:00:00Z"); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := app.backupTo(snapshot); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := app.insertEntry(root.ID, "after the backup", "", sourceAPI, "2026-02-17T11:00:00Z"); err != nil {
+		t.Fatal(err)
+	}
+	closeApp()
+
+	release, err := acquireDBLock(dbPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := runAdminRestore([]string{"--from", snapshot, "--db", dbPath, "--log", "-"}); !errors.Is(err, errDBLocked) {
+		t.Fatalf("restore under a running serve: %v", err)
+	}
+	release()
+
+	newer := filepath.Join(dir, "newer.db")
+	if _, err := copyForRestore(snapshot, newer); err != nil {
+		t.Fatal(err)
+	}
+	ndb, err := openDB(newer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ndb.Exec(fmt.Sprintf(`PRAGMA user_version = %d`, schemaVersion+1)); err != nil {
+		t.Fatal(err)
+	}
+	_ = ndb.Close()
+	if err := runAdminRestore([]string{"--from", newer, "--db", dbPath, "--log", "-"}); err == nil || !strings.Contains(err.Error(), "newer") {
+		t.Fatalf("restore of a newer snapshot: %v", err)
+	}
+	if n := count(dbPath); n != 2 {
+		t.Fatalf("refused restore touched the database: %d entries", n)
+	}
+
+	if err := runAdminRestore([]string{"--from", snapshot, "--db", dbPath, "--log", "-"}); err != nil {
+		t.Fatalf("restore: %v", err)
+	}
+	if n := count(dbPath); n != 1 {
+		t.Fatalf("restored database: %d entries, want 1", n)
+	}
+	previous, err := filepath.Glob(dbPath + ".pre-restore-*.db")
+	if err != nil || len(previous) != 1 || count(previous[0]) != 2 {
+		t.Fatalf("pre-restore copy: %v %v", previous, err)
+	}
+	app, closeApp, err = openAdminApp(dbPath, "-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer closeApp()
+	var logged int
+	if err := app.db.QueryRow(`SELECT COUNT(*) FROM action_logs WHERE action = 'restore_database'`).Scan(&logged); err != nil || logged != 1 {
+		t.Fatalf("restore_database audit rows: %d %v", logged, err)
+	}
+}
@@ -0,0 +1,391 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"flag"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Retention: the retention_rules setting lists {target, max_age_days} rules
+// that the retention loop applies hourly, deleting rows of the target older
+// than the age. Rows on days under legal hold are always kept. Pruning
+// action_logs removes a prefix of the chain and records the last removed
+// row's id and hash in audit_anchor, so 'admin verify-audit' checks the rest
+// from there; it never removes the newest row or rows an audit sink has not
+// forwarded yet. Pruning compacts also removes their archived originals and
+// their days' compactions rows, and journals "expired" for each day. Every
+// rule run that deletes something is audited as retention_prune.
+
+const (
+	retentionInterval  = time.Hour
+	subsystemRetention = "retention"
+	maxRetentionDays   = 36500
+	journalExpired     = "expired"
+)
+
+// retentionRule is one entry of the retention_rules setting.
+type retentionRule struct {
+	Target     string `json:"target"`
+	MaxAgeDays int    `json:"max_age_days"`
+}
+
+// retentionTable is a target pruned by one DELETE on a timestamp column.
+type retentionTable struct {
+	table, column string
+	// dateOnly columns hold YYYY-MM-DD instead of RFC 3339 timestamps.
+	dateOnly bool
+}
+
+var retentionTables = map[string]retentionTable{
+	"entries_archive":    {table: "entries_archive", column: "created_at"},
+	"compaction_journal": {table: "compaction_journal", column: "at"},
+	"api_usage":          {table: "api_usage", column: "day", dateOnly: true},
+}
+
+const (
+	retentionActionLogs = "action_logs"
+	retentionCompacts   = "compacts"
+)
+
+// retentionTargets returns every valid rule target, sorted.
+func retentionTargets() []string {
+	out := []string{retentionActionLogs, retentionCompacts}
+	for name := range retentionTables {
+		out = append(out, name)
+	}
+	sort.Strings(out)
+	return out
+}
+
+// validateRetentionRules normalizes rules in place.
+func validateRetentionRules(rules []retentionRule) error {
+	valid := map[string]bool{}
+	for _, t := range retentionTargets() {
+		valid[t] = true
+	}
+	seen := map[string]bool{}
+	for i := range rules {
+		r := &rules[i]
+		r.Target = strings.ToLower(strings.TrimSpace(r.Target))
+		if !valid[r.Target] {
+			return fmt.Errorf("unknown target %q (want one of %s)", r.Target, strings.Join(retentionTargets(), ", "))
+		}
+		if seen[r.Target] {
+			return fmt.Errorf("target %s appears twice", r.Target)
+		}
+		seen[r.Target] = true
+		if r.MaxAgeDays < 1 || r.MaxAgeDays > maxRetentionDays {
+			return fmt.Errorf("%s: max_age_days must be between 1 and %d", r.Target, maxRetentionDays)
+		}
+	}
+	return nil
+}
+
+// retentionRulesMeta renders rules for audit metadata, e.g.
+// "action_logs:90,compacts:730".
+func retentionRulesMeta(rules []retentionRule) string {
+	parts := make([]string, 0, len(rules))
+	for _, r := range rules {
+		parts = append(parts, fmt.Sprintf("%s:%d", r.Target, r.MaxAgeDays))
+	}
+	return strings.Join(parts, ",")
+}
+
+// retentionResult reports one rule run.
+type retentionResult struct {
+	Target     string `json:"target"`
+	MaxAgeDays int    `json:"max_age_days"`
+	Cutoff     string `json:"cutoff"`
+	Deleted    int    `json:"deleted"`
+}
+
+// heldDaySQL matches rows whose day (the first ten bytes of col) is under an
+// active legal hold.
+func heldDaySQL(col string) string {
+	return `EXISTS (SELECT 1 FROM legal_holds h WHERE h.released_at IS NULL AND h.start_day <= substr(` + col + `, 1, 10) AND h.end_day >= substr(` + col + `, 1, 10))`
+}
+
+// applyRetention runs every retention rule once against now.
+func (a *App) applyRetention(now time.Time) ([]retentionResult, error) {
+	return a.applyRetentionRules(now, a.settings().RetentionRules)
+}
+
+// applyRetentionRules runs rules once against now.
+func (a *App) applyRetentionRules(now time.Time, rules []retentionRule) ([]retentionResult, error) {
+	var out []retentionResult
+	for _, rule := range rules {
+		cutoff := now.UTC().AddDate(0, 0, -rule.MaxAgeDays)
+		res := retentionResult{Target: rule.Target, MaxAgeDays: rule.MaxAgeDays, Cutoff: cutoff.Format(time.RFC3339)}
+		var err error
+		switch rule.Target {
+		case retentionActionLogs:
+			res.Deleted, err = a.pruneActionLogs(res.Cutoff)
+		case retentionCompacts:
+			res.Deleted, err = a.pruneCompacts(rule, res.Cutoff)
+		default:
+			t, ok := retentionTables[rule.Target]
+			if !ok {
+				continue
+			}
+			if t.dateOnly {
+				res.Cutoff = cutoff.Format("2006-01-02")
+			}
+			var r sql.Result
+			r, err = a.db.Exec(`DELETE FROM `+t.table+` WHERE `+t.column+` < ? AND NOT `+heldDaySQL(t.table+"."+t.column), res.Cutoff)
+			if err == nil {
+				n, _ := r.RowsAffected()
+				res.Deleted = int(n)
+			}
+		}
+		if err != nil {
+			return out, fmt.Errorf("%s: %w", rule.Target, err)
+		}
+		if res.Deleted > 0 {
+			a.logger.Printf("event=retention_prune target=%s max_age_days=%d cutoff=%s deleted=%d", res.Target, res.MaxAgeDays, res.Cutoff, res.Deleted)
+			_ = a.logActorAction(actorScheduler, "retention_prune", fmt.Sprintf("target=%s max_age_days=%d cutoff=%s deleted=%d", res.Target, res.MaxAgeDays, res.Cutoff, res.Deleted))
+		}
+		out = append(out, res)
+	}
+	return out, nil
+}
+
+// pruneActionLogs deletes the longest prefix of action_logs written before
+// cutoff that stops short of held days, the newest row and unforwarded rows,
+// and moves audit_anchor to its last row.
+func (a *App) pruneActionLogs(cutoff string) (int, error) {
+	// Rows up to every configured sink's cursor have been forwarded.
+	limit := int64(-1)
+	for _, t := range a.auditTargets {
+		var id int64
+		err := a.db.QueryRow(`SELECT last_id FROM audit_forward_cursors WHERE sink = ?`, t.sink.name()).Scan(&id)
+		if errors.Is(err, sql.ErrNoRows) {
+			continue
+		}
+		if err != nil {
+			return 0, err
+		}
+		if limit < 0 || id < limit {
+			limit = id
+		}
+	}
+
+	a.auditMu.Lock()
+	defer a.auditMu.Unlock()
+	tx, err := a.db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	var stop sql.NullInt64
+	err = tx.QueryRow(`SELECT MIN(id) FROM action_logs WHERE created_at >= ? OR `+heldDaySQL("action_logs.created_at"), cutoff).Scan(&stop)
+	if err != nil {
+		return 0, err
+	}
+	var newest int64
+	if err := tx.QueryRow(`SELECT COALESCE(MAX(id), 0) FROM action_logs`).Scan(&newest); err != nil {
+		return 0, err
+	}
+	last := newest - 1
+	if stop.Valid && stop.Int64-1 < last {
+		last = stop.Int64 - 1
+	}
+	if limit >= 0 && limit < last {
+		last = limit
+	}
+	var lastID int64
+	var lastHash string
+	err = tx.QueryRow(`SELECT id, hash FROM action_logs WHERE id <= ? ORDER BY id DESC LIMIT 1`, last).Scan(&lastID, &lastHash)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	res, err := tx.Exec(`DELETE FROM action_logs WHERE id <= ?`, lastID)
+	if err != nil {
+		return 0, err
+	}
+	if _, err := tx.Exec(`
+INSERT INTO audit_anchor(id, last_id, last_hash, pruned_at) VALUES(1, ?, ?, ?)
+ON CONFLICT(id) DO UPDATE SET last_id = excluded.last_id, last_hash = excluded.last_hash, pruned_at = excluded.pruned_at`, lastID, lastHash, nowUTC()); err != nil {
+		return 0, err
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, err
+	}
+	n, _ := res.RowsAffected()
+	return int(n), nil
+}
+
+// auditAnchor returns where the stored chain starts: the id and hash of the
+// last row retention removed, or 0 and zeroHash.
+func (a *App) auditAnchor() (int64, string, error) {
+	var id int64
+	var hash string
+	err := a.db.QueryRow(`SELECT last_id, last_hash FROM audit_anchor WHERE id = 1`).Scan(&id, &hash)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, zeroHash, nil
+	}
+	return id, hash, err
+}
+
+// pruneCompacts deletes daily, weekly and monthly compacts written before
+// cutoff whose days are not held and whose compaction is finished.
+func (a *App) pruneCompacts(rule retentionRule, cutoff string) (int, error) {
+	a.compactMu.Lock()
+	defer a.compactMu.Unlock()
+
+	type compact struct {
+		id        int64
+		entryType string
+		days      []string
+	}
+	rows, err := a.db.Query(`SELECT id, entry_type, content, compact_data, created_at FROM entries WHERE entry_type IN `+compactTypesSQL+` AND created_at < ? ORDER BY id ASC`, cutoff)
+	if err != nil {
+		return 0, err
+	}
+	var found []compact
+	for rows.Next() {
+		var c compact
+		var content, createdAt string
+		var data sql.NullString
+		if err := rows.Scan(&c.id, &c.entryType, &content, &data, &createdAt); err != nil {
+			_ = rows.Close()
+			return 0, err
+		}
+		header, _, _ := strings.Cut(content, "\n")
+		if day := strings.TrimPrefix(header, compactHeaderPrefix); c.entryType == "daily_compact" && day != header {
+			c.days = []string{day}
+		} else {
+			c.days = sourceDays(compactSources(data, content))
+		}
+		if len(c.days) == 0 {
+			c.days = []string{createdAt[:min(len(createdAt), 10)]}
+		}
+		found = append(found, c)
+	}
+	if err := rows.Err(); err != nil {
+		_ = rows.Close()
+		return 0, err
+	}
+	_ = rows.Close()
+
+	deleted := 0
+	for _, c := range found {
+		keep := false
+		for _, day := range c.days {
+			held, err := a.dayOnHold(day)
+			if err != nil {
+				return deleted, err
+			}
+			phase, err := a.compactionPhase(day)
+			if err != nil {
+				return deleted, err
+			}
+			if held || phase == compactionProduced {
+				keep = true
+				break
+			}
+		}
+		if keep {
+			continue
+		}
+		if err := a.expireCompact(c.id, c.entryType, c.days, rule); err != nil {
+			return deleted, err
+		}
+		deleted++
+	}
+	return deleted, nil
+}
+
+// expireCompact removes one compact with its archived originals and the
+// compactions rows of the days it covered.
+func (a *App) expireCompact(id int64, entryType string, days []string, rule retentionRule) error {
+	tx, err := a.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer func() { _ = tx.Rollback() }()
+	if _, err := tx.Exec(`DELETE FROM entries_archive WHERE compact_id = ?`, id); err != nil {
+		return err
+	}
+	if entryType == "daily_compact" {
+		_, err = tx.Exec(`DELETE FROM compactions WHERE day = ? AND rollup_id IS NULL`, days[0])
+	} else {
+		_, err = tx.Exec(`DELETE FROM compactions WHERE rollup_id = ?`, id)
+	}
+	if err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`DELETE FROM entries WHERE id = ?`, id); err != nil {
+		return err
+	}
+	for _, day := range days {
+		if err := journalCompaction(tx, day, journalExpired, fmt.Sprintf("%s id=%d deleted by retention (max_age_days=%d)", entryType, id, rule.MaxAgeDays)); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// retentionLoop applies the retention rules once at startup and then hourly.
+func (a *App) retentionLoop(ctx context.Context) {
+	ticker := time.NewTicker(retentionInterval)
+	defer ticker.Stop()
+	a.health.register(subsystemRetention, retentionInterval, time.Now())
+	for {
+		_, err := a.applyRetention(time.Now())
+		if err != nil {
+			a.logger.Printf("event=retention_failed err=%v", err)
+		}
+		a.health.record(subsystemRetention, time.Now(), err)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func runAdminRetention(args []string) error {
+	fs := flag.NewFlagSet("admin retention", flag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s admin retention [options]\n\n", binName())
+		fmt.Fprintln(fs.Output(), "Applies the retention_rules setting now, as the serve loop does hourly, and")
+		fmt.Fprintln(fs.Output(), "prints what each rule deleted. Days under legal hold are kept.")
+		fmt.Fprintln(fs.Output())
+		fmt.Fprintln(fs.Output(), "Options:")
+		fs.PrintDefaults()
+	}
+	dbPath := fs.String("db", defaultDBPath, "sqlite db path")
+	logPath := fs.String("log", defaultLogPath, "log target path ('-' for stdout only)")
+	if err := fs.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return nil
+		}
+		return err
+	}
+
+	app, closeApp, err := openAdminApp(*dbPath, *logPath)
+	if err != nil {
+		return err
+	}
+	defer closeApp()
+	results, err := app.applyRetention(time.Now())
+	for _, r := range results {
+		fmt.Printf("%s\tmax_age_days=%d\tcutoff=%s\tdeleted=%d\n", r.Target, r.MaxAgeDays, r.Cutoff, r.Deleted)
+	}
+	if err != nil {
+		return err
+	}
+	if len(results) == 0 {
+		fmt.Println("no retention rules configured")
+	}
+	return nil
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRetentionRules(t *testing.T) {
+	app := newTestApp(t)
+	for _, rules := range [][]retentionRule{
+		{{Target: "users", MaxAgeDays: 30}},
+		{{Target: "api_usage", MaxAgeDays: 0}},
+		{{Target: "api_usage", MaxAgeDays: 30}, {Target: "API_USAGE", MaxAgeDays: 60}},
+	} {
+		if _, _, err := app.updateSettings(settingsPatch{RetentionRules: &rules}, "admin"); err == nil {
+			t.Fatalf("expected rules %+v to be rejected", rules)
+		}
+	}
+	for _, at := range []string{"2025-01-01T09:00:00Z", "2025-01-02T09:00:00Z", "2025-01-03T09:00:00Z"} {
+		if err := app.appendAuditRow(auditRecord{ActorType: "system", ActorUsername: "scheduler", Action: "old", Metadata: "-", CreatedAt: at}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	createUser(t, app, "alice", "PUDRETAINAL")
+	alice, err := app.lookupToken("PUDRETAINAL")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, day := range []string{"2025-01-05", "2025-01-06"} {
+		if _, err := app.insertEntry(alice.ID, "shipped on "+day, "", sourceAPI, day+"T10:00:00Z"); err != nil {
+			t.Fatal(err)
+		}
+		if err := app.compactDay(day); err != nil {
+			t.Fatalf("compactDay: %v", err)
+		}
+		if _, err := app.db.Exec(`UPDATE entries SET created_at = ? WHERE entry_type = 'daily_compact' AND content LIKE ?`, day+"T17:00:00Z", compactHeaderPrefix+day+"%"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := app.db.Exec(`INSERT INTO legal_holds(start_day, end_day, reason, created_at) VALUES('2025-01-06', '2025-01-06', 'incident', ?)`, nowUTC()); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := app.db.Exec(`INSERT INTO api_usage(day, user_id, requests, bytes_in, bytes_out) VALUES('2025-01-05', ?, 3, 0, 0), (?, ?, 1, 0, 0)`, alice.ID, time.Now().UTC().Format("2006-01-02"), alice.ID); err != nil {
+		t.Fatal(err)
+	}
+	rules := []retentionRule{{Target: " Action_Logs ", MaxAgeDays: 90}, {Target: "compacts", MaxAgeDays: 365}, {Target: "api_usage", MaxAgeDays: 30}}
+	s, _, err := app.updateSettings(settingsPatch{RetentionRules: &rules}, "admin")
+	if err != nil || s.RetentionRules[0].Target != retentionActionLogs {
+		t.Fatalf("updateSettings: %+v err=%v", s.RetentionRules, err)
+	}
+
+	results, err := app.applyRetention(time.Now())
+	if err != nil || len(results) != 3 {
+		t.Fatalf("applyRetention: %+v err=%v", results, err)
+	}
+	deleted := map[string]int{}
+	for _, r := range results {
+		deleted[r.Target] = r.Deleted
+	}
+	if deleted[retentionActionLogs] != 3 || deleted[retentionCompacts] != 1 || deleted["api_usage"] != 1 {
+		t.Fatalf("unexpected deletions: %v", deleted)
+	}
+	if n, problems, err := app.verifyAuditChain(); err != nil || len(problems) != 0 || n == 0 {
+		t.Fatalf("audit chain after pruning: n=%d problems=%+v err=%v", n, problems, err)
+	}
+	var compacts, rows int
+	if err := app.db.QueryRow(`SELECT COUNT(*) FROM entries WHERE entry_type = 'daily_compact'`).Scan(&compacts); err != nil || compacts != 1 {
+		t.Fatalf("expected only the held day's compact left, got %d err=%v", compacts, err)
+	}
+	if err := app.db.QueryRow(`SELECT COUNT(*) FROM compactions WHERE day = '2025-01-05'`).Scan(&rows); err != nil || rows != 0 {
+		t.Fatalf("expected the expired day's compactions row removed, got %d err=%v", rows, err)
+	}
+	var step string
+	if err := app.db.QueryRow(`SELECT step FROM compaction_journal WHERE day = '2025-01-05' ORDER BY id DESC LIMIT 1`).Scan(&step); err != nil || step != journalExpired {
+		t.Fatalf("journal: %q err=%v", step, err)
+	}
+	if issues, err := app.findIntegrityIssues(); err != nil || len(issues) != 0 {
+		t.Fatalf("integrity: %+v err=%v", issues, err)
+	}
+	var logged int
+	if err := app.db.QueryRow(`SELECT COUNT(*) FROM action_logs WHERE action = 'retention_prune'`).Scan(&logged); err != nil || logged != 3 {
+		t.Fatalf("expected one retention_prune row per rule, got %d err=%v", logged, err)
+	}
+
+	results, err = app.applyRetention(time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, r := range results {
+		if r.Deleted != 0 {
+			t.Fatalf("expected nothing left to prune, got %+v", results)
+		}
+	}
+}
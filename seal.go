@@ -0,0 +1,135 @@
+package main
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// Sealed values: secrets the server must read back (calendar refresh tokens,
+// private entry content) are stored as "v1:" + base64(nonce || AES-256-GCM
+// ciphertext). The additional data names the row the value belongs to, so a
+// sealed value copied into another row does not open.
+
+const sealedPrefix = "v1:"
+
+var errUnsealed = errors.New("value is not sealed")
+
+// deriveKey turns key material of any length into the AES-256 key for one
+// purpose, so one secret never keys two unrelated things directly.
+func deriveKey(secret []byte, purpose string) []byte {
+	m := hmac.New(sha256.New, secret)
+	m.Write([]byte(purpose))
+	return m.Sum(nil)
+}
+
+func isSealed(v string) bool {
+	return strings.HasPrefix(v, sealedPrefix)
+}
+
+// sealString encrypts plaintext under key (32 bytes, see deriveKey), bound to aad.
+func sealString(key []byte, aad, plaintext string) (string, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return "", err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	out := gcm.Seal(nonce, nonce, []byte(plaintext), []byte(aad))
+	return sealedPrefix + base64.RawStdEncoding.EncodeToString(out), nil
+}
+
+// openString reverses sealString. A wrong key or aad fails authentication.
+func openString(key []byte, aad, sealed string) (string, error) {
+	if !isSealed(sealed) {
+		return "", errUnsealed
+	}
+	raw, err := base64.RawStdEncoding.DecodeString(sealed[len(sealedPrefix):])
+	if err != nil {
+		return "", err
+	}
+	gcm, err := newGCM(key)
+	if err != nil {
+		return "", err
+	}
+	if len(raw) < gcm.NonceSize() {
+		return "", errors.New("sealed value is truncated")
+	}
+	plain, err := gcm.Open(nil, raw[:gcm.NonceSize()], raw[gcm.NonceSize():], []byte(aad))
+	if err != nil {
+		return "", err
+	}
+	return string(plain), nil
+}
+
+// pbkdf2SHA256 is PBKDF2 (RFC 8018) with HMAC-SHA256, for keys derived
+// from passphrases.
+func pbkdf2SHA256(passphrase, salt []byte, iterations, keyLen int) []byte {
+	prf := hmac.New(sha256.New, passphrase)
+	out := make([]byte, 0, keyLen)
+	u := make([]byte, prf.Size())
+	t := make([]byte, prf.Size())
+	for block := uint32(1); len(out) < keyLen; block++ {
+		prf.Reset()
+		prf.Write(salt)
+		prf.Write([]byte{byte(block >> 24), byte(block >> 16), byte(block >> 8), byte(block)})
+		u = prf.Sum(u[:0])
+		copy(t, u)
+		for i := 1; i < iterations; i++ {
+			prf.Reset()
+			prf.Write(u)
+			u = prf.Sum(u[:0])
+			for j := range t {
+				t[j] ^= u[j]
+			}
+		}
+		out = append(out, t...)
+	}
+	return out[:keyLen]
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// readOrCreateKeyFile reads a key file like readKeyFile. When the file does
+// not exist it is created with 32 random bytes (hex, mode 0600) and created
+// reports true. The file lives next to the database, not in it, so database
+// copies (backups, snapshots, standbys) do not carry it.
+func readOrCreateKeyFile(path string) (key []byte, created bool, err error) {
+	key, err = readKeyFile(path)
+	if err == nil || !errors.Is(err, os.ErrNotExist) {
+		return key, false, err
+	}
+	raw := make([]byte, 32)
+	if _, err := rand.Read(raw); err != nil {
+		return nil, false, err
+	}
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o600)
+	if err != nil {
+		return nil, false, err
+	}
+	if _, err := fmt.Fprintln(f, hex.EncodeToString(raw)); err != nil {
+		_ = f.Close()
+		return nil, false, err
+	}
+	if err := f.Close(); err != nil {
+		return nil, false, err
+	}
+	key, err = readKeyFile(path)
+	return key, err == nil, err
+}
@@ -0,0 +1,463 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Full-text search: GET /api/search goes through the provider picked with
+// --search. Providers only find and rank entry ids; the entries themselves
+// are always read back from SQLite, so a lagging external index can never
+// surface trashed, purged or compacted-away content.
+
+const (
+	searchSQLite        = "sqlite"
+	searchElasticsearch = "elasticsearch"
+
+	maxSearchQueryLen  = 200
+	defaultSearchLimit = 50
+	maxSearchLimit     = 200
+)
+
+// searchQuery is one search; From and To are inclusive days, "" when open.
+type searchQuery struct {
+	Text  string
+	User  string
+	From  string
+	To    string
+	Limit int
+}
+
+// searchProvider finds live entries (daily compacts included) matching q and
+// returns their ids, best match first.
+type searchProvider interface {
+	Name() string
+	Search(ctx context.Context, q searchQuery) ([]int64, error)
+}
+
+// newSearchProvider resolves --search. The external providers reuse the
+// index the corresponding indexer keeps up to date.
+func (a *App) newSearchProvider(name string) (searchProvider, error) {
+	switch strings.TrimSpace(name) {
+	case "", searchSQLite:
+		return sqliteSearch{db: a.db}, nil
+	case searchElasticsearch:
+		if a.esIndexer == nil {
+			return nil, errors.New("--search elasticsearch needs --es-url")
+		}
+		return esSearch{ix: a.esIndexer}, nil
+	case searchBleve:
+		if a.bleve == nil {
+			return nil, errors.New("--search bleve has no open index")
+		}
+		return bleveSearch{b: a.bleve}, nil
+	default:
+		return nil, fmt.Errorf("invalid --search %q (want %s, %s or %s)", name, searchSQLite, searchBleve, searchElasticsearch)
+	}
+}
+
+// searcher is the configured provider, SQLite when none was set.
+func (a *App) searcher() searchProvider {
+	if a.search != nil {
+		return a.search
+	}
+	return sqliteSearch{db: a.db}
+}
+
+// ensureSearchIndex creates the FTS4 index over entries.content and the
+// triggers that keep it in step. It is an external-content table, so the
+// text is stored once, in entries; a newly created index is filled from the
+// existing rows.
+func (a *App) ensureSearchIndex() error {
+	var n int
+	if err := a.db.QueryRow(`SELECT COUNT(*) FROM sqlite_master WHERE name = 'entries_fts'`).Scan(&n); err != nil {
+		return err
+	}
+	stmts := []string{
+		`CREATE VIRTUAL TABLE IF NOT EXISTS entries_fts USING fts4(content="entries", content, tokenize=unicode61)`,
+		// FTS4 reads the old text from entries, so removal must happen before the row changes.
+		`CREATE TRIGGER IF NOT EXISTS entries_fts_bu BEFORE UPDATE OF content ON entries BEGIN DELETE FROM entries_fts WHERE docid = OLD.id; END`,
+		`CREATE TRIGGER IF NOT EXISTS entries_fts_bd BEFORE DELETE ON entries BEGIN DELETE FROM entries_fts WHERE docid = OLD.id; END`,
+		`CREATE TRIGGER IF NOT EXISTS entries_fts_au AFTER UPDATE OF content ON entries BEGIN INSERT INTO entries_fts(docid, content) VALUES(NEW.id, NEW.content); END`,
+		`CREATE TRIGGER IF NOT EXISTS entries_fts_ai AFTER INSERT ON entries BEGIN INSERT INTO entries_fts(docid, content) VALUES(NEW.id, NEW.content); END`,
+	}
+	for _, s := range stmts {
+		if _, err := a.db.Exec(s); err != nil {
+			return fmt.Errorf("search index: %w", err)
+		}
+	}
+	if n == 0 {
+		if _, err := a.db.Exec(`INSERT INTO entries_fts(entries_fts) VALUES('rebuild')`); err != nil {
+			return fmt.Errorf("search index rebuild: %w", err)
+		}
+	}
+	return nil
+}
+
+// searchOutboxTriggers queue the id of every inserted, edited, trashed,
+// restored or deleted entry for the external indexers. They only exist while
+// serve runs with at least one indexer, so the outbox cannot grow unread.
+var searchOutboxTriggers = map[string]string{
+	"search_outbox_insert": `CREATE TRIGGER IF NOT EXISTS search_outbox_insert AFTER INSERT ON entries
+BEGIN
+	INSERT INTO search_outbox(entry_id, queued_at) VALUES(NEW.id, strftime('%Y-%m-%dT%H:%M:%SZ', 'now'));
+END`,
+	"search_outbox_update": `CREATE TRIGGER IF NOT EXISTS search_outbox_update AFTER UPDATE OF content, category, deleted_at ON entries
+BEGIN
+	INSERT INTO search_outbox(entry_id, queued_at) VALUES(NEW.id, strftime('%Y-%m-%dT%H:%M:%SZ', 'now'));
+END`,
+	"search_outbox_delete": `CREATE TRIGGER IF NOT EXISTS search_outbox_delete AFTER DELETE ON entries
+BEGIN
+	INSERT INTO search_outbox(entry_id, queued_at) VALUES(OLD.id, strftime('%Y-%m-%dT%H:%M:%SZ', 'now'));
+END`,
+}
+
+// setSearchOutbox configures the outbox for the given consumers (one per
+// external index), each reading it through its own cursor. A new consumer
+// starts just before the oldest queued change, so nothing pending is lost;
+// cursors of consumers no longer configured are dropped. With no consumers
+// the triggers are dropped and the outbox cleared.
+func (a *App) setSearchOutbox(consumers ...string) error {
+	for name, ddl := range searchOutboxTriggers {
+		stmt := ddl
+		if len(consumers) == 0 {
+			stmt = `DROP TRIGGER IF EXISTS ` + name
+		}
+		if _, err := a.db.Exec(stmt); err != nil {
+			return fmt.Errorf("search outbox trigger %s: %w", name, err)
+		}
+	}
+	if len(consumers) == 0 {
+		if _, err := a.db.Exec(`DELETE FROM search_outbox_cursors`); err != nil {
+			return err
+		}
+		_, err := a.db.Exec(`DELETE FROM search_outbox`)
+		return err
+	}
+	args := make([]any, len(consumers))
+	for i, c := range consumers {
+		args[i] = c
+	}
+	if _, err := a.db.Exec(`DELETE FROM search_outbox_cursors WHERE consumer NOT IN (`+placeholders(len(consumers))+`)`, args...); err != nil {
+		return err
+	}
+	for _, c := range consumers {
+		if _, err := a.db.Exec(`
+INSERT OR IGNORE INTO search_outbox_cursors(consumer, last_id, updated_at)
+SELECT ?, COALESCE(MIN(id) - 1, 0), ? FROM search_outbox`, c, nowUTC()); err != nil {
+			return err
+		}
+	}
+	return a.trimSearchOutbox()
+}
+
+// outboxBatch returns the next distinct entry ids queued for consumer, at
+// most searchIndexBatch changes, and the last outbox id they cover.
+func (a *App) outboxBatch(consumer string) ([]int64, int64, error) {
+	rows, err := a.db.Query(`
+SELECT id, entry_id FROM search_outbox
+WHERE id > (SELECT last_id FROM search_outbox_cursors WHERE consumer = ?)
+ORDER BY id ASC LIMIT ?`, consumer, searchIndexBatch)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+	var last int64
+	var ids []int64
+	seen := map[int64]bool{}
+	for rows.Next() {
+		var qid, entryID int64
+		if err := rows.Scan(&qid, &entryID); err != nil {
+			return nil, 0, err
+		}
+		last = qid
+		if !seen[entryID] {
+			seen[entryID] = true
+			ids = append(ids, entryID)
+		}
+	}
+	return ids, last, rows.Err()
+}
+
+// advanceOutbox records that consumer has indexed everything up to last and
+// drops the changes every consumer is past.
+func (a *App) advanceOutbox(consumer string, last int64) error {
+	if _, err := a.db.Exec(`UPDATE search_outbox_cursors SET last_id = ?, updated_at = ? WHERE consumer = ?`, last, nowUTC(), consumer); err != nil {
+		return err
+	}
+	return a.trimSearchOutbox()
+}
+
+func (a *App) trimSearchOutbox() error {
+	_, err := a.db.Exec(`DELETE FROM search_outbox WHERE id <= (SELECT MIN(last_id) FROM search_outbox_cursors)`)
+	return err
+}
+
+// searchOutboxDepth is the number of changes consumer has not indexed yet.
+func (a *App) searchOutboxDepth(consumer string) (int64, error) {
+	var n int64
+	err := a.db.QueryRow(`
+SELECT COUNT(*) FROM search_outbox
+WHERE id > COALESCE((SELECT last_id FROM search_outbox_cursors WHERE consumer = ?), 0)`, consumer).Scan(&n)
+	return n, err
+}
+
+// ftsQuery turns free text into an FTS MATCH expression: every word must
+// appear, and each is quoted so FTS operators in user input stay literal.
+func ftsQuery(text string) string {
+	var terms []string
+	for _, w := range strings.Fields(text) {
+		w = strings.ReplaceAll(w, `"`, "")
+		if w != "" {
+			terms = append(terms, `"`+w+`"`)
+		}
+	}
+	return strings.Join(terms, " ")
+}
+
+// sqliteSearch is the default provider: the FTS4 index in the main database.
+// FTS4 has no relevance ranking, so matches come back newest first.
+type sqliteSearch struct {
+	db *sql.DB
+}
+
+func (sqliteSearch) Name() string { return searchSQLite }
+
+func (s sqliteSearch) Search(ctx context.Context, q searchQuery) ([]int64, error) {
+	match := ftsQuery(q.Text)
+	if match == "" {
+		return nil, nil
+	}
+	where := `entries_fts MATCH ? AND e.deleted_at IS NULL`
+	args := []any{match}
+	if q.User != "" {
+		where += ` AND u.username = ?`
+		args = append(args, q.User)
+	}
+	if q.From != "" {
+		where += ` AND date(e.created_at) >= ?`
+		args = append(args, q.From)
+	}
+	if q.To != "" {
+		where += ` AND date(e.created_at) <= ?`
+		args = append(args, q.To)
+	}
+	rows, err := s.db.QueryContext(ctx, `
+SELECT e.id
+FROM entries_fts f
+JOIN entries e ON e.id = f.docid
+JOIN users u ON u.id = e.user_id
+WHERE `+where+`
+ORDER BY e.created_at DESC, e.id DESC
+LIMIT ?`, append(args, q.Limit)...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var ids []int64
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
+// esSearch queries the index kept by the Elasticsearch/OpenSearch indexer,
+// ranked by relevance.
+type esSearch struct {
+	ix *esIndexer
+}
+
+func (esSearch) Name() string { return searchElasticsearch }
+
+func (s esSearch) Search(ctx context.Context, q searchQuery) ([]int64, error) {
+	filters := []any{}
+	if q.User != "" {
+		filters = append(filters, map[string]any{"term": map[string]string{"user": q.User}})
+	}
+	if q.From != "" || q.To != "" {
+		day := map[string]string{}
+		if q.From != "" {
+			day["gte"] = q.From
+		}
+		if q.To != "" {
+			day["lte"] = q.To
+		}
+		filters = append(filters, map[string]any{"range": map[string]any{"day": day}})
+	}
+	body, err := json.Marshal(map[string]any{
+		"size":    q.Limit,
+		"_source": false,
+		"query": map[string]any{"bool": map[string]any{
+			"must":   map[string]any{"match": map[string]any{"content": map[string]string{"query": q.Text, "operator": "and"}}},
+			"filter": filters,
+		}},
+		"sort": []any{"_score", map[string]string{"created_at": "desc"}},
+	})
+	if err != nil {
+		return nil, err
+	}
+	out, err := s.ix.do(ctx, http.MethodPost, "/"+url.PathEscape(s.ix.index)+"/_search", "application/json", body)
+	if err != nil {
+		return nil, err
+	}
+	var resp struct {
+		Hits struct {
+			Hits []struct {
+				ID string `json:"_id"`
+			} `json:"hits"`
+		} `json:"hits"`
+	}
+	if err := json.Unmarshal(out, &resp); err != nil {
+		return nil, fmt.Errorf("parse search response: %w", err)
+	}
+	ids := make([]int64, 0, len(resp.Hits.Hits))
+	for _, h := range resp.Hits.Hits {
+		if id, err := strconv.ParseInt(h.ID, 10, 64); err == nil {
+			ids = append(ids, id)
+		}
+	}
+	return ids, nil
+}
+
+// entriesByID loads the live entries among ids, in the order given.
+func (a *App) entriesByID(ids []int64) ([]entryRow, error) {
+	out := []entryRow{}
+	if len(ids) == 0 {
+		return out, nil
+	}
+	args := make([]any, len(ids))
+	for i, id := range ids {
+		args[i] = id
+	}
+	rows, err := a.db.Query(`
+SELECT e.id, u.username, u.kind, e.entry_type, e.category, e.source, e.content, e.created_at, COALESCE(e.edited_at, '')
+FROM entries e
+JOIN users u ON u.id = e.user_id
+WHERE e.deleted_at IS NULL AND e.id IN (`+placeholders(len(ids))+`)`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	byID := map[int64]entryRow{}
+	for rows.Next() {
+		var e entryRow
+		if err := rows.Scan(&e.ID, &e.User, &e.UserKind, &e.EntryType, &e.Category, &e.Source, &e.Content, &e.CreatedAt, &e.EditedAt); err != nil {
+			return nil, err
+		}
+		byID[e.ID] = e
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	for _, id := range ids {
+		if e, ok := byID[id]; ok {
+			out = append(out, e)
+		}
+	}
+	return out, nil
+}
+
+// handleSearch serves GET /api/search?q=...&user=&from=&to=&limit=&source=.
+func (a *App) handleSearch(w http.ResponseWriter, r *http.Request, u AuthedUser) {
+	if r.Method != http.MethodGet {
+		jsonErr(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	params := r.URL.Query()
+	sq := searchQuery{
+		Text:  strings.Join(strings.Fields(params.Get("q")), " "),
+		User:  strings.TrimPrefix(strings.TrimSpace(params.Get("user")), "@"),
+		From:  strings.TrimSpace(params.Get("from")),
+		To:    strings.TrimSpace(params.Get("to")),
+		Limit: defaultSearchLimit,
+	}
+	if sq.Text == "" {
+		jsonErr(w, http.StatusBadRequest, "q is required")
+		return
+	}
+	if len(sq.Text) > maxSearchQueryLen {
+		jsonErr(w, http.StatusBadRequest, fmt.Sprintf("q must be at most %d bytes", maxSearchQueryLen))
+		return
+	}
+	for _, d := range []string{sq.From, sq.To} {
+		if _, err := time.Parse("2006-01-02", d); d != "" && err != nil {
+			jsonErr(w, http.StatusBadRequest, "from and to must be YYYY-MM-DD")
+			return
+		}
+	}
+	if raw := strings.TrimSpace(params.Get("limit")); raw != "" {
+		n, err := strconv.Atoi(raw)
+		if err != nil || n < 1 || n > maxSearchLimit {
+			jsonErr(w, http.StatusBadRequest, fmt.Sprintf("limit must be 1..%d", maxSearchLimit))
+			return
+		}
+		sq.Limit = n
+	}
+	anonymous, err := a.wantAnonymous(r)
+	if err != nil {
+		jsonErr(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if anonymous && sq.User != "" {
+		jsonErr(w, http.StatusBadRequest, "user filter is not available in anonymous mode")
+		return
+	}
+	sources, err := parseSourceFilter(params.Get("source"))
+	if err != nil {
+		jsonErr(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	p := a.searcher()
+	ids, err := p.Search(r.Context(), sq)
+	if err != nil {
+		a.logger.Printf("event=search_failed provider=%s err=%v", p.Name(), err)
+		status := http.StatusInternalServerError
+		if p.Name() != searchSQLite {
+			status = http.StatusBadGateway
+		}
+		jsonErr(w, status, "search failed")
+		return
+	}
+	entries, err := a.entriesByID(ids)
+	if err != nil {
+		jsonErr(w, http.StatusInternalServerError, "failed to query entries")
+		return
+	}
+	if len(sources) > 0 {
+		// Applied to the provider's matches: the search indexes do not
+		// carry the source.
+		entries = slices.DeleteFunc(entries, func(e entryRow) bool { return !slices.Contains(sources, e.Source) })
+	}
+	loaded := make([]int64, len(entries))
+	for i, e := range entries {
+		loaded[i] = e.ID
+	}
+	issues, err := a.entryIssues(loaded)
+	if err != nil {
+		jsonErr(w, http.StatusInternalServerError, "failed to query issues")
+		return
+	}
+	for i := range entries {
+		entries[i].Issues = issues[entries[i].ID]
+		entries[i].URL = a.entryURL(entries[i].ID, entries[i].CreatedAt)
+		if anonymous {
+			entries[i] = anonymizeEntry(entries[i])
+		}
+	}
+	_ = a.logUserAction(u, "search", fmt.Sprintf("provider=%s terms=%d count=%d", p.Name(), len(strings.Fields(sq.Text)), len(entries)))
+	jsonOut(w, http.StatusOK, map[string]any{"provider": p.Name(), "entries": entries})
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSearchProviders(t *testing.T) {
+	app := newTestApp(t)
+	h := newTestMux(app)
+	createUser(t, app, "alice", "PUDFINDER01")
+	createUser(t, app, "bob", "PUDFINDER02")
+	ids := map[string]int64{}
+	for _, p := range []struct{ token, content string }{
+		{"PUDFINDER01", "Fixed the flaky deploy pipeline"},
+		{"PUDFINDER02", "Reviewed deploy checklist OR docs"},
+		{"PUDFINDER01", "Café menu for the offsite"},
+	} {
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, authedReq(t, http.MethodPost, "/api/entries", map[string]string{"content": p.content}, p.token))
+		if rr.Code != http.StatusCreated {
+			t.Fatalf("post: %d %s", rr.Code, rr.Body.String())
+		}
+		var e entryRow
+		_ = json.Unmarshal(rr.Body.Bytes(), &e)
+		ids[p.content] = e.ID
+	}
+	search := func(query string) (int, []entryRow, string) {
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, authedReq(t, http.MethodGet, "/api/search?"+query, nil, "PUDFINDER01"))
+		var got struct {
+			Provider string     `json:"provider"`
+			Entries  []entryRow `json:"entries"`
+		}
+		_ = json.Unmarshal(rr.Body.Bytes(), &got)
+		return rr.Code, got.Entries, got.Provider
+	}
+
+	code, got, provider := search("q=DEPLOY")
+	if code != http.StatusOK || provider != searchSQLite || len(got) != 2 || got[0].User != "bob" || got[0].URL == "" {
+		t.Fatalf("deploy: %d %s %+v", code, provider, got)
+	}
+	if _, got, _ := search("q=deploy+flaky&user=alice"); len(got) != 1 || got[0].User != "alice" {
+		t.Fatalf("user filter: %+v", got)
+	}
+	if _, got, _ := search("q=cafe"); len(got) != 1 {
+		t.Fatalf("diacritics folded: %+v", got)
+	}
+	// FTS operators in the query are plain words.
+	if _, got, _ := search("q=deploy+OR+docs"); len(got) != 1 || got[0].User != "bob" {
+		t.Fatalf("OR taken literally: %+v", got)
+	}
+	for _, q := range []string{"q=", "q=deploy&from=yesterday", "q=deploy&limit=0"} {
+		if code, _, _ := search(q); code != http.StatusBadRequest {
+			t.Fatalf("%s: %d", q, code)
+		}
+	}
+
+	// Edits and trash reach the index.
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, authedReq(t, http.MethodPut, fmt.Sprintf("/api/entries/%d", ids["Fixed the flaky deploy pipeline"]), map[string]string{"content": "Fixed the flaky release pipeline"}, "PUDFINDER01"))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("edit: %d %s", rr.Code, rr.Body.String())
+	}
+	rr = httptest.NewRecorder()
+	h.ServeHTTP(rr, authedReq(t, http.MethodDelete, fmt.Sprintf("/api/entries/%d", ids["Reviewed deploy checklist OR docs"]), nil, "PUDFINDER02"))
+	if rr.Code != http.StatusOK && rr.Code != http.StatusNoContent {
+		t.Fatalf("delete: %d %s", rr.Code, rr.Body.String())
+	}
+	if _, got, _ := search("q=deploy"); len(got) != 0 {
+		t.Fatalf("after edit and trash: %+v", got)
+	}
+	if err := app.compactDay(time.Now().UTC().Format("2006-01-02")); err != nil {
+		t.Fatalf("compactDay: %v", err)
+	}
+	if _, got, _ := search("q=release"); len(got) != 1 || got[0].EntryType != "daily_compact" {
+		t.Fatalf("compact searchable: %+v", got)
+	}
+
+	// The Elasticsearch provider ranks ids; entries still come from SQLite.
+	var compactID int64
+	if err := app.db.QueryRow(`SELECT id FROM entries WHERE entry_type = 'daily_compact'`).Scan(&compactID); err != nil {
+		t.Fatal(err)
+	}
+	var searched map[string]any
+	es := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/devlog/_search" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		_ = json.NewDecoder(r.Body).Decode(&searched)
+		fmt.Fprintf(w, `{"hits":{"hits":[{"_id":"999999"},{"_id":"%d"}]}}`, compactID)
+	}))
+	defer es.Close()
+	if _, err := app.newSearchProvider(searchElasticsearch); err == nil {
+		t.Fatal("elasticsearch provider without --es-url")
+	}
+	var err error
+	if app.esIndexer, err = parseESIndexer(es.URL, defaultESIndex, ""); err != nil {
+		t.Fatal(err)
+	}
+	if app.search, err = app.newSearchProvider(searchElasticsearch); err != nil {
+		t.Fatal(err)
+	}
+	code, got, provider = search("q=release&user=alice&from=2026-01-01")
+	if code != http.StatusOK || provider != searchElasticsearch || len(got) != 1 || got[0].ID != compactID {
+		t.Fatalf("elasticsearch: %d %s %+v", code, provider, got)
+	}
+	if b, _ := json.Marshal(searched); !strings.Contains(string(b), `"user":"alice"`) || !strings.Contains(string(b), `"gte":"2026-01-01"`) {
+		t.Fatalf("elasticsearch query: %s", b)
+	}
+	es.Close()
+	if code, _, _ := search("q=release"); code != http.StatusBadGateway {
+		t.Fatalf("elasticsearch down: %d", code)
+	}
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+type secretPattern struct {
+	name string
+	re   *regexp.Regexp
+}
+
+// secretPatterns lists credential shapes that commonly leak into dev log entries.
+// Order matters: broader patterns come last so specific names win in reports.
+var secretPatterns = []secretPattern{
+	{"private_key", regexp.MustCompile(`(?s)-----BEGIN [A-Z ]*PRIVATE KEY-----.*?-----END [A-Z ]*PRIVATE KEY-----`)},
+	{"aws_access_key", regexp.MustCompile(`\b(?:AKIA|ASIA)[0-9A-Z]{16}\b`)},
+	{"github_token", regexp.MustCompile(`\bgh[pousr]_[A-Za-z0-9]{36,}\b`)},
+	{"slack_token", regexp.MustCompile(`\bxox[abprs]-[A-Za-z0-9-]{10,}`)},
+	{"devlog_token", regexp.MustCompile(`\b` + tokenPrefix + `[` + tokenSlugChars + `]{9}\b`)},
+	{"bearer_token", regexp.MustCompile(`(?i)\bbearer\s+[A-Za-z0-9._~+/-]{20,}=*`)},
+	{"assignment", regexp.MustCompile(`(?i)\b(?:password|passwd|pwd|secret|api[_-]?key|access[_-]?token)\s*[:=]\s*(['"]?[^\s'"]{6,}['"]?)`)},
+}
+
+// redactionMarker matches a value that an earlier pattern already replaced,
+// e.g. the key in "password=[REDACTED:aws_access_key]".
+var redactionMarker = regexp.MustCompile(`^['"]?\[REDACTED:[a-z_]+\]['"]?$`)
+
+// scanSecrets reports which secret patterns occur in content and returns the
+// content with every match replaced by a [REDACTED:<name>] marker.
+func scanSecrets(content string) (string, []string) {
+	found := map[string]bool{}
+	out := content
+	for _, p := range secretPatterns {
+		out = p.re.ReplaceAllStringFunc(out, func(m string) string {
+			// Patterns with a value group leave already-redacted values alone.
+			if sub := p.re.FindStringSubmatch(m); len(sub) > 1 && redactionMarker.MatchString(sub[1]) {
+				return m
+			}
+			found[p.name] = true
+			return "[REDACTED:" + p.name + "]"
+		})
+	}
+	names := make([]string, 0, len(found))
+	for name := range found {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return out, names
+}
+
+// scanImportedSecrets is the storeUserEntry secret scan for ingest paths that
+// write entries themselves (git, wiki and CI imports): content comes back
+// redacted unless --redact-secrets=false, along with the kinds found.
+func (a *App) scanImportedSecrets(content string) (string, []string) {
+	redacted, secrets := scanSecrets(content)
+	if len(secrets) > 0 && a.redactSecrets {
+		return redacted, secrets
+	}
+	return content, secrets
+}
+
+// logImportedSecrets audits what scanImportedSecrets found in entry id as
+// secret_detected by the importing actor.
+func (a *App) logImportedSecrets(act actorIdentity, id int64, secrets []string) {
+	if len(secrets) == 0 {
+		return
+	}
+	_ = a.logActorAction(act, "secret_detected", fmt.Sprintf("entry_id=%d kinds=%s redacted=%t", id, strings.Join(secrets, ","), a.redactSecrets))
+}
@@ -0,0 +1,442 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Self-update: a release is a directory (any static HTTP host) holding one
+// binary per platform, named team-dev-log-<os>-<arch>, plus manifest.json
+// listing each file's SHA-256 and size and manifest.json.sig, the hex Ed25519
+// signature of the manifest bytes. 'self-update sign' writes both from a
+// build directory; 'self-update' fetches them, checks the signature with the
+// operator's public key, downloads this platform's binary next to the
+// running one, checks its hash and that it runs, and renames it over the
+// executable. The old binary is kept as <exe>.previous.
+
+// version is the release this binary was built as, set at build time with
+// -ldflags "-X main.version=v1.4.0".
+var version = "dev"
+
+const (
+	releaseBinaryPrefix = "team-dev-log-"
+	releaseManifestName = "manifest.json"
+	releaseSigName      = "manifest.json.sig"
+	maxManifestBytes    = 1 << 20
+)
+
+type releaseManifest struct {
+	Version     string                   `json:"version"`
+	PublishedAt string                   `json:"published_at"`
+	Binaries    map[string]releaseBinary `json:"binaries"`
+}
+
+// releaseBinary is one platform's file, keyed "<os>/<arch>" in the manifest.
+type releaseBinary struct {
+	File   string `json:"file"`
+	SHA256 string `json:"sha256"`
+	Size   int64  `json:"size"`
+}
+
+func platformKey() string {
+	return runtime.GOOS + "/" + runtime.GOARCH
+}
+
+// compareVersions orders "v1.2.3"-style versions numerically. A version that
+// does not parse (a "dev" build) sorts before every release.
+func compareVersions(a, b string) int {
+	pa, okA := parseVersion(a)
+	pb, okB := parseVersion(b)
+	switch {
+	case !okA && !okB:
+		return strings.Compare(a, b)
+	case !okA:
+		return -1
+	case !okB:
+		return 1
+	}
+	for i := 0; i < len(pa) || i < len(pb); i++ {
+		var x, y int
+		if i < len(pa) {
+			x = pa[i]
+		}
+		if i < len(pb) {
+			y = pb[i]
+		}
+		if x != y {
+			if x < y {
+				return -1
+			}
+			return 1
+		}
+	}
+	return 0
+}
+
+func parseVersion(v string) ([]int, bool) {
+	v = strings.TrimPrefix(strings.TrimSpace(v), "v")
+	if v == "" {
+		return nil, false
+	}
+	var out []int
+	for _, part := range strings.Split(v, ".") {
+		n, err := strconv.Atoi(part)
+		if err != nil || n < 0 {
+			return nil, false
+		}
+		out = append(out, n)
+	}
+	return out, true
+}
+
+// signRelease writes manifest.json and manifest.json.sig for the
+// team-dev-log-<os>-<arch> binaries in dir.
+func signRelease(dir, ver string, priv ed25519.PrivateKey) (releaseManifest, error) {
+	m := releaseManifest{Version: ver, PublishedAt: nowUTC(), Binaries: map[string]releaseBinary{}}
+	ents, err := os.ReadDir(dir)
+	if err != nil {
+		return m, err
+	}
+	for _, e := range ents {
+		name := e.Name()
+		if e.IsDir() || !strings.HasPrefix(name, releaseBinaryPrefix) {
+			continue
+		}
+		goos, goarch, ok := strings.Cut(strings.TrimPrefix(name, releaseBinaryPrefix), "-")
+		if !ok || goos == "" || goarch == "" {
+			continue
+		}
+		sum, size, err := fileSHA256(filepath.Join(dir, name))
+		if err != nil {
+			return m, err
+		}
+		m.Binaries[goos+"/"+goarch] = releaseBinary{File: name, SHA256: sum, Size: size}
+	}
+	if len(m.Binaries) == 0 {
+		return m, fmt.Errorf("no %s<os>-<arch> binaries in %s", releaseBinaryPrefix, dir)
+	}
+	b, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return m, err
+	}
+	b = append(b, '\n')
+	if err := os.WriteFile(filepath.Join(dir, releaseManifestName), b, 0o644); err != nil {
+		return m, err
+	}
+	sig := hex.EncodeToString(ed25519.Sign(priv, b)) + "\n"
+	return m, os.WriteFile(filepath.Join(dir, releaseSigName), []byte(sig), 0o644)
+}
+
+func fileSHA256(path string) (string, int64, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", 0, err
+	}
+	defer f.Close()
+	h := sha256.New()
+	n, err := io.Copy(h, f)
+	if err != nil {
+		return "", 0, err
+	}
+	return hex.EncodeToString(h.Sum(nil)), n, nil
+}
+
+// releaseFileURL resolves name against the release base URL.
+func releaseFileURL(base, name string) (string, error) {
+	u, err := url.Parse(strings.TrimRight(base, "/") + "/")
+	if err != nil {
+		return "", err
+	}
+	ref, err := url.Parse(name)
+	if err != nil {
+		return "", err
+	}
+	return u.ResolveReference(ref).String(), nil
+}
+
+func fetchReleaseFile(ctx context.Context, client *http.Client, base, name string, limit int64) (*http.Response, error) {
+	u, err := releaseFileURL(base, name)
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("User-Agent", "team-dev-log/"+version)
+	res, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if res.StatusCode != http.StatusOK {
+		res.Body.Close()
+		return nil, fmt.Errorf("GET %s: %s", u, res.Status)
+	}
+	if limit > 0 {
+		res.Body = struct {
+			io.Reader
+			io.Closer
+		}{io.LimitReader(res.Body, limit), res.Body}
+	}
+	return res, nil
+}
+
+// fetchRelease downloads the manifest and its signature and returns the
+// manifest only if pub signed it.
+func fetchRelease(ctx context.Context, client *http.Client, base string, pub ed25519.PublicKey) (releaseManifest, error) {
+	var m releaseManifest
+	read := func(name string) ([]byte, error) {
+		res, err := fetchReleaseFile(ctx, client, base, name, maxManifestBytes)
+		if err != nil {
+			return nil, err
+		}
+		defer res.Body.Close()
+		return io.ReadAll(res.Body)
+	}
+	body, err := read(releaseManifestName)
+	if err != nil {
+		return m, err
+	}
+	sigHex, err := read(releaseSigName)
+	if err != nil {
+		return m, err
+	}
+	sig, err := hex.DecodeString(strings.TrimSpace(string(sigHex)))
+	if err != nil || !ed25519.Verify(pub, body, sig) {
+		return m, fmt.Errorf("release manifest signature does not verify with key %s", auditKeyID(pub))
+	}
+	if err := json.Unmarshal(body, &m); err != nil {
+		return m, fmt.Errorf("parse release manifest: %w", err)
+	}
+	if m.Version == "" {
+		return m, errors.New("release manifest has no version")
+	}
+	return m, nil
+}
+
+// stageBinary downloads bin to dst and checks its size and SHA-256. dst is
+// removed on failure.
+func stageBinary(ctx context.Context, client *http.Client, base string, bin releaseBinary, dst string) (err error) {
+	if strings.Contains(bin.File, "/") || strings.Contains(bin.File, `\`) {
+		return fmt.Errorf("release file %q must be a plain name", bin.File)
+	}
+	res, err := fetchReleaseFile(ctx, client, base, bin.File, bin.Size+1)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	out, err := os.OpenFile(dst, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0o755)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			_ = os.Remove(dst)
+		}
+	}()
+	h := sha256.New()
+	n, err := io.Copy(io.MultiWriter(out, h), res.Body)
+	if err == nil {
+		err = out.Sync()
+	}
+	if cerr := out.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+	if n != bin.Size {
+		return fmt.Errorf("%s: got %d bytes, manifest says %d", bin.File, n, bin.Size)
+	}
+	if sum := hex.EncodeToString(h.Sum(nil)); sum != bin.SHA256 {
+		return fmt.Errorf("%s: sha256 %s does not match the manifest", bin.File, sum)
+	}
+	return nil
+}
+
+// probeBinary runs '<path> version' and checks it reports want, which
+// catches a binary for the wrong architecture or C library before the swap.
+func probeBinary(path, want string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	out, err := exec.CommandContext(ctx, path, "version").CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("new binary does not run here: %v: %s", err, bytes.TrimSpace(out))
+	}
+	if fields := strings.Fields(string(out)); len(fields) < 2 || fields[1] != want {
+		return fmt.Errorf("new binary reports %q, want version %s", strings.TrimSpace(string(out)), want)
+	}
+	return nil
+}
+
+// swapBinary renames staged over exe, keeping the old file as
+// <exe>.previous. The rename is atomic: a crash leaves either binary whole.
+func swapBinary(staged, exe string) (string, error) {
+	previous := exe + ".previous"
+	_ = os.Remove(previous)
+	if err := os.Link(exe, previous); err != nil {
+		previous = ""
+	}
+	if err := os.Rename(staged, exe); err != nil {
+		return "", err
+	}
+	return previous, nil
+}
+
+func runVersion() error {
+	fmt.Printf("%s %s %s %s\n", binName(), version, platformKey(), runtime.Version())
+	return nil
+}
+
+func runSelfUpdate(args []string) error {
+	if len(args) > 0 && args[0] == "sign" {
+		return runSelfUpdateSign(args[1:])
+	}
+	fs := flag.NewFlagSet("self-update", flag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s self-update --url <release-url> --pubkey-file <key.pem> [options]\n", binName())
+		fmt.Fprintf(fs.Output(), "       %s self-update sign --dir <dist> --version <v> --key <key.pem>\n\n", binName())
+		fmt.Fprintln(fs.Output(), "Replaces this binary with the release at --url if it is newer. The release's")
+		fmt.Fprintln(fs.Output(), "manifest must be signed by --pubkey-file; the binary for this platform must match")
+		fmt.Fprintln(fs.Output(), "the manifest's SHA-256 and run. The old binary is kept as <exe>.previous.")
+		fmt.Fprintln(fs.Output(), "Restart serve afterwards (e.g. systemctl restart team-dev-log).")
+		fmt.Fprintln(fs.Output())
+		fmt.Fprintln(fs.Output(), "Options:")
+		fs.PrintDefaults()
+	}
+	releaseURL := fs.String("url", envOr("DEVLOG_UPDATE_URL", ""), "release base URL holding manifest.json (env DEVLOG_UPDATE_URL)")
+	pubkeyFile := fs.String("pubkey-file", envOr("DEVLOG_UPDATE_PUBKEY_FILE", ""), "PEM Ed25519 public key releases are signed with (env DEVLOG_UPDATE_PUBKEY_FILE)")
+	check := fs.Bool("check", false, "only report whether a newer release exists")
+	force := fs.Bool("force", false, "install the release even if it is not newer (reinstall or downgrade)")
+	timeout := fs.Duration("timeout", 5*time.Minute, "limit for the whole download")
+	if err := fs.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return nil
+		}
+		return err
+	}
+	if strings.TrimSpace(*releaseURL) == "" {
+		return errors.New("--url is required")
+	}
+	if *pubkeyFile == "" {
+		return errors.New("--pubkey-file is required")
+	}
+	pub, err := readVerifyKey(*pubkeyFile)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), *timeout)
+	defer cancel()
+	client := &http.Client{}
+	m, err := fetchRelease(ctx, client, *releaseURL, pub)
+	if err != nil {
+		return err
+	}
+	cmp := compareVersions(m.Version, version)
+	if *check {
+		if cmp > 0 {
+			fmt.Printf("update available: %s -> %s\n", version, m.Version)
+		} else {
+			fmt.Printf("up to date: %s (latest release %s)\n", version, m.Version)
+		}
+		return nil
+	}
+	if cmp <= 0 && !*force {
+		fmt.Printf("up to date: %s (latest release %s)\n", version, m.Version)
+		return nil
+	}
+	bin, ok := m.Binaries[platformKey()]
+	if !ok {
+		return fmt.Errorf("release %s has no binary for %s", m.Version, platformKey())
+	}
+
+	exe, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	if exe, err = filepath.EvalSymlinks(exe); err != nil {
+		return err
+	}
+	// Staged in the executable's directory so the final rename stays on one
+	// filesystem.
+	staged := exe + ".update"
+	if err := stageBinary(ctx, client, *releaseURL, bin, staged); err != nil {
+		return err
+	}
+	if err := probeBinary(staged, m.Version); err != nil {
+		_ = os.Remove(staged)
+		return err
+	}
+	previous, err := swapBinary(staged, exe)
+	if err != nil {
+		_ = os.Remove(staged)
+		return err
+	}
+	fmt.Printf("updated %s: %s -> %s (%s, sha256 %s)\n", exe, version, m.Version, platformKey(), bin.SHA256)
+	if previous != "" {
+		fmt.Printf("previous binary kept as %s\n", previous)
+	}
+	fmt.Println("restart serve to run the new version")
+	return nil
+}
+
+func runSelfUpdateSign(args []string) error {
+	fs := flag.NewFlagSet("self-update sign", flag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s self-update sign --dir <dist> --version <v> --key <key.pem>\n\n", binName())
+		fmt.Fprintf(fs.Output(), "Writes manifest.json and manifest.json.sig for the %s<os>-<arch> binaries\n", releaseBinaryPrefix)
+		fmt.Fprintln(fs.Output(), "in --dir. Upload the directory as is; its URL is self-update's --url.")
+		fmt.Fprintln(fs.Output())
+		fmt.Fprintln(fs.Output(), "Options:")
+		fs.PrintDefaults()
+	}
+	dir := fs.String("dir", "dist", "directory holding the release binaries")
+	ver := fs.String("version", "", "release version the binaries were built as (their -X main.version)")
+	keyFile := fs.String("key", "", "PEM Ed25519 private key (openssl genpkey -algorithm ed25519)")
+	if err := fs.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return nil
+		}
+		return err
+	}
+	if _, ok := parseVersion(*ver); !ok {
+		return errors.New("--version must look like v1.2.3")
+	}
+	if *keyFile == "" {
+		return errors.New("--key is required")
+	}
+	priv, err := readSigningKey(*keyFile)
+	if err != nil {
+		return err
+	}
+	m, err := signRelease(*dir, *ver, priv)
+	if err != nil {
+		return err
+	}
+	platforms := make([]string, 0, len(m.Binaries))
+	for p := range m.Binaries {
+		platforms = append(platforms, p)
+	}
+	sort.Strings(platforms)
+	fmt.Printf("signed %s %s with key %s: %s\n", *dir, m.Version, auditKeyID(priv.Public().(ed25519.PublicKey)), strings.Join(platforms, ", "))
+	return nil
+}
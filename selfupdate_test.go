@@ -0,0 +1,86 @@
+package main
+
+import (
+	"context"
+	"crypto/ed25519"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+func TestSelfUpdate(t *testing.T) {
+	pub, priv, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dist := t.TempDir()
+	// A stand-in binary that answers 'version' like the real one.
+	script := "#!/bin/sh\necho team-dev-log v9.1.0 " + platformKey() + "\n"
+	if err := os.WriteFile(filepath.Join(dist, releaseBinaryPrefix+runtime.GOOS+"-"+runtime.GOARCH), []byte(script), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dist, "README.txt"), []byte("not a binary"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	m, err := signRelease(dist, "v9.1.0", priv)
+	if err != nil {
+		t.Fatalf("signRelease: %v", err)
+	}
+	if len(m.Binaries) != 1 {
+		t.Fatalf("manifest binaries: %+v", m.Binaries)
+	}
+	srv := httptest.NewServer(http.FileServer(http.Dir(dist)))
+	defer srv.Close()
+	ctx := context.Background()
+
+	got, err := fetchRelease(ctx, srv.Client(), srv.URL, pub)
+	if err != nil || got.Version != "v9.1.0" {
+		t.Fatalf("fetchRelease: %+v %v", got, err)
+	}
+	otherPub, _, _ := ed25519.GenerateKey(nil)
+	if _, err := fetchRelease(ctx, srv.Client(), srv.URL, otherPub); err == nil || !strings.Contains(err.Error(), "signature") {
+		t.Fatalf("fetchRelease with the wrong key: %v", err)
+	}
+	if compareVersions("v9.1.0", "dev") <= 0 || compareVersions("v1.10.0", "v1.9.3") <= 0 || compareVersions("v1.2", "v1.2.0") != 0 {
+		t.Fatal("compareVersions ordering")
+	}
+
+	dir := t.TempDir()
+	exe := filepath.Join(dir, "team-dev-log")
+	if err := os.WriteFile(exe, []byte("old"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	bin := got.Binaries[platformKey()]
+	tampered := bin
+	tampered.SHA256 = strings.Repeat("0", 64)
+	if err := stageBinary(ctx, srv.Client(), srv.URL, tampered, exe+".update"); err == nil || !strings.Contains(err.Error(), "sha256") {
+		t.Fatalf("stage with wrong hash: %v", err)
+	}
+	if _, err := os.Stat(exe + ".update"); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("failed stage left %s.update behind: %v", exe, err)
+	}
+	if err := stageBinary(ctx, srv.Client(), srv.URL, bin, exe+".update"); err != nil {
+		t.Fatalf("stageBinary: %v", err)
+	}
+	if err := probeBinary(exe+".update", "v9.2.0"); err == nil {
+		t.Fatal("probe accepted a binary reporting another version")
+	}
+	if err := probeBinary(exe+".update", "v9.1.0"); err != nil {
+		t.Fatalf("probeBinary: %v", err)
+	}
+	previous, err := swapBinary(exe+".update", exe)
+	if err != nil {
+		t.Fatalf("swapBinary: %v", err)
+	}
+	if b, _ := os.ReadFile(exe); string(b) != script {
+		t.Fatalf("exe after swap: %q", b)
+	}
+	if b, _ := os.ReadFile(previous); string(b) != "old" {
+		t.Fatalf("previous binary: %q", b)
+	}
+}
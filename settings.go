@@ -0,0 +1,340 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+const (
+	defaultCompactionHour = 17
+	// maxEntrySizeLimit caps the max_entry_size setting; the quick endpoint
+	// reads bodies up to 1 MiB and must stay well above it.
+	maxEntrySizeLimit = 512 << 10
+	maxBannerLen      = 500
+	maxEditWindowDays = 30
+)
+
+var categoryRe = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]{0,31}$`)
+
+// orgSettings is org-level behavior an admin can change at runtime. It is
+// persisted one key per row in the settings table and cached in App.
+type orgSettings struct {
+	CompactionHour    int             `json:"compaction_hour"`
+	MaxEntrySize      int             `json:"max_entry_size"`
+	AllowedCategories []string        `json:"allowed_categories"`
+	Banner            string          `json:"banner"`
+	ExternalURL       string          `json:"external_url"`
+	EditWindowHours   int             `json:"edit_window_hours"`
+	QuietHours        quietHours      `json:"quiet_hours"`
+	CompactionMode    string          `json:"compaction_mode"`
+	CompactGrouping   string          `json:"compact_grouping"`
+	CompactionTiers   compactionTiers `json:"compaction_tiers"`
+	RetentionRules    []retentionRule `json:"retention_rules"`
+	UpdatedBy         string          `json:"updated_by,omitempty"`
+	UpdatedAt         string          `json:"updated_at,omitempty"`
+}
+
+// defaultSettings are the settings before any row is saved.
+func (a *App) defaultSettings() orgSettings {
+	s := orgSettings{CompactionHour: defaultCompactionHour, MaxEntrySize: defaultMaxEntrySize, AllowedCategories: []string{}, RetentionRules: []retentionRule{}, CompactionMode: compactionModeDelete, CompactGrouping: compactGroupingSingle}
+	if a.compactionHour != nil {
+		s.CompactionHour = *a.compactionHour
+	}
+	return s
+}
+
+// settingsCache holds the last loaded or saved settings. The zero value
+// serves defaults until loadSettings runs.
+type settingsCache struct {
+	mu     sync.RWMutex
+	loaded bool
+	s      orgSettings
+}
+
+// settings returns the cached org settings.
+func (a *App) settings() orgSettings {
+	a.orgSettings.mu.RLock()
+	defer a.orgSettings.mu.RUnlock()
+	if !a.orgSettings.loaded {
+		return a.defaultSettings()
+	}
+	s := a.orgSettings.s
+	s.AllowedCategories = append([]string{}, s.AllowedCategories...)
+	s.RetentionRules = append([]retentionRule{}, s.RetentionRules...)
+	return s
+}
+
+// externalBase is the origin links are built on: the external_url setting,
+// else --external-url, else "" (relative links).
+func (a *App) externalBase() string {
+	a.orgSettings.mu.RLock()
+	s := a.orgSettings.s.ExternalURL
+	a.orgSettings.mu.RUnlock()
+	if s != "" {
+		return s
+	}
+	return a.externalURL
+}
+
+// loadSettings reads the settings table into the cache. Unknown keys are
+// ignored so a downgrade keeps working.
+func (a *App) loadSettings() error {
+	s := a.defaultSettings()
+	rows, err := a.db.Query(`SELECT key, value, updated_by, updated_at FROM settings ORDER BY updated_at ASC`)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var key, value, by, at string
+		if err := rows.Scan(&key, &value, &by, &at); err != nil {
+			return err
+		}
+		var target any
+		switch key {
+		case "compaction_hour":
+			target = &s.CompactionHour
+		case "max_entry_size":
+			target = &s.MaxEntrySize
+		case "allowed_categories":
+			target = &s.AllowedCategories
+		case "banner":
+			target = &s.Banner
+		case "external_url":
+			target = &s.ExternalURL
+		case "edit_window_hours":
+			target = &s.EditWindowHours
+		case "quiet_hours":
+			target = &s.QuietHours
+		case "compaction_mode":
+			target = &s.CompactionMode
+		case "compact_grouping":
+			target = &s.CompactGrouping
+		case "compaction_tiers":
+			target = &s.CompactionTiers
+		case "retention_rules":
+			target = &s.RetentionRules
+		default:
+			continue
+		}
+		if err := json.Unmarshal([]byte(value), target); err != nil {
+			return fmt.Errorf("setting %s: %w", key, err)
+		}
+		s.UpdatedBy, s.UpdatedAt = by, at
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	_ = rows.Close()
+	if s.AllowedCategories == nil {
+		s.AllowedCategories = []string{}
+	}
+	if s.RetentionRules == nil {
+		s.RetentionRules = []retentionRule{}
+	}
+	a.orgSettings.mu.Lock()
+	a.orgSettings.s, a.orgSettings.loaded = s, true
+	a.orgSettings.mu.Unlock()
+	return nil
+}
+
+// settingsPatch is a PATCH body; absent fields are left unchanged.
+type settingsPatch struct {
+	CompactionHour    *int             `json:"compaction_hour"`
+	MaxEntrySize      *int             `json:"max_entry_size"`
+	AllowedCategories *[]string        `json:"allowed_categories"`
+	Banner            *string          `json:"banner"`
+	ExternalURL       *string          `json:"external_url"`
+	EditWindowHours   *int             `json:"edit_window_hours"`
+	QuietHours        *quietHours      `json:"quiet_hours"`
+	CompactionMode    *string          `json:"compaction_mode"`
+	CompactGrouping   *string          `json:"compact_grouping"`
+	CompactionTiers   *compactionTiers `json:"compaction_tiers"`
+	RetentionRules    *[]retentionRule `json:"retention_rules"`
+}
+
+// validate normalizes the patch in place.
+func (p *settingsPatch) validate() error {
+	if p.CompactionHour != nil && (*p.CompactionHour < 0 || *p.CompactionHour > 23) {
+		return errors.New("compaction_hour must be between 0 and 23")
+	}
+	if p.MaxEntrySize != nil && (*p.MaxEntrySize < 1 || *p.MaxEntrySize > maxEntrySizeLimit) {
+		return fmt.Errorf("max_entry_size must be between 1 and %d", maxEntrySizeLimit)
+	}
+	if p.AllowedCategories != nil {
+		seen := map[string]bool{}
+		cats := []string{}
+		for _, c := range *p.AllowedCategories {
+			c = strings.ToLower(strings.TrimSpace(c))
+			if !categoryRe.MatchString(c) {
+				return fmt.Errorf("invalid category %q", c)
+			}
+			if !seen[c] {
+				seen[c] = true
+				cats = append(cats, c)
+			}
+		}
+		*p.AllowedCategories = cats
+	}
+	if p.Banner != nil {
+		*p.Banner = strings.TrimSpace(*p.Banner)
+		if len(*p.Banner) > maxBannerLen {
+			return fmt.Errorf("banner must be at most %d bytes", maxBannerLen)
+		}
+	}
+	if p.EditWindowHours != nil && (*p.EditWindowHours < 0 || *p.EditWindowHours > maxEditWindowDays*24) {
+		return fmt.Errorf("edit_window_hours must be between 0 and %d", maxEditWindowDays*24)
+	}
+	if p.ExternalURL != nil {
+		u, err := parseExternalURL(*p.ExternalURL)
+		if err != nil {
+			return err
+		}
+		*p.ExternalURL = u
+	}
+	if p.QuietHours != nil {
+		if err := p.QuietHours.normalize(); err != nil {
+			return fmt.Errorf("quiet_hours: %w", err)
+		}
+	}
+	if p.CompactionMode != nil {
+		*p.CompactionMode = strings.ToLower(strings.TrimSpace(*p.CompactionMode))
+		if *p.CompactionMode != compactionModeDelete && *p.CompactionMode != compactionModeArchive {
+			return fmt.Errorf("compaction_mode must be %s or %s", compactionModeDelete, compactionModeArchive)
+		}
+	}
+	if p.CompactGrouping != nil {
+		*p.CompactGrouping = strings.ToLower(strings.TrimSpace(*p.CompactGrouping))
+		if *p.CompactGrouping != compactGroupingSingle && *p.CompactGrouping != compactGroupingPerUser {
+			return fmt.Errorf("compact_grouping must be %s or %s", compactGroupingSingle, compactGroupingPerUser)
+		}
+	}
+	if p.CompactionTiers != nil {
+		if err := p.CompactionTiers.validate(); err != nil {
+			return fmt.Errorf("compaction_tiers: %w", err)
+		}
+	}
+	if p.RetentionRules != nil {
+		if *p.RetentionRules == nil {
+			*p.RetentionRules = []retentionRule{}
+		}
+		if err := validateRetentionRules(*p.RetentionRules); err != nil {
+			return fmt.Errorf("retention_rules: %w", err)
+		}
+	}
+	return nil
+}
+
+// updateSettings persists the fields set in p and refreshes the cache. It
+// returns the new settings and the names of the keys written.
+func (a *App) updateSettings(p settingsPatch, by string) (orgSettings, []string, error) {
+	if err := p.validate(); err != nil {
+		return orgSettings{}, nil, err
+	}
+	values := []struct {
+		key string
+		set bool
+		v   any
+	}{
+		{"compaction_hour", p.CompactionHour != nil, p.CompactionHour},
+		{"max_entry_size", p.MaxEntrySize != nil, p.MaxEntrySize},
+		{"allowed_categories", p.AllowedCategories != nil, p.AllowedCategories},
+		{"banner", p.Banner != nil, p.Banner},
+		{"external_url", p.ExternalURL != nil, p.ExternalURL},
+		{"edit_window_hours", p.EditWindowHours != nil, p.EditWindowHours},
+		{"quiet_hours", p.QuietHours != nil, p.QuietHours},
+		{"compaction_mode", p.CompactionMode != nil, p.CompactionMode},
+		{"compact_grouping", p.CompactGrouping != nil, p.CompactGrouping},
+		{"compaction_tiers", p.CompactionTiers != nil, p.CompactionTiers},
+		{"retention_rules", p.RetentionRules != nil, p.RetentionRules},
+	}
+	tx, err := a.db.Begin()
+	if err != nil {
+		return orgSettings{}, nil, err
+	}
+	defer func() { _ = tx.Rollback() }()
+	now := nowUTC()
+	var keys []string
+	for _, v := range values {
+		if !v.set {
+			continue
+		}
+		data, err := json.Marshal(v.v)
+		if err != nil {
+			return orgSettings{}, nil, err
+		}
+		if _, err := tx.Exec(`
+INSERT INTO settings(key, value, updated_by, updated_at) VALUES(?, ?, ?, ?)
+ON CONFLICT(key) DO UPDATE SET value = excluded.value, updated_by = excluded.updated_by, updated_at = excluded.updated_at`,
+			v.key, string(data), by, now); err != nil {
+			return orgSettings{}, nil, err
+		}
+		keys = append(keys, v.key)
+	}
+	if err := tx.Commit(); err != nil {
+		return orgSettings{}, nil, err
+	}
+	if err := a.loadSettings(); err != nil {
+		return orgSettings{}, nil, err
+	}
+	return a.settings(), keys, nil
+}
+
+// checkCategory validates an entry category against the allowed list. An
+// empty list allows any well-formed category; no category is always fine.
+func (s orgSettings) checkCategory(c string) (string, error) {
+	c = strings.ToLower(strings.TrimSpace(c))
+	if c == "" {
+		return "", nil
+	}
+	if !categoryRe.MatchString(c) {
+		return "", fmt.Errorf("invalid category %q", c)
+	}
+	if len(s.AllowedCategories) == 0 {
+		return c, nil
+	}
+	for _, allowed := range s.AllowedCategories {
+		if c == allowed {
+			return c, nil
+		}
+	}
+	return "", fmt.Errorf("category %q is not allowed", c)
+}
+
+// handleAdminSettings reads (GET) or partially updates (PATCH) the org
+// settings. Changes apply to the running server without a restart.
+func (a *App) handleAdminSettings(w http.ResponseWriter, r *http.Request, u AuthedUser) {
+	switch r.Method {
+	case http.MethodGet:
+		jsonOut(w, http.StatusOK, a.settings())
+	case http.MethodPatch:
+		var p settingsPatch
+		dec := json.NewDecoder(r.Body)
+		dec.DisallowUnknownFields()
+		if err := dec.Decode(&p); err != nil {
+			jsonErr(w, http.StatusBadRequest, "invalid json")
+			return
+		}
+		if err := p.validate(); err != nil {
+			jsonErr(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		s, keys, err := a.updateSettings(p, u.Username)
+		if err != nil {
+			jsonErr(w, http.StatusInternalServerError, "failed to update settings")
+			return
+		}
+		if len(keys) > 0 {
+			_ = a.logUserAction(u, "update_settings", fmt.Sprintf("keys=%s compaction_hour=%d max_entry_size=%d categories=%s external_url=%s edit_window_hours=%d quiet_hours=%s-%s compaction_mode=%s compact_grouping=%s compaction_tiers=%d/%d retention_rules=%s",
+				strings.Join(keys, ","), s.CompactionHour, s.MaxEntrySize, strings.Join(s.AllowedCategories, ","), s.ExternalURL, s.EditWindowHours, s.QuietHours.Start, s.QuietHours.End, s.CompactionMode, s.CompactGrouping, s.CompactionTiers.WeeklyAfterDays, s.CompactionTiers.MonthlyAfterDays, retentionRulesMeta(s.RetentionRules)))
+		}
+		jsonOut(w, http.StatusOK, s)
+	default:
+		jsonErr(w, http.StatusMethodNotAllowed, "method not allowed")
+	}
+}
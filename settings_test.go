@@ -0,0 +1,79 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestAdminSettingsAPI(t *testing.T) {
+	app := newTestApp(t)
+	h := newTestMux(app)
+	createUser(t, app, "alice", "PUDSETTAL01")
+	createUser(t, app, "bob", "PUDSETTBO01")
+	if _, err := app.db.Exec(`UPDATE users SET role = 'admin' WHERE username = 'bob'`); err != nil {
+		t.Fatalf("promote: %v", err)
+	}
+
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, authedReq(t, http.MethodGet, "/api/admin/settings", nil, "PUDSETTBO01"))
+	var s orgSettings
+	if err := json.Unmarshal(rr.Body.Bytes(), &s); err != nil || rr.Code != http.StatusOK {
+		t.Fatalf("get settings: %d %s", rr.Code, rr.Body.String())
+	}
+	if s.CompactionHour != defaultCompactionHour || s.MaxEntrySize != defaultMaxEntrySize || len(s.AllowedCategories) != 0 {
+		t.Fatalf("unexpected defaults: %+v", s)
+	}
+
+	rr = httptest.NewRecorder()
+	h.ServeHTTP(rr, authedReq(t, http.MethodPatch, "/api/admin/settings", map[string]any{"banner": "hi"}, "PUDSETTAL01"))
+	if rr.Code != http.StatusForbidden {
+		t.Fatalf("members must not change settings, got %d", rr.Code)
+	}
+	rr = httptest.NewRecorder()
+	h.ServeHTTP(rr, authedReq(t, http.MethodPatch, "/api/admin/settings", map[string]any{"compaction_hour": 24}, "PUDSETTBO01"))
+	if rr.Code != http.StatusBadRequest {
+		t.Fatalf("out of range hour accepted: %d", rr.Code)
+	}
+
+	patch := map[string]any{"max_entry_size": 10, "allowed_categories": []string{"Bugfix", "release"}, "banner": " Retro at 4pm "}
+	rr = httptest.NewRecorder()
+	h.ServeHTTP(rr, authedReq(t, http.MethodPatch, "/api/admin/settings", patch, "PUDSETTBO01"))
+	if err := json.Unmarshal(rr.Body.Bytes(), &s); err != nil || rr.Code != http.StatusOK {
+		t.Fatalf("patch settings: %d %s", rr.Code, rr.Body.String())
+	}
+	if s.CompactionHour != defaultCompactionHour || s.MaxEntrySize != 10 || strings.Join(s.AllowedCategories, ",") != "bugfix,release" || s.Banner != "Retro at 4pm" || s.UpdatedBy != "bob" {
+		t.Fatalf("unexpected settings after patch: %+v", s)
+	}
+
+	rr = httptest.NewRecorder()
+	h.ServeHTTP(rr, authedReq(t, http.MethodPost, "/api/entries", map[string]string{"content": "way over ten bytes"}, "PUDSETTAL01"))
+	if rr.Code != http.StatusBadRequest {
+		t.Fatalf("max_entry_size not applied: %d", rr.Code)
+	}
+	rr = httptest.NewRecorder()
+	h.ServeHTTP(rr, authedReq(t, http.MethodPost, "/api/entries", map[string]string{"content": "fix", "category": "chores"}, "PUDSETTAL01"))
+	if rr.Code != http.StatusBadRequest {
+		t.Fatalf("disallowed category accepted: %d", rr.Code)
+	}
+	rr = httptest.NewRecorder()
+	h.ServeHTTP(rr, authedReq(t, http.MethodPost, "/api/entries", map[string]string{"content": "fix", "category": "BugFix"}, "PUDSETTAL01"))
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("create with category: %d %s", rr.Code, rr.Body.String())
+	}
+	var category string
+	if err := app.db.QueryRow(`SELECT category FROM entries WHERE content = 'fix'`).Scan(&category); err != nil || category != "bugfix" {
+		t.Fatalf("category = %q (%v)", category, err)
+	}
+
+	// A fresh App over the same database sees the persisted settings.
+	reloaded := &App{db: app.db}
+	if err := reloaded.loadSettings(); err != nil {
+		t.Fatalf("loadSettings: %v", err)
+	}
+	if got := reloaded.settings(); got.MaxEntrySize != 10 || got.Banner != "Retro at 4pm" {
+		t.Fatalf("settings not persisted: %+v", got)
+	}
+}
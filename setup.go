@@ -0,0 +1,123 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"strings"
+)
+
+// First-run setup: while the database has no human users, GET/POST
+// /api/setup (and the /setup page of the web UI) create the bootstrap admin
+// in place of 'admin create-user'. Both only answer direct loopback requests:
+// no forwarding headers (so nothing relayed by the reverse proxy), a
+// localhost Host (against DNS rebinding) and, when a browser sends one, a
+// localhost Origin (so another site open in the operator's browser cannot
+// claim the instance through CORS).
+
+// needsSetup reports whether no human user exists yet.
+func (a *App) needsSetup() (bool, error) {
+	var n int
+	err := a.db.QueryRow(`SELECT COUNT(*) FROM users WHERE kind = 'human'`).Scan(&n)
+	return n == 0, err
+}
+
+// loopbackHost reports whether host (optionally with a port) names this machine.
+func loopbackHost(host string) bool {
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	host = strings.Trim(host, "[]")
+	if strings.EqualFold(host, "localhost") {
+		return true
+	}
+	addr, err := netip.ParseAddr(host)
+	return err == nil && addr.Unmap().IsLoopback()
+}
+
+// localRequest reports whether r came straight from a local browser or tool.
+func localRequest(r *http.Request) bool {
+	peer, ok := parseHop(r.RemoteAddr)
+	if !ok || !peer.IsLoopback() {
+		return false
+	}
+	for _, h := range []string{"X-Forwarded-For", "X-Real-IP", "Forwarded"} {
+		if r.Header.Get(h) != "" {
+			return false
+		}
+	}
+	if !loopbackHost(r.Host) {
+		return false
+	}
+	if origin := r.Header.Get("Origin"); origin != "" {
+		u, err := url.Parse(origin)
+		if err != nil || !loopbackHost(u.Host) {
+			return false
+		}
+	}
+	return true
+}
+
+// handleSetup serves /api/setup. GET reports whether setup is still needed;
+// POST {"username":"..."} creates the first admin and returns its token once.
+// Afterwards both answer 410.
+func (a *App) handleSetup(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodPost {
+		jsonErr(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	if !localRequest(r) {
+		jsonErr(w, http.StatusForbidden, "setup is only available from localhost")
+		return
+	}
+	// Serialized so two concurrent submissions cannot both see an empty table.
+	a.setupMu.Lock()
+	defer a.setupMu.Unlock()
+	needed, err := a.needsSetup()
+	if err != nil {
+		jsonErr(w, http.StatusInternalServerError, "failed to query users")
+		return
+	}
+	if !needed {
+		jsonErr(w, http.StatusGone, "setup already completed")
+		return
+	}
+	if r.Method == http.MethodGet {
+		jsonOut(w, http.StatusOK, map[string]bool{"setup_required": true})
+		return
+	}
+
+	var req struct {
+		Username string `json:"username"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		jsonErr(w, http.StatusBadRequest, "invalid json")
+		return
+	}
+	if _, _, err := a.checkNewUser(req.Username, roleAdmin); err != nil {
+		jsonErr(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	nu, err := a.provisionUser(req.Username, roleAdmin, 0)
+	if err != nil {
+		jsonErr(w, http.StatusInternalServerError, "failed to create user")
+		return
+	}
+	rc := requestFrom(r)
+	_ = a.recordAction(auditRecord{ActorType: "setup", ActorUsername: "localhost", Action: "create_user", Metadata: fmt.Sprintf("target_username=%s user_id=%d role=%s bootstrap=true", nu.Username, nu.ID, nu.Role), ClientIP: a.clientIP(r), RequestID: rc.ID, Route: rc.Route})
+	a.logger.Printf("event=setup_completed username=%s", nu.Username)
+	jsonOut(w, http.StatusCreated, nu)
+}
+
+// handleSetupUI serves the /setup page. Remote visitors get a 404 so the
+// page does not advertise an unclaimed instance.
+func (a *App) handleSetupUI(w http.ResponseWriter, r *http.Request) {
+	if !localRequest(r) {
+		http.NotFound(w, r)
+		return
+	}
+	renderUI(w, "templates/setup.html", uiPageData{Title: "PUD Dev Log Setup", BasePath: a.basePath, APIBase: a.apiBase()})
+}
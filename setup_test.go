@@ -0,0 +1,89 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestFirstRunSetup(t *testing.T) {
+	app := newTestApp(t)
+	h := newTestMux(app)
+	setupReq := func(method string, body any, mutate func(*http.Request)) *httptest.ResponseRecorder {
+		t.Helper()
+		var r io.Reader
+		if body != nil {
+			b, _ := json.Marshal(body)
+			r = bytes.NewReader(b)
+		}
+		req := httptest.NewRequest(method, "http://localhost:9173/api/setup", r)
+		req.RemoteAddr = "127.0.0.1:50000"
+		req.Header.Set("Origin", "http://localhost:9172")
+		if mutate != nil {
+			mutate(req)
+		}
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, req)
+		return rr
+	}
+
+	if rr := setupReq(http.MethodGet, nil, nil); rr.Code != http.StatusOK || !strings.Contains(rr.Body.String(), `"setup_required":true`) {
+		t.Fatalf("status: %d %s", rr.Code, rr.Body.String())
+	}
+	// Only direct local requests may claim the instance.
+	for name, mutate := range map[string]func(*http.Request){
+		"remote peer":   func(r *http.Request) { r.RemoteAddr = "203.0.113.9:4000" },
+		"proxied":       func(r *http.Request) { r.Header.Set("X-Forwarded-For", "203.0.113.9") },
+		"rebound host":  func(r *http.Request) { r.Host = "evil.example.com:9173" },
+		"foreign site":  func(r *http.Request) { r.Header.Set("Origin", "https://evil.example.com") },
+		"forwarded hdr": func(r *http.Request) { r.Header.Set("Forwarded", "for=203.0.113.9") },
+	} {
+		if rr := setupReq(http.MethodPost, map[string]string{"username": "mallory"}, mutate); rr.Code != http.StatusForbidden {
+			t.Fatalf("%s: expected 403, got %d", name, rr.Code)
+		}
+	}
+	if rr := setupReq(http.MethodPost, map[string]string{"username": "system"}, nil); rr.Code != http.StatusBadRequest {
+		t.Fatalf("reserved username: %d", rr.Code)
+	}
+
+	rr := setupReq(http.MethodPost, map[string]string{"username": "ops"}, nil)
+	var nu newUser
+	if rr.Code != http.StatusCreated || json.Unmarshal(rr.Body.Bytes(), &nu) != nil || nu.Role != roleAdmin || len(nu.Token) != tokenTotalLen {
+		t.Fatalf("setup: %d %s", rr.Code, rr.Body.String())
+	}
+	rr = httptest.NewRecorder()
+	h.ServeHTTP(rr, authedReq(t, http.MethodGet, "/api/me", nil, nu.Token))
+	if rr.Code != http.StatusOK || !strings.Contains(rr.Body.String(), `"role":"admin"`) {
+		t.Fatalf("bootstrap token: %d %s", rr.Code, rr.Body.String())
+	}
+	var logged int
+	if err := app.db.QueryRow(`SELECT COUNT(*) FROM action_logs WHERE actor_type = 'setup' AND action = 'create_user'`).Scan(&logged); err != nil || logged != 1 {
+		t.Fatalf("setup audit rows: %d %v", logged, err)
+	}
+
+	// One-time: afterwards both the API and the page are gone.
+	if rr := setupReq(http.MethodPost, map[string]string{"username": "second"}, nil); rr.Code != http.StatusGone {
+		t.Fatalf("second setup: %d", rr.Code)
+	}
+	if rr := setupReq(http.MethodGet, nil, nil); rr.Code != http.StatusGone {
+		t.Fatalf("status after setup: %d", rr.Code)
+	}
+	page := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "http://localhost:9172/setup", nil)
+	req.RemoteAddr = "[::1]:4000"
+	app.handleSetupUI(page, req)
+	if page.Code != http.StatusOK || !strings.Contains(page.Body.String(), "/api/v1/setup") {
+		t.Fatalf("local setup page: %d", page.Code)
+	}
+	page = httptest.NewRecorder()
+	req = httptest.NewRequest(http.MethodGet, "http://203.0.113.1:9172/setup", nil)
+	req.RemoteAddr = "203.0.113.9:4000"
+	app.handleSetupUI(page, req)
+	if page.Code != http.StatusNotFound {
+		t.Fatalf("remote setup page: %d", page.Code)
+	}
+}
@@ -0,0 +1,259 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	shareDefaultTTL = 24 * time.Hour
+	shareRateWindow = time.Minute
+)
+
+// shareSignature signs a share target ("day:2026-02-17" or "entry:42") and
+// its expiry. Links carry no state server-side; rotating the key revokes all
+// of them at once.
+func shareSignature(key []byte, target string, exp int64) string {
+	mac := hmac.New(sha256.New, key)
+	fmt.Fprintf(mac, "%s:%d", target, exp)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// clientRateLimiter allows up to limit requests per client per fixed window.
+// A nil limiter allows everything.
+type clientRateLimiter struct {
+	mu     sync.Mutex
+	limit  int
+	window time.Duration
+	hits   map[string]*rateWindow
+}
+
+type rateWindow struct {
+	start time.Time
+	n     int
+}
+
+func newClientRateLimiter(limit int, window time.Duration) *clientRateLimiter {
+	if limit < 1 {
+		return nil
+	}
+	return &clientRateLimiter{limit: limit, window: window, hits: map[string]*rateWindow{}}
+}
+
+func (l *clientRateLimiter) allow(client string, now time.Time) bool {
+	if l == nil {
+		return true
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if len(l.hits) > 10000 {
+		for k, w := range l.hits {
+			if now.Sub(w.start) >= l.window {
+				delete(l.hits, k)
+			}
+		}
+	}
+	w, ok := l.hits[client]
+	if !ok || now.Sub(w.start) >= l.window {
+		l.hits[client] = &rateWindow{start: now, n: 1}
+		return true
+	}
+	if w.n >= l.limit {
+		return false
+	}
+	w.n++
+	return true
+}
+
+// handleCreateShare mints a signed, expiring read-only link for a day or a
+// single entry.
+func (a *App) handleCreateShare(w http.ResponseWriter, r *http.Request, u AuthedUser) {
+	if len(a.shareKey) == 0 {
+		jsonErr(w, http.StatusNotFound, "share links are not enabled")
+		return
+	}
+	if r.Method != http.MethodPost {
+		jsonErr(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	var req struct {
+		Day     string `json:"day"`
+		EntryID int64  `json:"entry_id"`
+		TTL     string `json:"ttl"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		jsonErr(w, http.StatusBadRequest, "invalid json")
+		return
+	}
+	req.Day = strings.TrimSpace(req.Day)
+	q := url.Values{}
+	var target string
+	switch {
+	case req.Day != "" && req.EntryID != 0:
+		jsonErr(w, http.StatusBadRequest, "set either day or entry_id, not both")
+		return
+	case req.Day != "":
+		if _, err := time.Parse("2006-01-02", req.Day); err != nil {
+			jsonErr(w, http.StatusBadRequest, "day must be YYYY-MM-DD")
+			return
+		}
+		target = "day:" + req.Day
+		q.Set("day", req.Day)
+	case req.EntryID > 0:
+		if _, err := a.entryByID(req.EntryID); err != nil {
+			jsonErr(w, http.StatusNotFound, "entry not found")
+			return
+		}
+		target = fmt.Sprintf("entry:%d", req.EntryID)
+		q.Set("entry", strconv.FormatInt(req.EntryID, 10))
+	default:
+		jsonErr(w, http.StatusBadRequest, "day or entry_id is required")
+		return
+	}
+	ttl := shareDefaultTTL
+	if strings.TrimSpace(req.TTL) != "" {
+		d, err := time.ParseDuration(strings.TrimSpace(req.TTL))
+		if err != nil || d <= 0 {
+			jsonErr(w, http.StatusBadRequest, "ttl must be a positive duration (e.g. 24h)")
+			return
+		}
+		ttl = d
+	}
+	if a.shareMaxTTL > 0 && ttl > a.shareMaxTTL {
+		jsonErr(w, http.StatusBadRequest, fmt.Sprintf("ttl must not exceed %s", a.shareMaxTTL))
+		return
+	}
+	expires := time.Now().UTC().Add(ttl).Truncate(time.Second)
+	q.Set("exp", strconv.FormatInt(expires.Unix(), 10))
+	q.Set("sig", shareSignature(a.shareKey, target, expires.Unix()))
+	_ = a.logUserAction(u, "create_share_link", fmt.Sprintf("target=%s expires_at=%s", target, expires.Format(time.RFC3339)))
+	jsonOut(w, http.StatusCreated, map[string]string{
+		"url":        a.externalBase() + "/api/shared?" + q.Encode(),
+		"expires_at": expires.Format(time.RFC3339),
+	})
+}
+
+// handleShared serves a share link without token auth. Requests are rate
+// limited per client address before the signature is even checked. Under
+// --anonymize force the content is anonymized like every other read.
+func (a *App) handleShared(w http.ResponseWriter, r *http.Request) {
+	if len(a.shareKey) == 0 {
+		jsonErr(w, http.StatusNotFound, "share links are not enabled")
+		return
+	}
+	if r.Method != http.MethodGet {
+		jsonErr(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	now := time.Now()
+	client := a.clientIP(r)
+	if !a.shareLimit.allow(client, now) {
+		a.logger.Printf("event=share_rate_limited client=%s", client)
+		w.Header().Set("Retry-After", strconv.Itoa(int(shareRateWindow.Seconds())))
+		jsonErr(w, http.StatusTooManyRequests, "too many requests")
+		return
+	}
+
+	q := r.URL.Query()
+	day, entry := strings.TrimSpace(q.Get("day")), strings.TrimSpace(q.Get("entry"))
+	target := "day:" + day
+	if entry != "" {
+		target = "entry:" + entry
+	}
+	exp, err := strconv.ParseInt(q.Get("exp"), 10, 64)
+	if err != nil || (day == "") == (entry == "") ||
+		!hmac.Equal([]byte(shareSignature(a.shareKey, target, exp)), []byte(q.Get("sig"))) {
+		jsonErr(w, http.StatusForbidden, "invalid share link")
+		return
+	}
+	expires := time.Unix(exp, 0).UTC()
+	if now.After(expires) {
+		jsonErr(w, http.StatusGone, "share link expired")
+		return
+	}
+	a.logger.Printf("event=share_view target=%s client=%s", target, client)
+	anon := a.anonymizeMode == anonymizeForce
+
+	if entry != "" {
+		id, err := strconv.ParseInt(entry, 10, 64)
+		if err != nil {
+			jsonErr(w, http.StatusForbidden, "invalid share link")
+			return
+		}
+		e, err := a.entryByID(id)
+		if errors.Is(err, sql.ErrNoRows) {
+			jsonErr(w, http.StatusNotFound, "entry not found")
+			return
+		}
+		if err != nil {
+			jsonErr(w, http.StatusInternalServerError, "failed to query entry")
+			return
+		}
+		if anon {
+			e = anonymizeEntry(e)
+		}
+		jsonOut(w, http.StatusOK, map[string]any{"entry": e, "expires_at": expires.Format(time.RFC3339)})
+		return
+	}
+
+	entries, err := a.dayEntries(day)
+	if err != nil {
+		jsonErr(w, http.StatusInternalServerError, "failed to query entries")
+		return
+	}
+	if anon {
+		for i := range entries {
+			entries[i] = anonymizeEntry(entries[i])
+		}
+	}
+	jsonOut(w, http.StatusOK, map[string]any{"day": day, "entries": entries, "expires_at": expires.Format(time.RFC3339)})
+}
+
+// dayEntries returns every entry of day in chronological order.
+func (a *App) dayEntries(day string) ([]entryRow, error) {
+	rows, err := a.db.Query(`
+SELECT e.id, u.username, u.kind, e.entry_type, e.content, e.created_at
+FROM entries e
+JOIN users u ON u.id = e.user_id
+WHERE date(e.created_at) = ? AND e.deleted_at IS NULL
+ORDER BY e.created_at ASC, e.id ASC`, day)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	out := []entryRow{}
+	for rows.Next() {
+		var e entryRow
+		if err := rows.Scan(&e.ID, &e.User, &e.UserKind, &e.EntryType, &e.Content, &e.CreatedAt); err != nil {
+			return nil, err
+		}
+		out = append(out, e)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	_ = rows.Close()
+	ids := make([]int64, len(out))
+	for i, e := range out {
+		ids[i] = e.ID
+	}
+	issues, err := a.entryIssues(ids)
+	if err != nil {
+		return nil, err
+	}
+	for i := range out {
+		out[i].Issues = issues[out[i].ID]
+	}
+	return out, nil
+}
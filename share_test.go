@@ -0,0 +1,105 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAPIShareLinks(t *testing.T) {
+	app := newTestApp(t)
+	app.shareKey = []byte("share-test-key")
+	app.shareMaxTTL = 48 * time.Hour
+	app.shareLimit = newClientRateLimiter(3, time.Minute)
+	h := newTestMux(app)
+	createUser(t, app, "alice", "PUDABCDEF12")
+
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, authedReq(t, http.MethodPost, "/api/entries", map[string]string{"content": "shipped the billing fix"}, "PUDABCDEF12"))
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("create entry: %d", rr.Code)
+	}
+	day := time.Now().UTC().Format("2006-01-02")
+
+	rr = httptest.NewRecorder()
+	h.ServeHTTP(rr, authedReq(t, http.MethodPost, "/api/share", map[string]string{"day": day, "ttl": "72h"}, "PUDABCDEF12"))
+	if rr.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400 for ttl above max, got %d", rr.Code)
+	}
+	rr = httptest.NewRecorder()
+	h.ServeHTTP(rr, authedReq(t, http.MethodPost, "/api/share", map[string]string{"day": day}, "PUDABCDEF12"))
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("expected 201, got %d body=%s", rr.Code, rr.Body.String())
+	}
+	var link struct {
+		URL string `json:"url"`
+	}
+	if err := json.Unmarshal(rr.Body.Bytes(), &link); err != nil {
+		t.Fatalf("decode link: %v", err)
+	}
+
+	rr = httptest.NewRecorder()
+	h.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, link.URL, nil))
+	if rr.Code != http.StatusOK || !strings.Contains(rr.Body.String(), "shipped the billing fix") {
+		t.Fatalf("expected shared day, got %d body=%s", rr.Code, rr.Body.String())
+	}
+
+	rr = httptest.NewRecorder()
+	h.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, strings.Replace(link.URL, "day="+day, "day=2020-01-01", 1), nil))
+	if rr.Code != http.StatusForbidden {
+		t.Fatalf("expected 403 for tampered link, got %d", rr.Code)
+	}
+
+	exp := time.Now().Add(-time.Minute).Unix()
+	expired := fmt.Sprintf("/api/shared?entry=1&exp=%d&sig=%s", exp, shareSignature(app.shareKey, "entry:1", exp))
+	rr = httptest.NewRecorder()
+	h.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, expired, nil))
+	if rr.Code != http.StatusGone {
+		t.Fatalf("expected 410 for expired link, got %d", rr.Code)
+	}
+
+	rr = httptest.NewRecorder()
+	h.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, link.URL, nil))
+	if rr.Code != http.StatusTooManyRequests {
+		t.Fatalf("expected 429 after rate limit, got %d", rr.Code)
+	}
+}
+
+func TestSharedLinksAnonymizedInForceMode(t *testing.T) {
+	app := newTestApp(t)
+	app.shareKey = []byte("share-test-key")
+	app.anonymizeMode = anonymizeForce
+	h := newTestMux(app)
+	createUser(t, app, "alice", "PUDSHAREAN1")
+	createUser(t, app, "bob", "PUDSHAREAN2")
+
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, authedReq(t, http.MethodPost, "/api/entries", map[string]string{"content": "paired with @bob on the billing fix"}, "PUDSHAREAN1"))
+	var created struct {
+		ID int64 `json:"id"`
+	}
+	if err := json.Unmarshal(rr.Body.Bytes(), &created); err != nil || rr.Code != http.StatusCreated {
+		t.Fatalf("create entry: %d %s", rr.Code, rr.Body.String())
+	}
+	day := time.Now().UTC().Format("2006-01-02")
+	for _, target := range []map[string]any{{"day": day}, {"entry_id": created.ID}} {
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, authedReq(t, http.MethodPost, "/api/share", target, "PUDSHAREAN1"))
+		var link struct {
+			URL string `json:"url"`
+		}
+		if err := json.Unmarshal(rr.Body.Bytes(), &link); err != nil || rr.Code != http.StatusCreated {
+			t.Fatalf("share %v: %d %s", target, rr.Code, rr.Body.String())
+		}
+		rr = httptest.NewRecorder()
+		h.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, link.URL, nil))
+		body := rr.Body.String()
+		if rr.Code != http.StatusOK || !strings.Contains(body, "paired with @teammate") || strings.Contains(body, "alice") || strings.Contains(body, "@bob") {
+			t.Fatalf("shared %v: %d %s", target, rr.Code, body)
+		}
+	}
+}
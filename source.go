@@ -0,0 +1,79 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Entry sources: every entry records the channel it came in through in
+// entries.source, so readers can tell hand-written notes from bot output.
+// The server sets it on its own ingest paths (inbound email, CI, importers);
+// clients of POST /api/entries may declare one of clientSources and default
+// to "api". Entries written before the column existed have "". Compacts keep
+// each source line's value in compact_data, and uncompact restores it.
+
+const (
+	sourceWeb    = "web"
+	sourceCLI    = "cli"
+	sourceSlack  = "slack"
+	sourceGitHub = "github"
+	sourceEmail  = "email"
+	sourceAPI    = "api"
+	sourceCI     = "ci"
+	sourceGit    = "git"
+	sourceImport = "import"
+)
+
+// entrySources are the values that may be stored and filtered on.
+var entrySources = []string{sourceWeb, sourceCLI, sourceSlack, sourceGitHub, sourceEmail, sourceAPI, sourceCI, sourceGit, sourceImport}
+
+// clientSources are the values a client may declare when posting an entry;
+// the rest are reserved for the server's own ingest paths.
+var clientSources = []string{sourceWeb, sourceCLI, sourceSlack, sourceGitHub, sourceAPI}
+
+// clientSource checks a source declared in a POST /api/entries body.
+func clientSource(v string) (string, error) {
+	v = strings.ToLower(strings.TrimSpace(v))
+	if v == "" {
+		return sourceAPI, nil
+	}
+	for _, s := range clientSources {
+		if v == s {
+			return v, nil
+		}
+	}
+	return "", fmt.Errorf("source must be one of %s", strings.Join(clientSources, ", "))
+}
+
+// parseSourceFilter reads a ?source= filter: comma-separated sources, nil
+// for none.
+func parseSourceFilter(v string) ([]string, error) {
+	var out []string
+	for _, part := range strings.Split(v, ",") {
+		part = strings.ToLower(strings.TrimSpace(part))
+		if part == "" {
+			continue
+		}
+		known := false
+		for _, s := range entrySources {
+			known = known || part == s
+		}
+		if !known {
+			return nil, fmt.Errorf("unknown source %q (want %s)", part, strings.Join(entrySources, ", "))
+		}
+		out = append(out, part)
+	}
+	return out, nil
+}
+
+// pendingSource maps the via column of a pending entry to its source: rows
+// queued before sources existed hold "" (entries API) or "quick".
+func pendingSource(via string) string {
+	switch via {
+	case "":
+		return sourceAPI
+	case "quick":
+		return sourceWeb
+	}
+	return via
+}
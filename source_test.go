@@ -0,0 +1,84 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestEntrySources(t *testing.T) {
+	app := newTestApp(t)
+	h := newTestMux(app)
+	createUser(t, app, "alice", "PUDSOURCE01")
+	post := func(body map[string]string) *httptest.ResponseRecorder {
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, authedReq(t, http.MethodPost, "/api/entries", body, "PUDSOURCE01"))
+		return rr
+	}
+	if rr := post(map[string]string{"content": "from the terminal", "source": "CLI"}); rr.Code != http.StatusCreated {
+		t.Fatalf("cli post: %d %s", rr.Code, rr.Body.String())
+	}
+	if rr := post(map[string]string{"content": "from a script"}); rr.Code != http.StatusCreated {
+		t.Fatalf("default post: %d %s", rr.Code, rr.Body.String())
+	}
+	// Server-side sources cannot be claimed by clients.
+	if rr := post(map[string]string{"content": "spoofed", "source": "email"}); rr.Code != http.StatusBadRequest {
+		t.Fatalf("reserved source: %d %s", rr.Code, rr.Body.String())
+	}
+	var aliceID int64
+	if err := app.db.QueryRow(`SELECT id FROM users WHERE username = 'alice'`).Scan(&aliceID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := app.insertEntry(aliceID, "mailed in", "", sourceEmail, nowUTC()); err != nil {
+		t.Fatal(err)
+	}
+
+	day := time.Now().UTC().Format("2006-01-02")
+	list := func(query string) (int, []entryRow, int) {
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, authedReq(t, http.MethodGet, "/api/entries?day="+day+query, nil, "PUDSOURCE01"))
+		var resp struct {
+			TotalCount int        `json:"total_count"`
+			Entries    []entryRow `json:"entries"`
+		}
+		_ = json.Unmarshal(rr.Body.Bytes(), &resp)
+		return rr.Code, resp.Entries, resp.TotalCount
+	}
+	code, entries, total := list("&source=cli,email")
+	if code != http.StatusOK || len(entries) != 2 || total != 2 {
+		t.Fatalf("filtered list: %d %+v total=%d", code, entries, total)
+	}
+	for _, e := range entries {
+		if e.Source != sourceCLI && e.Source != sourceEmail {
+			t.Fatalf("filter let through %+v", e)
+		}
+	}
+	if _, entries, _ := list(""); len(entries) != 3 || entries[1].Source != sourceAPI {
+		t.Fatalf("unfiltered list: %+v", entries)
+	}
+	if code, _, _ := list("&source=fax"); code != http.StatusBadRequest {
+		t.Fatalf("unknown source filter: %d", code)
+	}
+
+	// Compacts show non-web sources and uncompact puts them back.
+	if err := app.compactDay(day); err != nil {
+		t.Fatal(err)
+	}
+	var compact string
+	if err := app.db.QueryRow(`SELECT content FROM entries WHERE entry_type = 'daily_compact'`).Scan(&compact); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(compact, "from the terminal (via cli)") || !strings.Contains(compact, "mailed in (via email)") {
+		t.Fatalf("compact text:\n%s", compact)
+	}
+	if _, err := app.uncompactDay(day); err != nil {
+		t.Fatal(err)
+	}
+	var source string
+	if err := app.db.QueryRow(`SELECT source FROM entries WHERE content = 'from the terminal'`).Scan(&source); err != nil || source != sourceCLI {
+		t.Fatalf("restored source = %q, %v", source, err)
+	}
+}
@@ -0,0 +1,352 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// A warm standby is a second host that pulls consistent snapshots of the
+// primary's database over GET /api/admin/snapshot and swaps them into place.
+// While <db>.standby exists, serve refuses to open the database so a stale
+// copy is never written to by accident; 'admin promote-standby' removes it.
+
+const snapshotSHAHeader = "X-Snapshot-SHA256"
+
+// standbyState is the marker file kept next to a standby's database.
+type standbyState struct {
+	Primary  string `json:"primary"`
+	SHA256   string `json:"sha256,omitempty"`
+	Bytes    int64  `json:"bytes,omitempty"`
+	SyncedAt string `json:"synced_at,omitempty"`
+}
+
+func standbyMarkerPath(dbPath string) string {
+	return dbPath + ".standby"
+}
+
+func readStandbyState(dbPath string) (standbyState, bool, error) {
+	var st standbyState
+	b, err := os.ReadFile(standbyMarkerPath(dbPath))
+	if errors.Is(err, os.ErrNotExist) {
+		return st, false, nil
+	}
+	if err != nil {
+		return st, false, err
+	}
+	if err := json.Unmarshal(b, &st); err != nil {
+		return st, true, fmt.Errorf("parse %s: %w", standbyMarkerPath(dbPath), err)
+	}
+	return st, true, nil
+}
+
+func writeStandbyState(dbPath string, st standbyState) error {
+	b, err := json.MarshalIndent(st, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := standbyMarkerPath(dbPath) + ".tmp"
+	if err := os.WriteFile(tmp, append(b, '\n'), 0o600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, standbyMarkerPath(dbPath))
+}
+
+// handleAdminSnapshot streams a consistent copy of the database made with
+// VACUUM INTO. Writers are only blocked for the duration of the copy.
+func (a *App) handleAdminSnapshot(w http.ResponseWriter, r *http.Request, u AuthedUser) {
+	if r.Method != http.MethodGet {
+		jsonErr(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	a.serveSnapshot(w, u, "download_snapshot", "")
+}
+
+// writeSnapshot copies the live database to path (which must not exist)
+// with VACUUM INTO and returns the copy's SHA-256 and size.
+func (a *App) writeSnapshot(path string) (string, int64, error) {
+	if _, err := a.db.Exec(`VACUUM INTO ?`, path); err != nil {
+		a.health.record(subsystemBackup, time.Now(), err)
+		return "", 0, err
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return "", 0, err
+	}
+	defer f.Close()
+	h := sha256.New()
+	size, err := io.Copy(h, f)
+	if err != nil {
+		return "", 0, err
+	}
+	a.health.record(subsystemBackup, time.Now(), nil)
+	return hex.EncodeToString(h.Sum(nil)), size, nil
+}
+
+// serveSnapshot writes a fresh snapshot to a temporary file and streams it,
+// audited as action. A non-empty filename makes it a download attachment.
+func (a *App) serveSnapshot(w http.ResponseWriter, u AuthedUser, action, filename string) {
+	dir, err := os.MkdirTemp("", "devlog-snapshot-")
+	if err != nil {
+		jsonErr(w, http.StatusInternalServerError, "failed to prepare snapshot")
+		return
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "snapshot.db")
+	sum, size, err := a.writeSnapshot(path)
+	if err != nil {
+		a.logger.Printf("event=snapshot_failed action=%s err=%v", action, err)
+		jsonErr(w, http.StatusInternalServerError, "failed to create snapshot")
+		return
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		jsonErr(w, http.StatusInternalServerError, "failed to read snapshot")
+		return
+	}
+	defer f.Close()
+	_ = a.logUserAction(u, action, fmt.Sprintf("sha256=%s bytes=%d", sum, size))
+	w.Header().Set("Content-Type", "application/vnd.sqlite3")
+	w.Header().Set("Content-Length", fmt.Sprint(size))
+	w.Header().Set(snapshotSHAHeader, sum)
+	w.Header().Set("Cache-Control", "no-store")
+	if filename != "" {
+		w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filename))
+	}
+	w.WriteHeader(http.StatusOK)
+	_, _ = io.Copy(w, f)
+}
+
+// pullSnapshot downloads one snapshot from primary, checks its digest and
+// integrity and moves it over dbPath. It reports whether the database
+// changed; an identical snapshot leaves the current file alone.
+func pullSnapshot(ctx context.Context, client *http.Client, primary, token, dbPath string) (standbyState, bool, error) {
+	st := standbyState{Primary: primary}
+	prev, _, err := readStandbyState(dbPath)
+	if err != nil {
+		return st, false, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, strings.TrimRight(primary, "/")+apiV1Prefix+"/admin/snapshot", nil)
+	if err != nil {
+		return st, false, err
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	resp, err := client.Do(req)
+	if err != nil {
+		return st, false, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return st, false, fmt.Errorf("snapshot request: %s: %s", resp.Status, strings.TrimSpace(string(body)))
+	}
+
+	tmp := dbPath + ".incoming"
+	defer os.Remove(tmp)
+	f, err := os.OpenFile(tmp, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0o600)
+	if err != nil {
+		return st, false, err
+	}
+	h := sha256.New()
+	size, err := io.Copy(io.MultiWriter(f, h), resp.Body)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return st, false, fmt.Errorf("download snapshot: %w", err)
+	}
+	st.SHA256 = hex.EncodeToString(h.Sum(nil))
+	st.Bytes = size
+	st.SyncedAt = nowUTC()
+	if want := resp.Header.Get(snapshotSHAHeader); want != st.SHA256 {
+		return st, false, fmt.Errorf("snapshot digest mismatch: header %q, got %s", want, st.SHA256)
+	}
+	if err := checkSnapshotIntegrity(tmp); err != nil {
+		return st, false, err
+	}
+
+	changed := st.SHA256 != prev.SHA256
+	if !changed {
+		if _, err := os.Stat(dbPath); err != nil {
+			changed = true
+		}
+	}
+	if changed {
+		// Leftover WAL files belong to the old file and would be replayed
+		// onto the new one.
+		for _, suffix := range []string{"-wal", "-shm"} {
+			if err := os.Remove(dbPath + suffix); err != nil && !errors.Is(err, os.ErrNotExist) {
+				return st, false, err
+			}
+		}
+		if err := os.Rename(tmp, dbPath); err != nil {
+			return st, false, err
+		}
+	}
+	return st, changed, writeStandbyState(dbPath, st)
+}
+
+func checkSnapshotIntegrity(path string) error {
+	db, err := sql.Open("sqlite3", "file:"+path+"?mode=ro")
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	var res string
+	if err := db.QueryRow(`PRAGMA integrity_check`).Scan(&res); err != nil {
+		return fmt.Errorf("snapshot integrity check: %w", err)
+	}
+	if res != "ok" {
+		return fmt.Errorf("snapshot integrity check: %s", res)
+	}
+	return nil
+}
+
+// standbyLoop pulls a snapshot every interval until ctx ends or the standby
+// is promoted (its marker file disappears).
+func standbyLoop(ctx context.Context, logger *log.Logger, client *http.Client, primary, token, dbPath string, interval time.Duration) error {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		st, changed, err := pullSnapshot(ctx, client, primary, token, dbPath)
+		switch {
+		case err != nil:
+			logger.Printf("event=standby_sync_failed primary=%s err=%v", primary, err)
+		case changed:
+			logger.Printf("event=standby_synced primary=%s sha256=%s bytes=%d", primary, st.SHA256, st.Bytes)
+		}
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+		if _, ok, err := readStandbyState(dbPath); err == nil && !ok {
+			logger.Printf("event=standby_promoted db=%s", dbPath)
+			return nil
+		}
+	}
+}
+
+func runStandby(args []string) error {
+	fs := flag.NewFlagSet("standby", flag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s standby --primary <url> --token-file <path> [options]\n\n", binName())
+		fmt.Fprintln(fs.Output(), "Runs a warm standby: pulls a database snapshot from the primary every --interval")
+		fmt.Fprintln(fs.Output(), "and swaps it into --db. The token needs the db.snapshot permission (admins have it).")
+		fmt.Fprintf(fs.Output(), "Promote with '%s admin promote-standby --db <path>', then start serve.\n", binName())
+		fmt.Fprintln(fs.Output())
+		fmt.Fprintln(fs.Output(), "Options:")
+		fs.PrintDefaults()
+	}
+	primary := fs.String("primary", "", "primary API base URL (e.g. https://devlog.example.com)")
+	tokenFile := fs.String("token-file", "", "file holding the API token used to pull snapshots")
+	interval := fs.Duration("interval", 5*time.Minute, "time between snapshot pulls")
+	once := fs.Bool("once", false, "pull a single snapshot and exit")
+	dbPath := fs.String("db", defaultDBPath, "sqlite db path the snapshots are written to")
+	logPath := fs.String("log", defaultLogPath, "log target path ('-' for stdout only)")
+	if err := fs.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return nil
+		}
+		return err
+	}
+	if strings.TrimSpace(*primary) == "" {
+		return errors.New("--primary is required")
+	}
+	if *tokenFile == "" {
+		return errors.New("--token-file is required")
+	}
+	if *interval < time.Second {
+		return errors.New("--interval must be at least 1s")
+	}
+	token, err := readKeyFile(*tokenFile)
+	if err != nil {
+		return err
+	}
+	if _, ok, err := readStandbyState(*dbPath); err != nil {
+		return err
+	} else if !ok {
+		if _, err := os.Stat(*dbPath); err == nil {
+			return fmt.Errorf("%s exists and is not a standby copy; refusing to overwrite it", *dbPath)
+		}
+		if err := writeStandbyState(*dbPath, standbyState{Primary: *primary}); err != nil {
+			return err
+		}
+	}
+
+	logger, closeLog, err := buildLogger(*logPath)
+	if err != nil {
+		return err
+	}
+	defer closeLog()
+	client := &http.Client{Timeout: 10 * time.Minute}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	if *once {
+		st, changed, err := pullSnapshot(ctx, client, *primary, string(token), *dbPath)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("snapshot %s (%d bytes, changed=%t)\n", st.SHA256, st.Bytes, changed)
+		return nil
+	}
+	logger.Printf("event=standby_started primary=%s db=%s interval=%s", *primary, *dbPath, *interval)
+	return standbyLoop(ctx, logger, client, *primary, string(token), *dbPath, *interval)
+}
+
+func runAdminPromoteStandby(args []string) error {
+	fs := flag.NewFlagSet("admin promote-standby", flag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s admin promote-standby [options]\n\n", binName())
+		fmt.Fprintln(fs.Output(), "Turns a warm standby copy into a primary: removes the standby marker (a running")
+		fmt.Fprintln(fs.Output(), "'standby' process exits at its next tick) so 'serve' can open the database.")
+		fmt.Fprintln(fs.Output())
+		fmt.Fprintln(fs.Output(), "Options:")
+		fs.PrintDefaults()
+	}
+	dbPath := fs.String("db", defaultDBPath, "sqlite db path")
+	logPath := fs.String("log", defaultLogPath, "log target path ('-' for stdout only)")
+	if err := fs.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return nil
+		}
+		return err
+	}
+	st, ok, err := readStandbyState(*dbPath)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return fmt.Errorf("%s is not a standby copy", *dbPath)
+	}
+	if st.SyncedAt == "" {
+		return errors.New("standby has never synced a snapshot; nothing to promote")
+	}
+	if err := os.Remove(standbyMarkerPath(*dbPath)); err != nil {
+		return err
+	}
+
+	app, closeApp, err := openAdminApp(*dbPath, *logPath)
+	if err != nil {
+		return err
+	}
+	defer closeApp()
+	_ = app.logAction("admin_cli", "admin", "promote_standby", fmt.Sprintf("primary=%s sha256=%s synced_at=%s", st.Primary, st.SHA256, st.SyncedAt))
+	fmt.Printf("promoted %s (last snapshot %s from %s); start serve with --db %s\n", *dbPath, st.SyncedAt, st.Primary, *dbPath)
+	return nil
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestStandbySnapshotPullAndPromote(t *testing.T) {
+	app := newTestApp(t)
+	createUser(t, app, "root", "PUDSNAPROOT")
+	createUser(t, app, "alice", "PUDSNAPALIC")
+	if _, err := app.db.Exec(`UPDATE users SET role = 'admin' WHERE username = 'root'`); err != nil {
+		t.Fatalf("promote root: %v", err)
+	}
+	srv := httptest.NewServer(newTestMux(app))
+	defer srv.Close()
+	h := newTestMux(app)
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, authedReq(t, http.MethodPost, "/api/entries", map[string]string{"content": "before failover"}, "PUDSNAPALIC"))
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("expected 201, got %d body=%s", rr.Code, rr.Body.String())
+	}
+
+	rr = httptest.NewRecorder()
+	h.ServeHTTP(rr, authedReq(t, http.MethodGet, "/api/admin/snapshot", nil, "PUDSNAPALIC"))
+	if rr.Code != http.StatusForbidden {
+		t.Fatalf("expected 403 for member snapshot, got %d", rr.Code)
+	}
+
+	standbyDB := filepath.Join(t.TempDir(), "standby.db")
+	if err := writeStandbyState(standbyDB, standbyState{Primary: srv.URL}); err != nil {
+		t.Fatalf("write marker: %v", err)
+	}
+	if _, _, err := pullSnapshot(context.Background(), srv.Client(), srv.URL, "PUDSNAPALIC", standbyDB); err == nil {
+		t.Fatalf("expected member token to be refused")
+	}
+	st, changed, err := pullSnapshot(context.Background(), srv.Client(), srv.URL, "PUDSNAPROOT", standbyDB)
+	if err != nil || !changed || st.SHA256 == "" {
+		t.Fatalf("pullSnapshot: st=%+v changed=%t err=%v", st, changed, err)
+	}
+	copyDB, err := openDB(standbyDB)
+	if err != nil {
+		t.Fatalf("open standby: %v", err)
+	}
+	var content string
+	if err := copyDB.QueryRow(`SELECT content FROM entries WHERE entry_type = 'normal'`).Scan(&content); err != nil || content != "before failover" {
+		t.Fatalf("standby content = %q err=%v", content, err)
+	}
+	_ = copyDB.Close()
+
+	if err := runServe([]string{"--db", standbyDB, "--log", "-"}); err == nil || !strings.Contains(err.Error(), "warm standby") {
+		t.Fatalf("expected serve to refuse a standby db, got %v", err)
+	}
+	if err := runAdminPromoteStandby([]string{"--db", standbyDB, "--log", "-"}); err != nil {
+		t.Fatalf("promote: %v", err)
+	}
+	if _, ok, _ := readStandbyState(standbyDB); ok {
+		t.Fatalf("expected standby marker to be removed")
+	}
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"compress/gzip"
+	"encoding/json"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+const ndjsonContentType = "application/x-ndjson"
+
+// negotiateEncoding picks the response encoding from Accept-Encoding: zstd
+// first, then gzip. Brotli has no encoder in this binary; a proxy in front
+// (Caddy's encode directive) can add it.
+func negotiateEncoding(r *http.Request) string {
+	accepted := map[string]bool{}
+	for _, part := range strings.Split(r.Header.Get("Accept-Encoding"), ",") {
+		name, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		if q, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			if v, err := strconv.ParseFloat(q, 64); err == nil && v == 0 {
+				continue
+			}
+		}
+		accepted[strings.ToLower(strings.TrimSpace(name))] = true
+	}
+	switch {
+	case accepted["zstd"]:
+		return "zstd"
+	case accepted["gzip"]:
+		return "gzip"
+	}
+	return ""
+}
+
+// compressResponse wraps w in the negotiated encoder. The returned close
+// function flushes the encoder and must run after the last write.
+func (a *App) compressResponse(w http.ResponseWriter, r *http.Request) (io.Writer, func()) {
+	w.Header().Add("Vary", "Accept-Encoding")
+	if !a.compress {
+		return w, func() {}
+	}
+	switch negotiateEncoding(r) {
+	case "zstd":
+		// A small window keeps encoder memory low on small hosts.
+		zw, err := zstd.NewWriter(w, zstd.WithEncoderLevel(zstd.SpeedFastest), zstd.WithWindowSize(1<<20))
+		if err != nil {
+			return w, func() {}
+		}
+		w.Header().Set("Content-Encoding", "zstd")
+		w.Header().Del("Content-Length")
+		return zw, func() { _ = zw.Close() }
+	case "gzip":
+		gw, _ := gzip.NewWriterLevel(w, gzip.BestSpeed)
+		w.Header().Set("Content-Encoding", "gzip")
+		w.Header().Del("Content-Length")
+		return gw, func() { _ = gw.Close() }
+	}
+	return w, func() {}
+}
+
+// wantNDJSON reports whether the client asked for newline-delimited JSON via
+// ?format=ndjson or the Accept header.
+func wantNDJSON(r *http.Request) bool {
+	return r.URL.Query().Get("format") == "ndjson" || strings.Contains(r.Header.Get("Accept"), ndjsonContentType)
+}
+
+// jsonField is one top-level field written around a streamed array.
+type jsonField struct {
+	Key   string
+	Value any
+}
+
+// jsonArrayStream writes {"<fields>...,"<key>":[elem,elem,...]} one element
+// at a time, so a large day never sits in memory as a single JSON document.
+// In NDJSON mode only the elements are written, one per line.
+type jsonArrayStream struct {
+	w      io.Writer
+	ndjson bool
+	n      int
+	err    error
+}
+
+// startJSONArrayStream writes the leading fields (sorted by the caller) and
+// opens the array named key.
+func startJSONArrayStream(w io.Writer, ndjson bool, fields []jsonField, key string) *jsonArrayStream {
+	s := &jsonArrayStream{w: w, ndjson: ndjson}
+	if ndjson {
+		return s
+	}
+	s.write([]byte("{"))
+	for _, f := range fields {
+		s.field(f.Key, f.Value)
+		s.write([]byte(","))
+	}
+	s.writeKey(key)
+	s.write([]byte("["))
+	return s
+}
+
+func (s *jsonArrayStream) write(b []byte) {
+	if s.err == nil {
+		_, s.err = s.w.Write(b)
+	}
+}
+
+func (s *jsonArrayStream) writeKey(key string) {
+	k, _ := json.Marshal(key)
+	s.write(k)
+	s.write([]byte(":"))
+}
+
+func (s *jsonArrayStream) field(key string, v any) {
+	b, err := json.Marshal(v)
+	if err != nil && s.err == nil {
+		s.err = err
+	}
+	s.writeKey(key)
+	s.write(b)
+}
+
+// add writes one array element.
+func (s *jsonArrayStream) add(v any) {
+	b, err := json.Marshal(v)
+	if err != nil && s.err == nil {
+		s.err = err
+	}
+	switch {
+	case s.ndjson:
+		s.write(append(b, '\n'))
+	case s.n > 0:
+		s.write(append([]byte(","), b...))
+	default:
+		s.write(b)
+	}
+	s.n++
+}
+
+// finish closes the array, appends trailing fields and the closing brace.
+func (s *jsonArrayStream) finish(fields []jsonField) error {
+	if s.ndjson {
+		return s.err
+	}
+	s.write([]byte("]"))
+	for _, f := range fields {
+		s.write([]byte(","))
+		s.field(f.Key, f.Value)
+	}
+	s.write([]byte("}\n"))
+	return s.err
+}
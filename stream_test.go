@@ -0,0 +1,69 @@
+package main
+
+import (
+	"compress/gzip"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+func TestAPIListEntriesStreamingEncodings(t *testing.T) {
+	app := newTestApp(t)
+	app.compress = true
+	h := newTestMux(app)
+	createUser(t, app, "alice", "PUDSTREAM12")
+	for _, content := range []string{"first", "second", "third"} {
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, authedReq(t, http.MethodPost, "/api/entries", map[string]string{"content": content}, "PUDSTREAM12"))
+		if rr.Code != http.StatusCreated {
+			t.Fatalf("expected 201, got %d", rr.Code)
+		}
+	}
+	day := time.Now().UTC().Format("2006-01-02")
+
+	req := authedReq(t, http.MethodGet, "/api/entries?day="+day, nil, "PUDSTREAM12")
+	req.Header.Set("Accept-Encoding", "gzip, br, zstd")
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, req)
+	if rr.Header().Get("Content-Encoding") != "zstd" {
+		t.Fatalf("expected zstd encoding, got %q", rr.Header().Get("Content-Encoding"))
+	}
+	zr, err := zstd.NewReader(rr.Body)
+	if err != nil {
+		t.Fatalf("zstd reader: %v", err)
+	}
+	defer zr.Close()
+	var out struct {
+		Day     string     `json:"day"`
+		Entries []entryRow `json:"entries"`
+	}
+	if err := json.NewDecoder(zr).Decode(&out); err != nil {
+		t.Fatalf("decode zstd body: %v", err)
+	}
+	if out.Day != day || len(out.Entries) != 3 || out.Entries[0].Content != "third" {
+		t.Fatalf("unexpected streamed list: %+v", out)
+	}
+
+	req = authedReq(t, http.MethodGet, "/api/entries?format=ndjson&day="+day, nil, "PUDSTREAM12")
+	req.Header.Set("Accept-Encoding", "gzip")
+	rr = httptest.NewRecorder()
+	h.ServeHTTP(rr, req)
+	if rr.Header().Get("Content-Encoding") != "gzip" || rr.Header().Get("Content-Type") != ndjsonContentType {
+		t.Fatalf("unexpected headers: %v", rr.Header())
+	}
+	gz, err := gzip.NewReader(rr.Body)
+	if err != nil {
+		t.Fatalf("gzip reader: %v", err)
+	}
+	raw, _ := io.ReadAll(gz)
+	lines := strings.Split(strings.TrimSpace(string(raw)), "\n")
+	if len(lines) != 3 || !strings.Contains(lines[2], `"content":"first"`) {
+		t.Fatalf("unexpected ndjson body: %q", raw)
+	}
+}
@@ -0,0 +1,207 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// suggestWindow bounds how far back tag and user activity is ranked.
+	suggestWindow = 90 * 24 * time.Hour
+	// suggestScanRows caps the entries scanned for tags per request.
+	suggestScanRows = 2000
+)
+
+var tagRe = regexp.MustCompile(`(?:^|\s)#([A-Za-z][A-Za-z0-9_-]{0,49})`)
+
+// suggestion is one autocomplete candidate; Score is the usage count it was
+// ranked by.
+type suggestion struct {
+	Value string `json:"value"`
+	Label string `json:"label,omitempty"`
+	Score int    `json:"score"`
+}
+
+// rankSuggestions orders by score, then value, and trims to limit.
+func rankSuggestions(out []suggestion, limit int) []suggestion {
+	sort.SliceStable(out, func(i, j int) bool {
+		if out[i].Score != out[j].Score {
+			return out[i].Score > out[j].Score
+		}
+		return out[i].Value < out[j].Value
+	})
+	if len(out) > limit {
+		out = out[:limit]
+	}
+	return out
+}
+
+// likePrefix escapes q for a LIKE prefix match using '\' as escape char.
+func likePrefix(q string) string {
+	r := strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+	return r.Replace(q) + "%"
+}
+
+func (a *App) suggestTags(q string, since string, limit int) ([]suggestion, error) {
+	rows, err := a.db.Query(`
+SELECT content FROM entries
+WHERE created_at >= ? AND deleted_at IS NULL AND content LIKE ? ESCAPE '\'
+ORDER BY created_at DESC
+LIMIT ?`, since, "%#"+likePrefix(q), suggestScanRows)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	counts := map[string]int{}
+	lq := strings.ToLower(q)
+	for rows.Next() {
+		var content string
+		if err := rows.Scan(&content); err != nil {
+			return nil, err
+		}
+		for _, m := range tagRe.FindAllStringSubmatch(content, -1) {
+			tag := strings.ToLower(m[1])
+			if strings.HasPrefix(tag, lq) {
+				counts[tag]++
+			}
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	out := make([]suggestion, 0, len(counts))
+	for tag, n := range counts {
+		out = append(out, suggestion{Value: tag, Score: n})
+	}
+	return rankSuggestions(out, limit), nil
+}
+
+func (a *App) suggestUsers(q string, since string, limit int) ([]suggestion, error) {
+	rows, err := a.db.Query(`
+SELECT u.username, COUNT(e.id)
+FROM users u
+LEFT JOIN entries e ON e.user_id = u.id AND e.created_at >= ? AND e.deleted_at IS NULL
+WHERE u.kind = 'human' AND u.username LIKE ? ESCAPE '\'
+GROUP BY u.id
+ORDER BY COUNT(e.id) DESC, u.username ASC
+LIMIT ?`, since, likePrefix(q), limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	out := []suggestion{}
+	for rows.Next() {
+		var s suggestion
+		if err := rows.Scan(&s.Value, &s.Score); err != nil {
+			return nil, err
+		}
+		out = append(out, s)
+	}
+	return out, rows.Err()
+}
+
+// suggestReferences completes issue keys seen in entries and, for numeric
+// prefixes, entry ids usable as [[entry:N]] links.
+func (a *App) suggestReferences(q string, limit int) ([]suggestion, error) {
+	out := []suggestion{}
+	rows, err := a.db.Query(`
+SELECT r.issue_key, COALESCE(i.title, ''), COUNT(*)
+FROM issue_refs r
+LEFT JOIN issues i ON i.issue_key = r.issue_key
+WHERE r.issue_key LIKE ? ESCAPE '\'
+GROUP BY r.issue_key
+ORDER BY COUNT(*) DESC, r.issue_key ASC
+LIMIT ?`, likePrefix(strings.ToUpper(q)), limit)
+	if err != nil {
+		return nil, err
+	}
+	for rows.Next() {
+		var s suggestion
+		if err := rows.Scan(&s.Value, &s.Label, &s.Score); err != nil {
+			_ = rows.Close()
+			return nil, err
+		}
+		out = append(out, s)
+	}
+	_ = rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	if _, err := strconv.ParseInt(q, 10, 64); err != nil {
+		return out, nil
+	}
+	rows, err = a.db.Query(`
+SELECT e.id, u.username, e.entry_type, (SELECT COUNT(*) FROM entry_links l WHERE l.target_id = e.id)
+FROM entries e
+JOIN users u ON u.id = e.user_id
+WHERE CAST(e.id AS TEXT) LIKE ? AND e.deleted_at IS NULL
+ORDER BY e.id DESC
+LIMIT ?`, q+"%", limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var id int64
+		var user, entryType string
+		var backlinks int
+		if err := rows.Scan(&id, &user, &entryType, &backlinks); err != nil {
+			return nil, err
+		}
+		out = append(out, suggestion{Value: fmt.Sprintf("[[entry:%d]]", id), Label: user + " " + entryType, Score: backlinks})
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return rankSuggestions(out, limit), nil
+}
+
+// handleSuggest serves compose-box autocomplete. It is called on keystrokes,
+// so unlike other reads it does not write an action log row.
+func (a *App) handleSuggest(w http.ResponseWriter, r *http.Request, _ AuthedUser) {
+	if r.Method != http.MethodGet {
+		jsonErr(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	kind := strings.TrimSpace(r.URL.Query().Get("kind"))
+	q := strings.TrimSpace(r.URL.Query().Get("q"))
+	q = strings.TrimLeft(q, "#@")
+	if len(q) > 100 {
+		jsonErr(w, http.StatusBadRequest, "q too long")
+		return
+	}
+	limit := 10
+	if raw := strings.TrimSpace(r.URL.Query().Get("limit")); raw != "" {
+		if n, err := strconv.Atoi(raw); err == nil && n > 0 && n <= 50 {
+			limit = n
+		}
+	}
+	since := time.Now().UTC().Add(-suggestWindow).Format(time.RFC3339)
+
+	var (
+		out []suggestion
+		err error
+	)
+	switch kind {
+	case "tag":
+		out, err = a.suggestTags(q, since, limit)
+	case "user":
+		out, err = a.suggestUsers(q, since, limit)
+	case "reference":
+		out, err = a.suggestReferences(q, limit)
+	default:
+		jsonErr(w, http.StatusBadRequest, "kind must be tag, user or reference")
+		return
+	}
+	if err != nil {
+		jsonErr(w, http.StatusInternalServerError, "failed to query suggestions")
+		return
+	}
+	jsonOut(w, http.StatusOK, map[string]any{"kind": kind, "q": q, "suggestions": out})
+}
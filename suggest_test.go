@@ -0,0 +1,65 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestAPISuggest(t *testing.T) {
+	app := newTestApp(t)
+	h := newTestMux(app)
+	createUser(t, app, "alice", "PUDABCDEF12")
+	createUser(t, app, "alfred", "PUDALFRED12")
+	createUser(t, app, "bob", "PUDBOBBOB12")
+
+	for _, c := range []string{"#release prep for PROJ-12", "more #release work", "#retro notes", "#Release done"} {
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, authedReq(t, http.MethodPost, "/api/entries", map[string]string{"content": c}, "PUDABCDEF12"))
+		if rr.Code != http.StatusCreated {
+			t.Fatalf("create entry: %d", rr.Code)
+		}
+	}
+	if _, err := app.db.Exec(`INSERT INTO issue_refs(entry_id, issue_key) VALUES(1, 'PROJ-12')`); err != nil {
+		t.Fatalf("insert issue ref: %v", err)
+	}
+
+	suggest := func(query string) []suggestion {
+		t.Helper()
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, authedReq(t, http.MethodGet, "/api/suggest?"+query, nil, "PUDBOBBOB12"))
+		if rr.Code != http.StatusOK {
+			t.Fatalf("suggest %s: %d %s", query, rr.Code, rr.Body.String())
+		}
+		var out struct {
+			Suggestions []suggestion `json:"suggestions"`
+		}
+		if err := json.Unmarshal(rr.Body.Bytes(), &out); err != nil {
+			t.Fatalf("decode: %v", err)
+		}
+		return out.Suggestions
+	}
+
+	tags := suggest("kind=tag&q=%23re")
+	if len(tags) != 2 || tags[0].Value != "release" || tags[0].Score != 3 || tags[1].Value != "retro" {
+		t.Fatalf("unexpected tag suggestions: %+v", tags)
+	}
+	users := suggest("kind=user&q=al")
+	if len(users) != 2 || users[0].Value != "alice" || users[1].Value != "alfred" {
+		t.Fatalf("expected active user ranked first, got %+v", users)
+	}
+	refs := suggest("kind=reference&q=proj")
+	if len(refs) != 1 || refs[0].Value != "PROJ-12" {
+		t.Fatalf("unexpected reference suggestions: %+v", refs)
+	}
+	if refs := suggest("kind=reference&q=3"); len(refs) != 1 || refs[0].Value != "[[entry:3]]" {
+		t.Fatalf("expected entry id completion, got %+v", refs)
+	}
+
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, authedReq(t, http.MethodGet, "/api/suggest?kind=file&q=x", nil, "PUDBOBBOB12"))
+	if rr.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400 for unknown kind, got %d", rr.Code)
+	}
+}
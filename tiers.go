@@ -0,0 +1,417 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Compaction tiers: a second stage that rolls daily compacts into one
+// weekly_compact per ISO week, and daily or weekly compacts into one
+// monthly_compact per month, once the period ended more than the tier's
+// *_after_days ago (the compaction_tiers setting; 0 turns a tier off). A
+// rollup keeps every source of the compacts it replaces in compact_data,
+// takes over their issue refs, links, attachments and entries_archive rows,
+// and is recorded on each day it covers in compactions.rollup_id. A weekly
+// compact belongs to the month of the first day it covers. Meeting-load
+// trailers are not carried over. Periods with a day under legal hold or in
+// quarantine wait, and rolled-up days can no longer be un-compacted.
+
+const (
+	tierWeekly  = "weekly"
+	tierMonthly = "monthly"
+	// maxTierAfterDays caps the *_after_days settings at about ten years.
+	maxTierAfterDays = 3650
+	rollupInterval   = time.Hour
+	subsystemRollup  = "compact_rollup"
+	journalRolledUp  = "rolled_up"
+)
+
+// compactTypesSQL lists the entry types whose compact_data holds sources.
+const compactTypesSQL = `('daily_compact', 'weekly_compact', 'monthly_compact')`
+
+var errDayRolledUp = errors.New("day is rolled up into a weekly or monthly compact")
+
+// compactionTiers is the compaction_tiers setting.
+type compactionTiers struct {
+	WeeklyAfterDays  int `json:"weekly_after_days"`
+	MonthlyAfterDays int `json:"monthly_after_days"`
+}
+
+func (t compactionTiers) validate() error {
+	for _, v := range []struct {
+		name string
+		n    int
+	}{{"weekly_after_days", t.WeeklyAfterDays}, {"monthly_after_days", t.MonthlyAfterDays}} {
+		if v.n < 0 || v.n > maxTierAfterDays {
+			return fmt.Errorf("%s must be between 0 and %d", v.name, maxTierAfterDays)
+		}
+	}
+	return nil
+}
+
+// isCompactType reports whether entries of entryType hold compacted sources.
+func isCompactType(entryType string) bool {
+	switch entryType {
+	case "daily_compact", "weekly_compact", "monthly_compact":
+		return true
+	}
+	return false
+}
+
+// tierRank orders the tiers; daily compacts are rank 0.
+func tierRank(tier string) int {
+	switch tier {
+	case tierWeekly:
+		return 1
+	case tierMonthly:
+		return 2
+	}
+	return 0
+}
+
+// tierPeriod returns the key, compact header and last day of the tier's
+// period containing day (YYYY-MM-DD).
+func tierPeriod(tier, day string) (key, header, end string, err error) {
+	t, err := time.Parse("2006-01-02", day)
+	if err != nil {
+		return "", "", "", err
+	}
+	if tier == tierWeekly {
+		start := t.AddDate(0, 0, -((int(t.Weekday()) + 6) % 7))
+		last := start.AddDate(0, 0, 6)
+		year, week := start.ISOWeek()
+		key = fmt.Sprintf("%d-W%02d", year, week)
+		return key, fmt.Sprintf("Weekly compact for %s (%s..%s)", key, start.Format("2006-01-02"), last.Format("2006-01-02")), last.Format("2006-01-02"), nil
+	}
+	start := time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, time.UTC)
+	key = start.Format("2006-01")
+	return key, "Monthly compact for " + key, start.AddDate(0, 1, -1).Format("2006-01-02"), nil
+}
+
+// renderRollup renders a rollup's text: its header, then each day's sources
+// rendered as that day's compact.
+func renderRollup(header string, sources []compactSource) string {
+	var b strings.Builder
+	b.WriteString(header)
+	b.WriteString("\n")
+	for i := 0; i < len(sources); {
+		day := sources[i].CreatedAt[:min(len(sources[i].CreatedAt), 10)]
+		j := i
+		for j < len(sources) && strings.HasPrefix(sources[j].CreatedAt, day) {
+			j++
+		}
+		b.WriteString("\n")
+		b.WriteString(renderCompact(day, sources[i:j]))
+		i = j
+	}
+	return b.String()
+}
+
+// sourceDays returns the distinct days of sorted sources.
+func sourceDays(sources []compactSource) []string {
+	var days []string
+	for _, s := range sources {
+		day := s.CreatedAt[:min(len(s.CreatedAt), 10)]
+		if len(days) == 0 || days[len(days)-1] != day {
+			days = append(days, day)
+		}
+	}
+	return days
+}
+
+// rollupUnit is something a rollup absorbs: the compacts of one day (tier
+// "") or an earlier rollup with the days it covers.
+type rollupUnit struct {
+	tier string
+	ids  []int64
+	days []string
+}
+
+// legacyCompactID finds the compact of a compactions row from a release
+// that did not record compact_id.
+func legacyCompactID(tx *sql.Tx, day string) (sql.NullInt64, error) {
+	var id sql.NullInt64
+	err := tx.QueryRow(`SELECT id FROM entries WHERE entry_type = 'daily_compact' AND content LIKE ? ORDER BY id DESC LIMIT 1`, compactHeaderPrefix+day+"%").Scan(&id)
+	if errors.Is(err, sql.ErrNoRows) {
+		return id, nil
+	}
+	return id, err
+}
+
+// rollupUnits lists every finished compaction as a unit, in day order.
+func rollupUnits(tx *sql.Tx) ([]rollupUnit, error) {
+	type row struct {
+		day       string
+		compactID sql.NullInt64
+		rollupID  sql.NullInt64
+	}
+	rows, err := tx.Query(`SELECT day, compact_id, rollup_id FROM compactions WHERE phase = ? AND merged_count > 0 ORDER BY day ASC`, compactionDone)
+	if err != nil {
+		return nil, err
+	}
+	var days []row
+	for rows.Next() {
+		var r row
+		if err := rows.Scan(&r.day, &r.compactID, &r.rollupID); err != nil {
+			_ = rows.Close()
+			return nil, err
+		}
+		days = append(days, r)
+	}
+	if err := rows.Err(); err != nil {
+		_ = rows.Close()
+		return nil, err
+	}
+	_ = rows.Close()
+
+	var units []rollupUnit
+	rollups := map[int64]int{}
+	for _, r := range days {
+		if r.rollupID.Valid {
+			if i, ok := rollups[r.rollupID.Int64]; ok {
+				units[i].days = append(units[i].days, r.day)
+				continue
+			}
+			var entryType string
+			err := tx.QueryRow(`SELECT entry_type FROM entries WHERE id = ?`, r.rollupID.Int64).Scan(&entryType)
+			if errors.Is(err, sql.ErrNoRows) {
+				// Reported by the integrity check.
+				continue
+			}
+			if err != nil {
+				return nil, err
+			}
+			rollups[r.rollupID.Int64] = len(units)
+			units = append(units, rollupUnit{tier: strings.TrimSuffix(entryType, "_compact"), ids: []int64{r.rollupID.Int64}, days: []string{r.day}})
+			continue
+		}
+		if !r.compactID.Valid {
+			if r.compactID, err = legacyCompactID(tx, r.day); err != nil {
+				return nil, err
+			}
+			if !r.compactID.Valid {
+				continue
+			}
+		}
+		parts, err := dayCompactParts(tx, r.day, r.compactID.Int64)
+		if err != nil {
+			return nil, err
+		}
+		u := rollupUnit{days: []string{r.day}}
+		for _, p := range parts {
+			u.ids = append(u.ids, p.CompactID)
+		}
+		units = append(units, u)
+	}
+	return units, nil
+}
+
+// rollupResult reports one rollup written by rollupTick.
+type rollupResult struct {
+	Tier      string `json:"tier"`
+	Period    string `json:"period"`
+	CompactID int64  `json:"compact_id"`
+	Compacts  int    `json:"compacts"`
+	Days      int    `json:"days"`
+	Sources   int    `json:"sources"`
+}
+
+func (a *App) rollupLoop(ctx context.Context) {
+	ticker := time.NewTicker(rollupInterval)
+	defer ticker.Stop()
+	a.health.register(subsystemRollup, rollupInterval, time.Now())
+	for {
+		_, err := a.rollupTick(time.Now())
+		if err != nil {
+			a.logger.Printf("event=compact_rollup_failed err=%v", err)
+		}
+		a.health.record(subsystemRollup, time.Now(), err)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// rollupTick writes every rollup that is due at now, weekly before monthly,
+// and returns what it wrote.
+func (a *App) rollupTick(now time.Time) ([]rollupResult, error) {
+	tiers := a.settings().CompactionTiers
+	if tiers.WeeklyAfterDays == 0 && tiers.MonthlyAfterDays == 0 {
+		return nil, nil
+	}
+	if a.compactionTZ != nil {
+		now = now.In(a.compactionTZ)
+	}
+	a.compactMu.Lock()
+	defer a.compactMu.Unlock()
+
+	var out []rollupResult
+	for _, tier := range []struct {
+		name  string
+		after int
+	}{{tierWeekly, tiers.WeeklyAfterDays}, {tierMonthly, tiers.MonthlyAfterDays}} {
+		if tier.after == 0 {
+			continue
+		}
+		cutoff := now.AddDate(0, 0, -tier.after).Format("2006-01-02")
+		tx, err := a.db.Begin()
+		if err != nil {
+			return out, err
+		}
+		units, err := rollupUnits(tx)
+		_ = tx.Rollback()
+		if err != nil {
+			return out, err
+		}
+		type period struct {
+			header string
+			units  []rollupUnit
+			fresh  bool
+		}
+		periods := map[string]*period{}
+		var keys []string
+		for _, u := range units {
+			if tierRank(u.tier) > tierRank(tier.name) {
+				continue
+			}
+			key, header, end, err := tierPeriod(tier.name, u.days[0])
+			if err != nil || end > cutoff {
+				continue
+			}
+			p, ok := periods[key]
+			if !ok {
+				p = &period{header: header}
+				periods[key] = p
+				keys = append(keys, key)
+			}
+			p.units = append(p.units, u)
+			p.fresh = p.fresh || u.tier != tier.name
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			p := periods[key]
+			if !p.fresh {
+				continue
+			}
+			blocked, err := a.periodBlocked(p.units)
+			if err != nil {
+				return out, err
+			}
+			if blocked {
+				continue
+			}
+			res, err := a.rollupPeriod(tier.name, key, p.header, p.units)
+			if err != nil {
+				return out, fmt.Errorf("%s %s: %w", tier.name, key, err)
+			}
+			a.logger.Printf("event=compact_rollup tier=%s period=%s compact_id=%d compacts=%d days=%d sources=%d", res.Tier, res.Period, res.CompactID, res.Compacts, res.Days, res.Sources)
+			_ = a.logActorAction(actorScheduler, "compact_rollup", fmt.Sprintf("tier=%s period=%s compact_id=%d compacts=%d days=%d sources=%d", res.Tier, res.Period, res.CompactID, res.Compacts, res.Days, res.Sources))
+			out = append(out, res)
+		}
+	}
+	return out, nil
+}
+
+// periodBlocked reports whether a day of the units is held or quarantined.
+func (a *App) periodBlocked(units []rollupUnit) (bool, error) {
+	for _, u := range units {
+		for _, day := range u.days {
+			held, err := a.dayOnHold(day)
+			if err != nil || held {
+				return held, err
+			}
+			quarantined, err := a.dayQuarantined(day)
+			if err != nil || quarantined {
+				return quarantined, err
+			}
+		}
+	}
+	return false, nil
+}
+
+// rollupPeriod replaces the units' compacts with one rollup in a single
+// transaction.
+func (a *App) rollupPeriod(tier, key, header string, units []rollupUnit) (rollupResult, error) {
+	res := rollupResult{Tier: tier, Period: key}
+	tx, err := a.db.Begin()
+	if err != nil {
+		return res, err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	var ids, days []any
+	var sources []compactSource
+	for _, u := range units {
+		for _, id := range u.ids {
+			var content string
+			var data sql.NullString
+			err := tx.QueryRow(`SELECT content, compact_data FROM entries WHERE id = ? AND entry_type IN `+compactTypesSQL, id).Scan(&content, &data)
+			if errors.Is(err, sql.ErrNoRows) {
+				return res, fmt.Errorf("compact %d is missing", id)
+			}
+			if err != nil {
+				return res, err
+			}
+			sources = append(sources, compactSources(data, content)...)
+			ids = append(ids, id)
+		}
+		for _, day := range u.days {
+			days = append(days, day)
+		}
+	}
+	sortSources(sources)
+	data, err := json.Marshal(sources)
+	if err != nil {
+		return res, err
+	}
+	r, err := tx.Exec(`INSERT INTO entries(user_id, entry_type, content, compact_data, created_at) VALUES(?, ?, ?, ?, ?)`,
+		actorSystem.ID, tier+"_compact", renderRollup(header, sources), string(data), nowUTC())
+	if err != nil {
+		return res, err
+	}
+	if res.CompactID, err = r.LastInsertId(); err != nil {
+		return res, err
+	}
+
+	in := placeholders(len(ids))
+	moved := append([]any{res.CompactID}, ids...)
+	for _, q := range []string{
+		`UPDATE OR IGNORE issue_refs SET entry_id = ? WHERE entry_id IN (` + in + `)`,
+		`UPDATE OR IGNORE entry_attachments SET entry_id = ? WHERE entry_id IN (` + in + `)`,
+		`UPDATE OR IGNORE entry_links SET source_id = ? WHERE source_id IN (` + in + `)`,
+		`UPDATE OR IGNORE entry_links SET target_id = ? WHERE target_id IN (` + in + `)`,
+		`UPDATE entries_archive SET compact_id = ? WHERE compact_id IN (` + in + `)`,
+	} {
+		if _, err := tx.Exec(q, moved...); err != nil {
+			return res, err
+		}
+	}
+	if _, err := tx.Exec(`DELETE FROM entry_links WHERE source_id = ? AND target_id = ?`, res.CompactID, res.CompactID); err != nil {
+		return res, err
+	}
+	// Whatever could not move (duplicates) goes with the old compacts.
+	if _, err := tx.Exec(`DELETE FROM entries WHERE id IN (`+in+`)`, ids...); err != nil {
+		return res, err
+	}
+	if _, err := tx.Exec(`UPDATE compactions SET rollup_id = ? WHERE day IN (`+placeholders(len(days))+`)`, append([]any{res.CompactID}, days...)...); err != nil {
+		return res, err
+	}
+	for _, day := range days {
+		if err := journalCompaction(tx, day.(string), journalRolledUp, fmt.Sprintf("into %s_compact id=%d for %s", tier, res.CompactID, key)); err != nil {
+			return res, err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return res, err
+	}
+	res.Compacts, res.Days, res.Sources = len(ids), len(days), len(sources)
+	return res, nil
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCompactionTiers(t *testing.T) {
+	app := newTestApp(t)
+	createUser(t, app, "alice", "PUDTIERSAL1")
+	alice, err := app.lookupToken("PUDTIERSAL1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	bad := compactionTiers{WeeklyAfterDays: -1}
+	if _, _, err := app.updateSettings(settingsPatch{CompactionTiers: &bad}, "admin"); err == nil {
+		t.Fatalf("expected negative weekly_after_days to be rejected")
+	}
+	days := []string{"2026-02-09", "2026-02-11", "2026-02-17"}
+	for _, day := range days {
+		if _, err := app.insertEntry(alice.ID, "worked on "+day, "", sourceAPI, day+"T10:00:00Z"); err != nil {
+			t.Fatal(err)
+		}
+		if err := app.compactDay(day); err != nil {
+			t.Fatalf("compactDay %s: %v", day, err)
+		}
+	}
+	tiers := compactionTiers{WeeklyAfterDays: 7}
+	if _, _, err := app.updateSettings(settingsPatch{CompactionTiers: &tiers}, "admin"); err != nil {
+		t.Fatalf("updateSettings: %v", err)
+	}
+	count := func(entryType string) int {
+		var n int
+		if err := app.db.QueryRow(`SELECT COUNT(*) FROM entries WHERE entry_type = ?`, entryType).Scan(&n); err != nil {
+			t.Fatal(err)
+		}
+		return n
+	}
+
+	// Week 7 (Feb 9-15) ended more than 7 days before Feb 23; week 8 did not.
+	out, err := app.rollupTick(time.Date(2026, 2, 23, 12, 0, 0, 0, time.UTC))
+	if err != nil || len(out) != 1 || out[0].Period != "2026-W07" || out[0].Days != 2 || out[0].Sources != 2 {
+		t.Fatalf("weekly rollup: %+v err=%v", out, err)
+	}
+	if count("daily_compact") != 1 || count("weekly_compact") != 1 {
+		t.Fatalf("expected one daily and one weekly compact, got %d and %d", count("daily_compact"), count("weekly_compact"))
+	}
+	var content string
+	if err := app.db.QueryRow(`SELECT content FROM entries WHERE id = ?`, out[0].CompactID).Scan(&content); err != nil || !strings.HasPrefix(content, "Weekly compact for 2026-W07 (2026-02-09..2026-02-15)") || !strings.Contains(content, "worked on 2026-02-11") {
+		t.Fatalf("weekly compact: %q err=%v", content, err)
+	}
+	if _, err := app.uncompactDay("2026-02-09"); !errors.Is(err, errDayRolledUp) {
+		t.Fatalf("expected rolled-up day to refuse uncompact, got %v", err)
+	}
+	notes, err := app.dayNoteEntries("2026-02-09")
+	if err != nil || len(notes) != 1 || notes[0].Content != "worked on 2026-02-09" {
+		t.Fatalf("daily note of a rolled-up day: %+v err=%v", notes, err)
+	}
+	if issues, err := app.findIntegrityIssues(); err != nil || len(issues) != 0 {
+		t.Fatalf("integrity: %+v err=%v", issues, err)
+	}
+
+	tiers.MonthlyAfterDays = 1
+	if _, _, err := app.updateSettings(settingsPatch{CompactionTiers: &tiers}, "admin"); err != nil {
+		t.Fatalf("updateSettings: %v", err)
+	}
+	out, err = app.rollupTick(time.Date(2026, 3, 5, 12, 0, 0, 0, time.UTC))
+	if err != nil || len(out) != 2 || out[0].Period != "2026-W08" || out[1].Period != "2026-02" || out[1].Compacts != 2 || out[1].Sources != 3 {
+		t.Fatalf("monthly rollup: %+v err=%v", out, err)
+	}
+	if count("daily_compact") != 0 || count("weekly_compact") != 0 || count("monthly_compact") != 1 {
+		t.Fatalf("expected only the monthly compact left")
+	}
+	var rolled int
+	if err := app.db.QueryRow(`SELECT COUNT(*) FROM compactions WHERE rollup_id = ?`, out[1].CompactID).Scan(&rolled); err != nil || rolled != len(days) {
+		t.Fatalf("expected every day rolled into the monthly compact, got %d err=%v", rolled, err)
+	}
+	if issues, err := app.findIntegrityIssues(); err != nil || len(issues) != 0 {
+		t.Fatalf("integrity: %+v err=%v", issues, err)
+	}
+	if out, err := app.rollupTick(time.Date(2026, 3, 5, 13, 0, 0, 0, time.UTC)); err != nil || len(out) != 0 {
+		t.Fatalf("expected nothing left to roll up, got %+v err=%v", out, err)
+	}
+}
@@ -0,0 +1,810 @@
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Token hash schemes, stored per row in tokens.token_scheme. Tokens are
+// random but short (9 slug chars), so a bare SHA-256 can be brute-forced
+// offline from a copy of the database. The hash is an HMAC keyed by a pepper
+// kept outside the database: --token-pepper-file, or <db>.pepper, which serve
+// generates on first start. SHA-256 remains for rows written before that and
+// for admin commands run where no pepper exists. A memory-hard hash is not
+// used because tokens are looked up by hash: a salted hash would need a scan
+// over every user on each request.
+const (
+	tokenSchemeSHA256 = "sha256"
+	tokenSchemeHMAC   = "hmac-sha256"
+)
+
+// defaultTokenName names the token a user is created with; tokens moved over
+// from the users table get it too.
+const defaultTokenName = "default"
+
+const (
+	maxTokenNameLen = 64
+	// Browser tokens minted by /api/auth/exchange live for hours, not
+	// months, so what the UI keeps in localStorage is worth little.
+	defaultBrowserTokenTTL = 8 * time.Hour
+	maxBrowserTokenTTL     = 24 * time.Hour
+	browserTokenPrefix     = "browser-"
+	// tokenPruneInterval is how often expired tokens are deleted; lookups
+	// reject them from the moment they expire.
+	tokenPruneInterval  = time.Hour
+	subsystemTokenPrune = "token_prune"
+)
+
+// tokenPepperIDKey is the settings row holding the fingerprint of the pepper
+// tokens are hashed with (tokenPepperID).
+const tokenPepperIDKey = "token_pepper_id"
+
+var (
+	errPepperRequired = errors.New("token pepper required")
+	errPepperMismatch = errors.New("token pepper does not match")
+	errTokenNameTaken = errors.New("a token with that name already exists")
+	errUnknownToken   = errors.New("token not found")
+)
+
+// hashToken is the legacy unkeyed scheme.
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+func pepperToken(pepper []byte, token string) string {
+	m := hmac.New(sha256.New, pepper)
+	m.Write([]byte(token))
+	return hex.EncodeToString(m.Sum(nil))
+}
+
+// tokenHash returns the hash and scheme new tokens are stored with: the
+// peppered HMAC when a pepper is configured, SHA-256 otherwise.
+func (a *App) tokenHash(token string) (string, string) {
+	if len(a.tokenPepper) > 0 {
+		return pepperToken(a.tokenPepper, token), tokenSchemeHMAC
+	}
+	return hashToken(token), tokenSchemeSHA256
+}
+
+// usersTokenHashColumn matches the users.token_hash declaration of
+// releases that stored tokens on users rows.
+var usersTokenHashColumn = regexp.MustCompile(`\s*token_hash\s+TEXT\s+NOT\s+NULL\s+UNIQUE\s*,`)
+
+// migrateUserTokens moves tokens still stored on users rows into the tokens
+// table as each user's default token, keeping their scheme and last use, and
+// then drops users.token_hash. SQLite cannot drop a UNIQUE column, so users is
+// rebuilt from its stored definition with foreign keys off, the way the SQLite
+// docs describe for schema changes; the connection is pinned so the pragma
+// applies to the transaction.
+func (a *App) migrateUserTokens() error {
+	var legacy int
+	if err := a.db.QueryRow(`SELECT COUNT(*) FROM pragma_table_info('users') WHERE name = 'token_hash'`).Scan(&legacy); err != nil || legacy == 0 {
+		return err
+	}
+	var createSQL string
+	if err := a.db.QueryRow(`SELECT sql FROM sqlite_master WHERE type = 'table' AND name = 'users'`).Scan(&createSQL); err != nil {
+		return err
+	}
+	newSQL := usersTokenHashColumn.ReplaceAllString(createSQL, "")
+	if newSQL == createSQL || !strings.HasPrefix(newSQL, "CREATE TABLE users") {
+		return fmt.Errorf("drop users.token_hash: unexpected table definition %q", createSQL)
+	}
+	newSQL = "CREATE TABLE users_new" + strings.TrimPrefix(newSQL, "CREATE TABLE users")
+	rows, err := a.db.Query(`SELECT name FROM pragma_table_info('users') WHERE name != 'token_hash' ORDER BY cid`)
+	if err != nil {
+		return err
+	}
+	var cols []string
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			rows.Close()
+			return err
+		}
+		cols = append(cols, `"`+name+`"`)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	colList := strings.Join(cols, ", ")
+
+	ctx := context.Background()
+	conn, err := a.db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	if _, err := conn.ExecContext(ctx, `PRAGMA foreign_keys=OFF`); err != nil {
+		return err
+	}
+	defer func() { _, _ = conn.ExecContext(ctx, `PRAGMA foreign_keys=ON`) }()
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = tx.Rollback() }()
+	// Rows written by releases before the tokens table hold a real hash;
+	// later ones hold a non-hex placeholder starting with '!'.
+	if _, err := tx.Exec(`
+INSERT INTO tokens(user_id, name, token_hash, token_scheme, created_at, last_used_at, last_used_ip)
+SELECT id, ?, token_hash, token_scheme, created_at, last_used_at, last_used_ip
+FROM users
+WHERE kind = 'human' AND token_hash NOT LIKE '!%'`, defaultTokenName); err != nil {
+		return err
+	}
+	var seq sql.NullInt64
+	if err := tx.QueryRow(`SELECT seq FROM sqlite_sequence WHERE name = 'users'`).Scan(&seq); err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return err
+	}
+	for _, q := range []string{
+		newSQL,
+		`INSERT INTO users_new(` + colList + `) SELECT ` + colList + ` FROM users`,
+		`DROP TABLE users`,
+		`ALTER TABLE users_new RENAME TO users`,
+	} {
+		if _, err := tx.Exec(q); err != nil {
+			return fmt.Errorf("drop users.token_hash: %w", err)
+		}
+	}
+	// Keep AUTOINCREMENT from handing out ids of users deleted before the rebuild.
+	if seq.Valid {
+		if _, err := tx.Exec(`UPDATE sqlite_sequence SET seq = MAX(seq, ?) WHERE name = 'users'`, seq.Int64); err != nil {
+			return err
+		}
+	}
+	var dangling int
+	if err := tx.QueryRow(`SELECT COUNT(*) FROM pragma_foreign_key_check`).Scan(&dangling); err != nil {
+		return err
+	}
+	if dangling > 0 {
+		return fmt.Errorf("drop users.token_hash: %d rows fail foreign key checks", dangling)
+	}
+	return tx.Commit()
+}
+
+const tokenLookupSQL = `
+SELECT t.id, u.id, u.username, u.role, t.parent_id IS NOT NULL
+FROM tokens t
+JOIN users u ON u.id = t.user_id
+WHERE t.token_hash = ? AND t.token_scheme = ? AND u.kind = 'human' AND u.disabled = 0
+  AND (t.expires_at IS NULL OR t.expires_at > ?)`
+
+// lookupToken finds the enabled human user holding token, unless the token
+// has expired. A SHA-256 row matched while a pepper is configured is rehashed
+// in place, so tokens move to the peppered scheme on first use.
+func (a *App) lookupToken(token string) (AuthedUser, error) {
+	var u AuthedUser
+	now := nowUTC()
+	if len(a.tokenPepper) > 0 {
+		err := a.db.QueryRow(tokenLookupSQL, pepperToken(a.tokenPepper, token), tokenSchemeHMAC, now).Scan(&u.TokenID, &u.ID, &u.Username, &u.Role, &u.Exchanged)
+		if !errors.Is(err, sql.ErrNoRows) {
+			return u, err
+		}
+	}
+	legacy := hashToken(token)
+	err := a.db.QueryRow(tokenLookupSQL, legacy, tokenSchemeSHA256, now).Scan(&u.TokenID, &u.ID, &u.Username, &u.Role, &u.Exchanged)
+	if err != nil {
+		return AuthedUser{}, err
+	}
+	if len(a.tokenPepper) > 0 {
+		if _, err := a.db.Exec(`UPDATE tokens SET token_hash = ?, token_scheme = ? WHERE id = ? AND token_hash = ?`,
+			pepperToken(a.tokenPepper, token), tokenSchemeHMAC, u.TokenID, legacy); err != nil {
+			a.logger.Printf("event=token_rehash_failed user_id=%d token_id=%d err=%v", u.ID, u.TokenID, err)
+		} else {
+			a.logger.Printf("event=token_rehashed user_id=%d token_id=%d scheme=%s", u.ID, u.TokenID, tokenSchemeHMAC)
+		}
+	}
+	return u, nil
+}
+
+// checkTokenPepper refuses to run without a pepper, or with a different one,
+// once any token has been stored with one, since those users could no longer
+// sign in. It warns when running without a pepper and otherwise records the
+// pepper's fingerprint for the next start.
+func (a *App) checkTokenPepper() error {
+	var n int
+	if err := a.db.QueryRow(`SELECT COUNT(*) FROM tokens WHERE token_scheme = ?`, tokenSchemeHMAC).Scan(&n); err != nil {
+		return err
+	}
+	if len(a.tokenPepper) == 0 {
+		if n > 0 {
+			return fmt.Errorf("%w: %d token(s) are hashed with a pepper; pass --token-pepper-file", errPepperRequired, n)
+		}
+		a.logger.Printf("event=token_pepper_missing detail=%q", "tokens are stored as plain SHA-256 and can be brute-forced from a copy of the database")
+		return nil
+	}
+	id := tokenPepperID(a.tokenPepper)
+	var stored string
+	err := a.db.QueryRow(`SELECT value FROM settings WHERE key = ?`, tokenPepperIDKey).Scan(&stored)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return err
+	}
+	var storedID string
+	if stored != "" {
+		if err := json.Unmarshal([]byte(stored), &storedID); err != nil {
+			return fmt.Errorf("setting %s: %w", tokenPepperIDKey, err)
+		}
+	}
+	if storedID == id {
+		return nil
+	}
+	if storedID != "" && n > 0 {
+		return fmt.Errorf("%w: %d token(s) are hashed with pepper %s, this one is %s; pass the original --token-pepper-file", errPepperMismatch, n, storedID, id)
+	}
+	data, _ := json.Marshal(id)
+	_, err = a.db.Exec(`
+INSERT INTO settings(key, value, updated_by, updated_at) VALUES(?, ?, ?, ?)
+ON CONFLICT(key) DO UPDATE SET value = excluded.value, updated_by = excluded.updated_by, updated_at = excluded.updated_at`,
+		tokenPepperIDKey, string(data), actorSystem.Username, nowUTC())
+	return err
+}
+
+// tokenPepperID fingerprints a pepper without revealing it.
+func tokenPepperID(pepper []byte) string {
+	return pepperToken(pepper, tokenPepperIDKey)[:16]
+}
+
+// loadTokenPepper reads the token pepper. An explicit path must exist. The
+// default, <db>.pepper, is generated when generate is set (serve) and used
+// if present otherwise (admin commands, which then hash like the server).
+func loadTokenPepper(path, dbPath string, generate bool, logger *log.Logger) ([]byte, error) {
+	var pepper []byte
+	var err error
+	switch {
+	case path != "":
+		pepper, err = readKeyFile(path)
+	case generate:
+		var created bool
+		path = dbPath + ".pepper"
+		pepper, created, err = readOrCreateKeyFile(path)
+		if created {
+			logger.Printf("event=token_pepper_generated path=%s", path)
+		}
+	default:
+		pepper, err = readKeyFile(dbPath + ".pepper")
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, nil
+		}
+	}
+	if err != nil {
+		return nil, fmt.Errorf("token pepper: %w", err)
+	}
+	return pepper, nil
+}
+
+// checkTokenName trims and validates a token name.
+func checkTokenName(name string) (string, error) {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return "", errors.New("name is required")
+	}
+	if len(name) > maxTokenNameLen {
+		return "", fmt.Errorf("name must be at most %d characters", maxTokenNameLen)
+	}
+	return name, nil
+}
+
+// parseTokenTTL parses a token lifetime: 90d, 12w, 720h or any Go duration.
+// Empty means the token does not expire.
+func parseTokenTTL(s string) (time.Duration, error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return 0, nil
+	}
+	if n := len(s); n > 1 && (s[n-1] == 'd' || s[n-1] == 'w') {
+		if count, err := strconv.Atoi(s[:n-1]); err == nil && count > 0 {
+			if s[n-1] == 'w' {
+				count *= 7
+			}
+			return time.Duration(count) * 24 * time.Hour, nil
+		}
+	}
+	if d, err := time.ParseDuration(s); err == nil && d > 0 {
+		return d, nil
+	}
+	return 0, fmt.Errorf("invalid ttl %q (want e.g. 90d, 12w or 720h)", s)
+}
+
+// tokenExpiry is the expires_at value for a token created now with ttl.
+func tokenExpiry(ttl time.Duration) sql.NullString {
+	if ttl <= 0 {
+		return sql.NullString{}
+	}
+	return sql.NullString{String: time.Now().Add(ttl).UTC().Format(time.RFC3339), Valid: true}
+}
+
+// insertHumanUser creates a human user holding token as its default token,
+// expiring at expiresAt (see tokenExpiry).
+func (a *App) insertHumanUser(username, role, token string, expiresAt sql.NullString) (int64, error) {
+	tx, err := a.db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer func() { _ = tx.Rollback() }()
+	now := nowUTC()
+	res, err := tx.Exec(`INSERT INTO users(username, role, created_at) VALUES(?, ?, ?)`, username, role, now)
+	if err != nil {
+		return 0, err
+	}
+	id, _ := res.LastInsertId()
+	hash, scheme := a.tokenHash(token)
+	if _, err := tx.Exec(`INSERT INTO tokens(user_id, name, token_hash, token_scheme, created_at, expires_at) VALUES(?, ?, ?, ?, ?, ?)`,
+		id, defaultTokenName, hash, scheme, now, expiresAt); err != nil {
+		return 0, err
+	}
+	return id, tx.Commit()
+}
+
+// issueToken adds a token named name to user userID, expiring at expiresAt,
+// and returns its id and the plaintext, which is not stored.
+func (a *App) issueToken(userID int64, name string, expiresAt sql.NullString) (int64, string, error) {
+	var n int
+	if err := a.db.QueryRow(`SELECT COUNT(*) FROM tokens WHERE user_id = ? AND name = ?`, userID, name).Scan(&n); err != nil {
+		return 0, "", err
+	}
+	if n > 0 {
+		return 0, "", errTokenNameTaken
+	}
+	token, err := generateToken()
+	if err != nil {
+		return 0, "", err
+	}
+	hash, scheme := a.tokenHash(token)
+	res, err := a.db.Exec(`INSERT INTO tokens(user_id, name, token_hash, token_scheme, created_at, expires_at) VALUES(?, ?, ?, ?, ?, ?)`,
+		userID, name, hash, scheme, nowUTC(), expiresAt)
+	if err != nil {
+		return 0, "", err
+	}
+	id, _ := res.LastInsertId()
+	return id, token, nil
+}
+
+// rotateToken replaces user userID's token called name with a fresh one and
+// returns it, creating the token if the user has none by that name. The old
+// hash is overwritten in the same UPDATE, so the old token stops working the
+// moment the new one exists. An expiring token keeps its lifetime: the new
+// one expires as long after now as the old one did after its creation.
+// Browser tokens exchanged from the old token are revoked.
+func (a *App) rotateToken(userID int64, name string) (string, error) {
+	tx, err := a.db.Begin()
+	if err != nil {
+		return "", err
+	}
+	defer func() { _ = tx.Rollback() }()
+	token, err := a.rotateTokenTx(tx, userID, name)
+	if err != nil {
+		return "", err
+	}
+	return token, tx.Commit()
+}
+
+// rotateTokenTx is rotateToken within the caller's transaction.
+func (a *App) rotateTokenTx(tx execer, userID int64, name string) (string, error) {
+	token, err := generateToken()
+	if err != nil {
+		return "", err
+	}
+	if _, err := tx.Exec(`DELETE FROM tokens WHERE parent_id IN (SELECT id FROM tokens WHERE user_id = ? AND name = ?)`, userID, name); err != nil {
+		return "", err
+	}
+	hash, scheme := a.tokenHash(token)
+	now := nowUTC()
+	res, err := tx.Exec(`
+UPDATE tokens
+SET token_hash = ?, token_scheme = ?, last_used_at = NULL, last_used_ip = '', created_at = ?,
+    expires_at = CASE WHEN expires_at IS NULL THEN NULL
+        ELSE strftime('%Y-%m-%dT%H:%M:%SZ', ?, '+' || (strftime('%s', expires_at) - strftime('%s', created_at)) || ' seconds') END
+WHERE user_id = ? AND name = ?`,
+		hash, scheme, now, now, userID, name)
+	if err != nil {
+		return "", err
+	}
+	if n, _ := res.RowsAffected(); n > 0 {
+		return token, nil
+	}
+	if _, err := tx.Exec(`INSERT INTO tokens(user_id, name, token_hash, token_scheme, created_at) VALUES(?, ?, ?, ?, ?)`, userID, name, hash, scheme, now); err != nil {
+		return "", err
+	}
+	return token, nil
+}
+
+// pruneExpiredTokens deletes tokens that expired before now, one
+// prune_token audit row each, and returns how many went.
+func (a *App) pruneExpiredTokens(now time.Time) (int, error) {
+	cutoff := now.UTC().Format(time.RFC3339)
+	rows, err := a.db.Query(`
+SELECT t.id, t.name, u.username, t.expires_at
+FROM tokens t
+JOIN users u ON u.id = t.user_id
+WHERE t.expires_at IS NOT NULL AND t.expires_at <= ?`, cutoff)
+	if err != nil {
+		return 0, err
+	}
+	type expired struct {
+		id                        int64
+		name, username, expiresAt string
+	}
+	var list []expired
+	for rows.Next() {
+		var e expired
+		if err := rows.Scan(&e.id, &e.name, &e.username, &e.expiresAt); err != nil {
+			_ = rows.Close()
+			return 0, err
+		}
+		list = append(list, e)
+	}
+	if err := rows.Err(); err != nil {
+		_ = rows.Close()
+		return 0, err
+	}
+	_ = rows.Close()
+	for i, e := range list {
+		if _, err := a.db.Exec(`DELETE FROM tokens WHERE id = ?`, e.id); err != nil {
+			return i, err
+		}
+		_ = a.logActorAction(actorSystem, "prune_token", fmt.Sprintf("token_id=%d name=%q target_username=%s expired_at=%s", e.id, e.name, e.username, e.expiresAt))
+	}
+	return len(list), nil
+}
+
+func (a *App) tokenPruneLoop(ctx context.Context) {
+	ticker := time.NewTicker(tokenPruneInterval)
+	defer ticker.Stop()
+	a.health.register(subsystemTokenPrune, tokenPruneInterval, time.Now())
+	for {
+		n, err := a.pruneExpiredTokens(time.Now())
+		if err != nil {
+			a.logger.Printf("event=token_prune_failed err=%v", err)
+		} else if n > 0 {
+			a.logger.Printf("event=tokens_pruned count=%d", n)
+		}
+		a.health.record(subsystemTokenPrune, time.Now(), err)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// apiToken is a token as listed by /api/me/tokens; the hash never leaves the
+// database.
+type apiToken struct {
+	ID         int64  `json:"id"`
+	Name       string `json:"name"`
+	CreatedAt  string `json:"created_at"`
+	LastUsedAt string `json:"last_used_at,omitempty"`
+	LastUsedIP string `json:"last_used_ip,omitempty"`
+	ExpiresAt  string `json:"expires_at,omitempty"`
+	// ExchangedFrom is the token a browser token was exchanged from.
+	ExchangedFrom int64 `json:"exchanged_from,omitempty"`
+	Current       bool  `json:"current"`
+}
+
+func (a *App) listTokens(userID, currentID int64) ([]apiToken, error) {
+	rows, err := a.db.Query(`
+SELECT id, name, created_at, COALESCE(last_used_at, ''), last_used_ip, COALESCE(expires_at, ''), COALESCE(parent_id, 0)
+FROM tokens
+WHERE user_id = ?
+ORDER BY name ASC`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	out := []apiToken{}
+	for rows.Next() {
+		var t apiToken
+		if err := rows.Scan(&t.ID, &t.Name, &t.CreatedAt, &t.LastUsedAt, &t.LastUsedIP, &t.ExpiresAt, &t.ExchangedFrom); err != nil {
+			return nil, err
+		}
+		t.Current = t.ID == currentID
+		out = append(out, t)
+	}
+	return out, rows.Err()
+}
+
+// revokeToken deletes token id of user userID, with the browser tokens
+// exchanged from it, and returns its name.
+func (a *App) revokeToken(userID, id int64) (string, error) {
+	var name string
+	err := a.db.QueryRow(`SELECT name FROM tokens WHERE id = ? AND user_id = ?`, id, userID).Scan(&name)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", errUnknownToken
+	}
+	if err != nil {
+		return "", err
+	}
+	if _, err := a.db.Exec(`DELETE FROM tokens WHERE (id = ? OR parent_id = ?) AND user_id = ?`, id, id, userID); err != nil {
+		return "", err
+	}
+	return name, nil
+}
+
+// tokenWriteRefused reports why the caller may not create, rotate or revoke
+// tokens: the demo's shared token is fixed, an admin impersonating someone
+// should not mint credentials in their name, and a browser token must not
+// be turned into a long-lived one.
+func (a *App) tokenWriteRefused(u AuthedUser) string {
+	if a.demo {
+		return "token management is disabled in demo mode"
+	}
+	if u.ImpersonatedBy != "" {
+		return "token management is not available while impersonating"
+	}
+	if u.Exchanged {
+		return "token management needs a long-lived token, not a browser token"
+	}
+	return ""
+}
+
+// exchangeToken mints a browser token for user userID, exchanged from token
+// parentID and expiring after ttl, and returns its id, name, plaintext and
+// expiry.
+func (a *App) exchangeToken(userID, parentID int64, ttl time.Duration) (int64, string, string, string, error) {
+	token, err := generateToken()
+	if err != nil {
+		return 0, "", "", "", err
+	}
+	suffix, err := randomHex(4)
+	if err != nil {
+		return 0, "", "", "", err
+	}
+	name := browserTokenPrefix + suffix
+	expires := tokenExpiry(ttl)
+	hash, scheme := a.tokenHash(token)
+	res, err := a.db.Exec(`INSERT INTO tokens(user_id, name, token_hash, token_scheme, created_at, expires_at, parent_id) VALUES(?, ?, ?, ?, ?, ?, ?)`,
+		userID, name, hash, scheme, nowUTC(), expires, parentID)
+	if err != nil {
+		return 0, "", "", "", err
+	}
+	id, _ := res.LastInsertId()
+	return id, name, token, expires.String, nil
+}
+
+// handleAuthExchange serves POST /api/auth/exchange {"ttl":"8h"}: the
+// long-lived token the request is made with mints a browser token expiring
+// after ttl (default 8h, at most 24h), returned once. Browser tokens cannot
+// be exchanged again or manage tokens, and go away with their parent.
+func (a *App) handleAuthExchange(w http.ResponseWriter, r *http.Request, u AuthedUser) {
+	if r.Method != http.MethodPost {
+		jsonErr(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	if u.Exchanged {
+		jsonErr(w, http.StatusForbidden, "browser tokens cannot be exchanged; sign in with a long-lived token")
+		return
+	}
+	if u.ImpersonatedBy != "" {
+		jsonErr(w, http.StatusForbidden, "token exchange is not available while impersonating")
+		return
+	}
+	var req struct {
+		TTL string `json:"ttl"`
+	}
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			jsonErr(w, http.StatusBadRequest, "invalid json")
+			return
+		}
+	}
+	ttl := defaultBrowserTokenTTL
+	if req.TTL != "" {
+		d, err := parseTokenTTL(req.TTL)
+		if err != nil {
+			jsonErr(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		if d > maxBrowserTokenTTL {
+			jsonErr(w, http.StatusBadRequest, fmt.Sprintf("ttl must be at most %s", maxBrowserTokenTTL))
+			return
+		}
+		ttl = d
+	}
+	id, name, token, expires, err := a.exchangeToken(u.ID, u.TokenID, ttl)
+	if err != nil {
+		jsonErr(w, http.StatusInternalServerError, "failed to exchange token")
+		return
+	}
+	_ = a.logUserAction(u, "exchange_token", fmt.Sprintf("token_id=%d name=%q parent_token_id=%d expires_at=%s", id, name, u.TokenID, expires))
+	jsonOut(w, http.StatusCreated, map[string]any{"id": id, "name": name, "token": token, "expires_at": expires})
+}
+
+// handleMyTokens serves /api/me/tokens: GET lists the caller's tokens with
+// their last use, POST {"name":"ci","ttl":"90d"} issues a new one and returns
+// it once; ttl is optional.
+func (a *App) handleMyTokens(w http.ResponseWriter, r *http.Request, u AuthedUser) {
+	switch r.Method {
+	case http.MethodGet:
+		tokens, err := a.listTokens(u.ID, u.TokenID)
+		if err != nil {
+			jsonErr(w, http.StatusInternalServerError, "failed to list tokens")
+			return
+		}
+		jsonOut(w, http.StatusOK, map[string]any{"tokens": tokens})
+	case http.MethodPost:
+		if msg := a.tokenWriteRefused(u); msg != "" {
+			jsonErr(w, http.StatusForbidden, msg)
+			return
+		}
+		var req struct {
+			Name string `json:"name"`
+			TTL  string `json:"ttl"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			jsonErr(w, http.StatusBadRequest, "invalid json")
+			return
+		}
+		name, err := checkTokenName(req.Name)
+		if err != nil {
+			jsonErr(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		ttl, err := parseTokenTTL(req.TTL)
+		if err != nil {
+			jsonErr(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		expires := tokenExpiry(ttl)
+		id, token, err := a.issueToken(u.ID, name, expires)
+		if errors.Is(err, errTokenNameTaken) {
+			jsonErr(w, http.StatusConflict, err.Error())
+			return
+		}
+		if err != nil {
+			jsonErr(w, http.StatusInternalServerError, "failed to create token")
+			return
+		}
+		_ = a.logUserAction(u, "create_token", fmt.Sprintf("token_id=%d name=%q expires_at=%s", id, name, expires.String))
+		resp := map[string]any{"id": id, "name": name, "token": token}
+		if expires.Valid {
+			resp["expires_at"] = expires.String
+		}
+		jsonOut(w, http.StatusCreated, resp)
+	default:
+		jsonErr(w, http.StatusMethodNotAllowed, "method not allowed")
+	}
+}
+
+// handleMyToken serves DELETE /api/me/tokens/{id}, revoking one of the
+// caller's tokens; revoking the token in use signs the caller out.
+func (a *App) handleMyToken(w http.ResponseWriter, r *http.Request, u AuthedUser) {
+	if r.Method != http.MethodDelete {
+		jsonErr(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	if msg := a.tokenWriteRefused(u); msg != "" {
+		jsonErr(w, http.StatusForbidden, msg)
+		return
+	}
+	id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil {
+		jsonErr(w, http.StatusBadRequest, "invalid token id")
+		return
+	}
+	name, err := a.revokeToken(u.ID, id)
+	if errors.Is(err, errUnknownToken) {
+		jsonErr(w, http.StatusNotFound, err.Error())
+		return
+	}
+	if err != nil {
+		jsonErr(w, http.StatusInternalServerError, "failed to revoke token")
+		return
+	}
+	_ = a.logUserAction(u, "revoke_token", fmt.Sprintf("token_id=%d name=%q", id, name))
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handleRotateToken serves POST /api/me/token/rotate: the token the request
+// was made with is replaced and the new one returned once. An admin
+// impersonating someone cannot rotate their token this way; 'admin
+// rotate-token' is for that. On a demo instance the shared token is fixed, so
+// rotation is refused.
+func (a *App) handleRotateToken(w http.ResponseWriter, r *http.Request, u AuthedUser) {
+	if r.Method != http.MethodPost {
+		jsonErr(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	if a.demo {
+		jsonErr(w, http.StatusForbidden, "token rotation is disabled in demo mode")
+		return
+	}
+	if u.ImpersonatedBy != "" {
+		jsonErr(w, http.StatusForbidden, "token rotation is not available while impersonating")
+		return
+	}
+	if u.Exchanged {
+		// Rotation renews the lifetime, which would keep a browser token
+		// alive forever.
+		jsonErr(w, http.StatusForbidden, "browser tokens cannot be rotated; exchange a long-lived token again")
+		return
+	}
+	var name string
+	if err := a.db.QueryRow(`SELECT name FROM tokens WHERE id = ?`, u.TokenID).Scan(&name); err != nil {
+		jsonErr(w, http.StatusInternalServerError, "failed to rotate token")
+		return
+	}
+	token, err := a.rotateToken(u.ID, name)
+	if err != nil {
+		jsonErr(w, http.StatusInternalServerError, "failed to rotate token")
+		return
+	}
+	_ = a.logUserAction(u, "rotate_token", fmt.Sprintf("user_id=%d token_id=%d name=%q", u.ID, u.TokenID, name))
+	jsonOut(w, http.StatusOK, map[string]any{"id": u.ID, "username": u.Username, "name": name, "token": token})
+}
+
+func runAdminRotateToken(args []string) error {
+	fs := flag.NewFlagSet("admin rotate-token", flag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s admin rotate-token --username <name> [options]\n\n", binName())
+		fmt.Fprintln(fs.Output(), "Replaces a user's token with a new one; the old token stops working immediately.")
+		fmt.Fprintln(fs.Output(), "A user without a token of that name gets a new one (e.g. after revoking them all).")
+		fmt.Fprintln(fs.Output())
+		fmt.Fprintln(fs.Output(), "Options:")
+		fs.PrintDefaults()
+	}
+	username := fs.String("username", "", "user whose token is replaced")
+	name := fs.String("name", defaultTokenName, "name of the token to replace")
+	tokenPepperFile := fs.String("token-pepper-file", "", "token pepper the server runs with (default <db>.pepper if it exists)")
+	dbPath := fs.String("db", defaultDBPath, "sqlite db path")
+	logPath := fs.String("log", defaultLogPath, "log target path ('-' for stdout only)")
+	if err := fs.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return nil
+		}
+		return err
+	}
+	if strings.TrimSpace(*username) == "" {
+		return errors.New("--username is required")
+	}
+	tokenName, err := checkTokenName(*name)
+	if err != nil {
+		return fmt.Errorf("--name: %w", err)
+	}
+	tokenPepper, err := loadTokenPepper(*tokenPepperFile, *dbPath, false, nil)
+	if err != nil {
+		return err
+	}
+	app, closeApp, err := openAdminApp(*dbPath, *logPath)
+	if err != nil {
+		return err
+	}
+	defer closeApp()
+	app.tokenPepper = tokenPepper
+	if err := app.checkTokenPepper(); err != nil {
+		return err
+	}
+
+	var id int64
+	err = app.db.QueryRow(`SELECT id FROM users WHERE username = ? AND kind = 'human'`, strings.TrimSpace(*username)).Scan(&id)
+	if errors.Is(err, sql.ErrNoRows) {
+		return fmt.Errorf("unknown user %q", *username)
+	}
+	if err != nil {
+		return err
+	}
+	token, err := app.rotateToken(id, tokenName)
+	if err != nil {
+		return err
+	}
+	_ = app.logAction("admin_cli", "admin", "rotate_token", fmt.Sprintf("target_username=%s user_id=%d name=%q", strings.TrimSpace(*username), id, tokenName))
+
+	fmt.Printf("rotated token %q for: %s\n", tokenName, strings.TrimSpace(*username))
+	fmt.Printf("token (save now, cannot be retrieved later): %s\n", token)
+	return nil
+}
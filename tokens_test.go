@@ -0,0 +1,425 @@
+package main
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestTokenPepperMigration(t *testing.T) {
+	app := newTestApp(t)
+	h := newTestMux(app)
+	createUser(t, app, "alice", "PUDPEPPER01")
+
+	app.tokenPepper = []byte("pepper-secret")
+	bob, err := app.provisionUser("bob", "", 0)
+	if err != nil {
+		t.Fatalf("provisionUser: %v", err)
+	}
+	scheme := func(name string) (string, string) {
+		t.Helper()
+		var hash, s string
+		if err := app.db.QueryRow(`SELECT t.token_hash, t.token_scheme FROM tokens t JOIN users u ON u.id = t.user_id WHERE u.username = ?`, name).Scan(&hash, &s); err != nil {
+			t.Fatal(err)
+		}
+		return hash, s
+	}
+	if hash, s := scheme("bob"); s != tokenSchemeHMAC || hash == hashToken(bob.Token) || hash != pepperToken(app.tokenPepper, bob.Token) {
+		t.Fatalf("new token stored as %s %s", s, hash)
+	}
+	if _, s := scheme("alice"); s != tokenSchemeSHA256 {
+		t.Fatalf("legacy row scheme %s", s)
+	}
+
+	// Both schemes authenticate; the legacy row is rehashed on first use.
+	for _, tok := range []string{"PUDPEPPER01", bob.Token, "PUDPEPPER01"} {
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, authedReq(t, http.MethodGet, "/api/me", nil, tok))
+		if rr.Code != http.StatusOK {
+			t.Fatalf("token %s: %d %s", tok, rr.Code, rr.Body.String())
+		}
+	}
+	if hash, s := scheme("alice"); s != tokenSchemeHMAC || hash != pepperToken(app.tokenPepper, "PUDPEPPER01") {
+		t.Fatalf("legacy token not rehashed: %s %s", s, hash)
+	}
+
+	// The unkeyed hash no longer matches, and a wrong pepper locks everyone out.
+	rr := httptest.NewRecorder()
+	req := authedReq(t, http.MethodGet, "/api/me", nil, "PUDPEPPER01")
+	app.tokenPepper = []byte("other-pepper")
+	h.ServeHTTP(rr, req)
+	if rr.Code != http.StatusUnauthorized {
+		t.Fatalf("wrong pepper: %d", rr.Code)
+	}
+
+	app.tokenPepper = nil
+	if err := app.checkTokenPepper(); !errors.Is(err, errPepperRequired) {
+		t.Fatalf("checkTokenPepper without pepper: %v", err)
+	}
+
+	// The first start with a pepper records its fingerprint; another one is refused.
+	app.tokenPepper = []byte("pepper-secret")
+	if err := app.checkTokenPepper(); err != nil {
+		t.Fatalf("checkTokenPepper: %v", err)
+	}
+	app.tokenPepper = []byte("other-pepper")
+	if err := app.checkTokenPepper(); !errors.Is(err, errPepperMismatch) {
+		t.Fatalf("checkTokenPepper with another pepper: %v", err)
+	}
+	app.tokenPepper = []byte("pepper-secret")
+	if err := app.checkTokenPepper(); err != nil {
+		t.Fatalf("checkTokenPepper again: %v", err)
+	}
+}
+
+func TestLoadTokenPepper(t *testing.T) {
+	dbPath := filepath.Join(t.TempDir(), "devlog.db")
+	var logs bytes.Buffer
+	logger := log.New(&logs, "", 0)
+	if pepper, err := loadTokenPepper("", dbPath, false, logger); err != nil || pepper != nil {
+		t.Fatalf("admin without a pepper file = %x, %v", pepper, err)
+	}
+	first, err := loadTokenPepper("", dbPath, true, logger)
+	if err != nil || len(first) == 0 || !strings.Contains(logs.String(), "event=token_pepper_generated") {
+		t.Fatalf("generate = %x, %v; logs %q", first, err, logs.String())
+	}
+	for _, generate := range []bool{true, false} {
+		if again, err := loadTokenPepper("", dbPath, generate, logger); err != nil || !bytes.Equal(first, again) {
+			t.Fatalf("reload (generate=%v) = %x, %v; want %x", generate, again, err, first)
+		}
+	}
+	if _, err := loadTokenPepper(filepath.Join(t.TempDir(), "missing"), dbPath, true, logger); err == nil {
+		t.Fatal("an explicit pepper file that does not exist must not be generated")
+	}
+}
+
+func TestCheckTokenPepperWarnsWithoutPepper(t *testing.T) {
+	app := newTestApp(t)
+	var logs bytes.Buffer
+	app.logger = log.New(&logs, "", 0)
+	createUser(t, app, "alice", "PUDPEPPER02")
+	if err := app.checkTokenPepper(); err != nil || !strings.Contains(logs.String(), "event=token_pepper_missing") {
+		t.Fatalf("checkTokenPepper = %v; logs %q", err, logs.String())
+	}
+}
+
+func TestRotateToken(t *testing.T) {
+	app := newTestApp(t)
+	h := newTestMux(app)
+	createUser(t, app, "alice", "PUDROTATE01")
+	createUser(t, app, "bob", "PUDROTATE02")
+
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, authedReq(t, http.MethodPost, "/api/me/token/rotate", nil, "PUDROTATE01"))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("rotate: %d %s", rr.Code, rr.Body.String())
+	}
+	var got struct {
+		Username string `json:"username"`
+		Token    string `json:"token"`
+	}
+	if err := json.Unmarshal(rr.Body.Bytes(), &got); err != nil {
+		t.Fatal(err)
+	}
+	if got.Username != "alice" || len(got.Token) != tokenTotalLen || got.Token == "PUDROTATE01" {
+		t.Fatalf("rotate response: %+v", got)
+	}
+	for tok, want := range map[string]int{"PUDROTATE01": http.StatusUnauthorized, got.Token: http.StatusOK, "PUDROTATE02": http.StatusOK} {
+		rr = httptest.NewRecorder()
+		h.ServeHTTP(rr, authedReq(t, http.MethodGet, "/api/me", nil, tok))
+		if rr.Code != want {
+			t.Fatalf("token %s after rotation: %d, want %d", tok, rr.Code, want)
+		}
+	}
+	var meta string
+	if err := app.db.QueryRow(`SELECT metadata FROM action_logs WHERE action = 'rotate_token' AND actor_username = 'alice'`).Scan(&meta); err != nil || strings.Contains(meta, got.Token) {
+		t.Fatalf("rotate_token audit row: %q %v", meta, err)
+	}
+
+	rr = httptest.NewRecorder()
+	h.ServeHTTP(rr, authedReq(t, http.MethodGet, "/api/me/token/rotate", nil, got.Token))
+	if rr.Code != http.StatusMethodNotAllowed {
+		t.Fatalf("GET rotate: %d", rr.Code)
+	}
+	app.demo = true
+	rr = httptest.NewRecorder()
+	h.ServeHTTP(rr, authedReq(t, http.MethodPost, "/api/me/token/rotate", nil, got.Token))
+	if rr.Code != http.StatusForbidden {
+		t.Fatalf("rotate in demo mode: %d", rr.Code)
+	}
+}
+
+func TestMultipleTokens(t *testing.T) {
+	app := newTestApp(t)
+	h := newTestMux(app)
+	createUser(t, app, "alice", "PUDDEVICE01")
+	createUser(t, app, "bob", "PUDDEVICE02")
+	do := func(method, path string, body any, token string) *httptest.ResponseRecorder {
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, authedReq(t, method, path, body, token))
+		return rr
+	}
+
+	rr := do(http.MethodPost, "/api/me/tokens", map[string]string{"name": " ci "}, "PUDDEVICE01")
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("create: %d %s", rr.Code, rr.Body.String())
+	}
+	var ci struct {
+		ID    int64  `json:"id"`
+		Name  string `json:"name"`
+		Token string `json:"token"`
+	}
+	if err := json.Unmarshal(rr.Body.Bytes(), &ci); err != nil || ci.Name != "ci" || len(ci.Token) != tokenTotalLen {
+		t.Fatalf("create response: %+v %v", ci, err)
+	}
+	if rr := do(http.MethodPost, "/api/me/tokens", map[string]string{"name": "ci"}, "PUDDEVICE01"); rr.Code != http.StatusConflict {
+		t.Fatalf("duplicate name: %d", rr.Code)
+	}
+	if rr := do(http.MethodPost, "/api/me/tokens", map[string]string{"name": ""}, "PUDDEVICE01"); rr.Code != http.StatusBadRequest {
+		t.Fatalf("empty name: %d", rr.Code)
+	}
+	if rr := do(http.MethodGet, "/api/me", nil, ci.Token); rr.Code != http.StatusOK || !strings.Contains(rr.Body.String(), `"username":"alice"`) {
+		t.Fatalf("ci token: %d %s", rr.Code, rr.Body.String())
+	}
+
+	rr = do(http.MethodGet, "/api/me/tokens", nil, ci.Token)
+	var list struct {
+		Tokens []apiToken `json:"tokens"`
+	}
+	if err := json.Unmarshal(rr.Body.Bytes(), &list); err != nil || len(list.Tokens) != 2 {
+		t.Fatalf("list: %s %v", rr.Body.String(), err)
+	}
+	for _, tok := range list.Tokens {
+		if tok.LastUsedAt == "" || tok.Current != (tok.Name == "ci") {
+			t.Fatalf("listed token: %+v", tok)
+		}
+	}
+	if strings.Contains(rr.Body.String(), "hash") {
+		t.Fatalf("list leaks hashes: %s", rr.Body.String())
+	}
+
+	// Tokens are revoked one at a time and only by their owner.
+	if rr := do(http.MethodDelete, "/api/me/tokens/"+strconv.FormatInt(ci.ID, 10), nil, "PUDDEVICE02"); rr.Code != http.StatusNotFound {
+		t.Fatalf("revoke someone else's token: %d", rr.Code)
+	}
+	if rr := do(http.MethodDelete, "/api/me/tokens/"+strconv.FormatInt(ci.ID, 10), nil, "PUDDEVICE01"); rr.Code != http.StatusNoContent {
+		t.Fatalf("revoke: %d %s", rr.Code, rr.Body.String())
+	}
+	for tok, want := range map[string]int{ci.Token: http.StatusUnauthorized, "PUDDEVICE01": http.StatusOK} {
+		if rr := do(http.MethodGet, "/api/me", nil, tok); rr.Code != want {
+			t.Fatalf("token %s after revoke: %d, want %d", tok, rr.Code, want)
+		}
+	}
+
+}
+
+// Databases from releases that kept the token on users rows get it moved to
+// tokens and lose users.token_hash, keeping ids and foreign keys intact.
+func TestLegacyUserTokensMigrate(t *testing.T) {
+	db, err := openDB(filepath.Join(t.TempDir(), "legacy.db"))
+	if err != nil {
+		t.Fatalf("openDB: %v", err)
+	}
+	t.Cleanup(func() { _ = db.Close() })
+	if _, err := db.Exec(`
+CREATE TABLE users (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	username TEXT NOT NULL UNIQUE,
+	token_hash TEXT NOT NULL UNIQUE,
+	created_at TEXT NOT NULL
+);
+INSERT INTO users(username, token_hash, created_at) VALUES('carol', ?, '2025-01-02T09:00:00Z');
+INSERT INTO users(username, token_hash, created_at) VALUES('dave', '!tokens:dave', '2025-01-02T09:00:00Z');
+DELETE FROM users WHERE username = 'dave';`, hashToken("PUDDEVICE03")); err != nil {
+		t.Fatal(err)
+	}
+	app := &App{db: db, logger: log.New(io.Discard, "", 0)}
+	if err := app.initSchema(); err != nil {
+		t.Fatalf("initSchema: %v", err)
+	}
+	var legacy int
+	if err := db.QueryRow(`SELECT COUNT(*) FROM pragma_table_info('users') WHERE name = 'token_hash'`).Scan(&legacy); err != nil || legacy != 0 {
+		t.Fatalf("users.token_hash still present: %d err=%v", legacy, err)
+	}
+	var name string
+	if err := db.QueryRow(`SELECT t.name FROM tokens t JOIN users u ON u.id = t.user_id WHERE u.username = 'carol' AND u.id = 1`).Scan(&name); err != nil || name != defaultTokenName {
+		t.Fatalf("migrated token: %q err=%v", name, err)
+	}
+	var fk int
+	if err := db.QueryRow(`PRAGMA foreign_keys`).Scan(&fk); err != nil || fk != 1 {
+		t.Fatalf("foreign keys left off: %d err=%v", fk, err)
+	}
+	h := newTestMux(app)
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, authedReq(t, http.MethodGet, "/api/me", nil, "PUDDEVICE03"))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("migrated token: %d", rr.Code)
+	}
+	id, err := app.insertHumanUser("erin", roleMember, "PUDDEVICE04", sql.NullString{})
+	if err != nil || id != 3 {
+		t.Fatalf("insertHumanUser after rebuild: id=%d err=%v", id, err)
+	}
+	if err := app.initSchema(); err != nil {
+		t.Fatalf("second initSchema: %v", err)
+	}
+}
+
+func TestTokenExpiry(t *testing.T) {
+	app := newTestApp(t)
+	h := newTestMux(app)
+	me := func(token string) int {
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, authedReq(t, http.MethodGet, "/api/me", nil, token))
+		return rr.Code
+	}
+	nu, err := app.provisionUser("alice", "", 90*24*time.Hour)
+	if err != nil {
+		t.Fatalf("provisionUser: %v", err)
+	}
+	if exp, err := time.Parse(time.RFC3339, nu.ExpiresAt); err != nil || exp.Sub(time.Now()) < 89*24*time.Hour {
+		t.Fatalf("expires_at %q %v", nu.ExpiresAt, err)
+	}
+	if code := me(nu.Token); code != http.StatusOK {
+		t.Fatalf("fresh token: %d", code)
+	}
+
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, authedReq(t, http.MethodPost, "/api/me/tokens", map[string]string{"name": "ci", "ttl": "2w"}, nu.Token))
+	var ci struct {
+		Token     string `json:"token"`
+		ExpiresAt string `json:"expires_at"`
+	}
+	if err := json.Unmarshal(rr.Body.Bytes(), &ci); rr.Code != http.StatusCreated || err != nil || ci.ExpiresAt == "" {
+		t.Fatalf("create with ttl: %d %s", rr.Code, rr.Body.String())
+	}
+	rr = httptest.NewRecorder()
+	h.ServeHTTP(rr, authedReq(t, http.MethodPost, "/api/me/tokens", map[string]string{"name": "phone", "ttl": "soon"}, nu.Token))
+	if rr.Code != http.StatusBadRequest {
+		t.Fatalf("bad ttl: %d", rr.Code)
+	}
+
+	// Rotation keeps the lifetime: created now, expiring 14 days from now.
+	if _, err := app.db.Exec(`UPDATE tokens SET created_at = '2026-01-01T00:00:00Z', expires_at = '2026-01-15T00:00:00Z' WHERE name = 'ci'`); err != nil {
+		t.Fatal(err)
+	}
+	if code := me(ci.Token); code != http.StatusUnauthorized {
+		t.Fatalf("expired token: %d", code)
+	}
+	if _, err := app.rotateToken(nu.ID, "ci"); err != nil {
+		t.Fatalf("rotate: %v", err)
+	}
+	var created, expires string
+	if err := app.db.QueryRow(`SELECT created_at, expires_at FROM tokens WHERE name = 'ci'`).Scan(&created, &expires); err != nil {
+		t.Fatal(err)
+	}
+	c, _ := time.Parse(time.RFC3339, created)
+	e, _ := time.Parse(time.RFC3339, expires)
+	if e.Sub(c) != 14*24*time.Hour || time.Since(c) > time.Minute {
+		t.Fatalf("rotated lifetime: %s..%s", created, expires)
+	}
+
+	if _, err := app.db.Exec(`UPDATE tokens SET expires_at = '2026-01-15T00:00:00Z' WHERE name = 'ci'`); err != nil {
+		t.Fatal(err)
+	}
+	if n, err := app.pruneExpiredTokens(time.Now()); err != nil || n != 1 {
+		t.Fatalf("prune: %d %v", n, err)
+	}
+	var left int
+	if err := app.db.QueryRow(`SELECT COUNT(*) FROM tokens WHERE user_id = ?`, nu.ID).Scan(&left); err != nil || left != 1 {
+		t.Fatalf("tokens left: %d %v", left, err)
+	}
+	var meta string
+	if err := app.db.QueryRow(`SELECT metadata FROM action_logs WHERE action = 'prune_token' AND actor_username = 'system'`).Scan(&meta); err != nil || !strings.Contains(meta, `name="ci"`) {
+		t.Fatalf("prune audit: %q %v", meta, err)
+	}
+
+	if _, err := parseTokenTTL("0h"); err == nil {
+		t.Fatal("zero ttl accepted")
+	}
+	if d, err := parseTokenTTL("12w"); err != nil || d != 84*24*time.Hour {
+		t.Fatalf("12w: %s %v", d, err)
+	}
+}
+
+func TestAuthExchange(t *testing.T) {
+	app := newTestApp(t)
+	h := newTestMux(app)
+	createUser(t, app, "alice", "PUDEXCHANGE")
+	do := func(method, path string, body any, token string) *httptest.ResponseRecorder {
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, authedReq(t, method, path, body, token))
+		return rr
+	}
+	rr := do(http.MethodPost, "/api/auth/exchange", nil, "PUDEXCHANGE")
+	var browser struct {
+		ID        int64  `json:"id"`
+		Name      string `json:"name"`
+		Token     string `json:"token"`
+		ExpiresAt string `json:"expires_at"`
+	}
+	if err := json.Unmarshal(rr.Body.Bytes(), &browser); err != nil || rr.Code != http.StatusCreated || browser.Token == "" || !strings.HasPrefix(browser.Name, browserTokenPrefix) {
+		t.Fatalf("exchange: %d %s", rr.Code, rr.Body.String())
+	}
+	if exp, err := time.Parse(time.RFC3339, browser.ExpiresAt); err != nil || exp.Sub(time.Now()) > defaultBrowserTokenTTL || exp.Sub(time.Now()) < defaultBrowserTokenTTL-time.Minute {
+		t.Fatalf("expires_at %q %v", browser.ExpiresAt, err)
+	}
+	if rr := do(http.MethodGet, "/api/entries", nil, browser.Token); rr.Code != http.StatusOK {
+		t.Fatalf("browser token read: %d", rr.Code)
+	}
+	for _, tc := range []struct {
+		method, path string
+		body         any
+		token        string
+		want         int
+	}{
+		{http.MethodPost, "/api/auth/exchange", nil, browser.Token, http.StatusForbidden},
+		{http.MethodPost, "/api/me/tokens", map[string]string{"name": "forever"}, browser.Token, http.StatusForbidden},
+		{http.MethodPost, "/api/me/token/rotate", nil, browser.Token, http.StatusForbidden},
+		{http.MethodPost, "/api/auth/exchange", map[string]string{"ttl": "30d"}, "PUDEXCHANGE", http.StatusBadRequest},
+		{http.MethodPost, "/api/auth/exchange", map[string]string{"ttl": "1h"}, "PUDEXCHANGE", http.StatusCreated},
+	} {
+		if rr := do(tc.method, tc.path, tc.body, tc.token); rr.Code != tc.want {
+			t.Fatalf("%s %s: got %d want %d: %s", tc.method, tc.path, rr.Code, tc.want, rr.Body.String())
+		}
+	}
+
+	rr = do(http.MethodGet, "/api/me/tokens", nil, "PUDEXCHANGE")
+	var list struct {
+		Tokens []apiToken `json:"tokens"`
+	}
+	_ = json.Unmarshal(rr.Body.Bytes(), &list)
+	var parent int64
+	exchanged := 0
+	for _, tk := range list.Tokens {
+		if tk.Current {
+			parent = tk.ID
+		}
+		if tk.ExchangedFrom != 0 {
+			exchanged++
+		}
+	}
+	if parent == 0 || exchanged != 2 {
+		t.Fatalf("tokens: %+v", list.Tokens)
+	}
+	// Revoking the long-lived token takes its browser tokens with it.
+	var aliceID int64
+	if err := app.db.QueryRow(`SELECT id FROM users WHERE username = 'alice'`).Scan(&aliceID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := app.revokeToken(aliceID, parent); err != nil {
+		t.Fatalf("revokeToken: %v", err)
+	}
+	if rr := do(http.MethodGet, "/api/entries", nil, browser.Token); rr.Code != http.StatusUnauthorized {
+		t.Fatalf("browser token after parent revoked: %d", rr.Code)
+	}
+}
@@ -0,0 +1,204 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// defaultTrashDays is how long a deleted entry stays restorable.
+const defaultTrashDays = 30
+
+type trashedEntry struct {
+	ID        int64  `json:"id"`
+	Content   string `json:"content"`
+	CreatedAt string `json:"created_at"`
+	DeletedAt string `json:"deleted_at"`
+	PurgeAt   string `json:"purge_at"`
+}
+
+// trashRetention returns how long trashed entries are kept.
+func (a *App) trashRetention() time.Duration {
+	days := a.trashDays
+	if days <= 0 {
+		days = defaultTrashDays
+	}
+	return time.Duration(days) * 24 * time.Hour
+}
+
+// ownEntry loads the owner, type and creation time of a live or trashed
+// entry for the trash and restore endpoints.
+func (a *App) ownEntry(id int64) (userID int64, entryType, createdAt string, deletedAt sql.NullString, err error) {
+	err = a.db.QueryRow(`SELECT user_id, entry_type, created_at, deleted_at FROM entries WHERE id = ?`, id).Scan(&userID, &entryType, &createdAt, &deletedAt)
+	return userID, entryType, createdAt, deletedAt, err
+}
+
+// handleTrashEntry soft-deletes one of the caller's own entries, or anyone's
+// for an entries.moderate holder.
+func (a *App) handleTrashEntry(w http.ResponseWriter, u AuthedUser, id int64) {
+	owner, entryType, createdAt, deletedAt, err := a.ownEntry(id)
+	if errors.Is(err, sql.ErrNoRows) || (err == nil && deletedAt.Valid) {
+		jsonErr(w, http.StatusNotFound, "entry not found")
+		return
+	}
+	if err != nil {
+		jsonErr(w, http.StatusInternalServerError, "failed to query entry")
+		return
+	}
+	if entryType != "normal" || (owner != u.ID && !a.canModerate(u)) {
+		jsonErr(w, http.StatusForbidden, "only the author can delete this entry")
+		return
+	}
+	now := time.Now().UTC()
+	if err := a.checkMutable(createdAt, now); err != nil {
+		writeMutableErr(w, err)
+		return
+	}
+	if _, err := a.db.Exec(`UPDATE entries SET deleted_at = ? WHERE id = ? AND deleted_at IS NULL`, now.Format(time.RFC3339), id); err != nil {
+		jsonErr(w, http.StatusInternalServerError, "failed to delete entry")
+		return
+	}
+	purgeAt := now.Add(a.trashRetention()).Format(time.RFC3339)
+	meta := fmt.Sprintf("entry_id=%d purge_at=%s", id, purgeAt)
+	if owner != u.ID {
+		meta += fmt.Sprintf(" owner_id=%d", owner)
+	}
+	_ = a.logUserAction(u, "trash_entry", meta)
+	jsonOut(w, http.StatusOK, map[string]any{"id": id, "status": "trashed", "purge_at": purgeAt})
+}
+
+// handleRestoreEntry takes one of the caller's entries back out of the trash.
+func (a *App) handleRestoreEntry(w http.ResponseWriter, r *http.Request, u AuthedUser) {
+	if r.Method != http.MethodPost {
+		jsonErr(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil || id <= 0 {
+		jsonErr(w, http.StatusBadRequest, "invalid entry id")
+		return
+	}
+	owner, _, _, deletedAt, err := a.ownEntry(id)
+	if errors.Is(err, sql.ErrNoRows) || (err == nil && (!deletedAt.Valid || owner != u.ID)) {
+		jsonErr(w, http.StatusNotFound, "entry not in trash")
+		return
+	}
+	if err != nil {
+		jsonErr(w, http.StatusInternalServerError, "failed to query entry")
+		return
+	}
+	if _, err := a.db.Exec(`UPDATE entries SET deleted_at = NULL WHERE id = ?`, id); err != nil {
+		jsonErr(w, http.StatusInternalServerError, "failed to restore entry")
+		return
+	}
+	_ = a.logUserAction(u, "restore_entry", fmt.Sprintf("entry_id=%d", id))
+	jsonOut(w, http.StatusOK, map[string]any{"id": id, "status": "restored"})
+}
+
+// handleTrash lists the caller's trashed entries, newest deletion first.
+func (a *App) handleTrash(w http.ResponseWriter, r *http.Request, u AuthedUser) {
+	if r.Method != http.MethodGet {
+		jsonErr(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	rows, err := a.db.Query(`
+SELECT id, content, created_at, deleted_at
+FROM entries
+WHERE user_id = ? AND deleted_at IS NOT NULL
+ORDER BY deleted_at DESC, id DESC`, u.ID)
+	if err != nil {
+		jsonErr(w, http.StatusInternalServerError, "failed to query trash")
+		return
+	}
+	defer rows.Close()
+	out := []trashedEntry{}
+	for rows.Next() {
+		var e trashedEntry
+		if err := rows.Scan(&e.ID, &e.Content, &e.CreatedAt, &e.DeletedAt); err != nil {
+			jsonErr(w, http.StatusInternalServerError, "failed to parse trash")
+			return
+		}
+		if t, err := time.Parse(time.RFC3339, e.DeletedAt); err == nil {
+			e.PurgeAt = t.Add(a.trashRetention()).Format(time.RFC3339)
+		}
+		out = append(out, e)
+	}
+	_ = a.logUserAction(u, "list_trash", fmt.Sprintf("count=%d", len(out)))
+	jsonOut(w, http.StatusOK, map[string]any{"entries": out, "retention_days": int(a.trashRetention().Hours() / 24)})
+}
+
+// purgeTrash permanently deletes entries trashed before now minus the
+// retention. Entries on days under legal hold are kept. Every purge gets its
+// own action log row.
+func (a *App) purgeTrash(now time.Time) (int, error) {
+	cutoff := now.UTC().Add(-a.trashRetention()).Format(time.RFC3339)
+	rows, err := a.db.Query(`
+SELECT e.id, u.username, date(e.created_at), e.deleted_at
+FROM entries e
+JOIN users u ON u.id = e.user_id
+WHERE e.deleted_at IS NOT NULL AND e.deleted_at < ?
+ORDER BY e.id ASC`, cutoff)
+	if err != nil {
+		return 0, err
+	}
+	type victim struct {
+		id                   int64
+		user, day, deletedAt string
+	}
+	var victims []victim
+	for rows.Next() {
+		var v victim
+		if err := rows.Scan(&v.id, &v.user, &v.day, &v.deletedAt); err != nil {
+			_ = rows.Close()
+			return 0, err
+		}
+		victims = append(victims, v)
+	}
+	if err := rows.Err(); err != nil {
+		_ = rows.Close()
+		return 0, err
+	}
+	_ = rows.Close()
+
+	purged := 0
+	for _, v := range victims {
+		held, err := a.dayOnHold(v.day)
+		if err != nil {
+			return purged, err
+		}
+		if held {
+			continue
+		}
+		if _, err := a.db.Exec(`DELETE FROM entries WHERE id = ? AND deleted_at IS NOT NULL`, v.id); err != nil {
+			return purged, err
+		}
+		purged++
+		_ = a.logActorAction(actorScheduler, "purge_entry", fmt.Sprintf("entry_id=%d user=%s day=%s deleted_at=%s", v.id, v.user, v.day, v.deletedAt))
+	}
+	return purged, nil
+}
+
+// trashPurgeLoop purges expired trash once at startup and then hourly.
+func (a *App) trashPurgeLoop(ctx context.Context) {
+	ticker := time.NewTicker(time.Hour)
+	defer ticker.Stop()
+	a.health.register(subsystemTrashPurge, time.Hour, time.Now())
+	for {
+		n, err := a.purgeTrash(time.Now())
+		if err != nil {
+			a.logger.Printf("event=trash_purge_failed err=%v", err)
+		} else if n > 0 {
+			a.logger.Printf("event=trash_purged count=%d", n)
+		}
+		a.health.record(subsystemTrashPurge, time.Now(), err)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAPITrashRestoreAndPurge(t *testing.T) {
+	app := newTestApp(t)
+	h := newTestMux(app)
+	createUser(t, app, "alice", "PUDABCDEF12")
+	createUser(t, app, "bob", "PUDBOBBOB12")
+
+	post := func(content string) int64 {
+		t.Helper()
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, authedReq(t, http.MethodPost, "/api/entries", map[string]string{"content": content}, "PUDABCDEF12"))
+		var out struct {
+			ID int64 `json:"id"`
+		}
+		if rr.Code != http.StatusCreated || json.Unmarshal(rr.Body.Bytes(), &out) != nil {
+			t.Fatalf("create entry: %d %s", rr.Code, rr.Body.String())
+		}
+		return out.ID
+	}
+	do := func(method, path, token string) *httptest.ResponseRecorder {
+		t.Helper()
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, authedReq(t, method, path, nil, token))
+		return rr
+	}
+	id := post("wrong channel, sorry")
+	path := fmt.Sprintf("/api/entries/%d", id)
+
+	if rr := do(http.MethodDelete, path, "PUDBOBBOB12"); rr.Code != http.StatusForbidden {
+		t.Fatalf("expected 403 deleting someone else's entry, got %d", rr.Code)
+	}
+	if rr := do(http.MethodDelete, path, "PUDABCDEF12"); rr.Code != http.StatusOK {
+		t.Fatalf("expected 200 trashing entry, got %d %s", rr.Code, rr.Body.String())
+	}
+	if rr := do(http.MethodGet, path, "PUDABCDEF12"); rr.Code != http.StatusNotFound {
+		t.Fatalf("expected trashed entry to be hidden, got %d", rr.Code)
+	}
+	if rr := do(http.MethodGet, "/api/entries", "PUDABCDEF12"); strings.Contains(rr.Body.String(), "wrong channel") {
+		t.Fatalf("trashed entry still listed: %s", rr.Body.String())
+	}
+	rr := do(http.MethodGet, "/api/trash", "PUDABCDEF12")
+	if rr.Code != http.StatusOK || !strings.Contains(rr.Body.String(), "wrong channel") || !strings.Contains(rr.Body.String(), "purge_at") {
+		t.Fatalf("expected entry in author's trash, got %d %s", rr.Code, rr.Body.String())
+	}
+	if rr := do(http.MethodGet, "/api/trash", "PUDBOBBOB12"); strings.Contains(rr.Body.String(), "wrong channel") {
+		t.Fatalf("trash visible to another user: %s", rr.Body.String())
+	}
+	if rr := do(http.MethodPost, path+"/restore", "PUDBOBBOB12"); rr.Code != http.StatusNotFound {
+		t.Fatalf("expected 404 restoring someone else's entry, got %d", rr.Code)
+	}
+	if rr := do(http.MethodPost, path+"/restore", "PUDABCDEF12"); rr.Code != http.StatusOK {
+		t.Fatalf("expected 200 restoring entry, got %d", rr.Code)
+	}
+	if rr := do(http.MethodGet, path, "PUDABCDEF12"); rr.Code != http.StatusOK {
+		t.Fatalf("expected restored entry to be visible, got %d", rr.Code)
+	}
+
+	// Trash it again alongside an entry on a held day, then purge after retention.
+	if rr := do(http.MethodDelete, path, "PUDABCDEF12"); rr.Code != http.StatusOK {
+		t.Fatalf("trash again: %d", rr.Code)
+	}
+	if _, err := app.db.Exec(`INSERT INTO entries(user_id, entry_type, content, created_at, deleted_at) VALUES(1, 'normal', 'held', '2026-01-05T10:00:00Z', ?)`, nowUTC()); err != nil {
+		t.Fatalf("insert held entry: %v", err)
+	}
+	if _, err := app.db.Exec(`INSERT INTO legal_holds(start_day, end_day, reason, created_at) VALUES('2026-01-01', '2026-01-31', 'incident', ?)`, nowUTC()); err != nil {
+		t.Fatalf("insert hold: %v", err)
+	}
+	if n, err := app.purgeTrash(time.Now()); err != nil || n != 0 {
+		t.Fatalf("expected nothing purged before retention, n=%d err=%v", n, err)
+	}
+	n, err := app.purgeTrash(time.Now().Add(app.trashRetention() + time.Hour))
+	if err != nil || n != 1 {
+		t.Fatalf("expected 1 purged entry, n=%d err=%v", n, err)
+	}
+	var left, logged int
+	_ = app.db.QueryRow(`SELECT COUNT(*) FROM entries WHERE deleted_at IS NOT NULL`).Scan(&left)
+	_ = app.db.QueryRow(`SELECT COUNT(*) FROM action_logs WHERE action = 'purge_entry' AND actor_username = 'scheduler'`).Scan(&logged)
+	if left != 1 || logged != 1 {
+		t.Fatalf("expected held entry kept and one purge log row, left=%d logged=%d", left, logged)
+	}
+}
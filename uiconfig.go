@@ -0,0 +1,68 @@
+package main
+
+import "net/http"
+
+// UI feature flags: one embedded UI serves every deployment, so it asks
+// GET /api/ui-config what this server supports instead of assuming it.
+// Features follow serve's configuration (--store for attachments,
+// --share-key-file for share links, --backup-dir for the admin pages,
+// --markdown for rendered entry content) and, where a feature needs a
+// permission, the caller's role, so the UI hides what would only 403.
+
+type uiFeatures struct {
+	Search      bool `json:"search"`
+	Attachments bool `json:"attachments"`
+	ShareLinks  bool `json:"share_links"`
+	AdminPanel  bool `json:"admin_panel"`
+	Markdown    bool `json:"markdown"`
+	Calendar    bool `json:"calendar"`
+	// Approval is set for callers whose entries wait for a moderator.
+	Approval bool `json:"approval"`
+}
+
+type uiConfig struct {
+	Features           uiFeatures `json:"features"`
+	SearchProvider     string     `json:"search_provider"`
+	Anonymize          string     `json:"anonymize"`
+	MaxEntrySize       int        `json:"max_entry_size"`
+	AllowedCategories  []string   `json:"allowed_categories"`
+	AttachmentMaxBytes int64      `json:"attachment_max_bytes,omitempty"`
+	Demo               bool       `json:"demo,omitempty"`
+}
+
+// uiConfigFor returns the UI configuration as seen by u.
+func (a *App) uiConfigFor(u AuthedUser) uiConfig {
+	s := a.settings()
+	c := uiConfig{
+		Features: uiFeatures{
+			Search:      a.policy.allows(u.Role, actionEntriesRead),
+			Attachments: a.blobs != nil && a.policy.allows(u.Role, actionEntriesWrite),
+			ShareLinks:  len(a.shareKey) > 0 && a.policy.allows(u.Role, actionShareCreate),
+			AdminPanel:  a.backupDir != "" && a.policy.allows(u.Role, actionBackups),
+			Markdown:    a.markdown,
+			Calendar:    a.calendar != nil,
+			Approval:    needsApproval(u),
+		},
+		SearchProvider:    a.searcher().Name(),
+		Anonymize:         a.anonymizeMode,
+		MaxEntrySize:      s.MaxEntrySize,
+		AllowedCategories: s.AllowedCategories,
+		Demo:              a.demo,
+	}
+	if c.Anonymize == "" {
+		c.Anonymize = anonymizeOff
+	}
+	if c.Features.Attachments {
+		c.AttachmentMaxBytes = a.attachmentMaxBytes
+	}
+	return c
+}
+
+// handleUIConfig serves GET /api/ui-config.
+func (a *App) handleUIConfig(w http.ResponseWriter, r *http.Request, u AuthedUser) {
+	if r.Method != http.MethodGet {
+		jsonErr(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	jsonOut(w, http.StatusOK, a.uiConfigFor(u))
+}
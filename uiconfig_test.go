@@ -0,0 +1,64 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestUIConfig(t *testing.T) {
+	app := newTestApp(t)
+	h := newTestMux(app)
+	createUser(t, app, "root", "PUDUICONF01")
+	createUser(t, app, "intern", "PUDUICONF02")
+	if _, err := app.db.Exec(`UPDATE users SET role = 'admin' WHERE username = 'root'`); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := app.db.Exec(`UPDATE users SET role = ? WHERE username = 'intern'`, roleContributor); err != nil {
+		t.Fatal(err)
+	}
+	get := func(token string) uiConfig {
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, authedReq(t, http.MethodGet, "/api/ui-config", nil, token))
+		var c uiConfig
+		if rr.Code != http.StatusOK || json.Unmarshal(rr.Body.Bytes(), &c) != nil {
+			t.Fatalf("ui-config: %d %s", rr.Code, rr.Body.String())
+		}
+		return c
+	}
+
+	c := get("PUDUICONF01")
+	if !c.Features.Search || c.Features.Attachments || c.Features.ShareLinks || c.Features.AdminPanel || c.Features.Markdown || c.Features.Approval {
+		t.Fatalf("default features: %+v", c.Features)
+	}
+	if c.SearchProvider != searchSQLite || c.Anonymize != anonymizeOff || c.MaxEntrySize != defaultMaxEntrySize {
+		t.Fatalf("default config: %+v", c)
+	}
+
+	app.blobs = &FSBlobStore{Root: t.TempDir()}
+	app.attachmentMaxBytes = 1 << 20
+	app.shareKey = []byte("share-key")
+	app.backupDir = t.TempDir()
+	app.markdown = true
+	c = get("PUDUICONF01")
+	if !c.Features.Attachments || !c.Features.ShareLinks || !c.Features.AdminPanel || !c.Features.Markdown || c.AttachmentMaxBytes != 1<<20 {
+		t.Fatalf("configured features for admin: %+v", c)
+	}
+	// Permission-bound features follow the caller's role.
+	c = get("PUDUICONF02")
+	if c.Features.ShareLinks || c.Features.AdminPanel || !c.Features.Approval || !c.Features.Markdown || !c.Features.Attachments {
+		t.Fatalf("features for contributor: %+v", c.Features)
+	}
+
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, authedReq(t, http.MethodPost, "/api/ui-config", nil, "PUDUICONF01"))
+	if rr.Code != http.StatusMethodNotAllowed {
+		t.Fatalf("POST ui-config: %d", rr.Code)
+	}
+	rr = httptest.NewRecorder()
+	h.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/api/ui-config", nil))
+	if rr.Code != http.StatusUnauthorized {
+		t.Fatalf("anonymous ui-config: %d", rr.Code)
+	}
+}
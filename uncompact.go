@@ -0,0 +1,450 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"flag"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// Un-compaction puts a compacted day back the way it was before compaction:
+// the original entries are restored from entries_archive when the day was
+// compacted in archive mode, else from the compact's compact_data (or its
+// text for compacts older than that column), reusing the original entry ids
+// so links and deep links keep working. Issue refs, cross-links and
+// attachments move from the compact back to the restored entries, then the
+// compact (every per-author compact with compact_grouping per_user) and the
+// day's compactions row are removed and the journal records
+// "uncompacted". The day compacts again at the next scheduled run for it or
+// with 'admin compact'.
+
+const journalUncompacted = "uncompacted"
+
+var (
+	errNotCompacted      = errors.New("day is not compacted")
+	errCompactionPending = errors.New("day's compaction is not finished")
+)
+
+// uncompactResult reports what uncompactDay restored.
+type uncompactResult struct {
+	Day       string `json:"day"`
+	CompactID int64  `json:"compact_id,omitempty"`
+	Restored  int    `json:"restored"`
+	// Compacts is how many compacts were removed (one per author when the
+	// day was compacted with compact_grouping per_user).
+	Compacts int `json:"compacts"`
+	// Source is "archive", "compact_data" or "text" (compacts that predate
+	// compact_data; their restored content keeps the rendered issue labels).
+	Source string `json:"source"`
+	// AttachmentsGuessed counts attachments of compacts that did not record
+	// their owner; they go to the day's first restored entry.
+	AttachmentsGuessed int `json:"attachments_guessed"`
+}
+
+// restoredEntry is one original entry to put back.
+type restoredEntry struct {
+	oldID       int64
+	userID      int64
+	content     string
+	category    string
+	source      string
+	createdAt   string
+	editedAt    sql.NullString
+	attachments []string
+	newID       int64
+}
+
+// uncompactDay restores day's original entries and removes its compact.
+func (a *App) uncompactDay(day string) (uncompactResult, error) {
+	res := uncompactResult{Day: day}
+	held, err := a.dayOnHold(day)
+	if err != nil {
+		return res, err
+	}
+	if held {
+		return res, errDayOnHold
+	}
+
+	a.compactMu.Lock()
+	defer a.compactMu.Unlock()
+	tx, err := a.db.Begin()
+	if err != nil {
+		return res, err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	var phase string
+	var compactID, rollupID sql.NullInt64
+	var merged int
+	err = tx.QueryRow(`SELECT phase, compact_id, merged_count, rollup_id FROM compactions WHERE day = ?`, day).Scan(&phase, &compactID, &merged, &rollupID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return res, errNotCompacted
+	}
+	if err != nil {
+		return res, err
+	}
+	if phase != compactionDone {
+		return res, errCompactionPending
+	}
+	if rollupID.Valid {
+		return res, errDayRolledUp
+	}
+	if !compactID.Valid && merged > 0 {
+		// Compactions rows from older releases did not record the compact.
+		if compactID, err = legacyCompactID(tx, day); err != nil {
+			return res, err
+		}
+	}
+
+	var restored []restoredEntry
+	var compactIDs []int64
+	if compactID.Valid {
+		res.CompactID = compactID.Int64
+		parts, err := dayCompactParts(tx, day, compactID.Int64)
+		if err != nil {
+			return res, err
+		}
+		for _, p := range parts {
+			part, source, err := restoreSources(tx, p.CompactID)
+			if err != nil {
+				return res, err
+			}
+			restored = append(restored, part...)
+			res.Source = source
+			compactIDs = append(compactIDs, p.CompactID)
+		}
+	}
+
+	for i := range restored {
+		e := &restored[i]
+		var taken int
+		if e.oldID > 0 {
+			if err := tx.QueryRow(`SELECT COUNT(*) FROM entries WHERE id = ?`, e.oldID).Scan(&taken); err != nil {
+				return res, err
+			}
+		}
+		var id any
+		if e.oldID > 0 && taken == 0 {
+			id = e.oldID
+		}
+		r, err := tx.Exec(`INSERT INTO entries(id, user_id, entry_type, content, category, source, created_at, edited_at) VALUES(?, ?, 'normal', ?, ?, ?, ?, ?)`,
+			id, e.userID, e.content, e.category, e.source, e.createdAt, e.editedAt)
+		if err != nil {
+			return res, err
+		}
+		if e.newID, err = r.LastInsertId(); err != nil {
+			return res, err
+		}
+	}
+	if len(compactIDs) > 0 {
+		guessed, err := moveCompactRefs(tx, compactIDs, restored)
+		if err != nil {
+			return res, err
+		}
+		res.AttachmentsGuessed = guessed
+	}
+	for _, id := range compactIDs {
+		if _, err := tx.Exec(`DELETE FROM entries WHERE id = ? AND entry_type = 'daily_compact'`, id); err != nil {
+			return res, err
+		}
+		if _, err := tx.Exec(`DELETE FROM entries_archive WHERE compact_id = ?`, id); err != nil {
+			return res, err
+		}
+	}
+	if _, err := tx.Exec(`DELETE FROM compactions WHERE day = ?`, day); err != nil {
+		return res, err
+	}
+	res.Restored, res.Compacts = len(restored), len(compactIDs)
+	if err := journalCompaction(tx, day, journalUncompacted, fmt.Sprintf("compact_id=%d removed (%d compacts), %d source entries restored from %s", res.CompactID, res.Compacts, res.Restored, res.Source)); err != nil {
+		return res, err
+	}
+	if err := tx.Commit(); err != nil {
+		return res, err
+	}
+	a.logger.Printf("event=uncompacted day=%s compact_id=%d restored=%d source=%s", day, res.CompactID, res.Restored, res.Source)
+	return res, nil
+}
+
+// restoreSources reads the entries a compact replaced, from entries_archive
+// when it has them, else from the compact itself.
+func restoreSources(tx *sql.Tx, compactID int64) ([]restoredEntry, string, error) {
+	var content string
+	var data sql.NullString
+	err := tx.QueryRow(`SELECT content, compact_data FROM entries WHERE id = ? AND entry_type = 'daily_compact'`, compactID).Scan(&content, &data)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, "", fmt.Errorf("compact %d is missing", compactID)
+	}
+	if err != nil {
+		return nil, "", err
+	}
+	sources := compactSources(data, content)
+	attachments := map[int64][]string{}
+	for _, s := range sources {
+		attachments[s.EntryID] = s.Attachments
+	}
+
+	rows, err := tx.Query(`
+SELECT id, COALESCE(user_id, 0), content, category, source, created_at, edited_at
+FROM entries_archive WHERE compact_id = ? ORDER BY created_at ASC, id ASC`, compactID)
+	if err != nil {
+		return nil, "", err
+	}
+	var out []restoredEntry
+	for rows.Next() {
+		var e restoredEntry
+		if err := rows.Scan(&e.oldID, &e.userID, &e.content, &e.category, &e.source, &e.createdAt, &e.editedAt); err != nil {
+			_ = rows.Close()
+			return nil, "", err
+		}
+		e.attachments = attachments[e.oldID]
+		out = append(out, e)
+	}
+	if err := rows.Err(); err != nil {
+		_ = rows.Close()
+		return nil, "", err
+	}
+	_ = rows.Close()
+	if len(out) > 0 {
+		return out, "archive", nil
+	}
+
+	source := "compact_data"
+	if !data.Valid {
+		source = "text"
+	}
+	userIDs := map[string]int64{}
+	for _, s := range sources {
+		id, ok := userIDs[s.User]
+		if !ok {
+			err := tx.QueryRow(`SELECT id FROM users WHERE username = ?`, s.User).Scan(&id)
+			if errors.Is(err, sql.ErrNoRows) {
+				return nil, "", fmt.Errorf("compact %d names unknown user %q", compactID, s.User)
+			}
+			if err != nil {
+				return nil, "", err
+			}
+			userIDs[s.User] = id
+		}
+		out = append(out, restoredEntry{oldID: s.EntryID, userID: id, content: s.Content, source: s.Source, createdAt: s.CreatedAt, attachments: s.Attachments})
+	}
+	return out, source, nil
+}
+
+// moveCompactRefs gives the restored entries back the issue refs,
+// cross-links and attachments finishCompaction moved onto the compact.
+// Issue refs and outgoing links follow the restored content; links into the
+// compact follow the [[entry:N]] in their source. It returns how many
+// attachments had no recorded owner.
+func moveCompactRefs(tx *sql.Tx, compactIDs []int64, restored []restoredEntry) (int, error) {
+	if len(restored) == 0 {
+		return 0, nil
+	}
+	in := placeholders(len(compactIDs))
+	ids := make([]any, 0, len(compactIDs))
+	for _, id := range compactIDs {
+		ids = append(ids, id)
+	}
+	byOldID := map[int64]int64{}
+	for _, e := range restored {
+		if e.oldID > 0 {
+			byOldID[e.oldID] = e.newID
+		}
+	}
+
+	keys := map[string]bool{}
+	rows, err := tx.Query(`SELECT DISTINCT issue_key FROM issue_refs WHERE entry_id IN (`+in+`)`, ids...)
+	if err != nil {
+		return 0, err
+	}
+	for rows.Next() {
+		var k string
+		if err := rows.Scan(&k); err != nil {
+			_ = rows.Close()
+			return 0, err
+		}
+		keys[k] = true
+	}
+	if err := rows.Err(); err != nil {
+		_ = rows.Close()
+		return 0, err
+	}
+	_ = rows.Close()
+	for _, e := range restored {
+		for _, k := range extractIssueKeys(e.content, nil) {
+			if !keys[k] {
+				continue
+			}
+			if _, err := tx.Exec(`INSERT OR IGNORE INTO issue_refs(entry_id, issue_key) VALUES(?, ?)`, e.newID, k); err != nil {
+				return 0, err
+			}
+		}
+	}
+
+	for _, e := range restored {
+		for _, target := range parseEntryLinks(e.content) {
+			if id, ok := byOldID[target]; ok {
+				target = id
+			}
+			if target == e.newID {
+				continue
+			}
+			if _, err := tx.Exec(`
+INSERT OR IGNORE INTO entry_links(source_id, target_id, created_at)
+SELECT ?, id, ? FROM entries WHERE id = ? AND deleted_at IS NULL AND entry_type = 'normal'`, e.newID, nowUTC(), target); err != nil {
+				return 0, err
+			}
+		}
+	}
+	type incoming struct {
+		source  int64
+		content string
+	}
+	var into []incoming
+	rows, err = tx.Query(`SELECT e.id, e.content FROM entry_links l JOIN entries e ON e.id = l.source_id WHERE l.target_id IN (`+in+`)`, ids...)
+	if err != nil {
+		return 0, err
+	}
+	for rows.Next() {
+		var in incoming
+		if err := rows.Scan(&in.source, &in.content); err != nil {
+			_ = rows.Close()
+			return 0, err
+		}
+		into = append(into, in)
+	}
+	if err := rows.Err(); err != nil {
+		_ = rows.Close()
+		return 0, err
+	}
+	_ = rows.Close()
+	for _, in := range into {
+		for _, target := range parseEntryLinks(in.content) {
+			id, ok := byOldID[target]
+			if !ok || id == in.source {
+				continue
+			}
+			if _, err := tx.Exec(`INSERT OR IGNORE INTO entry_links(source_id, target_id, created_at) VALUES(?, ?, ?)`, in.source, id, nowUTC()); err != nil {
+				return 0, err
+			}
+		}
+	}
+
+	owner := map[string]int64{}
+	for _, e := range restored {
+		for _, sha := range e.attachments {
+			if _, ok := owner[sha]; !ok {
+				owner[sha] = e.newID
+			}
+		}
+	}
+	type attachment struct {
+		compactID int64
+		sha       string
+	}
+	var held []attachment
+	rows, err = tx.Query(`SELECT entry_id, sha256 FROM entry_attachments WHERE entry_id IN (`+in+`)`, ids...)
+	if err != nil {
+		return 0, err
+	}
+	for rows.Next() {
+		var at attachment
+		if err := rows.Scan(&at.compactID, &at.sha); err != nil {
+			_ = rows.Close()
+			return 0, err
+		}
+		held = append(held, at)
+	}
+	if err := rows.Err(); err != nil {
+		_ = rows.Close()
+		return 0, err
+	}
+	_ = rows.Close()
+	guessed := 0
+	for _, at := range held {
+		id, ok := owner[at.sha]
+		if !ok {
+			id = restored[0].newID
+			guessed++
+		}
+		if _, err := tx.Exec(`UPDATE OR IGNORE entry_attachments SET entry_id = ? WHERE entry_id = ? AND sha256 = ?`, id, at.compactID, at.sha); err != nil {
+			return 0, err
+		}
+	}
+	return guessed, nil
+}
+
+func uncompactMeta(res uncompactResult) string {
+	return fmt.Sprintf("day=%s compact_id=%d compacts=%d restored=%d source=%s attachments_guessed=%d", res.Day, res.CompactID, res.Compacts, res.Restored, res.Source, res.AttachmentsGuessed)
+}
+
+// handleAdminUncompact serves POST /api/admin/uncompact?day=YYYY-MM-DD.
+func (a *App) handleAdminUncompact(w http.ResponseWriter, r *http.Request, u AuthedUser) {
+	if r.Method != http.MethodPost {
+		jsonErr(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	day := strings.TrimSpace(r.URL.Query().Get("day"))
+	if err := a.checkCompactDay(day); err != nil {
+		jsonErr(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	res, err := a.uncompactDay(day)
+	switch {
+	case errors.Is(err, errNotCompacted):
+		jsonErr(w, http.StatusNotFound, err.Error())
+		return
+	case errors.Is(err, errDayOnHold), errors.Is(err, errCompactionPending), errors.Is(err, errDayRolledUp):
+		jsonErr(w, http.StatusConflict, err.Error())
+		return
+	case err != nil:
+		a.logger.Printf("event=uncompact_failed day=%s err=%v", day, err)
+		jsonErr(w, http.StatusInternalServerError, "failed to restore day")
+		return
+	}
+	_ = a.logUserAction(u, "uncompact_day", uncompactMeta(res))
+	jsonOut(w, http.StatusOK, map[string]any{"result": res, "url": a.dayURL(day)})
+}
+
+func runAdminUncompact(args []string) error {
+	fs := flag.NewFlagSet("admin uncompact", flag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s admin uncompact --day YYYY-MM-DD [options]\n\n", binName())
+		fmt.Fprintln(fs.Output(), "Restores a compacted day's original entries (from entries_archive or the")
+		fmt.Fprintln(fs.Output(), "compact) and removes its compact, e.g. after compaction fired too early.")
+		fmt.Fprintln(fs.Output(), "Days under legal hold or rolled up into a weekly or monthly compact are")
+		fmt.Fprintln(fs.Output(), "refused.")
+		fmt.Fprintln(fs.Output())
+		fmt.Fprintln(fs.Output(), "Options:")
+		fs.PrintDefaults()
+	}
+	day := fs.String("day", "", "day to restore (YYYY-MM-DD)")
+	dbPath := fs.String("db", defaultDBPath, "sqlite db path")
+	logPath := fs.String("log", defaultLogPath, "log target path ('-' for stdout only)")
+	if err := fs.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return nil
+		}
+		return err
+	}
+
+	app, closeApp, err := openAdminApp(*dbPath, *logPath)
+	if err != nil {
+		return err
+	}
+	defer closeApp()
+	if err := app.checkCompactDay(*day); err != nil {
+		return fmt.Errorf("--%w", err)
+	}
+	res, err := app.uncompactDay(*day)
+	if err != nil {
+		return fmt.Errorf("%s: %w", *day, err)
+	}
+	_ = app.logAction("admin_cli", "admin", "uncompact_day", uncompactMeta(res))
+	fmt.Printf("restored %s: %d entries from %s, %d compact(s) removed\n", res.Day, res.Restored, res.Source, res.Compacts)
+	if res.AttachmentsGuessed > 0 {
+		fmt.Printf("%d attachment(s) had no recorded owner and were given to the first restored entry\n", res.AttachmentsGuessed)
+	}
+	return nil
+}
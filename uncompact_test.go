@@ -0,0 +1,106 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestUncompactDay(t *testing.T) {
+	app := newTestApp(t)
+	h := newTestMux(app)
+	createUser(t, app, "alice", "PUDUNCOMPAL1")
+	createUser(t, app, "root", "PUDUNCOMPRO1")
+	if _, err := app.db.Exec(`UPDATE users SET role = 'admin' WHERE username = 'root'`); err != nil {
+		t.Fatal(err)
+	}
+	var ids []int64
+	for _, content := range []string{"fixed OPS-7 rollout", "paired on the parser"} {
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, authedReq(t, http.MethodPost, "/api/entries", map[string]string{"content": content}, "PUDUNCOMPAL1"))
+		if rr.Code != http.StatusCreated {
+			t.Fatalf("post: %d %s", rr.Code, rr.Body.String())
+		}
+		var e entryRow
+		_ = json.Unmarshal(rr.Body.Bytes(), &e)
+		ids = append(ids, e.ID)
+	}
+	if _, err := app.db.Exec(`INSERT INTO issue_refs(entry_id, issue_key) VALUES(?, 'OPS-7')`, ids[0]); err != nil {
+		t.Fatal(err)
+	}
+	day := time.Now().UTC().Format("2006-01-02")
+	uncompact := func(token string) *httptest.ResponseRecorder {
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, authedReq(t, http.MethodPost, "/api/admin/uncompact?day="+day, nil, token))
+		return rr
+	}
+	if rr := uncompact("PUDUNCOMPRO1"); rr.Code != http.StatusNotFound {
+		t.Fatalf("expected 404 before compaction, got %d %s", rr.Code, rr.Body.String())
+	}
+	check := func(source string) {
+		t.Helper()
+		rows, err := app.db.Query(`SELECT id, content FROM entries WHERE entry_type = 'normal' ORDER BY id`)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got []string
+		for rows.Next() {
+			var id int64
+			var content string
+			_ = rows.Scan(&id, &content)
+			got = append(got, fmt.Sprintf("%d:%s", id, content))
+		}
+		rows.Close()
+		want := []string{fmt.Sprintf("%d:fixed OPS-7 rollout", ids[0]), fmt.Sprintf("%d:paired on the parser", ids[1])}
+		if strings.Join(got, "|") != strings.Join(want, "|") {
+			t.Fatalf("%s: restored %v, want %v", source, got, want)
+		}
+		var compacts, marker, refs int
+		_ = app.db.QueryRow(`SELECT COUNT(*) FROM entries WHERE entry_type = 'daily_compact'`).Scan(&compacts)
+		_ = app.db.QueryRow(`SELECT COUNT(*) FROM compactions WHERE day = ?`, day).Scan(&marker)
+		_ = app.db.QueryRow(`SELECT COUNT(*) FROM issue_refs WHERE entry_id = ? AND issue_key = 'OPS-7'`, ids[0]).Scan(&refs)
+		if compacts != 0 || marker != 0 || refs != 1 {
+			t.Fatalf("%s: compacts=%d compactions=%d refs=%d", source, compacts, marker, refs)
+		}
+	}
+
+	if err := app.compactDay(day); err != nil {
+		t.Fatalf("compactDay: %v", err)
+	}
+	if rr := uncompact("PUDUNCOMPAL1"); rr.Code != http.StatusForbidden {
+		t.Fatalf("expected 403 for member, got %d", rr.Code)
+	}
+	rr := uncompact("PUDUNCOMPRO1")
+	var body struct {
+		Result uncompactResult `json:"result"`
+	}
+	if err := json.Unmarshal(rr.Body.Bytes(), &body); err != nil || rr.Code != http.StatusOK || body.Result.Restored != 2 || body.Result.Source != "compact_data" {
+		t.Fatalf("uncompact: %d %s", rr.Code, rr.Body.String())
+	}
+	check("compact_data")
+
+	mode := compactionModeArchive
+	if _, _, err := app.updateSettings(settingsPatch{CompactionMode: &mode}, "admin"); err != nil {
+		t.Fatalf("updateSettings: %v", err)
+	}
+	if err := app.compactDay(day); err != nil {
+		t.Fatalf("compactDay: %v", err)
+	}
+	res, err := app.uncompactDay(day)
+	if err != nil || res.Source != "archive" || res.Restored != 2 {
+		t.Fatalf("uncompactDay: %+v err=%v", res, err)
+	}
+	check("archive")
+	var archived int
+	if err := app.db.QueryRow(`SELECT COUNT(*) FROM entries_archive`).Scan(&archived); err != nil || archived != 0 {
+		t.Fatalf("expected archive rows removed, got %d err=%v", archived, err)
+	}
+	var detail string
+	if err := app.db.QueryRow(`SELECT detail FROM compaction_journal WHERE day = ? AND step = ? ORDER BY id DESC LIMIT 1`, day, journalUncompacted).Scan(&detail); err != nil || !strings.Contains(detail, "restored from archive") {
+		t.Fatalf("journal: %q err=%v", detail, err)
+	}
+}
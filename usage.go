@@ -0,0 +1,205 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// usageReport is the capacity-planning summary printed by 'admin usage'.
+type usageReport struct {
+	Since           string     `json:"since"`
+	Until           string     `json:"until"`
+	UsersTotal      int        `json:"users_total"`
+	ActiveUsers     int        `json:"active_users"`
+	EntriesCreated  int        `json:"entries_created"`
+	LiveEntries     int        `json:"live_entries"`
+	ContentBytes    int64      `json:"content_bytes"`
+	WindowBytes     int64      `json:"window_content_bytes"`
+	DBBytes         int64      `json:"db_bytes"`
+	DBFreeBytes     int64      `json:"db_free_bytes"`
+	AttachmentBytes int64      `json:"attachment_bytes"`
+	Days            []usageDay `json:"days"`
+}
+
+// usageDay is one day of live entries and content bytes from entry_counts.
+type usageDay struct {
+	Day     string `json:"day"`
+	Entries int    `json:"entries"`
+	Bytes   int64  `json:"bytes"`
+}
+
+// parseSince accepts a day (YYYY-MM-DD), a day/week count (30d, 4w) or a Go
+// duration (36h) and returns the start of the window ending at now.
+func parseSince(s string, now time.Time) (time.Time, error) {
+	s = strings.TrimSpace(s)
+	if t, err := time.Parse("2006-01-02", s); err == nil {
+		return t, nil
+	}
+	if n := len(s); n > 1 && (s[n-1] == 'd' || s[n-1] == 'w') {
+		count, err := strconv.Atoi(s[:n-1])
+		if err == nil && count > 0 {
+			if s[n-1] == 'w' {
+				count *= 7
+			}
+			return now.AddDate(0, 0, -count), nil
+		}
+	}
+	if d, err := time.ParseDuration(s); err == nil && d > 0 {
+		return now.Add(-d), nil
+	}
+	return time.Time{}, fmt.Errorf("invalid --since %q (want e.g. 30d, 4w, 72h or YYYY-MM-DD)", s)
+}
+
+// usage builds the report for [since, now]. Entry creation and activity come
+// from the action log, which compaction does not rewrite; live entries and
+// bytes come from entry_counts.
+func (a *App) usage(since, now time.Time) (usageReport, error) {
+	rep := usageReport{Since: since.UTC().Format(time.RFC3339), Until: now.UTC().Format(time.RFC3339), Days: []usageDay{}}
+	if err := a.db.QueryRow(`SELECT COUNT(*) FROM users WHERE kind = ?`, kindHuman).Scan(&rep.UsersTotal); err != nil {
+		return rep, err
+	}
+	if err := a.db.QueryRow(`
+SELECT COUNT(DISTINCT actor_username), COUNT(*)
+FROM action_logs
+WHERE actor_type = 'api_user' AND action IN ('create_entry', 'queue_entry') AND created_at >= ? AND created_at <= ?`,
+		rep.Since, rep.Until).Scan(&rep.ActiveUsers, &rep.EntriesCreated); err != nil {
+		return rep, err
+	}
+	if err := a.db.QueryRow(`SELECT COALESCE(SUM(count), 0), COALESCE(SUM(bytes), 0) FROM entry_counts`).Scan(&rep.LiveEntries, &rep.ContentBytes); err != nil {
+		return rep, err
+	}
+	var pages, pageSize, freePages int64
+	if err := a.db.QueryRow(`PRAGMA page_count`).Scan(&pages); err != nil {
+		return rep, err
+	}
+	if err := a.db.QueryRow(`PRAGMA page_size`).Scan(&pageSize); err != nil {
+		return rep, err
+	}
+	if err := a.db.QueryRow(`PRAGMA freelist_count`).Scan(&freePages); err != nil {
+		return rep, err
+	}
+	rep.DBBytes, rep.DBFreeBytes = pages*pageSize, freePages*pageSize
+	if err := a.db.QueryRow(`SELECT COALESCE(SUM(size), 0) FROM blobs`).Scan(&rep.AttachmentBytes); err != nil {
+		return rep, err
+	}
+
+	rows, err := a.db.Query(`
+SELECT day, SUM(count), SUM(bytes) FROM entry_counts
+WHERE day >= ? AND day <= ?
+GROUP BY day HAVING SUM(count) > 0
+ORDER BY day ASC`, since.UTC().Format("2006-01-02"), now.UTC().Format("2006-01-02"))
+	if err != nil {
+		return rep, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var d usageDay
+		if err := rows.Scan(&d.Day, &d.Entries, &d.Bytes); err != nil {
+			return rep, err
+		}
+		rep.WindowBytes += d.Bytes
+		rep.Days = append(rep.Days, d)
+	}
+	return rep, rows.Err()
+}
+
+// writeOpenMetrics renders rep in the OpenMetrics text format.
+func (rep usageReport) writeOpenMetrics(w io.Writer) {
+	gauge := func(name, help, unit string, v float64) {
+		fmt.Fprintf(w, "# TYPE %s gauge\n", name)
+		if unit != "" {
+			fmt.Fprintf(w, "# UNIT %s %s\n", name, unit)
+		}
+		fmt.Fprintf(w, "# HELP %s %s\n", name, help)
+		fmt.Fprintf(w, "%s %g\n", name, v)
+	}
+	gauge("devlog_usage_users", "Human user accounts.", "", float64(rep.UsersTotal))
+	gauge("devlog_usage_active_users", "Users who posted at least one entry in the window.", "", float64(rep.ActiveUsers))
+	gauge("devlog_usage_window_entries", "Entries posted in the window.", "", float64(rep.EntriesCreated))
+	gauge("devlog_usage_live_entries", "Live entries (compacts count once per day).", "", float64(rep.LiveEntries))
+	gauge("devlog_usage_content_bytes", "Content bytes of live entries.", "bytes", float64(rep.ContentBytes))
+	gauge("devlog_usage_window_content_bytes", "Content bytes of live entries on days in the window.", "bytes", float64(rep.WindowBytes))
+	gauge("devlog_usage_db_bytes", "SQLite database file size.", "bytes", float64(rep.DBBytes))
+	gauge("devlog_usage_db_free_bytes", "Reclaimable free pages in the database file.", "bytes", float64(rep.DBFreeBytes))
+	gauge("devlog_usage_attachment_bytes", "Stored attachment blob bytes.", "bytes", float64(rep.AttachmentBytes))
+	if len(rep.Days) > 0 {
+		fmt.Fprintln(w, "# TYPE devlog_usage_day_entries gauge")
+		fmt.Fprintln(w, "# HELP devlog_usage_day_entries Live entries per day.")
+		for _, d := range rep.Days {
+			fmt.Fprintf(w, "devlog_usage_day_entries{day=%q} %d\n", d.Day, d.Entries)
+		}
+		fmt.Fprintln(w, "# TYPE devlog_usage_day_content_bytes gauge")
+		fmt.Fprintln(w, "# UNIT devlog_usage_day_content_bytes bytes")
+		fmt.Fprintln(w, "# HELP devlog_usage_day_content_bytes Content bytes of live entries per day.")
+		for _, d := range rep.Days {
+			fmt.Fprintf(w, "devlog_usage_day_content_bytes{day=%q} %d\n", d.Day, d.Bytes)
+		}
+	}
+	fmt.Fprintln(w, "# EOF")
+}
+
+func runAdminUsage(args []string) error {
+	fs := flag.NewFlagSet("admin usage", flag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s admin usage [--since 30d] [--format json|openmetrics] [options]\n\n", binName())
+		fmt.Fprintln(fs.Output(), "Prints usage statistics for capacity planning: active users, entries posted,")
+		fmt.Fprintln(fs.Output(), "live entries and bytes per day, database and attachment size.")
+		fmt.Fprintln(fs.Output())
+		fmt.Fprintln(fs.Output(), "Options:")
+		fs.PrintDefaults()
+	}
+	sinceRaw := fs.String("since", "30d", "window start: 30d, 4w, 72h or YYYY-MM-DD")
+	format := fs.String("format", "json", "output format: json or openmetrics")
+	out := fs.String("out", "", "write the report to this file instead of stdout")
+	dbPath := fs.String("db", defaultDBPath, "sqlite db path")
+	logPath := fs.String("log", defaultLogPath, "log target path ('-' for stdout only)")
+	if err := fs.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return nil
+		}
+		return err
+	}
+	if *format != "json" && *format != "openmetrics" {
+		return errors.New("--format must be json or openmetrics")
+	}
+	now := time.Now()
+	since, err := parseSince(*sinceRaw, now)
+	if err != nil {
+		return err
+	}
+
+	app, closeApp, err := openAdminApp(*dbPath, *logPath)
+	if err != nil {
+		return err
+	}
+	defer closeApp()
+
+	rep, err := app.usage(since, now)
+	if err != nil {
+		return err
+	}
+	_ = app.logAction("admin_cli", "admin", "usage_report", fmt.Sprintf("since=%s format=%s", rep.Since, *format))
+	var w io.Writer = os.Stdout
+	if *out != "" {
+		f, err := os.Create(*out)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		w = f
+	}
+	if *format == "openmetrics" {
+		rep.writeOpenMetrics(w)
+		return nil
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(rep)
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAdminUsageReport(t *testing.T) {
+	app := newTestApp(t)
+	h := newTestMux(app)
+	createUser(t, app, "alice", "PUDUSAGEAL1")
+	createUser(t, app, "bob", "PUDUSAGEBO1")
+	for _, content := range []string{"first", "second"} {
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, authedReq(t, http.MethodPost, "/api/entries", map[string]string{"content": content}, "PUDUSAGEAL1"))
+		if rr.Code != http.StatusCreated {
+			t.Fatalf("create: %d", rr.Code)
+		}
+	}
+
+	now := time.Now()
+	since, err := parseSince("30d", now)
+	if err != nil || !since.Equal(now.AddDate(0, 0, -30)) {
+		t.Fatalf("parseSince(30d) = %v, %v", since, err)
+	}
+	if _, err := parseSince("soon", now); err == nil {
+		t.Fatal("expected invalid --since to fail")
+	}
+	rep, err := app.usage(since, now)
+	if err != nil {
+		t.Fatalf("usage: %v", err)
+	}
+	if rep.UsersTotal != 2 || rep.ActiveUsers != 1 || rep.EntriesCreated != 2 || rep.LiveEntries != 2 {
+		t.Fatalf("unexpected usage report: %+v", rep)
+	}
+	if rep.ContentBytes != 11 || rep.WindowBytes != 11 || len(rep.Days) != 1 || rep.DBBytes <= 0 {
+		t.Fatalf("unexpected usage sizes: %+v", rep)
+	}
+
+	var buf bytes.Buffer
+	rep.writeOpenMetrics(&buf)
+	out := buf.String()
+	for _, want := range []string{
+		"# TYPE devlog_usage_active_users gauge\n",
+		"devlog_usage_active_users 1\n",
+		"# UNIT devlog_usage_db_bytes bytes\n",
+		`devlog_usage_day_entries{day="` + rep.Days[0].Day + `"} 2`,
+	} {
+		if !strings.Contains(out, want) {
+			t.Fatalf("openmetrics output missing %q:\n%s", want, out)
+		}
+	}
+	if !strings.HasSuffix(out, "# EOF\n") {
+		t.Fatalf("openmetrics output must end with # EOF:\n%s", out)
+	}
+}
@@ -0,0 +1,260 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+var (
+	errUsernameTaken = errors.New("username already exists")
+	errUnknownUser   = errors.New("unknown user")
+)
+
+// newUser is a freshly provisioned account. Token is the only copy of the
+// plaintext token; the database keeps its hash.
+type newUser struct {
+	ID       int64  `json:"id"`
+	Username string `json:"username"`
+	Role     string `json:"role"`
+	Token    string `json:"token,omitempty"`
+	// ExpiresAt is set when the token was created with a ttl.
+	ExpiresAt string `json:"expires_at,omitempty"`
+	// ClaimURL replaces Token when the user was created with a claim link
+	// (withClaimLink); ClaimExpiresAt is when the link stops working.
+	ClaimURL       string `json:"claim_url,omitempty"`
+	ClaimExpiresAt string `json:"claim_expires_at,omitempty"`
+	claimID        int64
+}
+
+// checkNewUser validates a username and role for provisionUser and returns
+// the trimmed username and effective role; an empty role means member.
+func (a *App) checkNewUser(username, role string) (string, string, error) {
+	username = strings.TrimSpace(username)
+	if username == "" {
+		return "", "", errors.New("username is required")
+	}
+	if role == "" {
+		role = roleMember
+	}
+	if !a.policy.hasRole(role) {
+		return "", "", fmt.Errorf("role must be one of %s", strings.Join(a.policy.roles(), ", "))
+	}
+	if isReservedUsername(username) {
+		return "", "", fmt.Errorf("username %q is reserved for a system identity", username)
+	}
+	return username, role, nil
+}
+
+// provisionUser creates a human user with a generated token that expires
+// after ttl (0 for never). It backs both 'admin create-user' and
+// POST /api/admin/users.
+func (a *App) provisionUser(username, role string, ttl time.Duration) (newUser, error) {
+	username, role, err := a.checkNewUser(username, role)
+	if err != nil {
+		return newUser{}, err
+	}
+	var n int
+	if err := a.db.QueryRow(`SELECT COUNT(*) FROM users WHERE username = ?`, username).Scan(&n); err != nil {
+		return newUser{}, err
+	}
+	if n > 0 {
+		return newUser{}, errUsernameTaken
+	}
+	token, err := generateToken()
+	if err != nil {
+		return newUser{}, err
+	}
+	expires := tokenExpiry(ttl)
+	id, err := a.insertHumanUser(username, role, token, expires)
+	if err != nil {
+		return newUser{}, err
+	}
+	return newUser{ID: id, Username: username, Role: role, Token: token, ExpiresAt: expires.String}, nil
+}
+
+// withClaimLink swaps nu's token for a one-time claim link valid for ttl.
+// The token itself is dropped: claiming mints a fresh one, whose expiry the
+// claim reports.
+func (a *App) withClaimLink(nu newUser, createdBy string, ttl time.Duration) (newUser, error) {
+	id, link, expires, err := a.createClaimLink(nu.ID, createdBy, ttl)
+	if err != nil {
+		return nu, err
+	}
+	nu.Token, nu.ExpiresAt = "", ""
+	nu.ClaimURL, nu.ClaimExpiresAt, nu.claimID = link, expires, id
+	return nu, nil
+}
+
+// createUserMeta is the audit metadata of a create_user row.
+func (nu newUser) createUserMeta() string {
+	meta := fmt.Sprintf("target_username=%s user_id=%d role=%s expires_at=%s", nu.Username, nu.ID, nu.Role, nu.ExpiresAt)
+	if nu.claimID != 0 {
+		meta += fmt.Sprintf(" claim_id=%d claim_expires_at=%s", nu.claimID, nu.ClaimExpiresAt)
+	}
+	return meta
+}
+
+// handleAdminUsers serves POST /api/admin/users: it creates a user and
+// returns the generated token once, like 'admin create-user'.
+func (a *App) handleAdminUsers(w http.ResponseWriter, r *http.Request, u AuthedUser) {
+	if r.Method != http.MethodPost {
+		jsonErr(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	var req struct {
+		Username  string `json:"username"`
+		Role      string `json:"role"`
+		TTL       string `json:"ttl"`
+		ClaimLink bool   `json:"claim_link"`
+		ClaimTTL  string `json:"claim_ttl"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		jsonErr(w, http.StatusBadRequest, "invalid json")
+		return
+	}
+	if _, _, err := a.checkNewUser(req.Username, strings.TrimSpace(req.Role)); err != nil {
+		jsonErr(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	ttl, err := parseTokenTTL(req.TTL)
+	if err != nil {
+		jsonErr(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	claimTTL, err := parseClaimTTL(req.ClaimTTL)
+	if err != nil {
+		jsonErr(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	nu, err := a.provisionUser(req.Username, strings.TrimSpace(req.Role), ttl)
+	if errors.Is(err, errUsernameTaken) {
+		jsonErr(w, http.StatusConflict, err.Error())
+		return
+	}
+	if err != nil {
+		jsonErr(w, http.StatusInternalServerError, "failed to create user")
+		return
+	}
+	if req.ClaimLink {
+		if nu, err = a.withClaimLink(nu, u.Username, claimTTL); err != nil {
+			jsonErr(w, http.StatusInternalServerError, "failed to create claim link")
+			return
+		}
+	}
+	_ = a.logUserAction(u, "create_user", nu.createUserMeta())
+	jsonOut(w, http.StatusCreated, nu)
+}
+
+// setUserDisabled disables or re-enables human user username. A disabled
+// account keeps its tokens and data, but lookupToken, impersonation and
+// resolveIdentity no longer find it, so every way in is closed at once.
+func (a *App) setUserDisabled(username string, disabled bool) (int64, error) {
+	var id int64
+	err := a.db.QueryRow(`SELECT id FROM users WHERE username = ? AND kind = 'human'`, strings.TrimSpace(username)).Scan(&id)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, errUnknownUser
+	}
+	if err != nil {
+		return 0, err
+	}
+	var at any
+	if disabled {
+		at = nowUTC()
+	}
+	if _, err := a.db.Exec(`UPDATE users SET disabled = ?, disabled_at = ? WHERE id = ?`, disabled, at, id); err != nil {
+		return 0, err
+	}
+	return id, nil
+}
+
+// handleAdminUser serves PATCH /api/admin/users/{username} with
+// {"disabled":true|false}. Admins cannot disable themselves.
+func (a *App) handleAdminUser(w http.ResponseWriter, r *http.Request, u AuthedUser) {
+	if r.Method != http.MethodPatch {
+		jsonErr(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	var req struct {
+		Disabled *bool `json:"disabled"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		jsonErr(w, http.StatusBadRequest, "invalid json")
+		return
+	}
+	if req.Disabled == nil {
+		jsonErr(w, http.StatusBadRequest, "disabled is required")
+		return
+	}
+	username := r.PathValue("username")
+	if *req.Disabled && username == u.Username {
+		jsonErr(w, http.StatusBadRequest, "cannot disable your own account")
+		return
+	}
+	id, err := a.setUserDisabled(username, *req.Disabled)
+	if errors.Is(err, errUnknownUser) {
+		jsonErr(w, http.StatusNotFound, err.Error())
+		return
+	}
+	if err != nil {
+		jsonErr(w, http.StatusInternalServerError, "failed to update user")
+		return
+	}
+	action := "enable_user"
+	if *req.Disabled {
+		action = "disable_user"
+	}
+	_ = a.logUserAction(u, action, fmt.Sprintf("target_username=%s user_id=%d", username, id))
+	jsonOut(w, http.StatusOK, map[string]any{"id": id, "username": username, "disabled": *req.Disabled})
+}
+
+// runAdminDisableUser backs 'admin disable-user' and 'admin enable-user'.
+func runAdminDisableUser(args []string, disabled bool) error {
+	name, verb := "disable-user", "Disables"
+	if !disabled {
+		name, verb = "enable-user", "Re-enables"
+	}
+	fs := flag.NewFlagSet("admin "+name, flag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s admin %s --username <name> [options]\n\n", binName(), name)
+		fmt.Fprintf(fs.Output(), "%s a user's account; a disabled user's token is rejected and their data is kept.\n", verb)
+		fmt.Fprintln(fs.Output())
+		fmt.Fprintln(fs.Output(), "Options:")
+		fs.PrintDefaults()
+	}
+	username := fs.String("username", "", "user to update")
+	dbPath := fs.String("db", defaultDBPath, "sqlite db path")
+	logPath := fs.String("log", defaultLogPath, "log target path ('-' for stdout only)")
+	if err := fs.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return nil
+		}
+		return err
+	}
+	if strings.TrimSpace(*username) == "" {
+		return errors.New("--username is required")
+	}
+	app, closeApp, err := openAdminApp(*dbPath, *logPath)
+	if err != nil {
+		return err
+	}
+	defer closeApp()
+
+	id, err := app.setUserDisabled(*username, disabled)
+	if err != nil {
+		return fmt.Errorf("%w: %s", err, *username)
+	}
+	action := strings.ReplaceAll(name, "-", "_")
+	_ = app.logAction("admin_cli", "admin", action, fmt.Sprintf("target_username=%s user_id=%d", strings.TrimSpace(*username), id))
+	if disabled {
+		fmt.Printf("disabled user: %s\n", strings.TrimSpace(*username))
+	} else {
+		fmt.Printf("enabled user: %s\n", strings.TrimSpace(*username))
+	}
+	return nil
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestAdminCreateUserAPI(t *testing.T) {
+	app := newTestApp(t)
+	h := newTestMux(app)
+	createUser(t, app, "root", "PUDUSERRO01")
+	if _, err := app.db.Exec(`UPDATE users SET role = 'admin' WHERE username = 'root'`); err != nil {
+		t.Fatalf("promote: %v", err)
+	}
+	post := func(body any) *httptest.ResponseRecorder {
+		t.Helper()
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, authedReq(t, http.MethodPost, "/api/admin/users", body, "PUDUSERRO01"))
+		return rr
+	}
+
+	rr := post(map[string]string{"username": " dana ", "role": "admin"})
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("create: %d %s", rr.Code, rr.Body.String())
+	}
+	var nu newUser
+	if err := json.Unmarshal(rr.Body.Bytes(), &nu); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if nu.ID == 0 || nu.Username != "dana" || nu.Role != "admin" || len(nu.Token) != tokenTotalLen {
+		t.Fatalf("unexpected user: %+v", nu)
+	}
+	var stored string
+	if err := app.db.QueryRow(`SELECT token_hash FROM tokens WHERE user_id = ?`, nu.ID).Scan(&stored); err != nil || stored != hashToken(nu.Token) {
+		t.Fatalf("token hash not stored: %q %v", stored, err)
+	}
+	rr = httptest.NewRecorder()
+	h.ServeHTTP(rr, authedReq(t, http.MethodGet, "/api/admin/settings", nil, nu.Token))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("new admin token should work: %d", rr.Code)
+	}
+
+	if rr := post(map[string]string{"username": "erin"}); rr.Code != http.StatusCreated || !strings.Contains(rr.Body.String(), `"role":"member"`) {
+		t.Fatalf("default role: %d %s", rr.Code, rr.Body.String())
+	}
+	for _, tc := range []struct {
+		body map[string]string
+		want int
+	}{
+		{map[string]string{"username": "dana"}, http.StatusConflict},
+		{map[string]string{"username": ""}, http.StatusBadRequest},
+		{map[string]string{"username": "fred", "role": "superuser"}, http.StatusBadRequest},
+		{map[string]string{"username": "system"}, http.StatusBadRequest},
+	} {
+		if rr := post(tc.body); rr.Code != tc.want {
+			t.Errorf("%v: expected %d, got %d %s", tc.body, tc.want, rr.Code, rr.Body.String())
+		}
+	}
+	var meta string
+	if err := app.db.QueryRow(`SELECT metadata FROM action_logs WHERE action = 'create_user' ORDER BY id ASC LIMIT 1`).Scan(&meta); err != nil || !strings.Contains(meta, "target_username=dana") || strings.Contains(meta, nu.Token) {
+		t.Fatalf("audit row: %q %v", meta, err)
+	}
+}
+
+func TestDisableUser(t *testing.T) {
+	app := newTestApp(t)
+	h := newTestMux(app)
+	createUser(t, app, "root", "PUDDISABLE1")
+	createUser(t, app, "alice", "PUDDISABLE2")
+	if _, err := app.db.Exec(`UPDATE users SET role = 'admin' WHERE username = 'root'`); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := app.linkIdentity(providerEmail, "alice@example.com", "alice"); err != nil {
+		t.Fatal(err)
+	}
+	patch := func(username string, body any) *httptest.ResponseRecorder {
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, authedReq(t, http.MethodPatch, "/api/admin/users/"+username, body, "PUDDISABLE1"))
+		return rr
+	}
+	me := func(token, impersonate string) int {
+		req := authedReq(t, http.MethodGet, "/api/me", nil, token)
+		if impersonate != "" {
+			req.Header.Set("X-Impersonate-User", impersonate)
+		}
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, req)
+		return rr.Code
+	}
+
+	if rr := patch("alice", map[string]bool{"disabled": true}); rr.Code != http.StatusOK {
+		t.Fatalf("disable: %d %s", rr.Code, rr.Body.String())
+	}
+	if code := me("PUDDISABLE2", ""); code != http.StatusUnauthorized {
+		t.Fatalf("disabled token: %d", code)
+	}
+	if code := me("PUDDISABLE1", "alice"); code != http.StatusNotFound {
+		t.Fatalf("impersonating a disabled user: %d", code)
+	}
+	if _, err := app.resolveIdentity(providerEmail, "alice@example.com"); !errors.Is(err, errUnknownIdentity) {
+		t.Fatalf("identity of a disabled user: %v", err)
+	}
+
+	for _, c := range []struct {
+		user string
+		body any
+		want int
+	}{
+		{"root", map[string]bool{"disabled": true}, http.StatusBadRequest},
+		{"nobody", map[string]bool{"disabled": true}, http.StatusNotFound},
+		{"system", map[string]bool{"disabled": true}, http.StatusNotFound},
+		{"alice", map[string]string{}, http.StatusBadRequest},
+	} {
+		if rr := patch(c.user, c.body); rr.Code != c.want {
+			t.Fatalf("patch %s %v: %d, want %d", c.user, c.body, rr.Code, c.want)
+		}
+	}
+
+	if rr := patch("alice", map[string]bool{"disabled": false}); rr.Code != http.StatusOK {
+		t.Fatalf("enable: %d %s", rr.Code, rr.Body.String())
+	}
+	if code := me("PUDDISABLE2", ""); code != http.StatusOK {
+		t.Fatalf("re-enabled token: %d", code)
+	}
+	var actions []string
+	rows, err := app.db.Query(`SELECT action FROM action_logs WHERE action IN ('disable_user', 'enable_user') ORDER BY id`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var a string
+		_ = rows.Scan(&a)
+		actions = append(actions, a)
+	}
+	if strings.Join(actions, ",") != "disable_user,enable_user" {
+		t.Fatalf("audit rows: %v", actions)
+	}
+}
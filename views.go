@@ -0,0 +1,490 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	maxViewsPerUser = 50
+	maxViewNameLen  = 64
+	maxViewQueryLen = 200
+	maxViewFilters  = 20
+	// viewScanRows caps the live entries and compacts read per view run.
+	viewScanRows = 5000
+)
+
+var viewTagRe = regexp.MustCompile(`^[A-Za-z][A-Za-z0-9_-]{0,49}$`)
+
+// savedView is a named search owned by one user. Query terms must all appear
+// in the content; an entry matches Tags when it carries any of them and Users
+// when any of them wrote it ("me" is the owner). Range is relative to the run
+// (14d, 2w, 72h), a start day, or a closed day range "FROM..TO".
+type savedView struct {
+	ID        int64    `json:"id"`
+	Name      string   `json:"name"`
+	Query     string   `json:"query"`
+	Tags      []string `json:"tags"`
+	Users     []string `json:"users"`
+	Range     string   `json:"range"`
+	CreatedAt string   `json:"created_at"`
+	UpdatedAt string   `json:"updated_at"`
+}
+
+// normalize trims and validates v in place.
+func (v *savedView) normalize() error {
+	v.Name = strings.Join(strings.Fields(v.Name), " ")
+	if v.Name == "" || len(v.Name) > maxViewNameLen {
+		return fmt.Errorf("name is required and must be at most %d bytes", maxViewNameLen)
+	}
+	v.Query = strings.Join(strings.Fields(v.Query), " ")
+	if len(v.Query) > maxViewQueryLen {
+		return fmt.Errorf("query must be at most %d bytes", maxViewQueryLen)
+	}
+	if len(v.Tags) > maxViewFilters || len(v.Users) > maxViewFilters {
+		return fmt.Errorf("at most %d tags and %d users", maxViewFilters, maxViewFilters)
+	}
+	tags := []string{}
+	for _, t := range v.Tags {
+		t = strings.ToLower(strings.TrimPrefix(strings.TrimSpace(t), "#"))
+		if !viewTagRe.MatchString(t) {
+			return fmt.Errorf("invalid tag %q", t)
+		}
+		if !containsString(tags, t) {
+			tags = append(tags, t)
+		}
+	}
+	users := []string{}
+	for _, u := range v.Users {
+		u = strings.TrimPrefix(strings.TrimSpace(u), "@")
+		if u == "" {
+			return errors.New("empty user")
+		}
+		if !containsString(users, u) {
+			users = append(users, u)
+		}
+	}
+	v.Tags, v.Users = tags, users
+	v.Range = strings.TrimSpace(v.Range)
+	if _, _, err := viewRange(v.Range, time.Now()); err != nil {
+		return err
+	}
+	return nil
+}
+
+// viewRange resolves a view range to [from, to); zero times are open ends.
+func viewRange(s string, now time.Time) (time.Time, time.Time, error) {
+	if s == "" {
+		return time.Time{}, time.Time{}, nil
+	}
+	if a, b, ok := strings.Cut(s, ".."); ok {
+		from, err1 := time.Parse("2006-01-02", strings.TrimSpace(a))
+		to, err2 := time.Parse("2006-01-02", strings.TrimSpace(b))
+		if err1 != nil || err2 != nil || to.Before(from) {
+			return time.Time{}, time.Time{}, fmt.Errorf("invalid range %q (want FROM..TO days)", s)
+		}
+		return from, to.AddDate(0, 0, 1), nil
+	}
+	from, err := parseSince(s, now)
+	if err != nil {
+		return time.Time{}, time.Time{}, fmt.Errorf("invalid range %q (want e.g. 14d, 2w, 72h, YYYY-MM-DD or FROM..TO)", s)
+	}
+	return from, time.Time{}, nil
+}
+
+// viewHit is one search result. Entries already merged into a daily compact
+// keep their original id and carry the compact's id.
+type viewHit struct {
+	EntryID   int64  `json:"entry_id"`
+	CompactID int64  `json:"compact_id,omitempty"`
+	User      string `json:"user"`
+	Category  string `json:"category,omitempty"`
+	Content   string `json:"content"`
+	CreatedAt string `json:"created_at"`
+	URL       string `json:"url"`
+}
+
+// viewMatcher is a savedView resolved for one run.
+type viewMatcher struct {
+	terms    []string // lowercased
+	tags     map[string]bool
+	users    map[string]bool
+	from, to string // RFC3339 bounds, "" when open
+}
+
+func (v savedView) matcher(owner string, now time.Time) (viewMatcher, error) {
+	from, to, err := viewRange(v.Range, now)
+	if err != nil {
+		return viewMatcher{}, err
+	}
+	m := viewMatcher{terms: strings.Fields(strings.ToLower(v.Query)), tags: map[string]bool{}, users: map[string]bool{}}
+	for _, t := range v.Tags {
+		m.tags[t] = true
+	}
+	for _, u := range v.Users {
+		if u == "me" {
+			u = owner
+		}
+		m.users[u] = true
+	}
+	if !from.IsZero() {
+		m.from = from.UTC().Format(time.RFC3339)
+	}
+	if !to.IsZero() {
+		m.to = to.UTC().Format(time.RFC3339)
+	}
+	return m, nil
+}
+
+func (m viewMatcher) match(user, content, createdAt string) bool {
+	if (m.from != "" && createdAt < m.from) || (m.to != "" && createdAt >= m.to) {
+		return false
+	}
+	if len(m.users) > 0 && !m.users[user] {
+		return false
+	}
+	lower := strings.ToLower(content)
+	for _, t := range m.terms {
+		if !strings.Contains(lower, t) {
+			return false
+		}
+	}
+	if len(m.tags) == 0 {
+		return true
+	}
+	for _, t := range tagRe.FindAllStringSubmatch(content, -1) {
+		if m.tags[strings.ToLower(t[1])] {
+			return true
+		}
+	}
+	return false
+}
+
+// runView searches live entries and the sources kept in compacts,
+// newest first. SQL narrows the candidates; match decides. truncated reports
+// that more than limit entries matched or the scan cap was reached.
+func (a *App) runView(m viewMatcher, limit int) ([]viewHit, bool, error) {
+	var conds []string
+	var args []any
+	for _, t := range m.terms {
+		conds = append(conds, `e.content LIKE ? ESCAPE '\'`)
+		args = append(args, "%"+likePrefix(t))
+	}
+	if m.from != "" {
+		// Compacts are written after their sources, so this bound holds for both.
+		conds = append(conds, `e.created_at >= ?`)
+		args = append(args, m.from)
+	}
+	where := ""
+	if len(conds) > 0 {
+		where = " AND " + strings.Join(conds, " AND ")
+	}
+
+	hits := []viewHit{}
+	scanned := 0
+	rows, err := a.db.Query(`
+SELECT e.id, u.username, e.category, e.content, e.created_at
+FROM entries e
+JOIN users u ON u.id = e.user_id
+WHERE e.entry_type = 'normal' AND e.deleted_at IS NULL`+where+`
+ORDER BY e.created_at DESC
+LIMIT ?`, append(args, viewScanRows)...)
+	if err != nil {
+		return nil, false, err
+	}
+	for rows.Next() {
+		var h viewHit
+		if err := rows.Scan(&h.EntryID, &h.User, &h.Category, &h.Content, &h.CreatedAt); err != nil {
+			_ = rows.Close()
+			return nil, false, err
+		}
+		scanned++
+		if m.match(h.User, h.Content, h.CreatedAt) {
+			hits = append(hits, h)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		_ = rows.Close()
+		return nil, false, err
+	}
+	_ = rows.Close()
+	capped := scanned == viewScanRows
+
+	scanned = 0
+	rows, err = a.db.Query(`
+SELECT e.id, e.compact_data
+FROM entries e
+WHERE e.entry_type IN `+compactTypesSQL+` AND e.deleted_at IS NULL AND e.compact_data IS NOT NULL`+where+`
+ORDER BY e.created_at DESC
+LIMIT ?`, append(args, viewScanRows)...)
+	if err != nil {
+		return nil, false, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var compactID int64
+		var data string
+		if err := rows.Scan(&compactID, &data); err != nil {
+			return nil, false, err
+		}
+		scanned++
+		var sources []compactSource
+		if err := json.Unmarshal([]byte(data), &sources); err != nil {
+			return nil, false, fmt.Errorf("compact %d: %w", compactID, err)
+		}
+		for _, s := range sources {
+			if m.match(s.User, s.Content, s.CreatedAt) {
+				hits = append(hits, viewHit{EntryID: s.EntryID, CompactID: compactID, User: s.User, Content: s.Content, CreatedAt: s.CreatedAt})
+			}
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, false, err
+	}
+	_ = rows.Close()
+	capped = capped || scanned == viewScanRows
+
+	sort.SliceStable(hits, func(i, j int) bool {
+		if hits[i].CreatedAt != hits[j].CreatedAt {
+			return hits[i].CreatedAt > hits[j].CreatedAt
+		}
+		return hits[i].EntryID > hits[j].EntryID
+	})
+	truncated := capped || len(hits) > limit
+	if len(hits) > limit {
+		hits = hits[:limit]
+	}
+	for i := range hits {
+		// Compacted entries are anchored on their compact.
+		anchor := hits[i].EntryID
+		if hits[i].CompactID != 0 {
+			anchor = hits[i].CompactID
+		}
+		hits[i].URL = a.entryURL(anchor, hits[i].CreatedAt)
+	}
+	return hits, truncated, nil
+}
+
+const savedViewColumns = `id, name, query, tags, users, range_spec, created_at, updated_at`
+
+func scanSavedView(sc interface{ Scan(...any) error }) (savedView, error) {
+	var v savedView
+	var tags, users string
+	if err := sc.Scan(&v.ID, &v.Name, &v.Query, &tags, &users, &v.Range, &v.CreatedAt, &v.UpdatedAt); err != nil {
+		return v, err
+	}
+	if err := json.Unmarshal([]byte(tags), &v.Tags); err != nil {
+		return v, err
+	}
+	if err := json.Unmarshal([]byte(users), &v.Users); err != nil {
+		return v, err
+	}
+	return v, nil
+}
+
+func (a *App) savedViews(userID int64) ([]savedView, error) {
+	rows, err := a.db.Query(`SELECT `+savedViewColumns+` FROM saved_views WHERE user_id = ? ORDER BY name ASC`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	out := []savedView{}
+	for rows.Next() {
+		v, err := scanSavedView(rows)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, v)
+	}
+	return out, rows.Err()
+}
+
+func (a *App) savedView(userID, id int64) (savedView, error) {
+	return scanSavedView(a.db.QueryRow(`SELECT `+savedViewColumns+` FROM saved_views WHERE id = ? AND user_id = ?`, id, userID))
+}
+
+// decodeSavedView reads and validates a view from a request body.
+func decodeSavedView(r *http.Request) (savedView, error) {
+	var v savedView
+	dec := json.NewDecoder(r.Body)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&v); err != nil {
+		return v, errors.New("invalid json")
+	}
+	return v, v.normalize()
+}
+
+// viewNameTaken reports whether another of the user's views (not id) has name.
+func (a *App) viewNameTaken(userID, id int64, name string) (bool, error) {
+	var n int
+	err := a.db.QueryRow(`SELECT COUNT(*) FROM saved_views WHERE user_id = ? AND name = ? AND id != ?`, userID, name, id).Scan(&n)
+	return n > 0, err
+}
+
+func viewNameError(w http.ResponseWriter, err error) {
+	if err != nil {
+		jsonErr(w, http.StatusInternalServerError, "failed to store view")
+		return
+	}
+	jsonErr(w, http.StatusConflict, "a view with this name already exists")
+}
+
+// handleMyViews lists (GET) or creates (POST) the caller's saved views.
+func (a *App) handleMyViews(w http.ResponseWriter, r *http.Request, u AuthedUser) {
+	switch r.Method {
+	case http.MethodGet:
+		views, err := a.savedViews(u.ID)
+		if err != nil {
+			jsonErr(w, http.StatusInternalServerError, "failed to query views")
+			return
+		}
+		jsonOut(w, http.StatusOK, map[string]any{"views": views})
+	case http.MethodPost:
+		v, err := decodeSavedView(r)
+		if err != nil {
+			jsonErr(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		var n int
+		if err := a.db.QueryRow(`SELECT COUNT(*) FROM saved_views WHERE user_id = ?`, u.ID).Scan(&n); err != nil {
+			jsonErr(w, http.StatusInternalServerError, "failed to store view")
+			return
+		}
+		if n >= maxViewsPerUser {
+			jsonErr(w, http.StatusConflict, fmt.Sprintf("at most %d saved views", maxViewsPerUser))
+			return
+		}
+		if taken, err := a.viewNameTaken(u.ID, 0, v.Name); err != nil || taken {
+			viewNameError(w, err)
+			return
+		}
+		tags, _ := json.Marshal(v.Tags)
+		users, _ := json.Marshal(v.Users)
+		v.CreatedAt = nowUTC()
+		v.UpdatedAt = v.CreatedAt
+		res, err := a.db.Exec(`INSERT INTO saved_views(user_id, name, query, tags, users, range_spec, created_at, updated_at) VALUES(?, ?, ?, ?, ?, ?, ?, ?)`,
+			u.ID, v.Name, v.Query, string(tags), string(users), v.Range, v.CreatedAt, v.UpdatedAt)
+		if err != nil {
+			jsonErr(w, http.StatusInternalServerError, "failed to store view")
+			return
+		}
+		v.ID, _ = res.LastInsertId()
+		_ = a.logUserAction(u, "create_view", fmt.Sprintf("view_id=%d name=%q", v.ID, v.Name))
+		jsonOut(w, http.StatusCreated, v)
+	default:
+		jsonErr(w, http.StatusMethodNotAllowed, "method not allowed")
+	}
+}
+
+// handleMyView reads (GET), replaces (PUT) or deletes (DELETE) one of the
+// caller's saved views. Other users' views answer 404.
+func (a *App) handleMyView(w http.ResponseWriter, r *http.Request, u AuthedUser) {
+	id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil || id <= 0 {
+		jsonErr(w, http.StatusBadRequest, "invalid view id")
+		return
+	}
+	cur, err := a.savedView(u.ID, id)
+	if errors.Is(err, sql.ErrNoRows) {
+		jsonErr(w, http.StatusNotFound, "view not found")
+		return
+	}
+	if err != nil {
+		jsonErr(w, http.StatusInternalServerError, "failed to query view")
+		return
+	}
+	switch r.Method {
+	case http.MethodGet:
+		jsonOut(w, http.StatusOK, cur)
+	case http.MethodPut:
+		v, err := decodeSavedView(r)
+		if err != nil {
+			jsonErr(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		if taken, err := a.viewNameTaken(u.ID, id, v.Name); err != nil || taken {
+			viewNameError(w, err)
+			return
+		}
+		v.ID, v.CreatedAt, v.UpdatedAt = id, cur.CreatedAt, nowUTC()
+		tags, _ := json.Marshal(v.Tags)
+		users, _ := json.Marshal(v.Users)
+		_, err = a.db.Exec(`UPDATE saved_views SET name = ?, query = ?, tags = ?, users = ?, range_spec = ?, updated_at = ? WHERE id = ? AND user_id = ?`,
+			v.Name, v.Query, string(tags), string(users), v.Range, v.UpdatedAt, id, u.ID)
+		if err != nil {
+			jsonErr(w, http.StatusInternalServerError, "failed to store view")
+			return
+		}
+		_ = a.logUserAction(u, "update_view", fmt.Sprintf("view_id=%d name=%q", id, v.Name))
+		jsonOut(w, http.StatusOK, v)
+	case http.MethodDelete:
+		if _, err := a.db.Exec(`DELETE FROM saved_views WHERE id = ? AND user_id = ?`, id, u.ID); err != nil {
+			jsonErr(w, http.StatusInternalServerError, "failed to delete view")
+			return
+		}
+		_ = a.logUserAction(u, "delete_view", fmt.Sprintf("view_id=%d name=%q", id, cur.Name))
+		jsonOut(w, http.StatusOK, map[string]string{"status": "deleted"})
+	default:
+		jsonErr(w, http.StatusMethodNotAllowed, "method not allowed")
+	}
+}
+
+// handleRunView serves GET /api/me/views/{id}/entries?limit&anonymize: the
+// entries matching one of the caller's saved views.
+func (a *App) handleRunView(w http.ResponseWriter, r *http.Request, u AuthedUser) {
+	if r.Method != http.MethodGet {
+		jsonErr(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil || id <= 0 {
+		jsonErr(w, http.StatusBadRequest, "invalid view id")
+		return
+	}
+	limit := 200
+	if raw := strings.TrimSpace(r.URL.Query().Get("limit")); raw != "" {
+		if n, err := strconv.Atoi(raw); err == nil && n > 0 && n <= 1000 {
+			limit = n
+		}
+	}
+	anonymous, err := a.wantAnonymous(r)
+	if err != nil {
+		jsonErr(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	v, err := a.savedView(u.ID, id)
+	if errors.Is(err, sql.ErrNoRows) {
+		jsonErr(w, http.StatusNotFound, "view not found")
+		return
+	}
+	if err != nil {
+		jsonErr(w, http.StatusInternalServerError, "failed to query view")
+		return
+	}
+	m, err := v.matcher(u.Username, time.Now())
+	if err != nil {
+		jsonErr(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	hits, truncated, err := a.runView(m, limit)
+	if err != nil {
+		jsonErr(w, http.StatusInternalServerError, "failed to run view")
+		return
+	}
+	if anonymous {
+		for i := range hits {
+			hits[i].User = anonymousName(hits[i].User)
+			hits[i].Content = scrubMentions(hits[i].Content)
+		}
+	}
+	_ = a.logUserAction(u, "run_view", fmt.Sprintf("view_id=%d results=%d anonymous=%t", id, len(hits), anonymous))
+	jsonOut(w, http.StatusOK, map[string]any{"view": v, "entries": hits, "truncated": truncated})
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSavedViews(t *testing.T) {
+	app := newTestApp(t)
+	h := newTestMux(app)
+	createUser(t, app, "alice", "PUDVIEWAL01")
+	createUser(t, app, "bob", "PUDVIEWBO01")
+	post := func(token, content string) {
+		t.Helper()
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, authedReq(t, http.MethodPost, "/api/entries", map[string]string{"content": content}, token))
+		if rr.Code != http.StatusCreated {
+			t.Fatalf("create: %d %s", rr.Code, rr.Body.String())
+		}
+	}
+	post("PUDVIEWAL01", "#blocker waiting on db creds")
+	post("PUDVIEWAL01", "shipped #release")
+	post("PUDVIEWBO01", "#blocker flaky CI")
+	old := time.Now().UTC().AddDate(0, 0, -30).Format(time.RFC3339)
+	if _, err := app.insertEntry(1, "#blocker from last month", "", sourceAPI, old); err != nil {
+		t.Fatalf("insert old entry: %v", err)
+	}
+	if err := app.compactDay(time.Now().UTC().Format("2006-01-02")); err != nil {
+		t.Fatalf("compactDay: %v", err)
+	}
+	post("PUDVIEWAL01", "deploy stuck #Blocker")
+
+	view := map[string]any{"name": "my blockers this sprint", "tags": []string{"#blocker"}, "users": []string{"me"}, "range": "14d"}
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, authedReq(t, http.MethodPost, "/api/me/views", view, "PUDVIEWAL01"))
+	var created savedView
+	if err := json.Unmarshal(rr.Body.Bytes(), &created); err != nil || rr.Code != http.StatusCreated {
+		t.Fatalf("create view: %d %s", rr.Code, rr.Body.String())
+	}
+	if created.Tags[0] != "blocker" {
+		t.Fatalf("tag not normalized: %+v", created)
+	}
+	rr = httptest.NewRecorder()
+	h.ServeHTTP(rr, authedReq(t, http.MethodPost, "/api/me/views", view, "PUDVIEWAL01"))
+	if rr.Code != http.StatusConflict {
+		t.Fatalf("duplicate name: expected 409, got %d", rr.Code)
+	}
+	rr = httptest.NewRecorder()
+	h.ServeHTTP(rr, authedReq(t, http.MethodPost, "/api/me/views", map[string]any{"name": "bad", "range": "soon"}, "PUDVIEWAL01"))
+	if rr.Code != http.StatusBadRequest {
+		t.Fatalf("invalid range: expected 400, got %d", rr.Code)
+	}
+
+	path := fmt.Sprintf("/api/me/views/%d", created.ID)
+	rr = httptest.NewRecorder()
+	h.ServeHTTP(rr, authedReq(t, http.MethodGet, path+"/entries", nil, "PUDVIEWAL01"))
+	var run struct {
+		Entries []viewHit `json:"entries"`
+	}
+	if err := json.Unmarshal(rr.Body.Bytes(), &run); err != nil || rr.Code != http.StatusOK {
+		t.Fatalf("run view: %d %s", rr.Code, rr.Body.String())
+	}
+	if len(run.Entries) != 2 || run.Entries[0].Content != "deploy stuck #Blocker" || run.Entries[0].CompactID != 0 ||
+		run.Entries[1].Content != "#blocker waiting on db creds" || run.Entries[1].CompactID == 0 {
+		t.Fatalf("unexpected hits: %+v", run.Entries)
+	}
+
+	rr = httptest.NewRecorder()
+	h.ServeHTTP(rr, authedReq(t, http.MethodGet, path+"/entries", nil, "PUDVIEWBO01"))
+	if rr.Code != http.StatusNotFound {
+		t.Fatalf("other users' views must be hidden, got %d", rr.Code)
+	}
+	rr = httptest.NewRecorder()
+	h.ServeHTTP(rr, authedReq(t, http.MethodDelete, path, nil, "PUDVIEWAL01"))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("delete view: %d", rr.Code)
+	}
+	rr = httptest.NewRecorder()
+	h.ServeHTTP(rr, authedReq(t, http.MethodGet, "/api/me/views", nil, "PUDVIEWAL01"))
+	if rr.Code != http.StatusOK || !strings.Contains(rr.Body.String(), `"views":[]`) {
+		t.Fatalf("list after delete: %d %s", rr.Code, rr.Body.String())
+	}
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+const (
+	// defaultWatchdogStall is the --watchdog-stall default.
+	defaultWatchdogStall = 10 * time.Minute
+	// maxWatchdogRestarts caps restarts of one loop per hour; a loop that
+	// keeps wedging is left stale for /api/ready to report instead of
+	// piling up stuck goroutines.
+	maxWatchdogRestarts = 3
+	// deliveryHeartbeat is how often an idle dispatcher reports in.
+	deliveryHeartbeat = 30 * time.Second
+)
+
+// supervise runs a background loop under the watchdog. The loop must report
+// to a.health under name at least every interval. When it has not for
+// max(3 intervals, --watchdog-stall), or it returns or panics while ctx is
+// live, its context is cancelled and a fresh goroutine takes over. A wedged
+// goroutine cannot be killed: it exits at its next context check, and
+// shared state stays safe because compaction serializes on compactMu.
+func (a *App) supervise(ctx context.Context, name string, interval time.Duration, run func(context.Context)) {
+	a.health.register(name, interval, time.Now())
+	if a.watchdogStall <= 0 {
+		go a.runLoop(ctx, name, run)
+		return
+	}
+	limit := staleAfterTicks * interval
+	if a.watchdogStall > limit {
+		limit = a.watchdogStall
+	}
+	check := limit / 3
+	if check > 30*time.Second {
+		check = 30 * time.Second
+	}
+	go func() {
+		start := func() (context.CancelFunc, <-chan struct{}) {
+			loopCtx, cancel := context.WithCancel(ctx)
+			done := make(chan struct{})
+			go func() {
+				defer close(done)
+				a.runLoop(loopCtx, name, run)
+			}()
+			return cancel, done
+		}
+		cancel, done := start()
+		ticker := time.NewTicker(check)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				cancel()
+				return
+			case <-done:
+				done = nil
+				if ctx.Err() != nil {
+					return
+				}
+			case now := <-ticker.C:
+				if done != nil && !a.health.stalled(name, now, limit) {
+					continue
+				}
+			}
+			reason := "stalled"
+			if done == nil {
+				reason = "exited"
+			}
+			ok, gaveUp := a.health.restarted(name, time.Now())
+			if !ok {
+				if gaveUp {
+					a.logger.Printf("event=watchdog_gave_up subsystem=%s reason=%s restarts_per_hour=%d", name, reason, maxWatchdogRestarts)
+				}
+				continue
+			}
+			cancel()
+			a.logger.Printf("event=watchdog_restart subsystem=%s reason=%s limit=%s", name, reason, limit)
+			cancel, done = start()
+		}
+	}()
+}
+
+// runLoop runs one loop instance, turning a panic into a recorded failure so
+// the watchdog can restart it.
+func (a *App) runLoop(ctx context.Context, name string, run func(context.Context)) {
+	defer func() {
+		if p := recover(); p != nil {
+			a.logger.Printf("event=loop_panic subsystem=%s panic=%v", name, p)
+			a.health.record(name, time.Now(), fmt.Errorf("panic: %v", p))
+		}
+	}()
+	run(ctx)
+}
+
+// stalled reports whether name has not run for limit.
+func (h *healthTracker) stalled(name string, now time.Time, limit time.Duration) bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	r, ok := h.runs[name]
+	return ok && now.Sub(r.lastSeen()) > limit
+}
+
+// restarted counts a watchdog restart of name and resets its staleness
+// clock. Once the hourly cap is used up it refuses; gaveUp is true only on
+// the first refusal so the supervisor logs it once.
+func (h *healthTracker) restarted(name string, now time.Time) (ok, gaveUp bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	r, found := h.runs[name]
+	if !found {
+		return false, false
+	}
+	recent := 0
+	for _, t := range r.restarts {
+		if now.Sub(t) < time.Hour {
+			recent++
+		}
+	}
+	if recent >= maxWatchdogRestarts {
+		gaveUp = !r.gaveUp
+		r.gaveUp = true
+		return false, gaveUp
+	}
+	r.gaveUp = false
+	r.restarts = append(r.restarts, now)
+	r.started = now
+	return true, false
+}
+
+// restartCounts returns watchdog restarts per subsystem for /metrics.
+func (h *healthTracker) restartCounts() map[string]float64 {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	out := map[string]float64{}
+	for name, r := range h.runs {
+		if r.interval > 0 {
+			out[name] = float64(len(r.restarts))
+		}
+	}
+	return out
+}
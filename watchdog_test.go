@@ -0,0 +1,63 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestWatchdogRestartsStalledLoops(t *testing.T) {
+	app := newTestApp(t)
+	app.watchdogStall = 30 * time.Millisecond
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// A loop that heartbeats once and then wedges until cancelled.
+	var starts atomic.Int32
+	app.supervise(ctx, "wedged", 10*time.Millisecond, func(ctx context.Context) {
+		starts.Add(1)
+		app.health.record("wedged", time.Now(), nil)
+		<-ctx.Done()
+	})
+	// A loop that panics on its first run and then behaves.
+	var panics atomic.Int32
+	app.supervise(ctx, "flaky", time.Second, func(ctx context.Context) {
+		if panics.Add(1) == 1 {
+			panic("boom")
+		}
+		app.health.record("flaky", time.Now(), nil)
+		<-ctx.Done()
+	})
+
+	deadline := time.Now().Add(3 * time.Second)
+	for time.Now().Before(deadline) && (starts.Load() < 1+maxWatchdogRestarts || panics.Load() < 2) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	if n := starts.Load(); n != 1+maxWatchdogRestarts {
+		t.Fatalf("wedged loop started %d times, want %d", n, 1+maxWatchdogRestarts)
+	}
+	if n := panics.Load(); n != 2 {
+		t.Fatalf("panicking loop started %d times, want 2", n)
+	}
+	// The hourly cap stops further restarts; the loop is left stale for /api/ready.
+	time.Sleep(100 * time.Millisecond)
+	if n := starts.Load(); n != 1+maxWatchdogRestarts {
+		t.Fatalf("restarts past the cap: %d starts", n)
+	}
+	counts := app.health.restartCounts()
+	if counts["wedged"] != maxWatchdogRestarts || counts["flaky"] != 1 {
+		t.Fatalf("restart counts: %v", counts)
+	}
+	if s := app.health.snapshot(time.Now())["wedged"]; s.Status != "stale" || s.Restarts != maxWatchdogRestarts {
+		t.Fatalf("wedged status: %+v", s)
+	}
+	rr := httptest.NewRecorder()
+	app.handleMetrics(rr, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	if !strings.Contains(rr.Body.String(), `devlog_watchdog_restarts_total{subsystem="wedged"} 3`) {
+		t.Fatalf("metric missing:\n%s", rr.Body.String())
+	}
+}
@@ -1,13 +1,32 @@
 package main
 
 import (
+	"bytes"
+	"context"
+	"crypto/sha256"
 	"embed"
+	"encoding/hex"
+	"fmt"
 	"html/template"
+	"mime"
 	"net/http"
+	"path"
+	"strconv"
+	"strings"
+	"time"
 )
 
 type uiPageData struct {
-	Title string
+	Title       string
+	Maintenance string
+	Banner      string
+	BasePath    string
+	APIBase     string
+	CSSPath     string
+	JSPath      string
+	// Status and Message fill templates/error.html.
+	Status  int
+	Message string
 }
 
 //go:embed templates/*.html
@@ -19,33 +38,183 @@ var oatCSS []byte
 //go:embed oat.min.js
 var oatJS []byte
 
+// uiAsset is an embedded static file. Pages link the content-hashed path,
+// which is cached forever; the plain path stays available for old pages and
+// is revalidated with the ETag.
+type uiAsset struct {
+	plainPath   string
+	hashedPath  string
+	contentType string
+	etag        string
+	data        []byte
+}
+
+func newUIAsset(name, contentType string, data []byte) *uiAsset {
+	sum := sha256.Sum256(data)
+	digest := hex.EncodeToString(sum[:])
+	ext := path.Ext(name)
+	return &uiAsset{
+		plainPath:   "/assets/" + name,
+		hashedPath:  "/assets/" + strings.TrimSuffix(name, ext) + "." + digest[:12] + ext,
+		contentType: contentType,
+		etag:        `"` + digest[:32] + `"`,
+		data:        data,
+	}
+}
+
+var (
+	oatCSSAsset = newUIAsset("oat.min.css", "text/css; charset=utf-8", oatCSS)
+	oatJSAsset  = newUIAsset("oat.min.js", "application/javascript; charset=utf-8", oatJS)
+	uiAssets    = []*uiAsset{oatCSSAsset, oatJSAsset}
+)
+
+// uiHandler routes the web UI server. Pages and assets answer GET and HEAD
+// only; other methods get 405 and unknown paths 404, as an error page or, for
+// clients that prefer JSON, an API-style error body. /healthz answers at the
+// root even under --base-path, for load balancers.
+func (a *App) uiHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/{$}", a.uiPage(a.handleUI))
+	mux.HandleFunc("/entries-view", a.uiPage(a.handleEntriesViewUI))
+	mux.HandleFunc("/setup", a.uiPage(a.handleSetupUI))
+	mux.HandleFunc("/claim", a.uiPage(a.handleClaimUI))
+	mux.HandleFunc("/admin/backups", a.uiPage(a.handleBackupsUI))
+	mux.HandleFunc("/embed.js", a.uiPage(a.handleEmbedJS))
+	mux.HandleFunc("/assets/", a.uiPage(a.handleAsset))
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		a.uiError(w, r, http.StatusNotFound, "")
+	})
+	var pages http.Handler = mux
+	if a.basePath != "" {
+		pages = http.StripPrefix(a.basePath, mux)
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.URL.Path == "/healthz":
+			a.handleUIHealthz(w, r)
+		case a.basePath != "" && r.URL.Path == a.basePath:
+			http.Redirect(w, r, a.basePath+"/", http.StatusMovedPermanently)
+		case a.basePath != "" && !strings.HasPrefix(r.URL.Path, a.basePath+"/"):
+			a.uiError(w, r, http.StatusNotFound, "")
+		default:
+			pages.ServeHTTP(w, r)
+		}
+	})
+}
+
+// uiPage limits h to GET and HEAD.
+func (a *App) uiPage(h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			w.Header().Set("Allow", "GET, HEAD")
+			a.uiError(w, r, http.StatusMethodNotAllowed, "")
+			return
+		}
+		h(w, r)
+	}
+}
+
+// prefersJSON reports whether the Accept header ranks application/json
+// above text/html (an absent type counts as q=0).
+func prefersJSON(r *http.Request) bool {
+	jsonQ, htmlQ := -1.0, -1.0
+	for _, part := range strings.Split(r.Header.Get("Accept"), ",") {
+		mt, params, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err != nil {
+			continue
+		}
+		q := 1.0
+		if v, ok := params["q"]; ok {
+			if q, err = strconv.ParseFloat(v, 64); err != nil {
+				continue
+			}
+		}
+		switch mt {
+		case "application/json":
+			jsonQ = max(jsonQ, q)
+		case "text/html":
+			htmlQ = max(htmlQ, q)
+		}
+	}
+	return jsonQ > 0 && jsonQ > htmlQ
+}
+
+// uiError answers a UI request that has no page: the error page, or JSON
+// when the client prefers it. message defaults to the status text.
+func (a *App) uiError(w http.ResponseWriter, r *http.Request, status int, message string) {
+	if message == "" {
+		message = strings.ToLower(http.StatusText(status))
+	}
+	if prefersJSON(r) {
+		jsonErr(w, status, message)
+		return
+	}
+	renderUIStatus(w, status, "templates/error.html", uiPageData{Title: fmt.Sprintf("%d %s", status, http.StatusText(status)), Status: status, Message: message, Maintenance: a.maintenanceBanner(), BasePath: a.basePath, APIBase: a.apiBase()})
+}
+
+// handleUIHealthz serves /healthz on the UI port: 200 while the database
+// answers, 503 otherwise.
+func (a *App) handleUIHealthz(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		jsonErr(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), 2*time.Second)
+	defer cancel()
+	var one int
+	if err := a.db.QueryRowContext(ctx, `SELECT 1`).Scan(&one); err != nil {
+		jsonOut(w, http.StatusServiceUnavailable, map[string]string{"status": "unavailable"})
+		return
+	}
+	jsonOut(w, http.StatusOK, map[string]string{"status": "ok"})
+}
+
 func (a *App) handleUI(w http.ResponseWriter, _ *http.Request) {
-	renderUI(w, "templates/index.html", uiPageData{Title: "PUD Dev Log"})
+	renderUI(w, "templates/index.html", uiPageData{Title: "PUD Dev Log", Maintenance: a.maintenanceBanner(), Banner: a.settings().Banner, BasePath: a.basePath, APIBase: a.apiBase()})
 }
 
 func (a *App) handleEntriesViewUI(w http.ResponseWriter, _ *http.Request) {
-	renderUI(w, "templates/entries-view.html", uiPageData{Title: "PUD Entries View"})
+	renderUI(w, "templates/entries-view.html", uiPageData{Title: "PUD Entries View", Maintenance: a.maintenanceBanner(), Banner: a.settings().Banner, BasePath: a.basePath, APIBase: a.apiBase()})
 }
 
-func (a *App) handleOatCSS(w http.ResponseWriter, _ *http.Request) {
-	w.Header().Set("Content-Type", "text/css; charset=utf-8")
-	w.WriteHeader(http.StatusOK)
-	_, _ = w.Write(oatCSS)
+// handleAsset serves /assets/ by plain or hashed name with ETag-based
+// conditional GET (http.ServeContent answers If-None-Match with 304).
+func (a *App) handleAsset(w http.ResponseWriter, r *http.Request) {
+	for _, asset := range uiAssets {
+		switch r.URL.Path {
+		case asset.hashedPath:
+			w.Header().Set("Cache-Control", "public, max-age=31536000, immutable")
+		case asset.plainPath:
+			w.Header().Set("Cache-Control", "no-cache")
+		default:
+			continue
+		}
+		w.Header().Set("Content-Type", asset.contentType)
+		w.Header().Set("ETag", asset.etag)
+		http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(asset.data))
+		return
+	}
+	a.uiError(w, r, http.StatusNotFound, "")
 }
 
-func (a *App) handleOatJS(w http.ResponseWriter, _ *http.Request) {
-	w.Header().Set("Content-Type", "application/javascript; charset=utf-8")
-	w.WriteHeader(http.StatusOK)
-	_, _ = w.Write(oatJS)
+// renderUI renders a page. HTML is never cached without revalidation, so a
+// deploy's new asset hashes are picked up on the next load.
+func renderUI(w http.ResponseWriter, pagePath string, data uiPageData) {
+	renderUIStatus(w, http.StatusOK, pagePath, data)
 }
 
-func renderUI(w http.ResponseWriter, pagePath string, data uiPageData) {
+func renderUIStatus(w http.ResponseWriter, status int, pagePath string, data uiPageData) {
+	data.CSSPath = data.BasePath + oatCSSAsset.hashedPath
+	data.JSPath = data.BasePath + oatJSAsset.hashedPath
 	t, err := template.ParseFS(uiTemplatesFS, "templates/base.html", pagePath)
 	if err != nil {
 		http.Error(w, "failed to load template", http.StatusInternalServerError)
 		return
 	}
 	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(status)
 	if err := t.ExecuteTemplate(w, "base", data); err != nil {
 		http.Error(w, "failed to render template", http.StatusInternalServerError)
 	}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestUIAssetCaching(t *testing.T) {
+	app := newTestApp(t)
+	rr := httptest.NewRecorder()
+	app.handleUI(rr, httptest.NewRequest(http.MethodGet, "/", nil))
+	if rr.Header().Get("Cache-Control") != "no-cache" {
+		t.Fatalf("expected no-cache HTML, got %q", rr.Header().Get("Cache-Control"))
+	}
+	if !strings.Contains(rr.Body.String(), `href="`+oatCSSAsset.hashedPath+`"`) || !strings.Contains(rr.Body.String(), `src="`+oatJSAsset.hashedPath+`"`) {
+		t.Fatalf("expected hashed asset links in HTML")
+	}
+
+	rr = httptest.NewRecorder()
+	app.handleAsset(rr, httptest.NewRequest(http.MethodGet, oatCSSAsset.hashedPath, nil))
+	if rr.Code != http.StatusOK || !strings.Contains(rr.Header().Get("Cache-Control"), "immutable") || rr.Header().Get("ETag") == "" {
+		t.Fatalf("hashed asset: code=%d headers=%v", rr.Code, rr.Header())
+	}
+	if rr.Body.Len() != len(oatCSS) || !strings.HasPrefix(rr.Header().Get("Content-Type"), "text/css") {
+		t.Fatalf("unexpected asset body/content type")
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/assets/oat.min.js", nil)
+	req.Header.Set("If-None-Match", oatJSAsset.etag)
+	rr = httptest.NewRecorder()
+	app.handleAsset(rr, req)
+	if rr.Code != http.StatusNotModified || rr.Header().Get("Cache-Control") != "no-cache" {
+		t.Fatalf("expected 304 revalidation on plain path, got %d headers=%v", rr.Code, rr.Header())
+	}
+
+	rr = httptest.NewRecorder()
+	app.handleAsset(rr, httptest.NewRequest(http.MethodGet, "/assets/oat.min.0000.css", nil))
+	if rr.Code != http.StatusNotFound {
+		t.Fatalf("expected 404 for unknown asset, got %d", rr.Code)
+	}
+}
+
+func TestUIServerErrorPages(t *testing.T) {
+	app := newTestApp(t)
+	get := func(h http.Handler, method, path, accept string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, nil)
+		if accept != "" {
+			req.Header.Set("Accept", accept)
+		}
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, req)
+		return rr
+	}
+	h := app.uiHandler()
+	if rr := get(h, http.MethodGet, "/", ""); rr.Code != http.StatusOK || !strings.Contains(rr.Body.String(), "PUD Dev Log") {
+		t.Fatalf("index: %d", rr.Code)
+	}
+	rr := get(h, http.MethodGet, "/no-such-page", "text/html,application/xhtml+xml,*/*;q=0.8")
+	if rr.Code != http.StatusNotFound || !strings.HasPrefix(rr.Header().Get("Content-Type"), "text/html") || !strings.Contains(rr.Body.String(), "<title>404 Not Found</title>") {
+		t.Fatalf("404 page: %d %s", rr.Code, rr.Header().Get("Content-Type"))
+	}
+	rr = get(h, http.MethodGet, "/no-such-page", "application/json")
+	if rr.Code != http.StatusNotFound || strings.TrimSpace(rr.Body.String()) != `{"error":"not found"}` {
+		t.Fatalf("404 json: %d %s", rr.Code, rr.Body.String())
+	}
+	rr = get(h, http.MethodPost, "/entries-view", "")
+	if rr.Code != http.StatusMethodNotAllowed || rr.Header().Get("Allow") != "GET, HEAD" || !strings.Contains(rr.Body.String(), "405") {
+		t.Fatalf("405 page: %d %v", rr.Code, rr.Header())
+	}
+	if rr := get(h, http.MethodGet, "/assets/oat.min.0000.css", ""); rr.Code != http.StatusNotFound {
+		t.Fatalf("unknown asset: %d", rr.Code)
+	}
+	if rr := get(h, http.MethodGet, "/healthz", ""); rr.Code != http.StatusOK || !strings.Contains(rr.Body.String(), `"ok"`) {
+		t.Fatalf("healthz: %d %s", rr.Code, rr.Body.String())
+	}
+
+	app.basePath = "/devlog"
+	h = app.uiHandler()
+	for path, want := range map[string]int{"/devlog/": http.StatusOK, "/devlog/entries-view": http.StatusOK, "/healthz": http.StatusOK, "/entries-view": http.StatusNotFound, "/devlogx/": http.StatusNotFound, "/devlog": http.StatusMovedPermanently} {
+		if rr := get(h, http.MethodGet, path, ""); rr.Code != want {
+			t.Fatalf("base path %s: %d, want %d", path, rr.Code, want)
+		}
+	}
+}
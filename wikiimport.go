@@ -0,0 +1,638 @@
+package main
+
+import (
+	"archive/zip"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// Wiki import sources.
+const (
+	wikiNotion     = "notion"
+	wikiConfluence = "confluence"
+)
+
+// wikiPage is one exported page, reduced to a day, an author and text blocks.
+type wikiPage struct {
+	ID     string // stable id within the export: Notion file path, Confluence page id
+	Title  string
+	Day    string
+	At     time.Time // creation time when the export has one
+	Author string    // Confluence username; Notion exports carry no usable author
+	Blocks []string
+}
+
+// wikiMapping is one line of the per-page mapping report.
+type wikiMapping struct {
+	Page    string `json:"page"`
+	Title   string `json:"title"`
+	Day     string `json:"day,omitempty"`
+	User    string `json:"user,omitempty"`
+	Entries int    `json:"entries"`
+	Status  string `json:"status"` // imported, would_import, already_imported, skipped
+	Reason  string `json:"reason,omitempty"`
+}
+
+var (
+	wikiDayRe      = regexp.MustCompile(`\b(\d{4}-\d{2}-\d{2})\b`)
+	notionIDRe     = regexp.MustCompile(`\s+[0-9a-f]{32}$`)
+	notionPropRe   = regexp.MustCompile(`^([A-Za-z][A-Za-z0-9 _-]{0,39}):\s*(.*)$`)
+	markdownListRe = regexp.MustCompile(`^([-*+]|\d+[.)])\s`)
+)
+
+var notionDateLayouts = []string{
+	"2006-01-02",
+	"2006-01-02T15:04",
+	"2006/01/02",
+	"January 2, 2006",
+	"January 2, 2006 3:04 PM",
+}
+
+// parseNotionDate reads a Notion date property; ranges ("A → B") use the start.
+func parseNotionDate(v string) (time.Time, bool) {
+	v, _, _ = strings.Cut(v, "→")
+	v = strings.TrimSpace(v)
+	for _, layout := range notionDateLayouts {
+		if t, err := time.Parse(layout, v); err == nil {
+			return t, true
+		}
+	}
+	return time.Time{}, false
+}
+
+// parseNotionPage splits a Notion Markdown export page into title, leading
+// properties and top-level blocks.
+func parseNotionPage(id, name, text string) wikiPage {
+	p := wikiPage{ID: id, Title: notionIDRe.ReplaceAllString(strings.TrimSuffix(name, ".md"), "")}
+	lines := strings.Split(strings.ReplaceAll(text, "\r\n", "\n"), "\n")
+	i := 0
+	for i < len(lines) && strings.TrimSpace(lines[i]) == "" {
+		i++
+	}
+	if i < len(lines) && strings.HasPrefix(lines[i], "# ") {
+		p.Title = strings.TrimSpace(strings.TrimPrefix(lines[i], "# "))
+		i++
+	}
+	for i < len(lines) && strings.TrimSpace(lines[i]) == "" {
+		i++
+	}
+	// The first paragraph holds the database properties when every line is "Key: value".
+	props := map[string]string{}
+	j := i
+	for j < len(lines) && strings.TrimSpace(lines[j]) != "" {
+		m := notionPropRe.FindStringSubmatch(lines[j])
+		if m == nil {
+			props = nil
+			break
+		}
+		props[strings.ToLower(m[1])] = m[2]
+		j++
+	}
+	if len(props) > 0 {
+		i = j
+	}
+
+	var dated bool
+	if v, ok := props["date"]; ok {
+		p.At, dated = parseNotionDate(v)
+	}
+	if !dated {
+		if m := wikiDayRe.FindStringSubmatch(p.Title); m != nil {
+			p.At, dated = parseNotionDate(m[1])
+		}
+	}
+	if !dated {
+		for _, key := range []string{"created", "created time"} {
+			if v, ok := props[key]; ok {
+				if p.At, dated = parseNotionDate(v); dated {
+					break
+				}
+			}
+		}
+	}
+	if dated {
+		p.Day = p.At.Format("2006-01-02")
+	}
+	p.Blocks = markdownBlocks(lines[i:])
+	return p
+}
+
+// markdownBlocks groups lines into top-level blocks: paragraphs, headings and
+// list items, with indented lines staying in their parent item.
+func markdownBlocks(lines []string) []string {
+	var blocks []string
+	var cur []string
+	flush := func() {
+		if b := strings.TrimSpace(strings.Join(cur, "\n")); b != "" {
+			blocks = append(blocks, b)
+		}
+		cur = nil
+	}
+	for _, line := range lines {
+		line = strings.TrimRight(line, " \t")
+		switch {
+		case line == "":
+			flush()
+		case strings.HasPrefix(line, "#"), markdownListRe.MatchString(line):
+			flush()
+			cur = append(cur, line)
+			if strings.HasPrefix(line, "#") {
+				flush()
+			}
+		default:
+			cur = append(cur, line)
+		}
+	}
+	flush()
+	return blocks
+}
+
+func isMarkdownHeading(block string) bool {
+	return strings.HasPrefix(block, "#") && !strings.Contains(block, "\n")
+}
+
+// readNotionExport reads every Markdown page of a Notion export (the zip file
+// or its unpacked directory).
+func readNotionExport(fsys fs.FS) ([]wikiPage, error) {
+	var pages []wikiPage
+	err := fs.WalkDir(fsys, ".", func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() || !strings.EqualFold(path.Ext(p), ".md") {
+			return nil
+		}
+		data, err := fs.ReadFile(fsys, p)
+		if err != nil {
+			return err
+		}
+		pages = append(pages, parseNotionPage(p, path.Base(p), string(data)))
+		return nil
+	})
+	return pages, err
+}
+
+type confluenceRef struct {
+	Name  string `xml:"name,attr"`
+	Value string `xml:",chardata"`
+}
+
+type confluenceProperty struct {
+	Name  string         `xml:"name,attr"`
+	Value string         `xml:",chardata"`
+	Ref   *confluenceRef `xml:"id"`
+}
+
+type confluenceObject struct {
+	Class string               `xml:"class,attr"`
+	ID    confluenceRef        `xml:"id"`
+	Props []confluenceProperty `xml:"property"`
+}
+
+func (o confluenceObject) prop(name string) string {
+	for _, p := range o.Props {
+		if p.Name == name {
+			if p.Ref != nil {
+				return strings.TrimSpace(p.Ref.Value)
+			}
+			return p.Value
+		}
+	}
+	return ""
+}
+
+func (o confluenceObject) has(name string) bool {
+	for _, p := range o.Props {
+		if p.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// readConfluenceExport reads current pages from a Confluence space export's
+// entities.xml. Historical versions, drafts and blog posts are ignored.
+func readConfluenceExport(r io.Reader) ([]wikiPage, error) {
+	d := xml.NewDecoder(r)
+	pages := map[string]confluenceObject{}
+	var order []string
+	bodies := map[string]string{}
+	users := map[string]string{}
+	for {
+		tok, err := d.Token()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("entities.xml: %w", err)
+		}
+		se, ok := tok.(xml.StartElement)
+		if !ok || se.Name.Local != "object" {
+			continue
+		}
+		var o confluenceObject
+		if err := d.DecodeElement(&o, &se); err != nil {
+			return nil, fmt.Errorf("entities.xml: %w", err)
+		}
+		id := strings.TrimSpace(o.ID.Value)
+		switch o.Class {
+		case "Page":
+			if o.has("originalVersion") || (o.has("contentStatus") && o.prop("contentStatus") != "current") {
+				continue
+			}
+			pages[id] = o
+			order = append(order, id)
+		case "BodyContent":
+			bodies[o.prop("content")] = o.prop("body")
+		case "ConfluenceUserImpl":
+			users[id] = o.prop("name")
+		}
+	}
+
+	out := make([]wikiPage, 0, len(order))
+	for _, id := range order {
+		o := pages[id]
+		p := wikiPage{ID: id, Title: strings.TrimSpace(o.prop("title")), Author: users[o.prop("creator")]}
+		if p.Author == "" {
+			p.Author = o.prop("creatorName")
+		}
+		if at, err := time.Parse("2006-01-02 15:04:05.000", strings.TrimSpace(o.prop("creationDate"))); err == nil {
+			p.At = at.UTC()
+		}
+		if m := wikiDayRe.FindStringSubmatch(p.Title); m != nil {
+			p.Day = m[1]
+		} else if !p.At.IsZero() {
+			p.Day = p.At.Format("2006-01-02")
+		}
+		p.Blocks = confluenceBlocks(bodies[id])
+		out = append(out, p)
+	}
+	return out, nil
+}
+
+// confluenceBlocks turns Confluence storage-format XHTML into Markdown-ish
+// blocks: one per paragraph, heading, table row or top-level list item.
+func confluenceBlocks(body string) []string {
+	d := xml.NewDecoder(strings.NewReader("<body>" + body + "</body>"))
+	d.Strict = false
+	d.AutoClose = xml.HTMLAutoClose
+	d.Entity = xml.HTMLEntity
+	var blocks []string
+	var line strings.Builder
+	depth, skip := 0, 0
+	prefix := ""
+	flush := func() {
+		text := strings.Join(strings.Fields(line.String()), " ")
+		line.Reset()
+		if text == "" {
+			return
+		}
+		text = prefix + text
+		if depth > 1 && len(blocks) > 0 {
+			blocks[len(blocks)-1] += "\n" + text
+			return
+		}
+		blocks = append(blocks, text)
+	}
+	for {
+		tok, err := d.Token()
+		if err != nil {
+			break
+		}
+		switch t := tok.(type) {
+		case xml.StartElement:
+			name := t.Name.Local
+			switch {
+			case t.Name.Space == "ac" && name == "parameter":
+				skip++
+			case name == "ul" || name == "ol":
+				flush()
+				depth++
+			case name == "li":
+				flush()
+				prefix = strings.Repeat("  ", max(depth-1, 0)) + "- "
+			case len(name) == 2 && name[0] == 'h' && name[1] >= '1' && name[1] <= '6':
+				flush()
+				prefix = strings.Repeat("#", int(name[1]-'0')) + " "
+			case name == "p" || name == "tr" || name == "br":
+				flush()
+			case name == "td" || name == "th":
+				line.WriteString(" ")
+			}
+		case xml.EndElement:
+			name := t.Name.Local
+			switch {
+			case t.Name.Space == "ac" && name == "parameter":
+				skip--
+			case name == "ul" || name == "ol":
+				flush()
+				depth = max(depth-1, 0)
+				prefix = ""
+				if depth > 0 {
+					prefix = strings.Repeat("  ", depth-1) + "- "
+				}
+			case name == "li" || name == "p" || name == "tr" || (len(name) == 2 && name[0] == 'h' && name[1] >= '1' && name[1] <= '6'):
+				flush()
+				if depth == 0 {
+					prefix = ""
+				}
+			}
+		case xml.CharData:
+			if skip == 0 {
+				line.Write(t)
+			}
+		}
+	}
+	flush()
+	return blocks
+}
+
+// wikiImportOptions controls how pages become entries.
+type wikiImportOptions struct {
+	FallbackUser string // author when the page has none or it is not linked
+	PerBlock     bool   // one entry per top-level block instead of per page
+	DryRun       bool
+}
+
+// importWikiPages maps pages to dated entries and, unless DryRun, creates
+// them. Pages already imported, undated, on compacted days or without a
+// resolvable author are skipped and reported.
+func (a *App) importWikiPages(ctx context.Context, source string, pages []wikiPage, opts wikiImportOptions) ([]wikiMapping, error) {
+	var fallback AuthedUser
+	if opts.FallbackUser != "" {
+		err := a.db.QueryRow(`SELECT id, username, role FROM users WHERE username = ? AND kind = ?`, opts.FallbackUser, kindHuman).Scan(&fallback.ID, &fallback.Username, &fallback.Role)
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, fmt.Errorf("unknown user: %s", opts.FallbackUser)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	maxSize := a.settings().MaxEntrySize
+	sort.SliceStable(pages, func(i, j int) bool { return pages[i].Day < pages[j].Day })
+	report := make([]wikiMapping, 0, len(pages))
+	created := 0
+	for _, p := range pages {
+		m := wikiMapping{Page: p.ID, Title: p.Title, Day: p.Day, Status: "skipped"}
+		report = append(report, m)
+		row := &report[len(report)-1]
+		if p.Day == "" {
+			row.Reason = "no date in properties, title or creation time"
+			continue
+		}
+
+		author := fallback
+		if p.Author != "" {
+			u, err := a.resolveIdentity(source, p.Author)
+			switch {
+			case err == nil:
+				author = u
+			case !errors.Is(err, errUnknownIdentity):
+				return report, err
+			}
+		}
+		if author.ID == 0 {
+			row.Reason = fmt.Sprintf("author %q is not linked and no --user given", p.Author)
+			continue
+		}
+		row.User = author.Username
+
+		type item struct{ key, content string }
+		var items []item
+		header := fmt.Sprintf("[%s] %s", source, p.Title)
+		if opts.PerBlock {
+			n := 0
+			for _, b := range p.Blocks {
+				if isMarkdownHeading(b) {
+					continue
+				}
+				n++
+				items = append(items, item{fmt.Sprintf("%s#%d", p.ID, n), header + "\n" + b})
+			}
+		} else if len(p.Blocks) > 0 {
+			items = append(items, item{p.ID, header + "\n" + strings.Join(p.Blocks, "\n\n")})
+		}
+		if len(items) == 0 {
+			row.Reason = "empty page"
+			continue
+		}
+		oversized := false
+		for _, it := range items {
+			if len(it.content) > maxSize {
+				oversized = true
+			}
+		}
+		if oversized {
+			row.Reason = "page is larger than an entry allows; use --split block"
+			continue
+		}
+
+		ran, err := a.compactionAlreadyRan(p.Day)
+		if err != nil {
+			return report, err
+		}
+		if ran {
+			row.Reason = "day already compacted"
+			continue
+		}
+
+		var pending []item
+		for _, it := range items {
+			var seen int
+			if err := a.db.QueryRow(`SELECT COUNT(*) FROM imported_pages WHERE source = ? AND external_id = ?`, source, it.key).Scan(&seen); err != nil {
+				return report, err
+			}
+			if seen == 0 {
+				pending = append(pending, it)
+			}
+		}
+		if len(pending) == 0 {
+			row.Status, row.Reason = "already_imported", ""
+			continue
+		}
+		row.Entries = len(pending)
+		if opts.DryRun {
+			row.Status = "would_import"
+			continue
+		}
+
+		base := p.At
+		if base.IsZero() || base.Format("2006-01-02") != p.Day {
+			base, _ = time.Parse("2006-01-02T15:04:05Z", p.Day+"T12:00:00Z")
+		}
+		for i, it := range pending {
+			// Bulk imports share the global write limiter with API writers.
+			release, err := a.writeLimit.acquire(ctx)
+			if err != nil {
+				return report, err
+			}
+			err = a.insertWikiEntry(author.ID, source, it.key, it.content, base.Add(time.Duration(i)*time.Second).UTC().Format(time.RFC3339))
+			release()
+			if err != nil {
+				return report, err
+			}
+			created++
+		}
+		row.Status = "imported"
+	}
+	if !opts.DryRun {
+		_ = a.logActorAction(actorWikiImporter, "import_wiki", fmt.Sprintf("source=%s pages=%d entries=%d", source, len(pages), created))
+	}
+	return report, nil
+}
+
+func (a *App) insertWikiEntry(uid int64, source, key, content, createdAt string) error {
+	content, secrets := a.scanImportedSecrets(content)
+	id, err := a.insertEntry(uid, content, "", sourceImport, createdAt)
+	if err != nil {
+		return err
+	}
+	a.logImportedSecrets(actorWikiImporter, id, secrets)
+	_, err = a.db.Exec(`INSERT INTO imported_pages(source, external_id, entry_id, imported_at) VALUES(?, ?, ?, ?)`, source, key, id, nowUTC())
+	return err
+}
+
+func printWikiReport(w io.Writer, report []wikiMapping) {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "STATUS\tDAY\tUSER\tENTRIES\tPAGE\tREASON")
+	counts := map[string]int{}
+	for _, m := range report {
+		counts[m.Status]++
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%d\t%s\t%s\n", m.Status, dashIfEmpty(m.Day), dashIfEmpty(m.User), m.Entries, m.Title, m.Reason)
+	}
+	_ = tw.Flush()
+	fmt.Fprintf(w, "%d page(s): %d imported, %d would import, %d already imported, %d skipped\n",
+		len(report), counts["imported"], counts["would_import"], counts["already_imported"], counts["skipped"])
+}
+
+func dashIfEmpty(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
+
+// runImportWiki implements 'import notion' and 'import confluence'.
+func runImportWiki(source string, args []string) error {
+	fs := flag.NewFlagSet("import "+source, flag.ContinueOnError)
+	fs.Usage = func() {
+		if source == wikiNotion {
+			fmt.Fprintf(fs.Output(), "Usage: %s import notion --path <export.zip|dir> --user <username> [options]\n\n", binName())
+			fmt.Fprintln(fs.Output(), "Imports a Notion Markdown export. Each page's day comes from its Date property,")
+			fmt.Fprintln(fs.Output(), "a YYYY-MM-DD in its title, or its Created property; pages are written as --user.")
+		} else {
+			fmt.Fprintf(fs.Output(), "Usage: %s import confluence --path <export.zip|entities.xml> [options]\n\n", binName())
+			fmt.Fprintln(fs.Output(), "Imports a Confluence space XML export. Each page's day is a YYYY-MM-DD in its")
+			fmt.Fprintln(fs.Output(), "title or its creation date. Creators are mapped with 'admin link-identity")
+			fmt.Fprintln(fs.Output(), "--provider confluence'; --user is used for unlinked creators.")
+		}
+		fmt.Fprintln(fs.Output(), "Already-imported pages are never imported twice. --dry-run prints the mapping only.")
+		fmt.Fprintln(fs.Output())
+		fmt.Fprintln(fs.Output(), "Options:")
+		fs.PrintDefaults()
+	}
+	src := fs.String("path", "", "export zip file, directory or entities.xml")
+	user := fs.String("user", "", "author for pages without a linked author")
+	split := fs.String("split", "page", "one entry per 'page' or per top-level 'block'")
+	dryRun := fs.Bool("dry-run", false, "print the mapping report without creating entries")
+	reportPath := fs.String("report", "", "also write the mapping report as JSON to this file")
+	dbPath := fs.String("db", defaultDBPath, "sqlite db path")
+	logPath := fs.String("log", defaultLogPath, "log target path ('-' for stdout only)")
+	if err := fs.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return nil
+		}
+		return err
+	}
+	if strings.TrimSpace(*src) == "" {
+		return errors.New("--path is required")
+	}
+	if source == wikiNotion && strings.TrimSpace(*user) == "" {
+		return errors.New("--user is required for notion imports")
+	}
+	if *split != "page" && *split != "block" {
+		return errors.New("--split must be page or block")
+	}
+
+	pages, err := readWikiExport(source, *src)
+	if err != nil {
+		return err
+	}
+
+	app, closeApp, err := openAdminApp(*dbPath, *logPath)
+	if err != nil {
+		return err
+	}
+	defer closeApp()
+
+	report, err := app.importWikiPages(context.Background(), source, pages, wikiImportOptions{
+		FallbackUser: strings.TrimSpace(*user),
+		PerBlock:     *split == "block",
+		DryRun:       *dryRun,
+	})
+	printWikiReport(os.Stdout, report)
+	if *reportPath != "" {
+		data, merr := json.MarshalIndent(report, "", "  ")
+		if merr != nil {
+			return merr
+		}
+		if werr := os.WriteFile(*reportPath, append(data, '\n'), 0o644); werr != nil {
+			return werr
+		}
+	}
+	return err
+}
+
+// readWikiExport opens src as a zip archive, a directory or (Confluence) a
+// bare entities.xml.
+func readWikiExport(source, src string) ([]wikiPage, error) {
+	info, err := os.Stat(src)
+	if err != nil {
+		return nil, err
+	}
+	var fsys fs.FS
+	switch {
+	case info.IsDir():
+		fsys = os.DirFS(src)
+	case strings.EqualFold(path.Ext(src), ".zip"):
+		zr, err := zip.OpenReader(src)
+		if err != nil {
+			return nil, err
+		}
+		defer zr.Close()
+		fsys = zr
+	case source == wikiConfluence:
+		f, err := os.Open(src)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		return readConfluenceExport(f)
+	default:
+		return nil, errors.New("--path must be a zip file or directory")
+	}
+	if source == wikiNotion {
+		return readNotionExport(fsys)
+	}
+	f, err := fsys.Open("entities.xml")
+	if err != nil {
+		return nil, fmt.Errorf("confluence export: %w", err)
+	}
+	defer f.Close()
+	return readConfluenceExport(f)
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestImportNotionExport(t *testing.T) {
+	dir := t.TempDir()
+	pages := map[string]string{
+		"Daily 0123456789abcdef0123456789abcdef.md":              "# Standup notes\n\nDate: February 17, 2026\nTags: team\n\n## Done\n\n- fixed login timeout\n  - root cause: stale session\n- reviewed PR 42\n\nPaired with bob.\n",
+		"Journal/2026-02-18 fedcba9876543210fedcba9876543210.md": "# 2026-02-18\n\nshipped the release\n",
+		"Ideas aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa.md":              "# Ideas\n\nno date anywhere\n",
+	}
+	for name, body := range pages {
+		p := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(p), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(p, []byte(body), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	parsed, err := readNotionExport(os.DirFS(dir))
+	if err != nil || len(parsed) != 3 {
+		t.Fatalf("readNotionExport: %d pages, %v", len(parsed), err)
+	}
+
+	app := newTestApp(t)
+	createUser(t, app, "alice", "PUDWIKIAAA1")
+	ctx := context.Background()
+	opts := wikiImportOptions{FallbackUser: "alice", PerBlock: true, DryRun: true}
+	report, err := app.importWikiPages(ctx, wikiNotion, parsed, opts)
+	if err != nil {
+		t.Fatalf("dry run: %v", err)
+	}
+	byTitle := map[string]wikiMapping{}
+	for _, m := range report {
+		byTitle[m.Title] = m
+	}
+	if m := byTitle["Standup notes"]; m.Status != "would_import" || m.Day != "2026-02-17" || m.Entries != 3 || m.User != "alice" {
+		t.Fatalf("unexpected standup mapping: %+v", m)
+	}
+	if m := byTitle["2026-02-18"]; m.Status != "would_import" || m.Day != "2026-02-18" {
+		t.Fatalf("unexpected journal mapping: %+v", m)
+	}
+	if m := byTitle["Ideas"]; m.Status != "skipped" || m.Reason == "" {
+		t.Fatalf("undated page should be skipped: %+v", m)
+	}
+	var n int
+	if err := app.db.QueryRow(`SELECT COUNT(*) FROM entries`).Scan(&n); err != nil || n != 0 {
+		t.Fatalf("dry run created %d entries (%v)", n, err)
+	}
+
+	opts.DryRun = false
+	if _, err := app.importWikiPages(ctx, wikiNotion, parsed, opts); err != nil {
+		t.Fatalf("import: %v", err)
+	}
+	var content, createdAt string
+	if err := app.db.QueryRow(`SELECT content, created_at FROM entries WHERE content LIKE '%login timeout%'`).Scan(&content, &createdAt); err != nil {
+		t.Fatalf("query entry: %v", err)
+	}
+	if content != "[notion] Standup notes\n- fixed login timeout\n  - root cause: stale session" || !strings.HasPrefix(createdAt, "2026-02-17T") {
+		t.Fatalf("unexpected entry %q at %s", content, createdAt)
+	}
+	if err := app.db.QueryRow(`SELECT COUNT(*) FROM entries`).Scan(&n); err != nil || n != 4 {
+		t.Fatalf("expected 4 entries, got %d (%v)", n, err)
+	}
+
+	report, err = app.importWikiPages(ctx, wikiNotion, parsed, opts)
+	if err != nil {
+		t.Fatalf("re-import: %v", err)
+	}
+	for _, m := range report {
+		if m.Status == "imported" {
+			t.Fatalf("re-import created entries: %+v", m)
+		}
+	}
+}
+
+func TestImportConfluenceExport(t *testing.T) {
+	const entities = `<?xml version="1.0" encoding="UTF-8"?>
+<hibernate-generic datetime="2026-02-20 10:00:00">
+<object class="ConfluenceUserImpl" package="com.atlassian.confluence.user">
+<id name="key"><![CDATA[8a7f808a]]></id>
+<property name="name"><![CDATA[Alice.Smith]]></property>
+</object>
+<object class="Page" package="com.atlassian.confluence.pages">
+<id name="id">1001</id>
+<property name="title"><![CDATA[2026-02-17 Daily]]></property>
+<property name="creator" class="ConfluenceUserImpl" package="com.atlassian.confluence.user"><id name="key"><![CDATA[8a7f808a]]></id></property>
+<property name="creationDate">2026-02-17 09:12:00.000</property>
+<property name="contentStatus"><![CDATA[current]]></property>
+</object>
+<object class="Page" package="com.atlassian.confluence.pages">
+<id name="id">1000</id>
+<property name="title"><![CDATA[2026-02-17 Daily]]></property>
+<property name="originalVersion" class="Page" package="com.atlassian.confluence.pages"><id name="id">1001</id></property>
+<property name="contentStatus"><![CDATA[current]]></property>
+</object>
+<object class="BodyContent" package="com.atlassian.confluence.core">
+<id name="id">5001</id>
+<property name="body"><![CDATA[<h2>Done</h2><ul><li>fixed&nbsp;login <strong>timeout</strong><ul><li>stale session</li></ul></li><li>reviewed PR</li></ul><ac:structured-macro ac:name="info"><ac:parameter ac:name="title">Note</ac:parameter><ac:rich-text-body><p>on call tomorrow</p></ac:rich-text-body></ac:structured-macro>]]></property>
+<property name="content" class="Page" package="com.atlassian.confluence.pages"><id name="id">1001</id></property>
+</object>
+</hibernate-generic>`
+	pages, err := readConfluenceExport(strings.NewReader(entities))
+	if err != nil {
+		t.Fatalf("readConfluenceExport: %v", err)
+	}
+	if len(pages) != 1 {
+		t.Fatalf("expected only the current page, got %d", len(pages))
+	}
+	p := pages[0]
+	want := []string{"## Done", "- fixed login timeout\n  - stale session", "- reviewed PR", "on call tomorrow"}
+	if p.Day != "2026-02-17" || p.Author != "Alice.Smith" || strings.Join(p.Blocks, "|") != strings.Join(want, "|") {
+		t.Fatalf("unexpected page: %+v", p)
+	}
+
+	app := newTestApp(t)
+	createUser(t, app, "alice", "PUDWIKIAAA2")
+	ctx := context.Background()
+	report, err := app.importWikiPages(ctx, wikiConfluence, pages, wikiImportOptions{})
+	if err != nil || report[0].Status != "skipped" {
+		t.Fatalf("unlinked creator should be skipped: %+v %v", report, err)
+	}
+	if _, err := app.linkIdentity(providerConfluence, "alice.smith", "alice"); err != nil {
+		t.Fatalf("link: %v", err)
+	}
+	report, err = app.importWikiPages(ctx, wikiConfluence, pages, wikiImportOptions{})
+	if err != nil || report[0].Status != "imported" || report[0].User != "alice" || report[0].Entries != 1 {
+		t.Fatalf("unexpected report: %+v %v", report, err)
+	}
+	var createdAt string
+	if err := app.db.QueryRow(`SELECT created_at FROM entries WHERE user_id = 1`).Scan(&createdAt); err != nil || createdAt != "2026-02-17T09:12:00Z" {
+		t.Fatalf("created_at = %q (%v)", createdAt, err)
+	}
+}
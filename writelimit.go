@@ -0,0 +1,156 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+var (
+	errWriteQueueFull = errors.New("write queue full")
+	errWriteTimeout   = errors.New("timed out waiting for a write slot")
+)
+
+// writeLimiter bounds concurrent writers in front of the single SQLite
+// connection. Up to cap(slots) writers run at once, up to maxWaiting more wait
+// at most maxWait for a slot, and everything beyond that is rejected so bursts
+// turn into backpressure instead of goroutines piling up on the pool.
+type writeLimiter struct {
+	name       string
+	slots      chan struct{}
+	maxWaiting int64
+	maxWait    time.Duration
+
+	waiting       atomic.Int64
+	admitted      atomic.Int64
+	rejectedFull  atomic.Int64
+	rejectedWait  atomic.Int64
+	waitNanoTotal atomic.Int64
+}
+
+func newWriteLimiter(name string, concurrency, maxWaiting int, maxWait time.Duration) *writeLimiter {
+	if concurrency < 1 {
+		concurrency = 1
+	}
+	return &writeLimiter{
+		name:       name,
+		slots:      make(chan struct{}, concurrency),
+		maxWaiting: int64(maxWaiting),
+		maxWait:    maxWait,
+	}
+}
+
+// acquire waits for a slot and returns its release func. A nil limiter admits
+// everything.
+func (l *writeLimiter) acquire(ctx context.Context) (func(), error) {
+	if l == nil {
+		return func() {}, nil
+	}
+	release := func() { <-l.slots }
+	select {
+	case l.slots <- struct{}{}:
+		l.admitted.Add(1)
+		return release, nil
+	default:
+	}
+
+	if l.waiting.Add(1) > l.maxWaiting {
+		l.waiting.Add(-1)
+		l.rejectedFull.Add(1)
+		return nil, errWriteQueueFull
+	}
+	defer l.waiting.Add(-1)
+	start := time.Now()
+	defer func() { l.waitNanoTotal.Add(int64(time.Since(start))) }()
+
+	timer := time.NewTimer(l.maxWait)
+	defer timer.Stop()
+	select {
+	case l.slots <- struct{}{}:
+		l.admitted.Add(1)
+		return release, nil
+	case <-timer.C:
+		l.rejectedWait.Add(1)
+		return nil, errWriteTimeout
+	case <-ctx.Done():
+		l.rejectedWait.Add(1)
+		return nil, ctx.Err()
+	}
+}
+
+// writeLimiters returns the global limiter followed by the per-route ones in
+// route order, skipping unset limiters.
+func (a *App) writeLimiters() []*writeLimiter {
+	var out []*writeLimiter
+	if a.writeLimit != nil {
+		out = append(out, a.writeLimit)
+	}
+	routes := make([]string, 0, len(a.routeWriteLimits))
+	for route := range a.routeWriteLimits {
+		routes = append(routes, route)
+	}
+	sort.Strings(routes)
+	for _, route := range routes {
+		out = append(out, a.routeWriteLimits[route])
+	}
+	return out
+}
+
+// guardWrites wraps a write route: while maintenance mode is on writes get
+// 503 with the maintenance message, otherwise they are admitted through the
+// route's limiter and then the global one. Only the listed methods count as
+// writes (POST, PUT and DELETE when none are given); other requests pass
+// straight through.
+func (a *App) guardWrites(route string, next http.HandlerFunc, methods ...string) http.HandlerFunc {
+	if len(methods) == 0 {
+		methods = []string{http.MethodPost, http.MethodPut, http.MethodDelete}
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !containsString(methods, r.Method) {
+			next(w, r)
+			return
+		}
+		m, err := a.maintenance()
+		if err != nil {
+			jsonErr(w, http.StatusInternalServerError, "failed to read maintenance state")
+			return
+		}
+		if m.Enabled {
+			jsonOut(w, http.StatusServiceUnavailable, map[string]any{"error": m.Message, "maintenance": true})
+			return
+		}
+		for _, l := range []*writeLimiter{a.routeWriteLimits[route], a.writeLimit} {
+			release, err := l.acquire(r.Context())
+			if err != nil {
+				a.logger.Printf("event=write_rejected route=%s limiter=%s reason=%q", route, l.name, err)
+				w.Header().Set("Retry-After", "1")
+				jsonErr(w, http.StatusServiceUnavailable, "server busy, retry shortly")
+				return
+			}
+			defer release()
+		}
+		next(w, r)
+	}
+}
+
+// parseRouteWriteLimits parses "route=n,route=n" into per-route limiters
+// sharing the global queue settings.
+func parseRouteWriteLimits(spec string, maxWaiting int, maxWait time.Duration) (map[string]*writeLimiter, error) {
+	out := map[string]*writeLimiter{}
+	for _, item := range splitList(spec) {
+		route, raw, ok := strings.Cut(item, "=")
+		n, err := strconv.Atoi(strings.TrimSpace(raw))
+		route = strings.TrimSpace(route)
+		if !ok || err != nil || n < 1 || !strings.HasPrefix(route, "/") {
+			return nil, fmt.Errorf("invalid route write limit %q (want /path=N)", item)
+		}
+		out[route] = newWriteLimiter(route, n, maxWaiting, maxWait)
+	}
+	return out, nil
+}